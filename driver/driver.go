@@ -16,6 +16,7 @@
 package driver
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"regexp"
@@ -33,6 +34,13 @@ func PProf(o *Options) error {
 	return internaldriver.PProf(o.internalOptions())
 }
 
+// PProfContext runs pprof as PProf does, until ctx is canceled: it stops
+// fetching and symbolizing the profiles and generating the reports once
+// ctx is done, failing with its error.
+func PProfContext(ctx context.Context, o *Options) error {
+	return internaldriver.PProfContext(ctx, o.internalOptions())
+}
+
 func (o *Options) internalOptions() *plugin.Options {
 	var obj plugin.ObjTool
 	if o.Obj != nil {
@@ -48,15 +56,23 @@ func (o *Options) internalOptions() *plugin.Options {
 			return o.HTTPServer(((*HTTPServerArgs)(args)))
 		}
 	}
+	var webViews []plugin.WebView
+	for _, v := range o.WebViews {
+		webViews = append(webViews, plugin.WebView(v))
+	}
 	return &plugin.Options{
-		Writer:        o.Writer,
-		Flagset:       o.Flagset,
-		Fetch:         o.Fetch,
-		Sym:           sym,
-		Obj:           obj,
-		UI:            o.UI,
-		HTTPServer:    httpServer,
-		HTTPTransport: o.HTTPTransport,
+		Writer:           o.Writer,
+		Flagset:          o.Flagset,
+		Fetch:            o.Fetch,
+		Sym:              sym,
+		Obj:              obj,
+		UI:               o.UI,
+		HTTPServer:       httpServer,
+		HTTPTransport:    o.HTTPTransport,
+		RequestDecorator: o.RequestDecorator,
+		HTTPMiddleware:   o.HTTPMiddleware,
+		Telemetry:        o.Telemetry,
+		WebViews:         webViews,
 	}
 }
 
@@ -74,6 +90,52 @@ type Options struct {
 	UI            UI
 	HTTPServer    func(*HTTPServerArgs) error
 	HTTPTransport http.RoundTripper
+
+	// RequestDecorator, if non-nil, is called on each outbound HTTP
+	// request of pprof, such as the fetches of profiles and the symbolz
+	// requests, before it is sent through HTTPTransport. It can modify
+	// the request, eg to add authentication headers with freshly refreshed
+	// tokens or to sign it, or fail it by returning an error.
+	RequestDecorator func(*http.Request) error
+
+	// HTTPMiddleware, if non-nil, wraps each handler of the web interface,
+	// including those served by a custom HTTPServer. It can check the
+	// authentication of the requests, eg the session of an SSO or OIDC
+	// proxy, and reject them before they reach pprof.
+	HTTPMiddleware func(http.Handler) http.Handler
+
+	// Telemetry, if non-nil, records the use of pprof, for embedders
+	// measuring the adoption of their profiling workflows. pprof never
+	// sends it anywhere itself.
+	Telemetry Telemetry
+
+	// WebViews are the views added to the web interface, eg to show the
+	// cost of the profile in dollars or the runbooks of its functions,
+	// served next to those of pprof and listed in its View menu.
+	WebViews []WebView
+}
+
+// WebView is a view added to the web interface: its Name is its path,
+// its Title and Help its entry in the View menu, and its Template the
+// html/template of its body, executed with the value returned by Data
+// from the profile of the view, with the options of the request applied.
+type WebView plugin.WebView
+
+// Telemetry records how pprof is used, anonymously: it is given the
+// names of the flags, reports and views used, never their values nor
+// anything of the profiles, and the durations of the operations of pprof.
+type Telemetry interface {
+	// Event records a use of the feature name of the given kind: "flag"
+	// for the flags set on the command line, eg "base", "report" for the
+	// reports generated, eg "top", and "view" for the pages of the web
+	// interface served, eg "/flamegraph".
+	Event(kind, name string)
+
+	// Duration records how long the operation took: "fetch" for the
+	// fetch of the profiles, "symbolize" for their symbolization, the
+	// name of a report, eg "report top", for its generation, and that of
+	// a view, eg "view /flamegraph", for serving it.
+	Duration(operation string, d time.Duration)
 }
 
 // Writer provides a mechanism to write data under a certain name,
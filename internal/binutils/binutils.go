@@ -371,11 +371,12 @@ func (b *binrep) openMachOCommon(name string, of *macho.File, start, limit, offs
 	}
 
 	base := start - textSegment.Addr
+	reloc := Relocation{Base: base, Mode: "macho"}
 
 	if b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound) {
-		return &fileNM{file: file{b: b, name: name, base: base}}, nil
+		return &fileNM{file: file{b: b, name: name, base: base, reloc: &reloc}}, nil
 	}
-	return &fileAddr2Line{file: file{b: b, name: name, base: base}}, nil
+	return &fileAddr2Line{file: file{b: b, name: name, base: base, reloc: &reloc}}, nil
 }
 
 func (b *binrep) openFatMachO(name string, start, limit, offset uint64) (plugin.ObjFile, error) {
@@ -447,23 +448,20 @@ func (b *binrep) openELF(name string, start, limit, offset uint64) (plugin.ObjFi
 		pageAligned = func(addr uint64) bool { return addr%4096 == 0 }
 	)
 	if strings.Contains(name, "vmlinux") || !pageAligned(start) || !pageAligned(limit) || !pageAligned(offset) {
-		// Reading all Symbols is expensive, and we only rarely need it so
+		// Looking up symbols is expensive, and we only rarely need it so
 		// we don't want to do it every time. But if _stext happens to be
 		// page-aligned but isn't the same as Vaddr, we would symbolize
 		// wrong. So if the name the addresses aren't page aligned, or if
 		// the name is "vmlinux" we read _stext. We can be wrong if: (1)
 		// someone passes a kernel path that doesn't contain "vmlinux" AND
 		// (2) _stext is page-aligned AND (3) _stext is not at Vaddr
-		symbols, err := ef.Symbols()
-		if err != nil && err != elf.ErrNoSymbols {
+		s, err := elfexec.FindSymbol(ef, "_stext")
+		if err != nil {
 			return nil, err
 		}
-		for _, s := range symbols {
-			if s.Name == "_stext" {
-				// The kernel may use _stext as the mapping start address.
-				stextOffset = &s.Value
-				break
-			}
+		if s != nil {
+			// The kernel may use _stext as the mapping start address.
+			stextOffset = &s.Value
 		}
 	}
 
@@ -472,7 +470,8 @@ func (b *binrep) openELF(name string, start, limit, offset uint64) (plugin.ObjFi
 	// value until we have a sample address for this mapping, so that we can
 	// correctly identify the associated program segment that is needed to compute
 	// the base.
-	if _, err := elfexec.GetBase(&ef.FileHeader, elfexec.FindTextProgHeader(ef), stextOffset, start, limit, offset); err != nil {
+	textHeader := elfexec.FindTextProgHeader(ef)
+	if _, err := elfexec.GetBaseAligned(&ef.FileHeader, textHeader, stextOffset, start, limit, offset, elfexec.MappingAlignment(textHeader, start)); err != nil {
 		return nil, fmt.Errorf("could not identify base for %s: %v", name, err)
 	}
 
@@ -513,10 +512,11 @@ func (b *binrep) openPE(name string, start, limit, offset uint64) (plugin.ObjFil
 	if start > 0 {
 		base = start - imageBase
 	}
+	reloc := Relocation{Base: base, Mode: "pe"}
 	if b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound) {
-		return &fileNM{file: file{b: b, name: name, base: base}}, nil
+		return &fileNM{file: file{b: b, name: name, base: base, reloc: &reloc}}, nil
 	}
-	return &fileAddr2Line{file: file{b: b, name: name, base: base}}, nil
+	return &fileAddr2Line{file: file{b: b, name: name, base: base, reloc: &reloc}}, nil
 }
 
 // elfMapping stores the parameters of a runtime mapping that are needed to
@@ -579,13 +579,45 @@ type file struct {
 	base     uint64
 	baseErr  error // Any eventual error while computing the base.
 	isData   bool
+	// How the base was computed, nil until it is.
+	reloc *Relocation
 	// Mapping information. Relevant only for ELF files, nil otherwise.
 	m *elfMapping
 }
 
+// A Relocation describes how the runtime addresses of a mapping were
+// translated to the addresses of its binary, for the record of the
+// profiles symbolized with it.
+type Relocation struct {
+	// Base is the base subtracted from the runtime addresses.
+	Base uint64
+	// Mode is the rule the base was computed by: one of the modes of
+	// elfexec.RelocationMode for ELF binaries, "macho" or "pe".
+	Mode string
+	// Segment is the load segment of an ELF binary the base was computed
+	// from, or nil.
+	Segment *elf.ProgHeader
+}
+
+// FileRelocation returns the relocation of the mapping of f, opened by
+// Binutils, and whether it is known: the base of the mapping of an ELF
+// binary is only computed from the first address looked up in it.
+func FileRelocation(f plugin.ObjFile) (Relocation, bool) {
+	if r, ok := f.(interface{ relocation() *Relocation }); ok {
+		if reloc := r.relocation(); reloc != nil {
+			return *reloc, true
+		}
+	}
+	return Relocation{}, false
+}
+
+func (f *file) relocation() *Relocation {
+	return f.reloc
+}
+
 // computeBase computes the relocation base for the given binary file only if
-// the elfMapping field is set. It populates the base and isData fields and
-// returns an error.
+// the elfMapping field is set. It populates the base, isData and reloc
+// fields and returns an error.
 func (f *file) computeBase(addr uint64) error {
 	if f == nil || f.m == nil {
 		return nil
@@ -604,12 +636,13 @@ func (f *file) computeBase(addr uint64) error {
 		return fmt.Errorf("failed to find program header for file %q, ELF mapping %#v, address %x: %v", f.name, *f.m, addr, err)
 	}
 
-	base, err := elfexec.GetBase(&ef.FileHeader, ph, f.m.stextOffset, f.m.start, f.m.limit, f.m.offset)
+	reloc, err := elfexec.GetRelocation(&ef.FileHeader, ph, f.m.stextOffset, f.m.start, f.m.limit, f.m.offset, elfexec.MappingAlignment(ph, f.m.start))
 	if err != nil {
 		return err
 	}
-	f.base = base
+	f.base = reloc.Base
 	f.isData = ph != nil && ph.Flags&elf.PF_X == 0
+	f.reloc = &Relocation{Base: reloc.Base, Mode: string(reloc.Mode), Segment: ph}
 	return nil
 }
 
@@ -838,3 +838,25 @@ func TestELFObjAddr(t *testing.T) {
 		})
 	}
 }
+
+func TestFileRelocation(t *testing.T) {
+	b := binrep{}
+	o, err := b.openELF(filepath.Join("testdata", "exe_linux_64"), 0x5400000, 0x5401000, 0)
+	if err != nil {
+		t.Fatalf("openELF: %v", err)
+	}
+	// The base of an ELF mapping is computed from the first address.
+	if r, ok := FileRelocation(o); ok {
+		t.Errorf("got relocation %+v before looking up an address, want none", r)
+	}
+	if _, err := o.ObjAddr(0x5400400); err != nil {
+		t.Fatalf("ObjAddr: %v", err)
+	}
+	r, ok := FileRelocation(o)
+	if !ok {
+		t.Fatal("got no relocation after looking up an address")
+	}
+	if r.Base != 0x5000000 || r.Mode != "user" || r.Segment == nil || r.Segment.Vaddr != 0x400000 || r.Segment.Align != 0x200000 {
+		t.Errorf("got relocation %+v, segment %+v, want base 0x5000000 by the user mode from the segment at 0x400000", r, r.Segment)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Tool is an external tool used by Binutils, identified by its version
+// and the hash of its executable, to pin the tools symbolizing and
+// disassembling profiles.
+type Tool struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+// Tools returns the external tools found by bu, in the order they are
+// preferred for symbolization.
+func (bu *Binutils) Tools() ([]Tool, error) {
+	b := bu.get()
+	var tools []Tool
+	for _, t := range []struct {
+		name, path string
+		found      bool
+	}{
+		{"llvm-symbolizer", b.llvmSymbolizer, b.llvmSymbolizerFound},
+		{"addr2line", b.addr2line, b.addr2lineFound},
+		{"nm", b.nm, b.nmFound},
+		{"objdump", b.objdump, b.objdumpFound},
+	} {
+		if !t.found {
+			continue
+		}
+		hash, err := fileSHA256(t.path)
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %v", t.name, err)
+		}
+		tools = append(tools, Tool{
+			Name:    t.name,
+			Path:    t.path,
+			Version: toolVersion(t.path),
+			SHA256:  hash,
+		})
+	}
+	return tools, nil
+}
+
+// toolVersion returns the first line mentioning a version number in the
+// --version output of the tool at path, or "" if it has none.
+func toolVersion(path string) string {
+	out, _ := exec.Command(path, "--version").Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); strings.ContainsAny(line, "0123456789") {
+			return line
+		}
+	}
+	return ""
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestTools(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("test runs shell scripts as tools")
+	}
+	dir, err := ioutil.TempDir("", "pprof_tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var want []Tool
+	for _, tool := range []struct{ name, file, version string }{
+		{"addr2line", "addr2line", "GNU addr2line (GNU Binutils) 2.38"},
+		{"objdump", "objdump", "GNU objdump (GNU Binutils) 2.38"},
+	} {
+		script := []byte("#!/bin/sh\necho 'Copyright notice'\necho '" + tool.version + "'\n")
+		path := filepath.Join(dir, tool.file)
+		if err := ioutil.WriteFile(path, script, 0755); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(script)
+		want = append(want, Tool{Name: tool.name, Path: path, Version: tool.version, SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	bu := &Binutils{}
+	bu.SetTools(dir)
+	got, err := bu.Tools()
+	if err != nil {
+		t.Fatalf("Tools: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got tools %+v, want %+v", got, want)
+	}
+}
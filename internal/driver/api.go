@@ -0,0 +1,381 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// apiUsage describes pprof api.
+const apiUsage = `
+  pprof api [-allow_sources] [host]:[port]
+
+  Serves the operations of pprof over HTTP, as a backend for other
+  profiling frontends. Requests are handled concurrently, each as a POST
+  of a JSON object:
+
+    /fetch   Fetches the profile of "sources", a single source
+    /merge   Merges the profiles of "sources"
+    /diff    Subtracts the profiles of "base" from those of "sources",
+             or compares them with "diff_base": true
+    /report  Generates the "report" of the profile of "sources", eg "top"
+             or "svg", with the "options" of the interactive shell, eg
+             {"nodecount": "10", "focus": "main"}, and the "regexp" of
+             the functions to list for reports such as "list" or "disasm"
+
+  The operations other than /report return the profile in compressed
+  protobuf format. Sources are "inline:<n>" for the n-th element of
+  "profiles", which holds base64-encoded profiles. With -allow_sources,
+  sources can also be the file names and URLs that pprof can open, read
+  and fetch on behalf of the clients, and the reports can read the
+  source files and binaries of the server: the "list", "disasm",
+  "diffdisasm" and "unsampled" reports, the "metadata", "source_path" and
+  "trim_path" options, and symbolization. The "seconds", "timeout", "symbolize" and "normalize" fields match the
+  pprof options with the same names, and "trace_ids" restricts the
+  profile to the samples of these trace or span IDs, matched against
+  their trace_id and span_id labels. Errors are returned as a JSON object
+  with an "error" field, and the messages of pprof as Pprof-Message
+  headers.
+`
+
+// maxAPIRequestSize is the maximum size of the requests to pprof api.
+const maxAPIRequestSize = 256 << 20
+
+// apiRequest is the request of an operation of pprof api.
+type apiRequest struct {
+	Sources   []string          `json:"sources"`
+	Base      []string          `json:"base"`
+	DiffBase  bool              `json:"diff_base"`
+	Normalize bool              `json:"normalize"`
+	Profiles  [][]byte          `json:"profiles"`
+	Seconds   int               `json:"seconds"`
+	Timeout   int               `json:"timeout"`
+	Symbolize string            `json:"symbolize"`
+	TraceIDs  []string          `json:"trace_ids"`
+	Report    string            `json:"report"`
+	Regexp    string            `json:"regexp"`
+	Options   map[string]string `json:"options"`
+}
+
+// serveAPI implements pprof api, serving the operations of pprof on the
+// host:port of args.
+func serveAPI(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	allowSources := len(args) > 0 && args[0] == "-allow_sources"
+	if allowSources {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return errors.New("pprof api takes a single [host]:[port] to serve on" + apiUsage)
+	}
+	host, port, err := getHostAndPort(args[0])
+	if err != nil {
+		return err
+	}
+	a := &apiServer{cfg: cfg, options: o, allowSources: allowSources}
+	server := o.HTTPServer
+	if server == nil {
+		server = defaultAPIServer
+	}
+	hostport := net.JoinHostPort(host, strconv.Itoa(port))
+	o.UI.Print("Serving pprof API on http://", hostport)
+	return server(&plugin.HTTPServerArgs{
+		Hostport: hostport,
+		Host:     host,
+		Port:     port,
+		Handlers: map[string]http.Handler{
+			"/fetch":  a.handler(a.fetch),
+			"/merge":  a.handler(a.merge),
+			"/diff":   a.handler(a.diff),
+			"/report": a.handler(a.report),
+		},
+	})
+}
+
+// defaultAPIServer serves the handlers of args, only to local clients if
+// args.Host is local.
+func defaultAPIServer(args *plugin.HTTPServerArgs) error {
+	ln, err := net.Listen("tcp", args.Hostport)
+	if err != nil {
+		return err
+	}
+	s := &http.Server{Handler: localHandlers(args)}
+	return s.Serve(ln)
+}
+
+// apiServer serves the operations of pprof api.
+type apiServer struct {
+	cfg          config
+	options      *plugin.Options
+	allowSources bool // Whether to fetch the sources other than inline profiles.
+}
+
+// apiOperation runs an operation of pprof api, returning its output and
+// content type.
+type apiOperation func(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error)
+
+// handler returns an HTTP handler running op on the JSON request.
+func (a *apiServer) handler(op apiOperation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apiError(w, http.StatusMethodNotAllowed, errors.New("requests must be POSTed"))
+			return
+		}
+		var req apiRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("malformed request: %v", err))
+			return
+		}
+
+		ui := &apiUI{}
+		o := *a.options
+		o.UI = ui
+		o.Fetch = &apiFetcher{profiles: req.Profiles, fetcher: a.options.Fetch, allowSources: a.allowSources}
+		out, contentType, err := op(r.Context(), &req, &o)
+		for _, m := range ui.messages() {
+			w.Header().Add("Pprof-Message", m)
+		}
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(out)
+	})
+}
+
+// apiError replies to a request of pprof api with err.
+func apiError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// fetch implements /fetch.
+func (a *apiServer) fetch(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error) {
+	if len(req.Sources) != 1 || len(req.Base) != 0 {
+		return nil, "", errors.New("fetch takes a single source and no base, use merge or diff instead")
+	}
+	return a.profile(ctx, req, o)
+}
+
+// merge implements /merge.
+func (a *apiServer) merge(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error) {
+	if len(req.Sources) == 0 || len(req.Base) != 0 {
+		return nil, "", errors.New("merge takes one or more sources and no base, use diff instead")
+	}
+	return a.profile(ctx, req, o)
+}
+
+// diff implements /diff.
+func (a *apiServer) diff(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error) {
+	if len(req.Sources) == 0 || len(req.Base) == 0 {
+		return nil, "", errors.New("diff takes one or more sources and bases")
+	}
+	return a.profile(ctx, req, o)
+}
+
+// profile returns the profile of req in compressed protobuf format.
+func (a *apiServer) profile(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error) {
+	p, err := a.fetchProfile(ctx, req, o)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "application/vnd.google.protobuf+gzip", nil
+}
+
+// report implements /report.
+func (a *apiServer) report(ctx context.Context, req *apiRequest, o *plugin.Options) ([]byte, string, error) {
+	c := pprofCommands[req.Report]
+	if c == nil || c.visualizer != nil {
+		return nil, "", fmt.Errorf("unknown report %q", req.Report)
+	}
+	if !a.allowSources && apiFileReports[req.Report] {
+		return nil, "", fmt.Errorf("report %q reads the files of the server, which pprof api only does with -allow_sources", req.Report)
+	}
+	cfg := a.cfg
+	cfg.Output = ""
+	for name, value := range req.Options {
+		if !a.allowSources && apiFileOptions[name] {
+			return nil, "", fmt.Errorf("option %q names files of the server, which pprof api only reads with -allow_sources", name)
+		}
+		if err := cfg.configure(name, value); err != nil {
+			return nil, "", err
+		}
+	}
+	cmd := []string{req.Report}
+	if c.hasParam {
+		if req.Regexp == "" {
+			return nil, "", fmt.Errorf("report %q requires a regexp", req.Report)
+		}
+		cmd = append(cmd, req.Regexp)
+	}
+
+	p, err := a.fetchProfile(ctx, req, o)
+	if err != nil {
+		return nil, "", err
+	}
+	_, out, err := renderReport(ctx, p, cmd, cfg, o)
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), http.DetectContentType(out.Bytes()), nil
+}
+
+// apiFileOptions are the options naming files of the server, and
+// apiFileReports the reports reading the sources and binaries of the
+// profiles, which pprof api only accepts with -allow_sources.
+var (
+	apiFileOptions = map[string]bool{"output": true, "metadata": true, "source_path": true, "trim_path": true}
+	apiFileReports = map[string]bool{"list": true, "disasm": true, "diffdisasm": true, "unsampled": true}
+)
+
+// fetchProfile fetches the profile of the sources and bases of req,
+// restricted to the samples of its trace IDs.
+func (a *apiServer) fetchProfile(ctx context.Context, req *apiRequest, o *plugin.Options) (*profile.Profile, error) {
+	s := &source{
+		Sources:   req.Sources,
+		Base:      req.Base,
+		DiffBase:  req.DiffBase,
+		Normalize: req.Normalize,
+		Seconds:   req.Seconds,
+		Timeout:   req.Timeout,
+		Symbolize: req.Symbolize,
+	}
+	if s.Seconds == 0 {
+		s.Seconds = -1
+	}
+	if s.Timeout == 0 {
+		s.Timeout = -1
+	}
+	if !a.allowSources {
+		// Local symbolization reads the binaries of the server.
+		switch s.Symbolize {
+		case "":
+			s.Symbolize = "none"
+		case "none":
+		default:
+			return nil, fmt.Errorf("symbolize %q reads the binaries of the server, which pprof api only does with -allow_sources", s.Symbolize)
+		}
+	}
+	if len(s.Sources) == 0 {
+		return nil, errors.New("no profile source specified")
+	}
+	p, err := fetchProfiles(ctx, s, o)
+	if err != nil || len(req.TraceIDs) == 0 {
+		return p, err
+	}
+	if fm, _ := p.FilterSamplesByTag(compileTraceFilter(strings.Join(req.TraceIDs, ",")), nil); !fm {
+		return nil, fmt.Errorf("no samples found for trace IDs %s", strings.Join(req.TraceIDs, ", "))
+	}
+	return p, nil
+}
+
+// apiInlinePrefix is the prefix of the sources of pprof api naming the
+// profiles inlined in the request.
+const apiInlinePrefix = "inline:"
+
+// apiFetcher fetches the profiles inlined in a request of pprof api, and
+// the other sources with fetcher if allowSources is set.
+type apiFetcher struct {
+	profiles     [][]byte
+	fetcher      plugin.Fetcher
+	allowSources bool
+}
+
+func (f *apiFetcher) Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error) {
+	if !strings.HasPrefix(src, apiInlinePrefix) {
+		if !f.allowSources {
+			return nil, "", fmt.Errorf("%s: pprof api only serves inline profiles unless run with -allow_sources", src)
+		}
+		if f.fetcher == nil {
+			// Fetch the profile over HTTP or from a file.
+			return nil, "", nil
+		}
+		return f.fetcher.Fetch(src, duration, timeout)
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(src, apiInlinePrefix))
+	if err != nil || i < 0 || i >= len(f.profiles) {
+		return nil, "", fmt.Errorf("%s: no such inline profile", src)
+	}
+	p, err := profile.ParseData(f.profiles[i])
+	return p, "", err
+}
+
+// apiUI records the messages of pprof while handling a request of pprof
+// api, to return them with its response.
+type apiUI struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (ui *apiUI) ReadLine(prompt string) (string, error) {
+	return "", errors.New("pprof api is not interactive")
+}
+
+func (ui *apiUI) Print(args ...interface{}) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.msgs = append(ui.msgs, strings.TrimSpace(fmt.Sprint(args...)))
+}
+
+func (ui *apiUI) PrintErr(args ...interface{}) {
+	ui.Print(args...)
+}
+
+func (ui *apiUI) IsTerminal() bool {
+	return false
+}
+
+func (ui *apiUI) WantBrowser() bool {
+	return false
+}
+
+func (ui *apiUI) SetAutoComplete(func(string) string) {
+}
+
+// messages returns the recorded messages, with their lines joined, as
+// header values cannot span lines.
+func (ui *apiUI) messages() []string {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	msgs := make([]string, len(ui.msgs))
+	for i, m := range ui.msgs {
+		msgs[i] = strings.Join(strings.Fields(m), " ")
+	}
+	return msgs
+}
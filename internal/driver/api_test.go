@@ -0,0 +1,255 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestAPI(t *testing.T) {
+	var server *httptest.Server
+	creator := func(a *plugin.HTTPServerArgs) error {
+		server = httptest.NewServer(localHandlers(a))
+		return nil
+	}
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t, AllowRx: "Serving pprof API"},
+		HTTPServer:    creator,
+		HTTPTransport: &httpTransport{},
+	})
+	if err := serveAPI(context.Background(), []string{"localhost:0"}, defaultConfig(), o); err != nil {
+		t.Fatalf("serveAPI: %v", err)
+	}
+	defer server.Close()
+
+	inline := func(spec string) []byte {
+		t.Helper()
+		p, err := parseSynthSpec("spec", []byte("sample_type cpu nanoseconds\nmapping /bin/app\n"+spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	profiles := [][]byte{
+		inline("stack main;work 30\nstack main;idle 10\n"),
+		inline("stack main;work 20\n"),
+		inline("stack main;work 5 trace_id=t1 span_id=s1\nstack main;idle 7 trace_id=t2\nstack main;idle 9\n"),
+	}
+
+	post := func(path string, req *apiRequest) (*http.Response, []byte) {
+		t.Helper()
+		req.Profiles = profiles
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(server.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, data
+	}
+	total := func(data []byte) int64 {
+		t.Helper()
+		p, err := profile.ParseData(data)
+		if err != nil {
+			t.Fatalf("parsing profile: %v", err)
+		}
+		var v int64
+		for _, s := range p.Sample {
+			v += s.Value[0]
+		}
+		return v
+	}
+
+	for _, tc := range []struct {
+		path  string
+		req   apiRequest
+		total int64
+	}{
+		{"/fetch", apiRequest{Sources: []string{"inline:0"}}, 40},
+		{"/merge", apiRequest{Sources: []string{"inline:0", "inline:1"}}, 60},
+		{"/diff", apiRequest{Sources: []string{"inline:0"}, Base: []string{"inline:1"}}, 20},
+		{"/fetch", apiRequest{Sources: []string{"inline:2"}, TraceIDs: []string{"t2", "s1"}}, 12},
+	} {
+		res, data := post(tc.path, &tc.req)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", tc.path, res.StatusCode, data)
+			continue
+		}
+		if got := total(data); got != tc.total {
+			t.Errorf("%s: got total %d, want %d", tc.path, got, tc.total)
+		}
+	}
+
+	res, data := post("/report", &apiRequest{
+		Sources: []string{"inline:0"},
+		Report:  "top",
+		Options: map[string]string{"nodecount": "1", "hide": "main"},
+	})
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(data), "work") || strings.Contains(string(data), "idle") {
+		t.Errorf("/report: got status %d and report:\n%s", res.StatusCode, data)
+	}
+
+	for _, tc := range []struct {
+		path string
+		req  apiRequest
+		want string
+	}{
+		{"/fetch", apiRequest{Sources: []string{"inline:0", "inline:1"}}, "fetch takes a single source"},
+		{"/diff", apiRequest{Sources: []string{"inline:0"}}, "diff takes one or more sources and bases"},
+		{"/merge", apiRequest{Sources: []string{"inline:3"}}, "inline:3: no such inline profile"},
+		{"/fetch", apiRequest{Sources: []string{"cpu.pb.gz"}}, "cpu.pb.gz: pprof api only serves inline profiles unless run with -allow_sources"},
+		{"/fetch", apiRequest{Sources: []string{"inline:2"}, TraceIDs: []string{"t3"}}, "no samples found for trace IDs t3"},
+		{"/report", apiRequest{Sources: []string{"inline:0"}, Report: "web"}, `unknown report \"web\"`},
+		{"/report", apiRequest{Sources: []string{"inline:0"}, Report: "peek"}, `report \"peek\" requires a regexp`},
+		{"/report", apiRequest{Sources: []string{"inline:0"}, Report: "list", Regexp: "main"}, `report \"list\" reads the files of the server`},
+		{"/report", apiRequest{Sources: []string{"inline:0"}, Report: "top", Options: map[string]string{"metadata": "/etc/passwd"}}, `option \"metadata\" names files of the server`},
+		{"/fetch", apiRequest{Sources: []string{"inline:0"}, Symbolize: "local"}, `symbolize \"local\" reads the binaries of the server`},
+		{"/report", apiRequest{Sources: []string{"inline:0"}, Report: "top", Options: map[string]string{"bogus": "1"}}, `unknown config field \"bogus\"`},
+	} {
+		res, data := post(tc.path, &tc.req)
+		// The errors of the sources are returned as messages.
+		got := string(data) + strings.Join(res.Header["Pprof-Message"], "\n")
+		if res.StatusCode != http.StatusBadRequest || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: got status %d and response %s, want an error containing %s", tc.path, res.StatusCode, got, tc.want)
+		}
+	}
+
+	// The reports written by pprof rather than by the report package are
+	// served too.
+	res, data = post("/report", &apiRequest{Sources: []string{"inline:0"}, Report: "flamegraphjson"})
+	if res.StatusCode != http.StatusOK || !json.Valid(data) || !strings.Contains(string(data), "work") {
+		t.Errorf("/report flamegraphjson: got status %d and report:\n%s", res.StatusCode, data)
+	}
+
+	if res, err := http.Get(server.URL + "/fetch"); err != nil {
+		t.Errorf("GET /fetch: %v", err)
+	} else {
+		res.Body.Close()
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("GET /fetch: got status %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+		}
+	}
+
+	// Requests are handled concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(&apiRequest{Sources: []string{"inline:0", "inline:1"}, Profiles: profiles})
+			res, err := http.Post(server.URL+"/merge", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("concurrent /merge: got status %d", res.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAPIAllowSources(t *testing.T) {
+	p, err := parseSynthSpec("spec", []byte("sample_type cpu nanoseconds\nmapping /bin/app\nstack main;work 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "api_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var server *httptest.Server
+	o := setDefaults(&plugin.Options{
+		UI: &proftest.TestUI{T: t, AllowRx: "Serving pprof API"},
+		HTTPServer: func(a *plugin.HTTPServerArgs) error {
+			server = httptest.NewServer(localHandlers(a))
+			return nil
+		},
+		HTTPTransport: &httpTransport{},
+	})
+	if err := serveAPI(context.Background(), []string{"-allow_sources", "localhost:0"}, defaultConfig(), o); err != nil {
+		t.Fatalf("serveAPI: %v", err)
+	}
+	defer server.Close()
+
+	// The reports can read the files of the server.
+	body, err := json.Marshal(&apiRequest{Sources: []string{f.Name()}, Report: "list", Regexp: "work", Options: map[string]string{"source_path": os.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := http.Post(server.URL+"/report", "application/json", bytes.NewReader(body)); err != nil {
+		t.Fatal(err)
+	} else {
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("/report list: got status %d, want %d", res.StatusCode, http.StatusOK)
+		}
+	}
+
+	body, err = json.Marshal(&apiRequest{Sources: []string{f.Name()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(server.URL+"/fetch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("/fetch %s: got status %d: %s", f.Name(), res.StatusCode, data)
+	}
+	if q, err := profile.ParseData(data); err != nil || len(q.Sample) != 1 || q.Sample[0].Value[0] != 30 {
+		t.Errorf("/fetch %s: got profile %v, %v, want the profile of the file", f.Name(), q, err)
+	}
+}
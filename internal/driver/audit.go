@@ -0,0 +1,57 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// auditPrefix starts the comments of a profile recording the
+// transformations pprof applied to it, such as merges, subtractions,
+// scaling and filters, in the order they were applied. They are kept in
+// the profiles pprof writes, and shown in the headers of reports, so
+// that a derived profile tells how it was made.
+const auditPrefix = "pprof: "
+
+// audit records the transformation described by format and args in the
+// comments of p.
+func audit(p *profile.Profile, format string, args ...interface{}) {
+	p.Comments = append(p.Comments, auditPrefix+fmt.Sprintf(format, args...))
+}
+
+// auditFilters records the filters of cfg applied to p, for the profiles
+// written by reports. Other reports list them as their active filters.
+func auditFilters(p *profile.Profile, cfg config) {
+	for _, f := range []struct{ name, value string }{
+		{"focus", cfg.Focus},
+		{"ignore", cfg.Ignore},
+		{"hide", cfg.Hide},
+		{"show", cfg.Show},
+		{"show_from", cfg.ShowFrom},
+		{"tagfocus", cfg.TagFocus},
+		{"tagignore", cfg.TagIgnore},
+		{"tagshow", cfg.TagShow},
+		{"taghide", cfg.TagHide},
+		{"trace", cfg.Trace},
+		{"time_window", cfg.TimeWindow},
+		{"prune_from", cfg.PruneFrom},
+	} {
+		if f.value != "" {
+			audit(p, "Filtered the samples with -%s=%s", f.name, f.value)
+		}
+	}
+}
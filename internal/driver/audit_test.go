@@ -0,0 +1,88 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/internal/transport"
+	"github.com/google/pprof/profile"
+)
+
+func TestAudit(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	f := testFlags{
+		stringLists: map[string][]string{
+			"diff_base": {"testdata/cppbench.small.contention"},
+		},
+		bools: map[string]bool{
+			"normalize": true,
+		},
+		args: []string{"testdata/cppbench.contention", "testdata/cppbench.contention"},
+	}
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t, AllowRx: "Local symbolization failed|Some binary filenames not available"},
+		Flagset:       f,
+		HTTPTransport: transport.New(nil),
+	})
+	src, _, err := parseFlags(o)
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	p, err := fetchProfiles(context.Background(), src, o)
+	if err != nil {
+		t.Fatalf("fetchProfiles: %v", err)
+	}
+
+	cfg := currentConfig()
+	cfg.Focus = "libpthread"
+	_, rpt, err := generateRawReport(context.Background(), p, []string{"proto"}, cfg, o)
+	if err != nil {
+		t.Fatalf("generateRawReport: %v", err)
+	}
+	var b bytes.Buffer
+	if err := report.Generate(&b, rpt, o.Obj); err != nil {
+		t.Fatalf("report.Generate: %v", err)
+	}
+	written, err := profile.Parse(&b)
+	if err != nil {
+		t.Fatalf("parsing the written profile: %v", err)
+	}
+
+	var got []string
+	for _, c := range written.Comments {
+		if strings.HasPrefix(c, auditPrefix) {
+			got = append(got, c)
+		}
+	}
+	want := []string{
+		"pprof: Merged 2 source profiles",
+		"pprof: Scaled the source profiles to the total of the base profiles with -normalize",
+		"pprof: Subtracted the base profiles with -diff_base, labeling their samples pprof::base",
+		"pprof: Filtered the samples with -focus=libpthread",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got audit comments %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// benchUsage describes pprof bench.
+const benchUsage = `
+  pprof bench [options] <old dir> <new dir>
+
+  Compares the profiles of benchmarks written by "go test -bench
+  -cpuprofile" to the two directories, pairing them by file name, eg
+  old/BenchmarkFoo.prof with new/BenchmarkFoo.prof. For each benchmark,
+  it lists the functions whose share of the profile changed the most,
+  with their flat share, or their cumulative share with -cum. Use
+  -nodecount to set the number of functions listed per benchmark, and
+  -sample_index to select the sample value to compare.
+`
+
+// defaultBenchFunctions is the number of functions listed per benchmark
+// unless -nodecount is set.
+const defaultBenchFunctions = 10
+
+// compareBenchmarks implements pprof bench, comparing the profiles in
+// the two directories of args.
+func compareBenchmarks(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	if len(args) != 2 {
+		return errors.New("pprof bench takes two directories of profiles" + benchUsage)
+	}
+	oldFiles, err := benchProfiles(args[0])
+	if err != nil {
+		return err
+	}
+	newFiles, err := benchProfiles(args[1])
+	if err != nil {
+		return err
+	}
+
+	var names, onlyOld, onlyNew []string
+	for name := range oldFiles {
+		if _, ok := newFiles[name]; ok {
+			names = append(names, name)
+		} else {
+			onlyOld = append(onlyOld, name)
+		}
+	}
+	for name := range newFiles {
+		if _, ok := oldFiles[name]; !ok {
+			onlyNew = append(onlyNew, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(onlyOld)
+	sort.Strings(onlyNew)
+	if len(names) == 0 {
+		return fmt.Errorf("no benchmark profiles in common between %s and %s", args[0], args[1])
+	}
+
+	var comparisons []*benchComparison
+	for _, name := range names {
+		pold, err := fetchBenchProfile(ctx, oldFiles[name], o)
+		if err != nil {
+			return err
+		}
+		pnew, err := fetchBenchProfile(ctx, newFiles[name], o)
+		if err != nil {
+			return err
+		}
+		c, err := compareBenchmark(name, pold, pnew, cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		comparisons = append(comparisons, c)
+	}
+
+	rows := cfg.NodeCount
+	if rows <= 0 {
+		rows = defaultBenchFunctions
+	}
+	var buf bytes.Buffer
+	printBenchComparisons(&buf, comparisons, rows, cfg.Sort == "cum")
+	for _, name := range onlyOld {
+		fmt.Fprintf(&buf, "only in %s: %s\n", args[0], name)
+	}
+	for _, name := range onlyNew {
+		fmt.Fprintf(&buf, "only in %s: %s\n", args[1], name)
+	}
+
+	if cfg.Output == "" {
+		_, err := buf.WriteTo(os.Stdout)
+		return err
+	}
+	o.UI.PrintErr("Generating report in ", cfg.Output)
+	out, err := o.Writer.Open(cfg.Output)
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// benchProfiles returns the profile files in dir by benchmark name, which
+// is the file name without its extension. Test binaries, which go test
+// leaves next to the profiles, are skipped.
+func benchProfiles(dir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".test") {
+			continue
+		}
+		bench := strings.TrimSuffix(name, ".gz")
+		bench = strings.TrimSuffix(bench, filepath.Ext(bench))
+		if prev, ok := files[bench]; ok {
+			return nil, fmt.Errorf("%s: both %s and %s hold profiles of %s", dir, filepath.Base(prev), name, bench)
+		}
+		files[bench] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
+// fetchBenchProfile fetches and symbolizes the profile in file.
+func fetchBenchProfile(ctx context.Context, file string, o *plugin.Options) (*profile.Profile, error) {
+	return fetchProfiles(ctx, &source{Sources: []string{file}, Seconds: -1, Timeout: -1}, o)
+}
+
+// benchComparison holds the comparison of the profiles of a benchmark.
+type benchComparison struct {
+	name               string
+	unit               string
+	oldTotal, newTotal int64
+	functions          []*benchFunction
+}
+
+// benchFunction holds the values of a function in the old and new
+// profiles of a benchmark.
+type benchFunction struct {
+	name               string
+	old, new           functionValue
+	oldShare, newShare float64 // Shares of the compared value.
+}
+
+// functionValue holds the flat and cumulative values of a function.
+type functionValue struct {
+	flat, cum int64
+}
+
+// compareBenchmark compares the values of the functions in the old and
+// new profiles of a benchmark.
+func compareBenchmark(name string, pold, pnew *profile.Profile, cfg config) (*benchComparison, error) {
+	oi, err := pold.SampleIndexByName(cfg.SampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	st := pold.SampleType[oi]
+	ni, err := pnew.SampleIndexByName(st.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &benchComparison{name: name, unit: st.Unit}
+	oldValues, oldTotal := benchFunctionValues(pold, oi)
+	newValues, newTotal := benchFunctionValues(pnew, ni)
+	c.oldTotal, c.newTotal = oldTotal, newTotal
+	for name, v := range oldValues {
+		f := &benchFunction{name: name, old: *v}
+		if nv := newValues[name]; nv != nil {
+			f.new = *nv
+		}
+		c.functions = append(c.functions, f)
+	}
+	for name, v := range newValues {
+		if oldValues[name] == nil {
+			c.functions = append(c.functions, &benchFunction{name: name, new: *v})
+		}
+	}
+	return c, nil
+}
+
+// benchFunctionValues returns the flat and cumulative values of the
+// functions in p by name for the sample value at index si, and the total
+// value of p.
+func benchFunctionValues(p *profile.Profile, si int) (map[string]*functionValue, int64) {
+	values := make(map[string]*functionValue)
+	var total int64
+	for _, s := range p.Sample {
+		v := s.Value[si]
+		total += v
+		seen := make(map[string]bool)
+		for i, l := range s.Location {
+			for j, ln := range l.Line {
+				if ln.Function == nil {
+					continue
+				}
+				name := ln.Function.Name
+				fv := values[name]
+				if fv == nil {
+					fv = &functionValue{}
+					values[name] = fv
+				}
+				if i == 0 && j == 0 {
+					fv.flat += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					fv.cum += v
+				}
+			}
+		}
+	}
+	return values, total
+}
+
+// printBenchComparisons prints a table with the functions of each
+// benchmark whose share of their profile changed the most.
+func printBenchComparisons(w io.Writer, comparisons []*benchComparison, rows int, cum bool) {
+	kind := "flat"
+	if cum {
+		kind = "cum"
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "benchmark\tfunction\told %s%%\tnew %s%%\tdelta\n", kind, kind)
+	for _, c := range comparisons {
+		share := func(v, total int64) float64 {
+			if total == 0 {
+				return 0
+			}
+			return float64(v) / float64(total)
+		}
+		for _, f := range c.functions {
+			oldValue, newValue := f.old.flat, f.new.flat
+			if cum {
+				oldValue, newValue = f.old.cum, f.new.cum
+			}
+			f.oldShare, f.newShare = share(oldValue, c.oldTotal), share(newValue, c.newTotal)
+		}
+		sort.Slice(c.functions, func(i, j int) bool {
+			fi, fj := c.functions[i], c.functions[j]
+			di, dj := math.Abs(fi.newShare-fi.oldShare), math.Abs(fj.newShare-fj.oldShare)
+			if di != dj {
+				return di > dj
+			}
+			return fi.name < fj.name
+		})
+
+		fmt.Fprintf(tw, "%s\t[total]\t%s\t%s\t%s\n", c.name,
+			measurement.Label(c.oldTotal, c.unit), measurement.Label(c.newTotal, c.unit),
+			benchDelta(float64(c.oldTotal), float64(c.newTotal)))
+		for i, f := range c.functions {
+			if i == rows || f.oldShare == f.newShare {
+				break
+			}
+			fmt.Fprintf(tw, "\t%s\t%.2f%%\t%.2f%%\t%s\n", f.name, 100*f.oldShare, 100*f.newShare, benchDelta(f.oldShare, f.newShare))
+		}
+	}
+	tw.Flush()
+}
+
+// benchDelta formats the relative change from old to new.
+func benchDelta(old, new float64) string {
+	switch {
+	case old == new:
+		return "~"
+	case old == 0:
+		return "new"
+	}
+	return fmt.Sprintf("%+.2f%%", 100*(new-old)/old)
+}
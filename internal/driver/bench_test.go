@@ -0,0 +1,146 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestCompareBenchmarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProfile := func(name, spec string) {
+		t.Helper()
+		p, err := parseSynthSpec(name, []byte("sample_type cpu nanoseconds\nmapping /bin/bench.test\n"+spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProfile("old/BenchmarkWork.prof", "stack main;work;alloc 30\nstack main;work 60\nstack main;gc 10\n")
+	writeProfile("new/BenchmarkWork.prof", "stack main;work;alloc 60\nstack main;work 60\nstack main;gc 20\nstack main;scan 10\n")
+	writeProfile("old/BenchmarkGone.prof", "stack main 1\n")
+	writeProfile("new/BenchmarkNew.pb.gz", "stack main 1\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "new", "bench.test"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		cfg  func(*config)
+		want string
+	}{
+		{
+			"flat",
+			func(*config) {},
+			`benchmark      function  old flat%  new flat%  delta
+BenchmarkWork  [total]   100ns      150ns      +50.00%
+               work      60.00%     40.00%     -33.33%
+               alloc     30.00%     40.00%     +33.33%
+               scan      0.00%      6.67%      new
+               gc        10.00%     13.33%     +33.33%
+only in old: BenchmarkGone
+only in new: BenchmarkNew
+`,
+		},
+		{
+			"cum with two functions",
+			func(cfg *config) { cfg.Sort, cfg.NodeCount = "cum", 2 },
+			`benchmark      function  old cum%  new cum%  delta
+BenchmarkWork  [total]   100ns     150ns     +50.00%
+               alloc     30.00%    40.00%    +33.33%
+               work      90.00%    80.00%    -11.11%
+only in old: BenchmarkGone
+only in new: BenchmarkNew
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.cfg(&cfg)
+			cfg.Output = filepath.Join(dir, "out.txt")
+			o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: "Generating report in"}, HTTPTransport: &httpTransport{}})
+
+			// Run from dir to get short directory names in the output.
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			if err := compareBenchmarks(context.Background(), []string{"old", "new"}, cfg, o); err != nil {
+				t.Fatalf("compareBenchmarks: %v", err)
+			}
+			got, err := ioutil.ReadFile(cfg.Output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBenchProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"BenchmarkA.prof", "BenchmarkB.pb.gz", "pkg.test", ".hidden"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := benchProfiles(dir)
+	if err != nil {
+		t.Fatalf("benchProfiles: %v", err)
+	}
+	if len(got) != 2 || got["BenchmarkA"] != filepath.Join(dir, "BenchmarkA.prof") || got["BenchmarkB"] != filepath.Join(dir, "BenchmarkB.pb.gz") {
+		t.Errorf("got %v, want BenchmarkA and BenchmarkB", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "BenchmarkA.out"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := benchProfiles(dir); err == nil {
+		t.Errorf("got no error for two profiles of BenchmarkA")
+	}
+}
@@ -30,13 +30,85 @@ type source struct {
 	BuildID   string
 	Base      []string
 	DiffBase  bool
+	DeltaFrom bool // Base is an earlier profile of cumulative counters, given with -delta_from.
 	Normalize bool
 
+	// DiffAutoOrient swaps the source and base profiles of a comparison
+	// that look to have been given in the wrong order.
+	DiffAutoOrient bool
+
+	// SampleTypeMap maps the sample types of the base profiles to those
+	// of the source profiles, as a list of base=source pairs.
+	SampleTypeMap string
+
+	// LabelNames gives the files naming the values of numeric labels, as
+	// a list of key=file pairs.
+	LabelNames string
+
+	// PhaseMap is the file of the execution phases to label the samples
+	// with, by their timestamp.
+	PhaseMap string
+
+	// ProcessTree stitches profiles of related processes into a
+	// single process tree.
+	ProcessTree bool
+
+	// Series labels the samples of each profile with the time it was
+	// collected at, to compare the profiles of a series over time.
+	Series bool
+
+	// Resume completes the symbolization of a profile saved partially
+	// symbolized after its symbolization was interrupted.
+	Resume bool
+
+	// Strict fails on profiles with contents that can only be partially
+	// interpreted, instead of warning about them.
+	Strict bool
+
+	// FetchEnv records the fetch of remote profiles, and the command line
+	// and variables of the programs serving them, in their comments.
+	FetchEnv bool
+
+	// SampleFraction is the fraction of the samples of each profile to
+	// keep before merging them, or 0 to keep them all.
+	SampleFraction float64
+
+	// CacheDir is the directory caching the output of reports, to reuse
+	// it when the same report of the same profile is generated again.
+	CacheDir string
+
+	// DownloadRate limits the bandwidth of profile downloads over HTTP,
+	// in kilobytes per second, or 0 for no limit.
+	DownloadRate int
+
+	// RecordTools and RequireTools name the manifests of the external
+	// tools used to symbolize the profiles, to write or to check them.
+	RecordTools, RequireTools string
+
+	// SelfProfile is the directory to write profiles of pprof itself to,
+	// with the cost of its phases.
+	SelfProfile string
+
+	// selfProfile profiles the phases of pprof for SelfProfile.
+	selfProfile *selfProfiler
+
+	// Subcommand is the pprof subcommand to run on Sources instead of
+	// fetching profiles, such as synth.
+	Subcommand string
+
 	Seconds            int
 	Timeout            int
 	Symbolize          string
 	HTTPHostport       string
 	HTTPDisableBrowser bool
+	HTTPTabs           bool
+	HTTPUser           string
+	HTTPPasswordFile   string
+	TLSCert            string
+	TLSKey             string
+	TLSSelfSigned      bool
+	SSHTunnel          string
+	StaticHTML         string
 	Comment            string
 }
 
@@ -48,11 +120,24 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	// Comparisons.
 	flagDiffBase := flag.StringList("diff_base", "", "Source of base profile for comparison")
 	flagBase := flag.StringList("base", "", "Source of base profile for profile subtraction")
+	flagDeltaFrom := flag.String("delta_from", "", "Source of an earlier profile whose counters to subtract, for cumulative profiles")
+	flagDiffAutoOrient := flag.Bool("diff_auto_orient", false, "Swap the source and base profiles if they look given in the wrong order")
+	flagSampleTypeMap := flag.String("sample_type_map", "", "Sample types of base profiles to compare to those of source profiles, as base=source,...")
 	// Source options.
 	flagSymbolize := flag.String("symbolize", "", "Options for profile symbolization")
+	flagResume := flag.Bool("resume", false, "Complete the symbolization of a profile saved after it was interrupted")
 	flagBuildID := flag.String("buildid", "", "Override build id for first mapping")
 	flagTimeout := flag.Int("timeout", -1, "Timeout in seconds for fetching a profile")
 	flagAddComment := flag.String("add_comment", "", "Annotation string to record in the profile")
+	flagLabelNames := flag.String("label_names", "", "Files naming the values of numeric labels, as key=file,...")
+	flagPhaseMap := flag.String("phase_map", "", "File of the execution phases to label the samples with, by their timestamp")
+	flagProcessTree := flag.Bool("process_tree", false, "Combine profiles of parent and child processes into a process tree")
+	flagSeries := flag.Bool("series", false, "Label the samples of each profile with its collection time, to compare the profiles over time")
+	flagStrict := flag.Bool("strict", false, "Fail on profiles that can only be partially interpreted")
+	flagFetchEnv := flag.Bool("fetch_env", false, "Record the fetch and the environment of remote profiles in their comments")
+	flagSampleFraction := flag.Float64("sample_fraction", 1, "Fraction of the samples of each profile to keep, for profiles too large to merge")
+	flagCacheDir := flag.String("cache_dir", "", "Reuse the output of identical reports cached in the specified directory")
+	flagDownloadRate := flag.Int("download_rate", 0, "Limit profile downloads to the specified kilobytes per second")
 	// CPU profile options
 	flagSeconds := flag.Int("seconds", -1, "Length of time for dynamic profiles")
 	// Heap profile options
@@ -65,9 +150,20 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	flagContentions := flag.Bool("contentions", false, "Display number of delays at each region")
 	flagMeanDelay := flag.Bool("mean_delay", false, "Display mean delay at each region")
 	flagTools := flag.String("tools", os.Getenv("PPROF_TOOLS"), "Path for object tool pathnames")
+	flagRecordTools := flag.String("record_tools", "", "Write the versions and hashes of the object tools to the specified manifest")
+	flagRequireTools := flag.String("require_tools", "", "Fail unless the object tools match the versions and hashes of the specified manifest")
+	flagSelfProfile := flag.String("selfprofile", "", "Write CPU and heap profiles of pprof itself to the specified directory")
 
 	flagHTTP := flag.String("http", "", "Present interactive web UI at the specified http host:port")
 	flagNoBrowser := flag.Bool("no_browser", false, "Skip opening a browswer for the interactive web UI")
+	flagTabs := flag.Bool("tabs", false, "Serve each profile in its own tab of the web UI instead of merging them")
+	flagHTTPUser := flag.String("http_user", "", "Require HTTP basic authentication as the specified user for the web UI")
+	flagHTTPPasswordFile := flag.String("http_password_file", "", "File holding the password of the -http_user")
+	flagTLSCert := flag.String("http_cert", "", "Serve the web UI over HTTPS with the certificate of the specified PEM file")
+	flagTLSKey := flag.String("http_key", "", "PEM file holding the private key of the -http_cert")
+	flagTLSSelfSigned := flag.Bool("http_self_signed", false, "Serve the web UI over HTTPS with a generated self-signed certificate")
+	flagStaticHTML := flag.String("static_html", "", "Write the views of the web UI to the specified directory or zip file")
+	flagSSHTunnel := flag.String("ssh_tunnel", "", "Run the web UI on the specified user@host and tunnel it to the -http port")
 
 	// Flags that set configuration properties.
 	cfg := currentConfig()
@@ -93,6 +189,15 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	if len(args) == 0 {
 		return nil, nil, errors.New("no profile source specified")
 	}
+	// The first argument names a subcommand unless it is a file, such as
+	// the binary of "pprof api cpu.pprof".
+	if _, ok := subcommands[args[0]]; ok && !isFile(args[0]) {
+		if err := configFlagSetter(); err != nil {
+			return nil, nil, err
+		}
+		setCurrentConfig(cfg)
+		return &source{Sources: args[1:], Subcommand: args[0]}, nil, nil
+	}
 
 	var execName string
 	// Recognize first argument as an executable or buildid override.
@@ -121,10 +226,43 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 		return nil, nil, errors.New("-http is not compatible with an output format on the command line")
 	}
 
+	if *flagStaticHTML != "" && (cmd != nil || *flagHTTP != "") {
+		return nil, nil, errors.New("-static_html is not compatible with -http or an output format on the command line")
+	}
+
 	if *flagNoBrowser && *flagHTTP == "" {
 		return nil, nil, errors.New("-no_browser only makes sense with -http")
 	}
 
+	if *flagCacheDir != "" && cmd == nil {
+		return nil, nil, errors.New("-cache_dir only makes sense with an output format on the command line")
+	}
+
+	if *flagTabs && *flagHTTP == "" {
+		return nil, nil, errors.New("-tabs only makes sense with -http")
+	}
+
+	if (*flagHTTPUser != "" || *flagHTTPPasswordFile != "") && *flagHTTP == "" {
+		return nil, nil, errors.New("-http_user and -http_password_file only make sense with -http")
+	}
+	if (*flagHTTPUser == "") != (*flagHTTPPasswordFile == "") {
+		return nil, nil, errors.New("-http_user and -http_password_file must be used together")
+	}
+
+	if (*flagTLSCert != "" || *flagTLSKey != "" || *flagTLSSelfSigned) && *flagHTTP == "" {
+		return nil, nil, errors.New("-http_cert, -http_key and -http_self_signed only make sense with -http")
+	}
+	if (*flagTLSCert == "") != (*flagTLSKey == "") {
+		return nil, nil, errors.New("-http_cert and -http_key must be used together")
+	}
+	if *flagTLSSelfSigned && *flagTLSCert != "" {
+		return nil, nil, errors.New("-http_self_signed and -http_cert are mutually exclusive")
+	}
+
+	if *flagSSHTunnel != "" && *flagHTTP == "" {
+		return nil, nil, errors.New("-ssh_tunnel only makes sense with -http")
+	}
+
 	si := cfg.SampleIndex
 	si = sampleIndex(flagTotalDelay, si, "delay", "-total_delay", o.UI)
 	si = sampleIndex(flagMeanDelay, si, "delay", "-mean_delay", o.UI)
@@ -146,14 +284,52 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 		Seconds:            *flagSeconds,
 		Timeout:            *flagTimeout,
 		Symbolize:          *flagSymbolize,
+		Resume:             *flagResume,
 		HTTPHostport:       *flagHTTP,
 		HTTPDisableBrowser: *flagNoBrowser,
+		HTTPTabs:           *flagTabs,
+		HTTPUser:           *flagHTTPUser,
+		HTTPPasswordFile:   *flagHTTPPasswordFile,
+		TLSCert:            *flagTLSCert,
+		TLSKey:             *flagTLSKey,
+		TLSSelfSigned:      *flagTLSSelfSigned,
+		SSHTunnel:          *flagSSHTunnel,
+		CacheDir:           *flagCacheDir,
+		StaticHTML:         *flagStaticHTML,
 		Comment:            *flagAddComment,
+		LabelNames:         *flagLabelNames,
+		PhaseMap:           *flagPhaseMap,
+		ProcessTree:        *flagProcessTree,
+		Series:             *flagSeries,
+		Strict:             *flagStrict,
+		FetchEnv:           *flagFetchEnv,
+		RecordTools:        *flagRecordTools,
+		RequireTools:       *flagRequireTools,
+		SelfProfile:        *flagSelfProfile,
+	}
+
+	if *flagDownloadRate < 0 {
+		return nil, nil, fmt.Errorf("-download_rate must not be negative, got %d", *flagDownloadRate)
+	}
+	source.DownloadRate = *flagDownloadRate
+
+	switch f := *flagSampleFraction; {
+	case f <= 0 || f > 1:
+		return nil, nil, fmt.Errorf("-sample_fraction must be in (0, 1], got %g", f)
+	case f < 1:
+		source.SampleFraction = f
 	}
 
 	if err := source.addBaseProfiles(*flagBase, *flagDiffBase); err != nil {
 		return nil, nil, err
 	}
+	if *flagDeltaFrom != "" {
+		if len(source.Base) > 0 {
+			return nil, nil, errors.New("-delta_from is not compatible with -base or -diff_base")
+		}
+		source.Base = []string{*flagDeltaFrom}
+		source.DeltaFrom = true
+	}
 
 	normalize := cfg.Normalize
 	if normalize && len(source.Base) == 0 {
@@ -161,6 +337,23 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	}
 	source.Normalize = normalize
 
+	if *flagDiffAutoOrient {
+		if len(source.Base) == 0 || source.DeltaFrom {
+			return nil, nil, errors.New("-diff_auto_orient only makes sense with -base or -diff_base")
+		}
+		source.DiffAutoOrient = true
+	}
+
+	if *flagSampleTypeMap != "" {
+		if len(source.Base) == 0 {
+			return nil, nil, errors.New("-sample_type_map only makes sense with -base or -diff_base")
+		}
+		if _, err := parseSampleTypeMap(*flagSampleTypeMap); err != nil {
+			return nil, nil, err
+		}
+		source.SampleTypeMap = *flagSampleTypeMap
+	}
+
 	if bu, ok := o.Obj.(*binutils.Binutils); ok {
 		bu.SetTools(*flagTools)
 	}
@@ -265,6 +458,12 @@ func installConfigFlags(flag plugin.FlagSet, cfg *config) func() error {
 	}
 }
 
+// isFile reports whether a file named name exists.
+func isFile(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 // isBuildID determines if the profile may contain a build ID, by
 // checking that it is a string of hex digits.
 func isBuildID(id string) bool {
@@ -318,6 +517,35 @@ various views of a profile.
 
    pprof -http [host]:[port] [options] [binary] <source> ...
 
+Provide the "-static_html" flag instead to write these views as static
+HTML pages, to be hosted on any file server.
+
+   pprof -static_html <dir or file.zip> [options] [binary] <source> ...
+
+Use the synth command to generate a synthetic profile from a specification
+of its stacks, values, labels and mappings, eg as a test fixture. Run it
+without a specification for details.
+
+   pprof synth [-output=file] <spec>
+
+Use the bench command to compare, function by function, the profiles
+written by "go test -bench -cpuprofile" for the same benchmarks to two
+directories.
+
+   pprof bench [options] <old dir> <new dir>
+
+Use the compare command to write a self-contained HTML page comparing two
+profiles, with their totals, the functions that changed the most, the
+differential flame graph and the source of the largest changes.
+
+   pprof compare [-output=file.html] [options] <old profile> <new profile>
+
+Use the api command to serve the fetch, merge, diff and report operations
+of pprof over HTTP, as a backend for other profiling frontends. Run it
+without an address for details.
+
+   pprof api [options] [host]:[port]
+
 Details:
 `
 
@@ -325,11 +553,41 @@ var usageMsgSrc = "\n\n" +
 	"  Source options:\n" +
 	"    -seconds              Duration for time-based profile collection\n" +
 	"    -timeout              Timeout in seconds for profile collection\n" +
+	"                          Downloads fail after receiving no data for as long\n" +
 	"    -buildid              Override build id for main binary\n" +
 	"    -add_comment          Free-form annotation to add to the profile\n" +
 	"                          Displayed on some reports or with pprof -comments\n" +
 	"    -diff_base source     Source of base profile for comparison\n" +
 	"    -base source          Source of base profile for profile subtraction\n" +
+	"    -diff_auto_orient     Swap the source and base profiles if nearly all the\n" +
+	"                          values decreased and the base profiles are not older\n" +
+	"    -delta_from source    Source of an earlier profile of the same counters, to\n" +
+	"                          report those counted since then, for profiles of\n" +
+	"                          counters since the start of the process\n" +
+	"    -sample_type_map      Sample types of base profiles to compare to those of\n" +
+	"                          source profiles, as base=source,..., for profiles with\n" +
+	"                          different sample types, eg space=inuse_space\n" +
+	"    -label_names          Files naming the values of numeric labels, as\n" +
+	"                          key=file,..., with CSV records of values and names\n" +
+	"                          or a JSON object from values to names\n" +
+	"    -phase_map            File of the execution phases of the samples, with\n" +
+	"                          a line per phase giving its name, start and end after\n" +
+	"                          the first timestamp, eg startup 0s 30s\n" +
+	"    -process_tree         Combine parent and child process profiles into a tree\n" +
+	"                          Processes are identified by pid/ppid labels or by\n" +
+	"                          source names such as cpu.pid123.ppid45.pb.gz\n" +
+	"    -series               Label the samples of each profile with the time it was\n" +
+	"                          collected at, to compare a series of profiles over\n" +
+	"                          time with the heatmap report\n" +
+	"    -strict               Fail on profiles with unsupported contents, such as\n" +
+	"                          invalid labels, instead of warning and dropping them\n" +
+	"    -fetch_env            Record the URL, time and pprof version of remote fetches,\n" +
+	"                          and the command line and expvar strings of the target,\n" +
+	"                          in the comments of the profile\n" +
+	"    -sample_fraction      Fraction of the samples of each profile to keep,\n" +
+	"                          scaling their values, to merge profiles too large\n" +
+	"                          to hold, eg 0.1 for a tenth of the samples\n" +
+	"    -download_rate        Limit profile downloads to this many kilobytes per second\n" +
 	"    profile.pb.gz         Profile in compressed protobuf format\n" +
 	"    legacy_profile        Profile in legacy pprof format\n" +
 	"    http://host/profile   URL for profile handler to retrieve\n" +
@@ -339,6 +597,9 @@ var usageMsgSrc = "\n\n" +
 	"      fastlocal             Only get function names from local binaries\n" +
 	"      remote                Do not examine local binaries\n" +
 	"      force                 Force re-symbolization\n" +
+	"      timeout=duration      Interrupt the symbolization after the duration, eg 1m,\n" +
+	"                            saving the partially symbolized profile, as does Ctrl-C\n" +
+	"    -resume               Complete the symbolization of a saved partial profile\n" +
 	"    Binary                  Local path or build id of binary for symbolization\n"
 
 var usageMsgVars = "\n\n" +
@@ -347,7 +608,28 @@ var usageMsgVars = "\n\n" +
 	"                      Host is optional and 'localhost' by default.\n" +
 	"                      Port is optional and a randomly available port by default.\n" +
 	"   -no_browser        Skip opening a browser for the interactive web UI.\n" +
+	"   -tabs              Serve each profile in its own tab of the web UI,\n" +
+	"                      instead of merging them.\n" +
+	"   -http_user         Require HTTP basic authentication as this user, with\n" +
+	"                      the password on the first line of -http_password_file.\n" +
+	"   -http_cert         Serve the web UI over HTTPS with the certificate of this\n" +
+	"                      PEM file, and the private key of the -http_key file.\n" +
+	"   -http_self_signed  Serve the web UI over HTTPS with a self-signed\n" +
+	"                      certificate generated for this run.\n" +
+	"   -ssh_tunnel        Run pprof with the web UI on user@host over ssh, and\n" +
+	"                      tunnel it to the -http port of the local browser.\n" +
+	"   -static_html       Write the views of the web UI to a directory, or to a\n" +
+	"                      zip file if its name ends in .zip, for file servers.\n" +
+	"   -cache_dir         Directory caching the output of reports, to reuse it\n" +
+	"                      for the same report of the same profile and options.\n" +
 	"   -tools             Search path for object tools\n" +
+	"   -record_tools      Write the versions and hashes of the object tools to a\n" +
+	"                      manifest, and record them in the profile comments.\n" +
+	"   -require_tools     Fail unless the object tools match those of a manifest,\n" +
+	"                      written by -record_tools, for reproducible results.\n" +
+	"   -selfprofile       Write CPU and heap profiles of pprof itself to a directory,\n" +
+	"                      with the samples of its fetch, symbolize and report\n" +
+	"                      phases labeled, until the report or the UI is ready.\n" +
 	"\n" +
 	"  Legacy convenience options:\n" +
 	"   -inuse_space           Same as -sample_index=inuse_space\n" +
@@ -15,15 +15,11 @@
 package driver
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"runtime"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/report"
@@ -82,43 +78,40 @@ var interactiveMode = false
 // pprofCommands are the report generation commands recognized by pprof.
 var pprofCommands = commands{
 	// Commands that require no post-processing.
-	"comments": {report.Comments, nil, nil, false, "Output all profile comments", ""},
-	"disasm":   {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
-	"dot":      {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
-	"list":     {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
-	"peek":     {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
-	"raw":      {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", ""},
-	"tags":     {report.Tags, nil, nil, false, "Outputs all tags in the profile", "tags [tag_regex]* [-ignore_regex]* [>file]\nList tags with key:value matching tag_regex and exclude ignore_regex."},
-	"text":     {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("text", true, true)},
-	"top":      {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("top", true, true)},
-	"traces":   {report.Traces, nil, nil, false, "Outputs all profile samples in text form", ""},
-	"tree":     {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
+	"callhierarchy": {report.CallHierarchy, nil, nil, true, "Outputs callers/callees of functions matching regexp as call hierarchy JSON", "callhierarchy func_regex [>file]\nPrint the callers and callees of functions matching func_regex,\nwith their weights, as JSON in the shape of the call hierarchy\nof the Language Server Protocol, for IDE plugins."},
+	"collisions":    {report.Collisions, nil, nil, false, "Outputs distinct functions that share a name", "collisions\nList function names shared by functions from different\nsource files or binaries. Unless -filefunctions or a finer\ngranularity is used, these are reported separately with\ntheir file or binary name appended."},
+	"comments":      {report.Comments, nil, nil, false, "Output all profile comments", ""},
+	"contenders":    {report.Contenders, nil, nil, false, "Outputs the stacks holding locks along with the stacks they block", "contenders\nPair the stacks holding each lock in a mutex profile with the\nstacks waiting for it, from samples labeled lock_role=holder\nor lock_role=waiter and lock=<lock id>, showing how long the\nwaiters were blocked. The wait of a waiter is split among\nthe holders of the lock in proportion to their values."},
+	"datasources":   {report.DataSources, nil, nil, false, "Outputs the cost of memory accesses by data source", "datasources [>file]\nList the data_source labels of memory samples, such as L1, L2\nor DRAM, by their total and mean value. Use -sample_index=latency\nfor the latency of the accesses."},
+	"diffdisasm":    {report.DiffDis, nil, nil, true, "Output assembly listings of the base and new binaries side by side", "diffdisasm<func_regex> [-focus_regex]* [-ignore_regex]* >f\nAlign the assembly of functions matching func_regex in the\nbinaries of the -diff_base profile with that in the new binaries,\nannotated with the flat values of both profiles and their change.\nOptionally save the report on the file f"},
+	"disasm":        {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
+	"dot":           {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
+	"folded":        {report.Folded, nil, nil, false, "Outputs all stacks in folded format for flame graph tools", ""},
+	"heatmap":       {report.Heatmap, nil, nil, false, "Outputs the share of each profile of a series spent in its top functions", "heatmap [>file]\nList the share of the total of each profile of a series\nspent in the functions costing the most over all of them,\nshaded by share, to spot which function regressed and when.\nUse -series to label the samples of each profile."},
+	"json":          {report.JSON, nil, nil, false, "Outputs the top nodes and the call graph in JSON format", ""},
+	"list":          {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
+	"longcsv":       {report.LongCSV, nil, nil, false, "Outputs a row per frame of each sample as long format CSV", "longcsv [>file]\nPrints a row per frame of each sample, with its values and labels,\nfor pivot tables and data frames."},
+	"mermaid":       {report.Mermaid, nil, nil, false, "Outputs a graph as a Mermaid flowchart", reportHelp("mermaid", false, true)},
+	"phases":        {report.Phases, nil, nil, false, "Outputs the cost of each execution phase and of its top functions", "phases [>file]\nList the values of the phase label of the samples, such as\nstartup or steady, with their total, and the share of each\nphase spent in the functions costing the most, to compare\nthe phases. Use -phase_map to label samples by timestamp."},
+	"peek":          {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
+	"raw":           {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", ""},
+	"tags":          {report.Tags, nil, nil, false, "Outputs all tags in the profile", "tags [tag_regex]* [-ignore_regex]* [>file]\nList tags with key:value matching tag_regex and exclude ignore_regex."},
+	"text":          {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("text", true, true)},
+	"top":           {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("top", true, true)},
+	"traceids":      {report.TraceIDs, nil, nil, false, "Outputs the cost of each distributed trace", "traceids [>file]\nList the trace_id labels of the samples by their total value."},
+	"traces":        {report.Traces, nil, nil, false, "Outputs all profile samples in text form", ""},
+	"tree":          {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
+	"unsampled":     {report.Unsampled, nil, nil, true, "Outputs the functions of the binaries without samples", "unsampled<func_regex> [>f]\nList the functions matching func_regex in the symbol tables of the\nbinaries of the profile that appear in no sample, largest first,\nskipping those smaller than min_size bytes. Use . to match all the\nfunctions. Optionally save the report on the file f"},
 
 	// Save binary formats to a file
-	"callgrind": {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
-	"proto":     {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
-	"topproto":  {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
-
-	// Generate report in DOT format and postprocess with dot
-	"gif": {report.Dot, invokeDot("gif"), awayFromTTY("gif"), false, "Outputs a graph image in GIF format", reportHelp("gif", false, true)},
-	"pdf": {report.Dot, invokeDot("pdf"), awayFromTTY("pdf"), false, "Outputs a graph in PDF format", reportHelp("pdf", false, true)},
-	"png": {report.Dot, invokeDot("png"), awayFromTTY("png"), false, "Outputs a graph image in PNG format", reportHelp("png", false, true)},
-	"ps":  {report.Dot, invokeDot("ps"), awayFromTTY("ps"), false, "Outputs a graph in PS format", reportHelp("ps", false, true)},
-
-	// Save SVG output into a file
-	"svg": {report.Dot, massageDotSVG(), awayFromTTY("svg"), false, "Outputs a graph in SVG format", reportHelp("svg", false, true)},
-
-	// Visualize postprocessed dot output
-	"eog":    {report.Dot, invokeDot("svg"), invokeVisualizer("svg", []string{"eog"}), false, "Visualize graph through eog", reportHelp("eog", false, false)},
-	"evince": {report.Dot, invokeDot("pdf"), invokeVisualizer("pdf", []string{"evince"}), false, "Visualize graph through evince", reportHelp("evince", false, false)},
-	"gv":     {report.Dot, invokeDot("ps"), invokeVisualizer("ps", []string{"gv --noantialias"}), false, "Visualize graph through gv", reportHelp("gv", false, false)},
-	"web":    {report.Dot, massageDotSVG(), invokeVisualizer("svg", browsers()), false, "Visualize graph through web browser", reportHelp("web", false, false)},
-
-	// Visualize callgrind output
-	"kcachegrind": {report.Callgrind, nil, invokeVisualizer("grind", kcachegrind), false, "Visualize report in KCachegrind", reportHelp("kcachegrind", false, false)},
-
-	// Visualize HTML directly generated by report.
-	"weblist": {report.WebList, nil, invokeVisualizer("html", browsers()), true, "Display annotated source in a web browser", listHelp("weblist", false)},
+	"callgrind":   {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
+	"chrometrace": {report.ChromeTrace, nil, awayFromTTY("trace.json"), false, "Outputs all samples as Chrome trace events", ""},
+	"otlp":        {report.OTLP, nil, awayFromTTY("otlp.pb"), false, "Outputs the profile as an OpenTelemetry OTLP profiles request", ""},
+	"parquet":     {report.Parquet, nil, awayFromTTY("parquet"), false, "Outputs a row per frame of each sample in a Parquet file", ""},
+	"proto":       {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
+	"speedscope":  {report.Speedscope, nil, awayFromTTY("speedscope.json"), false, "Outputs all stacks in speedscope JSON format", ""},
+	"sqlite":      {report.SQLite, nil, awayFromTTY("db"), false, "Outputs the samples, locations, functions and labels in a SQLite database", ""},
+	"topproto":    {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
 }
 
 // configHelp contains help text per configuration parameter.
@@ -141,6 +134,17 @@ var configHelp = map[string]string{
 		"Show percentages relative to focused subgraph",
 		"If unset, percentages are relative to full graph before focusing",
 		"to facilitate comparison with original graph."),
+	"percent_base": helpText(
+		"What percentages are relative to: total, focused or node",
+		"total is the value of all the samples before filtering, focused the",
+		"value of the samples left by the filters, as relative_percentages,",
+		"and node the cum value of the nodes matching percent_node, eg",
+		"percent_base=node percent_node=ServeHTTP, to compare views of the",
+		"same node."),
+	"percent_node": helpText(
+		"Regexp of the nodes whose cum value percent_base=node is relative to",
+		"Matched like focus, against the functions, files and addresses of the",
+		"locations of the samples left by the filters."),
 	"unit": helpText(
 		"Measurement units to display",
 		"Scale the sample values to this unit.",
@@ -148,11 +152,68 @@ var configHelp = map[string]string{
 		"For memory profiles, use megabytes, kilobytes, bytes, etc.",
 		"Using auto will scale each value independently to the most natural unit."),
 	"compact_labels": "Show minimal headers",
-	"source_path":    "Search path for source files",
-	"trim_path":      "Path to trim from source paths before search",
+	"graph_title":    "Title drawn above graph images",
+	"annotations": helpText(
+		"Text boxes drawn next to the legend of graph images",
+		"Separate the text of the boxes with ';', and their lines with \\n,",
+		"eg annotations='Load test at 1k QPS;Cache disabled\\nsee issue 123'."),
+	"dot_metadata": helpText(
+		"Add raw values and profile IDs as attributes of dot graphs",
+		"Nodes get pprof_flat, pprof_cum, pprof_name, pprof_file, pprof_objfile,",
+		"pprof_function_ids and pprof_build_ids attributes, edges pprof_weight,",
+		"and the graph pprof_total, pprof_sample_type and pprof_sample_unit, for",
+		"tools post-processing the -dot output."),
+	"max_name_len": helpText(
+		"Truncate names longer than this many characters",
+		"Names are shortened in the middle, keeping their start and end.",
+		"0 means no limit, except for text reports on a terminal, which are",
+		"fit to the terminal width given by $COLUMNS."),
+	"table_format": helpText(
+		"Print text, traces and tags reports as csv or tsv",
+		"Prints a row per entry, with a header row naming the columns,",
+		"and the values as plain numbers in the unit of the unit column."),
+	"fair_share": helpText(
+		"Divide the samples among the values of their labels in tags reports",
+		"A sample with several values of a label counts for an equal share",
+		"of its value in each, so that the values of a label add up to the",
+		"total of the samples with the label instead of over-counting them."),
+	"metadata": helpText(
+		"File of metadata of the functions, shown next to their cost",
+		"A CSV file whose header row names the keys, such as team or",
+		"critical, after the first column holding function or package names,",
+		"or a JSON object mapping the names to objects of the values. Text",
+		"and web reports show the metadata of the functions as badges."),
+	"metadata_group": helpText(
+		"Group the functions of text reports by this metadata key",
+		"Lists a row per value of the key, such as a row per team, with the",
+		"flat value of the samples whose leaf function has the value, and the",
+		"cumulative value of those with any function having it."),
+	"compact": helpText(
+		"Encode proto output to reduce its size",
+		"Merges the identical samples and numbers the most referenced",
+		"locations, functions and strings first, without changing the",
+		"profile written."),
+	"zstd": helpText(
+		"Compress proto output with zstd instead of gzip",
+		"The profile written is smaller and faster to decode, and is read",
+		"by pprof and by tools supporting zstd-compressed profiles. The",
+		"profile is compressed by the zstd tool, which must be installed."),
+	"source_path": "Search path for source files",
+	"trim_path":   "Path to trim from source paths before search",
 	"intel_syntax": helpText(
 		"Show assembly in Intel syntax",
-		"Only applicable to commands `disasm` and `weblist`"),
+		"Only applicable to commands `disasm`, `diffdisasm` and `weblist`"),
+	"with_source": helpText(
+		"Show the source lines above their instructions in disasm reports",
+		"Reads the source files from source_path, and shows the file and",
+		"line of the instructions whose source cannot be found."),
+	"only_hot": helpText(
+		"Hide the basic blocks below <f>*total in disasm reports",
+		"Shows only the blocks of instructions between jumps whose flat",
+		"value is at least this fraction of the total. 0 shows all of them."),
+	"min_size": helpText(
+		"Skip functions smaller than this many bytes in unsampled reports",
+		"0 lists all the functions without samples."),
 
 	// Filtering options
 	"nodecount": helpText(
@@ -199,6 +260,15 @@ var configHelp = map[string]string{
 		"Use name=value syntax to limit the matching to a specific tag.",
 		"Numeric tag filter examples: 1kb, 1kb:10kb, memory=32mb:",
 		"String tag filter examples: foo, foo.*bar, mytag=foo.*bar"),
+	"trace": helpText(
+		"Restricts to samples of the distributed traces or spans listed",
+		"Takes a comma-separated list of IDs, matched against the",
+		"trace_id and span_id labels of the samples."),
+	"time_window": helpText(
+		"Restricts to samples recorded in a time window",
+		"Takes start:end, the times in nanoseconds of the timestamp",
+		"labels of the first and after the last sample to keep, as",
+		"selected in the timeline of the web interface."),
 	"tagshow": helpText(
 		"Only consider tags matching this regexp",
 		"Discard tags that do not match this regexp"),
@@ -327,33 +397,6 @@ func listHelp(c string, redirect bool) string {
 	return strings.Join(h, "\n")
 }
 
-// browsers returns a list of commands to attempt for web visualization.
-func browsers() []string {
-	var cmds []string
-	if userBrowser := os.Getenv("BROWSER"); userBrowser != "" {
-		cmds = append(cmds, userBrowser)
-	}
-	switch runtime.GOOS {
-	case "darwin":
-		cmds = append(cmds, "/usr/bin/open")
-	case "windows":
-		cmds = append(cmds, "cmd /c start")
-	default:
-		// Commands opening browsers are prioritized over xdg-open, so browser()
-		// command can be used on linux to open the .svg file generated by the -web
-		// command (the .svg file includes embedded javascript so is best viewed in
-		// a browser).
-		cmds = append(cmds, []string{"chrome", "google-chrome", "chromium", "firefox", "sensible-browser"}...)
-		if os.Getenv("DISPLAY") != "" {
-			// xdg-open is only for use in a desktop environment.
-			cmds = append(cmds, "xdg-open")
-		}
-	}
-	return cmds
-}
-
-var kcachegrind = []string{"kcachegrind"}
-
 // awayFromTTY saves the output in a file if it would otherwise go to
 // the terminal screen. This is used to avoid dumping binary data on
 // the screen.
@@ -372,71 +415,6 @@ func awayFromTTY(format string) PostProcessor {
 	}
 }
 
-func invokeDot(format string) PostProcessor {
-	return func(input io.Reader, output io.Writer, ui plugin.UI) error {
-		cmd := exec.Command("dot", "-T"+format)
-		cmd.Stdin, cmd.Stdout, cmd.Stderr = input, output, os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to execute dot. Is Graphviz installed? Error: %v", err)
-		}
-		return nil
-	}
-}
-
-// massageDotSVG invokes the dot tool to generate an SVG image and alters
-// the image to have panning capabilities when viewed in a browser.
-func massageDotSVG() PostProcessor {
-	generateSVG := invokeDot("svg")
-	return func(input io.Reader, output io.Writer, ui plugin.UI) error {
-		baseSVG := new(bytes.Buffer)
-		if err := generateSVG(input, baseSVG, ui); err != nil {
-			return err
-		}
-		_, err := output.Write([]byte(massageSVG(baseSVG.String())))
-		return err
-	}
-}
-
-func invokeVisualizer(suffix string, visualizers []string) PostProcessor {
-	return func(input io.Reader, output io.Writer, ui plugin.UI) error {
-		tempFile, err := newTempFile(os.TempDir(), "pprof", "."+suffix)
-		if err != nil {
-			return err
-		}
-		deferDeleteTempFile(tempFile.Name())
-		if _, err := io.Copy(tempFile, input); err != nil {
-			return err
-		}
-		tempFile.Close()
-		// Try visualizers until one is successful
-		for _, v := range visualizers {
-			// Separate command and arguments for exec.Command.
-			args := strings.Split(v, " ")
-			if len(args) == 0 {
-				continue
-			}
-			viewer := exec.Command(args[0], append(args[1:], tempFile.Name())...)
-			viewer.Stderr = os.Stderr
-			if err = viewer.Start(); err == nil {
-				// Wait for a second so that the visualizer has a chance to
-				// open the input file. This needs to be done even if we're
-				// waiting for the visualizer as it can be just a wrapper that
-				// spawns a browser tab and returns right away.
-				defer func(t <-chan time.Time) {
-					<-t
-				}(time.After(time.Second))
-				// On interactive mode, let the visualizer run in the background
-				// so other commands can be issued.
-				if !interactiveMode {
-					return viewer.Wait()
-				}
-				return nil
-			}
-		}
-		return err
-	}
-}
-
 // stringToBool is a custom parser for bools. We avoid using strconv.ParseBool
 // to remain compatible with old pprof behavior (e.g., treating "" as true).
 func stringToBool(s string) (bool, error) {
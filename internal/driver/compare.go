@@ -0,0 +1,283 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// compareUsage describes pprof compare.
+const compareUsage = `
+  pprof compare [options] <old profile> <new profile>
+
+  Writes a self-contained HTML page comparing the new profile with the
+  old one, to attach to reports of performance regressions: the totals
+  of both profiles, the functions whose values changed the most, the
+  differential flame graph, and the annotated source of the functions
+  that changed the most. Use -output to write the page to a file,
+  -nodecount to set the number of functions listed (20 by default), and
+  -sample_index to select the sample value to compare.
+`
+
+const (
+	// defaultCompareFunctions is the number of functions listed unless
+	// -nodecount is set.
+	defaultCompareFunctions = 20
+
+	// compareSources is the number of functions whose annotated source
+	// is included.
+	compareSources = 3
+)
+
+// compareArgs holds the data of the page written by pprof compare.
+type compareArgs struct {
+	Title      string
+	Legend     []string
+	Totals     compareRow
+	Functions  []compareRow
+	FlameGraph template.JS
+	Sources    []compareSource
+
+	// Preferences are the default view preferences, as the theme of the
+	// shared templates: the report is not served by the web UI.
+	Preferences preferences
+}
+
+// compareRow holds the old and new values of a function, or of the
+// total, of a comparison.
+type compareRow struct {
+	Name                               string
+	OldFlat, NewFlat, DeltaFlat        string
+	OldCum, NewCum, DeltaCum, Relative string
+	Regression                         bool
+
+	function        string // Name of the function, without its inline label.
+	delta, cumDelta int64
+}
+
+// compareSource holds the annotated source of a function of a comparison.
+type compareSource struct {
+	Name, Text string
+}
+
+// compareProfiles implements pprof compare, comparing the new profile of
+// args with the old one.
+func compareProfiles(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	if len(args) != 2 {
+		return errors.New("pprof compare takes two profiles" + compareUsage)
+	}
+	p, err := fetchProfiles(ctx, &source{
+		Sources:  []string{args[1]},
+		Base:     []string{args[0]},
+		DiffBase: true,
+		Seconds:  -1,
+		Timeout:  -1,
+	}, o)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := printComparison(ctx, &buf, p, cfg, o); err != nil {
+		return err
+	}
+
+	if cfg.Output == "" {
+		_, err := buf.WriteTo(os.Stdout)
+		return err
+	}
+	o.UI.PrintErr("Generating report in ", cfg.Output)
+	out, err := o.Writer.Open(cfg.Output)
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// printComparison writes the comparison page of the differential
+// profile p, as with -diff_base.
+func printComparison(ctx context.Context, w io.Writer, p *profile.Profile, cfg config, o *plugin.Options) error {
+	n := cfg.NodeCount
+	if n <= 0 {
+		n = defaultCompareFunctions
+	}
+	cfg.Trim = false
+	cfg.NodeCount = 0
+	_, rpt, err := generateRawReport(ctx, p, []string{"top"}, cfg, o)
+	if err != nil {
+		return err
+	}
+	brpt, crpt := report.SplitDiffBase(rpt)
+	if brpt == nil {
+		return errors.New("the old profile has no samples")
+	}
+
+	// Join the functions of the old, new and differential reports.
+	type values struct{ old, new *report.TextItem }
+	byName := make(map[string]*values)
+	for i, r := range []*report.Report{brpt, crpt} {
+		items, _ := report.TextItems(r)
+		for j := range items {
+			it := &items[j]
+			v := byName[it.Name+it.InlineLabel]
+			if v == nil {
+				v = &values{}
+				byName[it.Name+it.InlineLabel] = v
+			}
+			if i == 0 {
+				v.old = it
+			} else {
+				v.new = it
+			}
+		}
+	}
+	items, legend := report.TextItems(rpt)
+	var rows []compareRow
+	for _, it := range items {
+		v := byName[it.Name+it.InlineLabel]
+		if v == nil || it.Flat == 0 && it.Cum == 0 {
+			continue
+		}
+		row := compareRow{
+			Name:       it.Name + it.InlineLabel,
+			OldFlat:    ".",
+			NewFlat:    ".",
+			OldCum:     ".",
+			NewCum:     ".",
+			DeltaFlat:  formatChange(it.Flat, it.FlatFormat),
+			DeltaCum:   formatChange(it.Cum, it.CumFormat),
+			Regression: it.Flat > 0 || it.Flat == 0 && it.Cum > 0,
+			function:   it.Name,
+			delta:      it.Flat,
+			cumDelta:   it.Cum,
+		}
+		if v.old != nil {
+			row.OldFlat, row.OldCum = v.old.FlatFormat, v.old.CumFormat
+		}
+		if v.new != nil {
+			row.NewFlat, row.NewCum = v.new.FlatFormat, v.new.CumFormat
+		}
+		rows = append(rows, row)
+	}
+	// The functions whose flat values changed the most come first, then
+	// those whose cumulative values did.
+	sort.SliceStable(rows, func(i, j int) bool {
+		if di, dj := abs64(rows[i].delta), abs64(rows[j].delta); di != dj {
+			return di > dj
+		}
+		return abs64(rows[i].cumDelta) > abs64(rows[j].cumDelta)
+	})
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+
+	_, dot := report.GetDOT(rpt)
+	oldTotal, newTotal := brpt.Total(), crpt.Total()
+	totals := compareRow{
+		Name:       "Total",
+		OldCum:     dot.FormatValue(oldTotal),
+		NewCum:     dot.FormatValue(newTotal),
+		DeltaCum:   formatChange(newTotal-oldTotal, dot.FormatValue(newTotal-oldTotal)),
+		Relative:   benchDelta(float64(oldTotal), float64(newTotal)),
+		Regression: newTotal > oldTotal,
+	}
+
+	// The differential flame graph.
+	fcfg := cfg
+	fcfg.CallTree = true
+	_, frpt, err := generateRawReport(ctx, p, []string{"svg"}, fcfg, o)
+	if err != nil {
+		return err
+	}
+	root, _, _ := flameGraphTree(frpt)
+	b, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+
+	// The annotated source of the functions that changed the most. Their
+	// lines show the change of their values.
+	var sources []compareSource
+	listed := make(map[string]bool)
+	for _, row := range rows {
+		if len(sources) == compareSources {
+			break
+		}
+		if row.delta == 0 || listed[row.function] {
+			continue
+		}
+		listed[row.function] = true
+		src := compareSource{Name: row.Name}
+		var text bytes.Buffer
+		_, lrpt, err := generateRawReport(ctx, p, []string{"list", "^" + regexp.QuoteMeta(row.function) + "$"}, cfg, o)
+		if err == nil {
+			err = report.Generate(&text, lrpt, o.Obj)
+		}
+		if err != nil {
+			src.Text = fmt.Sprintf("Source not available: %v", err)
+		} else {
+			src.Text = text.String()
+		}
+		sources = append(sources, src)
+	}
+
+	templates := template.New("templategroup")
+	addTemplates(templates)
+	return templates.ExecuteTemplate(w, "compare", compareArgs{
+		Title:      getFromLegend(legend, "File: ", "unknown") + " " + getFromLegend(legend, "Type: ", "unknown"),
+		Legend:     legend,
+		Totals:     totals,
+		Functions:  rows,
+		FlameGraph: template.JS(b),
+		Sources:    sources,
+	})
+}
+
+// formatChange returns the formatted change of a value, with its sign.
+func formatChange(delta int64, format string) string {
+	switch {
+	case delta == 0:
+		return "."
+	case delta > 0:
+		return "+" + format
+	}
+	return format
+}
+
+func abs64(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestCompareProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProfile := func(name, spec string) string {
+		t.Helper()
+		p, err := parseSynthSpec(name, []byte("sample_type cpu nanoseconds\nmapping /bin/app\n"+spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	oldFile := writeProfile("old.pb.gz", "stack main;work;alloc 30\nstack main;work 60\nstack main;gc 10\n")
+	newFile := writeProfile("new.pb.gz", "stack main;work;alloc 60\nstack main;work 60\nstack main;gc 5\n")
+
+	cfg := defaultConfig()
+	cfg.Output = filepath.Join(dir, "report.html")
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t, AllowRx: "Generating report in|Source not available"},
+		HTTPTransport: &httpTransport{},
+	})
+	if err := compareProfiles(context.Background(), []string{oldFile}, cfg, o); err == nil {
+		t.Error("compareProfiles: got no error for a single profile")
+	}
+	if err := compareProfiles(context.Background(), []string{oldFile, newFile}, cfg, o); err != nil {
+		t.Fatalf("compareProfiles: %v", err)
+	}
+	got, err := ioutil.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		// The totals and their change.
+		`<td class="name">Total</td><td>100ns</td><td>125ns</td><td>&#43;25ns</td><td>&#43;25.00%</td>`,
+		// The functions, largest change first.
+		`(?s)<td>30ns</td><td>60ns</td><td>&#43;30ns</td>.*<td class="name">alloc</td>.*<td class="name">gc</td>.*<td class="name">work</td>.*<td class="name">main</td>`,
+		`<tr class="improvement">\s*<td>10ns</td><td>5ns</td><td>-5ns</td>`,
+		// The differential flame graph, with the change of each frame.
+		`"n":"alloc","f":"alloc","v":60,"l":"60ns","p":"[0-9.]+%","c":null,"d":30,"dl":"\+30ns"`,
+		`var flamegraph = function`,
+		`<h3>alloc</h3>`,
+	} {
+		if !regexp.MustCompile(want).Match(got) {
+			t.Errorf("report does not match %q:\n%s", want, got)
+		}
+	}
+}
@@ -19,11 +19,26 @@ type config struct {
 	// Display options.
 	CallTree            bool    `json:"call_tree,omitempty"`
 	RelativePercentages bool    `json:"relative_percentages,omitempty"`
+	PercentBase         string  `json:"percent_base,omitempty"`
+	PercentNode         string  `json:"percent_node,omitempty"`
 	Unit                string  `json:"unit,omitempty"`
 	CompactLabels       bool    `json:"compact_labels,omitempty"`
+	GraphTitle          string  `json:"graph_title,omitempty"`
+	Annotations         string  `json:"annotations,omitempty"`
+	DotMetadata         bool    `json:"dot_metadata,omitempty"`
+	MaxNameLen          int     `json:"max_name_len,omitempty"`
+	TableFormat         string  `json:"table_format,omitempty"`
+	FairShare           bool    `json:"fair_share,omitempty"`
+	Metadata            string  `json:"-"`
+	MetadataGroup       string  `json:"metadata_group,omitempty"`
+	Compact             bool    `json:"compact,omitempty"`
+	Zstd                bool    `json:"zstd,omitempty"`
 	SourcePath          string  `json:"-"`
 	TrimPath            string  `json:"-"`
 	IntelSyntax         bool    `json:"intel_syntax,omitempty"`
+	WithSource          bool    `json:"with_source,omitempty"`
+	OnlyHot             float64 `json:"only_hot,omitempty"`
+	MinSize             int     `json:"min_size,omitempty"`
 	Mean                bool    `json:"mean,omitempty"`
 	SampleIndex         string  `json:"-"`
 	DivideBy            float64 `json:"-"`
@@ -46,6 +61,8 @@ type config struct {
 	TagIgnore    string  `json:"tagignore,omitempty"`
 	TagShow      string  `json:"tagshow,omitempty"`
 	TagHide      string  `json:"taghide,omitempty"`
+	Trace        string  `json:"trace,omitempty"`
+	TimeWindow   string  `json:"time_window,omitempty"`
 	NoInlines    bool    `json:"noinlines,omitempty"`
 
 	// Output granularity
@@ -57,6 +74,7 @@ type config struct {
 func defaultConfig() config {
 	return config{
 		Unit:         "minimum",
+		PercentBase:  "total",
 		NodeCount:    -1,
 		NodeFraction: 0.005,
 		EdgeFraction: 0.001,
@@ -113,6 +131,7 @@ func init() {
 		"Output":     "output",
 		"SourcePath": "source_path",
 		"TrimPath":   "trim_path",
+		"Metadata":   "metadata",
 		"DivideBy":   "divide_by",
 	}
 
@@ -130,9 +149,16 @@ func init() {
 		"drop_negative":        "dropneg",
 		"call_tree":            "calltree",
 		"relative_percentages": "rel",
+		"percent_base":         "pb",
+		"percent_node":         "pn",
 		"unit":                 "unit",
 		"compact_labels":       "compact",
+		"max_name_len":         "maxname",
+		"fair_share":           "fairshare",
+		"metadata_group":       "mgroup",
 		"intel_syntax":         "intel",
+		"with_source":          "withsrc",
+		"only_hot":             "onlyhot",
 		"nodecount":            "n",
 		"nodefraction":         "nf",
 		"edgefraction":         "ef",
@@ -147,6 +173,8 @@ func init() {
 		"tagignore":            "ti",
 		"tagshow":              "ts",
 		"taghide":              "th",
+		"trace":                "trace",
+		"time_window":          "tw",
 		"mean":                 "mean",
 		"sample_index":         "si",
 		"normalize":            "norm",
@@ -291,17 +319,23 @@ func completeConfig(prefix string) []string {
 func configure(name, value string) error {
 	currentMu.Lock()
 	defer currentMu.Unlock()
+	return currentCfg.configure(name, value)
+}
+
+// configure stores the name=value mapping into *cfg, as configure does
+// for the current config.
+func (cfg *config) configure(name, value string) error {
 	f, ok := configFieldMap[name]
 	if !ok {
 		return fmt.Errorf("unknown config field %q", name)
 	}
 	if f.name == name {
-		return currentCfg.set(f, value)
+		return cfg.set(f, value)
 	}
 	// name must be one of the choices. If value is true, set field-value
 	// to name.
 	if v, err := strconv.ParseBool(value); v && err == nil {
-		return currentCfg.set(f, name)
+		return cfg.set(f, name)
 	}
 	return fmt.Errorf("unknown config field %q", name)
 }
@@ -317,8 +351,14 @@ func (cfg *config) resetTransient() {
 	cfg.SampleIndex = current.SampleIndex
 }
 
-// applyURL updates *cfg based on params.
+// applyURL updates *cfg based on params. The options of a view token
+// are set first, and those of other parameters override them.
 func (cfg *config) applyURL(params url.Values) error {
+	if token := params.Get(viewTokenParam); token != "" {
+		if err := cfg.applyViewToken(token); err != nil {
+			return err
+		}
+	}
 	for _, f := range configFields {
 		var value string
 		if f.urlparam != "" {
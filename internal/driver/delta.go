@@ -0,0 +1,80 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// cumulativeComment is the comment of the profiles whose values are
+// counters since the start of the process, such as the mutex and block
+// profiles of Go programs fetched without -seconds.
+const cumulativeComment = "pprof::cumulative"
+
+// isCumulative reports whether the comments of p mark its values as
+// counters since the start of the process.
+func isCumulative(p *profile.Profile) bool {
+	for _, c := range p.Comments {
+		if c == cumulativeComment {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDeltaFrom returns an error unless the profile earlier, given with
+// -delta_from, was collected before p.
+func checkDeltaFrom(p, earlier *profile.Profile) error {
+	if p.TimeNanos != 0 && earlier.TimeNanos > p.TimeNanos {
+		return fmt.Errorf("-delta_from profile collected at %v, after the profile at %v",
+			time.Unix(0, earlier.TimeNanos).UTC(), time.Unix(0, p.TimeNanos).UTC())
+	}
+	return nil
+}
+
+// setDeltaInterval sets the time and duration of the profile p, from
+// which the counters of the profile collected at start were subtracted,
+// to the interval between start and the collection of p at end. It warns
+// if some counters decreased, as after a restart of the process.
+func setDeltaInterval(p *profile.Profile, start, end int64, ui plugin.UI) {
+	if start != 0 && end > start {
+		p.TimeNanos, p.DurationNanos = start, end-start
+	}
+	comments := p.Comments[:0]
+	for _, c := range p.Comments {
+		if c != cumulativeComment {
+			comments = append(comments, c)
+		}
+	}
+	if start != 0 {
+		comments = append(comments, "Delta since "+time.Unix(0, start).UTC().Format(time.RFC3339))
+	} else {
+		comments = append(comments, "Delta since the -delta_from profile")
+	}
+	p.Comments = comments
+
+	for _, s := range p.Sample {
+		for _, v := range s.Value {
+			if v < 0 {
+				ui.PrintErr("Some counters decreased since the -delta_from profile; was the process restarted?")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestDeltaFrom(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	dir, err := ioutil.TempDir("", "delta_from")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	// counters returns a cumulative profile of contentions collected at
+	// start+d, with the counts of the functions F1 and F2.
+	counters := func(name string, d time.Duration, f1, f2 int64) string {
+		m := &profile.Mapping{ID: 1, HasFunctions: true}
+		var fns []*profile.Function
+		var locs []*profile.Location
+		var samples []*profile.Sample
+		for i, v := range []int64{f1, f2} {
+			fn := &profile.Function{ID: uint64(i + 1), Name: []string{"F1", "F2"}[i]}
+			loc := &profile.Location{ID: uint64(i + 1), Mapping: m, Line: []profile.Line{{Function: fn}}}
+			fns, locs = append(fns, fn), append(locs, loc)
+			samples = append(samples, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{v}})
+		}
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "contentions", Unit: "count"}},
+			Sample:     samples,
+			Location:   locs,
+			Function:   fns,
+			Mapping:    []*profile.Mapping{m},
+			TimeNanos:  start.Add(d).UnixNano(),
+			Comments:   []string{cumulativeComment},
+		}
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	earlier := counters("earlier.pb.gz", 0, 100, 10)
+	later := counters("later.pb.gz", time.Minute, 150, 40)
+	restarted := counters("restarted.pb.gz", 2*time.Minute, 5, 50)
+
+	for _, tc := range []struct {
+		desc, source, deltaFrom string
+		want                    map[string]int64
+		wantErr, allowRx        string
+	}{
+		{
+			desc:      "delta",
+			source:    later,
+			deltaFrom: earlier,
+			want:      map[string]int64{"F1": 50, "F2": 30},
+		},
+		{
+			desc:    "cumulative",
+			source:  later,
+			want:    map[string]int64{"F1": 150, "F2": 40},
+			allowRx: "count since the start of the process",
+		},
+		{
+			desc:      "restart",
+			source:    restarted,
+			deltaFrom: later,
+			want:      map[string]int64{"F1": -145, "F2": 10},
+			allowRx:   "Some counters decreased",
+		},
+		{
+			desc:      "later delta_from",
+			source:    earlier,
+			deltaFrom: later,
+			wantErr:   "after the profile",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			setCurrentConfig(baseConfig)
+			f := testFlags{strings: map[string]string{"delta_from": tc.deltaFrom}, args: []string{tc.source}}
+			ui := &proftest.TestUI{T: t, AllowRx: tc.allowRx}
+			o := setDefaults(&plugin.Options{UI: ui, Flagset: f, HTTPTransport: &httpTransport{}})
+			src, _, err := parseFlags(o)
+			if err != nil {
+				t.Fatalf("parseFlags: %v", err)
+			}
+			p, err := fetchProfiles(context.Background(), src, o)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchProfiles: %v", err)
+			}
+			if tc.allowRx != "" && ui.NumAllowRxMatches != 1 {
+				t.Errorf("got %d warnings matching %q, want 1", ui.NumAllowRxMatches, tc.allowRx)
+			}
+
+			got := make(map[string]int64)
+			for _, s := range p.Sample {
+				got[s.Location[0].Line[0].Function.Name] += s.Value[0]
+			}
+			for name, v := range tc.want {
+				if got[name] != v {
+					t.Errorf("%s: got %d, want %d", name, got[name], v)
+				}
+			}
+			if tc.deltaFrom == "" {
+				return
+			}
+			if p.DurationNanos != int64(time.Minute) {
+				t.Errorf("got duration %v, want 1m", time.Duration(p.DurationNanos))
+			}
+			if isCumulative(p) {
+				t.Errorf("delta profile is marked cumulative: %q", p.Comments)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dotLimits bounds the resources a dot process may use before it is
+// killed. Large graphs can keep dot busy for minutes or make it use
+// gigabytes of memory, which would hang the command or the web request
+// waiting for it.
+type dotLimits struct {
+	Time    time.Duration // Wall time.
+	CPUTime time.Duration // User and system CPU time, on Linux only.
+	Memory  int64         // Resident memory in bytes, on Linux only.
+}
+
+var (
+	// dotCommand is the graphviz program rendering graphs.
+	dotCommand = "dot"
+	// defaultDotLimits are the limits of the dot processes run by pprof.
+	defaultDotLimits = dotLimits{
+		Time:    30 * time.Second,
+		CPUTime: 30 * time.Second,
+		Memory:  2 << 30,
+	}
+	// dotPollInterval is how often the usage of dot is checked.
+	dotPollInterval = 100 * time.Millisecond
+)
+
+// runDot renders the DOT graph in the given graphviz output format,
+// killing dot if it exceeds the limits, or once ctx is done. The errors
+// from running dot, other than failing to start it or ctx being done,
+// wrap errRenderGraph.
+func runDot(ctx context.Context, dot []byte, format string, limits dotLimits) ([]byte, error) {
+	cmd := exec.Command(dotCommand, "-T"+format)
+	out := &bytes.Buffer{}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(dot), out, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(dotPollInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, fmt.Errorf("%w: dot failed: %v", errRenderGraph, err)
+			}
+			return out.Bytes(), nil
+		case <-ctx.Done():
+			cmd.Process.Kill()
+			<-done
+			return nil, ctx.Err()
+		case <-ticker.C:
+			if exceeded := limits.exceeded(cmd.Process.Pid, time.Since(start)); exceeded != "" {
+				cmd.Process.Kill()
+				<-done
+				return nil, fmt.Errorf("%w: dot killed after using more than %s", errRenderGraph, exceeded)
+			}
+		}
+	}
+}
+
+// exceeded returns the limit that the process pid, running for elapsed
+// time, has exceeded, or "" if none.
+func (l dotLimits) exceeded(pid int, elapsed time.Duration) string {
+	if l.Time > 0 && elapsed > l.Time {
+		return fmt.Sprintf("%v", l.Time)
+	}
+	if l.CPUTime <= 0 && l.Memory <= 0 || runtime.GOOS != "linux" {
+		return ""
+	}
+	cpu, rss, err := procUsage(pid)
+	if err != nil {
+		// The process may have just exited.
+		return ""
+	}
+	if l.CPUTime > 0 && cpu > l.CPUTime {
+		return fmt.Sprintf("%v of CPU time", l.CPUTime)
+	}
+	if l.Memory > 0 && rss > l.Memory {
+		return fmt.Sprintf("%d MB of memory", l.Memory>>20)
+	}
+	return ""
+}
+
+// procUsage returns the CPU time and resident memory used by the Linux
+// process pid, from /proc/<pid>/stat.
+func procUsage(pid int) (cpu time.Duration, rss int64, err error) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name, in parentheses, may contain spaces.
+	s := string(b)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	// The fields after the command name start at the state, the 3rd one.
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+	// utime and stime are in clock ticks, which are 1/100s on Linux.
+	const ticksPerSecond = 100
+	cpu = time.Duration(field(14)+field(15)) * time.Second / ticksPerSecond
+	rss = field(24) * int64(os.Getpagesize())
+	return cpu, rss, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+// fakeDot makes pprof run a shell script instead of dot, until the
+// returned function restores dot.
+func fakeDot(t *testing.T, script string) func() {
+	t.Helper()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("test runs a shell script as dot")
+	}
+	dir, err := ioutil.TempDir("", "pprof_dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := filepath.Join(dir, "dot")
+	if err := ioutil.WriteFile(dot, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := dotCommand
+	dotCommand = dot
+	return func() {
+		dotCommand = saved
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunDotLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		limits dotLimits
+		want   string
+		linux  bool
+	}{
+		{"time", "exec sleep 10", dotLimits{Time: 200 * time.Millisecond}, "more than 200ms", false},
+		{"cpu", "while :; do :; done", dotLimits{Time: 10 * time.Second, CPUTime: 200 * time.Millisecond}, "more than 200ms of CPU time", true},
+		{"failure", "exit 3", dotLimits{}, "dot failed: exit status 3", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.linux && runtime.GOOS != "linux" {
+				t.Skip("CPU time is only limited on Linux")
+			}
+			defer fakeDot(t, tc.script)()
+			_, err := runDot(context.Background(), []byte("digraph {}"), "svg", tc.limits)
+			if !errors.Is(err, errRenderGraph) {
+				t.Fatalf("got error %v, want a render error", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %q, want %q", err, tc.want)
+			}
+		})
+	}
+
+	defer fakeDot(t, "cat")()
+	got, err := runDot(context.Background(), []byte("digraph {}"), "svg", defaultDotLimits)
+	if err != nil || string(got) != "digraph {}" {
+		t.Errorf("got %q, %v, want the output of dot", got, err)
+	}
+}
+
+// chainProfile returns a profile with a single stack of n functions.
+func chainProfile(n int) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Mapping:    []*profile.Mapping{{ID: 1, File: "/bin/app", HasFunctions: true}},
+	}
+	var stack []*profile.Location
+	for i := 1; i <= n; i++ {
+		f := &profile.Function{ID: uint64(i), Name: fmt.Sprintf("f%d", i)}
+		l := &profile.Location{ID: uint64(i), Mapping: p.Mapping[0], Line: []profile.Line{{Function: f}}}
+		p.Function = append(p.Function, f)
+		p.Location = append(p.Location, l)
+		stack = append(stack, l)
+	}
+	p.Sample = []*profile.Sample{{Location: stack, Value: []int64{1000}}}
+	return p
+}
+
+// failFirstDot is a fake dot that fails the first time it runs, with
+// calls counting its runs.
+func failFirstDot(calls string) string {
+	return fmt.Sprintf(`echo x >> %s
+if [ "$(wc -l < %s)" -eq 1 ]; then exit 1; fi
+cat > /dev/null
+echo '<svg></svg>'
+`, calls, calls)
+}
+
+func TestSimplifyGraphOnDotFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_simplify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeDot(t, failFirstDot(filepath.Join(dir, "calls")))()
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(dir, "graph.svg")
+	ui := &proftest.TestUI{T: t, AllowRx: "Generating report in|graph simplified automatically to 40 nodes"}
+	o := setDefaults(&plugin.Options{UI: ui, HTTPTransport: &httpTransport{}})
+	if err := generateReport(context.Background(), chainProfile(100), []string{"svg"}, cfg, o); err != nil {
+		t.Fatalf("generateReport: %v", err)
+	}
+	if ui.NumAllowRxMatches != 2 {
+		t.Errorf("got %d messages, want the simplified graph notice and the output file", ui.NumAllowRxMatches)
+	}
+	if got, err := ioutil.ReadFile(cfg.Output); err != nil || !strings.Contains(string(got), "<svg>") {
+		t.Errorf("got %q, %v, want the svg of the simplified graph", got, err)
+	}
+}
+
+func TestWebSimplifyGraphOnDotFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_simplify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeDot(t, failFirstDot(filepath.Join(dir, "calls")))()
+	ui, err := makeWebInterface(chainProfile(100), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	ui.dot(w, req)
+	if w.Code != 200 {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if want := "Graph simplified automatically to 40 nodes"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("graph page has no %q notice", want)
+	}
+}
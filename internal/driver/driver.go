@@ -19,21 +19,43 @@ package driver
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/report"
 	"github.com/google/pprof/profile"
 )
 
+// subcommands are the commands that pprof runs on their own arguments
+// instead of fetching and reporting a profile, as in "pprof synth spec.txt".
+var subcommands = map[string]func(ctx context.Context, args []string, cfg config, o *plugin.Options) error{
+	"api":     serveAPI,
+	"bench":   compareBenchmarks,
+	"compare": compareProfiles,
+	"synth":   generateSynthetic,
+}
+
 // PProf acquires a profile, and symbolizes it using a profile
 // manager. Then it generates a report formatted according to the
 // options selected through the flags package.
 func PProf(eo *plugin.Options) error {
+	return PProfContext(context.Background(), eo)
+}
+
+// PProfContext runs pprof as PProf does, until ctx is canceled: it stops
+// fetching and symbolizing the profiles and generating the reports once
+// ctx is done, failing with its error. Interrupting pprof, as by Ctrl-C,
+// cancels the operation it runs, and a second interrupt kills it.
+func PProfContext(ctx context.Context, eo *plugin.Options) error {
 	// Remove any temporary files created during pprof processing.
 	defer cleanupTempFiles()
 
@@ -43,23 +65,81 @@ func PProf(eo *plugin.Options) error {
 	if err != nil {
 		return err
 	}
+	if run := subcommands[src.Subcommand]; run != nil {
+		return run(ctx, src.Sources, currentConfig(), o)
+	}
 
-	p, err := fetchProfiles(src, o)
+	if src.SSHTunnel != "" {
+		// The remote pprof fetches and serves the profiles.
+		return serveSSHTunnel(src, os.Args[1:], o)
+	}
+
+	if o.HTTPMiddleware, err = basicAuth(src, o.HTTPMiddleware); err != nil {
+		return err
+	}
+	tlsConfig, err := webTLSConfig(src, o.UI)
 	if err != nil {
 		return err
 	}
 
+	// Pprof is profiled until the report is written, or until the
+	// profiles are ready to be browsed.
+	if src.selfProfile, err = startSelfProfile(src.SelfProfile); err != nil {
+		return err
+	}
+	defer src.selfProfile.stop(o.UI)
+
+	if src.HTTPTabs {
+		var tabs []*profileTab
+		if err := src.selfProfile.phase("fetch", o.UI, func() (err error) {
+			ictx, stop := interruptible(ctx)
+			defer stop()
+			tabs, err = fetchTabs(ictx, src, o)
+			return err
+		}); err != nil {
+			return err
+		}
+		src.selfProfile.stop(o.UI)
+		return serveWebInterface(src.HTTPHostport, tabs, o, src.HTTPDisableBrowser, tlsConfig)
+	}
+
+	var p *profile.Profile
+	if err := src.selfProfile.phase("fetch", o.UI, func() (err error) {
+		ictx, stop := interruptible(ctx)
+		defer stop()
+		p, err = fetchProfiles(ictx, src, o)
+		return err
+	}); err != nil {
+		return err
+	}
+
 	if cmd != nil {
-		return generateReport(p, cmd, currentConfig(), o)
+		return src.selfProfile.phase("report", o.UI, func() error {
+			recordEvent(o, "report", cmd[0])
+			defer recordDuration(o, "report "+cmd[0], time.Now())
+			ictx, stop := interruptible(ctx)
+			defer stop()
+			return generateCachedReport(ictx, src.CacheDir, p, cmd, currentConfig(), o)
+		})
 	}
 
+	if src.StaticHTML != "" {
+		return src.selfProfile.phase("report", o.UI, func() error {
+			ictx, stop := interruptible(ctx)
+			defer stop()
+			return exportWebInterface(ictx, src.StaticHTML, p, o)
+		})
+	}
+	src.selfProfile.stop(o.UI)
 	if src.HTTPHostport != "" {
-		return serveWebInterface(src.HTTPHostport, p, o, src.HTTPDisableBrowser)
+		tab := newProfileTab(tabName(src.Sources), p)
+		tab.refetch = liveFetcher(src, o)
+		return serveWebInterface(src.HTTPHostport, []*profileTab{tab}, o, src.HTTPDisableBrowser, tlsConfig)
 	}
-	return interactive(p, o)
+	return interactive(ctx, p, o)
 }
 
-func generateRawReport(p *profile.Profile, cmd []string, cfg config, o *plugin.Options) (*command, *report.Report, error) {
+func generateRawReport(ctx context.Context, p *profile.Profile, cmd []string, cfg config, o *plugin.Options) (*command, *report.Report, error) {
 	p = p.Copy() // Prevent modification to the incoming profile.
 
 	// Identify units of numeric tags in profile.
@@ -70,11 +150,21 @@ func generateRawReport(p *profile.Profile, cmd []string, cfg config, o *plugin.O
 	if c == nil {
 		panic("unexpected nil command")
 	}
+	if cfg.Zstd && c.format == report.Proto {
+		// The profile is written uncompressed, for the zstd tool.
+		zc := *c
+		zc.postProcess = compressZstd
+		c = &zc
+	}
 
 	cfg = applyCommandOverrides(cmd[0], c.format, cfg)
 
 	// Delay focus after configuring report to get percentages on all samples.
-	relative := cfg.RelativePercentages
+	base, err := percentBase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	relative := base != "total"
 	if relative {
 		if err := applyFocus(p, numLabelUnits, cfg, o.UI); err != nil {
 			return nil, nil, err
@@ -84,7 +174,13 @@ func generateRawReport(p *profile.Profile, cmd []string, cfg config, o *plugin.O
 	if err != nil {
 		return nil, nil, err
 	}
+	if base == "node" {
+		if ropt.Total, err = nodeTotal(p, cfg.PercentNode, ropt, o.UI); err != nil {
+			return nil, nil, err
+		}
+	}
 	ropt.OutputFormat = c.format
+	ropt.Context = ctx
 	if len(cmd) == 2 {
 		s, err := regexp.Compile(cmd[1])
 		if err != nil {
@@ -99,23 +195,80 @@ func generateRawReport(p *profile.Profile, cmd []string, cfg config, o *plugin.O
 			return nil, nil, err
 		}
 	}
+	// Report distinct functions sharing a name separately, as this
+	// granularity would otherwise merge them. They are renamed once the
+	// filters matched their names.
+	if cfg.Granularity == "functions" {
+		report.DisambiguateFunctions(p)
+	}
 	if err := aggregate(p, cfg); err != nil {
 		return nil, nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if c.format == report.Proto {
+		auditFilters(p, cfg)
+	}
 
 	return c, rpt, nil
 }
 
-func generateReport(p *profile.Profile, cmd []string, cfg config, o *plugin.Options) error {
-	c, rpt, err := generateRawReport(p, cmd, cfg, o)
+// errRenderGraph is wrapped by the errors of post processors that failed
+// to render a graph, which might be rendered with fewer nodes.
+var errRenderGraph = errors.New("could not render graph")
+
+// simplerNodeCount returns the number of nodes to retry rendering a
+// graph that failed with nodeCount nodes, or 0 if it is too simple
+// already.
+func simplerNodeCount(nodeCount int) int {
+	const minNodeCount = 10
+	if nodeCount/2 < minNodeCount {
+		return 0
+	}
+	return nodeCount / 2
+}
+
+// reportGenerators holds the commands whose output is written by the
+// driver itself rather than by report.Generate, keyed by command name.
+var reportGenerators = map[string]func(w io.Writer, rpt *report.Report) error{}
+
+func generateReport(ctx context.Context, p *profile.Profile, cmd []string, cfg config, o *plugin.Options) error {
+	cfg = terminalConfig(cmd, cfg, o)
+	c, src, err := renderReport(ctx, p, cmd, cfg, o)
 	if err != nil {
 		return err
 	}
+	return writeReport(src, c, cfg, o)
+}
+
+// terminalConfig returns cfg, with the names of the text report cmd fit
+// to the terminal if it is written to one.
+func terminalConfig(cmd []string, cfg config, o *plugin.Options) config {
+	if cfg.MaxNameLen == 0 && cfg.Output == "" && o.UI.IsTerminal() {
+		cfg.MaxNameLen = terminalNameLen(cmd[0])
+	}
+	return cfg
+}
+
+// renderReport generates the report cmd of p, post-processed if the
+// command requires it, and returns it with the command.
+func renderReport(ctx context.Context, p *profile.Profile, cmd []string, cfg config, o *plugin.Options) (*command, *bytes.Buffer, error) {
+	c, rpt, err := generateRawReport(ctx, p, cmd, cfg, o)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Generate the report.
 	dst := new(bytes.Buffer)
-	if err := report.Generate(dst, rpt, o.Obj); err != nil {
-		return err
+	generate := func(w io.Writer, rpt *report.Report) error {
+		return report.Generate(w, rpt, o.Obj)
+	}
+	if g, ok := reportGenerators[cmd[0]]; ok {
+		generate = g
+	}
+	if err := generate(dst, rpt); err != nil {
+		return nil, nil, err
 	}
 	src := dst
 
@@ -123,11 +276,22 @@ func generateReport(p *profile.Profile, cmd []string, cfg config, o *plugin.Opti
 	if c.postProcess != nil {
 		dst = new(bytes.Buffer)
 		if err := c.postProcess(src, dst, o.UI); err != nil {
-			return err
+			nodeCount := simplerNodeCount(applyCommandOverrides(cmd[0], c.format, cfg).NodeCount)
+			if !errors.Is(err, errRenderGraph) || nodeCount == 0 {
+				return nil, nil, err
+			}
+			o.UI.PrintErr(fmt.Sprintf("%v; graph simplified automatically to %d nodes", err, nodeCount))
+			cfg.NodeCount = nodeCount
+			return renderReport(ctx, p, cmd, cfg, o)
 		}
 		src = dst
 	}
+	return c, src, nil
+}
 
+// writeReport writes the report src of the command c to the output of
+// cfg, or shows it with the visualizer of c if there is none.
+func writeReport(src *bytes.Buffer, c *command, cfg config, o *plugin.Options) error {
 	// If no output is specified, use default visualizer.
 	output := cfg.Output
 	if output == "" {
@@ -151,6 +315,31 @@ func generateReport(p *profile.Profile, cmd []string, cfg config, o *plugin.Opti
 	return out.Close()
 }
 
+// terminalNameLen returns the number of columns left for names by the
+// text report cmd on a terminal of the width given by $COLUMNS, or 0 if
+// the width is unknown or cmd is not a text report.
+func terminalNameLen(cmd string) int {
+	// Width of the value columns preceding the names.
+	var prefix int
+	switch cmd {
+	case "text", "top":
+		prefix = 44
+	case "tree", "peek":
+		prefix = 62
+	default:
+		return 0
+	}
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width-prefix < minTerminalNameLen {
+		return 0
+	}
+	return width - prefix
+}
+
+// minTerminalNameLen is the minimum name length worth truncating to when
+// fitting a text report to a terminal.
+const minTerminalNameLen = 20
+
 func applyCommandOverrides(cmd string, outputFormat int, cfg config) config {
 	// Some report types override the trim flag to false below. This is to make
 	// sure the default heuristics of excluding insignificant nodes and edges
@@ -163,7 +352,7 @@ func applyCommandOverrides(cmd string, outputFormat int, cfg config) config {
 	trim := cfg.Trim
 
 	switch cmd {
-	case "disasm":
+	case "disasm", "diffdisasm":
 		trim = false
 		cfg.Granularity = "addresses"
 		// Force the 'noinlines' mode so that source locations for a given address
@@ -176,8 +365,16 @@ func applyCommandOverrides(cmd string, outputFormat int, cfg config) config {
 		trim = false
 		cfg.Granularity = "addresses"
 		cfg.NoInlines = false // Need inline info to support call expansion
-	case "peek":
+	case "peek", "unsampled":
+		trim = false
+	case "callhierarchy":
+		// Keep the files of the functions for IDEs to open them.
+		trim = false
+		cfg.Granularity = "filefunctions"
+	case "flamegraph", "flamegraphjson":
+		// Like the flame graph of the web interface, draw the whole call tree.
 		trim = false
+		cfg.CallTree = true
 	case "list":
 		trim = false
 		cfg.Granularity = "lines"
@@ -194,7 +391,7 @@ func applyCommandOverrides(cmd string, outputFormat int, cfg config) config {
 	}
 
 	switch outputFormat {
-	case report.Proto, report.Raw, report.Callgrind:
+	case report.Proto, report.OTLP, report.Raw, report.Callgrind, report.Collisions, report.SQLite, report.Parquet, report.LongCSV:
 		trim = false
 		cfg.Granularity = "addresses"
 		cfg.NoInlines = false
@@ -253,6 +450,32 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, cfg conf
 		return nil, fmt.Errorf("zero divisor specified")
 	}
 
+	var delimiter rune
+	switch cfg.TableFormat {
+	case "":
+	case "csv":
+		delimiter = ','
+	case "tsv":
+		delimiter = '\t'
+	default:
+		return nil, fmt.Errorf("invalid table_format %q, want csv or tsv", cfg.TableFormat)
+	}
+
+	var metadata *report.Metadata
+	if cfg.Metadata != "" {
+		f, err := os.Open(cfg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err = report.ParseMetadata(f, strings.HasSuffix(cfg.Metadata, ".json"))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.Metadata, err)
+		}
+	} else if cfg.MetadataGroup != "" {
+		return nil, fmt.Errorf("metadata_group requires metadata")
+	}
+
 	var filters []string
 	addFilter := func(k string, v string) {
 		if v != "" {
@@ -268,14 +491,23 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, cfg conf
 	addFilter("tagignore", cfg.TagIgnore)
 	addFilter("tagshow", cfg.TagShow)
 	addFilter("taghide", cfg.TagHide)
+	addFilter("trace", cfg.Trace)
+	addFilter("time_window", cfg.TimeWindow)
+	if base, _ := percentBase(cfg); base == "node" {
+		addFilter("percent_node", cfg.PercentNode)
+	}
 
 	ropt := &report.Options{
 		CumSort:      cfg.Sort == "cum",
 		CallTree:     cfg.CallTree,
+		MergeRepeats: cfg.Granularity == "files",
 		DropNegative: cfg.DropNegative,
 
 		CompactLabels: cfg.CompactLabels,
 		Ratio:         1 / cfg.DivideBy,
+		GraphTitle:    cfg.GraphTitle,
+		Annotations:   annotations(cfg.Annotations),
+		DotMetadata:   cfg.DotMetadata,
 
 		NodeCount:    cfg.NodeCount,
 		NodeFraction: cfg.NodeFraction,
@@ -295,6 +527,22 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, cfg conf
 		TrimPath:   cfg.TrimPath,
 
 		IntelSyntax: cfg.IntelSyntax,
+
+		AsmSource:      cfg.WithSource,
+		AsmHotFraction: cfg.OnlyHot,
+
+		MinSymbolSize: uint64(cfg.MinSize),
+
+		MaxNameLen: cfg.MaxNameLen,
+		Delimiter:  delimiter,
+
+		FairShare: cfg.FairShare,
+
+		Metadata:      metadata,
+		MetadataGroup: cfg.MetadataGroup,
+
+		CompactProto: cfg.Compact,
+		RawProto:     cfg.Zstd,
 	}
 
 	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
@@ -304,6 +552,18 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, cfg conf
 	return ropt, nil
 }
 
+// annotations returns the text boxes of the annotations option, separated
+// by semicolons, with \n starting new lines.
+func annotations(s string) []string {
+	var notes []string
+	for _, note := range strings.Split(s, ";") {
+		if note = strings.TrimSpace(note); note != "" {
+			notes = append(notes, strings.ReplaceAll(note, `\n`, "\n"))
+		}
+	}
+	return notes
+}
+
 // identifyNumLabelUnits returns a map of numeric label keys to the units
 // associated with those keys.
 func identifyNumLabelUnits(p *profile.Profile, ui plugin.UI) map[string]string {
@@ -22,6 +22,7 @@ import (
 
 	"github.com/google/pprof/internal/measurement"
 	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
 	"github.com/google/pprof/profile"
 )
 
@@ -54,6 +55,17 @@ func applyFocus(prof *profile.Profile, numLabelUnits map[string]string, cfg conf
 	warnNoMatches(tagfocus == nil || tfm, "TagFocus", ui)
 	warnNoMatches(tagignore == nil || tim, "TagIgnore", ui)
 
+	trace := compileTraceFilter(cfg.Trace)
+	trm, _ := prof.FilterSamplesByTag(trace, nil)
+	warnNoMatches(trace == nil || trm, "Trace", ui)
+
+	window, err := compileTimeWindow(cfg.TimeWindow, numLabelUnits, err)
+	if err != nil {
+		return err
+	}
+	twm, _ := prof.FilterSamplesByTag(window, nil)
+	warnNoMatches(window == nil || twm, "TimeWindow", ui)
+
 	tagshow, err := compileRegexOption("tagshow", cfg.TagShow, err)
 	taghide, err := compileRegexOption("taghide", cfg.TagHide, err)
 	tns, tnh := prof.FilterTagsByName(tagshow, taghide)
@@ -159,6 +171,98 @@ func compileTagFilter(name, value string, numLabelUnits map[string]string, ui pl
 	}, nil
 }
 
+// compileTraceFilter returns a function to check if a sample belongs to
+// one of the comma-separated trace or span IDs of value, as recorded by
+// its trace and span ID labels.
+func compileTraceFilter(value string) func(*profile.Sample) bool {
+	if value == "" {
+		return nil
+	}
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return func(s *profile.Sample) bool {
+		for _, key := range []string{report.TraceIDLabel, report.SpanIDLabel} {
+			for _, id := range s.Label[key] {
+				if ids[id] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// compileTimeWindow returns a function to check if a sample was recorded
+// in the time window of value, start:end in nanoseconds, from start up to
+// but not including end, by its timestamp label.
+func compileTimeWindow(value string, numLabelUnits map[string]string, err error) (func(*profile.Sample) bool, error) {
+	if value == "" || err != nil {
+		return nil, err
+	}
+	bounds := strings.SplitN(value, ":", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("parsing time_window %q: want start:end", value)
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing time_window start: %v", err)
+	}
+	end, err := strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing time_window end: %v", err)
+	}
+	unit := numLabelUnits[report.TimestampLabel]
+	return func(s *profile.Sample) bool {
+		t, ok := report.SampleTime(s, unit)
+		return ok && t >= start && t < end
+	}, nil
+}
+
+// percentBase returns what the percentages of the report of cfg are
+// relative to: "total", "focused" or "node". relative_percentages stands
+// for focused.
+func percentBase(cfg config) (string, error) {
+	switch cfg.PercentBase {
+	case "", "total":
+		if cfg.RelativePercentages {
+			return "focused", nil
+		}
+		return "total", nil
+	case "focused":
+		return "focused", nil
+	case "node":
+		if cfg.PercentNode == "" {
+			return "", fmt.Errorf("percent_base=node requires percent_node")
+		}
+		return "node", nil
+	}
+	return "", fmt.Errorf("invalid percent_base %q, want total, focused or node", cfg.PercentBase)
+}
+
+// nodeTotal returns the cum value of the nodes of prof matching the regexp
+// value, that is the total of the samples with a location matching it, as
+// the focus option would select them.
+func nodeTotal(prof *profile.Profile, value string, ropt *report.Options, ui plugin.UI) (int64, error) {
+	rx, err := compileRegexOption("percent_node", value, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Filter a copy of the samples, without hide or show, which would
+	// change the locations.
+	matched := &profile.Profile{Sample: prof.Sample, Location: prof.Location}
+	fm, _, _, _ := matched.FilterSamplesByName(rx, nil, nil, nil)
+	warnNoMatches(fm && len(matched.Sample) > 0, "PercentNode", ui)
+	total := report.New(matched, ropt).Total()
+	if total == 0 {
+		return 0, fmt.Errorf("percent_node %q: the matching nodes have no value to compute percentages of", value)
+	}
+	return total, nil
+}
+
 // parseTagFilterRange returns a function to checks if a value is
 // contained on the range described by a string. It can recognize
 // strings of the form:
@@ -16,6 +16,7 @@ package driver
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -38,9 +39,17 @@ import (
 
 var updateFlag = flag.Bool("update", false, "Update the golden files")
 
+// liteBuild reports whether the tests run in a pprof-lite build, which
+// leaves out the visualizers, the web interface and HTTP fetching.
+func liteBuild() bool {
+	return pprofCommands["web"] == nil
+}
+
 func TestParse(t *testing.T) {
 	// Override weblist command to collect output in buffer
-	pprofCommands["weblist"].postProcess = nil
+	if c := pprofCommands["weblist"]; c != nil {
+		c.postProcess = nil
+	}
 
 	// Our mockObjTool.Open will always return success, causing
 	// driver.locateBinaries to "find" the binaries below in a non-existent
@@ -88,6 +97,12 @@ func TestParse(t *testing.T) {
 		{"traces", "cpu"},
 		{"traces,addresses", "cpu"},
 		{"traces", "heap_tags"},
+		{"folded", "cpu"},
+		{"folded,inuse_objects", "heap"},
+		{"speedscope", "cpu"},
+		{"speedscope,lines", "heap"},
+		{"chrometrace", "cpu"},
+		{"chrometrace", "heap_tags"},
 		{"dot,alloc_space,flat,focus=[234]00", "heap_alloc"},
 		{"dot,alloc_space,flat,tagshow=[2]00", "heap_alloc"},
 		{"dot,alloc_space,flat,hide=line.*1?23?", "heap_alloc"},
@@ -100,12 +115,17 @@ func TestParse(t *testing.T) {
 		{"tags,tagfocus=+400kb:", "heap_request"},
 		{"dot", "long_name_funcs"},
 		{"text", "long_name_funcs"},
+		{"dot,max_name_len=12", "long_name_funcs"},
+		{"text,max_name_len=30", "long_name_funcs"},
 	}
 
 	baseConfig := currentConfig()
 	defer setCurrentConfig(baseConfig)
 	for _, tc := range testcase {
 		t.Run(tc.flags+":"+tc.source, func(t *testing.T) {
+			if cmd := strings.SplitN(strings.Split(tc.flags, ",")[0], "=", 2)[0]; pprofCommands[cmd] == nil {
+				t.Skipf("%s is not available in this build", cmd)
+			}
 			// Reset config before processing
 			setCurrentConfig(baseConfig)
 
@@ -269,7 +289,8 @@ func solutionFilename(source string, f *testFlags) string {
 	name = addString(name, f, []string{"relative_percentages"})
 	name = addString(name, f, []string{"seconds"})
 	name = addString(name, f, []string{"call_tree"})
-	name = addString(name, f, []string{"text", "tree", "callgrind", "dot", "svg", "tags", "dot", "traces", "disasm", "peek", "weblist", "topproto", "comments"})
+	name = addString(name, f, []string{"max_name_len"})
+	name = addString(name, f, []string{"text", "tree", "callgrind", "dot", "svg", "tags", "dot", "traces", "folded", "speedscope", "chrometrace", "disasm", "peek", "weblist", "topproto", "comments"})
 	if f.strings["focus"] != "" || f.strings["tagfocus"] != "" {
 		name = append(name, "focus")
 	}
@@ -1050,7 +1071,7 @@ var autoCompleteTests = []struct {
 	{"EdGeF", "edgefraction"},                           // single capitalized match
 	{"help dis", "help disasm"},                         // help command match
 	{"help relative_perc", "help relative_percentages"}, // help variable match
-	{"help coMpa", "help compact_labels"},               // help variable capitalized match
+	{"help coMpact_", "help compact_labels"},            // help variable capitalized match
 }
 
 func TestAutoComplete(t *testing.T) {
@@ -1063,6 +1084,21 @@ func TestAutoComplete(t *testing.T) {
 	}
 }
 
+func TestAnnotations(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"a, b; c\\nd ;;", []string{"a, b", "c\nd"}},
+	} {
+		if got := annotations(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("annotations(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
 func TestTagFilter(t *testing.T) {
 	var tagFilterTests = []struct {
 		desc, value string
@@ -1144,6 +1180,95 @@ func TestTagFilter(t *testing.T) {
 	}
 }
 
+func TestTraceFilter(t *testing.T) {
+	filter := compileTraceFilter("t1, s2")
+	for _, tc := range []struct {
+		labels map[string][]string
+		want   bool
+	}{
+		{map[string][]string{"trace_id": {"t1"}, "span_id": {"s1"}}, true},
+		{map[string][]string{"trace_id": {"t2"}, "span_id": {"s2"}}, true},
+		{map[string][]string{"trace_id": {"t2"}, "span_id": {"s1"}}, false},
+		{map[string][]string{"request": {"t1"}}, false},
+		{nil, false},
+	} {
+		if got := filter(&profile.Sample{Label: tc.labels}); got != tc.want {
+			t.Errorf("trace filter on %v: got %v, want %v", tc.labels, got, tc.want)
+		}
+	}
+	if compileTraceFilter("") != nil {
+		t.Errorf("got a trace filter for no trace IDs")
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	window, err := compileTimeWindow("2000:5000", map[string]string{"timestamp": "us"}, nil)
+	if err != nil {
+		t.Fatalf("compileTimeWindow: %v", err)
+	}
+	for _, tc := range []struct {
+		timestamps []int64
+		want       bool
+	}{
+		{[]int64{1}, false},
+		{[]int64{2}, true},
+		{[]int64{4}, true},
+		{[]int64{5}, false},
+		{nil, false},
+	} {
+		s := &profile.Sample{NumLabel: map[string][]int64{"timestamp": tc.timestamps}}
+		if got := window(s); got != tc.want {
+			t.Errorf("time window on %v: got %v, want %v", tc.timestamps, got, tc.want)
+		}
+	}
+	for _, value := range []string{"2000", "a:5000", "2000:b"} {
+		if _, err := compileTimeWindow(value, nil, nil); err == nil {
+			t.Errorf("time window %q: got no error", value)
+		}
+	}
+}
+
+func TestPercentBase(t *testing.T) {
+	// The samples of F3 are worth 100 out of 300, and all have F1 and F2.
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t, AllowRx: "PercentNode expression matched no samples"},
+		HTTPTransport: &httpTransport{},
+	})
+	for _, tc := range []struct {
+		desc   string
+		edit   func(*config)
+		want   int64
+		errors bool
+	}{
+		{"total", func(cfg *config) {}, 300, false},
+		{"total with focus", func(cfg *config) { cfg.Focus = "F3" }, 300, false},
+		{"focused", func(cfg *config) { cfg.Focus, cfg.PercentBase = "F3", "focused" }, 100, false},
+		{"relative_percentages", func(cfg *config) { cfg.Focus, cfg.RelativePercentages = "F3", true }, 100, false},
+		{"node", func(cfg *config) { cfg.PercentBase, cfg.PercentNode = "node", "F3" }, 100, false},
+		{"node after focus", func(cfg *config) { cfg.Focus, cfg.PercentBase, cfg.PercentNode = "F3", "node", "F1" }, 100, false},
+		{"node without percent_node", func(cfg *config) { cfg.PercentBase = "node" }, 0, true},
+		{"node matching nothing", func(cfg *config) { cfg.PercentBase, cfg.PercentNode = "node", "F9" }, 0, true},
+		{"invalid base", func(cfg *config) { cfg.PercentBase = "all" }, 0, true},
+	} {
+		cfg := defaultConfig()
+		tc.edit(&cfg)
+		_, rpt, err := generateRawReport(context.Background(), makeFakeProfile(), []string{"top"}, cfg, o)
+		if tc.errors {
+			if err == nil {
+				t.Errorf("%s: got no error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if got := rpt.Total(); got != tc.want {
+			t.Errorf("%s: got total %d, want %d", tc.desc, got, tc.want)
+		}
+	}
+}
+
 func TestIdentifyNumLabelUnits(t *testing.T) {
 	var tagFilterTests = []struct {
 		desc               string
@@ -1559,7 +1684,7 @@ func TestSymbolzAfterMerge(t *testing.T) {
 
 	o.Fetch = testSymbolzMergeFetcher{}
 	o.Sym = testSymbolzSymbolizer{}
-	p, err := fetchProfiles(src, o)
+	p, err := fetchProfiles(context.Background(), src, o)
 	if err != nil {
 		t.Fatalf("fetchProfiles: %v", err)
 	}
@@ -1708,3 +1833,93 @@ func (m *mockFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error)
 func (*mockFile) Close() error {
 	return nil
 }
+
+func TestSubcommandNamedFile(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	dir, err := ioutil.TempDir("", "pprof_subcommand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// A binary named like a subcommand.
+	if err := ioutil.WriteFile("api", []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		args                     []string
+		wantSubcommand, wantExec string
+	}{
+		{[]string{"api", "cpu.pprof"}, "", "api"},
+		{[]string{"./api", "cpu.pprof"}, "", "./api"},
+		{[]string{"bench", "old", "new"}, "bench", ""},
+	} {
+		setCurrentConfig(baseConfig)
+		o := setDefaults(&plugin.Options{
+			Obj:           fakeObjTool{},
+			UI:            &proftest.TestUI{T: t},
+			Flagset:       testFlags{args: tc.args},
+			HTTPTransport: &httpTransport{},
+		})
+		src, _, err := parseFlags(o)
+		if err != nil {
+			t.Fatalf("pprof %v: %v", tc.args, err)
+		}
+		if src.Subcommand != tc.wantSubcommand || src.ExecName != tc.wantExec {
+			t.Errorf("pprof %v: got subcommand %q and binary %q, want %q and %q", tc.args, src.Subcommand, src.ExecName, tc.wantSubcommand, tc.wantExec)
+		}
+	}
+}
+
+func TestFocusCollidingFunctions(t *testing.T) {
+	m := &profile.Mapping{ID: 1, File: "/bin/main"}
+	f := []*profile.Function{
+		{ID: 1, Name: "main", Filename: "main.c"},
+		{ID: 2, Name: "helper", Filename: "a/util.c"},
+		{ID: 3, Name: "helper", Filename: "b/util.c"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Mapping: m, Line: []profile.Line{{Function: f[0]}}},
+		{ID: 2, Mapping: m, Line: []profile.Line{{Function: f[1]}}},
+		{ID: 3, Mapping: m, Line: []profile.Line{{Function: f[2]}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[1], l[0]}, Value: []int64{10}},
+			{Location: []*profile.Location{l[2], l[0]}, Value: []int64{20}},
+			{Location: []*profile.Location{l[0]}, Value: []int64{5}},
+		},
+		Location: l,
+		Function: f,
+		Mapping:  []*profile.Mapping{m},
+	}
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: &httpTransport{},
+	})
+	// The filters match the names of the functions, before they are told
+	// apart.
+	cfg := currentConfig()
+	cfg.Granularity = "functions"
+	cfg.Focus = "^helper$"
+	_, out, err := renderReport(context.Background(), p, []string{"top"}, cfg, o)
+	if err != nil {
+		t.Fatalf("renderReport: %v", err)
+	}
+	for _, want := range []string{"helper (a/util.c)", "helper (b/util.c)", "accounting for 30, 85.71% of 35 total"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got report:\n%s\nwant %q", out, want)
+		}
+	}
+}
@@ -16,9 +16,11 @@ package driver
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -38,8 +40,9 @@ import (
 // fetchProfiles fetches and symbolizes the profiles specified by s.
 // It will merge all the profiles it is able to retrieve, even if
 // there are some failures. It will return an error if it is unable to
-// fetch any profiles.
-func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
+// fetch any profiles, or if ctx is canceled.
+func fetchProfiles(ctx context.Context, s *source, o *plugin.Options) (*profile.Profile, error) {
+	defer recordDuration(o, "fetch", time.Now())
 	sources := make([]profileSource, 0, len(s.Sources))
 	for _, src := range s.Sources {
 		sources = append(sources, profileSource{
@@ -56,32 +59,94 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 		})
 	}
 
-	p, pbase, m, mbase, save, err := grabSourcesAndBases(sources, bases, o.Fetch, o.Obj, o.UI, o.HTTPTransport)
+	labelNames, err := loadLabelNames(s.LabelNames)
+	if err != nil {
+		return nil, err
+	}
+	phases, err := loadPhaseMap(s.PhaseMap)
+	if err != nil {
+		return nil, err
+	}
+
+	p, pbase, m, mbase, save, err := grabSourcesAndBases(ctx, sources, bases, o.Fetch, o.Obj, o.UI, o.HTTPTransport)
 	if err != nil {
 		return nil, err
 	}
 
 	if pbase != nil {
+		typeMap, err := parseSampleTypeMap(s.SampleTypeMap)
+		if err != nil {
+			return nil, err
+		}
+		if err := reconcileSampleTypes(p, pbase, typeMap, o.UI); err != nil {
+			return nil, err
+		}
+		if !s.DeltaFrom {
+			if reason := swappedDiff(p, pbase, s.Normalize); reason != "" {
+				if s.DiffAutoOrient {
+					p, pbase, m, mbase = pbase, p, mbase, m
+					o.UI.PrintErr("Swapped the source and base profiles with -diff_auto_orient, as " + reason)
+					audit(p, "Swapped the source and base profiles with -diff_auto_orient")
+				} else {
+					o.UI.PrintErr("Warning: the source and base profiles may be given in the wrong order, as " + reason +
+						". Give the older profiles with -base or -diff_base, or use -diff_auto_orient to swap them.")
+				}
+			}
+		}
 		if s.DiffBase {
 			pbase.SetLabel("pprof::base", []string{"true"})
 		}
+		start, end := pbase.TimeNanos, p.TimeNanos
+		if s.DeltaFrom {
+			if err := checkDeltaFrom(p, pbase); err != nil {
+				return nil, err
+			}
+		}
 		if s.Normalize {
 			err := p.Normalize(pbase)
 			if err != nil {
 				return nil, err
 			}
+			audit(p, "Scaled the source profiles to the total of the base profiles with -normalize")
 		}
 		pbase.Scale(-1)
 		p, m, err = combineProfiles([]*profile.Profile{p, pbase}, []plugin.MappingSources{m, mbase})
 		if err != nil {
 			return nil, err
 		}
+		switch {
+		case s.DiffBase:
+			audit(p, "Subtracted the base profiles with -diff_base, labeling their samples pprof::base")
+		case s.DeltaFrom:
+			audit(p, "Subtracted the base profiles with -delta_from")
+		default:
+			audit(p, "Subtracted the base profiles with -base")
+		}
+		if s.DeltaFrom {
+			setDeltaInterval(p, start, end, o.UI)
+		}
+	}
+	correctPeriods(p, o.UI)
+	if isCumulative(p) {
+		o.UI.PrintErr("The values of the profile count since the start of the process, use -delta_from to subtract those of an earlier profile")
 	}
 
-	// Symbolize the merged profile.
-	if err := o.Sym.Symbolize(s.Symbolize, m, p); err != nil {
+	// Symbolize the merged profile, with the pinned tools if any.
+	tools, err := pinTools(s, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.selfProfile.phase("symbolize", o.UI, func() error {
+		return symbolize(ctx, s, m, p, o)
+	}); err != nil {
 		return nil, err
 	}
+	p.Comments = append(p.Comments, tools...)
+	if s.ProcessTree {
+		stitchProcessTree(p)
+	}
+	nameNumLabels(p, labelNames)
+	labelPhases(p, phases, o.UI)
 	p.RemoveUninteresting()
 	unsourceMappings(p)
 
@@ -122,7 +187,7 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 	return p, nil
 }
 
-func grabSourcesAndBases(sources, bases []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, *profile.Profile, plugin.MappingSources, plugin.MappingSources, bool, error) {
+func grabSourcesAndBases(ctx context.Context, sources, bases []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, *profile.Profile, plugin.MappingSources, plugin.MappingSources, bool, error) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 	var psrc, pbase *profile.Profile
@@ -132,20 +197,20 @@ func grabSourcesAndBases(sources, bases []profileSource, fetch plugin.Fetcher, o
 	var countsrc, countbase int
 	go func() {
 		defer wg.Done()
-		psrc, msrc, savesrc, countsrc, errsrc = chunkedGrab(sources, fetch, obj, ui, tr)
+		psrc, msrc, savesrc, countsrc, errsrc = chunkedGrab(ctx, sources, fetch, obj, ui, tr)
 	}()
 	go func() {
 		defer wg.Done()
-		pbase, mbase, savebase, countbase, errbase = chunkedGrab(bases, fetch, obj, ui, tr)
+		pbase, mbase, savebase, countbase, errbase = chunkedGrab(ctx, bases, fetch, obj, ui, tr)
 	}()
 	wg.Wait()
 	save := savesrc || savebase
 
 	if errsrc != nil {
-		return nil, nil, nil, nil, false, fmt.Errorf("problem fetching source profiles: %v", errsrc)
+		return nil, nil, nil, nil, false, fmt.Errorf("problem fetching source profiles: %w", errsrc)
 	}
 	if errbase != nil {
-		return nil, nil, nil, nil, false, fmt.Errorf("problem fetching base profiles: %v,", errbase)
+		return nil, nil, nil, nil, false, fmt.Errorf("problem fetching base profiles: %w,", errbase)
 	}
 	if countsrc == 0 {
 		return nil, nil, nil, nil, false, fmt.Errorf("failed to fetch any source profiles")
@@ -159,6 +224,12 @@ func grabSourcesAndBases(sources, bases []profileSource, fetch plugin.Fetcher, o
 	if want, got := len(bases), countbase; want != got {
 		ui.PrintErr(fmt.Sprintf("Fetched %d base profiles out of %d", got, want))
 	}
+	if countsrc > 1 {
+		audit(psrc, "Merged %d source profiles", countsrc)
+	}
+	if countbase > 1 {
+		audit(pbase, "Merged %d base profiles", countbase)
+	}
 
 	return psrc, pbase, msrc, mbase, save, nil
 }
@@ -166,7 +237,7 @@ func grabSourcesAndBases(sources, bases []profileSource, fetch plugin.Fetcher, o
 // chunkedGrab fetches the profiles described in source and merges them into
 // a single profile. It fetches a chunk of profiles concurrently, with a maximum
 // chunk size to limit its memory usage.
-func chunkedGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, plugin.MappingSources, bool, int, error) {
+func chunkedGrab(ctx context.Context, sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, plugin.MappingSources, bool, int, error) {
 	const chunkSize = 64
 
 	var p *profile.Profile
@@ -175,11 +246,14 @@ func chunkedGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTo
 	var count int
 
 	for start := 0; start < len(sources); start += chunkSize {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, false, 0, err
+		}
 		end := start + chunkSize
 		if end > len(sources) {
 			end = len(sources)
 		}
-		chunkP, chunkMsrc, chunkSave, chunkCount, chunkErr := concurrentGrab(sources[start:end], fetch, obj, ui, tr)
+		chunkP, chunkMsrc, chunkSave, chunkCount, chunkErr := concurrentGrab(ctx, sources[start:end], fetch, obj, ui, tr)
 		switch {
 		case chunkErr != nil:
 			return nil, nil, false, 0, chunkErr
@@ -203,16 +277,19 @@ func chunkedGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTo
 }
 
 // concurrentGrab fetches multiple profiles concurrently
-func concurrentGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, plugin.MappingSources, bool, int, error) {
+func concurrentGrab(ctx context.Context, sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (*profile.Profile, plugin.MappingSources, bool, int, error) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(sources))
 	for i := range sources {
 		go func(s *profileSource) {
 			defer wg.Done()
-			s.p, s.msrc, s.remote, s.err = grabProfile(s.source, s.addr, fetch, obj, ui, tr)
+			s.p, s.msrc, s.remote, s.err = grabProfile(ctx, s.source, s.addr, fetch, obj, ui, tr)
 		}(&sources[i])
 	}
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, false, 0, err
+	}
 
 	var save bool
 	profiles := make([]*profile.Profile, 0, len(sources))
@@ -245,9 +322,13 @@ func combineProfiles(profiles []*profile.Profile, msrcs []plugin.MappingSources)
 	if err := measurement.ScaleProfiles(profiles); err != nil {
 		return nil, nil, err
 	}
+	labelPeriods(profiles)
 
 	p, err := profile.Merge(profiles)
 	if err != nil {
+		if errors.Is(err, profile.ErrTooLarge) {
+			err = fmt.Errorf("%v, use -sample_fraction to keep a fraction of the samples", err)
+		}
 		return nil, nil, err
 	}
 
@@ -261,6 +342,23 @@ func combineProfiles(profiles []*profile.Profile, msrcs []plugin.MappingSources)
 	return p, msrc, nil
 }
 
+// keepSampleFraction keeps a fraction f of the samples of p, picked at
+// random with a fixed seed so that reports are reproducible, and scales
+// their values to preserve the totals of p on average.
+func keepSampleFraction(p *profile.Profile, f float64) {
+	r := rand.New(rand.NewSource(1))
+	n := len(p.Sample)
+	kept := p.Sample[:0]
+	for _, s := range p.Sample {
+		if r.Float64() < f {
+			kept = append(kept, s)
+		}
+	}
+	p.Sample = kept
+	p.Scale(1 / f)
+	audit(p, "Kept %d of %d samples with -sample_fraction=%g, scaling their values by %g", len(kept), n, f, 1/f)
+}
+
 type profileSource struct {
 	addr   string
 	source *source
@@ -309,9 +407,10 @@ const testSourceAddress = "pproftest.local"
 // grabProfile fetches a profile. Returns the profile, sources for the
 // profile mappings, a bool indicating if the profile was fetched
 // remotely, and an error.
-func grabProfile(s *source, source string, fetcher plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, msrc plugin.MappingSources, remote bool, err error) {
+func grabProfile(ctx context.Context, s *source, source string, fetcher plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, msrc plugin.MappingSources, remote bool, err error) {
 	var src string
 	duration, timeout := time.Duration(s.Seconds)*time.Second, time.Duration(s.Timeout)*time.Second
+	start := time.Now()
 	if fetcher != nil {
 		p, src, err = fetcher.Fetch(source, duration, timeout)
 		if err != nil {
@@ -320,7 +419,7 @@ func grabProfile(s *source, source string, fetcher plugin.Fetcher, obj plugin.Ob
 	}
 	if err != nil || p == nil {
 		// Fetch the profile over HTTP or from a file.
-		p, src, err = fetch(source, duration, timeout, ui, tr)
+		p, src, err = fetch(ctx, source, duration, timeout, s.DownloadRate, ui, tr)
 		if err != nil {
 			return
 		}
@@ -329,10 +428,27 @@ func grabProfile(s *source, source string, fetcher plugin.Fetcher, obj plugin.Ob
 	if err = p.CheckValid(); err != nil {
 		return
 	}
+	if err = checkWarnings(p, s, source, ui); err != nil {
+		return
+	}
+	if s.SampleFraction > 0 {
+		keepSampleFraction(p, s.SampleFraction)
+	}
 
 	// Update the binary locations from command line and paths.
 	locateBinaries(p, s, obj, ui)
 
+	if s.ProcessTree {
+		labelProcess(p, source)
+	}
+	if s.Series {
+		labelSeries(p, source)
+	}
+
+	if s.FetchEnv && src != "" {
+		p.Comments = append(p.Comments, fetchEnvComments(ctx, src, start, time.Since(start), ui, tr)...)
+	}
+
 	// Collect the source URL for all mappings.
 	if src != "" {
 		msrc = collectMappingSources(p, src)
@@ -346,6 +462,21 @@ func grabProfile(s *source, source string, fetcher plugin.Fetcher, obj plugin.Ob
 	return
 }
 
+// checkWarnings reports the contents of a profile fetched from source
+// that could only be partially interpreted. It returns an error instead
+// when strict checking was requested.
+func checkWarnings(p *profile.Profile, s *source, source string, ui plugin.UI) error {
+	ws := p.Warnings()
+	if len(ws) == 0 {
+		return nil
+	}
+	if s.Strict {
+		return fmt.Errorf("%s: unsupported profile contents: %s", source, strings.Join(ws, "; "))
+	}
+	ui.PrintErr(fmt.Sprintf("Warning: %s contains unsupported data:\n  %s", source, strings.Join(ws, "\n  ")))
+	return nil
+}
+
 // collectMappingSources saves the mapping sources of a profile.
 func collectMappingSources(p *profile.Profile, source string) plugin.MappingSources {
 	ms := plugin.MappingSources{}
@@ -460,7 +591,7 @@ mapping:
 // fetch fetches a profile from source, within the timeout specified,
 // producing messages through the ui. It returns the profile and the
 // url of the actual source of the profile for remote profiles.
-func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, src string, err error) {
+func fetch(ctx context.Context, source string, duration, timeout time.Duration, rate int, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, src string, err error) {
 	var f io.ReadCloser
 
 	if sourceURL, timeout := adjustURL(source, duration, timeout); sourceURL != "" {
@@ -468,7 +599,7 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http
 		if duration > 0 {
 			ui.Print(fmt.Sprintf("Please wait... (%v)", duration))
 		}
-		f, err = fetchURL(sourceURL, timeout, tr)
+		f, err = downloadURL(ctx, sourceURL, timeout, rate, ui, tr)
 		src = sourceURL
 	} else if isPerfFile(source) {
 		f, err = convertPerfData(source, ui)
@@ -482,34 +613,6 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http
 	return
 }
 
-// fetchURL fetches a profile from a URL using HTTP.
-func fetchURL(source string, timeout time.Duration, tr http.RoundTripper) (io.ReadCloser, error) {
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   timeout + 5*time.Second,
-	}
-	resp, err := client.Get(source)
-	if err != nil {
-		return nil, fmt.Errorf("http fetch: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		return nil, statusCodeError(resp)
-	}
-
-	return resp.Body, nil
-}
-
-func statusCodeError(resp *http.Response) error {
-	if resp.Header.Get("X-Go-Pprof") != "" && strings.Contains(resp.Header.Get("Content-Type"), "text/plain") {
-		// error is from pprof endpoint
-		if body, err := ioutil.ReadAll(resp.Body); err == nil {
-			return fmt.Errorf("server response: %s - %s", resp.Status, body)
-		}
-	}
-	return fmt.Errorf("server response: %s", resp.Status)
-}
-
 // isPerfFile checks if a file is in perf.data format. It also returns false
 // if it encounters an error during the check.
 func isPerfFile(path string) bool {
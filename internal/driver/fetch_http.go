@@ -0,0 +1,250 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/plugin"
+)
+
+// fetchURL fetches a profile from a URL using HTTP.
+func fetchURL(source string, timeout time.Duration, tr http.RoundTripper) (io.ReadCloser, error) {
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   timeout + 5*time.Second,
+	}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, fmt.Errorf("http fetch: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusCodeError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+func statusCodeError(resp *http.Response) error {
+	if resp.Header.Get("X-Go-Pprof") != "" && strings.Contains(resp.Header.Get("Content-Type"), "text/plain") {
+		// error is from pprof endpoint
+		if body, err := ioutil.ReadAll(resp.Body); err == nil {
+			return fmt.Errorf("server response: %s - %s", resp.Status, body)
+		}
+	}
+	return fmt.Errorf("server response: %s", resp.Status)
+}
+
+// maxDownloadResumes is the number of times a download is resumed
+// without receiving any data before giving up.
+const maxDownloadResumes = 5
+
+// downloadProgressInterval is the interval between reports of the
+// progress of downloads.
+const downloadProgressInterval = 10 * time.Second
+
+// downloadResumeDelay is the delay before the first attempt to resume a
+// download, doubled on each further attempt.
+var downloadResumeDelay = time.Second
+
+// download is the body of a profile downloaded over HTTP. Its connection
+// fails if no data is received for the timeout, rather than when the
+// whole download takes longer than that, so that large profiles can be
+// downloaded over slow links. When its connection fails, the download is
+// resumed with a range request if the server supports them for the
+// profile, and its bandwidth is limited to a rate, if any.
+type download struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	ui        plugin.UI
+	timeout   time.Duration
+	rate      int64 // In bytes per second, or 0 for no limit.
+	validator string
+
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	read    int64
+	size    int64
+	resumes int
+
+	start, reported time.Time
+}
+
+// downloadURL downloads a profile from a URL using HTTP, resuming the
+// download if its connection fails and limiting it to rate kilobytes per
+// second, if positive. Canceling ctx cancels the download.
+func downloadURL(ctx context.Context, source string, timeout time.Duration, rate int, ui plugin.UI, tr http.RoundTripper) (io.ReadCloser, error) {
+	d := &download{
+		ctx:     ctx,
+		client:  &http.Client{Transport: tr},
+		url:     source,
+		ui:      ui,
+		timeout: timeout + 5*time.Second,
+		rate:    int64(rate) * 1024,
+		start:   time.Now(),
+	}
+	d.reported = d.start
+	resp, err := d.get("")
+	if err != nil {
+		return nil, fmt.Errorf("http fetch: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer d.Close()
+		return nil, statusCodeError(resp)
+	}
+	d.size = resp.ContentLength
+	// Only stored profiles, identified by their validator, can be resumed:
+	// a profile collected on request would differ on each request.
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		if d.validator = resp.Header.Get("ETag"); d.validator == "" {
+			d.validator = resp.Header.Get("Last-Modified")
+		}
+	}
+	return d, nil
+}
+
+// get requests the profile from offset start, if any, and makes the body
+// of the response that of the download.
+func (d *download) get(start string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if start != "" {
+		req.Header.Set("Range", "bytes="+start+"-")
+		req.Header.Set("If-Range", d.validator)
+	}
+	d.cancel = cancel
+	d.timer = time.AfterFunc(d.timeout, cancel)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		d.timer.Stop()
+		cancel()
+		return nil, err
+	}
+	d.body = resp.Body
+	return resp, nil
+}
+
+func (d *download) Read(b []byte) (int, error) {
+	if d.rate > 0 && int64(len(b)) > d.rate {
+		// Keep the pauses limiting the bandwidth short.
+		b = b[:d.rate]
+	}
+	for {
+		n, err := d.body.Read(b)
+		if n > 0 {
+			d.timer.Reset(d.timeout)
+			d.read += int64(n)
+			d.resumes = 0
+			d.throttle()
+			d.progress()
+		}
+		if err == nil || err == io.EOF || d.validator == "" || d.ctx.Err() != nil {
+			return n, err
+		}
+		if err := d.resume(err); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume resumes the download after its connection failed with cause.
+func (d *download) resume(cause error) error {
+	d.Close()
+	for ; d.resumes < maxDownloadResumes; d.resumes++ {
+		d.ui.PrintErr(fmt.Sprintf("Download of %s failed after %s: %v, resuming", d.url, d.amount(), cause))
+		select {
+		case <-time.After(downloadResumeDelay << uint(d.resumes)):
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+		resp, err := d.get(fmt.Sprint(d.read))
+		if err != nil {
+			cause = err
+			continue
+		}
+		want := fmt.Sprintf("bytes %d-", d.read)
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			d.Close()
+			return fmt.Errorf("http fetch: %s changed during its download", d.url)
+		case resp.StatusCode != http.StatusPartialContent:
+			defer d.Close()
+			return statusCodeError(resp)
+		case !strings.HasPrefix(resp.Header.Get("Content-Range"), want):
+			d.Close()
+			return fmt.Errorf("http fetch: got range %q, want %s...", resp.Header.Get("Content-Range"), want)
+		}
+		return nil
+	}
+	return fmt.Errorf("http fetch: %v", cause)
+}
+
+// throttle pauses the download for as long as it is ahead of its rate.
+func (d *download) throttle() {
+	if d.rate <= 0 {
+		return
+	}
+	due := time.Duration(float64(d.read) / float64(d.rate) * float64(time.Second))
+	if ahead := due - time.Since(d.start); ahead > 0 {
+		d.timer.Stop()
+		time.Sleep(ahead)
+		d.timer.Reset(d.timeout)
+	}
+}
+
+// progress reports the progress of the download periodically.
+func (d *download) progress() {
+	if time.Since(d.reported) < downloadProgressInterval {
+		return
+	}
+	d.reported = time.Now()
+	d.ui.PrintErr(fmt.Sprintf("Downloading %s: %s", d.url, d.amount()))
+}
+
+// amount describes the amount of data downloaded.
+func (d *download) amount() string {
+	read := measurement.ScaledLabel(d.read, "B", "auto")
+	if d.size <= 0 {
+		return read
+	}
+	return fmt.Sprintf("%s of %s (%s)", read, measurement.ScaledLabel(d.size, "B", "auto"),
+		strings.TrimSpace(measurement.Percentage(d.read, d.size)))
+}
+
+func (d *download) Close() error {
+	d.timer.Stop()
+	defer d.cancel()
+	return d.body.Close()
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/symbolizer"
+)
+
+func TestDownloadResume(t *testing.T) {
+	defer func(d time.Duration) { downloadResumeDelay = d }(downloadResumeDelay)
+	downloadResumeDelay = 0
+
+	data := make([]byte, 1<<16)
+	rand.Read(data)
+	for _, tc := range []struct {
+		desc    string
+		etags   []string
+		rate    int
+		wantErr string
+	}{
+		{desc: "resumed", etags: []string{`"v1"`, `"v1"`}},
+		{desc: "changed", etags: []string{`"v1"`, `"v2"`}, wantErr: "changed during its download"},
+		{desc: "throttled", etags: []string{`"v1"`, `"v1"`}, rate: 128},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", tc.etags[requests])
+				requests++
+				if requests == 1 {
+					// Drop the connection halfway through the profile.
+					w.Header().Set("Accept-Ranges", "bytes")
+					w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+					w.Write(data[:len(data)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			}))
+			defer server.Close()
+
+			start := time.Now()
+			ui := &proftest.TestUI{T: t, AllowRx: "failed after 32kB of 64kB \\(50.00%\\): .*, resuming"}
+			f, err := downloadURL(context.Background(), server.URL, time.Minute, tc.rate, ui, nil)
+			if err != nil {
+				t.Fatalf("downloadURL: %v", err)
+			}
+			defer f.Close()
+			got, err := ioutil.ReadAll(f)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading download: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %d bytes, want the %d bytes served", len(got), len(data))
+			}
+			if tc.rate > 0 {
+				if want := time.Duration(len(data)/1024/tc.rate) * time.Second * 9 / 10; time.Since(start) < want {
+					t.Errorf("downloaded %d bytes in %v at %dKB/s, want at least %v", len(data), time.Since(start), tc.rate, want)
+				}
+			}
+		})
+	}
+}
+
+// headerTransport serves the value of the Authorization header of the
+// requests it gets.
+type headerTransport struct{}
+
+func (headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(req.Header.Get("Authorization"))),
+	}, nil
+}
+
+func TestRequestDecorator(t *testing.T) {
+	token := "t1"
+	o := setDefaults(&plugin.Options{
+		HTTPTransport: headerTransport{},
+		RequestDecorator: func(req *http.Request) error {
+			if token == "" {
+				return errors.New("no token")
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		},
+	})
+	if s, ok := o.Sym.(*symbolizer.Symbolizer); !ok || s.Transport != o.HTTPTransport {
+		t.Errorf("symbolizer does not use the decorated transport")
+	}
+	// Defaulting the options again must not decorate the requests twice.
+	o = setDefaults(o)
+
+	for _, want := range []string{"Bearer t1", "Bearer t2"} {
+		f, err := fetchURL("http://example.com/profile", time.Second, o.HTTPTransport)
+		if err != nil {
+			t.Fatalf("fetchURL: %v", err)
+		}
+		got, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(got) != want {
+			t.Errorf("got Authorization %q, want %q", got, want)
+		}
+		token = "t2"
+	}
+	token = ""
+	if _, err := fetchURL("http://example.com/profile", time.Second, o.HTTPTransport); err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Errorf("got error %v, want the error of the decorator", err)
+	}
+}
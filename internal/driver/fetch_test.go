@@ -15,12 +15,14 @@
 package driver
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -185,7 +187,10 @@ func TestFetch(t *testing.T) {
 		{path + "go.nomappings.crash", "/bin/gotest.exe"},
 		{"http://localhost/profile?file=cppbench.cpu", ""},
 	} {
-		p, _, _, err := grabProfile(&source{ExecName: tc.execName}, tc.source, nil, testObj{}, &proftest.TestUI{T: t}, &httpTransport{})
+		if liteBuild() && strings.HasPrefix(tc.source, "http://") {
+			continue
+		}
+		p, _, _, err := grabProfile(context.Background(), &source{ExecName: tc.execName}, tc.source, nil, testObj{}, &proftest.TestUI{T: t}, &httpTransport{})
 		if err != nil {
 			t.Fatalf("%s: %s", tc.source, err)
 		}
@@ -203,6 +208,62 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+func TestFetchStrict(t *testing.T) {
+	// A profile with an unnamed sample type, which is renamed when
+	// parsing it.
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Unit: "count"}},
+		Sample:     []*profile.Sample{{Value: []int64{1}}},
+	}
+	f, err := ioutil.TempFile("", "profile_test")
+	if err != nil {
+		t.Fatalf("cannot create tempfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatalf("cannot write profile: %v", err)
+	}
+
+	ui := &proftest.TestUI{T: t, AllowRx: "Warning: .* contains unsupported data:\n  1 sample types without a name"}
+	got, _, _, err := grabProfile(context.Background(), &source{}, f.Name(), nil, testObj{}, ui, &httpTransport{})
+	if err != nil {
+		t.Fatalf("grabProfile: %v", err)
+	}
+	if ui.NumAllowRxMatches != 1 {
+		t.Errorf("got %d warnings, want 1", ui.NumAllowRxMatches)
+	}
+	if got.SampleType[0].Type != "unknown_0" {
+		t.Errorf("got sample type %q, want unknown_0", got.SampleType[0].Type)
+	}
+
+	_, _, _, err = grabProfile(context.Background(), &source{Strict: true}, f.Name(), nil, testObj{}, &proftest.TestUI{T: t}, &httpTransport{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported profile contents") {
+		t.Errorf("got error %v with -strict, want unsupported profile contents", err)
+	}
+}
+
+func TestKeepSampleFraction(t *testing.T) {
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}}}
+	for i := 0; i < 1000; i++ {
+		p.Sample = append(p.Sample, &profile.Sample{Value: []int64{3}})
+	}
+	keepSampleFraction(p, 0.1)
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[0]
+	}
+	if n := len(p.Sample); n < 50 || n > 150 {
+		t.Errorf("kept %d of 1000 samples, want about 100", n)
+	}
+	if total != int64(len(p.Sample))*30 {
+		t.Errorf("got total %d for %d samples, want their values scaled to 30", total, len(p.Sample))
+	}
+	if want := fmt.Sprintf("pprof: Kept %d of 1000 samples with -sample_fraction=0.1, scaling their values by 10", len(p.Sample)); len(p.Comments) != 1 || p.Comments[0] != want {
+		t.Errorf("got comments %q, want %q", p.Comments, want)
+	}
+}
+
 func TestFetchWithBase(t *testing.T) {
 	baseConfig := currentConfig()
 	defer setCurrentConfig(baseConfig)
@@ -446,7 +507,7 @@ func TestFetchWithBase(t *testing.T) {
 			f.args = tc.sources
 
 			o := setDefaults(&plugin.Options{
-				UI:            &proftest.TestUI{T: t, AllowRx: "Local symbolization failed|Some binary filenames not available"},
+				UI:            &proftest.TestUI{T: t, AllowRx: "Local symbolization failed|Some binary filenames not available|in the wrong order"},
 				Flagset:       f,
 				HTTPTransport: transport.New(nil),
 			})
@@ -467,7 +528,7 @@ func TestFetchWithBase(t *testing.T) {
 				t.Fatalf("got error %q, want no error", err)
 			}
 
-			p, err := fetchProfiles(src, o)
+			p, err := fetchProfiles(context.Background(), src, o)
 
 			if err != nil {
 				t.Fatalf("got error %q, want no error", err)
@@ -529,6 +590,9 @@ func TestHTTPSInsecure(t *testing.T) {
 	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
 		t.Skip("test assumes tcp available")
 	}
+	if liteBuild() {
+		t.Skip("fetching profiles over HTTP is not available in pprof-lite builds")
+	}
 	saveHome := os.Getenv(homeEnv())
 	tempdir, err := ioutil.TempDir("", "home")
 	if err != nil {
@@ -583,7 +647,7 @@ func TestHTTPSInsecure(t *testing.T) {
 		HTTPTransport: transport.New(nil),
 	}
 	o.Sym = &symbolizer.Symbolizer{Obj: o.Obj, UI: o.UI}
-	p, err := fetchProfiles(s, o)
+	p, err := fetchProfiles(context.Background(), s, o)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -602,6 +666,9 @@ func TestHTTPSWithServerCertFetch(t *testing.T) {
 	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
 		t.Skip("test assumes tcp available")
 	}
+	if liteBuild() {
+		t.Skip("fetching profiles over HTTP is not available in pprof-lite builds")
+	}
 	saveHome := os.Getenv(homeEnv())
 	tempdir, err := ioutil.TempDir("", "home")
 	if err != nil {
@@ -696,7 +763,7 @@ func TestHTTPSWithServerCertFetch(t *testing.T) {
 	}
 
 	o.Sym = &symbolizer.Symbolizer{Obj: o.Obj, UI: o.UI, Transport: o.HTTPTransport}
-	p, err := fetchProfiles(s, o)
+	p, err := fetchProfiles(context.Background(), s, o)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -754,3 +821,13 @@ func selfSignedCert(t *testing.T, host string) (tls.Certificate, []byte, []byte)
 	}
 	return cert, bc, bk
 }
+
+func TestFetchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t}, HTTPTransport: transport.New(nil)})
+	s := &source{Sources: []string{"testdata/cppbench.cpu"}, Seconds: -1, Timeout: -1}
+	if _, err := fetchProfiles(ctx, s, o); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchProfiles with a canceled context: got error %v, want %v", err, context.Canceled)
+	}
+}
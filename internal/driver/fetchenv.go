@@ -0,0 +1,132 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// fetchEnvTimeout is the timeout for scraping the command line and the
+// variables of the program serving a profile.
+const fetchEnvTimeout = 5 * time.Second
+
+// fetchEnvVars are the string variables of the program serving a profile
+// recorded in its comments, which describe its version. The others may
+// hold anything, such as secrets.
+var fetchEnvVars = map[string]bool{
+	"build":    true,
+	"commit":   true,
+	"revision": true,
+	"version":  true,
+}
+
+// fetchEnvComments returns the comments recording the fetch of a profile
+// from sourceURL, started at start and lasting elapsed, with the command
+// line and the version variables of the program serving it, scraped from
+// its /debug/pprof/cmdline and expvar /debug/vars handlers if it has them.
+// Canceling ctx cancels the scraping.
+func fetchEnvComments(ctx context.Context, sourceURL string, start time.Time, elapsed time.Duration, ui plugin.UI, tr http.RoundTripper) []string {
+	comments := []string{
+		"Fetched from: " + sourceURL,
+		fmt.Sprintf("Fetched at: %s in %v", start.Format(time.RFC3339), elapsed.Round(time.Millisecond)),
+		"Fetched by: pprof " + pprofVersion(),
+	}
+
+	var cmdline []string
+	if b, err := fetchDebugPage(ctx, sourceURL, "/debug/pprof/cmdline", ui, tr); err == nil {
+		cmdline = strings.Split(strings.TrimRight(string(b), "\x00\n"), "\x00")
+	}
+
+	var vars []string
+	if b, err := fetchDebugPage(ctx, sourceURL, "/debug/vars", ui, tr); err == nil {
+		var values map[string]json.RawMessage
+		if json.Unmarshal(b, &values) == nil {
+			if len(cmdline) == 0 {
+				json.Unmarshal(values["cmdline"], &cmdline)
+			}
+			for k, v := range values {
+				var s string
+				if fetchEnvVars[k] && json.Unmarshal(v, &s) == nil {
+					vars = append(vars, fmt.Sprintf("Var %s: %s", k, s))
+				}
+			}
+		}
+	}
+	if len(cmdline) > 0 && cmdline[0] != "" {
+		for i, arg := range cmdline {
+			if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+				cmdline[i] = strconv.Quote(arg)
+			}
+		}
+		comments = append(comments, "Command line: "+strings.Join(cmdline, " "))
+	}
+	sort.Strings(vars)
+	return append(comments, vars...)
+}
+
+// fetchDebugPage fetches the debug handler at path of the program
+// serving the profile at sourceURL, mounted along with its profile
+// handlers.
+func fetchDebugPage(ctx context.Context, sourceURL, path string, ui plugin.UI, tr http.RoundTripper) ([]byte, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if i := strings.Index(u.Path, "/debug/pprof/"); i >= 0 {
+		prefix = u.Path[:i]
+	}
+	u.Path, u.RawQuery = prefix+path, ""
+	ctx, cancel := context.WithTimeout(ctx, fetchEnvTimeout)
+	defer cancel()
+	f, err := downloadURL(ctx, u.String(), fetchEnvTimeout, 0, ui, tr)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	const maxSize = 1 << 20
+	b, err := ioutil.ReadAll(io.LimitReader(f, maxSize))
+	return bytes.TrimSpace(b), err
+}
+
+// pprofVersion returns the version of the pprof module, and of Go, of
+// the running binary.
+func pprofVersion() string {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&info.Main}, info.Deps...) {
+			if m.Path == "github.com/google/pprof" && m.Version != "" {
+				version = m.Version
+				break
+			}
+		}
+	}
+	return version + ", " + runtime.Version()
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestFetchEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		handlers map[string]string
+		want     []string
+	}{
+		{
+			name: "cmdline and vars",
+			handlers: map[string]string{
+				"/admin/debug/pprof/cmdline": "/bin/server\x00-port=8080\x00-name=a b",
+				"/admin/debug/vars":          `{"cmdline": ["/bin/other"], "version": "v1.2.3", "memstats": {"NumGC": 3}, "build": "abc", "db_password": "hunter2"}`,
+			},
+			want: []string{
+				`Command line: /bin/server -port=8080 "-name=a b"`,
+				"Var build: abc",
+				"Var version: v1.2.3",
+			},
+		},
+		{
+			name: "vars only",
+			handlers: map[string]string{
+				"/admin/debug/vars": `{"cmdline": ["/bin/server", "-v"]}`,
+			},
+			want: []string{"Command line: /bin/server -v"},
+		},
+		{
+			name: "no debug handlers",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/admin/debug/pprof/profile" {
+					makeFakeProfile().Write(w)
+					return
+				}
+				body, ok := tc.handlers[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			src := server.URL + "/admin/debug/pprof/profile?seconds=1"
+			s := &source{Seconds: -1, Timeout: 10, FetchEnv: true}
+			p, _, _, err := grabProfile(context.Background(), s, src, nil, fakeObjTool{}, &proftest.TestUI{T: t, AllowRx: "Fetching profile"}, nil)
+			if err != nil {
+				t.Fatalf("grabProfile: %v", err)
+			}
+			got := strings.Join(p.Comments, "\n")
+			for _, rx := range []string{
+				"Fetched from: " + regexp.QuoteMeta(src) + "\n",
+				`Fetched at: \d{4}-\d\d-\d\dT[^ ]+ in \d+(\.\d+)?m?s\n`,
+				"Fetched by: pprof .*, go",
+			} {
+				if !regexp.MustCompile(rx).MatchString(got) {
+					t.Errorf("comments do not match %q:\n%s", rx, got)
+				}
+			}
+			if want := strings.Join(tc.want, "\n"); !strings.HasSuffix(got, "\n"+want) && want != "" {
+				t.Errorf("got comments:\n%s\nwant them to end with:\n%s", got, want)
+			}
+			if len(p.Comments) != 3+len(tc.want) {
+				t.Errorf("got %d comments, want %d:\n%s", len(p.Comments), 3+len(tc.want), got)
+			}
+		})
+	}
+}
+
+func TestFetchEnvCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	// Canceling the fetch of the profile cancels the scraping, rather
+	// than waiting for its timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	comments := fetchEnvComments(ctx, server.URL+"/debug/pprof/profile", start, 0, &proftest.TestUI{T: t}, nil)
+	if elapsed := time.Since(start); elapsed >= fetchEnvTimeout {
+		t.Errorf("scraping took %v after canceling it, want less than its timeout %v", elapsed, fetchEnvTimeout)
+	}
+	if len(comments) != 3 {
+		t.Errorf("got comments %q, want only those of the fetch", comments)
+	}
+}
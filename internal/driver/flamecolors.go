@@ -0,0 +1,281 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// labelColorPrefix is the prefix of the color modes of the flame graph
+// coloring the frames by the value of a label, as in "label:thread".
+const labelColorPrefix = "label:"
+
+// frameGroups returns the group that the color mode colors each node of
+// the call tree g of rpt by, or nil for the default mode, "" or "name",
+// which colors the frames by the hash of their names. The modes are:
+//
+//	package	      the package of the function, as by packageName.
+//	mapping	      the binary or library of the function, the kernel
+//	              being a single group.
+//	kind	      the language or runtime of the function, as by frameKind.
+//	label:<key>   the value of the label key of most of the samples
+//	              going through the frame, for the function
+//	              granularities.
+//
+// The frames of no group are in group "".
+func frameGroups(rpt *report.Report, g *graph.Graph, mode string) (map[*graph.Node]string, error) {
+	var group func(n *graph.Node) string
+	switch mode {
+	case "", "name":
+		return nil, nil
+	case "package":
+		group = func(n *graph.Node) string {
+			return packageName(n.Info.Name)
+		}
+	case "mapping":
+		functions := profileFunctions(rpt.Profile())
+		group = func(n *graph.Node) string {
+			return mappingGroup(functions[n.Info.Name].mapping)
+		}
+	case "kind":
+		functions := profileFunctions(rpt.Profile())
+		group = func(n *graph.Node) string {
+			f := functions[n.Info.Name]
+			return frameKind(n.Info.Name, f.file, f.mapping)
+		}
+	default:
+		if !strings.HasPrefix(mode, labelColorPrefix) || mode == labelColorPrefix {
+			return nil, fmt.Errorf("unknown color mode %q", mode)
+		}
+		return labelGroups(rpt, g, strings.TrimPrefix(mode, labelColorPrefix)), nil
+	}
+	groups := make(map[*graph.Node]string, len(g.Nodes))
+	for _, n := range g.Nodes {
+		groups[n] = group(n)
+	}
+	return groups, nil
+}
+
+// functionInfo holds the source file and the mapping of a function.
+type functionInfo struct {
+	file    string
+	mapping *profile.Mapping
+}
+
+// profileFunctions returns the source file and the mapping of the
+// functions of p, by name, from the first location of each.
+func profileFunctions(p *profile.Profile) map[string]functionInfo {
+	functions := make(map[string]functionInfo)
+	for _, loc := range p.Location {
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				continue
+			}
+			if _, ok := functions[line.Function.Name]; !ok {
+				functions[line.Function.Name] = functionInfo{line.Function.Filename, loc.Mapping}
+			}
+		}
+	}
+	return functions
+}
+
+// isKernelMapping returns whether m maps the kernel, as named by perf,
+// or one of its virtual libraries.
+func isKernelMapping(m *profile.Mapping) bool {
+	return m != nil && (strings.HasPrefix(m.File, "[kernel") || strings.HasPrefix(m.File, "[vdso") ||
+		filepath.Base(m.File) == "vmlinux" || m.Start >= 1<<63)
+}
+
+// mappingGroup returns the group of the functions of the mapping m when
+// the flame graph is colored by mapping: the base name of its file, or
+// "kernel" for the kernel.
+func mappingGroup(m *profile.Mapping) string {
+	switch {
+	case m == nil:
+		return ""
+	case isKernelMapping(m):
+		return "kernel"
+	case m.File == "":
+		return "[unknown]"
+	}
+	return filepath.Base(m.File)
+}
+
+// frameKind returns the language or runtime of the function name, with
+// the source file file, in the mapping m: "kernel", "Go runtime", "Go",
+// "JVM", "Java", "Python", "JavaScript", "C++", "C", "libc" or "native",
+// or "" if it is not known.
+func frameKind(name, file string, m *profile.Mapping) string {
+	lib := ""
+	if m != nil {
+		lib = filepath.Base(m.File)
+	}
+	switch ext := filepath.Ext(file); {
+	case isKernelMapping(m):
+		return "kernel"
+	case ext == ".go" || strings.HasSuffix(file, ".s") && strings.HasPrefix(name, "runtime."):
+		if strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "runtime/") {
+			return "Go runtime"
+		}
+		return "Go"
+	case strings.HasPrefix(lib, "libjvm"):
+		return "JVM"
+	case ext == ".java" || ext == ".kt" || strings.HasPrefix(name, "java.") || strings.HasPrefix(name, "javax.") || strings.HasPrefix(name, "jdk."):
+		return "Java"
+	case ext == ".py" || strings.HasPrefix(lib, "libpython"):
+		return "Python"
+	case ext == ".js" || ext == ".ts" || ext == ".mjs":
+		return "JavaScript"
+	case strings.HasPrefix(lib, "libc.") || strings.HasPrefix(lib, "libc-") || strings.HasPrefix(lib, "libpthread") || strings.HasPrefix(lib, "ld-linux"):
+		return "libc"
+	case strings.Contains(name, "::") || ext == ".cc" || ext == ".cpp" || ext == ".cxx" || ext == ".hpp":
+		return "C++"
+	case ext == ".c":
+		return "C"
+	case lib != "":
+		return "native"
+	}
+	return ""
+}
+
+// labelTrie is the trie of the stacks of the samples of a profile, by
+// the names of their functions from the root, with the weight of the
+// samples going through each frame by the value of a label.
+type labelTrie struct {
+	children map[string]*labelTrie
+	weights  map[string]int64
+}
+
+func (t *labelTrie) child(name string) *labelTrie {
+	c := t.children[name]
+	if c == nil {
+		c = &labelTrie{children: make(map[string]*labelTrie), weights: make(map[string]int64)}
+		t.children[name] = c
+	}
+	return c
+}
+
+// value returns the value of the label with the largest weight at t, the
+// smallest of those with the same weight, or "" if there is none.
+func (t *labelTrie) value() string {
+	best := ""
+	var bestWeight int64
+	for v, w := range t.weights {
+		if w > bestWeight || w == bestWeight && v < best {
+			best, bestWeight = v, w
+		}
+	}
+	return best
+}
+
+// labelGroups returns the value of the label key of most of the samples
+// going through each node of the call tree g of rpt, matched to the
+// stacks of the samples of the profile of rpt by the names of their
+// functions.
+func labelGroups(rpt *report.Report, g *graph.Graph, key string) map[*graph.Node]string {
+	p := rpt.Profile()
+	index := len(p.SampleType) - 1
+	for i, st := range p.SampleType {
+		if st.Type == rpt.SampleType() {
+			index = i
+		}
+	}
+	root := &labelTrie{children: make(map[string]*labelTrie)}
+	for _, s := range p.Sample {
+		if index < 0 || index >= len(s.Value) {
+			break
+		}
+		value := ""
+		if v := s.Label[key]; len(v) > 0 {
+			value = v[0]
+		} else if v := s.NumLabel[key]; len(v) > 0 {
+			value = strconv.FormatInt(v[0], 10)
+		}
+		if value == "" {
+			continue
+		}
+		w := s.Value[index]
+		if w < 0 {
+			w = -w
+		}
+		t := root
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			lines := s.Location[i].Line
+			for j := len(lines) - 1; j >= 0; j-- {
+				if lines[j].Function == nil {
+					continue
+				}
+				t = t.child(lines[j].Function.Name)
+				t.weights[value] += w
+			}
+		}
+	}
+
+	groups := make(map[*graph.Node]string, len(g.Nodes))
+	var visit func(n *graph.Node, t *labelTrie)
+	visit = func(n *graph.Node, t *labelTrie) {
+		if t != nil {
+			t = t.children[n.Info.Name]
+		}
+		if t != nil {
+			groups[n] = t.value()
+		} else {
+			groups[n] = ""
+		}
+		for child := range n.Out {
+			visit(child, t)
+		}
+	}
+	for _, n := range g.Nodes {
+		if len(n.In) == 0 {
+			visit(n, root)
+		}
+	}
+	return groups
+}
+
+// profileLabelKeys returns the sorted keys of the labels of the samples
+// of p, which the flame graph can be colored by.
+func profileLabelKeys(p *profile.Profile) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, s := range p.Sample {
+		for k := range s.Label {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		for k := range s.NumLabel {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestFrameKind(t *testing.T) {
+	bin := &profile.Mapping{File: "/usr/bin/server"}
+	for _, tc := range []struct {
+		name, file string
+		m          *profile.Mapping
+		want       string
+	}{
+		{"main.main", "/src/main.go", bin, "Go"},
+		{"runtime.mallocgc", "/go/src/runtime/malloc.go", bin, "Go runtime"},
+		{"runtime.memmove", "/go/src/runtime/memmove_amd64.s", bin, "Go runtime"},
+		{"com.example.Server.handle", "Server.java", nil, "Java"},
+		{"JVM_Sleep", "", &profile.Mapping{File: "/usr/lib/jvm/lib/server/libjvm.so"}, "JVM"},
+		{"handle_request", "app.py", nil, "Python"},
+		{"memcpy", "", &profile.Mapping{File: "/lib/x86_64-linux-gnu/libc.so.6"}, "libc"},
+		{"std::vector<int>::push_back", "", bin, "C++"},
+		{"compress", "zlib.c", bin, "C"},
+		{"do_syscall_64", "", &profile.Mapping{File: "[kernel.kallsyms]"}, "kernel"},
+		{"0x1234", "", bin, "native"},
+		{"0x1234", "", nil, ""},
+	} {
+		if got := frameKind(tc.name, tc.file, tc.m); got != tc.want {
+			t.Errorf("frameKind(%q, %q) = %q, want %q", tc.name, tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestFlameGraphColors(t *testing.T) {
+	p := makeFakeProfile()
+	// F1 and F2 run mostly for the main thread, F3 only for the worker.
+	p.Sample[0].Label = map[string][]string{"thread": {"worker"}}
+	p.Sample[1].Label = map[string][]string{"thread": {"main"}}
+	cfg := currentConfig()
+	cfg.CallTree = true
+	cfg.Trim = false
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: &httpTransport{},
+	})
+	_, rpt, err := generateRawReport(context.Background(), p, []string{"svg"}, cfg, o)
+	if err != nil {
+		t.Fatalf("generateRawReport: %v", err)
+	}
+
+	for _, tc := range []struct {
+		color string
+		want  map[string]string
+	}{
+		{"mapping", map[string]string{"F1": "testbin", "F2": "testbin", "F3": "testbin"}},
+		{"label:thread", map[string]string{"F1": "main", "F2": "main", "F3": "worker"}},
+		{"label:missing", map[string]string{"F1": "", "F2": "", "F3": ""}},
+	} {
+		root, _, _, err := colorFlameGraphTree(rpt, tc.color)
+		if err != nil {
+			t.Errorf("%s: %v", tc.color, err)
+			continue
+		}
+		got := make(map[string]string)
+		var visit func(n *treeNode)
+		visit = func(n *treeNode) {
+			if n.Group != nil {
+				got[n.Name] = *n.Group
+			}
+			for _, c := range n.Children {
+				visit(c)
+			}
+		}
+		visit(root)
+		for name, want := range tc.want {
+			if got[name] != want {
+				t.Errorf("%s: %s is in group %q, want %q", tc.color, name, got[name], want)
+			}
+		}
+	}
+
+	if _, _, _, err := colorFlameGraphTree(rpt, "bogus"); err == nil {
+		t.Error("colorFlameGraphTree succeeded with an unknown color mode")
+	}
+	if root, _, _, err := colorFlameGraphTree(rpt, "name"); err != nil || root.Children[0].Group != nil {
+		t.Errorf("frames colored by name have groups, or error %v", err)
+	}
+}
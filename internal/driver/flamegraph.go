@@ -12,19 +12,31 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !pprof_lite
+// +build !pprof_lite
+
 package driver
 
 import (
 	"encoding/json"
 	"html/template"
+	"io"
 	"net/http"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/pprof/internal/graph"
 	"github.com/google/pprof/internal/measurement"
 	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/third_party/d3"
+	"github.com/google/pprof/third_party/d3flamegraph"
 )
 
+// treeNode is a node of the stack tree of a flame graph. Its JSON
+// encoding is served by /api/flamegraph, written by the flamegraphjson
+// command and consumed by the renderer in flamegraph.js, so its field
+// names must not change.
 type treeNode struct {
 	Name      string      `json:"n"`
 	FullName  string      `json:"f"`
@@ -32,22 +44,169 @@ type treeNode struct {
 	CumFormat string      `json:"l"`
 	Percent   string      `json:"p"`
 	Children  []*treeNode `json:"c"`
+
+	// Delta and DeltaFormat are the change of Cum since the base
+	// profiles of a differential profile, and are only set for those.
+	Delta       *int64 `json:"d,omitempty"`
+	DeltaFormat string `json:"dl,omitempty"`
+
+	// Badges is the external metadata of the function, as key=value
+	// pairs, set with -metadata.
+	Badges []string `json:"b,omitempty"`
+
+	// Group is the group the frame is colored by, as by frameGroups, set
+	// for the flame graphs not colored by name.
+	Group *string `json:"k,omitempty"`
 }
 
 // flamegraph generates a web page containing a flamegraph.
 func (ui *webInterface) flamegraph(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeFlameGraphReport(w, req)
+	if rpt == nil {
+		return // error already reported
+	}
+	color := req.URL.Query().Get("color")
+	rootNode, nodeArr, legend, err := colorFlameGraphTree(rpt, color)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	// JSON marshalling flame graph
+	b, err := json.Marshal(rootNode)
+	if err != nil {
+		http.Error(w, "error serializing flame graph", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	ui.render(w, req, "flamegraph", rpt, errList, legend, webArgs{
+		FlameGraph:      template.JS(b),
+		Nodes:           nodeArr,
+		FlameGraphColor: color,
+		LabelKeys:       profileLabelKeys(ui.tab(req).prof),
+	})
+}
+
+// printFlameGraphHTML writes the flame graph of rpt as a single HTML
+// page, with the scripts and styles it needs inlined, so that it can be
+// viewed without a pprof server.
+func printFlameGraphHTML(w io.Writer, rpt *report.Report) error {
+	rootNode, _, legend := flameGraphTree(rpt)
+	b, err := json.Marshal(rootNode)
+	if err != nil {
+		return err
+	}
+	templates := template.New("templategroup")
+	addTemplates(templates)
+	file := getFromLegend(legend, "File: ", "unknown")
+	profile := getFromLegend(legend, "Type: ", "unknown")
+	return templates.ExecuteTemplate(w, "flamegraphfile", webArgs{
+		Title:      file + " " + profile,
+		Legend:     legend,
+		FlameGraph: template.JS(b),
+	})
+}
+
+// printFlameGraphJSON writes the stack tree of the flame graph of rpt as
+// JSON, as served by /api/flamegraph.
+func printFlameGraphJSON(w io.Writer, rpt *report.Report) error {
+	rootNode, _, _ := flameGraphTree(rpt)
+	return json.NewEncoder(w).Encode(rootNode)
+}
+
+// flamegraphJSON serves the stack tree of the flame graph as JSON, for
+// use by other pages embedding the renderer in flamegraph.js.
+func (ui *webInterface) flamegraphJSON(w http.ResponseWriter, req *http.Request) {
+	rpt, _ := ui.makeFlameGraphReport(w, req)
+	if rpt == nil {
+		return // error already reported
+	}
+	rootNode, _, _, err := colorFlameGraphTree(rpt, req.URL.Query().Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	b, err := json.Marshal(rootNode)
+	if err != nil {
+		http.Error(w, "error serializing flame graph", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// flamegraphScript serves the flame graph renderer, along with the d3
+// libraries it uses, as a standalone script.
+func flamegraphScript(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	for _, src := range []string{d3.JSSource, d3flamegraph.JSSource, flameGraphRendererJS} {
+		io.WriteString(w, src)
+		io.WriteString(w, "\n")
+	}
+}
+
+// flamegraphStyle serves the style sheet of the flame graph renderer.
+func flamegraphStyle(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/css")
+	io.WriteString(w, d3flamegraph.CSSSource)
+}
+
+// makeFlameGraphReport generates the report for a flame graph.
+func (ui *webInterface) makeFlameGraphReport(w http.ResponseWriter, req *http.Request) (*report.Report, []string) {
 	// Force the call tree so that the graph is a tree.
 	// Also do not trim the tree so that the flame graph contains all functions.
-	rpt, errList := ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
+	return ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
 		cfg.CallTree = true
 		cfg.Trim = false
 	})
-	if rpt == nil {
-		return // error already reported
-	}
+}
+
+// flameGraphTree returns the root of the stack tree of the flame graph
+// of rpt, the names of its nodes and the legend of the report. The flame
+// graph of a differential profile shows the new profiles, with the change
+// of each frame since the base profiles.
+func flameGraphTree(rpt *report.Report) (*treeNode, []string, []string) {
+	rootNode, nodeArr, legend, _ := colorFlameGraphTree(rpt, "")
+	return rootNode, nodeArr, legend
+}
 
-	// Generate dot graph.
+// colorFlameGraphTree returns the stack tree of the flame graph of rpt,
+// as flameGraphTree does, with the groups of its frames in the color mode
+// color, as by frameGroups.
+func colorFlameGraphTree(rpt *report.Report, color string) (*treeNode, []string, []string, error) {
+	var base map[string]int64
+	if brpt, crpt := report.SplitDiffBase(rpt); brpt != nil {
+		bg, _ := report.GetDOT(brpt)
+		base = make(map[string]int64)
+		for n, path := range stackPaths(bg) {
+			base[path] += n.CumValue()
+			if len(n.In) == 0 {
+				base[""] += n.CumValue()
+			}
+		}
+		rpt = crpt
+	}
 	g, config := report.GetDOT(rpt)
+	groups, err := frameGroups(rpt, g, color)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	paths := stackPaths(g)
+	setDelta := func(node *treeNode, path string) {
+		if base == nil {
+			return
+		}
+		d := node.Cum - base[path]
+		node.Delta = &d
+		node.DeltaFormat = config.FormatValue(d)
+		if d > 0 {
+			node.DeltaFormat = "+" + node.DeltaFormat
+		}
+	}
 	var nodes []*treeNode
 	nroots := 0
 	rootValue := int64(0)
@@ -57,12 +216,22 @@ func (ui *webInterface) flamegraph(w http.ResponseWriter, req *http.Request) {
 	for _, n := range g.Nodes {
 		v := n.CumValue()
 		fullName := n.Info.PrintableName()
+		name := graph.ShortenFunctionName(fullName)
+		if n.Info.Name == "" && n.Info.File != "" && n.Info.Lineno == 0 {
+			name = fileFrameName(n.Info.File)
+		}
 		node := &treeNode{
-			Name:      graph.ShortenFunctionName(fullName),
+			Name:      graph.TruncateName(name, config.MaxNameLen),
 			FullName:  fullName,
 			Cum:       v,
 			CumFormat: config.FormatValue(v),
 			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
+			Badges:    rpt.Badges(n.Info.Name),
+		}
+		setDelta(node, paths[n])
+		if groups != nil {
+			group := groups[n]
+			node.Group = &group
 		}
 		nodes = append(nodes, node)
 		if len(n.In) == 0 {
@@ -90,17 +259,274 @@ func (ui *webInterface) flamegraph(w http.ResponseWriter, req *http.Request) {
 		Percent:   strings.TrimSpace(measurement.Percentage(rootValue, config.Total)),
 		Children:  nodes[0:nroots],
 	}
+	setDelta(rootNode, "")
+	return rootNode, nodeArr, config.Labels, nil
+}
 
-	// JSON marshalling flame graph
-	b, err := json.Marshal(rootNode)
-	if err != nil {
-		http.Error(w, "error serializing flame graph", http.StatusInternalServerError)
-		ui.options.UI.PrintErr(err)
-		return
+// fileFrameName returns the name of the frame of the source file in the
+// flame graphs of the files granularity: its base name with that of its
+// directory, which tell most files of a codebase apart.
+func fileFrameName(file string) string {
+	file = filepath.ToSlash(file)
+	if dir := path.Base(path.Dir(file)); dir != "." && dir != "/" {
+		return dir + "/" + path.Base(file)
 	}
+	return path.Base(file)
+}
 
-	ui.render(w, req, "flamegraph", rpt, errList, config.Labels, webArgs{
-		FlameGraph: template.JS(b),
-		Nodes:      nodeArr,
-	})
+// stackPaths returns the stack of each node of the call tree g, as the
+// names of its frames from the root, to match the nodes of the call
+// trees of two profiles.
+func stackPaths(g *graph.Graph) map[*graph.Node]string {
+	paths := make(map[*graph.Node]string)
+	var path func(n *graph.Node) string
+	path = func(n *graph.Node) string {
+		if p, ok := paths[n]; ok {
+			return p
+		}
+		p := n.Info.PrintableName()
+		for parent := range n.In {
+			p = path(parent) + "\n" + p
+			break
+		}
+		paths[n] = p
+		return p
+	}
+	for _, n := range g.Nodes {
+		path(n)
+	}
+	return paths
+}
+
+// flameGraphRendererJS defines pprofFlameGraph, which renders a stack
+// tree, as served by /api/flamegraph, in a container element. It is
+// included in the flame graph page and in flamegraph.js.
+const flameGraphRendererJS = `
+// pprofFlameGraph renders the flame graph of the stack tree data in the
+// container element, showing the details of the frame under the mouse in
+// the details element, if any. The root is at the top, unless upwards is
+// set, as for the callers of a function, drawn upwards from it, and for
+// the flame graphs drawn as flames rather than icicles. It returns the d3
+// flame graph, to be used for searching and zooming.
+function pprofFlameGraph(container, details, data, upwards) {
+  var flameGraph = d3.flamegraph()
+    .width(container.clientWidth)
+    .cellHeight(18)
+    .minFrameSize(1)
+    .transitionDuration(750)
+    .transitionEase(d3.easeCubic)
+    .inverted(!upwards)
+    .sort(true)
+    .title('')
+    .tooltip(false)
+    .details(details);
+
+  // <full name> (percentage, value[, change])[ [metadata]]
+  flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l +
+    (d.data.dl ? ', ' + d.data.dl : '') + ')' +
+    (d.data.b ? ' [' + d.data.b.join(' ') + ']' : ''));
+
+  // The frames of a differential profile are colored by their change,
+  // red for regressions and green for improvements, the deeper the
+  // larger the change relative to the largest one.
+  var maxDelta = 0;
+  (function visit(nodes) {
+    for (const n of nodes || []) {
+      maxDelta = Math.max(maxDelta, Math.abs(n.d || 0));
+      visit(n.c);
+    }
+  })(data.c);
+
+  // The frames of a flame graph colored by group, as by package, are
+  // colored by the hash of their group instead of that of their name.
+  var oldColorMapper = flameGraph.color();
+  flameGraph.color(function(d) {
+    const { data, highlight } = d;
+    if (data.d !== undefined && !highlight) {
+      return diffColor(data.d, maxDelta);
+    }
+    if (data.k !== undefined && !highlight) {
+      return groupColor(data.k);
+    }
+    // Hack to force default color mapper to use 'warm' color scheme by not passing libtype
+    return oldColorMapper({ data: { n: data.n }, highlight });
+  });
+
+  d3.select(container)
+    .datum(data)
+    .call(flameGraph);
+  return flameGraph;
+}
+
+// pprofFlameGraphKeys lets the keyboard select the frames of the flame
+// graph drawn in container, showing the selected one in details: the
+// arrows move to its caller or its largest callee, and to the frames
+// beside it, Enter zooms on it and Escape resets the zoom. F calls
+// onFocus, if set, with the full name of the selected frame.
+function pprofFlameGraphKeys(container, flameGraph, details, onFocus) {
+  const root = d3.select(container).datum();
+  let selected = null;
+
+  // visible returns whether d is drawn, in the current zoom.
+  function visible(d) {
+    return d.x1 > d.x0;
+  }
+
+  function select(d) {
+    if (!d) return;
+    selected = d;
+    d3.select(container).selectAll('g').classed('selected', (n) => n === d);
+    if (details) {
+      details.textContent = flameGraph.label()(d);
+    }
+  }
+
+  // widest returns the visible frame of nodes with the largest value.
+  function widest(nodes) {
+    let best = null;
+    for (const n of nodes || []) {
+      if (visible(n) && (!best || n.value > best.value)) best = n;
+    }
+    return best;
+  }
+
+  // beside returns the visible frame next to d at its depth, on the left
+  // if dir is negative and on the right otherwise.
+  function beside(d, dir) {
+    const row = root.descendants()
+      .filter((n) => n.depth == d.depth && visible(n))
+      .sort((a, b) => a.x0 - b.x0);
+    return row[row.indexOf(d) + dir];
+  }
+
+  container.setAttribute('tabindex', '0');
+  document.addEventListener('keydown', function(e) {
+    if (e.ctrlKey || e.metaKey || e.altKey) return;
+    const tag = e.target.tagName;
+    if (tag == 'INPUT' || tag == 'TEXTAREA' || tag == 'SELECT') return;
+
+    // The callees are below the frames, unless the flame graph is drawn
+    // upwards.
+    const down = flameGraph.inverted() ? 'ArrowDown' : 'ArrowUp';
+    const up = flameGraph.inverted() ? 'ArrowUp' : 'ArrowDown';
+    const d = selected || root;
+    switch (e.key) {
+      case down:
+        select(selected ? widest(d.children) : root);
+        break;
+      case up:
+        select(d.parent || d);
+        break;
+      case 'ArrowLeft':
+        select(beside(d, -1) || d);
+        break;
+      case 'ArrowRight':
+        select(beside(d, 1) || d);
+        break;
+      case 'Enter':
+        flameGraph.zoomTo(d);
+        select(d);
+        break;
+      case 'Escape':
+        flameGraph.resetZoom();
+        select(selected);
+        break;
+      case 'f':
+      case 'F':
+        if (!onFocus || !selected) return;
+        onFocus(selected.data.f);
+        break;
+      default:
+        return;
+    }
+    e.preventDefault();
+  });
+}
+
+// pprofFramePath returns the path of the frame d of a flame graph from
+// its root, as the full names of the frames below the root separated by
+// semicolons, to find it again with pprofFindFrame.
+function pprofFramePath(d) {
+  const names = [];
+  for (let n = d; n.parent; n = n.parent) {
+    names.unshift(n.data.f);
+  }
+  return names.join(';');
+}
+
+// pprofFindFrame returns the frame of the flame graph rooted at root
+// whose path is path, as returned by pprofFramePath, or null if there is
+// no such frame.
+function pprofFindFrame(root, path) {
+  let d = root;
+  for (const name of path.split(';')) {
+    d = (d.children || []).find((c) => c.data.f == name);
+    if (!d) return null;
+  }
+  return d;
+}
+
+// pprofMatchedValue returns the sum of the values of the frames found by
+// the search of a flame graph, leaving out those within other found frames
+// so that the stacks going through several of them count once.
+function pprofMatchedValue(results) {
+  const found = new Set(results);
+  let sum = 0;
+  for (const d of results) {
+    let outermost = true;
+    for (let p = d.parent; p && outermost; p = p.parent) {
+      outermost = !found.has(p);
+    }
+    if (outermost) {
+      sum += d.data.v;
+    }
+  }
+  return sum;
+}
+
+// groupColors are the colors of the groups of frames of a flame graph
+// colored by group, after ColorBrewer's Set3.
+const groupColors = ['#8dd3c7', '#fdb462', '#bebada', '#fb8072', '#80b1d3',
+  '#ffffb3', '#b3de69', '#fccde5', '#bc80bd', '#ccebc5', '#ffed6f'];
+
+// groupColor returns the color of the frames of the group k, grey for
+// the frames of no group.
+function groupColor(k) {
+  if (k == '') {
+    return 'rgb(220,220,220)';
+  }
+  let hash = 0;
+  for (let i = 0; i < k.length; i++) {
+    hash = (hash * 31 + k.charCodeAt(i)) | 0;
+  }
+  return groupColors[Math.abs(hash) % groupColors.length];
+}
+
+// pprofGroupLegend returns the groups of the frames of the stack tree
+// data colored by group, with their colors and the sum of the values of
+// their own samples, the largest first.
+function pprofGroupLegend(data) {
+  const values = new Map();
+  (function visit(nodes) {
+    for (const n of nodes || []) {
+      if (n.k === undefined) continue;
+      let self = n.v;
+      for (const c of n.c || []) self -= c.v;
+      values.set(n.k, (values.get(n.k) || 0) + self);
+      visit(n.c);
+    }
+  })(data.c);
+  return Array.from(values, ([k, v]) => ({ name: k, color: groupColor(k), value: v }))
+    .sort((a, b) => b.value - a.value);
+}
+
+// diffColor returns the color of a frame of a differential flame graph
+// whose value changed by delta, the largest change being maxDelta.
+function diffColor(delta, maxDelta) {
+  if (delta == 0 || maxDelta == 0) {
+    return 'rgb(220,220,220)';
+  }
+  const c = Math.round(220 - 180 * Math.min(1, Math.abs(delta) / maxDelta));
+  return delta > 0 ? 'rgb(255,' + c + ',' + c + ')' : 'rgb(' + c + ',255,' + c + ')';
 }
+`
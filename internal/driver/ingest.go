@@ -0,0 +1,298 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// maxIngestSize is the maximum size of the profiles pushed to the web
+// interface.
+const maxIngestSize = 256 << 20
+
+// ingestName matches the name of a pushed profile, with the application
+// and its labels, eg "app.cpu{env=staging,region=us-east1}".
+var ingestName = regexp.MustCompile(`^([^{}]*)(?:\{([^{}]*)\})?$`)
+
+// ingest implements /ingest, which receives the profiles pushed by
+// continuous profiling agents, following the ingestion API of Pyroscope:
+// the profile is POSTed as the body of the request, or as the "profile"
+// file of a multipart form, in the pprof, folded or JFR format, and the
+// query describes it with parameters such as
+// "name=app.cpu{env=staging}&from=1600000000&until=1600000010". The format
+// is detected from the profile, so the format parameter is ignored.
+//
+// The samples of the pushed profile get an app label with the name of
+// the application, and a label for each label of its name. The profile
+// is merged into the profile served, so that the profiles pushed at
+// regular intervals by an agent accumulate, or replaces it if they are
+// not compatible, as when their sample types differ.
+func (ui *webInterface) ingest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "profiles must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "profiles are only accepted from agents and the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	p, name, err := readPushedProfile(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr("Rejecting pushed profile: ", err)
+		return
+	}
+
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	tab := ui.tabs[0]
+	merged, err := profile.Merge([]*profile.Profile{tab.prof, p})
+	if err != nil {
+		ui.options.UI.Print(fmt.Sprintf("Serving the profile pushed as %s, which cannot be merged into the previous profile: %v", name, err))
+		merged = p
+	}
+	ui.tabs[0] = tab.with(merged)
+}
+
+// open implements /open, which opens the profile POSTed as the body of
+// the request, or as the "profile" file of a multipart form, in a new
+// tab, named by the name parameter or the name of the file, and
+// redirects to it.
+func (ui *webInterface) open(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "profiles must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "profiles are only accepted from agents and the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	name := req.URL.Query().Get("name")
+	req.Body = http.MaxBytesReader(w, req.Body, maxIngestSize)
+	var r io.Reader = req.Body
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		f, h, err := req.FormFile("profile")
+		if err != nil {
+			http.Error(w, "form has no profile file", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		r = f
+		if name == "" {
+			name = h.Filename
+		}
+	}
+	p, err := profile.Parse(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr("Rejecting opened profile: ", err)
+		return
+	}
+	if name == "" {
+		name = "profile"
+	}
+
+	ui.mu.Lock()
+	ui.tabs = append(ui.tabs, newProfileTab(name, p))
+	i := len(ui.tabs) - 1
+	ui.mu.Unlock()
+	// The location is relative to that of the request, as the web
+	// interface may not be served at the root.
+	w.Header().Set("Location", "./?tab="+strconv.Itoa(i))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// compare implements /compare, which opens the comparison of two profiles
+// in a new tab, as -diff_base does, and redirects to it. The profiles are
+// given by the multipart form POSTed: the source and base fields select
+// the tabs of the new and the base profiles, unless the source_file and
+// base_file files are uploaded instead. The mode field is "diff", or
+// "relative" to scale the new profile to the total of the base profile
+// first, as -normalize does.
+func (ui *webInterface) compare(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "profiles to compare must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "profiles are only compared for the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, 2*maxIngestSize)
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "expected a multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var normalize bool
+	switch mode := req.FormValue("mode"); mode {
+	case "", "diff":
+	case "relative":
+		normalize = true
+	default:
+		http.Error(w, fmt.Sprintf("unknown comparison mode %q, want diff or relative", mode), http.StatusBadRequest)
+		return
+	}
+	p, name, err := ui.comparedProfile(req, "source")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	base, baseName, err := ui.comparedProfile(req, "base")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err = diffProfiles(p, base, normalize, ui.options.UI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr("Rejecting compared profiles: ", err)
+		return
+	}
+	name += " vs " + baseName
+	if normalize {
+		name += " (relative)"
+	}
+
+	ui.mu.Lock()
+	ui.tabs = append(ui.tabs, newProfileTab(name, p))
+	i := len(ui.tabs) - 1
+	ui.mu.Unlock()
+	w.Header().Set("Location", "./?tab="+strconv.Itoa(i))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// comparedProfile returns a copy of the profile of the comparison req
+// given by its field, along with its name: the file uploaded as
+// field_file, or else the profile of the tab of the index in field.
+func (ui *webInterface) comparedProfile(req *http.Request, field string) (*profile.Profile, string, error) {
+	if f, h, err := req.FormFile(field + "_file"); err == nil {
+		defer f.Close()
+		p, err := profile.Parse(f)
+		if err != nil {
+			return nil, "", fmt.Errorf("parsing %s profile %s: %v", field, h.Filename, err)
+		}
+		return p, h.Filename, nil
+	}
+	i, err := strconv.Atoi(req.FormValue(field))
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if err != nil || i < 0 || i >= len(ui.tabs) {
+		return nil, "", fmt.Errorf("no %s profile: select its tab or upload its file", field)
+	}
+	name := ui.tabs[i].name
+	if name == "" {
+		name = "tab " + strconv.Itoa(i)
+	}
+	return ui.tabs[i].prof.Copy(), name, nil
+}
+
+// diffProfiles returns the comparison of the profile p with the profile
+// base, as made by -diff_base, scaling p to the total of base first if
+// normalize is set, as -normalize does. It modifies both profiles.
+func diffProfiles(p, base *profile.Profile, normalize bool, ui plugin.UI) (*profile.Profile, error) {
+	if err := reconcileSampleTypes(p, base, nil, ui); err != nil {
+		return nil, err
+	}
+	base.SetLabel("pprof::base", []string{"true"})
+	if normalize {
+		if err := p.Normalize(base); err != nil {
+			return nil, err
+		}
+		audit(p, "Scaled the source profiles to the total of the base profiles with -normalize")
+	}
+	base.Scale(-1)
+	d, err := profile.Merge([]*profile.Profile{p, base})
+	if err != nil {
+		return nil, err
+	}
+	audit(d, "Subtracted the base profiles with -diff_base, labeling their samples pprof::base")
+	return d, nil
+}
+
+// readPushedProfile reads the profile pushed by req, with the labels of
+// its name, and returns it along with its name.
+func readPushedProfile(w http.ResponseWriter, req *http.Request) (*profile.Profile, string, error) {
+	query := req.URL.Query()
+	name := query.Get("name")
+	m := ingestName.FindStringSubmatch(name)
+	if m == nil || m[1] == "" {
+		return nil, "", fmt.Errorf("malformed profile name %q, want app[{key=value,...}]", name)
+	}
+	labels := map[string]string{"app": m[1]}
+	if m[2] != "" {
+		for _, kv := range strings.Split(m[2], ",") {
+			kv := strings.SplitN(kv, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, "", fmt.Errorf("malformed labels of profile name %q", name)
+			}
+			labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxIngestSize)
+	var r io.Reader = req.Body
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		if err := req.ParseMultipartForm(maxIngestSize); err != nil {
+			return nil, "", fmt.Errorf("malformed form: %v", err)
+		}
+		f, _, err := req.FormFile("profile")
+		if err != nil {
+			return nil, "", errors.New("form has no profile file")
+		}
+		defer f.Close()
+		r = f
+	}
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, s := range p.Sample {
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		for k, v := range labels {
+			if len(s.Label[k]) == 0 {
+				s.Label[k] = []string{v}
+			}
+		}
+	}
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	until, _ := strconv.ParseInt(query.Get("until"), 10, 64)
+	if p.TimeNanos == 0 && from > 0 {
+		p.TimeNanos = from * int64(time.Second)
+	}
+	if p.DurationNanos == 0 && until > from && from > 0 {
+		p.DurationNanos = (until - from) * int64(time.Second)
+	}
+	return p, name, nil
+}
@@ -15,12 +15,14 @@
 package driver
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/report"
@@ -31,7 +33,7 @@ var commentStart = "//:" // Sentinel for comments on options
 var tailDigitsRE = regexp.MustCompile("[0-9]+$")
 
 // interactive starts a shell to read pprof commands.
-func interactive(p *profile.Profile, o *plugin.Options) error {
+func interactive(ctx context.Context, p *profile.Profile, o *plugin.Options) error {
 	// Enter command processing loop.
 	o.UI.SetAutoComplete(newCompleter(functionNames(p)))
 	configure("compact_labels", "true")
@@ -110,7 +112,12 @@ func interactive(p *profile.Profile, o *plugin.Options) error {
 
 			args, cfg, err := parseCommandLine(tokens)
 			if err == nil {
-				err = generateReportWrapper(p, args, cfg, o)
+				recordEvent(o, "report", args[0])
+				start := time.Now()
+				ictx, stop := interruptible(ctx)
+				err = generateReportWrapper(ictx, p, args, cfg, o)
+				stop()
+				recordDuration(o, "report "+args[0], start)
 			}
 
 			if err != nil {
@@ -0,0 +1,133 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// loadLabelNames loads the files given by the value of -label_names, a list
+// of key=file pairs, and returns a map from numeric label keys to the names
+// of their values.
+func loadLabelNames(s string) (map[string]map[int64]string, error) {
+	names := make(map[string]map[int64]string)
+	if s == "" {
+		return names, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("malformed -label_names %q, want key=file,...", s)
+		}
+		key, file := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseLabelNames(data)
+		if err != nil {
+			return nil, fmt.Errorf("names of label %s in %s: %v", key, file, err)
+		}
+		if names[key] == nil {
+			names[key] = m
+			continue
+		}
+		for v, name := range m {
+			names[key][v] = name
+		}
+	}
+	return names, nil
+}
+
+// parseLabelNames parses a file of names of numeric label values. It is
+// either a JSON object from values to names, eg {"257": "openat"}, or CSV
+// records of a value and its name, eg "257,openat", optionally after a
+// header record. Values are decimal, or hexadecimal with a 0x prefix.
+func parseLabelNames(data []byte) (map[int64]string, error) {
+	names := make(map[int64]string)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var m map[string]string
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		for k, name := range m {
+			v, err := strconv.ParseInt(strings.TrimSpace(k), 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", k)
+			}
+			names[v] = name
+		}
+		return names, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, rec := range records {
+		v, err := strconv.ParseInt(strings.TrimSpace(rec[0]), 0, 64)
+		if err != nil {
+			if i == 0 {
+				// A header record.
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid value %q", i+1, rec[0])
+		}
+		names[v] = strings.TrimSpace(rec[1])
+	}
+	return names, nil
+}
+
+// nameNumLabels replaces the numeric labels of p with a key in names with
+// string labels holding the names of their values, so that they are shown,
+// filtered and grouped by name in every report. Values without a name are
+// kept as decimal strings.
+func nameNumLabels(p *profile.Profile, names map[string]map[int64]string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, s := range p.Sample {
+		for key, m := range names {
+			values, ok := s.NumLabel[key]
+			if !ok {
+				continue
+			}
+			if s.Label == nil {
+				s.Label = make(map[string][]string)
+			}
+			for _, v := range values {
+				name, ok := m[v]
+				if !ok {
+					name = strconv.FormatInt(v, 10)
+				}
+				s.Label[key] = append(s.Label[key], name)
+			}
+			delete(s.NumLabel, key)
+			delete(s.NumUnit, key)
+		}
+	}
+}
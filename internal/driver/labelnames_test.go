@@ -0,0 +1,115 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelNames(t *testing.T) {
+	for _, tc := range []struct {
+		desc, data string
+		want       map[int64]string
+		wantErr    bool
+	}{
+		{
+			desc: "csv",
+			data: "# Linux x86-64 syscalls.\nnumber,name\n0,read\n 257, openat\n0x3c,exit\n",
+			want: map[int64]string{0: "read", 257: "openat", 60: "exit"},
+		},
+		{
+			desc: "json",
+			data: `{"200": "OK", "404": "Not Found", "-1": "unknown"}`,
+			want: map[int64]string{200: "OK", 404: "Not Found", -1: "unknown"},
+		},
+		{
+			desc:    "csv invalid value",
+			data:    "0,read\nx,write\n",
+			wantErr: true,
+		},
+		{
+			desc:    "csv missing name",
+			data:    "0,read\n1\n",
+			wantErr: true,
+		},
+		{
+			desc:    "json invalid value",
+			data:    `{"ok": "OK"}`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseLabelNames([]byte(tc.data))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got names %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got names %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNameNumLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label_names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	syscalls := filepath.Join(dir, "syscalls.csv")
+	if err := ioutil.WriteFile(syscalls, []byte("0,read\n257,openat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := loadLabelNames("syscall=" + syscalls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadLabelNames("syscall"); err == nil {
+		t.Error("want error for a malformed -label_names")
+	}
+
+	p, err := parseSynthSpec("test", []byte("mapping /bin/app\n"+
+		"stack main;open 1 syscall:=257 fd:=3\n"+
+		"stack main;read 2 syscall:=0 syscall:=9\n"+
+		"stack main 3 fd:=4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameNumLabels(p, names)
+	var got [][]string
+	for _, s := range p.Sample {
+		got = append(got, s.Label["syscall"])
+		if _, ok := s.NumLabel["syscall"]; ok {
+			t.Errorf("got numeric syscall label %v, want none", s.NumLabel["syscall"])
+		}
+	}
+	want := [][]string{{"openat"}, {"read", "9"}, nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got syscall labels %v, want %v", got, want)
+	}
+	if got := p.Sample[0].NumLabel["fd"]; !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("got fd label %v, want [3]", got)
+	}
+}
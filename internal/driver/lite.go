@@ -0,0 +1,81 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build pprof_lite
+// +build pprof_lite
+
+package driver
+
+// This file stubs out the features that pprof-lite builds leave out to
+// keep their binaries small and free of external dependencies: the web
+// interface and API server, fetching profiles over HTTP, and the reports
+// run through graphviz and other visualizers. The profiles are read from
+// files, and can still be merged, filtered and reported as text or
+// protobuf.
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// errLite reports that a feature is not available in pprof-lite builds.
+func errLite(feature string) error {
+	return fmt.Errorf("%s is not available in pprof-lite builds", feature)
+}
+
+func serveWebInterface(hostport string, tabs []*profileTab, o *plugin.Options, disableBrowser bool, tlsConfig *tls.Config) error {
+	return errLite("the web interface")
+}
+
+// liveFeed runs the live mode of the tabs of the web interface.
+type liveFeed struct{}
+
+func basicAuth(src *source, next func(http.Handler) http.Handler) (func(http.Handler) http.Handler, error) {
+	return next, nil
+}
+
+func webTLSConfig(src *source, ui plugin.UI) (*tls.Config, error) {
+	return nil, nil
+}
+
+func serveSSHTunnel(src *source, args []string, o *plugin.Options) error {
+	return errLite("the web interface")
+}
+
+func exportWebInterface(ctx context.Context, dest string, p *profile.Profile, o *plugin.Options) error {
+	return errLite("the web interface")
+}
+
+func serveAPI(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	return errLite("pprof api")
+}
+
+func compareProfiles(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	return errLite("pprof compare")
+}
+
+func fetchURL(source string, timeout time.Duration, tr http.RoundTripper) (io.ReadCloser, error) {
+	return nil, errLite("fetching profiles over HTTP")
+}
+
+func downloadURL(ctx context.Context, source string, timeout time.Duration, rate int, ui plugin.UI, tr http.RoundTripper) (io.ReadCloser, error) {
+	return nil, errLite("fetching profiles over HTTP")
+}
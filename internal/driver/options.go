@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 
@@ -46,15 +47,45 @@ func setDefaults(o *plugin.Options) *plugin.Options {
 	if d.UI == nil {
 		d.UI = &stdUI{r: bufio.NewReader(os.Stdin)}
 	}
+	if _, ok := d.Flagset.(*telemetryFlags); d.Telemetry != nil && !ok {
+		// The flags are recorded once, even if the options are
+		// defaulted again.
+		d.Flagset = &telemetryFlags{FlagSet: d.Flagset, t: d.Telemetry}
+	}
 	if d.HTTPTransport == nil {
 		d.HTTPTransport = transport.New(d.Flagset)
 	}
+	if d.RequestDecorator != nil {
+		d.HTTPTransport = &decoratedTransport{d.HTTPTransport, d.RequestDecorator}
+		// The transport decorates the requests, which must not be
+		// decorated again if the options are defaulted again.
+		d.RequestDecorator = nil
+	}
 	if d.Sym == nil {
 		d.Sym = &symbolizer.Symbolizer{Obj: d.Obj, UI: d.UI, Transport: d.HTTPTransport}
 	}
 	return d
 }
 
+// decoratedTransport sends the requests decorated by decorate, as to
+// authenticate them, through the transport base.
+type decoratedTransport struct {
+	base     http.RoundTripper
+	decorate func(*http.Request) error
+}
+
+func (t *decoratedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given.
+	req = req.Clone(req.Context())
+	if err := t.decorate(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("decorating request to %s: %v", req.URL.Host, err)
+	}
+	return t.base.RoundTrip(req)
+}
+
 type stdUI struct {
 	r *bufio.Reader
 }
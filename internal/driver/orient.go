@@ -0,0 +1,121 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// swappedDecrease is the share of the change of the values of a diff
+// that is a decrease above which the source and base profiles are
+// suspected to have been given in the wrong order.
+const swappedDecrease = 0.9
+
+// swappedDiff returns why the base profiles of a diff look like the
+// newer profiles, which were meant to be the source ones, or "" if they
+// do not: nearly all the change of the values from the base profiles to
+// the source ones is a decrease, and the base profiles were collected
+// after the source ones, if the times of both are known. A decrease from
+// base profiles collected earlier is taken for a genuine improvement. The
+// values of the base profiles are scaled as by -normalize if normalize is
+// set.
+func swappedDiff(p, base *profile.Profile, normalize bool) string {
+	timed := p.TimeNanos != 0 && base.TimeNanos != 0
+	if timed && base.TimeNanos <= p.TimeNanos {
+		return ""
+	}
+	i := defaultValueIndex(p)
+	if i < 0 || i >= len(base.SampleType) {
+		return ""
+	}
+	values, baseValues := leafValues(p, i), leafValues(base, i)
+	scale := 1.0
+	if normalize {
+		var total, baseTotal int64
+		for _, v := range values {
+			total += v
+		}
+		for _, v := range baseValues {
+			baseTotal += v
+		}
+		if total == 0 || baseTotal == 0 {
+			return ""
+		}
+		scale = float64(total) / float64(baseTotal)
+	}
+
+	var decrease, increase float64
+	for k, v := range values {
+		if d := float64(v) - scale*float64(baseValues[k]); d > 0 {
+			increase += d
+		} else {
+			decrease -= d
+		}
+	}
+	for k, v := range baseValues {
+		if _, ok := values[k]; !ok {
+			decrease += scale * float64(v)
+		}
+	}
+	if decrease <= 0 || decrease < swappedDecrease*(decrease+increase) {
+		return ""
+	}
+	reason := fmt.Sprintf("%.0f%% of the change of %s is a decrease", 100*decrease/(decrease+increase), p.SampleType[i].Type)
+	if timed {
+		reason += fmt.Sprintf(", and the base profiles were collected at %v, after the source profiles at %v",
+			time.Unix(0, base.TimeNanos).UTC(), time.Unix(0, p.TimeNanos).UTC())
+	}
+	return reason
+}
+
+// defaultValueIndex returns the index of the sample value reported by
+// default for p: that of its default sample type, or else the last one.
+func defaultValueIndex(p *profile.Profile) int {
+	for i, st := range p.SampleType {
+		if st.Type == p.DefaultSampleType {
+			return i
+		}
+	}
+	return len(p.SampleType) - 1
+}
+
+// leafValues returns the sums of the i-th values of the samples of p by
+// their leaf frame, the innermost function of the sample, or its address
+// in its mapping when unsymbolized, to compare them across profiles.
+func leafValues(p *profile.Profile, i int) map[string]int64 {
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		if i >= len(s.Value) {
+			continue
+		}
+		key := ""
+		if len(s.Location) > 0 {
+			loc := s.Location[0]
+			switch {
+			case len(loc.Line) > 0 && loc.Line[0].Function != nil:
+				key = loc.Line[0].Function.Name
+			case loc.Mapping != nil:
+				key = fmt.Sprintf("%s+%#x", loc.Mapping.File, loc.Address-loc.Mapping.Start)
+			default:
+				key = fmt.Sprintf("%#x", loc.Address)
+			}
+		}
+		values[key] += s.Value[i]
+	}
+	return values
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestDiffAutoOrient(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	dir, err := ioutil.TempDir("", "diff_auto_orient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	// contentions returns a profile of contentions collected at start+d,
+	// or at an unknown time if d is negative, with the counts of the
+	// functions F1 and F2.
+	contentions := func(name string, d time.Duration, f1, f2 int64) string {
+		m := &profile.Mapping{ID: 1, HasFunctions: true}
+		var fns []*profile.Function
+		var locs []*profile.Location
+		var samples []*profile.Sample
+		for i, v := range []int64{f1, f2} {
+			fn := &profile.Function{ID: uint64(i + 1), Name: []string{"F1", "F2"}[i]}
+			loc := &profile.Location{ID: uint64(i + 1), Mapping: m, Line: []profile.Line{{Function: fn}}}
+			fns, locs = append(fns, fn), append(locs, loc)
+			samples = append(samples, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{v}})
+		}
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "contentions", Unit: "count"}},
+			Sample:     samples,
+			Location:   locs,
+			Function:   fns,
+			Mapping:    []*profile.Mapping{m},
+		}
+		if d >= 0 {
+			p.TimeNanos = start.Add(d).UnixNano()
+		}
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	older := contentions("older.pb.gz", 0, 100, 10)
+	newer := contentions("newer.pb.gz", time.Minute, 300, 12)
+	improved := contentions("improved.pb.gz", time.Minute, 40, 5)
+	untimedOlder := contentions("untimed_older.pb.gz", -1, 100, 10)
+	untimedNewer := contentions("untimed_newer.pb.gz", -1, 300, 12)
+
+	for _, tc := range []struct {
+		desc, source, base string
+		autoOrient         bool
+		want               map[string]int64
+		wantRx, wantAudit  string
+	}{
+		{
+			desc:   "in order",
+			source: newer,
+			base:   older,
+			want:   map[string]int64{"F1": 200, "F2": 2},
+		},
+		{
+			desc:   "improvement",
+			source: improved,
+			base:   older,
+			want:   map[string]int64{"F1": -60, "F2": -5},
+		},
+		{
+			desc:   "swapped",
+			source: older,
+			base:   newer,
+			want:   map[string]int64{"F1": -200, "F2": -2},
+			wantRx: "100% of the change of contentions is a decrease, and the base profiles were collected at 2020-01-02 03:05:05 \\+0000 UTC.*use -diff_auto_orient",
+		},
+		{
+			desc:   "swapped without times",
+			source: untimedOlder,
+			base:   untimedNewer,
+			want:   map[string]int64{"F1": -200, "F2": -2},
+			wantRx: "may be given in the wrong order, as 100% of the change of contentions is a decrease\\.",
+		},
+		{
+			desc:       "auto orient",
+			source:     older,
+			base:       newer,
+			autoOrient: true,
+			want:       map[string]int64{"F1": 200, "F2": 2},
+			wantRx:     "Swapped the source and base profiles with -diff_auto_orient, as 100%",
+			wantAudit:  "pprof: Swapped the source and base profiles with -diff_auto_orient",
+		},
+		{
+			desc:       "auto orient in order",
+			source:     newer,
+			base:       older,
+			autoOrient: true,
+			want:       map[string]int64{"F1": 200, "F2": 2},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			setCurrentConfig(baseConfig)
+			f := testFlags{
+				stringLists: map[string][]string{"base": {tc.base}},
+				bools:       map[string]bool{"diff_auto_orient": tc.autoOrient},
+				args:        []string{tc.source},
+			}
+			ui := &proftest.TestUI{T: t, AllowRx: tc.wantRx}
+			o := setDefaults(&plugin.Options{UI: ui, Flagset: f, HTTPTransport: &httpTransport{}})
+			src, _, err := parseFlags(o)
+			if err != nil {
+				t.Fatalf("parseFlags: %v", err)
+			}
+			p, err := fetchProfiles(context.Background(), src, o)
+			if err != nil {
+				t.Fatalf("fetchProfiles: %v", err)
+			}
+			if tc.wantRx != "" && ui.NumAllowRxMatches != 1 {
+				t.Errorf("got %d warnings matching %q, want 1", ui.NumAllowRxMatches, tc.wantRx)
+			}
+
+			got := make(map[string]int64)
+			for _, s := range p.Sample {
+				got[s.Location[0].Line[0].Function.Name] += s.Value[0]
+			}
+			for name, v := range tc.want {
+				if got[name] != v {
+					t.Errorf("%s: got %d, want %d", name, got[name], v)
+				}
+			}
+			if tc.wantAudit != "" && !strings.Contains(strings.Join(p.Comments, "\n"), tc.wantAudit) {
+				t.Errorf("got comments %q, want %q", p.Comments, tc.wantAudit)
+			}
+		})
+	}
+
+	// Cumulative counters subtracted with -delta_from are checked for
+	// their order.
+	f := testFlags{
+		strings: map[string]string{"delta_from": older},
+		bools:   map[string]bool{"diff_auto_orient": true},
+		args:    []string{newer},
+	}
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t}, Flagset: f})
+	if _, _, err := parseFlags(o); err == nil || !strings.Contains(err.Error(), "only makes sense with -base or -diff_base") {
+		t.Errorf("-diff_auto_orient with -delta_from: got error %v, want it rejected", err)
+	}
+}
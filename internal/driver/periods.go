@@ -0,0 +1,137 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// periodLabel is the numeric sample label holding the sampling period a
+// sample was collected with, for samplers adapting their period during the
+// collection of a profile.
+const periodLabel = "period"
+
+// labelPeriods sets the period label of the samples of profiles that are
+// about to be merged to the period of their profile, if the profiles have
+// different periods, as the merged profile only keeps the largest one.
+// Samples with a period label keep it.
+func labelPeriods(profiles []*profile.Profile) {
+	mixed := false
+	for _, p := range profiles[1:] {
+		if p.Period != profiles[0].Period {
+			mixed = true
+		}
+	}
+	if !mixed {
+		return
+	}
+	for _, p := range profiles {
+		if p.Period <= 0 {
+			continue
+		}
+		var unit string
+		if p.PeriodType != nil {
+			unit = p.PeriodType.Unit
+		}
+		for _, s := range p.Sample {
+			if len(s.NumLabel[periodLabel]) > 0 {
+				continue
+			}
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+			}
+			s.NumLabel[periodLabel] = []int64{p.Period}
+			if unit != "" {
+				if s.NumUnit == nil {
+					s.NumUnit = make(map[string][]string)
+				}
+				s.NumUnit[periodLabel] = []string{unit}
+			}
+		}
+	}
+}
+
+// correctPeriods scales the counts of the samples of p with a period label
+// from their period to the period of p, so that a count stands for the same
+// amount of the period type in all samples. The values in other units are
+// measured rather than counted, and are left as is. The period labels are
+// then removed, so that a saved profile is not corrected again. It warns if
+// the samples have periods other than that of p.
+func correctPeriods(p *profile.Profile, ui plugin.UI) {
+	var unit string
+	if p.PeriodType != nil {
+		unit = p.PeriodType.Unit
+	}
+	var counts []int
+	for i, st := range p.SampleType {
+		if st.Unit == "" || st.Unit == "count" {
+			counts = append(counts, i)
+		}
+	}
+
+	var min, max int64
+	corrected := 0
+	for _, s := range p.Sample {
+		v := s.NumLabel[periodLabel]
+		if len(v) == 0 {
+			continue
+		}
+		period := v[0]
+		if u := s.NumUnit[periodLabel]; len(u) > 0 && unit != "" {
+			scaled, _ := measurement.Scale(period, u[0], unit)
+			period = int64(scaled)
+		}
+		delete(s.NumLabel, periodLabel)
+		delete(s.NumUnit, periodLabel)
+		if period <= 0 || period == p.Period {
+			continue
+		}
+		if corrected == 0 || period < min {
+			min = period
+		}
+		if corrected == 0 || period > max {
+			max = period
+		}
+		corrected++
+		if p.Period <= 0 {
+			continue
+		}
+		ratio := float64(period) / float64(p.Period)
+		for _, i := range counts {
+			s.Value[i] = int64(math.Round(float64(s.Value[i]) * ratio))
+		}
+	}
+	if corrected == 0 {
+		return
+	}
+	periods := fmt.Sprintf("%d", min)
+	if max != min {
+		periods = fmt.Sprintf("%d to %d", min, max)
+	}
+	if unit != "" {
+		periods += " " + unit
+	}
+	if p.Period <= 0 {
+		ui.PrintErr(fmt.Sprintf("%d samples were collected with periods of %s, but the profile has no period to scale their counts to", corrected, periods))
+		return
+	}
+	ui.PrintErr(fmt.Sprintf("%d samples were collected with periods of %s instead of %d, scaling their counts to the period of the profile", corrected, periods, p.Period))
+	audit(p, "Scaled the counts of %d samples collected with periods of %s to the period of the profile", corrected, periods)
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestCorrectPeriods(t *testing.T) {
+	cpu := &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+	newProfile := func(period int64) *profile.Profile {
+		return &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, cpu},
+			PeriodType: cpu,
+			Period:     period,
+		}
+	}
+	p1, p2 := newProfile(10e6), newProfile(20e6)
+	p1.Sample = []*profile.Sample{{Value: []int64{3, 30e6}}}
+	p2.Sample = []*profile.Sample{
+		{Value: []int64{2, 40e6}},
+		{
+			Value:    []int64{4, 20e6},
+			NumLabel: map[string][]int64{periodLabel: {5}},
+			NumUnit:  map[string][]string{periodLabel: {"ms"}},
+		},
+	}
+	labelPeriods([]*profile.Profile{p1, p2})
+
+	// The merged profile keeps the period of 20ms.
+	p := newProfile(20e6)
+	p.Sample = append(p1.Sample, p2.Sample...)
+	ui := &proftest.TestUI{T: t, AllowRx: "2 samples were collected with periods of 5000000 to 10000000 nanoseconds instead of 20000000"}
+	correctPeriods(p, ui)
+	if ui.NumAllowRxMatches != 1 {
+		t.Errorf("got %d warnings about the periods, want 1", ui.NumAllowRxMatches)
+	}
+	var got [][]int64
+	for _, s := range p.Sample {
+		got = append(got, s.Value)
+		if len(s.NumLabel[periodLabel]) > 0 {
+			t.Errorf("the period label of %v was not removed", s.Value)
+		}
+	}
+	if want := [][]int64{{2, 30e6}, {2, 40e6}, {1, 20e6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got values %v, want %v", got, want)
+	}
+}
+
+func TestLabelPeriodsSamePeriod(t *testing.T) {
+	p1 := &profile.Profile{Period: 10, Sample: []*profile.Sample{{Value: []int64{1}}}}
+	p2 := &profile.Profile{Period: 10, Sample: []*profile.Sample{{Value: []int64{1}}}}
+	labelPeriods([]*profile.Profile{p1, p2})
+	for _, s := range append(p1.Sample, p2.Sample...) {
+		if s.NumLabel != nil {
+			t.Errorf("got labels %v for profiles of the same period", s.NumLabel)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// phaseRange is an execution phase of a -phase_map file, lasting from
+// start to end after the first sample, or until the last one if end is 0.
+type phaseRange struct {
+	name       string
+	start, end time.Duration
+}
+
+// loadPhaseMap loads the execution phases of the -phase_map file, which
+// has a line per phase with its name, and the times it starts and ends at
+// after the first sample, such as "startup 0s 30s". The end of the last
+// phase may be left out. Lines starting with # are comments.
+func loadPhaseMap(file string) ([]phaseRange, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var phases []phaseRange
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: want a phase name, start and end, got %q", file, i+1, line)
+		}
+		ph := phaseRange{name: fields[0]}
+		if ph.start, err = time.ParseDuration(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		if len(fields) == 3 {
+			if ph.end, err = time.ParseDuration(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+			}
+			if ph.end <= ph.start {
+				return nil, fmt.Errorf("%s:%d: phase %s ends before it starts", file, i+1, ph.name)
+			}
+		}
+		phases = append(phases, ph)
+	}
+	return phases, nil
+}
+
+// labelPhases sets the phase label of the samples of p to the first of
+// the phases their timestamp label falls in, relative to the earliest
+// timestamp. It warns if no sample has a timestamp.
+func labelPhases(p *profile.Profile, phases []phaseRange, ui plugin.UI) {
+	if len(phases) == 0 {
+		return
+	}
+	timestamp := func(s *profile.Sample) (int64, bool) {
+		var unit string
+		if u := s.NumUnit[report.TimestampLabel]; len(u) > 0 {
+			unit = u[0]
+		}
+		return report.SampleTime(s, unit)
+	}
+
+	var origin int64
+	timed := false
+	for _, s := range p.Sample {
+		if t, ok := timestamp(s); ok && (!timed || t < origin) {
+			origin, timed = t, true
+		}
+	}
+	if !timed {
+		ui.PrintErr("-phase_map: the samples have no ", report.TimestampLabel, " label to place them in phases")
+		return
+	}
+	for _, s := range p.Sample {
+		t, ok := timestamp(s)
+		if !ok {
+			continue
+		}
+		d := time.Duration(t - origin)
+		for _, ph := range phases {
+			if d >= ph.start && (ph.end == 0 || d < ph.end) {
+				if s.Label == nil {
+					s.Label = make(map[string][]string)
+				}
+				s.Label[report.PhaseLabel] = []string{ph.name}
+				break
+			}
+		}
+	}
+}
+
+// phaseEntry holds an execution phase of the phase menu of the web
+// interface.
+type phaseEntry struct {
+	Name     string
+	TagFocus string // Tag filter selecting the samples of the phase
+	URL      string // Link to the view focused on the phase, for a request
+}
+
+// phaseEntries returns the execution phases of the samples of p, in the
+// order of report.PhaseNames.
+func phaseEntries(p *profile.Profile) []phaseEntry {
+	var phases []phaseEntry
+	for _, name := range report.PhaseNames(p) {
+		// Commas separate the regexps of tag filters.
+		rx := strings.Replace(regexp.QuoteMeta(name), ",", `\x2c`, -1)
+		phases = append(phases, phaseEntry{Name: name, TagFocus: report.PhaseLabel + "=^" + rx + "$"})
+	}
+	return phases
+}
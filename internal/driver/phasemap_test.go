@@ -0,0 +1,109 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestPhaseMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phase_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "phases")
+	if err := ioutil.WriteFile(file, []byte("# phase from to\nstartup 0s 2s\nsteady,state 2s 1m\nshutdown 1m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	phases, err := loadPhaseMap(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []phaseRange{
+		{"startup", 0, 2 * time.Second},
+		{"steady,state", 2 * time.Second, time.Minute},
+		{"shutdown", time.Minute, 0},
+	}
+	if !reflect.DeepEqual(phases, want) {
+		t.Fatalf("got phases %v, want %v", phases, want)
+	}
+
+	// The timestamps are in milliseconds, after a first sample at 5s.
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}}}
+	for _, ms := range []int64{5000, 6000, 8000, 70000} {
+		p.Sample = append(p.Sample, &profile.Sample{
+			Value:    []int64{1},
+			NumLabel: map[string][]int64{"timestamp": {ms}},
+			NumUnit:  map[string][]string{"timestamp": {"ms"}},
+		})
+	}
+	p.Sample = append(p.Sample, &profile.Sample{Value: []int64{1}})
+	labelPhases(p, phases, &proftest.TestUI{T: t})
+	var got []string
+	for _, s := range p.Sample {
+		got = append(got, firstLabelValue(s, "phase"))
+	}
+	if want := []string{"startup", "startup", "steady,state", "shutdown", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got phases %q, want %q", got, want)
+	}
+
+	entries := phaseEntries(p)
+	wantEntries := []phaseEntry{
+		{Name: "startup", TagFocus: `phase=^startup$`},
+		{Name: "steady,state", TagFocus: `phase=^steady\x2cstate$`},
+		{Name: "shutdown", TagFocus: `phase=^shutdown$`},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got phase menu %v, want %v", entries, wantEntries)
+	}
+}
+
+func TestLoadPhaseMapErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phase_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, data := range []string{
+		"startup\n",
+		"startup 0s 1s 2s\n",
+		"startup 0 10\n",
+		"startup 10s 5s\n",
+	} {
+		file := filepath.Join(dir, "phases")
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if phases, err := loadPhaseMap(file); err == nil {
+			t.Errorf("%q: got phases %v, want error", data, phases)
+		}
+	}
+}
+
+func firstLabelValue(s *profile.Sample, key string) string {
+	if v := s.Label[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
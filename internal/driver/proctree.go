@@ -0,0 +1,229 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Profiles of processes that fork or exec workers can be combined into
+// a single process tree. Each sample is attributed to a process through
+// "pid" and "ppid" labels, either present in the profile or derived from
+// the name of the profile source (eg, cpu.pid123.ppid45.pb.gz). After
+// merging, every sample gets a chain of "pid N" pseudo-frames at the root
+// of its stack, so each process appears as a node whose cumulative value
+// includes the cost of all its descendants.
+
+// The pid and ppid of the names of profile sources start words, so that
+// names such as "rapid5" do not hold a pid.
+var (
+	pidRx  = regexp.MustCompile(`(?:^|[^[:alnum:]])pid[._-]?(\d+)`)
+	ppidRx = regexp.MustCompile(`(?:^|[^[:alnum:]])ppid[._-]?(\d+)`)
+)
+
+// processFramePrefix is the prefix of the pseudo-frames inserted for each
+// process in a process tree.
+const processFramePrefix = "pid "
+
+// labelProcess records the process identity of the profile fetched from
+// source as "pid" and "ppid" string labels on all its samples. Existing
+// labels, string or numeric, take precedence over the source name.
+func labelProcess(p *profile.Profile, source string) {
+	base := filepath.Base(source)
+	var pid, ppid string
+	if m := pidRx.FindStringSubmatch(base); m != nil {
+		pid = m[1]
+	}
+	if m := ppidRx.FindStringSubmatch(base); m != nil {
+		ppid = m[1]
+	}
+	for _, s := range p.Sample {
+		setProcessLabel(s, "pid", pid)
+		setProcessLabel(s, "ppid", ppid)
+	}
+}
+
+// setProcessLabel sets the string label key of s to def, unless s already
+// has a string or numeric label for that key.
+func setProcessLabel(s *profile.Sample, key, def string) {
+	if len(s.Label[key]) > 0 {
+		return
+	}
+	if v := s.NumLabel[key]; len(v) > 0 {
+		def = strconv.FormatInt(v[0], 10)
+		delete(s.NumLabel, key)
+		delete(s.NumUnit, key)
+	}
+	if def == "" {
+		return
+	}
+	if s.Label == nil {
+		s.Label = make(map[string][]string)
+	}
+	s.Label[key] = []string{def}
+}
+
+// stitchProcessTree prepends to every sample stack the chain of processes
+// from the root of the process tree to the process that recorded it.
+func stitchProcessTree(p *profile.Profile) {
+	parents := processParents(p)
+	if len(parents) == 0 {
+		return
+	}
+
+	var nextLocID, nextFuncID uint64
+	for _, l := range p.Location {
+		if l.ID > nextLocID {
+			nextLocID = l.ID
+		}
+	}
+	for _, f := range p.Function {
+		if f.ID > nextFuncID {
+			nextFuncID = f.ID
+		}
+	}
+	locs := make(map[string]*profile.Location)
+	processLocation := func(pid string) *profile.Location {
+		if l := locs[pid]; l != nil {
+			return l
+		}
+		nextLocID++
+		nextFuncID++
+		name := processFramePrefix + pid
+		f := &profile.Function{ID: nextFuncID, Name: name, SystemName: name}
+		l := &profile.Location{ID: nextLocID, Line: []profile.Line{{Function: f}}}
+		p.Function = append(p.Function, f)
+		p.Location = append(p.Location, l)
+		locs[pid] = l
+		return l
+	}
+
+	for _, s := range p.Sample {
+		pids := s.Label["pid"]
+		if len(pids) == 0 {
+			continue
+		}
+		// Walk up from the sample's process, guarding against cycles.
+		seen := make(map[string]bool)
+		for pid := pids[0]; pid != "" && !seen[pid]; pid = parents[pid] {
+			seen[pid] = true
+			s.Location = append(s.Location, processLocation(pid))
+		}
+	}
+}
+
+// processParents returns the parent of every process in p.
+func processParents(p *profile.Profile) map[string]string {
+	parents := make(map[string]string)
+	for _, s := range p.Sample {
+		pids := s.Label["pid"]
+		if len(pids) == 0 {
+			continue
+		}
+		var ppid string
+		if v := s.Label["ppid"]; len(v) > 0 {
+			ppid = v[0]
+		}
+		if parents[pids[0]] == "" {
+			parents[pids[0]] = ppid
+		}
+	}
+	return parents
+}
+
+// processEntry describes a process in a process tree for the web UI.
+type processEntry struct {
+	Name  string // Name of the process pseudo-frame
+	Depth int    // Depth of the process in the tree, 0 for roots
+	Focus string // Focus regexp selecting the process and its descendants
+	URL   string // Link to the view focused on the process, for a request
+}
+
+// processTree returns the processes recorded in p in depth-first order,
+// with children sorted by pid. It returns nil unless p has been stitched
+// into a process tree.
+func processTree(p *profile.Profile) []processEntry {
+	stitched := false
+	for _, f := range p.Function {
+		if strings.HasPrefix(f.Name, processFramePrefix) && f.Filename == "" {
+			stitched = true
+			break
+		}
+	}
+	parents := processParents(p)
+	if !stitched || len(parents) == 0 {
+		return nil
+	}
+
+	children := make(map[string][]string)
+	var roots []string
+	for pid, ppid := range parents {
+		if _, ok := parents[ppid]; ok && ppid != pid {
+			children[ppid] = append(children[ppid], pid)
+		} else {
+			roots = append(roots, pid)
+		}
+	}
+
+	var entries []processEntry
+	var all []string
+	visited := make(map[string]bool)
+	var walk func(pids []string, depth int)
+	walk = func(pids []string, depth int) {
+		sortPids(pids)
+		for _, pid := range pids {
+			if visited[pid] {
+				continue
+			}
+			visited[pid] = true
+			name := processFramePrefix + pid
+			entries = append(entries, processEntry{Name: name, Depth: depth, Focus: processFocus(name)})
+			walk(children[pid], depth+1)
+		}
+	}
+	walk(roots, 0)
+	// Processes only reachable through a cycle are listed as roots.
+	for pid := range parents {
+		if !visited[pid] {
+			all = append(all, pid)
+		}
+	}
+	walk(all, 0)
+	return entries
+}
+
+// sortPids sorts pids numerically where possible.
+func sortPids(pids []string) {
+	sort.Slice(pids, func(i, j int) bool {
+		a, erra := strconv.Atoi(pids[i])
+		b, errb := strconv.Atoi(pids[j])
+		if erra == nil && errb == nil {
+			return a < b
+		}
+		return pids[i] < pids[j]
+	})
+}
+
+// processFocus returns a focus regexp selecting the named process frame.
+func processFocus(name string) string {
+	return fmt.Sprintf("^%s$", regexp.QuoteMeta(name))
+}
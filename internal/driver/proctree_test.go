@@ -0,0 +1,126 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestProcessTree(t *testing.T) {
+	var profiles []*profile.Profile
+	for _, src := range []string{
+		"/tmp/cpu.pid10.pb.gz",
+		"/tmp/cpu.pid11.ppid10.pb.gz",
+		"/tmp/cpu.pid12.ppid11.pb.gz",
+		"/tmp/cpu.pid9.ppid10.pb.gz",
+	} {
+		p := makeFakeProfile()
+		labelProcess(p, src)
+		profiles = append(profiles, p)
+	}
+	p, err := profile.Merge(profiles)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	stitchProcessTree(p)
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("stitched profile is invalid: %v", err)
+	}
+
+	for _, s := range p.Sample {
+		var roots []string
+		for _, l := range s.Location {
+			if name := l.Line[0].Function.Name; strings.HasPrefix(name, processFramePrefix) {
+				roots = append(roots, name)
+			}
+		}
+		var want []string
+		switch s.Label["pid"][0] {
+		case "10":
+			want = []string{"pid 10"}
+		case "9":
+			want = []string{"pid 9", "pid 10"}
+		case "11":
+			want = []string{"pid 11", "pid 10"}
+		case "12":
+			want = []string{"pid 12", "pid 11", "pid 10"}
+		}
+		if !reflect.DeepEqual(roots, want) {
+			t.Errorf("pid %s: got process frames %v, want %v", s.Label["pid"][0], roots, want)
+		}
+	}
+
+	var got []processEntry
+	for _, e := range processTree(p) {
+		got = append(got, processEntry{Name: e.Name, Depth: e.Depth})
+	}
+	want := []processEntry{
+		{Name: "pid 10", Depth: 0},
+		{Name: "pid 9", Depth: 1},
+		{Name: "pid 11", Depth: 1},
+		{Name: "pid 12", Depth: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("processTree: got %v, want %v", got, want)
+	}
+}
+
+func TestProcessLabelsTakePrecedence(t *testing.T) {
+	p := makeFakeProfile()
+	for _, s := range p.Sample {
+		s.NumLabel = map[string][]int64{"pid": {42}}
+	}
+	labelProcess(p, "cpu.pid7.pb.gz")
+	for _, s := range p.Sample {
+		if got := s.Label["pid"]; !reflect.DeepEqual(got, []string{"42"}) {
+			t.Errorf("got pid label %v, want [42]", got)
+		}
+		if _, ok := s.NumLabel["pid"]; ok {
+			t.Errorf("numeric pid label was not removed")
+		}
+	}
+	if got := processTree(p); got != nil {
+		t.Errorf("processTree of unstitched profile: got %v, want nil", got)
+	}
+}
+
+func TestProcessSourceNames(t *testing.T) {
+	for _, tc := range []struct {
+		source, pid, ppid string
+	}{
+		{"cpu.pid123.ppid45.pb.gz", "123", "45"},
+		{"worker_pid7.pb.gz", "7", ""},
+		{"pid-8", "8", ""},
+		{"rapid5.pb.gz", "", ""},
+		{"cpu.stupid3.appid4.pb.gz", "", ""},
+	} {
+		p := makeFakeProfile()
+		labelProcess(p, tc.source)
+		s := p.Sample[0]
+		if got := strings.Join(s.Label["pid"], ","); got != tc.pid {
+			t.Errorf("%s: got pid %q, want %q", tc.source, got, tc.pid)
+		}
+		if got := strings.Join(s.Label["ppid"], ","); got != tc.ppid {
+			t.Errorf("%s: got ppid %q, want %q", tc.source, got, tc.ppid)
+		}
+	}
+}
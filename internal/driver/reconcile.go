@@ -0,0 +1,196 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// parseSampleTypeMap parses the value of -sample_type_map, a list of
+// base=source pairs of sample types, eg "space=inuse_space", into a map
+// from the sample types of the base profiles to those of the source
+// profiles.
+func parseSampleTypeMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	if s == "" {
+		return m, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("malformed -sample_type_map %q, want base=source,...", s)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+// reconcileSampleTypes makes the sample types of the base profile match
+// those of the source profile, so that profiles recorded by different
+// versions of a program or profiler can be compared. The sample types are
+// matched through typeMap, from the types of the base profile to those of
+// the source profile, then by type, whose units are reconciled when the
+// profiles are merged, then by unit if a single type of each profile is
+// left with it. The sample types left unmatched are dropped with a
+// warning, and the period type of the base profile is replaced by that of
+// the source profile if they differ.
+func reconcileSampleTypes(p, base *profile.Profile, typeMap map[string]string, ui plugin.UI) error {
+	if pt, bt := p.PeriodType, base.PeriodType; pt != nil && (bt == nil || pt.Type != bt.Type) {
+		if bt != nil {
+			ui.PrintErr(fmt.Sprintf("Using period type %s of the source profiles instead of %s for the base profiles", valueTypeString(pt), valueTypeString(bt)))
+		}
+		base.PeriodType = &profile.ValueType{Type: pt.Type, Unit: pt.Unit}
+		base.Period = p.Period
+	}
+	if len(typeMap) == 0 && sameSampleTypes(p, base) {
+		return nil
+	}
+
+	// match[i] is the index of the sample type of the base profile
+	// matching the i-th sample type of the source profile, or -1.
+	match := make([]int, len(p.SampleType))
+	matched := make([]bool, len(base.SampleType))
+	for i := range match {
+		match[i] = -1
+	}
+	find := func(ok func(st, bt *profile.ValueType) bool) {
+		for i, st := range p.SampleType {
+			if match[i] != -1 {
+				continue
+			}
+			for j, bt := range base.SampleType {
+				if _, mapped := typeMap[bt.Type]; !matched[j] && ok(st, bt) && (!mapped || typeMap[bt.Type] == st.Type) {
+					match[i], matched[j] = j, true
+					break
+				}
+			}
+		}
+	}
+	find(func(st, bt *profile.ValueType) bool {
+		return typeMap[bt.Type] == st.Type
+	})
+	find(func(st, bt *profile.ValueType) bool {
+		return bt.Type == st.Type
+	})
+	// Matching by unit is only unambiguous if a single type of each profile
+	// is left with it.
+	units := make(map[string]int)
+	for i, st := range p.SampleType {
+		if match[i] == -1 {
+			units[st.Unit]++
+		}
+	}
+	baseUnits := make(map[string]int)
+	for j, bt := range base.SampleType {
+		if !matched[j] {
+			baseUnits[bt.Unit]++
+		}
+	}
+	find(func(st, bt *profile.ValueType) bool {
+		return bt.Unit == st.Unit && units[st.Unit] == 1 && baseUnits[bt.Unit] == 1
+	})
+
+	var kept []int
+	for i, j := range match {
+		if j == -1 {
+			ui.PrintErr(fmt.Sprintf("Dropping sample type %s of the source profiles, not found in the base profiles", valueTypeString(p.SampleType[i])))
+			continue
+		}
+		kept = append(kept, i)
+	}
+	for j, bt := range base.SampleType {
+		if !matched[j] {
+			ui.PrintErr(fmt.Sprintf("Dropping sample type %s of the base profiles, not found in the source profiles", valueTypeString(bt)))
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("source and base profiles have no sample types in common: %s and %s, map them with -sample_type_map", valueTypesString(p.SampleType), valueTypesString(base.SampleType))
+	}
+
+	sampleTypes := make([]*profile.ValueType, len(kept))
+	baseTypes := make([]*profile.ValueType, len(kept))
+	baseIndex := make([]int, len(kept))
+	for k, i := range kept {
+		sampleTypes[k] = p.SampleType[i]
+		j := match[i]
+		if bt := base.SampleType[j]; bt.Type != p.SampleType[i].Type {
+			ui.PrintErr(fmt.Sprintf("Comparing sample type %s of the base profiles to %s of the source profiles", valueTypeString(bt), valueTypeString(p.SampleType[i])))
+		}
+		// The units are kept, for them to be scaled to those of the source
+		// profile.
+		baseTypes[k] = &profile.ValueType{Type: p.SampleType[i].Type, Unit: base.SampleType[j].Unit}
+		baseIndex[k] = j
+	}
+	selectValues(p, kept)
+	selectValues(base, baseIndex)
+	p.SampleType, base.SampleType = sampleTypes, baseTypes
+	if !hasSampleType(p, p.DefaultSampleType) {
+		p.DefaultSampleType = ""
+	}
+	base.DefaultSampleType = p.DefaultSampleType
+	return nil
+}
+
+// sameSampleTypes reports whether the profiles have the same types of
+// samples, in the same order, possibly with different units.
+func sameSampleTypes(p, base *profile.Profile) bool {
+	if len(p.SampleType) != len(base.SampleType) {
+		return false
+	}
+	for i, st := range p.SampleType {
+		if st.Type != base.SampleType[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// selectValues keeps the values of the samples of p at the given indexes,
+// in that order.
+func selectValues(p *profile.Profile, indexes []int) {
+	for _, s := range p.Sample {
+		values := make([]int64, len(indexes))
+		for k, i := range indexes {
+			values[k] = s.Value[i]
+		}
+		s.Value = values
+	}
+}
+
+// hasSampleType reports whether p has a sample type named t.
+func hasSampleType(p *profile.Profile, t string) bool {
+	for _, st := range p.SampleType {
+		if st.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+func valueTypeString(t *profile.ValueType) string {
+	return t.Type + "/" + t.Unit
+}
+
+func valueTypesString(types []*profile.ValueType) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = valueTypeString(t)
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestReconcileSampleTypes(t *testing.T) {
+	for _, tc := range []struct {
+		desc                 string
+		source, base         string
+		typeMap              string
+		wantTypes            string
+		wantSource           [][]int64
+		wantBase             [][]int64
+		wantPeriodType       string
+		wantDropped, wantErr string
+	}{
+		{
+			desc:       "same types",
+			source:     "sample_type alloc_space bytes\nsample_type inuse_space bytes\nstack main 1 2\n",
+			base:       "sample_type alloc_space kilobytes\nsample_type inuse_space bytes\nstack main 3 4\n",
+			wantTypes:  "alloc_space/bytes inuse_space/bytes",
+			wantSource: [][]int64{{1, 2}},
+			wantBase:   [][]int64{{3, 4}},
+		},
+		{
+			desc:        "reordered and dropped types",
+			source:      "sample_type inuse_objects count\nsample_type inuse_space bytes\nsample_type alloc_space bytes\nstack main 1 2 3\n",
+			base:        "sample_type alloc_space bytes\nsample_type inuse_objects count\nsample_type allocs count\nstack main 4 5 6\n",
+			wantTypes:   "inuse_objects/count alloc_space/bytes",
+			wantSource:  [][]int64{{1, 3}},
+			wantBase:    [][]int64{{5, 4}},
+			wantDropped: "inuse_space/bytes allocs/count",
+		},
+		{
+			desc:       "matched by unit",
+			source:     "period_type cpu nanoseconds\nsample_type cpu nanoseconds\nstack main 10\n",
+			base:       "period_type time nanoseconds\nsample_type time nanoseconds\nstack main 20\n",
+			wantTypes:  "cpu/nanoseconds",
+			wantSource: [][]int64{{10}},
+			wantBase:   [][]int64{{20}},
+		},
+		{
+			desc:       "mapped types",
+			source:     "sample_type inuse_objects count\nsample_type inuse_space bytes\nstack main 1 2\n",
+			base:       "sample_type objects count\nsample_type space kilobytes\nstack main 3 4\n",
+			typeMap:    "space=inuse_space,objects=inuse_objects",
+			wantTypes:  "inuse_objects/count inuse_space/bytes",
+			wantSource: [][]int64{{1, 2}},
+			wantBase:   [][]int64{{3, 4}},
+		},
+		{
+			desc:           "period types",
+			source:         "period_type space bytes\nsample_type inuse_space bytes\nstack main 1\n",
+			base:           "period_type objects count\nsample_type inuse_space bytes\nstack main 2\n",
+			wantTypes:      "inuse_space/bytes",
+			wantSource:     [][]int64{{1}},
+			wantBase:       [][]int64{{2}},
+			wantPeriodType: "space/bytes",
+		},
+		{
+			desc:    "no common types",
+			source:  "sample_type inuse_objects count\nsample_type inuse_space bytes\nstack main 1 2\n",
+			base:    "sample_type allocs count\nsample_type frees count\nstack main 3 4\n",
+			wantErr: "no sample types in common",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			parse := func(spec string) *profile.Profile {
+				t.Helper()
+				if !strings.HasPrefix(spec, "period_type ") {
+					spec = "period_type space bytes\n" + spec
+				}
+				p, err := parseSynthSpec(tc.desc, []byte("mapping /bin/app\n"+spec))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return p
+			}
+			p, base := parse(tc.source), parse(tc.base)
+			typeMap, err := parseSampleTypeMap(tc.typeMap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ui := &recordingUI{TestUI: proftest.TestUI{T: t}}
+			err = reconcileSampleTypes(p, base, typeMap, ui)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Trim(valueTypesString(p.SampleType), "[]"); got != tc.wantTypes {
+				t.Errorf("got sample types %s, want %s", got, tc.wantTypes)
+			}
+			// The base profile gets the types of the source profile, with
+			// its own units, for them to be scaled on merge.
+			for i, bt := range base.SampleType {
+				if bt.Type != p.SampleType[i].Type {
+					t.Errorf("got base sample type %s, want type %s", valueTypeString(bt), p.SampleType[i].Type)
+				}
+			}
+			if got := sampleValues(p); !reflect.DeepEqual(got, tc.wantSource) {
+				t.Errorf("got source values %v, want %v", got, tc.wantSource)
+			}
+			if got := sampleValues(base); !reflect.DeepEqual(got, tc.wantBase) {
+				t.Errorf("got base values %v, want %v", got, tc.wantBase)
+			}
+			if tc.wantPeriodType != "" {
+				if got := valueTypeString(base.PeriodType); got != tc.wantPeriodType {
+					t.Errorf("got base period type %s, want %s", got, tc.wantPeriodType)
+				}
+			}
+			var dropped []string
+			for _, e := range ui.errs {
+				if strings.HasPrefix(e, "Dropping sample type ") {
+					dropped = append(dropped, strings.Fields(e)[3])
+				}
+			}
+			if got := strings.Join(dropped, " "); got != tc.wantDropped {
+				t.Errorf("got dropped sample types %q, want %q", got, tc.wantDropped)
+			}
+			if _, _, err := combineProfiles([]*profile.Profile{p, base}, nil); err != nil {
+				t.Errorf("merging the reconciled profiles: %v", err)
+			}
+		})
+	}
+}
+
+// recordingUI records the messages printed with PrintErr.
+type recordingUI struct {
+	proftest.TestUI
+	errs []string
+}
+
+func (ui *recordingUI) PrintErr(args ...interface{}) {
+	ui.errs = append(ui.errs, fmt.Sprint(args...))
+}
+
+func sampleValues(p *profile.Profile) [][]int64 {
+	var values [][]int64
+	for _, s := range p.Sample {
+		values = append(values, s.Value)
+	}
+	return values
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// generateCachedReport generates the report cmd of p like generateReport,
+// reusing its output from the cache directory dir if the same report of
+// the same profile was generated before, and caching it otherwise. It
+// generates the report without a cache if dir is empty.
+func generateCachedReport(ctx context.Context, dir string, p *profile.Profile, cmd []string, cfg config, o *plugin.Options) error {
+	if dir == "" {
+		return generateReport(ctx, p, cmd, cfg, o)
+	}
+	cfg = terminalConfig(cmd, cfg, o)
+	key, err := reportCacheKey(p, cmd, cfg)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, key)
+	if b, err := ioutil.ReadFile(file); err == nil {
+		c := pprofCommands[cmd[0]]
+		if c == nil {
+			return fmt.Errorf("unknown command %s", cmd[0])
+		}
+		o.UI.PrintErr("Reusing the report cached in ", file)
+		return writeReport(bytes.NewBuffer(b), c, cfg, o)
+	}
+
+	c, src, err := renderReport(ctx, p, cmd, cfg, o)
+	if err != nil {
+		return err
+	}
+	if err := writeCacheFile(dir, file, src.Bytes()); err != nil {
+		o.UI.PrintErr("Could not cache the report: ", err)
+	}
+	return writeReport(src, c, cfg, o)
+}
+
+// reportCacheKey returns the key of the report cmd of p with cfg in a
+// report cache: the hash of the profile, the command, the configuration
+// but its output, and the version of pprof, which determine the report
+// along with the binaries and source files it may read.
+func reportCacheKey(p *profile.Profile, cmd []string, cfg config) (string, error) {
+	h := sha256.New()
+	if err := p.WriteUncompressed(h); err != nil {
+		return "", err
+	}
+	cfg.Output = ""
+	fmt.Fprintf(h, "\x00%q\x00%#v\x00%s", cmd, cfg, pprofVersion())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeCacheFile writes data to file in the directory dir, through a
+// temporary file so that concurrent pipelines never read a partial one.
+func writeCacheFile(dir, file string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
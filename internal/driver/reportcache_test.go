@@ -0,0 +1,89 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestGenerateCachedReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "Generating report in|Reusing the report cached in"},
+		HTTPTransport: &httpTransport{},
+	})
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(dir, "top.txt")
+	generate := func(cfg config) string {
+		t.Helper()
+		if err := generateCachedReport(context.Background(), cacheDir, makeFakeProfile(), []string{"top"}, cfg, o); err != nil {
+			t.Fatalf("generateCachedReport: %v", err)
+		}
+		got, err := ioutil.ReadFile(cfg.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+	cached := func() []string {
+		t.Helper()
+		files, err := filepath.Glob(filepath.Join(cacheDir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	if got := generate(cfg); !strings.Contains(got, "F2") {
+		t.Fatalf("got report %q, want the top functions", got)
+	}
+	files := cached()
+	if len(files) != 1 {
+		t.Fatalf("got cached files %v, want one", files)
+	}
+
+	// The same report, even to another output, reuses the cached one.
+	if err := ioutil.WriteFile(files[0], []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Output = filepath.Join(dir, "other.txt")
+	if got := generate(cfg); got != "cached" {
+		t.Errorf("got report %q, want the cached report", got)
+	}
+
+	// Other options generate another report.
+	cfg.NodeCount = 1
+	if got := generate(cfg); got == "cached" {
+		t.Errorf("got the cached report with other options")
+	}
+	if files := cached(); len(files) != 2 {
+		t.Errorf("got cached files %v, want two", files)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/symbolizer"
+	"github.com/google/pprof/profile"
+)
+
+// symbolize symbolizes p with the mapping sources m, as set by -symbolize
+// and -resume. Canceling ctx, as by interrupting pprof, or running out of
+// the time of the timeout option of -symbolize, interrupts the symbolizer
+// of pprof: the partially symbolized profile is then saved, for -resume
+// to complete its symbolization instead of starting over.
+func symbolize(ctx context.Context, s *source, m plugin.MappingSources, p *profile.Profile, o *plugin.Options) error {
+	defer recordDuration(o, "symbolize", time.Now())
+	mode := s.Symbolize
+	if s.Resume {
+		mode += ":resume"
+	}
+	sym := o.Sym
+	if ps, ok := sym.(*symbolizer.Symbolizer); ok {
+		is := *ps
+		is.Interrupt = ctx.Done()
+		sym = &is
+	}
+	err := sym.Symbolize(mode, m, p)
+	if !errors.Is(err, symbolizer.ErrInterrupted) {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+
+	dir, derr := setTmpDir(o.UI)
+	if derr != nil {
+		return fmt.Errorf("%v, and the partially symbolized profile could not be saved: %v", err, derr)
+	}
+	f, derr := newTempFile(dir, "pprof.partial.", ".pb.gz")
+	if derr == nil {
+		derr = p.Write(f)
+		f.Close()
+	}
+	if derr != nil {
+		return fmt.Errorf("%v, and the partially symbolized profile could not be saved: %v", err, derr)
+	}
+	return fmt.Errorf("%v: saved the partially symbolized profile in %s, complete its symbolization with pprof -resume %s", err, f.Name(), f.Name())
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/symbolizer"
+	"github.com/google/pprof/profile"
+)
+
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PPROF_TMPDIR", os.Getenv("PPROF_TMPDIR"))
+	os.Setenv("PPROF_TMPDIR", dir)
+
+	m := &profile.Mapping{ID: 1, Start: 0x1000, Limit: 0x4000, File: "binary"}
+	loc := &profile.Location{ID: 1, Mapping: m, Address: 0x2000}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "milliseconds"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{10}}},
+		Location:   []*profile.Location{loc},
+		Mapping:    []*profile.Mapping{m},
+	}
+	o := setDefaults(&plugin.Options{Obj: &mockObjTool{}, UI: &proftest.TestUI{T: t}, HTTPTransport: &httpTransport{}})
+
+	saved := regexp.MustCompile(`^symbolization interrupted: saved the partially symbolized profile in (.*pprof\.partial\.001\.pb\.gz), complete its symbolization with pprof -resume`)
+	// The symbolization is interrupted as soon as it starts.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = symbolize(ctx, &source{Symbolize: "local"}, nil, p, o)
+	if err == nil {
+		t.Fatalf("got no error, want %q", saved)
+	}
+	match := saved.FindStringSubmatch(err.Error())
+	if match == nil {
+		t.Fatalf("got error %v, want %q", err, saved)
+	}
+	f, err := os.Open(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	partial, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial.Comments) != 1 || partial.Comments[0] != symbolizer.PartialComment {
+		t.Errorf("got comments %q in the saved profile, want the partial symbolization marker", partial.Comments)
+	}
+
+	if err := symbolize(context.Background(), &source{Symbolize: "local", Resume: true}, nil, partial, o); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if len(partial.Comments) != 0 || len(partial.Location[0].Line) != 2 {
+		t.Errorf("got comments %q and lines %v after resuming, want the location symbolized", partial.Comments, partial.Location[0].Line)
+	}
+}
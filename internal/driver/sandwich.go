@@ -0,0 +1,164 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/report"
+)
+
+// sandwichTrees holds the stack trees of the sandwich view.
+type sandwichTrees struct {
+	Callers *treeNode `json:"callers"`
+	Callees *treeNode `json:"callees"`
+}
+
+// sandwich generates a web page with the sandwich view of the functions
+// matching the f parameter: a flame graph of their callers, drawn upwards
+// from them, above a flame graph of their callees.
+func (ui *webInterface) sandwich(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeFlameGraphReport(w, req)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	var trees *sandwichTrees
+	if f := req.URL.Query().Get("f"); f != "" {
+		rx, err := regexp.Compile(f)
+		if err != nil {
+			http.Error(w, "invalid regexp: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		trees = sandwichTree(rpt, rx)
+	}
+	var js template.JS
+	if trees != nil {
+		b, err := json.Marshal(trees)
+		if err != nil {
+			http.Error(w, "error serializing sandwich view", http.StatusInternalServerError)
+			ui.options.UI.PrintErr(err)
+			return
+		}
+		js = template.JS(b)
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "sandwich", rpt, errList, legend, webArgs{
+		FlameGraph: js,
+	})
+}
+
+// sandwichTree returns the trees of the callers and of the callees of
+// the functions matching rx in the call tree of rpt, both rooted at those
+// functions and weighted by their cumulative values, or nil if no
+// function matches. A stack counts once, at its outermost matching frame,
+// so that the values of recursive functions are not counted twice.
+func sandwichTree(rpt *report.Report, rx *regexp.Regexp) *sandwichTrees {
+	g, config := report.GetDOT(rpt)
+	parent := func(n *graph.Node) *graph.Node {
+		for p := range n.In {
+			return p
+		}
+		return nil
+	}
+
+	children := make(map[*treeNode]map[string]*treeNode)
+	child := func(t *treeNode, n *graph.Node) *treeNode {
+		name := n.Info.PrintableName()
+		if c := children[t][name]; c != nil {
+			return c
+		}
+		c := &treeNode{
+			Name:     graph.TruncateName(graph.ShortenFunctionName(name), config.MaxNameLen),
+			FullName: name,
+		}
+		if children[t] == nil {
+			children[t] = make(map[string]*treeNode)
+		}
+		children[t][name] = c
+		t.Children = append(t.Children, c)
+		return c
+	}
+	var addCallees func(t *treeNode, n *graph.Node)
+	addCallees = func(t *treeNode, n *graph.Node) {
+		for c := range n.Out {
+			tc := child(t, c)
+			tc.Cum += c.CumValue()
+			addCallees(tc, c)
+		}
+	}
+
+	callers, callees := &treeNode{}, &treeNode{}
+	names := make(map[string]bool)
+	for _, n := range g.Nodes {
+		name := n.Info.PrintableName()
+		if !rx.MatchString(name) {
+			continue
+		}
+		outermost := true
+		for p := parent(n); p != nil && outermost; p = parent(p) {
+			outermost = !rx.MatchString(p.Info.PrintableName())
+		}
+		if !outermost {
+			continue
+		}
+		names[name] = true
+		v := n.CumValue()
+		callers.Cum += v
+		callees.Cum += v
+		t := callers
+		for p := parent(n); p != nil; p = parent(p) {
+			t = child(t, p)
+			t.Cum += v
+		}
+		addCallees(callees, n)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// Name the roots after the function, or after the regexp if several
+	// functions match it.
+	name := rx.String()
+	if len(names) == 1 {
+		for n := range names {
+			name = n
+		}
+	}
+	var format func(t *treeNode)
+	format = func(t *treeNode) {
+		t.CumFormat = config.FormatValue(t.Cum)
+		t.Percent = strings.TrimSpace(measurement.Percentage(t.Cum, config.Total))
+		for _, c := range t.Children {
+			format(c)
+		}
+	}
+	for _, t := range []*treeNode{callers, callees} {
+		t.Name = graph.TruncateName(graph.ShortenFunctionName(name), config.MaxNameLen)
+		t.FullName = name
+		format(t)
+	}
+	return &sandwichTrees{Callers: callers, Callees: callees}
+}
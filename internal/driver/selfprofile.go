@@ -0,0 +1,99 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+)
+
+// selfProfiler profiles pprof itself for -selfprofile, writing a CPU
+// profile whose samples are labeled with the phase of pprof they were
+// taken in, such as fetch or report, and a heap profile after each phase,
+// to a directory. A nil selfProfiler runs the phases without profiling.
+type selfProfiler struct {
+	dir string
+	cpu *os.File
+}
+
+// startSelfProfile starts profiling pprof to the directory dir, or returns
+// nil if dir is empty.
+func startSelfProfile(dir string) (*selfProfiler, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, "cpu.pb.gz"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &selfProfiler{dir: dir, cpu: f}, nil
+}
+
+// phase runs f as the phase name of pprof, labeling the CPU samples taken
+// while it runs with that phase, and writes a heap profile once it has run.
+func (sp *selfProfiler) phase(name string, ui plugin.UI, f func() error) error {
+	if sp == nil || sp.cpu == nil {
+		return f()
+	}
+	var err error
+	pprof.Do(context.Background(), pprof.Labels(report.PhaseLabel, name), func(context.Context) {
+		err = f()
+	})
+	if herr := sp.writeHeap(name); herr != nil {
+		ui.PrintErr("Could not write the heap profile of pprof: ", herr)
+	}
+	return err
+}
+
+// writeHeap writes the heap profile of pprof after the phase name, with
+// the allocations made until then.
+func (sp *selfProfiler) writeHeap(name string) error {
+	f, err := os.Create(filepath.Join(sp.dir, "heap."+name+".pb.gz"))
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stop stops profiling pprof, if it has not been stopped yet.
+func (sp *selfProfiler) stop(ui plugin.UI) {
+	if sp == nil || sp.cpu == nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	if err := sp.cpu.Close(); err != nil {
+		ui.PrintErr("Could not write the CPU profile of pprof: ", err)
+	}
+	sp.cpu = nil
+	ui.PrintErr("Wrote the profiles of pprof to ", sp.dir)
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestSelfProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfprofile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sp, err := startSelfProfile(dir)
+	if err != nil {
+		t.Fatalf("startSelfProfile: %v", err)
+	}
+	ui := &proftest.TestUI{T: t, AllowRx: "Wrote the profiles of pprof to"}
+	ran := false
+	if err := sp.phase("report", ui, func() error {
+		ran = true
+		return nil
+	}); err != nil || !ran {
+		t.Errorf("phase: ran %v, got error %v", ran, err)
+	}
+	sp.stop(ui)
+	sp.stop(ui)
+	if ui.NumAllowRxMatches != 1 {
+		t.Errorf("got %d messages about the profiles written, want 1", ui.NumAllowRxMatches)
+	}
+	for _, name := range []string{"cpu.pb.gz", "heap.report.pb.gz"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("missing profile of pprof: %v", err)
+			continue
+		}
+		if _, err := profile.Parse(f); err != nil {
+			t.Errorf("parsing %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	// Without -selfprofile, the phases just run.
+	var none *selfProfiler
+	if err := none.phase("fetch", ui, func() error { return fmt.Errorf("failed") }); err == nil {
+		t.Errorf("got no error from a failed phase")
+	}
+	none.stop(ui)
+}
+
+// largeProfile returns a profile of the size of those of large services,
+// with deep stacks over many functions, for the benchmarks of pprof.
+// Profiles of pprof running the benchmarks, with go test -cpuprofile, show
+// where the time goes; -selfprofile does the same for pprof runs.
+func largeProfile() *profile.Profile {
+	const (
+		functions = 2000
+		samples   = 20000
+		maxDepth  = 40
+	)
+	r := rand.New(rand.NewSource(1))
+	m := &profile.Mapping{ID: 1, Start: 0x400000, Limit: 0x10000000, File: "/bin/server", HasFunctions: true}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+		Mapping:    []*profile.Mapping{m},
+	}
+	for i := 0; i < functions; i++ {
+		f := &profile.Function{
+			ID:       uint64(i + 1),
+			Name:     fmt.Sprintf("server/pkg%d.Function%d", i%100, i),
+			Filename: fmt.Sprintf("server/pkg%d/file%d.go", i%100, i%700),
+		}
+		p.Function = append(p.Function, f)
+		p.Location = append(p.Location, &profile.Location{
+			ID:      uint64(i + 1),
+			Mapping: m,
+			Address: m.Start + uint64(i)*0x40,
+			Line:    []profile.Line{{Function: f, Line: int64(r.Intn(1000) + 1)}},
+		})
+	}
+	for i := 0; i < samples; i++ {
+		s := &profile.Sample{
+			Value: []int64{1, 10000000},
+			Label: map[string][]string{"request": {fmt.Sprintf("r%d", r.Intn(20))}},
+		}
+		// Stacks share their root frames, as in real programs.
+		for d := r.Intn(maxDepth) + 1; d > 0; d-- {
+			s.Location = append(s.Location, p.Location[(d*97+r.Intn(d*50+1))%functions])
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+func BenchmarkParse(b *testing.B) {
+	var data bytes.Buffer
+	if err := largeProfile().Write(&data); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(data.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := profile.Parse(bytes.NewReader(data.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	p := largeProfile()
+	for i := 0; i < b.N; i++ {
+		if _, err := profile.Merge([]*profile.Profile{p, p, p, p}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReport(b *testing.B) {
+	p := largeProfile()
+	o := setDefaults(&plugin.Options{HTTPTransport: &httpTransport{}})
+	for _, cmd := range []string{"top", "peek", "traces", "tags", "dot", "proto"} {
+		b.Run(cmd, func(b *testing.B) {
+			cfg := currentConfig()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := renderReport(context.Background(), p, []string{cmd}, cfg, o); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"time"
+
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// labelSeries records the profile fetched from source as the series
+// label of all its samples, as the time the profile was collected at, or
+// as source if its time is unknown. An existing label takes precedence.
+func labelSeries(p *profile.Profile, source string) {
+	name := source
+	if p.TimeNanos != 0 {
+		name = time.Unix(0, p.TimeNanos).UTC().Format(report.SeriesTimeFormat)
+	}
+	for _, s := range p.Sample {
+		setProcessLabel(s, report.SeriesLabel, name)
+	}
+}
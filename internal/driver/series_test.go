@@ -0,0 +1,41 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestLabelSeries(t *testing.T) {
+	at := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		timeNanos int64
+		labels    map[string][]string
+		want      string
+	}{
+		{at.UnixNano(), nil, "2020-06-01T10:00:00Z"},
+		{0, nil, "cpu.pb.gz"},
+		{at.UnixNano(), map[string][]string{"series": {"canary"}}, "canary"},
+	} {
+		p := &profile.Profile{TimeNanos: tc.timeNanos, Sample: []*profile.Sample{{Label: tc.labels}}}
+		labelSeries(p, "cpu.pb.gz")
+		if got := firstLabelValue(p.Sample[0], "series"); got != tc.want {
+			t.Errorf("series of a profile at %d with labels %v: got %q, want %q", tc.timeNanos, tc.labels, got, tc.want)
+		}
+	}
+}
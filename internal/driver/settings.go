@@ -7,14 +7,50 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // settings holds pprof settings.
 type settings struct {
 	// Configs holds a list of named UI configurations.
 	Configs []namedConfig `json:"configs"`
+
+	// LabelLinks holds the URL templates that the values of labels link
+	// to in the web UI, by label key. The "{key}" and "{value}" strings of
+	// a template are replaced by the key and value of the label.
+	LabelLinks map[string]string `json:"label_links,omitempty"`
+
+	// Preferences holds the view preferences of the web UI.
+	Preferences preferences `json:"preferences"`
+}
+
+// preferences holds the view preferences of the user of the web UI,
+// which persist across its sessions.
+type preferences struct {
+	// Orientation is the orientation of flame graphs: "icicle" for the
+	// root at the top, and "flame" for the root at the bottom. It is
+	// "icicle" if empty.
+	Orientation string `json:"orientation,omitempty"`
+
+	// Theme is the color scheme: "light" or "dark", or that of the
+	// system if empty.
+	Theme string `json:"theme,omitempty"`
+
+	// DefaultView is the view opened at the root of the web UI, eg
+	// "flamegraph", or the graph if empty.
+	DefaultView string `json:"default_view,omitempty"`
+
+	// SampleIndex lists the sample types of the views whose URL selects
+	// none, separated by commas, in order of preference: the first one
+	// the profile holds is shown. The default of the profile is shown if
+	// it holds none of them.
+	SampleIndex string `json:"sample_index,omitempty"`
 }
 
+// defaultViews are the views that can be opened at the root of the web
+// UI, besides the graph.
+var defaultViews = []string{"top", "flamegraph", "treemap", "peek", "sandwich", "tags", "traces"}
+
 // namedConfig associates a name with a config.
 type namedConfig struct {
 	Name string `json:"name"`
@@ -108,6 +144,27 @@ func configMenu(fname string, url url.URL) []configMenuEntry {
 	return result
 }
 
+// labelLinks returns a function returning the URL that a value of a label
+// links to, following the label_links settings of fname, or "" if the
+// label has no link.
+func labelLinks(fname string) func(key, value string) string {
+	var templates map[string]string
+	if settings, err := readSettings(fname); err == nil {
+		templates = settings.LabelLinks
+	}
+	return func(key, value string) string {
+		tmpl, ok := templates[key]
+		if !ok {
+			return ""
+		}
+		link := strings.NewReplacer("{key}", url.QueryEscape(key), "{value}", url.QueryEscape(value)).Replace(tmpl)
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ""
+		}
+		return link
+	}
+}
+
 // editSettings edits settings by applying fn to them.
 func editSettings(fname string, fn func(s *settings) error) error {
 	settings, err := readSettings(fname)
@@ -143,6 +200,37 @@ func setConfig(fname string, request url.URL) error {
 	})
 }
 
+// setPreferences saves the preferences of form to fname, leaving out of
+// form those not to change.
+func setPreferences(fname string, form url.Values) error {
+	return editSettings(fname, func(s *settings) error {
+		for _, p := range []struct {
+			name  string
+			value *string
+			valid []string
+		}{
+			{"orientation", &s.Preferences.Orientation, []string{"icicle", "flame"}},
+			{"theme", &s.Preferences.Theme, []string{"light", "dark"}},
+			{"default_view", &s.Preferences.DefaultView, defaultViews},
+			{"sample_index", &s.Preferences.SampleIndex, nil},
+		} {
+			if _, ok := form[p.name]; !ok {
+				continue
+			}
+			v := strings.TrimSpace(form.Get(p.name))
+			valid := v == "" || p.valid == nil
+			for _, ok := range p.valid {
+				valid = valid || v == ok
+			}
+			if !valid {
+				return fmt.Errorf("invalid %s preference %q", p.name, v)
+			}
+			*p.value = v
+		}
+		return nil
+	})
+}
+
 // removeConfig removes config from fname.
 func removeConfig(fname, config string) error {
 	return editSettings(fname, func(s *settings) error {
@@ -63,6 +63,8 @@ func TestParseConfig(t *testing.T) {
 		DropNegative:        true,
 		CallTree:            true,
 		RelativePercentages: true,
+		PercentBase:         "node",
+		PercentNode:         "percent_node",
 		Unit:                "auto",
 		CompactLabels:       true,
 		SourcePath:          "",
@@ -245,3 +247,53 @@ func TestAssign(t *testing.T) {
 		t.Errorf("allowed assignment of invalid granularity")
 	}
 }
+
+func TestLabelLinks(t *testing.T) {
+	tmpDir, fname := settingsDirAndFile(t)
+	defer os.RemoveAll(tmpDir)
+	if err := writeSettings(fname, &settings{LabelLinks: map[string]string{
+		"trace_id": "https://jaeger.example.com/trace/{value}",
+		"pod":      "https://logs.example.com/search?q={key}%3D{value}",
+		"script":   "javascript:alert('{value}')",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	link := labelLinks(fname)
+	for _, tc := range []struct {
+		key, value, want string
+	}{
+		{"trace_id", "4bf92f35", "https://jaeger.example.com/trace/4bf92f35"},
+		{"pod", "api 1&2", "https://logs.example.com/search?q=pod%3Dapi+1%262"},
+		{"script", "x", ""},
+		{"thread", "main", ""},
+	} {
+		if got := link(tc.key, tc.value); got != tc.want {
+			t.Errorf("link(%q, %q) = %q, want %q", tc.key, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestSetPreferences(t *testing.T) {
+	tmpDir, fname := settingsDirAndFile(t)
+	defer os.RemoveAll(tmpDir)
+	if err := setPreferences(fname, url.Values{"orientation": {"flame"}, "default_view": {"flamegraph"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The preferences left out are kept.
+	if err := setPreferences(fname, url.Values{"theme": {"dark"}, "sample_index": {" alloc_space, cpu "}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, form := range []url.Values{{"theme": {"blue"}}, {"default_view": {"saveconfig"}}} {
+		if err := setPreferences(fname, form); err == nil {
+			t.Errorf("setPreferences(%v) succeeded, want an error", form)
+		}
+	}
+	s, err := readSettings(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := preferences{Orientation: "flame", Theme: "dark", DefaultView: "flamegraph", SampleIndex: "alloc_space, cpu"}
+	if s.Preferences != want {
+		t.Errorf("got preferences %+v, want %+v", s.Preferences, want)
+	}
+}
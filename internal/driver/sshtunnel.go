@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// sshTunnelPoll is the interval at which the web interface of the remote
+// pprof is checked until it serves requests.
+const sshTunnelPoll = 200 * time.Millisecond
+
+// serveSSHTunnel runs pprof with the command line args on the remote host
+// of src.SSHTunnel, as user@host, serving its web interface through an ssh
+// tunnel to the local port of src.HTTPHostport. It opens the browser once
+// the remote web interface serves requests, and returns when ssh exits,
+// which stops the remote pprof and closes the tunnel.
+func serveSSHTunnel(src *source, args []string, o *plugin.Options) error {
+	_, port, err := getHostAndPort(src.HTTPHostport)
+	if err != nil {
+		return err
+	}
+	remote := "pprof"
+	for _, a := range sshTunnelArgs(args, port) {
+		remote += " " + shellQuote(a)
+	}
+	fwd := fmt.Sprintf("localhost:%d:localhost:%d", port, port)
+	ssh := exec.Command("ssh", "-L", fwd, "-o", "ExitOnForwardFailure=yes", src.SSHTunnel, remote)
+	ssh.Stdin, ssh.Stdout, ssh.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := ssh.Start(); err != nil {
+		return fmt.Errorf("could not start ssh: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- ssh.Wait() }()
+
+	url := "http://" + net.JoinHostPort("localhost", strconv.Itoa(port))
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("ssh to %s: %v", src.SSHTunnel, err)
+			}
+			return nil
+		case <-time.After(sshTunnelPoll):
+		}
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+			break
+		}
+	}
+
+	o.UI.PrintErr("Serving the web UI of pprof on ", src.SSHTunnel, " at ", url)
+	if !src.HTTPDisableBrowser {
+		openBrowser(url, o)
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("ssh to %s: %v", src.SSHTunnel, err)
+	}
+	return nil
+}
+
+// sshTunnelArgs returns the arguments of the remote pprof run by
+// serveSSHTunnel from the local command line args: those of the local
+// tunnel and browser are replaced by the local port of the web interface.
+func sshTunnelArgs(args []string, port int) []string {
+	remote := []string{"-http=localhost:" + strconv.Itoa(port), "-no_browser"}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			remote = append(remote, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			remote = append(remote, a)
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		hasValue := false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, hasValue = name[:eq], true
+		}
+		switch name {
+		case "http", "ssh_tunnel":
+			if !hasValue {
+				i++ // Skip the value in the next argument.
+			}
+			continue
+		case "no_browser":
+			continue
+		}
+		remote = append(remote, a)
+	}
+	return remote
+}
+
+// shellQuote quotes s as a single word for the remote shell running the
+// command of ssh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSSHTunnelArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args, want []string
+	}{
+		{
+			args: []string{"-http=:8080", "-ssh_tunnel", "me@host", "/tmp/cpu.pb.gz"},
+			want: []string{"-http=localhost:8080", "-no_browser", "/tmp/cpu.pb.gz"},
+		},
+		{
+			args: []string{"--ssh_tunnel=me@host", "-http", "localhost:", "-no_browser", "-focus", "main", "bin", "cpu.pb.gz"},
+			want: []string{"-http=localhost:8080", "-no_browser", "-focus", "main", "bin", "cpu.pb.gz"},
+		},
+		{
+			// The arguments after -- are not flags.
+			args: []string{"-ssh_tunnel=me@host", "-http=:", "--", "-http"},
+			want: []string{"-http=localhost:8080", "-no_browser", "--", "-http"},
+		},
+	} {
+		if got := sshTunnelArgs(tc.args, 8080); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("sshTunnelArgs(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"cpu.pb.gz":  `'cpu.pb.gz'`,
+		"main|init":  `'main|init'`,
+		"it's $HOME": `'it'\''s $HOME'`,
+		"":           `''`,
+	} {
+		if got := shellQuote(s); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !pprof_lite
+// +build !pprof_lite
+
 package driver
 
 import (
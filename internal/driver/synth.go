@@ -0,0 +1,446 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// synthUsage describes the specification of synthetic profiles.
+const synthUsage = `
+  pprof synth [-output=file] <spec>
+
+  Generates a profile.proto file from a specification, read from the
+  file <spec> or from the standard input if it is "-". The profile is
+  written to the -output file or to the standard output. Each line of
+  the specification holds a directive:
+
+    sample_type <type> <unit>      Adds a sample type (default: samples count)
+    default_sample_type <type>     Sets the default sample type
+    period_type <type> <unit>      Sets the period type
+    period <n>                     Sets the sampling period
+    time <RFC3339 time>            Sets the time of collection
+    duration <duration>            Sets the duration, eg 10s
+    comment <text>                 Adds a comment
+    mapping <file> [start=<addr>] [size=<n>] [offset=<n>] [build_id=<id>]
+                                   Adds a mapping, with a build id derived
+                                   from the file name unless specified
+    stack <frames> <value>... [<key>=<value>]... [<key>:=<n>[:<unit>]]...
+                                   Adds a sample with a value per sample type
+                                   and optional string and numeric labels
+
+  Frames are listed from the root to the leaf separated by semicolons,
+  each as [<mapping>!]<function>[@<file>[:<line>]], where <mapping> is
+  the base name of a mapping file and defaults to the first mapping.
+  Fields holding spaces, such as the frames of "operator new", are
+  quoted as Go strings, eg "main;operator new" or "key=a b". Lines
+  starting with # are ignored.
+`
+
+// generateSynthetic writes the profile described by the specification in
+// the files of args, which must hold a single file name.
+func generateSynthetic(ctx context.Context, args []string, cfg config, o *plugin.Options) error {
+	if len(args) != 1 {
+		return errors.New("pprof synth takes a single specification file" + synthUsage)
+	}
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	p, err := parseSynthSpec(args[0], data)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Output == "" {
+		return p.Write(os.Stdout)
+	}
+	o.UI.PrintErr("Generating profile in ", cfg.Output)
+	out, err := o.Writer.Open(cfg.Output)
+	if err != nil {
+		return err
+	}
+	if err := p.Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// synthBuilder builds a profile from a specification.
+type synthBuilder struct {
+	p         *profile.Profile
+	mappings  map[string]*profile.Mapping
+	functions map[synthFunction]*profile.Function
+	locations map[synthLocation]*profile.Location
+	// next is the address of the next location of each mapping.
+	next map[*profile.Mapping]uint64
+}
+
+type synthFunction struct {
+	name, file string
+}
+
+type synthLocation struct {
+	m    *profile.Mapping
+	f    *profile.Function
+	line int64
+}
+
+// parseSynthSpec parses the specification of a synthetic profile named
+// name.
+func parseSynthSpec(name string, data []byte) (*profile.Profile, error) {
+	b := &synthBuilder{
+		p:         &profile.Profile{},
+		mappings:  make(map[string]*profile.Mapping),
+		functions: make(map[synthFunction]*profile.Function),
+		locations: make(map[synthLocation]*profile.Location),
+		next:      make(map[*profile.Mapping]uint64),
+	}
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := synthFields(line)
+		if err == nil {
+			err = b.directive(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.p.SampleType) == 0 {
+		b.p.SampleType = []*profile.ValueType{{Type: "samples", Unit: "count"}}
+	}
+	if err := b.p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b.p, nil
+}
+
+// synthFields splits a line of the specification in fields separated by
+// spaces, unquoting the parts of the fields quoted as Go strings, which can
+// hold spaces.
+func synthFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"':
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted string %s", line[i:])
+			}
+			s, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[i:end+1])
+			}
+			field.WriteString(s)
+			inField = true
+			i = end
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// directive applies a line of the specification, split in fields.
+func (b *synthBuilder) directive(fields []string) error {
+	p := b.p
+	args := fields[1:]
+	nargs := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			return fmt.Errorf("wrong number of arguments for %s", fields[0])
+		}
+		return nil
+	}
+
+	switch fields[0] {
+	case "sample_type":
+		if err := nargs(2, 2); err != nil {
+			return err
+		}
+		if len(p.Sample) > 0 {
+			return errors.New("sample_type must precede all stacks")
+		}
+		p.SampleType = append(p.SampleType, &profile.ValueType{Type: args[0], Unit: args[1]})
+	case "default_sample_type":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		p.DefaultSampleType = args[0]
+	case "period_type":
+		if err := nargs(2, 2); err != nil {
+			return err
+		}
+		p.PeriodType = &profile.ValueType{Type: args[0], Unit: args[1]}
+	case "period":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(args[0], 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid period: %v", err)
+		}
+		p.Period = v
+	case "time":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339, args[0])
+		if err != nil {
+			return fmt.Errorf("invalid time: %v", err)
+		}
+		p.TimeNanos = t.UnixNano()
+	case "duration":
+		if err := nargs(1, 1); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid duration: %v", err)
+		}
+		p.DurationNanos = int64(d)
+	case "comment":
+		p.Comments = append(p.Comments, strings.Join(args, " "))
+	case "mapping":
+		if err := nargs(1, 5); err != nil {
+			return err
+		}
+		return b.addMapping(args[0], args[1:])
+	case "stack":
+		if err := nargs(2, len(args)); err != nil {
+			return err
+		}
+		return b.addSample(args[0], args[1:])
+	default:
+		return fmt.Errorf("unknown directive %q", fields[0])
+	}
+	return nil
+}
+
+// addMapping adds a mapping for file with the given key=value attributes.
+func (b *synthBuilder) addMapping(file string, attrs []string) error {
+	p := b.p
+	m := &profile.Mapping{
+		ID:              uint64(len(p.Mapping) + 1),
+		File:            file,
+		HasFunctions:    true,
+		HasFilenames:    true,
+		HasLineNumbers:  true,
+		HasInlineFrames: true,
+	}
+	// By default, mappings are laid out one after another.
+	const defaultSize = 0x100000
+	m.Start = 0x400000
+	if n := len(p.Mapping); n > 0 {
+		m.Start = p.Mapping[n-1].Limit
+	}
+	size := uint64(defaultSize)
+	sum := sha1.Sum([]byte(file))
+	m.BuildID = hex.EncodeToString(sum[:])
+	for _, attr := range attrs {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid mapping attribute %q", attr)
+		}
+		if kv[0] == "build_id" {
+			m.BuildID = kv[1]
+			continue
+		}
+		v, err := strconv.ParseUint(kv[1], 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid mapping attribute %q: %v", attr, err)
+		}
+		switch kv[0] {
+		case "start":
+			m.Start = v
+		case "size":
+			size = v
+		case "offset":
+			m.Offset = v
+		default:
+			return fmt.Errorf("unknown mapping attribute %q", kv[0])
+		}
+	}
+	if size == 0 {
+		return errors.New("mapping size must not be zero")
+	}
+	m.Limit = m.Start + size
+	if _, ok := b.mappings[filepath.Base(file)]; ok {
+		return fmt.Errorf("duplicate mapping %s", file)
+	}
+	b.mappings[filepath.Base(file)] = m
+	p.Mapping = append(p.Mapping, m)
+	return nil
+}
+
+// addSample adds a sample for a stack with the given values and labels.
+func (b *synthBuilder) addSample(stack string, args []string) error {
+	p := b.p
+	if len(p.SampleType) == 0 {
+		p.SampleType = []*profile.ValueType{{Type: "samples", Unit: "count"}}
+	}
+	s := &profile.Sample{}
+	for _, arg := range args {
+		if !strings.Contains(arg, "=") {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid sample value %q", arg)
+			}
+			s.Value = append(s.Value, v)
+			continue
+		}
+		if kv := strings.SplitN(arg, ":=", 2); len(kv) == 2 {
+			value, unit := kv[1], ""
+			if i := strings.Index(value, ":"); i != -1 {
+				value, unit = value[:i], value[i+1:]
+			}
+			v, err := strconv.ParseInt(value, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid numeric label %q", arg)
+			}
+			if s.NumLabel == nil {
+				s.NumLabel, s.NumUnit = make(map[string][]int64), make(map[string][]string)
+			}
+			s.NumLabel[kv[0]] = append(s.NumLabel[kv[0]], v)
+			s.NumUnit[kv[0]] = append(s.NumUnit[kv[0]], unit)
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		s.Label[kv[0]] = append(s.Label[kv[0]], kv[1])
+	}
+	if len(s.Value) != len(p.SampleType) {
+		return fmt.Errorf("got %d sample values, want one per sample type (%d)", len(s.Value), len(p.SampleType))
+	}
+
+	frames := strings.Split(stack, ";")
+	for i := len(frames) - 1; i >= 0; i-- {
+		l, err := b.location(frames[i])
+		if err != nil {
+			return err
+		}
+		s.Location = append(s.Location, l)
+	}
+	p.Sample = append(p.Sample, s)
+	return nil
+}
+
+// location returns the location for a frame of a stack, written as
+// [mapping!]function[@file[:line]].
+func (b *synthBuilder) location(frame string) (*profile.Location, error) {
+	p := b.p
+	var m *profile.Mapping
+	if i := strings.Index(frame, "!"); i != -1 {
+		var ok bool
+		if m, ok = b.mappings[frame[:i]]; !ok {
+			return nil, fmt.Errorf("unknown mapping %q in frame %q", frame[:i], frame)
+		}
+		frame = frame[i+1:]
+	} else if len(p.Mapping) > 0 {
+		m = p.Mapping[0]
+	}
+	name, file, line := frame, "", int64(0)
+	if i := strings.LastIndex(frame, "@"); i != -1 {
+		name, file = frame[:i], frame[i+1:]
+		if j := strings.LastIndex(file, ":"); j != -1 {
+			n, err := strconv.ParseInt(file[j+1:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid line number in frame %q", frame)
+			}
+			file, line = file[:j], n
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing function name in frame %q", frame)
+	}
+
+	fk := synthFunction{name, file}
+	f, ok := b.functions[fk]
+	if !ok {
+		f = &profile.Function{ID: uint64(len(p.Function) + 1), Name: name, SystemName: name, Filename: file}
+		b.functions[fk] = f
+		p.Function = append(p.Function, f)
+	}
+	lk := synthLocation{m, f, line}
+	if l, ok := b.locations[lk]; ok {
+		return l, nil
+	}
+	l := &profile.Location{
+		ID:      uint64(len(p.Location) + 1),
+		Mapping: m,
+		Line:    []profile.Line{{Function: f, Line: line}},
+	}
+	if m != nil {
+		// Give each location a distinct address within its mapping.
+		const stride = 0x10
+		if b.next[m] == 0 {
+			b.next[m] = m.Start + stride
+		}
+		if b.next[m] >= m.Limit {
+			return nil, fmt.Errorf("too many locations for the size of mapping %s", m.File)
+		}
+		l.Address = b.next[m]
+		b.next[m] += stride
+	}
+	b.locations[lk] = l
+	p.Location = append(p.Location, l)
+	return l, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSynthSpec(t *testing.T) {
+	const spec = `
+# A CPU profile of two binaries.
+sample_type samples count
+sample_type cpu nanoseconds
+default_sample_type cpu
+period_type cpu nanoseconds
+period 10000000
+time 2020-01-02T03:04:05Z
+duration 10s
+comment synthetic
+mapping /bin/app
+mapping /lib/libc.so.6 start=0x7f0000000000 build_id=abcd
+
+stack main@main.go:10;work@work.go:20;libc.so.6!memcpy 5 50000000 thread=worker bytes:=4096:bytes
+stack main@main.go:10;work@work.go:21 1 10000000
+stack main@main.go:10;work@work.go:20 2 20000000 thread=worker
+`
+	p, err := parseSynthSpec("spec", []byte(spec))
+	if err != nil {
+		t.Fatalf("parseSynthSpec: %v", err)
+	}
+
+	if got, want := fmt.Sprint(sampleTypes(p)), "[samples cpu]"; got != want {
+		t.Errorf("got sample types %s, want %s", got, want)
+	}
+	if p.DefaultSampleType != "cpu" || p.Period != 10000000 || p.PeriodType.Type != "cpu" {
+		t.Errorf("got default sample type %q, period %d %v", p.DefaultSampleType, p.Period, p.PeriodType)
+	}
+	if p.TimeNanos != 1577934245e9 || p.DurationNanos != 10e9 {
+		t.Errorf("got time %d and duration %d", p.TimeNanos, p.DurationNanos)
+	}
+	if !reflect.DeepEqual(p.Comments, []string{"synthetic"}) {
+		t.Errorf("got comments %q, want [synthetic]", p.Comments)
+	}
+
+	if len(p.Mapping) != 2 {
+		t.Fatalf("got %d mappings, want 2", len(p.Mapping))
+	}
+	app, libc := p.Mapping[0], p.Mapping[1]
+	if app.Start != 0x400000 || app.Limit != 0x500000 || len(app.BuildID) != 40 || !app.HasFunctions {
+		t.Errorf("got main mapping %+v", app)
+	}
+	if libc.Start != 0x7f0000000000 || libc.BuildID != "abcd" {
+		t.Errorf("got libc mapping %+v", libc)
+	}
+
+	var got []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			ln := l.Line[0]
+			frames = append(frames, fmt.Sprintf("%s!%s@%s:%d", l.Mapping.File, ln.Function.Name, ln.Function.Filename, ln.Line))
+		}
+		got = append(got, fmt.Sprintf("%v %s %v %v %v", s.Value, strings.Join(frames, " "), s.Label, s.NumLabel, s.NumUnit))
+	}
+	want := []string{
+		"[5 50000000] /lib/libc.so.6!memcpy@:0 /bin/app!work@work.go:20 /bin/app!main@main.go:10 map[thread:[worker]] map[bytes:[4096]] map[bytes:[bytes]]",
+		"[1 10000000] /bin/app!work@work.go:21 /bin/app!main@main.go:10 map[] map[] map[]",
+		"[2 20000000] /bin/app!work@work.go:20 /bin/app!main@main.go:10 map[thread:[worker]] map[] map[]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// Frames are shared: main, work:20, work:21 and memcpy.
+	if len(p.Location) != 4 || len(p.Function) != 3 {
+		t.Errorf("got %d locations and %d functions, want 4 and 3", len(p.Location), len(p.Function))
+	}
+	addrs := make(map[uint64]bool)
+	for _, l := range p.Location {
+		if addrs[l.Address] || l.Address < l.Mapping.Start || l.Address >= l.Mapping.Limit {
+			t.Errorf("location %d has address %#x, want a distinct address in its mapping", l.ID, l.Address)
+		}
+		addrs[l.Address] = true
+	}
+}
+
+func TestParseSynthSpecDefaults(t *testing.T) {
+	p, err := parseSynthSpec("spec", []byte("stack a;b 3\n"))
+	if err != nil {
+		t.Fatalf("parseSynthSpec: %v", err)
+	}
+	if got, want := fmt.Sprint(sampleTypes(p)), "[samples]"; got != want {
+		t.Errorf("got sample types %s, want %s", got, want)
+	}
+	if len(p.Mapping) != 0 || len(p.Sample) != 1 || p.Sample[0].Location[0].Line[0].Function.Name != "b" {
+		t.Errorf("got unexpected profile:\n%s", p)
+	}
+}
+
+func TestParseSynthSpecQuoted(t *testing.T) {
+	const spec = `
+stack "main;operator new;std::map<int, int>::find@map.h:10" 5 "thread=io worker" id="a"b
+comment "two  spaces"
+`
+	p, err := parseSynthSpec("spec", []byte(spec))
+	if err != nil {
+		t.Fatalf("parseSynthSpec: %v", err)
+	}
+	var frames []string
+	for _, l := range p.Sample[0].Location {
+		frames = append(frames, l.Line[0].Function.Name)
+	}
+	if want := []string{"std::map<int, int>::find", "operator new", "main"}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("got frames %q, want %q", frames, want)
+	}
+	if got := p.Sample[0].Location[0].Line[0]; got.Function.Filename != "map.h" || got.Line != 10 {
+		t.Errorf("got file %s:%d, want map.h:10", got.Function.Filename, got.Line)
+	}
+	if got, want := p.Sample[0].Label, map[string][]string{"thread": {"io worker"}, "id": {"ab"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(p.Comments, []string{"two  spaces"}) {
+		t.Errorf("got comments %q, want [two  spaces]", p.Comments)
+	}
+}
+
+func TestParseSynthSpecErrors(t *testing.T) {
+	for _, tc := range []struct {
+		spec, want string
+	}{
+		{"bogus 1", `spec:1: unknown directive "bogus"`},
+		{"sample_type cpu", "spec:1: wrong number of arguments for sample_type"},
+		{"stack a 1 2", "spec:1: got 2 sample values, want one per sample type (1)"},
+		{"stack a x", `spec:1: invalid sample value "x"`},
+		{"stack a 1 n:=x", `spec:1: invalid numeric label "n:=x"`},
+		{"\nstack lib.so!a 1", `spec:2: unknown mapping "lib.so" in frame "lib.so!a"`},
+		{"stack a@f.go:x 1", `spec:1: invalid line number in frame "a@f.go:x"`},
+		{"stack a;;b 1", `spec:1: missing function name in frame ""`},
+		{"mapping /bin/a size=0", "spec:1: mapping size must not be zero"},
+		{"mapping /bin/a\nmapping /bin/a", "spec:2: duplicate mapping /bin/a"},
+		{"mapping /bin/a label=1", `spec:1: unknown mapping attribute "label"`},
+		{"stack a 1\nsample_type cpu ns", "spec:2: sample_type must precede all stacks"},
+		{"duration 10", "spec:1: invalid duration: time: missing unit in duration \"10\""},
+		{`stack "main;work 1`, `spec:1: unterminated quoted string "main;work 1`},
+		{`stack "main\q" 1`, `spec:1: invalid quoted string "main\q"`},
+	} {
+		_, err := parseSynthSpec("spec", []byte(tc.spec))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got error %v, want %s", tc.spec, err, tc.want)
+		}
+	}
+}
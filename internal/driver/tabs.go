@@ -0,0 +1,112 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+	"github.com/google/pprof/profile"
+)
+
+// profileTab is a profile served in a tab of the web interface, along
+// with its processes, its execution phases and whether it has trace IDs,
+// timestamps and a series of profiles. Tabs are not modified once made,
+// but replaced.
+type profileTab struct {
+	name      string
+	prof      *profile.Profile
+	processes []processEntry
+	phases    []phaseEntry
+	traced    bool
+	timed     bool
+	series    bool
+
+	// refetch fetches the profile again, for the live mode of profiles
+	// collected over a duration, or is nil for other profiles, and live
+	// runs the fetches for the browsers following the tab.
+	refetch func(context.Context) (*profile.Profile, error)
+	live    *liveFeed
+}
+
+func newProfileTab(name string, p *profile.Profile) *profileTab {
+	return &profileTab{
+		name:      name,
+		prof:      p,
+		processes: processTree(p),
+		phases:    phaseEntries(p),
+		traced:    report.HasTraceIDs(p),
+		timed:     report.HasTimestamps(p),
+		series:    len(report.SeriesNames(p)) > 0,
+		live:      &liveFeed{},
+	}
+}
+
+// with returns a copy of the tab t serving the profile p.
+func (t *profileTab) with(p *profile.Profile) *profileTab {
+	tab := newProfileTab(t.name, p)
+	tab.refetch, tab.live = t.refetch, t.live
+	return tab
+}
+
+// liveFetcher returns the function fetching the profiles of s again, if
+// they are collected over a duration from a URL, or nil otherwise.
+func liveFetcher(s *source, o *plugin.Options) func(context.Context) (*profile.Profile, error) {
+	for _, src := range s.Sources {
+		u, _ := adjustURL(src, time.Duration(s.Seconds)*time.Second, 0)
+		if u == "" {
+			continue
+		}
+		if pu, err := url.Parse(u); err == nil && pu.Query().Get("seconds") != "" {
+			return func(ctx context.Context) (*profile.Profile, error) {
+				return fetchProfiles(ctx, s, o)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchTabs fetches each source of s as a separate profile, with the
+// base profiles of s, to serve each in its own tab.
+func fetchTabs(ctx context.Context, s *source, o *plugin.Options) ([]*profileTab, error) {
+	var tabs []*profileTab
+	for _, src := range s.Sources {
+		ts := *s
+		ts.Sources = []string{src}
+		p, err := fetchProfiles(ctx, &ts, o)
+		if err != nil {
+			return nil, err
+		}
+		tab := newProfileTab(tabName([]string{src}), p)
+		tab.refetch = liveFetcher(&ts, o)
+		tabs = append(tabs, tab)
+	}
+	return tabs, nil
+}
+
+// tabName returns the name of the tab of the profile merged from
+// sources, made of the last elements of their paths or URLs.
+func tabName(sources []string) string {
+	var names []string
+	for _, src := range sources {
+		names = append(names, filepath.Base(strings.TrimRight(src, "/")))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+func TestTabName(t *testing.T) {
+	for _, tc := range []struct {
+		sources []string
+		want    string
+	}{
+		{[]string{"testdata/cpu.pb.gz"}, "cpu.pb.gz"},
+		{[]string{"http://host:8080/debug/pprof/heap"}, "heap"},
+		{[]string{"old/cpu.pb.gz", "new/cpu.pb.gz"}, "cpu.pb.gz, cpu.pb.gz"},
+	} {
+		if got := tabName(tc.sources); got != tc.want {
+			t.Errorf("tabName(%q): got %q, want %q", tc.sources, got, tc.want)
+		}
+	}
+}
+
+func TestLiveFetcher(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		s    *source
+		live bool
+	}{
+		{"file", &source{Sources: []string{"cpu.pb.gz"}}, false},
+		{"snapshot", &source{Sources: []string{"http://host/debug/pprof/heap"}}, false},
+		{"seconds parameter", &source{Sources: []string{"http://host/debug/pprof/profile?seconds=10"}}, true},
+		{"seconds flag", &source{Sources: []string{"host:8080/debug/pprof/profile"}, Seconds: 10}, true},
+	} {
+		if got := liveFetcher(tc.s, &plugin.Options{}) != nil; got != tc.live {
+			t.Errorf("%s: got live %v, want %v", tc.desc, got, tc.live)
+		}
+	}
+}
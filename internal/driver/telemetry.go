@@ -0,0 +1,120 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// recordEvent records the use of the feature name of the given kind with
+// the telemetry of o, if any.
+func recordEvent(o *plugin.Options, kind, name string) {
+	if o.Telemetry != nil {
+		o.Telemetry.Event(kind, name)
+	}
+}
+
+// recordDuration records how long the operation took since start with
+// the telemetry of o, if any. It is meant to be deferred, as in
+// defer recordDuration(o, "fetch", time.Now()).
+func recordDuration(o *plugin.Options, operation string, start time.Time) {
+	if o.Telemetry != nil {
+		o.Telemetry.Duration(operation, time.Since(start))
+	}
+}
+
+// telemetryHandler records the requests of the view path of the web
+// interface served by h, and how long they take, with t. The view is
+// recorded by the path it is registered at rather than by the URL of
+// the request, which may hold the names of functions or files.
+func telemetryHandler(path string, h http.Handler, t plugin.Telemetry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Event("view", path)
+		defer func(start time.Time) {
+			t.Duration("view "+path, time.Since(start))
+		}(time.Now())
+		h.ServeHTTP(w, req)
+	})
+}
+
+// telemetryFlags is a FlagSet recording with t the names of the flags
+// set on the command line: those whose values differ from their defaults
+// once parsed.
+type telemetryFlags struct {
+	plugin.FlagSet
+	t     plugin.Telemetry
+	flags []telemetryFlag
+}
+
+// telemetryFlag is a flag of telemetryFlags, with the function returning
+// whether it was set.
+type telemetryFlag struct {
+	name string
+	set  func() bool
+}
+
+func (f *telemetryFlags) add(name string, set func() bool) {
+	f.flags = append(f.flags, telemetryFlag{name, set})
+}
+
+func (f *telemetryFlags) Bool(name string, def bool, usage string) *bool {
+	v := f.FlagSet.Bool(name, def, usage)
+	f.add(name, func() bool { return *v != def })
+	return v
+}
+
+func (f *telemetryFlags) Int(name string, def int, usage string) *int {
+	v := f.FlagSet.Int(name, def, usage)
+	f.add(name, func() bool { return *v != def })
+	return v
+}
+
+func (f *telemetryFlags) Float64(name string, def float64, usage string) *float64 {
+	v := f.FlagSet.Float64(name, def, usage)
+	f.add(name, func() bool { return *v != def })
+	return v
+}
+
+func (f *telemetryFlags) String(name, def, usage string) *string {
+	v := f.FlagSet.String(name, def, usage)
+	f.add(name, func() bool { return *v != def })
+	return v
+}
+
+func (f *telemetryFlags) StringList(name, def, usage string) *[]*string {
+	v := f.FlagSet.StringList(name, def, usage)
+	f.add(name, func() bool {
+		for _, s := range *v {
+			if *s != def {
+				return true
+			}
+		}
+		return false
+	})
+	return v
+}
+
+func (f *telemetryFlags) Parse(usage func()) []string {
+	args := f.FlagSet.Parse(usage)
+	for _, flag := range f.flags {
+		if flag.set() {
+			f.t.Event("flag", flag.name)
+		}
+	}
+	return args
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+// testTelemetry records the events and the operations timed.
+type testTelemetry struct {
+	events     []string
+	operations []string
+}
+
+func (t *testTelemetry) Event(kind, name string) {
+	t.events = append(t.events, kind+" "+name)
+}
+
+func (t *testTelemetry) Duration(operation string, d time.Duration) {
+	t.operations = append(t.operations, operation)
+}
+
+func TestTelemetryFlags(t *testing.T) {
+	baseConfig := currentConfig()
+	defer setCurrentConfig(baseConfig)
+
+	tel := &testTelemetry{}
+	flags := testFlags{
+		bools:       map[string]bool{"top": true},
+		strings:     map[string]string{"focus": "secret.Function"},
+		stringLists: map[string][]string{"base": {"base.pb.gz"}},
+		args:        []string{"cpu.pb.gz"},
+	}
+	o := setDefaults(&plugin.Options{Flagset: flags, UI: &proftest.TestUI{T: t}, HTTPTransport: &httpTransport{}, Telemetry: tel})
+	o = setDefaults(o)
+	if _, _, err := parseFlags(o); err != nil {
+		t.Fatal(err)
+	}
+	// The flags are recorded once, by their names and not their values.
+	sort.Strings(tel.events)
+	if want := []string{"flag base", "flag focus", "flag top"}; !reflect.DeepEqual(tel.events, want) {
+		t.Errorf("got events %q, want %q", tel.events, want)
+	}
+}
+
+func TestTelemetryHandler(t *testing.T) {
+	tel := &testTelemetry{}
+	h := telemetryHandler("/flamegraph", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), tel)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/flamegraph?f=secret", nil))
+	if want := []string{"view /flamegraph"}; !reflect.DeepEqual(tel.events, want) {
+		t.Errorf("got events %q, want %q", tel.events, want)
+	}
+	if want := []string{"view /flamegraph"}; !reflect.DeepEqual(tel.operations, want) {
+		t.Errorf("got operations %q, want %q", tel.operations, want)
+	}
+}
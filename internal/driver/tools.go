@@ -0,0 +1,101 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/pprof/internal/binutils"
+	"github.com/google/pprof/internal/plugin"
+)
+
+// pinTools checks the external tools used to symbolize the profiles of s
+// against the manifest required by s, if any, and writes them to the
+// manifest recorded by s, if any. It returns the comments recording the
+// tools in the profile, which are hidden from the report headers.
+func pinTools(s *source, o *plugin.Options) ([]string, error) {
+	if s.RecordTools == "" && s.RequireTools == "" {
+		return nil, nil
+	}
+	bu, ok := o.Obj.(*binutils.Binutils)
+	if !ok {
+		return nil, errors.New("-record_tools and -require_tools need the binutils object tool")
+	}
+	tools, err := bu.Tools()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.RequireTools != "" {
+		b, err := ioutil.ReadFile(s.RequireTools)
+		if err != nil {
+			return nil, err
+		}
+		var want []binutils.Tool
+		if err := json.Unmarshal(b, &want); err != nil {
+			return nil, fmt.Errorf("parsing tool manifest %s: %v", s.RequireTools, err)
+		}
+		if diffs := diffTools(tools, want); len(diffs) > 0 {
+			return nil, fmt.Errorf("tools differ from manifest %s:\n  %s", s.RequireTools, strings.Join(diffs, "\n  "))
+		}
+	}
+	if s.RecordTools != "" {
+		b, err := json.MarshalIndent(tools, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(s.RecordTools, append(b, '\n'), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	var comments []string
+	for _, t := range tools {
+		comments = append(comments, fmt.Sprintf("# tool %s: %s, %s, sha256 %s", t.Name, t.Path, t.Version, t.SHA256))
+	}
+	return comments, nil
+}
+
+// diffTools describes the differences between the tools found and those
+// of a manifest, which are identified by their version and hash, as
+// their paths can differ between hosts.
+func diffTools(got, want []binutils.Tool) []string {
+	var diffs []string
+	found := make(map[string]binutils.Tool)
+	for _, t := range got {
+		found[t.Name] = t
+	}
+	required := make(map[string]bool)
+	for _, w := range want {
+		required[w.Name] = true
+		g, ok := found[w.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s not found, want %s with sha256 %s", w.Name, w.Version, w.SHA256))
+		case g.Version != w.Version || g.SHA256 != w.SHA256:
+			diffs = append(diffs, fmt.Sprintf("%s at %s is %s with sha256 %s, want %s with sha256 %s", g.Name, g.Path, g.Version, g.SHA256, w.Version, w.SHA256))
+		}
+	}
+	for _, g := range got {
+		if !required[g.Name] {
+			diffs = append(diffs, fmt.Sprintf("%s at %s is not in the manifest", g.Name, g.Path))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/binutils"
+	"github.com/google/pprof/internal/plugin"
+)
+
+func TestPinTools(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("test runs shell scripts as tools")
+	}
+	dir, err := ioutil.TempDir("", "pprof_tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr2line := filepath.Join(dir, "addr2line")
+	writeTool := func(version string) {
+		if err := ioutil.WriteFile(addr2line, []byte("#!/bin/sh\necho '"+version+"'\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTool("GNU addr2line 2.38")
+	bu := &binutils.Binutils{}
+	bu.SetTools(dir)
+	o := &plugin.Options{Obj: bu}
+	manifest := filepath.Join(dir, "manifest.json")
+
+	comments, err := pinTools(&source{RecordTools: manifest}, o)
+	if err != nil {
+		t.Fatalf("pinTools: %v", err)
+	}
+	if len(comments) != 1 || !regexp.MustCompile(`^# tool addr2line: .*/addr2line, GNU addr2line 2\.38, sha256 [0-9a-f]{64}$`).MatchString(comments[0]) {
+		t.Errorf("got comments %q, want the addr2line tool", comments)
+	}
+	if _, err := pinTools(&source{RequireTools: manifest}, o); err != nil {
+		t.Errorf("pinTools with the recorded manifest: %v", err)
+	}
+
+	writeTool("GNU addr2line 2.39")
+	_, err = pinTools(&source{RequireTools: manifest}, o)
+	if err == nil || !strings.Contains(err.Error(), "addr2line at "+addr2line+" is GNU addr2line 2.39 with sha256 ") {
+		t.Errorf("got error %v, want a different addr2line", err)
+	}
+
+	if _, err := pinTools(&source{RequireTools: filepath.Join(dir, "missing.json")}, o); err == nil {
+		t.Errorf("pinTools succeeded with a missing manifest")
+	}
+	if _, err := pinTools(&source{RequireTools: manifest}, &plugin.Options{Obj: fakeObjTool{}}); err == nil {
+		t.Errorf("pinTools succeeded without binutils")
+	}
+}
+
+func TestDiffTools(t *testing.T) {
+	a := binutils.Tool{Name: "addr2line", Path: "/usr/bin/addr2line", Version: "2.38", SHA256: "aa"}
+	nm := binutils.Tool{Name: "nm", Path: "/usr/bin/nm", Version: "2.38", SHA256: "bb"}
+	moved := a
+	moved.Path = "/opt/bin/addr2line"
+	if diffs := diffTools([]binutils.Tool{moved}, []binutils.Tool{a}); len(diffs) != 0 {
+		t.Errorf("got diffs %q for a tool at another path, want none", diffs)
+	}
+	diffs := diffTools([]binutils.Tool{a}, []binutils.Tool{nm})
+	want := []string{"nm not found, want 2.38 with sha256 bb", "addr2line at /usr/bin/addr2line is not in the manifest"}
+	if strings.Join(diffs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diffs %q, want %q", diffs, want)
+	}
+}
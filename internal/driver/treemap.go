@@ -0,0 +1,175 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/report"
+)
+
+// treemap generates a web page containing a treemap of the profile: the
+// area of each rectangle is proportional to the cum value of its node of
+// the call tree, nested in the rectangle of its caller, or, with the
+// nest=package parameter, to the flat value of its function, nested in
+// the rectangle of its package. It is often more readable than the flame
+// graph for profiles dominated by a few functions, such as heap profiles
+// with few allocation sites.
+func (ui *webInterface) treemap(w http.ResponseWriter, req *http.Request) {
+	byPackage := req.URL.Query().Get("nest") == "package"
+	var rpt *report.Report
+	var errList []string
+	if byPackage {
+		rpt, errList = ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
+			cfg.CallTree = false
+			cfg.Trim = false
+		})
+	} else {
+		rpt, errList = ui.makeFlameGraphReport(w, req)
+	}
+	if rpt == nil {
+		return // error already reported
+	}
+	var root *treeNode
+	var legend []string
+	if byPackage {
+		root, legend = packageTree(rpt)
+	} else {
+		root, _, legend = flameGraphTree(rpt)
+	}
+
+	b, err := json.Marshal(root)
+	if err != nil {
+		http.Error(w, "error serializing treemap", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	ui.render(w, req, "treemap", rpt, errList, legend, webArgs{
+		Treemap:          template.JS(b),
+		TreemapByPackage: byPackage,
+	})
+}
+
+// packageTree returns the root of the tree of the functions of rpt with
+// their flat values, nested in their packages, and the legend of the
+// report. The functions of no package are children of the root. As in
+// flame graphs, the tree of a differential profile holds the new
+// profiles, with the change of each node since the base profiles.
+func packageTree(rpt *report.Report) (*treeNode, []string) {
+	var base map[string]int64
+	if brpt, crpt := report.SplitDiffBase(rpt); brpt != nil {
+		bg, _ := report.GetDOT(brpt)
+		base = make(map[string]int64)
+		for _, n := range bg.Nodes {
+			v := n.FlatValue()
+			base[n.Info.PrintableName()] += v
+			base["package "+packageName(n.Info.Name)] += v
+			base[""] += v
+		}
+		rpt = crpt
+	}
+	g, config := report.GetDOT(rpt)
+	newNode := func(name, fullName string, v int64, key string) *treeNode {
+		node := &treeNode{
+			Name:      graph.TruncateName(name, config.MaxNameLen),
+			FullName:  fullName,
+			Cum:       v,
+			CumFormat: config.FormatValue(v),
+			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
+		}
+		if base != nil {
+			d := v - base[key]
+			node.Delta = &d
+			node.DeltaFormat = config.FormatValue(d)
+			if d > 0 {
+				node.DeltaFormat = "+" + node.DeltaFormat
+			}
+		}
+		return node
+	}
+
+	var roots []*treeNode
+	var total int64
+	packages := make(map[string][]*treeNode)
+	var packageOrder []string
+	for _, n := range g.Nodes {
+		v := n.FlatValue()
+		if v == 0 {
+			continue
+		}
+		fullName := n.Info.PrintableName()
+		node := newNode(graph.ShortenFunctionName(fullName), fullName, v, fullName)
+		node.Badges = rpt.Badges(n.Info.Name)
+		total += v
+		pkg := packageName(n.Info.Name)
+		if pkg == "" {
+			roots = append(roots, node)
+			continue
+		}
+		if packages[pkg] == nil {
+			packageOrder = append(packageOrder, pkg)
+		}
+		packages[pkg] = append(packages[pkg], node)
+	}
+	for _, pkg := range packageOrder {
+		var v int64
+		for _, fn := range packages[pkg] {
+			v += fn.Cum
+		}
+		node := newNode(pkg, pkg, v, "package "+pkg)
+		node.Children = packages[pkg]
+		roots = append(roots, node)
+	}
+	root := newNode("root", "root", total, "")
+	root.Children = roots
+	return root, config.Labels
+}
+
+// packageName returns the package of the function name, or "" if it
+// has none: the path of a Go package, or the packages of a Java class,
+// before the first of its elements naming a type or a method, or the
+// namespaces and classes of a C++ function.
+func packageName(name string) string {
+	if i := strings.Index(name, "("); i > 0 && strings.Contains(name[:i], "::") {
+		name = name[:i] // Drop the parameters of C++ functions.
+	}
+	if i := strings.LastIndex(name, "::"); i > 0 {
+		return name[:i]
+	}
+	dir := ""
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		dir, name = name[:i+1], name[i+1:]
+	}
+	elems := strings.Split(name, ".")
+	if len(elems) < 2 {
+		return ""
+	}
+	pkg := 1
+	for i, e := range elems[1:] {
+		if e != "" && (e[0] == '(' || e[0] >= 'A' && e[0] <= 'Z') {
+			pkg = i + 1
+			break
+		}
+	}
+	return dir + strings.Join(elems[:pkg], ".")
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import "testing"
+
+func TestPackageName(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"main.main", "main"},
+		{"runtime.mallocgc", "runtime"},
+		{"github.com/google/pprof/internal/driver.(*webInterface).treemap", "github.com/google/pprof/internal/driver"},
+		{"github.com/google/pprof/internal/driver.packageName.func1", "github.com/google/pprof/internal/driver"},
+		{"gopkg.in/yaml.v2.(*decoder).unmarshal", "gopkg.in/yaml.v2"},
+		{"com.example.Server.handle", "com.example"},
+		{"std::vector<int>::push_back(int const&)", "std::vector<int>"},
+		{"blink::Document::close", "blink::Document"},
+		{"malloc", ""},
+	} {
+		if got := packageName(tc.name); got != tc.want {
+			t.Errorf("packageName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
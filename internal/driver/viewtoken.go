@@ -0,0 +1,113 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// viewTokenParam is the URL parameter of the web interface holding a view
+// token, as made by viewToken.
+const viewTokenParam = "view"
+
+// viewTokenPrefix starts the view tokens of this encoding, so that a
+// later one can tell them apart.
+const viewTokenPrefix = "1"
+
+// viewTokenDict is the preset dictionary the options of view tokens are
+// compressed with, holding common options and values, so that a token is
+// shorter than the URL parameters it stands for. It must not change, as
+// that changes the tokens; a new one needs a new viewTokenPrefix.
+const viewTokenDict = `"true","false","granularity":"filefunctions","granularity":"files",` +
+	`"granularity":"lines","granularity":"addresses","sample_index":"alloc_space",` +
+	`"sample_index":"inuse_space","sample_index":"alloc_objects","sample_index":"inuse_objects",` +
+	`"sample_index":"samples","sample_index":"cpu","sort":"cum","nodecount":"","nodefraction":"",` +
+	`"edgefraction":"","trim":"false","call_tree":"true","relative_percentages":"true",` +
+	`"percent_base":"","percent_node":"","drop_negative":"true","noinlines":"true","mean":"true",` +
+	`"normalize":"true","unit":"","focus":"","ignore":"","prune_from":"","hide":"","show":"",` +
+	`"show_from":"","tagfocus":"","tagignore":"","tagshow":"","taghide":"","trace":"",` +
+	`"time_window":"",{"`
+
+// maxViewTokenOptions is the largest size of the options of a view token
+// once inflated, well above that of any view, so that a small token
+// cannot inflate to gigabytes.
+const maxViewTokenOptions = 64 << 10
+
+// viewToken returns the options of cfg held in the URLs of the web
+// interface, such as the filters, sample index, granularity and trimming,
+// as a compact URL-safe token. The options are keyed by their name rather
+// than by URL parameter, and only those differing from their default are
+// kept, so that a token stays valid across versions of pprof.
+func (cfg *config) viewToken() string {
+	options := make(map[string]string)
+	for _, f := range configFields {
+		if f.urlparam == "" {
+			continue
+		}
+		if v := cfg.get(f); v != f.defaultValue {
+			options[f.name] = v
+		}
+	}
+	data, _ := json.Marshal(options) // Maps are marshaled in key order.
+	var b bytes.Buffer
+	w, _ := flate.NewWriterDict(&b, flate.BestCompression, []byte(viewTokenDict))
+	w.Write(data)
+	w.Close()
+	return viewTokenPrefix + base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
+
+// applyViewToken sets the options of cfg held in URLs to those of the
+// view token, and the others to their default, to show the view the token
+// was made from. Options unknown to this version of pprof are skipped.
+func (cfg *config) applyViewToken(token string) error {
+	if !strings.HasPrefix(token, viewTokenPrefix) {
+		return fmt.Errorf("view token %q: unknown encoding, possibly from a later version of pprof", token)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, viewTokenPrefix))
+	if err != nil {
+		return fmt.Errorf("view token %q: %v", token, err)
+	}
+	r := flate.NewReaderDict(bytes.NewReader(data), []byte(viewTokenDict))
+	if data, err = ioutil.ReadAll(io.LimitReader(r, maxViewTokenOptions+1)); err != nil {
+		return fmt.Errorf("view token %q: %v", token, err)
+	}
+	if len(data) > maxViewTokenOptions {
+		return fmt.Errorf("view token: options larger than %d bytes", maxViewTokenOptions)
+	}
+	var options map[string]string
+	if err := json.Unmarshal(data, &options); err != nil {
+		return fmt.Errorf("view token %q: %v", token, err)
+	}
+	for _, f := range configFields {
+		if f.urlparam == "" {
+			continue
+		}
+		value, ok := options[f.name]
+		if !ok {
+			value = f.defaultValue
+		}
+		if err := cfg.set(f, value); err != nil {
+			return fmt.Errorf("view token: setting %s: %v", f.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestViewToken(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Focus = "net/http"
+	cfg.TagFocus = "phase=^startup$"
+	cfg.SampleIndex = "alloc_space"
+	cfg.Granularity = "lines"
+	cfg.Trim = false
+	cfg.NodeCount = 20
+	token := cfg.viewToken()
+	if len(token) > 64 {
+		t.Errorf("got token %q of %d bytes, want a compact one", token, len(token))
+	}
+	if url.QueryEscape(token) != token {
+		t.Errorf("got token %q, want it URL-safe", token)
+	}
+
+	// The options left out of the token are reset to their default.
+	got := defaultConfig()
+	got.Ignore = "runtime"
+	if err := got.applyURL(url.Values{"view": {token}}); err != nil {
+		t.Fatalf("applyURL: %v", err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("got config %+v from the token, want %+v", got, cfg)
+	}
+
+	// The other parameters override the options of the token.
+	if err := got.applyURL(url.Values{"view": {token}, "f": {"grpc"}}); err != nil {
+		t.Fatalf("applyURL: %v", err)
+	}
+	if got.Focus != "grpc" || got.Granularity != "lines" {
+		t.Errorf("got focus %q and granularity %q, want grpc and lines", got.Focus, got.Granularity)
+	}
+
+	// Options unknown to this version are skipped.
+	future := testViewToken(t, `{"focus":"main","sparkles":"t"}`)
+	got = defaultConfig()
+	if err := got.applyViewToken(future); err != nil || got.Focus != "main" {
+		t.Errorf("got focus %q, error %v from a later token, want main", got.Focus, err)
+	}
+
+	for _, token := range []string{
+		"2abc",
+		"1!!",
+		"1" + base64.RawURLEncoding.EncodeToString([]byte("not deflated")),
+		testViewToken(t, `["focus"]`),
+		testViewToken(t, `{"nodecount":"many"}`),
+		testViewToken(t, `{"focus":"`+strings.Repeat("a", maxViewTokenOptions)+`"}`),
+	} {
+		cfg := defaultConfig()
+		if err := cfg.applyViewToken(token); err == nil {
+			t.Errorf("view token %q: got no error", token)
+		}
+	}
+}
+
+// testViewToken returns the view token holding the JSON options.
+func testViewToken(t *testing.T, options string) string {
+	var b bytes.Buffer
+	w, err := flate.NewWriterDict(&b, flate.BestCompression, []byte(viewTokenDict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(options))
+	w.Close()
+	return viewTokenPrefix + base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/pprof/internal/report"
+)
+
+// The /api/v1/ endpoints of the web interface serve its views as JSON, for
+// dashboards and editor plugins, honoring the URL parameters of the views.
+// Their responses are types of their own rather than those of the views,
+// so that fields may be added to them, but none removed or changed: that
+// takes a new version of the endpoints.
+
+// apiResponse is the response of the endpoints, with the field of the
+// endpoint set. Failed requests get the {"error": ...} response of pprof
+// api.
+type apiResponse struct {
+	Version    int      `json:"version"`
+	SampleType string   `json:"sample_type"`
+	Unit       string   `json:"unit"`  // Unit of the raw values.
+	Total      int64    `json:"total"` // Value percentages are relative to.
+	Warnings   []string `json:"warnings,omitempty"`
+
+	Top        []apiTopEntry `json:"top,omitempty"`
+	FlameGraph *apiFrame     `json:"flamegraph,omitempty"`
+	Tags       []apiTag      `json:"tags,omitempty"`
+	Source     []apiRoutine  `json:"source,omitempty"`
+}
+
+type apiTopEntry struct {
+	Name       string `json:"name"`
+	Inline     bool   `json:"inline,omitempty"`
+	Flat       int64  `json:"flat"`
+	Cum        int64  `json:"cum"`
+	FlatFormat string `json:"flat_format"`
+	CumFormat  string `json:"cum_format"`
+}
+
+type apiFrame struct {
+	Name     string      `json:"name"`
+	FullName string      `json:"full_name"`
+	Value    int64       `json:"value"`
+	Format   string      `json:"format"`
+	Delta    *int64      `json:"delta,omitempty"` // Change since the base profiles of a diff.
+	Children []*apiFrame `json:"children,omitempty"`
+}
+
+type apiTag struct {
+	Key     string        `json:"key"`
+	Numeric bool          `json:"numeric,omitempty"`
+	Total   int64         `json:"total"`
+	Values  []apiTagValue `json:"values"`
+}
+
+type apiTagValue struct {
+	Value  string `json:"value"`
+	Flat   int64  `json:"flat"`
+	Format string `json:"format"`
+}
+
+type apiRoutine struct {
+	Function string          `json:"function"`
+	File     string          `json:"file,omitempty"` // Empty without source information.
+	Flat     int64           `json:"flat"`
+	Cum      int64           `json:"cum"`
+	Error    string          `json:"error,omitempty"`
+	Lines    []apiSourceLine `json:"lines,omitempty"`
+}
+
+type apiSourceLine struct {
+	Line   int    `json:"line"`
+	Source string `json:"source"`
+	Flat   int64  `json:"flat"`
+	Cum    int64  `json:"cum"`
+}
+
+// apiTop serves the top functions of the view, as the top view.
+func (ui *webInterface) apiTop(w http.ResponseWriter, req *http.Request) {
+	ui.serveJSON(w, req, []string{"top"}, func(cfg *config) {
+		if cfg.NodeCount < 0 {
+			cfg.NodeCount = 500
+		}
+	}, func(rpt *report.Report, resp *apiResponse) error {
+		items, _ := report.TextItems(rpt)
+		resp.Top = []apiTopEntry{}
+		for _, item := range items {
+			resp.Top = append(resp.Top, apiTopEntry{
+				Name:       item.Name,
+				Inline:     item.InlineLabel != "",
+				Flat:       item.Flat,
+				Cum:        item.Cum,
+				FlatFormat: item.FlatFormat,
+				CumFormat:  item.CumFormat,
+			})
+		}
+		return nil
+	})
+}
+
+// apiFlameGraph serves the stack tree of the flame graph of the view.
+func (ui *webInterface) apiFlameGraph(w http.ResponseWriter, req *http.Request) {
+	ui.serveJSON(w, req, []string{"svg"}, func(cfg *config) {
+		cfg.CallTree = true
+		cfg.Trim = false
+	}, func(rpt *report.Report, resp *apiResponse) error {
+		root, _, _ := flameGraphTree(rpt)
+		var frame func(n *treeNode) *apiFrame
+		frame = func(n *treeNode) *apiFrame {
+			f := &apiFrame{Name: n.Name, FullName: n.FullName, Value: n.Cum, Format: n.CumFormat, Delta: n.Delta}
+			for _, c := range n.Children {
+				f.Children = append(f.Children, frame(c))
+			}
+			return f
+		}
+		resp.FlameGraph = frame(root)
+		return nil
+	})
+}
+
+// apiTags serves the values of the labels of the samples of the view.
+func (ui *webInterface) apiTags(w http.ResponseWriter, req *http.Request) {
+	ui.serveJSON(w, req, []string{"tags"}, nil, func(rpt *report.Report, resp *apiResponse) error {
+		resp.Tags = []apiTag{}
+		for _, g := range report.TagGroups(rpt) {
+			t := apiTag{Key: g.Key, Numeric: g.Numeric, Total: g.Total, Values: []apiTagValue{}}
+			for _, v := range g.Values {
+				t.Values = append(t.Values, apiTagValue{Value: v.Value, Flat: v.Flat, Format: v.FlatFormat})
+			}
+			resp.Tags = append(resp.Tags, t)
+		}
+		return nil
+	})
+}
+
+// apiSource serves the annotated source of the functions matching the f
+// parameter.
+func (ui *webInterface) apiSource(w http.ResponseWriter, req *http.Request) {
+	f := req.URL.Query().Get("f")
+	if f == "" {
+		apiError(w, http.StatusBadRequest, errors.New("missing f parameter, the regexp of the functions to list"))
+		return
+	}
+	ui.serveJSON(w, req, []string{"list", f}, nil, func(rpt *report.Report, resp *apiResponse) error {
+		routines, err := report.SourceRoutines(rpt)
+		if err != nil {
+			return err
+		}
+		resp.Source = []apiRoutine{}
+		for _, r := range routines {
+			routine := apiRoutine{Function: r.Name, File: r.File, Flat: r.Flat, Cum: r.Cum, Error: r.Error}
+			for _, l := range r.Lines {
+				routine.Lines = append(routine.Lines, apiSourceLine{Line: l.Line, Source: l.Source, Flat: l.Flat, Cum: l.Cum})
+			}
+			resp.Source = append(resp.Source, routine)
+		}
+		return nil
+	})
+}
+
+// serveJSON serves the response of an endpoint, set by fill from the report
+// cmd of the view of req, edited by configEditor if not nil.
+func (ui *webInterface) serveJSON(w http.ResponseWriter, req *http.Request, cmd []string, configEditor func(*config), fill func(*report.Report, *apiResponse) error) {
+	rpt, errList, err := ui.buildReport(req, cmd, configEditor)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := &apiResponse{
+		Version:    1,
+		SampleType: rpt.SampleType(),
+		Unit:       rpt.SampleUnit(),
+		Total:      rpt.Total(),
+		Warnings:   errList,
+	}
+	if err := fill(rpt, resp); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// basicAuth returns the middleware of the web interface requiring the
+// HTTP basic authentication of src.HTTPUser, with the password on the
+// first line of src.HTTPPasswordFile, before the middleware next if any.
+// It returns next if no user is set.
+func basicAuth(src *source, next func(http.Handler) http.Handler) (func(http.Handler) http.Handler, error) {
+	if src.HTTPUser == "" {
+		return next, nil
+	}
+	data, err := ioutil.ReadFile(src.HTTPPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	password := strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r")
+	if password == "" {
+		return nil, fmt.Errorf("%s: empty password", src.HTTPPasswordFile)
+	}
+	wantUser, wantPassword := []byte(src.HTTPUser), []byte(password)
+	return func(h http.Handler) http.Handler {
+		if next != nil {
+			h = next(h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			user, password, ok := req.BasicAuth()
+			// Both are compared, in constant time, to not tell which one
+			// is wrong or how much of it.
+			userOK := subtle.ConstantTimeCompare([]byte(user), wantUser) == 1
+			passwordOK := subtle.ConstantTimeCompare([]byte(password), wantPassword) == 1
+			if !ok || !userOK || !passwordOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pprof", charset="UTF-8"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, req)
+		})
+	}, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The middleware of the options runs after the authentication.
+	next := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Next", "yes")
+			h.ServeHTTP(w, req)
+		})
+	}
+	auth, err := basicAuth(&source{HTTPUser: "alice", HTTPPasswordFile: file}, next)
+	if err != nil {
+		t.Fatalf("basicAuth: %v", err)
+	}
+	handler := auth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for _, tc := range []struct {
+		desc           string
+		user, password string
+		want           int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "alice", "secret", http.StatusUnauthorized},
+		{"wrong user", "bob", "s3cret", http.StatusUnauthorized},
+		{"authenticated", "alice", "s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.desc, w.Code, tc.want)
+		}
+		if authenticated := w.Header().Get("X-Next") == "yes"; authenticated != (tc.want == http.StatusOK) {
+			t.Errorf("%s: got the next middleware run %v", tc.desc, authenticated)
+		}
+		if tc.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: got no WWW-Authenticate header", tc.desc)
+		}
+	}
+
+	if auth, err := basicAuth(&source{}, next); err != nil || auth == nil {
+		t.Errorf("got middleware %t, error %v without a user, want the next one", auth != nil, err)
+	}
+	if err := ioutil.WriteFile(file, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := basicAuth(&source{HTTPUser: "alice", HTTPPasswordFile: file}, nil); err == nil {
+		t.Errorf("got no error for an empty password")
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	var handlers map[string]http.Handler
+	server := func(a *plugin.HTTPServerArgs) error {
+		handlers = a.Handlers
+		return nil
+	}
+	deny := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "denied", http.StatusForbidden)
+		})
+	}
+	tabs := []*profileTab{newProfileTab("cpu.pb.gz", makeFakeProfile())}
+	if err := serveWebInterface("unused:1234", tabs, &plugin.Options{
+		Obj:            fakeObjTool{},
+		UI:             &proftest.TestUI{T: t},
+		HTTPServer:     server,
+		HTTPMiddleware: deny,
+	}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", "/top", "/download", "/ingest"} {
+		w := httptest.NewRecorder()
+		handlers[path].ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want the middleware to deny it", path, w.Code)
+		}
+	}
+}
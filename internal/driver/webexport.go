@@ -0,0 +1,190 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// staticView is a view of the web interface written by -static_html,
+// with the link to it in the menus and the file it is written to.
+type staticView struct {
+	link, file string
+	handler    func(*webInterface, http.ResponseWriter, *http.Request)
+}
+
+var staticViews = []staticView{
+	{"./", "graph.html", (*webInterface).dot},
+	{"./top", "top.html", (*webInterface).top},
+	{"./flamegraph", "flamegraph.html", (*webInterface).flamegraph},
+	{"./treemap", "treemap.html", (*webInterface).treemap},
+	{"./peek", "peek.html", (*webInterface).peek},
+	{"./sandwich", "sandwich.html", (*webInterface).sandwich},
+	{"./source", "source.html", (*webInterface).source},
+	{"./disasm", "disasm.html", (*webInterface).disasm},
+	{"./tags", "tags.html", (*webInterface).tags},
+	{"./traces", "traces.html", (*webInterface).traces},
+}
+
+// staticFile is a file of the static web interface.
+type staticFile struct {
+	name string
+	data []byte
+}
+
+// exportWebInterface writes the views of the web interface for p as
+// static HTML pages, linked to each other, to the directory dest, or to
+// a zip file if dest ends in .zip. The views that fail, eg the graph
+// without graphviz, are left out with a warning.
+func exportWebInterface(ctx context.Context, dest string, p *profile.Profile, o *plugin.Options) error {
+	ui, err := makeWebInterface(p, o)
+	if err != nil {
+		return err
+	}
+
+	var pages []staticFile
+	links := []string{`href="./download"`, `href="profile.pb.gz"`}
+	views := staticViews
+	for _, cv := range ui.customViews {
+		cv := cv
+		views = append(views[:len(views):len(views)], staticView{"./" + cv.Name, "view-" + cv.Name + ".html", func(ui *webInterface, w http.ResponseWriter, req *http.Request) {
+			ui.customView(cv, w, req)
+		}})
+	}
+	for _, v := range views {
+		req, err := http.NewRequest("GET", v.link, nil)
+		if err != nil {
+			return err
+		}
+		w := &pageWriter{header: make(http.Header)}
+		v.handler(ui, w, req.WithContext(ctx))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if w.code != 0 && w.code != http.StatusOK {
+			o.UI.PrintErr("Skipping the ", strings.TrimSuffix(v.file, ".html"), " view: ", strings.TrimSpace(w.body.String()))
+			links = append(links, `href="`+v.link+`"`, `href="#"`)
+			continue
+		}
+		pages = append(pages, staticFile{v.file, w.body.Bytes()})
+		links = append(links, `href="`+v.link+`"`, `href="`+v.file+`"`)
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no views of the web interface could be generated")
+	}
+
+	// Link the pages to each other, and make index.html open the first
+	// one, the graph unless it was left out.
+	rewrite := strings.NewReplacer(links...)
+	for i := range pages {
+		pages[i].data = []byte(rewrite.Replace(string(pages[i].data)))
+	}
+	index := fmt.Sprintf(`<!DOCTYPE html>
+<html><head><meta http-equiv="refresh" content="0; url=%[1]s"></head>
+<body><a href="%[1]s">pprof</a></body></html>
+`, pages[0].name)
+	var prof bytes.Buffer
+	if err := p.Write(&prof); err != nil {
+		return err
+	}
+	files := append([]staticFile{{"index.html", []byte(index)}}, pages...)
+	files = append(files, staticFile{"profile.pb.gz", prof.Bytes()})
+
+	if strings.HasSuffix(dest, ".zip") {
+		err = writeStaticZip(dest, files)
+	} else {
+		err = writeStaticDir(dest, files)
+	}
+	if err != nil {
+		return err
+	}
+	o.UI.PrintErr("Wrote the web interface to ", dest)
+	return nil
+}
+
+func writeStaticDir(dir string, files []staticFile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), f.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStaticZip(name string, files []staticFile) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// pageWriter is an http.ResponseWriter keeping the page written by a
+// handler of the web interface.
+type pageWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *pageWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pageWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *pageWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestExportWebInterface(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The graph fails to render, and is left out.
+	defer fakeDot(t, "exit 1")()
+	o := &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t, AllowRx: "Skipping the graph view|Wrote the web interface|Failed to execute dot|dot failed"},
+	}
+	out := filepath.Join(dir, "static")
+	if err := exportWebInterface(context.Background(), out, makeFakeProfile(), o); err != nil {
+		t.Fatalf("exportWebInterface: %v", err)
+	}
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if _, err := os.Stat(filepath.Join(out, "graph.html")); !os.IsNotExist(err) {
+		t.Errorf("got graph.html for a graph that failed to render: %v", err)
+	}
+	if got := read("index.html"); !strings.Contains(got, `url=top.html`) {
+		t.Errorf("index.html does not open the top view:\n%s", got)
+	}
+	top := read("top.html")
+	for _, want := range []string{`href="flamegraph.html"`, `href="peek.html"`, `href="source.html"`, `href="profile.pb.gz"`, `<h1><a href="#">`} {
+		if !strings.Contains(top, want) {
+			t.Errorf("top.html does not contain %q", want)
+		}
+	}
+	if strings.Contains(top, `href="./`) {
+		t.Errorf("top.html links to the web server:\n%s", top)
+	}
+	for name, want := range map[string]string{
+		"flamegraph.html": `"n":"F1"`,
+		"peek.html":       "F1",
+		"source.html":     "F1",
+	} {
+		if got := read(name); !strings.Contains(got, want) {
+			t.Errorf("%s does not contain %q", name, want)
+		}
+	}
+	if p, err := profile.Parse(strings.NewReader(read("profile.pb.gz"))); err != nil || len(p.Sample) == 0 {
+		t.Errorf("got profile.pb.gz %v, %v, want the profile", p, err)
+	}
+
+	zipName := filepath.Join(dir, "static.zip")
+	if err := exportWebInterface(context.Background(), zipName, makeFakeProfile(), o); err != nil {
+		t.Fatalf("exportWebInterface: %v", err)
+	}
+	b, err := ioutil.ReadFile(zipName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, " "), "index.html top.html flamegraph.html treemap.html peek.html sandwich.html source.html disasm.html tags.html traces.html profile.pb.gz"; got != want {
+		t.Errorf("got zip files %q, want %q", got, want)
+	}
+}
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !pprof_lite
+// +build !pprof_lite
+
 package driver
 
 import (
@@ -26,9 +29,65 @@ func addTemplates(templates *template.Template) {
 	template.Must(templates.Parse(`{{define "d3script"}}` + d3.JSSource + `{{end}}`))
 	template.Must(templates.Parse(`{{define "d3flamegraphscript"}}` + d3flamegraph.JSSource + `{{end}}`))
 	template.Must(templates.Parse(`{{define "d3flamegraphcss"}}` + d3flamegraph.CSSSource + `{{end}}`))
+	template.Must(templates.Parse(`{{define "flamegraphrendererscript"}}` + flameGraphRendererJS + `{{end}}`))
 	template.Must(templates.Parse(`
 {{define "css"}}
+<script>
+{{/* Apply the theme before drawing the page, so that it does not flash:
+     the one of the preferences, or chosen with the theme toggle, or else
+     that of the system. */}}
+(function() {
+  const dark = window.matchMedia && window.matchMedia('(prefers-color-scheme: dark)');
+  function applyTheme() {
+    let theme = {{.Preferences.Theme}};
+    try {
+      theme = theme || localStorage.getItem('pprof-theme');
+    } catch (e) {}
+    if (theme != 'light' && theme != 'dark') {
+      theme = (dark && dark.matches) ? 'dark' : 'light';
+    }
+    document.documentElement.dataset.theme = theme;
+  }
+  applyTheme();
+  if (dark && dark.addEventListener) {
+    dark.addEventListener('change', applyTheme);
+  }
+})();
+</script>
 <style type="text/css">
+:root {
+  --bg: #ffffff;
+  --text: #000000;
+  --link: #2a66d9;
+  --header-bg: #eee;
+  --header-text: #212121;
+  --popup-bg: #ffffff;
+  --input-bg: white;
+  --input-border: #d1d2d3;
+  --dialog-border: #CCCCCC;
+  --arrow: #ccc;
+  --arrow-hover: #666;
+  --table-header-bg: #ddd;
+  --hilite-bg: #ebf5fb;
+  --shadow: rgba(0,0,0,.3);
+}
+:root[data-theme="dark"] {
+  color-scheme: dark;
+  --bg: #1e1e1e;
+  --text: #d4d4d4;
+  --link: #6ea8fe;
+  --header-bg: #2d2d2d;
+  --header-text: #e0e0e0;
+  --popup-bg: #252526;
+  --input-bg: #1e1e1e;
+  --input-border: #555;
+  --dialog-border: #444;
+  --arrow: #777;
+  --arrow-hover: #bbb;
+  --table-header-bg: #333;
+  --hilite-bg: #264f78;
+  --shadow: rgba(0,0,0,.6);
+}
 * {
   margin: 0;
   padding: 0;
@@ -43,17 +102,19 @@ body {
   line-height: 1.4;
   display: flex;
   flex-direction: column;
+  background-color: var(--bg);
+  color: var(--text);
 }
 a {
-  color: #2a66d9;
+  color: var(--link);
 }
 .header {
   display: flex;
   align-items: center;
   height: 44px;
   min-height: 44px;
-  background-color: #eee;
-  color: #212121;
+  background-color: var(--header-bg);
+  color: var(--header-text);
   padding: 0 1rem;
 }
 .header > div {
@@ -65,7 +126,7 @@ a {
   margin-bottom: 4px;
 }
 .header .title a {
-  color: #212121;
+  color: var(--header-text);
   text-decoration: none;
 }
 .header .title a:hover {
@@ -76,6 +137,107 @@ a {
   text-align: right;
   white-space: nowrap;
 }
+#live-toggle.live-on {
+  color: #d93025;
+  font-weight: bold;
+}
+#labels-toggle.labels-on {
+  font-weight: bold;
+}
+#labelpanel {
+  display: none;
+  z-index: 1;
+  position: fixed;
+  top: 40px;
+  right: 0;
+  bottom: 0;
+  width: 24em;
+  overflow-y: auto;
+  background-color: var(--popup-bg);
+  box-shadow: 0 1px 5px var(--shadow);
+  padding: 1em;
+}
+#labelpanel.open {
+  display: block;
+}
+#labelpanel h2 {
+  font-size: 110%;
+  margin: 1em 0 .3em;
+}
+#labelpanel h2:first-child {
+  margin-top: 0;
+}
+#labelpanel .label-value {
+  position: relative;
+  display: flex;
+  padding: 1px .3em;
+  white-space: nowrap;
+}
+#labelpanel .label-bar {
+  position: absolute;
+  top: 0;
+  bottom: 0;
+  left: 0;
+  background-color: var(--hilite-bg);
+  z-index: -1;
+}
+#labelpanel .label-name {
+  flex: 1;
+  overflow: hidden;
+  text-overflow: ellipsis;
+}
+#labelpanel .label-value span,
+#labelpanel .label-value a {
+  margin-left: .5em;
+}
+#tabs {
+  display: flex;
+  align-items: flex-end;
+  background-color: var(--bg);
+  border-bottom: 1px solid var(--input-border);
+  padding: 4px 1rem 0;
+  white-space: nowrap;
+  overflow-x: auto;
+}
+#tabs > * {
+  max-width: 16em;
+  overflow: hidden;
+  text-overflow: ellipsis;
+  padding: 4px 12px;
+  margin-right: 2px;
+  border: 1px solid var(--input-border);
+  border-bottom: none;
+  border-radius: 4px 4px 0 0;
+  color: var(--text);
+  text-decoration: none;
+}
+#tabs > .current {
+  background-color: var(--header-bg);
+  color: var(--header-text);
+  font-weight: bold;
+}
+#tabs label {
+  cursor: pointer;
+  border-style: dashed;
+}
+body.dropping::after {
+  content: 'Drop the profiles to open them in new tabs';
+  position: fixed;
+  top: 0;
+  left: 0;
+  right: 0;
+  bottom: 0;
+  z-index: 10;
+  display: flex;
+  align-items: center;
+  justify-content: center;
+  font-size: 1.5em;
+  color: var(--text);
+  background-color: var(--hilite-bg);
+  border: 3px dashed var(--link);
+  opacity: .9;
+  pointer-events: none;
+}
 @media screen and (max-width: 799px) {
   .header input {
     display: none;
@@ -87,15 +249,15 @@ a {
   position: fixed;
   top: 40px;
   right: 20px;
-  background-color: #ffffff;
-  box-shadow: 0 1px 5px rgba(0,0,0,.3);
+  background-color: var(--popup-bg);
+  box-shadow: 0 1px 5px var(--shadow);
   line-height: 24px;
   padding: 1em;
   text-align: left;
 }
 .header input {
-  background: white url("data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 24 24' style='pointer-events:none;display:block;width:100%25;height:100%25;fill:%23757575'%3E%3Cpath d='M15.5 14h-.79l-.28-.27C15.41 12.59 16 11.11 16 9.5 16 5.91 13.09 3 9.5 3S3 5.91 3 9.5 5.91 16 9.5 16c1.61.0 3.09-.59 4.23-1.57l.27.28v.79l5 4.99L20.49 19l-4.99-5zm-6 0C7.01 14 5 11.99 5 9.5S7.01 5 9.5 5 14 7.01 14 9.5 11.99 14 9.5 14z'/%3E%3C/svg%3E") no-repeat 4px center/20px 20px;
-  border: 1px solid #d1d2d3;
+  background: var(--input-bg) url("data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 24 24' style='pointer-events:none;display:block;width:100%25;height:100%25;fill:%23757575'%3E%3Cpath d='M15.5 14h-.79l-.28-.27C15.41 12.59 16 11.11 16 9.5 16 5.91 13.09 3 9.5 3S3 5.91 3 9.5 5.91 16 9.5 16c1.61.0 3.09-.59 4.23-1.57l.27.28v.79l5 4.99L20.49 19l-4.99-5zm-6 0C7.01 14 5 11.99 5 9.5S7.01 5 9.5 5 14 7.01 14 9.5 11.99 14 9.5 14z'/%3E%3C/svg%3E") no-repeat 4px center/20px 20px;
+  border: 1px solid var(--input-border);
   border-radius: 2px 0 0 2px;
   padding: 0.25em;
   padding-left: 28px;
@@ -103,10 +265,10 @@ a {
   font-family: 'Roboto', 'Noto', sans-serif;
   font-size: 1em;
   line-height: 24px;
-  color: #212121;
+  color: var(--header-text);
 }
 .downArrow {
-  border-top: .36em solid #ccc;
+  border-top: .36em solid var(--arrow);
   border-left: .36em solid transparent;
   border-right: .36em solid transparent;
   margin-bottom: .05em;
@@ -123,7 +285,7 @@ a {
   opacity: 0.75;
 }
 .menu-item .menu-name:hover .downArrow {
-  border-top-color: #666;
+  border-top-color: var(--arrow-hover);
 }
 .menu-name {
   height: 100%;
@@ -134,7 +296,7 @@ a {
 }
 .menu-name a {
   text-decoration: none;
-  color: #212121;
+  color: var(--header-text);
 }
 .submenu {
   display: none;
@@ -143,8 +305,8 @@ a {
   min-width: 10em;
   position: absolute;
   left: 0px;
-  background-color: white;
-  box-shadow: 0 1px 5px rgba(0,0,0,.3);
+  background-color: var(--popup-bg);
+  box-shadow: 0 1px 5px var(--shadow);
   font-size: 100%;
   text-transform: none;
 }
@@ -156,7 +318,7 @@ a {
 }
 .submenu hr {
   border: 0;
-  border-top: 2px solid #eee;
+  border-top: 2px solid var(--header-bg);
 }
 .submenu a {
   display: block;
@@ -202,19 +364,19 @@ a {
 
   z-index: 3;
   font-size: 125%;
-  background-color: #ffffff;
-  box-shadow: 0 1px 5px rgba(0,0,0,.3);
+  background-color: var(--popup-bg);
+  box-shadow: 0 1px 5px var(--shadow);
 }
 .dialog-header {
   font-size: 120%;
-  border-bottom: 1px solid #CCCCCC;
+  border-bottom: 1px solid var(--dialog-border);
   width: 100%;
   text-align: center;
-  background: #EEEEEE;
+  background: var(--header-bg);
   user-select: none;
 }
 .dialog-footer {
-  border-top: 1px solid #CCCCCC;
+  border-top: 1px solid var(--dialog-border);
   width: 100%;
   text-align: right;
   padding: 10px;
@@ -235,9 +397,38 @@ a {
   width: 50%;
   max-width: 20em;
 }
+#compare-dialog {
+  width: 50%;
+  max-width: 30em;
+}
+#compare-dialog .compare-profile,
+#compare-dialog .compare-mode {
+  padding: 10px;
+}
+#compare-dialog .compare-profile label {
+  display: inline-block;
+  width: 7em;
+}
+#compare-dialog .compare-mode label {
+  display: block;
+}
 #delete-prompt {
   padding: 10px;
 }
+#preferences-dialog {
+  width: 50%;
+  max-width: 30em;
+}
+#preferences-dialog .preference {
+  padding: 10px;
+}
+#preferences-dialog .preference label {
+  display: inline-block;
+  width: 8em;
+}
+#preferences-dialog .preference input {
+  margin: 0;
+}
 
 #content {
   overflow-y: scroll;
@@ -274,7 +465,7 @@ table thead {
 table tr th {
   position: sticky;
   top: 0;
-  background-color: #ddd;
+  background-color: var(--table-header-bg);
   text-align: right;
   padding: .3em .5em;
 }
@@ -297,14 +488,83 @@ table tr td {
 #flathdr1, #flathdr2, #cumhdr1, #cumhdr2, #namehdr {
   cursor: ns-resize;
 }
+#top table tr th:nth-child(8),
+#top table tr td:nth-child(8) {
+  text-align: left;
+}
+.badge {
+  display: inline-block;
+  margin-right: .3em;
+  padding: 0 .4em;
+  border: 1px solid var(--input-border);
+  border-radius: .6em;
+  background-color: var(--header-bg);
+  color: var(--header-text);
+  font-size: 90%;
+}
 .hilite {
-  background-color: #ebf5fb;
+  background-color: var(--hilite-bg);
   font-weight: bold;
 }
+#theme-toggle {
+  cursor: pointer;
+}
+{{/* The graphs drawn by dot, the source listings and the flame graphs have
+     their own colors, which are adapted to the dark theme here. */}}
+:root[data-theme="dark"] #graph svg {
+  filter: invert(90%) hue-rotate(180deg);
+}
+:root[data-theme="dark"] .inlinesrc {
+  color: #9cdcfe;
+}
+:root[data-theme="dark"] .livesrc:hover {
+  background-color: #333;
+}
+:root[data-theme="dark"] .asm {
+  color: #6a9955;
+}
+:root[data-theme="dark"] .kw {
+  color: #569cd6;
+}
+:root[data-theme="dark"] .str {
+  color: #ce9178;
+}
+:root[data-theme="dark"] .num {
+  color: #b5cea8;
+}
+:root[data-theme="dark"] .com {
+  color: #6a9955;
+}
+:root[data-theme="dark"] .coldtoggle:hover {
+  background-color: #333;
+}
+:root[data-theme="dark"] .jump {
+  color: #569cd6;
+}
+:root[data-theme="dark"] .jumptarget {
+  background-color: #5c5c00;
+}
+:root[data-theme="dark"] .d3-flame-graph rect {
+  stroke: var(--bg);
+}
+:root[data-theme="dark"] .d3-flame-graph rect:hover {
+  stroke: var(--text);
+}
 </style>
 {{end}}
 
 {{define "header"}}
+{{if .Tabs}}
+<div id="tabs">
+  {{range .Tabs}}
+  <a href="./?tab={{.Index}}" data-tab="{{.Index}}" title="{{.Name}}"{{if .Current}} class="current"{{end}}>{{.Name}}</a>
+  {{end}}
+  <form id="open-tab" action="./open" method="post" enctype="multipart/form-data">
+    <label title="{{.Help.open}}">+ Open<input type="file" name="profile" hidden></label>
+  </form>
+  <label id="compare-tabs" title="{{.Help.compare}}">Compare</label>
+</div>
+{{end}}
 <div class="header">
   <div class="title">
     <h1><a href="./">pprof</a></h1>
@@ -319,9 +579,16 @@ table tr td {
       <a title="{{.Help.top}}"  href="./top" id="topbtn">Top</a>
       <a title="{{.Help.graph}}" href="./" id="graphbtn">Graph</a>
       <a title="{{.Help.flamegraph}}" href="./flamegraph" id="flamegraph">Flame Graph</a>
+      <a title="{{.Help.treemap}}" href="./treemap" id="treemapbtn">Treemap</a>
       <a title="{{.Help.peek}}" href="./peek" id="peek">Peek</a>
+      <a title="{{.Help.sandwich}}" href="./sandwich" id="sandwich">Sandwich</a>
+      <a title="{{.Help.tags}}" href="./tags" id="tags">Tags</a>
+      <a title="{{.Help.traces}}" href="./traces" id="traces">Traces</a>
+      {{if .Timed}}<a title="{{.Help.timeline}}" href="./timeline" id="timelinebtn">Timeline</a>{{end}}
+      {{if .Series}}<a title="{{.Help.heatmap}}" href="./heatmap" id="heatmapbtn">Heatmap</a>{{end}}
       <a title="{{.Help.list}}" href="./source" id="list">Source</a>
       <a title="{{.Help.disasm}}" href="./disasm" id="disasm">Disassemble</a>
+      {{range .CustomViews}}<a title="{{.Help}}" href="./{{.Name}}" id="view-{{.Name}}">{{.Title}}</a>{{end}}
     </div>
   </div>
 
@@ -340,6 +607,39 @@ table tr td {
   </div>
   {{end}}
 
+  {{if .Processes}}
+  <div id="process" class="menu-item">
+    <div class="menu-name">
+      Process
+      <i class="downArrow"></i>
+    </div>
+    <div class="submenu">
+      <a title="Show all processes" href="{{.AllProcs}}">All processes</a>
+      <hr>
+      {{range .Processes}}
+      <a title="Focus on {{.Name}} and its children" href="{{.URL}}" style="padding-left: {{.Depth}}em">{{.Name}}</a>
+      {{end}}
+    </div>
+  </div>
+  {{end}}
+
+  {{if .Phases}}
+  <div id="phase" class="menu-item">
+    <div class="menu-name">
+      Phase
+      <i class="downArrow"></i>
+    </div>
+    <div class="submenu">
+      <a title="Show all phases" href="{{.AllPhases}}">All phases</a>
+      <a title="{{.Help.phases}}" href="./phases">Compare phases</a>
+      <hr>
+      {{range .Phases}}
+      <a title="Focus on the samples of phase {{.Name}}" href="{{.URL}}">{{.Name}}</a>
+      {{end}}
+    </div>
+  </div>
+  {{end}}
+
   <div id="refine" class="menu-item">
     <div class="menu-name">
       Refine
@@ -352,6 +652,40 @@ table tr td {
       <a title="{{.Help.show}}" href="?" id="show">Show</a>
       <a title="{{.Help.show_from}}" href="?" id="show-from">Show from</a>
       <hr>
+      <a title="{{.Help.percent_total}}" href="?" id="percent-total">
+        {{if eq .PercentBase "total"}}<span class="menu-check-mark">✓</span>{{end}}
+        Percent of total
+      </a>
+      <a title="{{.Help.percent_focused}}" href="?" id="percent-focused">
+        {{if eq .PercentBase "focused"}}<span class="menu-check-mark">✓</span>{{end}}
+        Percent of focused
+      </a>
+      <a title="{{.Help.percent_selection}}" href="?" id="percent-node">
+        {{if eq .PercentBase "node"}}<span class="menu-check-mark">✓</span>{{end}}
+        Percent of selection
+      </a>
+      <hr>
+      <a title="{{.Help.functions}}" href="?" id="granularity-functions">
+        {{if eq .Granularity "functions"}}<span class="menu-check-mark">✓</span>{{end}}
+        By function
+      </a>
+      <a title="{{.Help.filefunctions}}" href="?" id="granularity-filefunctions">
+        {{if eq .Granularity "filefunctions"}}<span class="menu-check-mark">✓</span>{{end}}
+        By function and file
+      </a>
+      <a title="{{.Help.files}}" href="?" id="granularity-files">
+        {{if eq .Granularity "files"}}<span class="menu-check-mark">✓</span>{{end}}
+        By file
+      </a>
+      <a title="{{.Help.lines}}" href="?" id="granularity-lines">
+        {{if eq .Granularity "lines"}}<span class="menu-check-mark">✓</span>{{end}}
+        By line
+      </a>
+      <a title="{{.Help.addresses}}" href="?" id="granularity-addresses">
+        {{if eq .Granularity "addresses"}}<span class="menu-check-mark">✓</span>{{end}}
+        By address
+      </a>
+      <hr>
       <a title="{{.Help.reset}}" href="?">Reset</a>
     </div>
   </div>
@@ -363,6 +697,8 @@ table tr td {
     </div>
     <div class="submenu">
       <a title="{{.Help.save_config}}" id="save-config">Save as ...</a>
+      <a title="{{.Help.view_link}}" href="?view={{.ViewToken}}" id="view-link">Link to this view</a>
+      <a title="{{.Help.preferences}}" id="preferences">Preferences ...</a>
       <hr>
       {{range .Configs}}
         <a href="{{.URL}}">
@@ -380,10 +716,47 @@ table tr td {
     </div>
   </div>
 
+  <div id="export" class="menu-item" style="display: none">
+    <div class="menu-name">
+      Export
+      <i class="downArrow"></i>
+    </div>
+    <div class="submenu">
+      <a title="{{.Help.export_svg}}" href="#" id="export-svg">SVG</a>
+      <a title="{{.Help.export_png}}" href="#" id="export-png">PNG</a>
+    </div>
+  </div>
+
+  {{if .Live}}
+  <div id="live" class="menu-item">
+    <div class="menu-name">
+      <a title="{{.Help.live}}" id="live-toggle">Live</a>
+    </div>
+  </div>
+  {{end}}
+
+  <div id="labels" class="menu-item">
+    <div class="menu-name">
+      <a title="{{.Help.labels}}" id="labels-toggle">Labels</a>
+    </div>
+  </div>
+
+  <div id="theme" class="menu-item">
+    <div class="menu-name">
+      <a title="{{.Help.theme}}" id="theme-toggle">Dark</a>
+    </div>
+  </div>
+
   <div>
     <input id="search" type="text" placeholder="Search regexp" autocomplete="off" autocapitalize="none" size=40>
   </div>
 
+  {{if .Traced}}
+  <div>
+    <input id="trace" type="text" title="{{.Help.trace}}" placeholder="Trace IDs" autocomplete="off" autocapitalize="none" size=20>
+  </div>
+  {{end}}
+
   <div class="description">
     <a title="{{.Help.details}}" href="#" id="details">{{.Title}}</a>
     <div id="detailsbox">
@@ -392,6 +765,8 @@ table tr td {
   </div>
 </div>
 
+<div id="labelpanel"></div>
+
 <div id="dialog-overlay"></div>
 
 <div class="dialog" id="save-dialog">
@@ -417,6 +792,81 @@ table tr td {
   </div>
 </div>
 
+<div class="dialog" id="preferences-dialog">
+  <div class="dialog-header">Preferences</div>
+  <div class="preference">
+    <label for="preference-orientation">Flame graphs</label>
+    <select id="preference-orientation" name="orientation">
+      <option value="icicle">Icicles, the root at the top</option>
+      <option value="flame">Flames, the root at the bottom</option>
+    </select>
+  </div>
+  <div class="preference">
+    <label for="preference-theme">Theme</label>
+    <select id="preference-theme" name="theme">
+      <option value="">That of the system</option>
+      <option value="light">Light</option>
+      <option value="dark">Dark</option>
+    </select>
+  </div>
+  <div class="preference">
+    <label for="preference-view">First view</label>
+    <select id="preference-view" name="default_view">
+      <option value="">Graph</option>
+      <option value="top">Top</option>
+      <option value="flamegraph">Flame Graph</option>
+      <option value="treemap">Treemap</option>
+      <option value="peek">Peek</option>
+      <option value="sandwich">Sandwich</option>
+      <option value="tags">Tags</option>
+      <option value="traces">Traces</option>
+    </select>
+  </div>
+  <div class="preference">
+    <label for="preference-sample">Sample types</label>
+    <datalist id="preference-samples">
+      {{range .SampleTypes}}<option value="{{.}}" />{{end}}
+    </datalist>
+    <input id="preference-sample" name="sample_index" type="text" list="preference-samples"
+           placeholder="Default of the profile" title="Sample types shown by default, by preference, separated by commas">
+  </div>
+  <div class="dialog-footer">
+    <span class="dialog-error" id="preferences-error"></span>
+    <button id="preferences-cancel">Cancel</button>
+    <button id="preferences-save">Save</button>
+  </div>
+</div>
+
+{{if .Tabs}}
+<form class="dialog" id="compare-dialog" action="./compare" method="post" enctype="multipart/form-data">
+  <div class="dialog-header">Compare profiles</div>
+  <div class="compare-profile">
+    <label for="compare-source">New profile</label>
+    <select id="compare-source" name="source">
+      {{range .Tabs}}<option value="{{.Index}}"{{if .Current}} selected{{end}}>{{.Name}}</option>{{end}}
+      <option value="file">Upload a file...</option>
+    </select>
+    <input type="file" name="source_file" hidden>
+  </div>
+  <div class="compare-profile">
+    <label for="compare-base">Base profile</label>
+    <select id="compare-base" name="base">
+      {{range .Tabs}}<option value="{{.Index}}">{{.Name}}</option>{{end}}
+      <option value="file">Upload a file...</option>
+    </select>
+    <input type="file" name="base_file" hidden>
+  </div>
+  <div class="compare-mode">
+    <label><input type="radio" name="mode" value="diff" checked>Difference</label>
+    <label><input type="radio" name="mode" value="relative">Relative difference, scaling the new profile to the total of the base</label>
+  </div>
+  <div class="dialog-footer">
+    <button type="button" id="compare-cancel">Cancel</button>
+    <button type="submit">Compare</button>
+  </div>
+</form>
+{{end}}
+
 <div id="errors">{{range .Errors}}<div>{{.}}</div>{{end}}</div>
 {{end}}
 
@@ -443,7 +893,7 @@ table tr td {
 <script>
 // Make svg pannable and zoomable.
 // Call clickHandler(t) if a click event is caught by the pan event handlers.
-function initPanAndZoom(svg, clickHandler) {
+function initPanAndZoom(svg, clickHandler, viewBox) {
   'use strict';
 
   // Current mouse/touch handling mode
@@ -458,6 +908,12 @@ function initPanAndZoom(svg, clickHandler) {
   const initWidth = svg.viewBox.baseVal.width;
   const initHeight = svg.viewBox.baseVal.height;
 
+  // Restore the zoom and panning of the graph replaced by a live update.
+  if (viewBox) {
+    svg.setAttribute('viewBox', viewBox);
+    currentScale = initWidth / svg.viewBox.baseVal.width;
+  }
+
   // State needed to implement panning.
   let panLastX = 0;      // Last event X coordinate
   let panLastY = 0;      // Last event Y coordinate
@@ -706,12 +1162,470 @@ function initMenus() {
   }, { passive: true, capture: true });
 }
 
+// Initialize the toggle between the light and dark themes, which
+// remembers the theme chosen over that of the system.
+function initThemeToggle() {
+  'use strict';
+  const toggle = document.getElementById('theme-toggle');
+  if (toggle == null) return;
+  const root = document.documentElement;
+  function update() {
+    toggle.textContent = root.dataset.theme == 'dark' ? 'Light' : 'Dark';
+  }
+  toggle.addEventListener('click', (e) => {
+    e.preventDefault();
+    root.dataset.theme = root.dataset.theme == 'dark' ? 'light' : 'dark';
+    try {
+      localStorage.setItem('pprof-theme', root.dataset.theme);
+    } catch (e) {}
+    savePreferences({theme: root.dataset.theme});
+    update();
+  });
+  const dark = window.matchMedia && window.matchMedia('(prefers-color-scheme: dark)');
+  if (dark && dark.addEventListener) {
+    dark.addEventListener('change', update);
+  }
+  update();
+}
+
+// Initialize the tabs of the profiles served: the links of the page keep
+// the tab shown, and each tab remembers the view it was left at, so that
+// switching back to it shows that view again.
+function initTabs() {
+  'use strict';
+  const tabs = document.getElementById('tabs');
+  if (tabs == null) return;
+  const tab = new URLSearchParams(window.location.search).get('tab') || '0';
+  try {
+    sessionStorage.setItem('pprof-tab-' + tab, window.location.href);
+    for (const a of tabs.querySelectorAll('a[data-tab]')) {
+      const view = sessionStorage.getItem('pprof-tab-' + a.dataset.tab);
+      if (view != null) a.href = view;
+    }
+  } catch (e) {}
+  if (tab != '0') {
+    for (const a of document.querySelectorAll('.header a[href]')) {
+      const url = new URL(a.href);
+      if (url.origin == window.location.origin && !url.searchParams.has('tab')) {
+        url.searchParams.set('tab', tab);
+        a.href = url.toString();
+      }
+    }
+  }
+  const input = tabs.querySelector('#open-tab input');
+  input.addEventListener('change', () => input.form.submit());
+  initDrop(input);
+  initCompare(document.getElementById('compare-tabs'), document.getElementById('compare-dialog'));
+}
+
+// Initialize the dialog comparing two profiles, each the profile of a tab
+// or an uploaded file, whose comparison the server opens in a new tab.
+function initCompare(button, dialog) {
+  'use strict';
+  const overlay = document.getElementById('dialog-overlay');
+  function show(on) {
+    overlay.style.display = on ? 'block' : 'none';
+    dialog.style.display = on ? 'block' : 'none';
+  }
+  button.addEventListener('click', () => show(true));
+  dialog.querySelector('#compare-cancel').addEventListener('click', () => show(false));
+  for (const select of dialog.querySelectorAll('select')) {
+    const file = dialog.querySelector('input[name="' + select.name + '_file"]');
+    select.addEventListener('change', () => {
+      file.hidden = select.value != 'file';
+      if (file.hidden) file.value = '';
+    });
+  }
+  dialog.addEventListener('submit', (e) => {
+    for (const select of dialog.querySelectorAll('select')) {
+      const file = dialog.querySelector('input[name="' + select.name + '_file"]');
+      if (select.value == 'file' && file.files.length == 0) {
+        e.preventDefault();
+        alert('Select the file of the ' + (select.name == 'base' ? 'base' : 'new') + ' profile');
+        return;
+      }
+    }
+  });
+}
+
+// Initialize the dropping of profile files on the page, which opens each
+// in a new tab, as the + Open button does, and shows the tab of the last.
+function initDrop(input) {
+  'use strict';
+  let depth = 0; // Nesting of the elements dragged over.
+  function hasFiles(e) {
+    return e.dataTransfer && Array.from(e.dataTransfer.types).includes('Files');
+  }
+  function setDropping(on) {
+    document.body.classList.toggle('dropping', on);
+  }
+  document.addEventListener('dragenter', (e) => {
+    if (!hasFiles(e)) return;
+    e.preventDefault();
+    depth++;
+    setDropping(true);
+  });
+  document.addEventListener('dragover', (e) => {
+    if (!hasFiles(e)) return;
+    e.preventDefault();
+    e.dataTransfer.dropEffect = 'copy';
+  });
+  document.addEventListener('dragleave', (e) => {
+    if (!hasFiles(e)) return;
+    if (--depth <= 0) {
+      depth = 0;
+      setDropping(false);
+    }
+  });
+  document.addEventListener('drop', async (e) => {
+    if (!hasFiles(e)) return;
+    e.preventDefault();
+    depth = 0;
+    setDropping(false);
+    const files = Array.from(e.dataTransfer.files);
+    if (files.length == 0) return;
+    // All but the last are opened in the background, without following
+    // the redirect to their tab.
+    for (const file of files.slice(0, -1)) {
+      const form = new FormData();
+      form.append('profile', file);
+      try {
+        const resp = await fetch(input.form.action, {method: 'POST', body: form, redirect: 'manual'});
+        if (resp.type != 'opaqueredirect' && !resp.ok) {
+          alert('Cannot open ' + file.name + ': ' + await resp.text());
+        }
+      } catch (err) {
+        alert('Cannot open ' + file.name + ': ' + err);
+      }
+    }
+    const last = new DataTransfer();
+    last.items.add(files[files.length - 1]);
+    input.files = last.files;
+    input.form.submit();
+  });
+}
+
+// Initialize the toggle of the live mode of profiles collected over a
+// duration. While it is on, the server fetches the profile again and
+// pushes the view rendered from each new profile as a server-sent event,
+// which replaces the page in place, keeping the zoom, selection and scroll
+// of the view. The views that cannot be pushed reload instead, as do all
+// views if the event stream cannot be opened. Each tab remembers whether
+// it is live.
+function initLive() {
+  'use strict';
+  const toggle = document.getElementById('live-toggle');
+  if (toggle == null) return;
+  const key = 'pprof-live-' + (new URLSearchParams(window.location.search).get('tab') || '0');
+  // The event stream outlives the pages it replaces.
+  let live = window.pprofLiveSource != null;
+  try {
+    live = sessionStorage.getItem(key) == 'on';
+  } catch (e) {}
+  function update() {
+    toggle.classList.toggle('live-on', live);
+    toggle.textContent = live ? 'Live \u25cf' : 'Live';
+  }
+  // isLive tells whether the current page is live, as the handlers of the
+  // event stream may run after the page that opened it was replaced.
+  function isLive() {
+    const t = document.getElementById('live-toggle');
+    return t != null && t.classList.contains('live-on');
+  }
+  function reload() {
+    if (!isLive()) return;
+    const url = new URL('./refresh', window.location.href);
+    url.search = window.location.search;
+    sendURL('POST', url, (ok) => {
+      if (!isLive()) return;
+      if (ok) {
+        window.location.reload();
+      } else {
+        // Retry after a failed fetch, as the service may be restarting.
+        setTimeout(reload, 5000);
+      }
+    });
+  }
+  function replacePage(page) {
+    window.pprofLiveState = window.pprofSaveViewState ? window.pprofSaveViewState() : null;
+    document.open();
+    document.write(page);
+    document.close();
+  }
+  function connect() {
+    if (!isLive() || window.pprofLiveSource != null) return;
+    const url = new URL('./live', window.location.href);
+    url.search = window.location.search;
+    url.searchParams.set('page', './' + window.location.pathname.split('/').pop());
+    const source = new EventSource(url.toString());
+    let opened = false;
+    window.pprofLiveSource = source;
+    source.onopen = () => {
+      opened = true;
+    };
+    source.onmessage = (e) => {
+      const msg = JSON.parse(e.data);
+      const t = document.getElementById('live-toggle');
+      if (msg.error) {
+        if (t != null) t.title = 'Fetching the profile failed, retrying: ' + msg.error;
+      } else if (msg.page) {
+        replacePage(msg.page);
+      } else {
+        window.location.reload();
+      }
+    };
+    source.onerror = () => {
+      if (window.pprofLiveSource !== source) return; // Turned off.
+      // The browser reconnects by itself after the stream was cut, unless
+      // the server refused it.
+      if (opened && source.readyState != EventSource.CLOSED) return;
+      source.close();
+      window.pprofLiveSource = null;
+      if (opened) {
+        setTimeout(connect, 5000);
+      } else {
+        reload();
+      }
+    };
+  }
+  toggle.addEventListener('click', (e) => {
+    e.preventDefault();
+    live = !live;
+    try {
+      sessionStorage.setItem(key, live ? 'on' : 'off');
+    } catch (e) {}
+    update();
+    if (live) {
+      connect();
+    } else if (window.pprofLiveSource != null) {
+      const source = window.pprofLiveSource;
+      window.pprofLiveSource = null;
+      source.close();
+    }
+  });
+  update();
+  connect();
+}
+
+// Initialize the export menu of the graph and flame graph views, which
+// downloads their visualization as rendered, with its filters, zoom and
+// theme, as an SVG or PNG file. The styles of the visualization are
+// inlined, as the file is viewed without the style sheets of the page.
+function initExport() {
+  'use strict';
+  const menu = document.getElementById('export');
+  if (menu == null) return;
+  if (document.getElementById('graph') == null && document.getElementById('chart') == null) return;
+  menu.style.display = '';
+
+  const svgProps = ['fill', 'fill-opacity', 'stroke', 'stroke-width', 'stroke-dasharray',
+                    'stroke-opacity', 'opacity', 'visibility', 'display', 'font-family',
+                    'font-size', 'font-weight', 'font-style', 'text-anchor',
+                    'dominant-baseline'];
+  // The labels of the flame graph are HTML elements in foreignObjects.
+  const htmlProps = ['color', 'background-color', 'font-family', 'font-size', 'font-weight',
+                     'line-height', 'margin', 'padding', 'width', 'height', 'overflow',
+                     'white-space', 'text-overflow', 'text-align', 'visibility', 'display'];
+  function inline(src, dst) {
+    const style = window.getComputedStyle(src);
+    const props = src.namespaceURI == 'http://www.w3.org/2000/svg' ? svgProps : htmlProps;
+    let css = '';
+    for (const p of props) {
+      const v = style.getPropertyValue(p);
+      if (v !== '') css += p + ':' + v + ';';
+    }
+    dst.setAttribute('style', css);
+    for (let i = 0; i < src.children.length; i++) {
+      inline(src.children[i], dst.children[i]);
+    }
+  }
+
+  // rendered returns the rendered visualization as an SVG document, with
+  // its size and background, or null if there is none.
+  function rendered() {
+    const svg = document.querySelector('#graph svg, #chart svg');
+    if (svg == null) return null;
+    const box = svg.getBoundingClientRect();
+    const background = window.getComputedStyle(document.body).backgroundColor;
+    const copy = svg.cloneNode(true);
+    inline(svg, copy);
+    copy.setAttribute('xmlns', 'http://www.w3.org/2000/svg');
+    copy.setAttribute('width', box.width);
+    copy.setAttribute('height', box.height);
+    copy.style.backgroundColor = background;
+    return {
+      data: new XMLSerializer().serializeToString(copy),
+      width: box.width,
+      height: box.height,
+      background: background,
+    };
+  }
+
+  const name = 'pprof-' + (window.location.pathname.split('/').pop() || 'graph');
+  function download(blob, file) {
+    const a = document.createElement('a');
+    a.href = URL.createObjectURL(blob);
+    a.download = file;
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+    setTimeout(() => URL.revokeObjectURL(a.href), 0);
+  }
+
+  document.getElementById('export-svg').addEventListener('click', (e) => {
+    e.preventDefault();
+    const r = rendered();
+    if (r == null) return;
+    download(new Blob([r.data], {type: 'image/svg+xml'}), name + '.svg');
+  });
+  document.getElementById('export-png').addEventListener('click', (e) => {
+    e.preventDefault();
+    const r = rendered();
+    if (r == null) return;
+    // Draw at twice the size of the screen, for slides.
+    const scale = 2 * (window.devicePixelRatio || 1);
+    const img = new Image();
+    img.onload = () => {
+      const canvas = document.createElement('canvas');
+      canvas.width = Math.ceil(r.width * scale);
+      canvas.height = Math.ceil(r.height * scale);
+      const ctx = canvas.getContext('2d');
+      ctx.fillStyle = r.background;
+      ctx.fillRect(0, 0, canvas.width, canvas.height);
+      ctx.drawImage(img, 0, 0, canvas.width, canvas.height);
+      try {
+        canvas.toBlob((blob) => download(blob, name + '.png'), 'image/png');
+      } catch (err) {
+        // Some browsers do not draw the labels of foreignObjects to
+        // canvases they let scripts read.
+        alert('This browser cannot export the view as PNG, export it as SVG instead: ' + err);
+      }
+    };
+    img.src = 'data:image/svg+xml;charset=utf-8,' + encodeURIComponent(r.data);
+  });
+}
+
+// Initialize the panel of the labels of the samples of the view, which
+// shows the share of each value of each label, with links to focus on or
+// ignore the samples with the value. Each tab remembers whether the panel
+// is open.
+function initLabelPanel() {
+  'use strict';
+  const toggle = document.getElementById('labels-toggle');
+  const panel = document.getElementById('labelpanel');
+  if (toggle == null || panel == null) return;
+  const key = 'pprof-labels-' + (new URLSearchParams(window.location.search).get('tab') || '0');
+  let open = false;
+  try {
+    open = sessionStorage.getItem(key) == 'on';
+  } catch (e) {}
+  let loaded = false;
+
+  // filterURL returns the URL of the view filtered by the param, tf or
+  // ti, on the samples whose label of group has the value, or null if no
+  // filter matches only the value, as for numeric values that are not
+  // integers once scaled.
+  function filterURL(param, group, value) {
+    let filter = value;
+    if (!group.Numeric) {
+      // Commas separate the regexps of the filter.
+      filter = '^' + value.replace(/[\\^$.*+?()[\]{}|]/g, '\\$&').replace(/,/g, '\\x2c') + '$';
+    } else if (!/^[0-9]+[a-zA-Z]*$/.test(value)) {
+      return null;
+    }
+    const url = new URL(window.location.href);
+    url.hash = '';
+    url.searchParams.set(param, group.Key + '=' + filter);
+    return url.toString();
+  }
+
+  function addLink(row, text, title, href) {
+    if (href == null) return;
+    const a = document.createElement('a');
+    a.textContent = text;
+    a.title = title;
+    a.href = href;
+    row.appendChild(a);
+  }
+
+  function render(groups) {
+    panel.textContent = '';
+    if (groups == null || groups.length == 0) {
+      panel.textContent = 'The samples of this view have no labels.';
+      return;
+    }
+    for (const g of groups) {
+      const h = document.createElement('h2');
+      h.textContent = g.Key + ': ' + g.TotalFormat;
+      panel.appendChild(h);
+      for (const v of g.Values) {
+        const row = document.createElement('div');
+        row.className = 'label-value';
+        const bar = document.createElement('div');
+        bar.className = 'label-bar';
+        bar.style.width = (g.Total > 0 ? Math.abs(v.Flat) * 100 / g.Total : 0) + '%';
+        row.appendChild(bar);
+        const name = document.createElement('div');
+        name.className = 'label-name';
+        name.textContent = v.Value;
+        name.title = v.Value;
+        row.appendChild(name);
+        const value = document.createElement('span');
+        value.textContent = v.FlatFormat + (v.Percent ? ' (' + v.Percent.trim() + ')' : '');
+        row.appendChild(value);
+        addLink(row, 'focus', 'Focus on the samples with this value', filterURL('tf', g, v.Value));
+        addLink(row, 'ignore', 'Ignore the samples with this value', filterURL('ti', g, v.Value));
+        panel.appendChild(row);
+      }
+    }
+  }
+
+  function update() {
+    toggle.classList.toggle('labels-on', open);
+    panel.classList.toggle('open', open);
+    if (!open || loaded) return;
+    loaded = true;
+    panel.textContent = 'Loading...';
+    const url = new URL('./api/labels', window.location.href);
+    url.search = window.location.search;
+    fetch(url.toString())
+        .then((response) => {
+          if (!response.ok) throw new Error(response.statusText);
+          return response.json();
+        })
+        .then(render)
+        .catch((error) => {
+          loaded = false;
+          panel.textContent = 'Could not load the labels: ' + error.message;
+        });
+  }
+  toggle.addEventListener('click', (e) => {
+    e.preventDefault();
+    open = !open;
+    try {
+      sessionStorage.setItem(key, open ? 'on' : 'off');
+    } catch (e) {}
+    update();
+  });
+  update();
+}
+
 function sendURL(method, url, done) {
   fetch(url.toString(), {method: method})
       .then((response) => { done(response.ok); })
       .catch((error) => { done(false); });
 }
 
+// savePreferences saves the view preferences of prefs, by name, on the
+// server, which keeps them across sessions, and calls done, if set, with
+// whether they were saved.
+function savePreferences(prefs, done) {
+  fetch(new URL('./preferences', document.URL).toString(),
+        {method: 'POST', body: new URLSearchParams(prefs)})
+      .then((response) => { if (done) done(response.ok); })
+      .catch((error) => { if (done) done(false); });
+}
+
 // Initialize handlers for saving/loading configurations.
 function initConfigManager() {
   'use strict';
@@ -729,6 +1643,9 @@ function initConfigManager() {
   const delDialog = elem('delete-dialog');
   const delPrompt = elem('delete-prompt');
   const delError = elem('delete-error');
+  const prefsDialog = elem('preferences-dialog');
+  const prefsError = elem('preferences-error');
+  const prefs = {{.Preferences}};
 
   let currentDialog = null;
   let currentDeleteTarget = null;
@@ -823,6 +1740,31 @@ function initConfigManager() {
   bind('click', elem('delete-cancel'), cancelDialog);
   bind('click', elem('delete-confirm'), commitDelete);
 
+  // The preferences dialog shows the saved preferences, the first option
+  // of a preference standing for its default.
+  bind('click', elem('preferences'), (e) => {
+    for (const field of prefsDialog.querySelectorAll('[name]')) {
+      field.value = prefs[field.name] || (field.options ? field.options[0].value : '');
+    }
+    prefsError.innerText = '';
+    showDialog(prefsDialog);
+  });
+  bind('click', elem('preferences-cancel'), cancelDialog);
+  bind('click', elem('preferences-save'), () => {
+    const values = {};
+    for (const field of prefsDialog.querySelectorAll('[name]')) {
+      values[field.name] = field.value;
+    }
+    savePreferences(values, (ok) => {
+      if (!ok) {
+        prefsError.innerText = 'Save failed';
+        return;
+      }
+      showDialog(null);
+      location.reload();  // Reload to apply the preferences.
+    });
+  });
+
   // Activate deletion button for all config entries in menu.
   for (const del of Array.from(document.getElementsByClassName('menu-delete-btn'))) {
     bind('click', del, (e) => {
@@ -846,6 +1788,22 @@ function viewer(baseUrl, nodes) {
   let searchAlarm = null;
   let buttonsEnabled = true;
 
+  // The state of the view replaced by a live update, to restore.
+  const liveState = window.pprofLiveState || null;
+  window.pprofLiveState = null;
+
+  // Show the samples of the trace IDs entered, in all views.
+  function handleTrace(e) {
+    const url = new URL(window.location.href);
+    url.hash = '';
+    if (e.target.value != '') {
+      url.searchParams.set('trace', e.target.value);
+    } else {
+      url.searchParams.delete('trace');
+    }
+    window.location.href = url.toString();
+  }
+
   function handleDetails(e) {
     e.preventDefault();
     const detailsText = document.getElementById('detailsbox');
@@ -877,8 +1835,20 @@ function viewer(baseUrl, nodes) {
     updateButtons();
   }
 
+  // Keep the search in the URL of the view, to share it.
+  function saveSearch() {
+    const url = new URL(window.location.href);
+    if (search.value != '') {
+      url.searchParams.set('q', search.value);
+    } else {
+      url.searchParams.delete('q');
+    }
+    history.replaceState(history.state, '', url.toString());
+  }
+
   function selectMatching() {
     searchAlarm = null;
+    saveSearch();
     let re = null;
     if (search.value != '') {
       try {
@@ -1009,6 +1979,7 @@ function viewer(baseUrl, nodes) {
     if (id == 'hide') param = 'h';
     if (id == 'show') param = 's';
     if (id == 'show-from') param = 'sf';
+    if (id == 'percent-node') param = 'pn';
 
     // We update on mouseenter so middle-click/right-click work properly.
     elem.addEventListener('mouseenter', updater);
@@ -1022,6 +1993,12 @@ function viewer(baseUrl, nodes) {
         : Array.from(selected.keys()).map(key => quotemeta(nodes[key])).join('|');
 
       setHrefParams(elem, function (params) {
+        if (param == 'pn') {
+          // Keep the node of the percentages if nothing is selected.
+          params.set('pb', 'node');
+          if (re != '') params.set(param, re);
+          return;
+        }
         if (re != '') {
           // For focus/show/show-from, forget old parameter. For others, add to re.
           if (param != 'f' && param != 's' && param != 'sf' && params.has(param)) {
@@ -1098,21 +2075,87 @@ function viewer(baseUrl, nodes) {
 
   // Setup event handlers
   initMenus();
+  initThemeToggle();
+  initTabs();
+  initLive();
+  initLabelPanel();
+  initExport();
   if (svg != null) {
-    initPanAndZoom(svg, toggleSvgSelect);
+    initPanAndZoom(svg, toggleSvgSelect, liveState && liveState.viewBox);
   }
   if (toptable != null) {
     toptable.addEventListener('mousedown', handleTopClick);
     toptable.addEventListener('touchstart', handleTopClick);
   }
 
-  const ids = ['topbtn', 'graphbtn', 'flamegraph', 'peek', 'list', 'disasm',
-               'focus', 'ignore', 'hide', 'show', 'show-from'];
+  // Save the state of the view for live updates, which restore the nodes
+  // selected by name, as their numbers change with the profile.
+  window.pprofSaveViewState = function() {
+    return {
+      viewBox: svg != null ? svg.getAttribute('viewBox') : null,
+      selected: Array.from(selected.keys(), (n) => nodes[n]),
+      scrollX: window.scrollX,
+      scrollY: window.scrollY,
+    };
+  };
+  if (liveState != null) {
+    for (const name of liveState.selected) {
+      const n = nodes == null ? -1 : nodes.indexOf(name);
+      if (n >= 0) {
+        select(n, svg != null ? document.getElementById('node' + n) : topRow(name));
+      }
+    }
+    updateButtons();
+    document.addEventListener('DOMContentLoaded', function() {
+      window.scrollTo(liveState.scrollX, liveState.scrollY);
+    });
+  }
+
+  // topRow returns the row of the function name in the top table.
+  function topRow(name) {
+    if (toptable == null) return null;
+    for (const tr of toptable.rows) {
+      if (tr.children.length >= 6 && tr.children[5].innerText == name) return tr;
+    }
+    return null;
+  }
+
+  const ids = ['topbtn', 'graphbtn', 'flamegraph', 'treemapbtn', 'peek',
+               'sandwich', 'list', 'disasm', 'focus', 'ignore', 'hide', 'show', 'show-from',
+               'percent-node'{{range .CustomViews}}, 'view-{{.Name}}'{{end}}];
   ids.forEach(makeSearchLinkDynamic);
 
   const sampleIDs = [{{range .SampleTypes}}'{{.}}', {{end}}];
   sampleIDs.forEach(setSampleIndexLink);
 
+  for (const [id, base] of [['percent-total', 'total'], ['percent-focused', 'focused']]) {
+    const elem = document.getElementById(id);
+    if (elem == null) continue;
+    const updater = () => setHrefParams(elem, (params) => {
+      params.set('pb', base);
+      params.delete('pn');
+      params.delete('rel');
+    });
+    elem.addEventListener('mouseenter', updater);
+    elem.addEventListener('touchstart', updater);
+  }
+
+  // The granularity of the nodes of all views, which keeps the other
+  // parameters of the view.
+  for (const g of ['functions', 'filefunctions', 'files', 'lines', 'addresses']) {
+    const elem = document.getElementById('granularity-' + g);
+    if (elem == null) continue;
+    const updater = () => setHrefParams(elem, (params) => {
+      if (g == 'functions') {
+        params.delete('g');
+      } else {
+        params.set('g', g);
+      }
+    });
+    elem.addEventListener('mouseenter', updater);
+    elem.addEventListener('touchstart', updater);
+  }
+
   // Bind action to button with specified id.
   function addAction(id, action) {
     const btn = document.getElementById(id);
@@ -1128,6 +2171,22 @@ function viewer(baseUrl, nodes) {
   search.addEventListener('input', handleSearch);
   search.addEventListener('keydown', handleKey);
 
+  // Restore the search of a shared URL, once the scripts of the view
+  // listen to the search box.
+  const q = new URLSearchParams(window.location.search).get('q');
+  if (q) {
+    search.value = q;
+    document.addEventListener('DOMContentLoaded', function() {
+      search.dispatchEvent(new Event('input'));
+    });
+  }
+
+  const trace = document.getElementById('trace');
+  if (trace != null) {
+    trace.value = new URLSearchParams(window.location.search).get('trace') || '';
+    trace.addEventListener('change', handleTrace);
+  }
+
   // Give initial focus to main container so it can be scrolled using keys.
   const main = document.getElementById('bodycontainer');
   if (main) {
@@ -1176,6 +2235,15 @@ function viewer(baseUrl, nodes) {
         entries[i].Id = 'node' + i;
       }
 
+      // The external metadata of the functions, if any, is shown as
+      // badges in an additional column.
+      const badges = entries.some((e) => e.Badges);
+      if (badges) {
+        const th = document.createElement('th');
+        th.textContent = 'Metadata';
+        document.querySelector('#toptable thead tr').appendChild(th);
+      }
+
       // Which column are we currently sorted by and in what order?
       let currentColumn = '';
       let descending = false;
@@ -1225,6 +2293,16 @@ function viewer(baseUrl, nodes) {
           addCell(tr, percent(row.Cum));
           addCell(tr, row.Name);
           addCell(tr, row.InlineLabel);
+          if (badges) {
+            const td = document.createElement('td');
+            for (const b of row.Badges || []) {
+              const span = document.createElement('span');
+              span.className = 'badge';
+              span.textContent = b;
+              td.appendChild(span);
+            }
+            tr.appendChild(td);
+          }
           fragment.appendChild(tr);
         }
 
@@ -1296,6 +2374,633 @@ function viewer(baseUrl, nodes) {
 </html>
 {{end}}
 
+{{define "customview" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+</head>
+<body>
+  {{template "header" .}}
+  <div id="content">
+    {{.HTMLBody}}
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), null);</script>
+</body>
+</html>
+{{end}}
+
+{{define "labelvalue" -}}
+{{if .URL}}<a href="{{.URL}}" target="_blank" rel="noopener noreferrer">{{.Value}}</a>{{else}}{{.Value}}{{end}}
+{{- end}}
+
+{{define "tags" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+</head>
+<body>
+  {{template "header" .}}
+  <div id="content">
+    <pre>
+{{range .Tags}}{{.Key}}: Total {{.TotalFormat}}
+{{range .Values}}{{printf "%12s" .FlatFormat}} {{with .Percent}}{{printf "(%s)" . | printf "%9s"}}{{end}}  {{template "labelvalue" .}}
+{{end}}
+{{end}}</pre>
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), null);</script>
+</body>
+</html>
+{{end}}
+
+{{define "traces" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #traces {
+      margin: 0 2em;
+    }
+    #tracenav {
+      margin: 1em 2em;
+    }
+    #tracenav a {
+      margin-left: 1em;
+    }
+    .trace {
+      border-top: 1px solid var(--input-border);
+      padding: 2px 0;
+    }
+    .trace summary {
+      cursor: pointer;
+      white-space: nowrap;
+      overflow: hidden;
+      text-overflow: ellipsis;
+    }
+    .tracevalue {
+      display: inline-block;
+      min-width: 6em;
+      text-align: right;
+      margin-right: 1em;
+      font-weight: bold;
+    }
+    .tracelabel {
+      margin-left: 1em;
+      opacity: .7;
+    }
+    .tracelabels, .tracestack {
+      margin: 4px 0 4px 7em;
+    }
+    .tracestack {
+      padding-left: 2em;
+      font-family: monospace;
+    }
+    .tracestack .fileline, .tracestack .inline {
+      opacity: .6;
+    }
+    .tracefocus {
+      font-size: 80%;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="content">
+    {{with .TraceNav}}
+    <div id="tracenav">
+      {{if .Total}}Samples {{.First}}&ndash;{{.Last}} of {{.Total}}{{else}}No samples{{end}},
+      sorted by {{if .ByWeight}}weight{{else}}profile order{{end}}
+      <a href="{{.SortURL}}" id="tracesort">Sort by {{if .ByWeight}}profile order{{else}}weight{{end}}</a>
+      {{if .PrevURL}}<a href="{{.PrevURL}}" id="traceprev">&larr; Previous</a>{{end}}
+      {{if gt .Pages 1}}<span>Page {{.Page}} of {{.Pages}}</span>{{end}}
+      {{if .NextURL}}<a href="{{.NextURL}}" id="tracenext">Next &rarr;</a>{{end}}
+      <a href="#" id="tracesexpand">Expand all</a>
+      <a href="#" id="tracescollapse">Collapse all</a>
+    </div>
+    {{end}}
+    <div id="traces">
+      {{range .Traces}}
+      <details class="trace">
+        <summary><span class="tracevalue">{{.Value}}</span>{{(index .Frames 0).Name}}
+          {{- range .Labels}}<span class="tracelabel">{{.Key}}:{{range .Values}} {{.Value}}{{end}}</span>{{end}}</summary>
+        {{if or .Labels .NumLabels}}
+        <div class="tracelabels">
+          {{range .Labels}}<div>{{.Key}}:{{range .Values}} {{template "labelvalue" .}}
+            <a class="tracefocus" href="{{.FocusURL}}" title="Focus on the samples with this value">focus</a>{{end}}</div>{{end}}
+          {{range .NumLabels}}<div>{{.Key}}:{{range .Values}} {{.}}{{end}}</div>{{end}}
+        </div>
+        {{end}}
+        <ol class="tracestack">
+          {{range .Frames}}<li><a href="{{.FocusURL}}" title="Focus on the samples going through {{.Name}}">{{.Name}}</a>
+            {{- if .Inline}} <span class="inline">(inline)</span>{{end}}
+            {{- if .FileLine}} <span class="fileline">{{.FileLine}}</span>{{end}}</li>
+          {{end}}
+        </ol>
+      </details>
+      {{end}}
+    </div>
+  </div>
+  {{template "script" .}}
+  <script>
+    viewer(new URL(window.location.href), null);
+    for (const [id, open] of [['tracesexpand', true], ['tracescollapse', false]]) {
+      document.getElementById(id).addEventListener('click', (e) => {
+        e.preventDefault();
+        for (const d of document.querySelectorAll('#traces details')) d.open = open;
+      });
+    }
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "timeline" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #timelineinfo {
+      margin: 0 2em 1em;
+    }
+    #timelineinfo a {
+      margin-left: 1em;
+    }
+    #timeline {
+      margin: 0 2em;
+      user-select: none;
+    }
+    #timeline svg {
+      display: block;
+      width: 100%;
+      height: 240px;
+      cursor: crosshair;
+      border-bottom: 1px solid var(--input-border);
+    }
+    #timeline .bar {
+      fill: #4c8bf5;
+    }
+    #timeline .bar:hover {
+      fill: #2a66d9;
+    }
+    #timeline .brush {
+      fill: var(--hilite-bg);
+      fill-opacity: .6;
+      stroke: var(--link);
+      stroke-width: 1px;
+      vector-effect: non-scaling-stroke;
+    }
+    #timelineaxis {
+      display: flex;
+      justify-content: space-between;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="content">
+    {{if .Timeline}}
+    <div id="timelineinfo"></div>
+    <div id="timeline">
+      <svg id="timelinechart" preserveAspectRatio="none"></svg>
+      <div id="timelineaxis"></div>
+    </div>
+    {{else}}
+    <p>The samples of this view have no timestamp label.</p>
+    {{end}}
+  </div>
+  {{template "script" .}}
+  <script>
+    // pprofTimeline draws the timeline tl as a bar per bucket, in which
+    // dragging selects the time window of the views, as the tw param.
+    function pprofTimeline(tl) {
+      'use strict';
+      if (tl == null) return;
+      const svgns = 'http://www.w3.org/2000/svg';
+      const chart = document.getElementById('timelinechart');
+      const n = tl.Values.length;
+      const start = BigInt(tl.Start), step = BigInt(tl.Step);
+      let max = 0;
+      for (const v of tl.Values) max = Math.max(max, Math.abs(v));
+      chart.setAttribute('viewBox', '0 0 ' + n + ' 100');
+
+      // offset returns the time after the start of bucket i, formatted.
+      function offset(i) {
+        const s = Number(step * BigInt(i)) / 1e9;
+        return '+' + (s < 10 ? s.toFixed(3) : s.toFixed(1)) + 's';
+      }
+
+      for (let i = 0; i < n; i++) {
+        const h = max > 0 ? Math.abs(tl.Values[i]) * 100 / max : 0;
+        const bar = document.createElementNS(svgns, 'rect');
+        bar.setAttribute('class', 'bar');
+        bar.setAttribute('x', i);
+        bar.setAttribute('y', 100 - h);
+        bar.setAttribute('width', 0.9);
+        bar.setAttribute('height', h);
+        const title = document.createElementNS(svgns, 'title');
+        title.textContent = offset(i) + ': ' + tl.Formats[i];
+        bar.appendChild(title);
+        chart.appendChild(bar);
+      }
+      const axis = document.getElementById('timelineaxis');
+      for (let t = 0; t <= 4; t++) {
+        const tick = document.createElement('span');
+        tick.textContent = offset(Math.round(t * n / 4));
+        axis.appendChild(tick);
+      }
+
+      const info = document.getElementById('timelineinfo');
+      let text = 'From ';
+      // Timestamps after 1973 are taken as times since the epoch.
+      if (start > BigInt(1e17)) {
+        text += new Date(Number(start / BigInt(1e6))).toISOString();
+      } else {
+        text += tl.Start + 'ns';
+      }
+      info.textContent = text + ', ' + offset(n) + ' in buckets of ' + offset(1) +
+          '. Drag over the timeline to show the samples of a time window in all views.';
+      const url = new URL(window.location.href);
+      if (url.searchParams.get('tw')) {
+        url.searchParams.delete('tw');
+        const all = document.createElement('a');
+        all.href = url.toString();
+        all.textContent = 'Show all times';
+        info.appendChild(all);
+      }
+
+      // Dragging selects whole buckets.
+      const brush = document.createElementNS(svgns, 'rect');
+      brush.setAttribute('class', 'brush');
+      brush.setAttribute('y', 0);
+      brush.setAttribute('height', 100);
+      brush.setAttribute('width', 0);
+      chart.appendChild(brush);
+      let from = null;
+      function bucket(e) {
+        const r = chart.getBoundingClientRect();
+        return Math.min(n - 1, Math.max(0, Math.floor((e.clientX - r.left) * n / r.width)));
+      }
+      function span(to) {
+        return [Math.min(from, to), Math.max(from, to)];
+      }
+      chart.addEventListener('mousedown', (e) => {
+        e.preventDefault();
+        from = bucket(e);
+      });
+      document.addEventListener('mousemove', (e) => {
+        if (from == null) return;
+        const [i, j] = span(bucket(e));
+        brush.setAttribute('x', i);
+        brush.setAttribute('width', j - i + 1);
+      });
+      document.addEventListener('mouseup', (e) => {
+        if (from == null) return;
+        const [i, j] = span(bucket(e));
+        from = null;
+        const url = new URL(window.location.href);
+        url.searchParams.set('tw', (start + step * BigInt(i)) + ':' + (start + step * BigInt(j + 1)));
+        window.location.href = url.toString();
+      });
+    }
+
+    viewer(new URL(window.location.href), null);
+    pprofTimeline({{.Timeline}});
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "heatmap" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #heatmaptable {
+      margin: 0 2em;
+      border-collapse: collapse;
+    }
+    #heatmaptable th.column {
+      writing-mode: vertical-rl;
+      transform: rotate(180deg);
+      padding: 4px 0;
+    }
+    #heatmaptable td.cell {
+      min-width: 3em;
+      text-align: right;
+      border: 1px solid var(--input-border);
+    }
+    #heatmaptable td.name {
+      text-align: left;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="content">
+    {{if .Heatmap}}
+    <p>Flat share of the total of each profile, the darker the larger. Click a
+    profile to focus on its samples, or a function to list it in the top view.</p>
+    <table id="heatmaptable"></table>
+    {{else}}
+    <p>The samples of this view are not labeled with the profile of a series;
+    load several profiles with -series.</p>
+    {{end}}
+  </div>
+  {{template "script" .}}
+  <script>
+    // pprofHeatmap fills the heatmap table with a row per function of hm and
+    // a column per profile, shading the cells by their flat share.
+    function pprofHeatmap(hm) {
+      'use strict';
+      if (hm == null) return;
+      const table = document.getElementById('heatmaptable');
+
+      // Commas separate the regexps of the filters.
+      function quote(s) {
+        return '^' + s.replace(/[\\^$.*+?()[\]{}|]/g, '\\$&').replace(/,/g, '\\x2c') + '$';
+      }
+      function viewURL(view, param, value) {
+        const url = new URL('./' + view, window.location.href);
+        url.search = window.location.search;
+        url.searchParams.set(param, value);
+        return url.toString();
+      }
+      function link(text, href, title) {
+        const a = document.createElement('a');
+        a.textContent = text;
+        a.href = href;
+        a.title = title;
+        return a;
+      }
+
+      let max = 0;
+      for (const row of hm.Rows) {
+        for (const s of row.Share) max = Math.max(max, s);
+      }
+      const head = table.insertRow();
+      const fn = document.createElement('th');
+      fn.textContent = 'Function';
+      for (let i = 0; i < hm.Columns.length; i++) {
+        const th = document.createElement('th');
+        th.className = 'column';
+        const col = hm.Columns[i];
+        th.appendChild(link(col, viewURL('heatmap', 'tf', 'series=' + quote(col)),
+            'Focus on the samples of this profile'));
+        head.appendChild(th);
+      }
+      head.appendChild(fn);
+      for (const row of hm.Rows) {
+        const tr = table.insertRow();
+        for (let i = 0; i < row.Share.length; i++) {
+          const td = tr.insertCell();
+          td.className = 'cell';
+          const s = row.Share[i];
+          if (row.Flat[i] != 0) {
+            td.textContent = s.toFixed(1) + '%';
+            td.title = row.Name + ' in ' + hm.Columns[i] + ': ' + s.toFixed(2) + '%';
+          }
+          if (max > 0 && s > 0) {
+            td.style.backgroundColor = 'rgba(220, 50, 32, ' + (s / max).toFixed(3) + ')';
+          }
+        }
+        const name = tr.insertCell();
+        name.className = 'name';
+        name.appendChild(link(row.Name, viewURL('top', 'f', quote(row.Name)),
+            'List this function in the top view'));
+      }
+    }
+
+    viewer(new URL(window.location.href), null);
+    pprofHeatmap({{.Heatmap}});
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "treemap" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    .treemap-details {
+      height: 1.2em;
+      margin: 0 5%;
+      padding: 15px 0;
+    }
+    .treemap-nesting {
+      margin: 0 5% 10px;
+    }
+    .treemap-nesting a.selected {
+      font-weight: bold;
+    }
+    #treemap {
+      position: relative;
+      margin: 0 5%;
+    }
+    #treemap div {
+      position: absolute;
+      box-sizing: border-box;
+      overflow: hidden;
+      white-space: nowrap;
+      text-overflow: ellipsis;
+      border: 1px solid rgba(0, 0, 0, 0.3);
+      color: #000;
+      font-size: 12px;
+      line-height: 14px;
+      padding: 0 2px;
+      cursor: pointer;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="bodycontainer">
+    <div class="treemap-nesting">
+      Nest functions in their
+      <a id="nestcallers" href="#" title="Nest the nodes of the call tree in the rectangles of their callers"
+         {{if not .TreemapByPackage}}class="selected"{{end}}>callers</a> |
+      <a id="nestpackages" href="#" title="Nest the functions, by their flat values, in the rectangles of their packages"
+         {{if .TreemapByPackage}}class="selected"{{end}}>packages</a>.
+      Click a rectangle to zoom on it, and the outermost one, or Escape, to zoom out.
+    </div>
+    <div id="treemapdetails" class="treemap-details"></div>
+    <div id="treemap"></div>
+  </div>
+  {{template "script" .}}
+  <script>{{template "flamegraphrendererscript" .}}</script>
+  <script>
+    // pprofTreemap draws the tree of data, as the stack trees of flame
+    // graphs, in the container element: the area of the rectangle of each
+    // node is proportional to its value, and holds the rectangles of its
+    // children. It shows the details of the node under the mouse in the
+    // details element.
+    function pprofTreemap(container, details, data) {
+      'use strict';
+      const minSize = 3; // Smallest side of the rectangles drawn, in pixels.
+      const header = 16; // Height of the names of the rectangles with children.
+
+      let maxDelta = 0;
+      (function visit(n) {
+        maxDelta = Math.max(maxDelta, Math.abs(n.d || 0));
+        for (const c of n.c || []) visit(c);
+      })(data);
+
+      // The rectangles are colored as the frames of flame graphs: by their
+      // change in differential profiles, and warm colors hashed from their
+      // names otherwise.
+      function color(n) {
+        if (n.d !== undefined) return diffColor(n.d, maxDelta);
+        let h = 0;
+        for (let i = 0; i < n.n.length; i++) {
+          h = (h * 31 + n.n.charCodeAt(i)) >>> 0;
+        }
+        return 'hsl(' + (h % 50) + ', 80%, ' + (60 + (h >> 8) % 20) + '%)';
+      }
+
+      // <full name> (percentage, value[, change])[ [metadata]]
+      function label(n) {
+        return n.f + ' (' + n.p + ', ' + n.l + (n.dl ? ', ' + n.dl : '') + ')' +
+          (n.b ? ' [' + n.b.join(' ') + ']' : '');
+      }
+
+      // squarify lays out the nodes in the rectangle of the given position
+      // and size, whose area stands for the value total, in rows keeping
+      // the aspect ratios of their rectangles close to 1. The area left
+      // over by nodes whose values sum to less than total is at the end.
+      function squarify(nodes, total, x, y, w, h) {
+        const rects = [];
+        if (total <= 0) return rects;
+        const scale = w * h / total;
+        const items = nodes.filter((n) => n.v > 0).sort((a, b) => b.v - a.v);
+
+        // worst returns the largest aspect ratio of the rectangles of row,
+        // of area sum, laid along side.
+        function worst(row, sum, side) {
+          const max = row[0].v * scale;
+          const min = row[row.length - 1].v * scale;
+          return Math.max(side * side * max / (sum * sum), sum * sum / (side * side * min));
+        }
+        function layout(row, sum) {
+          if (w >= h) {
+            const rw = sum / h;
+            let ry = y;
+            for (const n of row) {
+              const rh = n.v * scale / rw;
+              rects.push({node: n, x: x, y: ry, w: rw, h: rh});
+              ry += rh;
+            }
+            x += rw;
+            w -= rw;
+          } else {
+            const rh = sum / w;
+            let rx = x;
+            for (const n of row) {
+              const rw = n.v * scale / rh;
+              rects.push({node: n, x: rx, y: y, w: rw, h: rh});
+              rx += rw;
+            }
+            y += rh;
+            h -= rh;
+          }
+        }
+
+        let row = [];
+        let sum = 0;
+        for (const n of items) {
+          const side = Math.min(w, h);
+          const a = n.v * scale;
+          if (row.length == 0 || worst(row.concat([n]), sum + a, side) <= worst(row, sum, side)) {
+            row.push(n);
+            sum += a;
+          } else {
+            layout(row, sum);
+            row = [n];
+            sum = a;
+          }
+        }
+        if (row.length > 0) layout(row, sum);
+        return rects;
+      }
+
+      const zoomed = [data];
+      function draw(n, x, y, w, h) {
+        const div = document.createElement('div');
+        div.style.left = x + 'px';
+        div.style.top = y + 'px';
+        div.style.width = w + 'px';
+        div.style.height = h + 'px';
+        div.style.backgroundColor = color(n);
+        div.title = label(n);
+        if (w > 30 && h > 12) div.textContent = n.n;
+        div.addEventListener('mouseover', () => {
+          if (details) details.textContent = label(n);
+        });
+        div.addEventListener('click', () => {
+          if (n === zoomed[zoomed.length - 1]) {
+            if (zoomed.length > 1) zoomed.pop();
+          } else {
+            zoomed.push(n);
+          }
+          render();
+        });
+        container.appendChild(div);
+        const children = squarify(n.c || [], n.v, x + 1, y + header, w - 2, h - header - 1);
+        for (const r of children) {
+          if (r.w >= minSize && r.h >= minSize) draw(r.node, r.x, r.y, r.w, r.h);
+        }
+      }
+      function render() {
+        container.innerHTML = '';
+        const height = Math.max(300, window.innerHeight - container.getBoundingClientRect().top - 20);
+        container.style.height = height + 'px';
+        draw(zoomed[zoomed.length - 1], 0, 0, container.clientWidth, height);
+      }
+
+      document.addEventListener('keydown', (e) => {
+        if (e.key != 'Escape' || zoomed.length == 1) return;
+        zoomed.pop();
+        render();
+      });
+      window.addEventListener('resize', render);
+      render();
+    }
+
+    viewer(new URL(window.location.href), null);
+    for (const [id, nest] of [['nestcallers', ''], ['nestpackages', 'package']]) {
+      const url = new URL(window.location.href);
+      if (nest) {
+        url.searchParams.set('nest', nest);
+      } else {
+        url.searchParams.delete('nest');
+      }
+      document.getElementById(id).href = url.toString();
+    }
+    pprofTreemap(document.getElementById('treemap'),
+                 document.getElementById('treemapdetails'), {{.Treemap}});
+  </script>
+</body>
+</html>
+{{end}}
+
 {{define "flamegraph" -}}
 <!DOCTYPE html>
 <html>
@@ -1317,54 +3022,262 @@ function viewer(baseUrl, nodes) {
       margin-left: 5%;
       padding: 15px 0 35px;
     }
+    .flamegraph-matches {
+      display: none;
+      margin: -25px 0 10px 5%;
+    }
+    .flamegraph-orientation {
+      float: right;
+      margin: 15px 5% 0 0;
+    }
+    .flamegraph-orientation select {
+      margin-right: 1em;
+    }
+    .flamegraph-colors {
+      width: 90%;
+      margin: 10px 5% 0;
+    }
+    .flamegraph-colors span {
+      display: inline-block;
+      margin-right: 1.5em;
+    }
+    .flamegraph-colors i {
+      display: inline-block;
+      width: 0.9em;
+      height: 0.9em;
+      margin-right: 0.3em;
+      vertical-align: middle;
+      border: 1px solid #999;
+    }
+    .flamegraph-matches.visible {
+      display: block;
+    }
+    .flamegraph-matches.error {
+      color: #cc0000;
+    }
+    .flamegraph-matches a {
+      margin-left: 1em;
+    }
+    #chart g.selected rect {
+      stroke: var(--text, #000);
+      stroke-width: 2px;
+    }
   </style>
 </head>
 <body>
   {{template "header" .}}
   <div id="bodycontainer">
+    <div class="flamegraph-orientation">
+      <select id="color-mode" title="{{.Help.color}}">
+        <option value="">Color by name</option>
+        <option value="package"{{if eq .FlameGraphColor "package"}} selected{{end}}>Color by package</option>
+        <option value="mapping"{{if eq .FlameGraphColor "mapping"}} selected{{end}}>Color by binary</option>
+        <option value="kind"{{if eq .FlameGraphColor "kind"}} selected{{end}}>Color by language</option>
+        {{$color := .FlameGraphColor}}
+        {{range .LabelKeys}}<option value="label:{{.}}"{{if eq $color (printf "label:%s" .)}} selected{{end}}>Color by label {{.}}</option>
+        {{end}}
+      </select>
+      <a id="orientation-toggle" href="#" title="{{.Help.orientation}}"></a>
+    </div>
     <div id="flamegraphdetails" class="flamegraph-details"></div>
+    <div id="flamegraphmatches" class="flamegraph-matches">
+      <span class="matches"></span>
+      <a id="matchfocus" href="#" title="Only show the samples going through the matched frames">Focus</a>
+      <a id="matchhide" href="#" title="Remove the matched frames from the samples">Hide</a>
+    </div>
     <div class="flamegraph-content">
       <div id="chart"></div>
     </div>
+    <div id="flamegraphcolors" class="flamegraph-colors"></div>
   </div>
   {{template "script" .}}
   <script>viewer(new URL(window.location.href), {{.Nodes}});</script>
   <script>{{template "d3script" .}}</script>
   <script>{{template "d3flamegraphscript" .}}</script>
+  <script>{{template "flamegraphrendererscript" .}}</script>
+  <script>{{template "flamegraphpagescript" .}}</script>
+</body>
+</html>
+{{end}}
+
+{{define "sandwich" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">{{template "d3flamegraphcss" .}}</style>
+  <style type="text/css">
+    .flamegraph-content {
+      width: 90%;
+      min-width: 80%;
+      margin-left: 5%;
+    }
+    .flamegraph-details {
+      height: 1.2em;
+      width: 90%;
+      min-width: 90%;
+      margin-left: 5%;
+      padding: 15px 0 35px;
+    }
+    .sandwich-message {
+      margin-left: 5%;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="bodycontainer">
+    {{if .FlameGraph}}
+    <div id="flamegraphdetails" class="flamegraph-details"></div>
+    <div class="flamegraph-content">
+      <div id="callers"></div>
+      <div id="callees"></div>
+    </div>
+    {{else}}
+    <p class="sandwich-message">Search for the functions to show their callers and callees.</p>
+    {{end}}
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), {{.Nodes}});</script>
+  {{if .FlameGraph}}
+  <script>{{template "d3script" .}}</script>
+  <script>{{template "d3flamegraphscript" .}}</script>
+  <script>{{template "flamegraphrendererscript" .}}</script>
   <script>
     var data = {{.FlameGraph}};
+    var details = document.getElementById('flamegraphdetails');
+
+    // The callers are drawn upwards from the selected functions, and their
+    // callees downwards, both weighted by the samples of the functions.
+    var graphs = [
+      pprofFlameGraph(document.getElementById('callers'), details, data.callers, true),
+      pprofFlameGraph(document.getElementById('callees'), details, data.callees),
+    ];
+
+    function clear() {
+      graphs.forEach((g) => g.clear());
+    }
+
+    function resetZoom() {
+      graphs.forEach((g) => g.resetZoom());
+    }
 
-    var width = document.getElementById('chart').clientWidth;
-
-    var flameGraph = d3.flamegraph()
-      .width(width)
-      .cellHeight(18)
-      .minFrameSize(1)
-      .transitionDuration(750)
-      .transitionEase(d3.easeCubic)
-      .inverted(true)
-      .sort(true)
-      .title('')
-      .tooltip(false)
-      .details(document.getElementById('flamegraphdetails'));
-
-    // <full name> (percentage, value)
-    flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l + ')');
-
-    (function(flameGraph) {
-      var oldColorMapper = flameGraph.color();
-      function colorMapper(d) {
-        // Hack to force default color mapper to use 'warm' color scheme by not passing libtype
-        const { data, highlight } = d;
-        return oldColorMapper({ data: { n: data.n }, highlight });
+    window.addEventListener('resize', function() {
+      var width = document.getElementById('callees').clientWidth;
+      for (const svg of document.getElementsByClassName('d3-flame-graph')) {
+        svg.setAttribute('width', width);
       }
+      graphs.forEach((g) => {
+        g.width(width);
+        g.resetZoom();
+      });
+    }, true);
 
-      flameGraph.color(colorMapper);
-    }(flameGraph));
+    var search = document.getElementById('search');
+    var searchAlarm = null;
+
+    function selectMatching() {
+      searchAlarm = null;
+      graphs.forEach((g) => search.value != '' ? g.search(search.value) : g.clear());
+    }
+
+    search.addEventListener('input', function() {
+      // Delay expensive processing so a flurry of key strokes is handled once.
+      if (searchAlarm != null) {
+        clearTimeout(searchAlarm);
+      }
+      searchAlarm = setTimeout(selectMatching, 300);
+    });
+  </script>
+  {{end}}
+</body>
+</html>
+{{end}}
+
+{{define "flamegraphpagescript"}}
+    var data = {{.FlameGraph}};
+
+    // The flame graph is drawn as an icicle, its root at the top, unless
+    // the preferences draw it as a flame, which the orientation toggle
+    // switches between.
+    var flameGraph = pprofFlameGraph(document.getElementById('chart'),
+      document.getElementById('flamegraphdetails'), data,
+      {{.Preferences.Orientation}} == 'flame');
+    var orientationToggle = document.getElementById('orientation-toggle');
+    if (orientationToggle != null) {
+      var showOrientation = function() {
+        orientationToggle.textContent = flameGraph.inverted() ? 'Draw as a flame' : 'Draw as an icicle';
+      };
+      orientationToggle.addEventListener('click', function(e) {
+        e.preventDefault();
+        flameGraph.inverted(!flameGraph.inverted());
+        flameGraph.resetZoom();
+        savePreferences({orientation: flameGraph.inverted() ? 'icicle' : 'flame'});
+        showOrientation();
+      });
+      showOrientation();
+    }
+
+    // The color mode reloads the flame graph colored by the chosen groups,
+    // the largest of which are listed below it.
+    var colorMode = document.getElementById('color-mode');
+    if (colorMode != null) {
+      colorMode.addEventListener('change', function() {
+        var url = new URL(window.location.href);
+        if (colorMode.value == '') {
+          url.searchParams.delete('color');
+        } else {
+          url.searchParams.set('color', colorMode.value);
+        }
+        window.location.href = url.toString();
+      });
+    }
+    var colorLegend = document.getElementById('flamegraphcolors');
+    if (colorLegend != null) {
+      for (const g of pprofGroupLegend(data).slice(0, 20)) {
+        var entry = document.createElement('span');
+        var swatch = document.createElement('i');
+        swatch.style.background = g.color;
+        entry.appendChild(swatch);
+        entry.appendChild(document.createTextNode(g.name || 'other'));
+        colorLegend.appendChild(entry);
+      }
+    }
 
-    d3.select('#chart')
-      .datum(data)
-      .call(flameGraph);
+    // F focuses on the selected frame in the web interface, whose header
+    // refines the profile.
+    var focusFrame = null;
+    if (document.getElementById('focus') != null) {
+      focusFrame = function(name) {
+        var url = new URL(window.location.href);
+        url.searchParams.set('f', '^' + name.replace(/([\\\.?+*\[\](){}|^$])/g, '\\$1') + '$');
+        window.location.href = url.toString();
+      };
+    }
+    pprofFlameGraphKeys(document.getElementById('chart'), flameGraph,
+      document.getElementById('flamegraphdetails'), focusFrame);
+
+    // Keep the frame zoomed on in the URL of the web interface, to share
+    // it, and zoom on the frame of a shared URL.
+    if (focusFrame != null) {
+      flameGraph.onClick(function(d) {
+        var url = new URL(window.location.href);
+        var path = pprofFramePath(d);
+        if (path != '') {
+          url.searchParams.set('frame', path);
+        } else {
+          url.searchParams.delete('frame');
+        }
+        history.replaceState(history.state, '', url.toString());
+      });
+      var frame = new URL(window.location.href).searchParams.get('frame');
+      var zoomed = frame && pprofFindFrame(d3.select('#chart').datum(), frame);
+      if (zoomed) {
+        flameGraph.zoomTo(zoomed);
+      }
+    }
 
     function clear() {
       flameGraph.clear();
@@ -1390,10 +3303,47 @@ function viewer(baseUrl, nodes) {
     function selectMatching() {
       searchAlarm = null;
 
-      if (search.value != '') {
-        flameGraph.search(search.value);
-      } else {
+      if (search.value == '') {
         flameGraph.clear();
+        showMatches();
+        return;
+      }
+      try {
+        showMatches(flameGraph.search(search.value));
+      } catch (e) {
+        // The search is not a valid regexp.
+        showMatches(null);
+      }
+    }
+
+    // showMatches shows the number of frames found by the search and
+    // their share of the total, or that the search is not a valid regexp
+    // if results is null, or hides them if there is no search.
+    function showMatches(results) {
+      var box = document.getElementById('flamegraphmatches');
+      if (box == null) return;
+      box.classList.toggle('visible', results !== undefined);
+      box.classList.toggle('error', results === null);
+      var text = box.querySelector('.matches');
+      if (!results) {
+        text.textContent = results === null ? 'Invalid regexp' : '';
+        return;
+      }
+      var percent = data.v ? 100 * pprofMatchedValue(results) / Math.abs(data.v) : 0;
+      text.textContent = results.length + (results.length == 1 ? ' frame' : ' frames') +
+        ' matched, ' + percent.toFixed(2) + '% of the total';
+      // Focus on or hide the matched frames in one click. A hidden
+      // frame is added to those already hidden.
+      for (const [id, param] of [['matchfocus', 'f'], ['matchhide', 'h']]) {
+        var link = document.getElementById(id);
+        if (link == null) continue;
+        var url = new URL(window.location.href);
+        var re = search.value;
+        if (param == 'h' && url.searchParams.get('h')) {
+          re += '|' + url.searchParams.get('h');
+        }
+        url.searchParams.set(param, re);
+        link.href = url.toString();
       }
     }
 
@@ -1406,6 +3356,187 @@ function viewer(baseUrl, nodes) {
     }
 
     search.addEventListener('input', handleSearch);
+{{end}}
+
+{{define "flamegraphfile" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">{{template "d3flamegraphcss" .}}</style>
+  <style type="text/css">
+    .flamegraph-content {
+      width: 90%;
+      min-width: 80%;
+      margin-left: 5%;
+    }
+    .flamegraph-details {
+      height: 1.2em;
+      width: 90%;
+      min-width: 90%;
+      margin-left: 5%;
+      padding: 15px 0 35px;
+    }
+    .flamegraph-matches {
+      display: none;
+      margin: -25px 0 10px 5%;
+    }
+    .flamegraph-matches.visible {
+      display: block;
+    }
+    .flamegraph-matches.error {
+      color: #cc0000;
+    }
+    .flamegraph-matches a {
+      margin-left: 1em;
+    }
+    #chart g.selected rect {
+      stroke: var(--text, #000);
+      stroke-width: 2px;
+    }
+  </style>
+</head>
+<body>
+  <div class="header">
+    <div class="title">
+      <h1>pprof</h1>
+    </div>
+    <div>
+      <input id="search" type="text" placeholder="Search regexp" autocomplete="off" autocapitalize="none" size=40>
+    </div>
+    <div class="description">
+      <a href="#" id="details">{{.Title}}</a>
+      <div id="detailsbox">
+        {{range .Legend}}<div>{{.}}</div>{{end}}
+      </div>
+    </div>
+  </div>
+  <div id="bodycontainer">
+    <div id="flamegraphdetails" class="flamegraph-details"></div>
+    <div id="flamegraphmatches" class="flamegraph-matches"><span class="matches"></span></div>
+    <div class="flamegraph-content">
+      <div id="chart"></div>
+    </div>
+  </div>
+  <script>{{template "d3script" .}}</script>
+  <script>{{template "d3flamegraphscript" .}}</script>
+  <script>{{template "flamegraphrendererscript" .}}</script>
+  <script>{{template "flamegraphpagescript" .}}</script>
+  <script>
+    document.getElementById('details').addEventListener('click', function(e) {
+      var box = document.getElementById('detailsbox');
+      box.style.display = box.style.display === 'block' ? 'none' : 'block';
+      e.preventDefault();
+    });
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "compare" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">{{template "d3flamegraphcss" .}}</style>
+  <style type="text/css">
+    .compare-section {
+      width: 90%;
+      margin-left: 5%;
+    }
+    .compare-section table {
+      border-collapse: collapse;
+    }
+    .compare-section th {
+      background-color: var(--table-header-bg);
+      padding: 2px 10px;
+    }
+    .compare-section td {
+      padding: 2px 10px;
+      text-align: right;
+      font-family: monospace;
+    }
+    .compare-section td.name {
+      text-align: left;
+    }
+    .compare-section .regression {
+      color: #d00000;
+    }
+    .compare-section .improvement {
+      color: #008800;
+    }
+    .flamegraph-details {
+      height: 1.2em;
+      padding: 15px 0 35px;
+    }
+  </style>
+</head>
+<body>
+  <div class="header">
+    <div class="title">
+      <h1>pprof</h1>
+    </div>
+    <div>
+      <input id="search" type="text" placeholder="Search regexp" autocomplete="off" autocapitalize="none" size=40>
+    </div>
+    <div class="description">
+      <a href="#" id="details">{{.Title}}</a>
+      <div id="detailsbox">
+        {{range .Legend}}<div>{{.}}</div>{{end}}
+      </div>
+    </div>
+  </div>
+  <div id="bodycontainer">
+    <div class="compare-section">
+      <h2>Summary</h2>
+      <table>
+        <tr><th></th><th>Old</th><th>New</th><th>Delta</th><th></th></tr>
+        {{with .Totals}}
+        <tr class="{{if .Regression}}regression{{else}}improvement{{end}}">
+          <td class="name">{{.Name}}</td><td>{{.OldCum}}</td><td>{{.NewCum}}</td><td>{{.DeltaCum}}</td><td>{{.Relative}}</td>
+        </tr>
+        {{end}}
+      </table>
+      <h2>Functions that changed the most</h2>
+      <table>
+        <tr>
+          <th>Old flat</th><th>New flat</th><th>Delta flat</th>
+          <th>Old cum</th><th>New cum</th><th>Delta cum</th><th>Name</th>
+        </tr>
+        {{range .Functions}}
+        <tr class="{{if .Regression}}regression{{else}}improvement{{end}}">
+          <td>{{.OldFlat}}</td><td>{{.NewFlat}}</td><td>{{.DeltaFlat}}</td>
+          <td>{{.OldCum}}</td><td>{{.NewCum}}</td><td>{{.DeltaCum}}</td>
+          <td class="name">{{.Name}}</td>
+        </tr>
+        {{end}}
+      </table>
+      <h2>Differential flame graph</h2>
+      <div id="flamegraphdetails" class="flamegraph-details"></div>
+      <div id="chart"></div>
+      {{if .Sources}}
+      <h2>Source of the functions that changed the most</h2>
+      {{range .Sources}}
+      <h3>{{.Name}}</h3>
+      <pre>{{.Text}}</pre>
+      {{end}}
+      {{end}}
+    </div>
+  </div>
+  <script>{{template "d3script" .}}</script>
+  <script>{{template "d3flamegraphscript" .}}</script>
+  <script>{{template "flamegraphrendererscript" .}}</script>
+  <script>{{template "flamegraphpagescript" .}}</script>
+  <script>
+    document.getElementById('details').addEventListener('click', function(e) {
+      var box = document.getElementById('detailsbox');
+      box.style.display = box.style.display === 'block' ? 'none' : 'block';
+      e.preventDefault();
+    });
   </script>
 </body>
 </html>
@@ -0,0 +1,254 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveRetryDelay is how long the live mode waits before fetching the
+// profile again after a failed fetch, as the service may be restarting.
+var liveRetryDelay = 5 * time.Second
+
+// liveMessage is a message pushed to the browsers in live mode.
+type liveMessage struct {
+	Generation int    `json:"generation,omitempty"` // Number of the profiles fetched so far.
+	Page       string `json:"page,omitempty"`       // The view of the new profile, if it can replace the page in place.
+	Error      string `json:"error,omitempty"`      // Why the last fetch failed.
+}
+
+// live serves the live mode of the views of profiles collected over a
+// duration as server-sent events: it follows the live feed of the tab of
+// req, and pushes the view named by the page parameter rendered from each
+// new profile, so that the browser replaces the page in place, keeping its
+// zoom and selection.
+func (ui *webInterface) live(w http.ResponseWriter, req *http.Request) {
+	tab := ui.tab(req)
+	if tab.refetch == nil {
+		http.Error(w, "the profile is not collected over a duration from a URL", http.StatusBadRequest)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "live updates are only served to the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "the server cannot stream live updates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	// The browser leaves the feed once it closes the connection, which
+	// abandons the profile being fetched if it was the last one.
+	updates, leave := ui.follow(tab, false)
+	defer leave()
+
+	query := req.URL.Query()
+	page := query.Get("page")
+	query.Del("page")
+	for {
+		var u liveUpdate
+		select {
+		case <-req.Context().Done():
+			return
+		case u = <-updates:
+		}
+		msg := liveMessage{Generation: u.generation, Page: ui.livePage(req, page, query)}
+		if u.err != nil {
+			msg = liveMessage{Error: u.err.Error()}
+		}
+		// The JSON encoding escapes the newlines, which end the data
+		// lines of the events.
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// liveFeed fetches the profile of a tab again and again while browsers
+// follow it in live mode, and sends each outcome to all of them, as the
+// profiles collected over a duration, such as CPU profiles, cannot be
+// collected concurrently. A tab shares its feed with the tabs replacing
+// it.
+type liveFeed struct {
+	mu        sync.Mutex
+	followers map[*liveFollower]bool
+	stop      context.CancelFunc // Stops the fetches, or nil if they are stopped.
+	stopped   chan struct{}      // Closed once the last fetches stopped.
+}
+
+// liveFollower is a browser following a live feed, or waiting for its
+// next update only, once.
+type liveFollower struct {
+	updates chan liveUpdate // Holds the latest update not received yet.
+	once    bool
+}
+
+// liveUpdate is the outcome of a fetch of a live feed.
+type liveUpdate struct {
+	generation int // Number of the profiles fetched so far.
+	err        error
+}
+
+// follow returns the updates of the live feed of tab, starting its
+// fetches if needed, and the function leaving it, which stops them once
+// the last browser left. If once is set, the browser leaves the feed
+// after its next update.
+func (ui *webInterface) follow(tab *profileTab, once bool) (<-chan liveUpdate, func()) {
+	f := tab.live
+	c := &liveFollower{updates: make(chan liveUpdate, 1), once: once}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.followers == nil {
+		f.followers = make(map[*liveFollower]bool)
+	}
+	f.followers[c] = true
+	if f.stop == nil {
+		// The fetches start once the previous ones, abandoned, stopped.
+		ctx, cancel := context.WithCancel(context.Background())
+		previous, stopped := f.stopped, make(chan struct{})
+		f.stop, f.stopped = cancel, stopped
+		go func() {
+			defer close(stopped)
+			if previous != nil {
+				<-previous
+			}
+			ui.runLiveFeed(ctx, f, tab)
+		}()
+	}
+	return c.updates, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.followers, c)
+		if len(f.followers) == 0 && f.stop != nil {
+			f.stop()
+			f.stop = nil
+		}
+	}
+}
+
+// runLiveFeed fetches the profile of tab again and again, sending each
+// outcome to the followers of f, until ctx is done or no browser follows
+// the feed anymore.
+func (ui *webInterface) runLiveFeed(ctx context.Context, f *liveFeed, tab *profileTab) {
+	for generation := 1; ctx.Err() == nil; {
+		p, err := tab.refetch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			ui.options.UI.PrintErr(err)
+			f.send(liveUpdate{err: err})
+			if ctx.Err() != nil || f.idle() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(liveRetryDelay):
+			}
+			continue
+		}
+		tab = ui.replaceTab(tab, p)
+		f.send(liveUpdate{generation: generation})
+		generation++
+		if ctx.Err() != nil || f.idle() {
+			return
+		}
+	}
+}
+
+// idle stops the fetches of f and reports whether it has no followers
+// left, as when the browsers waiting for a single update got it.
+func (f *liveFeed) idle() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.followers) != 0 {
+		return false
+	}
+	f.stop()
+	f.stop = nil
+	return true
+}
+
+// send sends u to the followers of f without waiting for them: the
+// followers still pushing a previous update to a slow browser skip it for
+// u, so that they never hold up the others.
+func (f *liveFeed) send(u liveUpdate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.followers {
+		select {
+		case <-c.updates: // Superseded by u.
+		default:
+		}
+		c.updates <- u // Only send fills the buffer, which is now empty.
+		if c.once {
+			delete(f.followers, c)
+		}
+	}
+}
+
+// livePage returns the view of the web interface at the page link,
+// rendered for the query, or "" if it cannot replace the page of the
+// browser in place, which then reloads it.
+func (ui *webInterface) livePage(req *http.Request, page string, query url.Values) string {
+	for _, v := range staticViews {
+		if v.link != page {
+			continue
+		}
+		r := req.Clone(req.Context())
+		r.Method = http.MethodGet
+		r.URL.Path = strings.TrimPrefix(v.link, ".")
+		r.URL.RawQuery = query.Encode()
+		w := &pageWriter{header: make(http.Header)}
+		v.handler(ui, w, r)
+		if w.code != http.StatusOK {
+			return ""
+		}
+		return w.body.String()
+	}
+	return ""
+}
+
+// sameOrigin reports whether req comes from a page of the web interface
+// rather than of another site, as browsers let any page POST forms to
+// other sites and open their event streams.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true // Not sent by a browser.
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestLive(t *testing.T) {
+	defer func(d time.Duration) { liveRetryDelay = d }(liveRetryDelay)
+	liveRetryDelay = 0
+
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t, AllowRx: "service restarting"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(ui.live))
+	defer server.Close()
+
+	if resp, err := http.Get(server.URL + "/live?page=./top"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("live updates of a profile without a duration: got response %v, error %v, want status %d", resp, err, http.StatusBadRequest)
+	}
+
+	// Each fetch returns the next outcome given by the test, as the
+	// updates not received yet are superseded by later ones.
+	outcomes := make(chan error)
+	var fetches int32
+	ui.tabs[0].refetch = func(ctx context.Context) (*profile.Profile, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-outcomes:
+			n := atomic.AddInt32(&fetches, 1)
+			if err != nil {
+				return nil, err
+			}
+			p := makeFakeProfile()
+			p.Scale(float64(n + 1))
+			return p, nil
+		}
+	}
+
+	events := dialLive(t, server, "./top")
+	for _, want := range []struct {
+		outcome error
+		msg     liveMessage
+	}{
+		{nil, liveMessage{Generation: 1, Page: `id="toptable"`}},
+		{errors.New("service restarting"), liveMessage{Error: "service restarting"}},
+		{nil, liveMessage{Generation: 2, Page: `id="toptable"`}},
+	} {
+		outcomes <- want.outcome
+		var msg liveMessage
+		readLiveMessage(t, events, &msg)
+		if msg.Generation != want.msg.Generation || msg.Error != want.msg.Error || !strings.Contains(msg.Page, want.msg.Page) {
+			t.Errorf("got message %d %q %.40q, want %d %q with %q", msg.Generation, msg.Error, msg.Page, want.msg.Generation, want.msg.Error, want.msg.Page)
+		}
+	}
+	if got := ui.currentProfile().Sample[0].Value[0]; got != 400 {
+		t.Errorf("got sample value %d after the live updates, want the value of the last profile, 400", got)
+	}
+	events.Close()
+
+	// Views that cannot be pushed are reloaded.
+	events = dialLive(t, server, "./timeline")
+	outcomes <- nil
+	var msg liveMessage
+	readLiveMessage(t, events, &msg)
+	if msg.Generation == 0 || msg.Page != "" {
+		t.Errorf("got message %+v for the timeline, want a generation without page", msg)
+	}
+	events.Close()
+
+	// Other sites cannot follow the profile.
+	req, _ := http.NewRequest("GET", server.URL+"/live", nil)
+	req.Header.Set("Origin", "http://example.com")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("live updates for another site: got response %v, error %v, want status %d", resp, err, http.StatusForbidden)
+	}
+}
+
+func TestLiveShared(t *testing.T) {
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(ui.live))
+	defer server.Close()
+
+	// The browsers following the tab share its fetches, as CPU profiles
+	// cannot be collected concurrently.
+	var fetching, overlaps int32
+	ui.tabs[0].refetch = func(context.Context) (*profile.Profile, error) {
+		if atomic.AddInt32(&fetching, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.AddInt32(&fetching, -1)
+		time.Sleep(10 * time.Millisecond)
+		return makeFakeProfile(), nil
+	}
+	// A browser stalling holds up neither the fetches nor the others.
+	_, leave := ui.follow(ui.tabs[0], false)
+	defer leave()
+	streams := []*liveEvents{dialLive(t, server, "./top"), dialLive(t, server, "./top")}
+	for _, events := range streams {
+		last := 0
+		for i := 0; i < 3; i++ {
+			var msg liveMessage
+			readLiveMessage(t, events, &msg)
+			if msg.Generation <= last || !strings.Contains(msg.Page, `id="toptable"`) {
+				t.Errorf("got message %d %q %.40q after generation %d, want a later generation with the top view", msg.Generation, msg.Error, msg.Page, last)
+			}
+			last = msg.Generation
+		}
+	}
+	for _, events := range streams {
+		events.Close()
+	}
+	if n := atomic.LoadInt32(&overlaps); n != 0 {
+		t.Errorf("got %d fetches overlapping others, want the fetches shared by the browsers", n)
+	}
+}
+
+// liveEvents is the stream of the live updates of a view.
+type liveEvents struct {
+	io.Closer
+	r *bufio.Reader
+}
+
+// dialLive opens the stream of the live updates of the view page served
+// by server.
+func dialLive(t *testing.T, server *httptest.Server, page string) *liveEvents {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/live?page=" + page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("got response %d with content type %q, want an event stream", resp.StatusCode, ct)
+	}
+	return &liveEvents{resp.Body, bufio.NewReader(resp.Body)}
+}
+
+// readLiveMessage reads the next event of events into msg.
+func readLiveMessage(t *testing.T, events *liveEvents, msg *liveMessage) {
+	t.Helper()
+	var data string
+	for {
+		line, err := events.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && data != "" {
+			break
+		}
+		data += strings.TrimPrefix(line, "data: ")
+	}
+	if err := json.Unmarshal([]byte(data), msg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// selfSignedValidity is how long the certificates of -http_self_signed are
+// valid for, as they are generated again on each run.
+const selfSignedValidity = 30 * 24 * time.Hour
+
+// webTLSConfig returns the TLS config serving the web interface with the
+// certificate of src.TLSCert and src.TLSKey, or with a self-signed one if
+// src.TLSSelfSigned is set, or nil to serve it over plain HTTP.
+func webTLSConfig(src *source, ui plugin.UI) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case src.TLSCert != "":
+		if cert, err = tls.LoadX509KeyPair(src.TLSCert, src.TLSKey); err != nil {
+			return nil, fmt.Errorf("loading -http_cert: %v", err)
+		}
+	case src.TLSSelfSigned:
+		host, _, _ := net.SplitHostPort(src.HTTPHostport)
+		if cert, err = generateSelfSignedCert(host, time.Now()); err != nil {
+			return nil, fmt.Errorf("generating a self-signed certificate: %v", err)
+		}
+		// Browsers warn about the certificate, which users can check
+		// against the fingerprint before accepting it.
+		ui.PrintErr("Serving a self-signed certificate with SHA-256 fingerprint ", certFingerprint(cert.Certificate[0]))
+	default:
+		return nil, nil
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// generateSelfSignedCert returns a certificate valid from now for
+// localhost, the name of the machine and host, the host of the -http flag
+// if any.
+func generateSelfSignedCert(host string, now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"pprof"}, CommonName: "pprof web UI"},
+		// Allow for clocks running a little behind.
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	names := []string{host}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	for _, name := range names {
+		if name == "" || name == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the DER certificate
+// der, as colon-separated hex bytes like browsers show it.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
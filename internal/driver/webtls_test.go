@@ -0,0 +1,98 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestWebTLSConfig(t *testing.T) {
+	ui := &proftest.TestUI{T: t, AllowRx: "self-signed certificate with SHA-256 fingerprint"}
+	if cfg, err := webTLSConfig(&source{HTTPHostport: "localhost:8080"}, ui); err != nil || cfg != nil {
+		t.Errorf("got config %v, error %v without TLS flags, want none", cfg != nil, err)
+	}
+
+	cfg, err := webTLSConfig(&source{HTTPHostport: "lab.example.com:8080", TLSSelfSigned: true}, ui)
+	if err != nil {
+		t.Fatalf("webTLSConfig: %v", err)
+	}
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "lab.example.com"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("self-signed certificate: %v", err)
+		}
+	}
+
+	// The certificate of -http_cert and -http_key is served as is.
+	dir, err := ioutil.TempDir("", "webtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalECPrivateKey(cfg.Certificates[0].PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := webTLSConfig(&source{HTTPHostport: "localhost:8080", TLSCert: certFile, TLSKey: keyFile}, ui)
+	if err != nil {
+		t.Fatalf("webTLSConfig: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pprof"))
+	}))
+	server.TLS = loaded
+	server.StartTLS()
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("fetching over HTTPS: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "pprof" {
+		t.Errorf("got body %q, want pprof", body)
+	}
+
+	if _, err := webTLSConfig(&source{HTTPHostport: "localhost:8080", TLSCert: certFile, TLSKey: certFile}, ui); err == nil {
+		t.Error("got no error loading a certificate as its key")
+	}
+}
@@ -12,10 +12,17 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !pprof_lite
+// +build !pprof_lite
+
 package driver
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net"
@@ -23,8 +30,11 @@ import (
 	gourl "net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/pprof/internal/graph"
@@ -35,11 +45,18 @@ import (
 
 // webInterface holds the state needed for serving a browser based interface.
 type webInterface struct {
-	prof         *profile.Profile
 	options      *plugin.Options
 	help         map[string]string
 	templates    *template.Template
 	settingsFile string
+	customViews  []*customView // The views added by the embedder.
+
+	// The tabs of the profiles served, the first of which pushed
+	// profiles replace, and whether tabs can be opened, which the static
+	// web interface cannot.
+	mu       sync.Mutex
+	tabs     []*profileTab
+	openTabs bool
 }
 
 func makeWebInterface(p *profile.Profile, opt *plugin.Options) (*webInterface, error) {
@@ -47,18 +64,96 @@ func makeWebInterface(p *profile.Profile, opt *plugin.Options) (*webInterface, e
 	if err != nil {
 		return nil, err
 	}
+	customViews, err := parseWebViews(opt.WebViews)
+	if err != nil {
+		return nil, err
+	}
 	templates := template.New("templategroup")
 	addTemplates(templates)
 	report.AddSourceTemplates(templates)
+	help := make(map[string]string)
+	for n, c := range pprofCommands {
+		help[n] = c.description
+	}
+	for n, h := range configHelp {
+		help[n] = h
+	}
+	help["details"] = "Show information about the profile and this view"
+	help["graph"] = "Display profile as a directed graph"
+	help["sandwich"] = "Display the callers and callees of the selected functions as flame graphs"
+	help["reset"] = "Show the entire profile"
+	help["percent_total"] = "Show percentages of the whole profile, before the filters"
+	help["percent_focused"] = "Show percentages of the samples left by the filters"
+	help["percent_selection"] = "Show percentages of the cum value of the selected nodes, in all the views"
+	help["save_config"] = "Save current settings"
+	help["view_link"] = "Link to this view with all its options in a short token, to share exactly what it shows"
+	help["preferences"] = "Set the view preferences kept across the sessions of the web UI: the orientation of flame graphs, the theme, the view opened first and the sample types shown"
+	help["color"] = "Color the frames of the flame graph by the hash of their names, by package, by binary or library, by language or runtime, or by the value of a label"
+	help["orientation"] = "Switch between flame graphs drawn as icicles, the root at the top, and as flames, the root at the bottom, kept in the preferences"
+	help["theme"] = "Switch between the light and dark themes, by default that of the system"
+	help["open"] = "Open a profile file in a new tab, as does dropping profile files on the page"
+	help["compare"] = "Compare two profiles of the tabs or uploaded files in a new tab, as -diff_base does"
+	help["timeline"] = "Plot the samples over time, to select a time window of all the views"
+	help["treemap"] = "Display the profile as nested rectangles of areas proportional to their values, by callers or by packages"
+	help["heatmap"] = "Compare the top functions over the profiles of a series, to spot which function regressed and when"
+	help["export_svg"] = "Download the visualization of the view as rendered, with its filters and zoom, as an SVG file"
+	help["export_png"] = "Download the visualization of the view as rendered, with its filters and zoom, as a PNG image"
+	help["labels"] = "Show the values of the labels of the samples of this view, to focus on or ignore them"
+	help["live"] = "Fetch the profile again and update this view after each fetch, keeping its refinements, zoom and selection"
 	return &webInterface{
-		prof:         p,
 		options:      opt,
-		help:         make(map[string]string),
+		help:         help,
 		templates:    templates,
 		settingsFile: settingsFile,
+		customViews:  customViews,
+		tabs:         []*profileTab{newProfileTab("", p)},
 	}, nil
 }
 
+// currentProfile returns the profile of the first tab of the web
+// interface, which must not be modified.
+func (ui *webInterface) currentProfile() *profile.Profile {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.tabs[0].prof
+}
+
+// tab returns the tab selected by the tab parameter of req, or the first
+// tab if it selects none.
+func (ui *webInterface) tab(req *http.Request) *profileTab {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	i, err := strconv.Atoi(req.URL.Query().Get("tab"))
+	if err != nil || i < 0 || i >= len(ui.tabs) {
+		i = 0
+	}
+	return ui.tabs[i]
+}
+
+// tabEntries returns the entries of the tab menu, the tab selected by
+// req being current.
+func (ui *webInterface) tabEntries(req *http.Request) []tabEntry {
+	current := ui.tab(req)
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	var entries []tabEntry
+	for i, t := range ui.tabs {
+		entries = append(entries, tabEntry{Name: t.name, Index: i, Current: t == current})
+	}
+	return entries
+}
+
+// refineURL returns the link to the current view of query with its param
+// set to value, keeping its other parameters.
+func refineURL(query gourl.Values, param, value string) string {
+	q := gourl.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set(param, value)
+	return "?" + q.Encode()
+}
+
 // maxEntries is the maximum number of entries to print for text interfaces.
 const maxEntries = 50
 
@@ -86,56 +181,196 @@ type webArgs struct {
 	TextBody    string
 	Top         []report.TextItem
 	FlameGraph  template.JS
+	Treemap     template.JS
 	Configs     []configMenuEntry
+	Processes   []processEntry
+	AllProcs    string // Link to the current view of all processes.
+	Phases      []phaseEntry
+	AllPhases   string // Link to the current view of all phases.
+	Traced      bool
+	Timed       bool
+	Timeline    *report.Timeline
+	Series      bool
+	Heatmap     *report.LabelHeatmap
+	Tags        []tagGroupEntry
+	Traces      []traceEntry
+	TraceNav    *traceNav
+	Tabs        []tabEntry
+	Live        bool
+	PercentBase string // What the percentages are relative to, as by percentBase.
+	Granularity string // Granularity of the nodes of the view.
+	ViewToken   string // Options of the view, as by config.viewToken.
+
+	// TreemapByPackage is set for treemaps nesting functions in their
+	// packages, rather than in their callers.
+	TreemapByPackage bool
+
+	Preferences preferences // View preferences of the user.
+
+	CustomViews []customViewEntry // The views added by the embedder.
+
+	// FlameGraphColor is the color mode of the flame graph, as by
+	// frameGroups, and LabelKeys the keys of the labels it can be colored
+	// by.
+	FlameGraphColor string
+	LabelKeys       []string
+}
+
+// tabEntry holds a tab of the tab menu.
+type tabEntry struct {
+	Name    string
+	Index   int
+	Current bool
+}
+
+// labelValue is a value of a label in the web UI, with the URL it links
+// to, if any.
+type labelValue struct {
+	Value string
+	URL   string
+}
+
+// tagGroupEntry holds the values of a tag for the tags view.
+type tagGroupEntry struct {
+	Key         string
+	TotalFormat string
+	Values      []tagEntry
+}
+
+// tagEntry holds a value of a tag for the tags view.
+type tagEntry struct {
+	labelValue
+	FlatFormat string
+	Percent    string
 }
 
-func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options, disableBrowser bool) error {
+// traceEntry holds a sample for the traces view.
+type traceEntry struct {
+	Labels    []labelEntry
+	NumLabels []report.TraceLabel
+	Value     string
+	Frames    []traceFrame
+}
+
+// labelEntry holds the values of a label of a sample for the traces view.
+type labelEntry struct {
+	Key    string
+	Values []traceLabelValue
+}
+
+// traceLabelValue is a value of a label of a sample for the traces view,
+// with the URL of the view focused on the samples with that value.
+type traceLabelValue struct {
+	labelValue
+	FocusURL string
+}
+
+// traceFrame is a frame of a sample for the traces view, with the URL of
+// the view focused on its function.
+type traceFrame struct {
+	report.TraceFrame
+	FocusURL string
+}
+
+// traceNav holds the page of the traces view shown, with the URLs of the
+// other pages and of the other sort order.
+type traceNav struct {
+	Page, Pages        int
+	First, Last, Total int // Samples shown, from 1, out of Total.
+	ByWeight           bool
+	PrevURL, NextURL   string
+	SortURL            string
+}
+
+// tracesPerPage is the number of samples of a page of the traces view.
+const tracesPerPage = 100
+
+func serveWebInterface(hostport string, tabs []*profileTab, o *plugin.Options, disableBrowser bool, tlsConfig *tls.Config) error {
 	host, port, err := getHostAndPort(hostport)
 	if err != nil {
 		return err
 	}
 	interactiveMode = true
-	ui, err := makeWebInterface(p, o)
+	ui, err := makeWebInterface(tabs[0].prof, o)
 	if err != nil {
 		return err
 	}
-	for n, c := range pprofCommands {
-		ui.help[n] = c.description
-	}
-	for n, help := range configHelp {
-		ui.help[n] = help
-	}
-	ui.help["details"] = "Show information about the profile and this view"
-	ui.help["graph"] = "Display profile as a directed graph"
-	ui.help["reset"] = "Show the entire profile"
-	ui.help["save_config"] = "Save current settings"
+	ui.tabs = tabs
+	ui.openTabs = true
 
 	server := o.HTTPServer
 	if server == nil {
 		server = defaultWebServer
 	}
 	args := &plugin.HTTPServerArgs{
-		Hostport: net.JoinHostPort(host, strconv.Itoa(port)),
-		Host:     host,
-		Port:     port,
+		Hostport:  net.JoinHostPort(host, strconv.Itoa(port)),
+		Host:      host,
+		Port:      port,
+		TLSConfig: tlsConfig,
 		Handlers: map[string]http.Handler{
-			"/":             http.HandlerFunc(ui.dot),
-			"/top":          http.HandlerFunc(ui.top),
-			"/disasm":       http.HandlerFunc(ui.disasm),
-			"/source":       http.HandlerFunc(ui.source),
-			"/peek":         http.HandlerFunc(ui.peek),
-			"/flamegraph":   http.HandlerFunc(ui.flamegraph),
-			"/saveconfig":   http.HandlerFunc(ui.saveConfig),
-			"/deleteconfig": http.HandlerFunc(ui.deleteConfig),
+			"/":                  http.HandlerFunc(ui.dot),
+			"/top":               http.HandlerFunc(ui.top),
+			"/disasm":            http.HandlerFunc(ui.disasm),
+			"/source":            http.HandlerFunc(ui.source),
+			"/peek":              http.HandlerFunc(ui.peek),
+			"/sandwich":          http.HandlerFunc(ui.sandwich),
+			"/tags":              http.HandlerFunc(ui.tags),
+			"/traces":            http.HandlerFunc(ui.traces),
+			"/phases":            http.HandlerFunc(ui.phases),
+			"/timeline":          http.HandlerFunc(ui.timeline),
+			"/heatmap":           http.HandlerFunc(ui.heatmap),
+			"/flamegraph":        http.HandlerFunc(ui.flamegraph),
+			"/treemap":           http.HandlerFunc(ui.treemap),
+			"/flamegraph.js":     http.HandlerFunc(flamegraphScript),
+			"/flamegraph.css":    http.HandlerFunc(flamegraphStyle),
+			"/api/flamegraph":    http.HandlerFunc(ui.flamegraphJSON),
+			"/api/callhierarchy": http.HandlerFunc(ui.callHierarchy),
+			"/api/labels":        http.HandlerFunc(ui.labelsJSON),
+			"/api/v1/top":        http.HandlerFunc(ui.apiTop),
+			"/api/v1/flamegraph": http.HandlerFunc(ui.apiFlameGraph),
+			"/api/v1/tags":       http.HandlerFunc(ui.apiTags),
+			"/api/v1/source":     http.HandlerFunc(ui.apiSource),
+			"/saveconfig":        http.HandlerFunc(ui.saveConfig),
+			"/deleteconfig":      http.HandlerFunc(ui.deleteConfig),
+			"/preferences":       http.HandlerFunc(ui.savePreferences),
+			"/ingest":            http.HandlerFunc(ui.ingest),
+			"/open":              http.HandlerFunc(ui.open),
+			"/compare":           http.HandlerFunc(ui.compare),
+			"/refresh":           http.HandlerFunc(ui.refresh),
+			"/live":              http.HandlerFunc(ui.live),
 			"/download": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 				w.Header().Set("Content-Type", "application/vnd.google.protobuf+gzip")
 				w.Header().Set("Content-Disposition", "attachment;filename=profile.pb.gz")
-				p.Write(w)
+				ui.tab(req).prof.Write(w)
 			}),
 		},
 	}
+	for _, v := range ui.customViews {
+		path := "/" + v.Name
+		if args.Handlers[path] != nil {
+			return fmt.Errorf("web view %q conflicts with a view of pprof", v.Name)
+		}
+		v := v
+		args.Handlers[path] = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ui.customView(v, w, req)
+		})
+	}
+
+	if o.Telemetry != nil {
+		for path, h := range args.Handlers {
+			args.Handlers[path] = telemetryHandler(path, h, o.Telemetry)
+		}
+	}
+	if o.HTTPMiddleware != nil {
+		for path, h := range args.Handlers {
+			args.Handlers[path] = o.HTTPMiddleware(h)
+		}
+	}
 
 	url := "http://" + args.Hostport
+	if tlsConfig != nil {
+		url = "https://" + args.Hostport
+	}
 
 	o.UI.Print("Serving web UI on ", url)
 
@@ -177,8 +412,29 @@ func defaultWebServer(args *plugin.HTTPServerArgs) error {
 	if err != nil {
 		return err
 	}
+	if args.TLSConfig != nil {
+		ln = tls.NewListener(ln, args.TLSConfig)
+	}
+	handler := localHandlers(args)
+
+	// We serve the ui at /ui/ and redirect there from the root. This is done
+	// to surface any problems with serving the ui at a non-root early. See:
+	//
+	// https://github.com/google/pprof/pull/348
+	mux := http.NewServeMux()
+	mux.Handle("/ui/", http.StripPrefix("/ui", handler))
+	// Profiling agents push their profiles to /ingest of their server.
+	mux.Handle("/ingest", handler)
+	mux.Handle("/", redirectWithQuery("/ui"))
+	s := &http.Server{Handler: mux}
+	return s.Serve(ln)
+}
+
+// localHandlers returns a handler dispatching requests to the handlers
+// of args by path, which only serves local clients if args.Host is local.
+func localHandlers(args *plugin.HTTPServerArgs) http.Handler {
 	isLocal := isLocalhost(args.Host)
-	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if isLocal {
 			// Only allow local clients
 			host, _, err := net.SplitHostPort(req.RemoteAddr)
@@ -194,16 +450,6 @@ func defaultWebServer(args *plugin.HTTPServerArgs) error {
 		}
 		h.ServeHTTP(w, req)
 	})
-
-	// We serve the ui at /ui/ and redirect there from the root. This is done
-	// to surface any problems with serving the ui at a non-root early. See:
-	//
-	// https://github.com/google/pprof/pull/348
-	mux := http.NewServeMux()
-	mux.Handle("/ui/", http.StripPrefix("/ui", handler))
-	mux.Handle("/", redirectWithQuery("/ui"))
-	s := &http.Server{Handler: mux}
-	return s.Serve(ln)
 }
 
 func redirectWithQuery(path string) http.HandlerFunc {
@@ -250,25 +496,36 @@ func openBrowser(url string, o *plugin.Options) {
 // If configEditor is not null, it is used to edit the config used for the report.
 func (ui *webInterface) makeReport(w http.ResponseWriter, req *http.Request,
 	cmd []string, configEditor func(*config)) (*report.Report, []string) {
-	cfg := currentConfig()
-	if err := cfg.applyURL(req.URL.Query()); err != nil {
+	rpt, errList, err := ui.buildReport(req, cmd, configEditor)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		ui.options.UI.PrintErr(err)
 		return nil, nil
 	}
+	return rpt, errList
+}
+
+// buildReport returns the report cmd of the view of req, as makeReport,
+// with the errors printed while generating it, or the error failing it.
+func (ui *webInterface) buildReport(req *http.Request, cmd []string, configEditor func(*config)) (*report.Report, []string, error) {
+	cfg := currentConfig()
+	if err := cfg.applyURL(req.URL.Query()); err != nil {
+		return nil, nil, err
+	}
+	if cfg.SampleIndex == "" {
+		cfg.SampleIndex = preferredSampleIndex(ui.tab(req).prof, ui.preferences().SampleIndex)
+	}
 	if configEditor != nil {
 		configEditor(&cfg)
 	}
 	catcher := &errorCatcher{UI: ui.options.UI}
 	options := *ui.options
 	options.UI = catcher
-	_, rpt, err := generateRawReport(ui.prof, cmd, cfg, &options)
+	_, rpt, err := generateRawReport(req.Context(), ui.tab(req).prof, cmd, cfg, &options)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		ui.options.UI.PrintErr(err)
-		return nil, nil
+		return nil, nil, err
 	}
-	return rpt, catcher.errors
+	return rpt, catcher.errors, nil
 }
 
 // render generates html using the named template based on the contents of data.
@@ -279,10 +536,36 @@ func (ui *webInterface) render(w http.ResponseWriter, req *http.Request, tmpl st
 	data.Title = file + " " + profile
 	data.Errors = errList
 	data.Total = rpt.Total()
-	data.SampleTypes = sampleTypes(ui.prof)
 	data.Legend = legend
 	data.Help = ui.help
 	data.Configs = configMenu(ui.settingsFile, *req.URL)
+	data.Preferences = ui.preferences()
+	data.CustomViews = ui.customViewEntries()
+	tab := ui.tab(req)
+	data.SampleTypes = sampleTypes(tab.prof)
+	query := req.URL.Query()
+	for _, e := range tab.processes {
+		e.URL = refineURL(query, "f", e.Focus)
+		data.Processes = append(data.Processes, e)
+	}
+	data.AllProcs = refineURL(query, "f", "")
+	for _, e := range tab.phases {
+		e.URL = refineURL(query, "tf", e.TagFocus)
+		data.Phases = append(data.Phases, e)
+	}
+	data.AllPhases = refineURL(query, "tf", "")
+	data.Traced = tab.traced
+	data.Timed = tab.timed
+	data.Series = tab.series
+	data.Live = tab.refetch != nil
+	if cfg := currentConfig(); cfg.applyURL(req.URL.Query()) == nil {
+		data.PercentBase, _ = percentBase(cfg)
+		data.Granularity = cfg.Granularity
+		data.ViewToken = cfg.viewToken()
+	}
+	if ui.openTabs {
+		data.Tabs = ui.tabEntries(req)
+	}
 
 	html := &bytes.Buffer{}
 	if err := ui.templates.ExecuteTemplate(html, tmpl, data); err != nil {
@@ -296,20 +579,32 @@ func (ui *webInterface) render(w http.ResponseWriter, req *http.Request, tmpl st
 
 // dot generates a web page containing an svg diagram.
 func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
+	if view := ui.defaultView(req); view != "" {
+		http.Redirect(w, req, "./"+view, http.StatusFound)
+		return
+	}
 	rpt, errList := ui.makeReport(w, req, []string{"svg"}, nil)
 	if rpt == nil {
 		return // error already reported
 	}
 
-	// Generate dot graph.
-	g, config := report.GetDOT(rpt)
-	legend := config.Labels
-	config.Labels = nil
-	dot := &bytes.Buffer{}
-	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
-
-	// Convert to svg.
-	svg, err := dotToSvg(dot.Bytes())
+	g, legend, svg, err := graphSVG(req.Context(), rpt)
+	// Retry with fewer nodes, instead of failing on a graph too large for dot.
+	for nodeCount := simplerNodeCount(len(g.Nodes)); errors.Is(err, errRenderGraph) && nodeCount > 0; nodeCount = simplerNodeCount(nodeCount) {
+		rpt, errList = ui.makeReport(w, req, []string{"svg"}, func(cfg *config) {
+			cfg.NodeCount = nodeCount
+		})
+		if rpt == nil {
+			return // error already reported
+		}
+		errList = append(errList, fmt.Sprintf("Graph simplified automatically to %d nodes, as rendering the full graph failed (%v). Set nodecount to see more.", nodeCount, err))
+		g, legend, svg, err = graphSVG(req.Context(), rpt)
+	}
+	if errors.Is(err, errRenderGraph) {
+		http.Error(w, "Could not render the graph: "+err.Error(), http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Could not execute dot; may need to install graphviz.",
 			http.StatusNotImplemented)
@@ -329,16 +624,29 @@ func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func dotToSvg(dot []byte) ([]byte, error) {
-	cmd := exec.Command("dot", "-Tsvg")
-	out := &bytes.Buffer{}
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewBuffer(dot), out, os.Stderr
-	if err := cmd.Run(); err != nil {
+// graphSVG returns the graph of rpt, the legend of the graph and its
+// rendering in SVG, without the legend, giving up once ctx is done.
+func graphSVG(ctx context.Context, rpt *report.Report) (*graph.Graph, []string, []byte, error) {
+	// Generate dot graph.
+	g, config := report.GetDOT(rpt)
+	legend := config.Labels
+	config.Labels = nil
+	dot := &bytes.Buffer{}
+	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
+
+	// Convert to svg.
+	svg, err := dotToSvg(ctx, dot.Bytes())
+	return g, legend, svg, err
+}
+
+func dotToSvg(ctx context.Context, dot []byte) ([]byte, error) {
+	out, err := runDot(ctx, dot, "svg", defaultDotLimits)
+	if err != nil {
 		return nil, err
 	}
 
 	// Fix dot bug related to unquoted ampersands.
-	svg := bytes.Replace(out.Bytes(), []byte("&;"), []byte("&amp;;"), -1)
+	svg := bytes.Replace(out, []byte("&;"), []byte("&amp;;"), -1)
 
 	// Cleanup for embedding by dropping stuff before the <svg> start.
 	if pos := bytes.Index(svg, []byte("<svg")); pos >= 0 {
@@ -434,6 +742,256 @@ func (ui *webInterface) peek(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// phases generates a web page comparing the execution phases of the
+// samples.
+func (ui *webInterface) phases(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"phases"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, ui.options.Obj); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "plaintext", rpt, errList, legend, webArgs{
+		TextBody: out.String(),
+	})
+}
+
+// timelineBuckets is the number of buckets of the timeline view.
+const timelineBuckets = 200
+
+// timeline generates a web page plotting the value of the samples over
+// time, by their timestamp label, in which a time window is selected.
+func (ui *webInterface) timeline(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"tags"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "timeline", rpt, errList, legend, webArgs{
+		Timeline: report.SampleTimeline(rpt, timelineBuckets),
+	})
+}
+
+// heatmap generates a web page of the flat share of the top functions in
+// each profile of a series loaded with -series.
+func (ui *webInterface) heatmap(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"heatmap"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "heatmap", rpt, errList, legend, webArgs{
+		Heatmap: report.SeriesHeatmap(rpt),
+	})
+}
+
+// callHierarchy serves the callers and callees of the functions matching
+// the f parameter as the JSON of the callhierarchy command, for IDE
+// plugins showing them in their call hierarchy views.
+func (ui *webInterface) callHierarchy(w http.ResponseWriter, req *http.Request) {
+	args := []string{"callhierarchy", req.URL.Query().Get("f")}
+	rpt, _ := ui.makeReport(w, req, args, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, ui.options.Obj); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out.Bytes())
+}
+
+// tags generates a web page listing the values of the tags of the
+// samples, linked as set by the label_links settings.
+func (ui *webInterface) tags(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"tags"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	link := labelLinks(ui.settingsFile)
+	var tags []tagGroupEntry
+	for _, g := range report.TagGroups(rpt) {
+		e := tagGroupEntry{Key: g.Key, TotalFormat: g.TotalFormat}
+		for _, v := range g.Values {
+			e.Values = append(e.Values, tagEntry{
+				labelValue: labelValue{v.Value, link(g.Key, v.Value)},
+				FlatFormat: v.FlatFormat,
+				Percent:    v.Percent,
+			})
+		}
+		tags = append(tags, e)
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "tags", rpt, errList, legend, webArgs{
+		Tags: tags,
+	})
+}
+
+// labelsJSON serves the values of the labels of the samples of the view
+// given by the URL parameters, with their totals, for the label panel.
+func (ui *webInterface) labelsJSON(w http.ResponseWriter, req *http.Request) {
+	rpt, _ := ui.makeReport(w, req, []string{"tags"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+	b, err := json.Marshal(report.TagGroups(rpt))
+	if err != nil {
+		http.Error(w, "error serializing labels", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// traces generates a web page listing the samples with their labels,
+// linked as set by the label_links settings.
+func (ui *webInterface) traces(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"traces"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+
+	// viewURL returns the URL of the view with the param set to value,
+	// from its first page unless the param is the page.
+	query := req.URL.Query()
+	viewURL := func(param, value string) string {
+		q := gourl.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if param != "tpage" {
+			q.Del("tpage")
+		}
+		q.Set(param, value)
+		return "?" + q.Encode()
+	}
+
+	items := report.TraceItems(rpt)
+	nav := &traceNav{Total: len(items), ByWeight: query.Get("tsort") == "weight"}
+	if nav.ByWeight {
+		sort.SliceStable(items, func(i, j int) bool {
+			return abs64(items[i].Flat) > abs64(items[j].Flat)
+		})
+		nav.SortURL = viewURL("tsort", "order")
+	} else {
+		nav.SortURL = viewURL("tsort", "weight")
+	}
+	nav.Pages = (len(items) + tracesPerPage - 1) / tracesPerPage
+	nav.Page, _ = strconv.Atoi(query.Get("tpage"))
+	if nav.Page > nav.Pages {
+		nav.Page = nav.Pages
+	}
+	if nav.Page < 1 {
+		nav.Page = 1
+	}
+	start := (nav.Page - 1) * tracesPerPage
+	end := start + tracesPerPage
+	if end > len(items) {
+		end = len(items)
+	}
+	nav.First, nav.Last = start+1, end
+	if nav.Page > 1 {
+		nav.PrevURL = viewURL("tpage", strconv.Itoa(nav.Page-1))
+	}
+	if nav.Page < nav.Pages {
+		nav.NextURL = viewURL("tpage", strconv.Itoa(nav.Page+1))
+	}
+
+	link := labelLinks(ui.settingsFile)
+	var traces []traceEntry
+	for _, item := range items[start:end] {
+		e := traceEntry{NumLabels: item.NumLabels, Value: item.Value}
+		for _, l := range item.Labels {
+			le := labelEntry{Key: l.Key}
+			for _, v := range l.Values {
+				// Commas separate the regexps of tag filters.
+				rx := strings.Replace(regexp.QuoteMeta(v), ",", `\x2c`, -1)
+				le.Values = append(le.Values, traceLabelValue{
+					labelValue: labelValue{v, link(l.Key, v)},
+					FocusURL:   viewURL("tf", l.Key+"=^"+rx+"$"),
+				})
+			}
+			e.Labels = append(e.Labels, le)
+		}
+		for _, f := range item.Frames {
+			name := f.Function
+			if name == "" {
+				name = f.Name
+			}
+			e.Frames = append(e.Frames, traceFrame{f, viewURL("f", "^"+regexp.QuoteMeta(name)+"$")})
+		}
+		traces = append(traces, e)
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, req, "traces", rpt, errList, legend, webArgs{
+		Traces:   traces,
+		TraceNav: nav,
+	})
+}
+
+// refresh waits for the profile of the tab selected by req to be fetched
+// again by its live feed, for the live mode of the views of profiles
+// collected over a duration, so that the refreshes and the live updates
+// of the browsers share the fetches. The views are reloaded by the
+// browser once it returns.
+func (ui *webInterface) refresh(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "refreshes must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "profiles are only refreshed for the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	tab := ui.tab(req)
+	if tab.refetch == nil {
+		http.Error(w, "the profile is not collected over a duration from a URL", http.StatusBadRequest)
+		return
+	}
+	updates, leave := ui.follow(tab, true)
+	defer leave()
+	select {
+	case u := <-updates:
+		if u.err != nil {
+			http.Error(w, u.err.Error(), http.StatusBadGateway)
+		}
+	case <-req.Context().Done():
+	}
+}
+
+// replaceTab replaces the tab t by one serving the profile p, which it
+// returns.
+func (ui *webInterface) replaceTab(t *profileTab, p *profile.Profile) *profileTab {
+	tab := t.with(p)
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	// The tab may have been replaced meanwhile, by a pushed profile.
+	for i, u := range ui.tabs {
+		if u == t {
+			ui.tabs[i] = tab
+		}
+	}
+	return tab
+}
+
 // saveConfig saves URL configuration.
 func (ui *webInterface) saveConfig(w http.ResponseWriter, req *http.Request) {
 	if err := setConfig(ui.settingsFile, *req.URL); err != nil {
@@ -443,6 +1001,65 @@ func (ui *webInterface) saveConfig(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// preferences returns the view preferences of the settings file, or the
+// default ones if it cannot be read.
+func (ui *webInterface) preferences() preferences {
+	if ui.settingsFile == "" {
+		return preferences{}
+	}
+	s, err := readSettings(ui.settingsFile)
+	if err != nil {
+		return preferences{}
+	}
+	return s.Preferences
+}
+
+// defaultView returns the default view of the preferences if req opens
+// the root of the web UI directly, as pprof or a bookmark do, rather than
+// by a link of the web UI, or "" to show the graph.
+func (ui *webInterface) defaultView(req *http.Request) string {
+	if req.URL.Path != "/" || req.URL.RawQuery != "" || req.Referer() != "" {
+		return ""
+	}
+	return ui.preferences().DefaultView
+}
+
+// savePreferences saves the view preferences posted by the preferences
+// dialog and the toggles of the web UI.
+func (ui *webInterface) savePreferences(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "preferences must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "preferences are only saved for the pages of the web interface", http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := setPreferences(ui.settingsFile, req.PostForm); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+}
+
+// preferredSampleIndex returns the first of the sample types of types,
+// separated by commas, that p holds, or "" if it holds none of them.
+func preferredSampleIndex(p *profile.Profile, types string) string {
+	for _, t := range strings.Split(types, ",") {
+		t = strings.TrimSpace(t)
+		for _, st := range p.SampleType {
+			if t != "" && st.Type == t {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
 // deleteConfig deletes a configuration.
 func (ui *webInterface) deleteConfig(w http.ResponseWriter, req *http.Request) {
 	name := req.URL.Query().Get("config")
@@ -12,23 +12,36 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !pprof_lite
+// +build !pprof_lite
+
 package driver
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/internal/report"
 	"github.com/google/pprof/profile"
 )
 
@@ -38,6 +51,9 @@ func TestWebInterface(t *testing.T) {
 	}
 
 	prof := makeFakeProfile()
+	// A second profile, with twice the samples, is served in its own tab.
+	other := makeFakeProfile()
+	other.Scale(2)
 
 	// Custom http server creator
 	var server *httptest.Server
@@ -54,11 +70,12 @@ func TestWebInterface(t *testing.T) {
 	}
 
 	// Start server and wait for it to be initialized
-	go serveWebInterface("unused:1234", prof, &plugin.Options{
+	tabs := []*profileTab{newProfileTab("cpu.pb.gz", prof), newProfileTab("other.pb.gz", other)}
+	go serveWebInterface("unused:1234", tabs, &plugin.Options{
 		Obj:        fakeObjTool{},
 		UI:         &proftest.TestUI{T: t},
 		HTTPServer: creator,
-	}, false)
+	}, false, nil)
 	<-serverCreated
 	defer server.Close()
 
@@ -86,6 +103,38 @@ func TestWebInterface(t *testing.T) {
 		{"/disasm?f=" + url.QueryEscape("F[12]"),
 			[]string{"f1:asm", "f2:asm"}, false},
 		{"/flamegraph", []string{"File: testbin", "\"n\":\"root\"", "\"n\":\"F1\"", "var flamegraph = function", "function hierarchy"}, false},
+		{"/flamegraph?g=files", []string{`"n":"root","f":"root","v":300,"l":"300ms","p":"100%","c":\[\{"n":"testdata/file1000.src","f":"testdata/file1000.src","v":300,[^\[]*\}\]`,
+			`id="granularity-files">\s*<span class="menu-check-mark">✓</span>\s*By file`}, false},
+		{"/flamegraph", []string{`id="export-svg"`, `id="export-png"`, "function initExport", "XMLSerializer"}, false},
+		{"/flamegraph", []string{`id="flamegraphmatches"`, `id="matchfocus"`, `id="matchhide"`, "function pprofMatchedValue", "pprofFlameGraphKeys\\(", "pprofFindFrame\\("}, false},
+		{"/treemap", []string{"File: testbin", `id="treemapbtn"`, "function pprofTreemap", `"n":"root","f":"root","v":300,`, `"n":"F1"`}, false},
+		{"/treemap?nest=package", []string{`"n":"root","f":"root","v":300,"l":"300ms","p":"100%","c":\[\{"n":"F2","f":"F2","v":200,`}, false},
+		{"/api/flamegraph", []string{`^\{"n":"root","f":"root","v":300,"l":"300ms","p":"100%","c":\[`, `"n":"F1"`}, false},
+		{"/api/callhierarchy?f=F2", []string{`"name": "F2"`, `"incomingCalls": \[\s*\{\s*"from": \{\s*"name": "F1"`}, false},
+		{"/sandwich?f=F2", []string{`"callers":\{"n":"F2","f":"F2","v":300,"l":"300ms","p":"100%","c":\[\{"n":"F1","f":"F1","v":300`, `"callees":\{"n":"F2"`}, false},
+		{"/sandwich", []string{"Search for the functions to show their callers and callees."}, false},
+		{"/flamegraph.js", []string{"var flamegraph = function", "function pprofFlameGraph"}, false},
+		{"/flamegraph.css", []string{`\.d3-flame-graph`}, false},
+		{"/tags", []string{"File: testbin", `id="labels-toggle"`, `id="labelpanel"`, "function initLabelPanel"}, false},
+		{"/traces", []string{"prefers-color-scheme: dark", `localStorage.getItem\('pprof-theme'\)`, `:root\[data-theme="dark"\]`, `id="theme-toggle"`}, false},
+		{"/traces", []string{"File: testbin", `<span class="tracevalue">100ms</span>F3</summary>`, `<a href="\?f=%5EF1%24"`, `id="tracesort"`}, false},
+		{"/phases", []string{"No phases found"}, false},
+		{"/top?pb=node&pn=F3", []string{`(?s)<span class="menu-check-mark">✓</span>\s*Percent of selection`, `id="percent-focused"`}, false},
+		{"/top?f=F2&g=lines", []string{`id="view-link"`, `href="\?view=1[A-Za-z0-9_-]+"`}, false},
+		{"/top?view=" + func() string { cfg := defaultConfig(); cfg.Ignore = "F3"; return cfg.viewToken() }(), []string{`"Name":"F2","InlineLabel":"","Flat":200,"Cum":200,`}, false},
+		{"/api/v1/top", []string{`^\{"version":1,"sample_type":"cpu","unit":"milliseconds","total":300,`, `\{"name":"F2","flat":200,"cum":300,"flat_format":"200ms","cum_format":"300ms"\}`}, false},
+		{"/api/v1/top?i=F3", []string{`"total":300,`, `\{"name":"F2","flat":200,"cum":200,`}, false},
+		{"/api/v1/flamegraph?f=F3", []string{`"flamegraph":\{"name":"root","full_name":"root","value":100,"format":"100ms","children":\[\{"name":"F1"`}, false},
+		{"/api/v1/tags", []string{`^\{"version":1,"sample_type":"cpu"`}, false},
+		{"/api/v1/source?f=F2", []string{`"source":\[\{"function":"F2","file":"` + fakeSource + `","flat":200,"cum":300`}, false},
+		{"/api/v1/source", []string{`^\{"error":"missing f parameter`}, false},
+		{"/api/v1/top?n=many", []string{`^\{"error":".*nodecount`}, false},
+		{"/timeline", []string{"no timestamp label"}, false},
+		{"/heatmap", []string{"not labeled with the profile of a series"}, false},
+		{"/top?tab=1", []string{`"Name":"F2","InlineLabel":"","Flat":400,"Cum":600`, `<a href="./\?tab=0" data-tab="0" title="cpu.pb.gz">`,
+			`<a href="./\?tab=1" data-tab="1" title="other.pb.gz" class="current">`, `action="./open"`,
+			`<form class="dialog" id="compare-dialog" action="./compare"`, `<option value="1" selected>other.pb.gz</option>`,
+			`document.addEventListener\('drop', async \(e\) => \{`}, false},
 	}
 	for _, c := range testcases {
 		if c.needDot && !haveDot {
@@ -139,104 +188,580 @@ func TestWebInterface(t *testing.T) {
 	wg.Wait()
 }
 
-// Implement fake object file support.
+func TestLabelLinkViews(t *testing.T) {
+	tmpDir, fname := settingsDirAndFile(t)
+	defer os.RemoveAll(tmpDir)
+	if err := writeSettings(fname, &settings{LabelLinks: map[string]string{
+		"trace_id": "https://jaeger.example.com/trace/{value}",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	prof := makeFakeProfile()
+	prof.Sample[0].Label = map[string][]string{"trace_id": {"4bf92f35"}, "thread": {"main"}}
+	ui, err := makeWebInterface(prof, &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.settingsFile = fname
 
-const addrBase = 0x1000
-const fakeSource = "testdata/file1000.src"
+	for _, tc := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/tags", ui.tags},
+		{"/traces", ui.traces},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest("GET", tc.path, nil))
+		body := w.Body.String()
+		if want := `<a href="https://jaeger.example.com/trace/4bf92f35" target="_blank" rel="noopener noreferrer">4bf92f35</a>`; !strings.Contains(body, want) {
+			t.Errorf("%s: missing link %s in:\n%s", tc.path, want, body)
+		}
+		if strings.Contains(body, `href="https://jaeger.example.com/trace/main"`) || !strings.Contains(body, "main") {
+			t.Errorf("%s: want thread label main without link in:\n%s", tc.path, body)
+		}
+	}
+}
 
-type fakeObj struct{}
+func TestPreferenceViews(t *testing.T) {
+	tmpDir, fname := settingsDirAndFile(t)
+	defer os.RemoveAll(tmpDir)
+	if err := writeSettings(fname, &settings{Preferences: preferences{Orientation: "flame", Theme: "dark", DefaultView: "top"}}); err != nil {
+		t.Fatal(err)
+	}
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.settingsFile = fname
 
-func (f fakeObj) Close() error                        { return nil }
-func (f fakeObj) Name() string                        { return "testbin" }
-func (f fakeObj) ObjAddr(addr uint64) (uint64, error) { return addr, nil }
-func (f fakeObj) BuildID() string                     { return "" }
-func (f fakeObj) SourceLine(addr uint64) ([]plugin.Frame, error) {
-	return nil, fmt.Errorf("SourceLine unimplemented")
+	// The root opens the default view, unless it is opened by a link.
+	w := httptest.NewRecorder()
+	ui.dot(w, httptest.NewRequest("GET", "/", nil))
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "/top" {
+		t.Errorf("got status %d to %q for the root, want a redirect to /top", w.Code, loc)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Referer", "http://localhost/top")
+	if view := ui.defaultView(req); view != "" {
+		t.Errorf("got default view %q for the root opened by a link, want the graph", view)
+	}
+
+	w = httptest.NewRecorder()
+	ui.flamegraph(w, httptest.NewRequest("GET", "/flamegraph", nil))
+	for _, want := range []string{`let theme = "dark";`, `"flame" == 'flame'`, `id="orientation-toggle"`, `id="preferences-dialog"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("missing %s in the flame graph", want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/preferences", strings.NewReader("orientation=icicle"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ui.savePreferences(w, req)
+	if got := ui.preferences(); w.Code != http.StatusOK || got.Orientation != "icicle" || got.Theme != "dark" {
+		t.Errorf("got status %d and preferences %+v after saving the orientation", w.Code, got)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/preferences", strings.NewReader("theme=light"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://attacker.example")
+	ui.savePreferences(w, req)
+	if got := ui.preferences(); w.Code != http.StatusForbidden || got.Theme != "dark" {
+		t.Errorf("got status %d and preferences %+v after saving the theme from another site, want status %d", w.Code, got, http.StatusForbidden)
+	}
 }
-func (f fakeObj) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error) {
-	return []*plugin.Sym{
-		{
-			Name: []string{"F1"}, File: fakeSource,
-			Start: addrBase, End: addrBase + 10,
-		},
-		{
-			Name: []string{"F2"}, File: fakeSource,
-			Start: addrBase + 10, End: addrBase + 20,
-		},
-		{
-			Name: []string{"F3"}, File: fakeSource,
-			Start: addrBase + 20, End: addrBase + 30,
+
+func TestCustomWebViews(t *testing.T) {
+	cost := plugin.WebView{
+		Name:     "cost",
+		Title:    "Cost",
+		Help:     "Cost of the profile",
+		Template: `<p id="cost">{{.}} dollars</p>`,
+		Data: func(p *profile.Profile, req *http.Request) (interface{}, error) {
+			var v int64
+			for _, s := range p.Sample {
+				v += s.Value[0]
+			}
+			return v, nil
 		},
-	}, nil
+	}
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj:      fakeObjTool{},
+		UI:       &proftest.TestUI{T: t},
+		WebViews: []plugin.WebView{cost},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"/cost":      `<p id="cost">300 dollars</p>`,
+		"/cost?f=F3": `<p id="cost">100 dollars</p>`,
+	} {
+		w := httptest.NewRecorder()
+		ui.customView(ui.customViews[0], w, httptest.NewRequest("GET", path, nil))
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: missing %s in:\n%s", path, want, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	ui.top(w, httptest.NewRequest("GET", "/top", nil))
+	if want := `<a title="Cost of the profile" href="./cost" id="view-cost">Cost</a>`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("missing the menu entry %s of the custom view in the top view", want)
+	}
+
+	for _, v := range []plugin.WebView{
+		{Name: "Cost"},
+		{Name: "cost", Template: "{{.Missing"},
+	} {
+		if _, err := makeWebInterface(makeFakeProfile(), &plugin.Options{UI: &proftest.TestUI{T: t}, WebViews: []plugin.WebView{v}}); err == nil {
+			t.Errorf("got no error for the web view %+v", v)
+		}
+	}
 }
 
-type fakeObjTool struct{}
+func TestTracesPages(t *testing.T) {
+	prof := makeFakeProfile()
+	// 150 samples with values from 1ms to 150ms, to sort and split in pages.
+	var samples []*profile.Sample
+	for i := 1; i <= 150; i++ {
+		s := *prof.Sample[0]
+		s.Value = []int64{int64(i) * 1e6}
+		s.Label = map[string][]string{"request": {fmt.Sprintf("r%d", i)}}
+		samples = append(samples, &s)
+	}
+	prof.Sample = samples
+	ui, err := makeWebInterface(prof, &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func (obj fakeObjTool) Open(file string, start, limit, offset uint64) (plugin.ObjFile, error) {
-	return fakeObj{}, nil
+	for _, tc := range []struct {
+		path       string
+		want, skip []string
+	}{
+		{"/traces", []string{"Samples 1&ndash;100 of 150", `href="\?tpage=2"`, `href="\?tsort=weight"`, `request: r1<`, `href="\?tf=request%3D%5Er1%24"`}, []string{"traceprev", `request: r101<`}},
+		{"/traces?tpage=2", []string{"Samples 101&ndash;150 of 150", `href="\?tpage=1"`, `request: r101<`}, []string{"tracenext", `request: r1<`}},
+		{"/traces?tsort=weight", []string{"sorted by weight", `(?s)request: r150<.*request: r149<`, `href="\?tpage=2&amp;tsort=weight"`}, []string{`request: r1<`}},
+		{"/traces?tpage=9", []string{"Samples 101&ndash;150 of 150"}, nil},
+	} {
+		w := httptest.NewRecorder()
+		ui.traces(w, httptest.NewRequest("GET", tc.path, nil))
+		body := w.Body.String()
+		for _, want := range tc.want {
+			if !regexp.MustCompile(want).MatchString(body) {
+				t.Errorf("%s: missing %s", tc.path, want)
+			}
+		}
+		for _, skip := range tc.skip {
+			if regexp.MustCompile(skip).MatchString(body) {
+				t.Errorf("%s: unexpected %s", tc.path, skip)
+			}
+		}
+	}
 }
 
-func (obj fakeObjTool) Disasm(file string, start, end uint64, intelSyntax bool) ([]plugin.Inst, error) {
-	return []plugin.Inst{
-		{Addr: addrBase + 10, Text: "f1:asm", Function: "F1", Line: 3},
-		{Addr: addrBase + 20, Text: "f2:asm", Function: "F2", Line: 11},
-		{Addr: addrBase + 30, Text: "d3:asm", Function: "F3", Line: 22},
-	}, nil
+func TestMenuLinks(t *testing.T) {
+	var profiles []*profile.Profile
+	for _, src := range []string{"cpu.pid10.pb.gz", "cpu.pid11.ppid10.pb.gz"} {
+		p := makeFakeProfile()
+		labelProcess(p, src)
+		profiles = append(profiles, p)
+	}
+	prof, err := profile.Merge(profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stitchProcessTree(prof)
+	for i, s := range prof.Sample {
+		s.Label[report.PhaseLabel] = []string{[]string{"startup", "steady"}[i%2]}
+	}
+	ui, err := makeWebInterface(prof, &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The links of the process and phase menus keep the other parameters
+	// of the view.
+	w := httptest.NewRecorder()
+	ui.top(w, httptest.NewRequest("GET", "/top?f=F2&h=F3&si=cpu", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`href="?f=&amp;h=F3&amp;si=cpu">All processes`,
+		`href="?f=%5Epid&#43;10%24&amp;h=F3&amp;si=cpu"`,
+		`href="?f=%5Epid&#43;11%24&amp;h=F3&amp;si=cpu"`,
+		`href="?f=F2&amp;h=F3&amp;si=cpu&amp;tf=">All phases`,
+		`href="?f=F2&amp;h=F3&amp;si=cpu&amp;tf=phase%3D%5Esteady%24"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("menus have no link %s", want)
+		}
+	}
 }
 
-func makeFakeProfile() *profile.Profile {
-	// Three functions: F1, F2, F3 with three lines, 11, 22, 33.
-	funcs := []*profile.Function{
-		{ID: 1, Name: "F1", Filename: fakeSource, StartLine: 3},
-		{ID: 2, Name: "F2", Filename: fakeSource, StartLine: 5},
-		{ID: 3, Name: "F3", Filename: fakeSource, StartLine: 7},
+func TestLabelsJSON(t *testing.T) {
+	prof := makeFakeProfile()
+	prof.Sample[0].Label = map[string][]string{"thread": {"main"}}
+	prof.Sample[1].Label = map[string][]string{"thread": {"worker"}}
+	prof.Sample[1].NumLabel = map[string][]int64{"bytes": {512}}
+	ui, err := makeWebInterface(prof, &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	lines := []profile.Line{
-		{Function: funcs[0], Line: 11},
-		{Function: funcs[1], Line: 22},
-		{Function: funcs[2], Line: 33},
+
+	for _, tc := range []struct {
+		path string
+		want map[string]int64 // Totals of the values of the thread label.
+	}{
+		{"/api/labels", map[string]int64{"main": 100, "worker": 200}},
+		{"/api/labels?tf=thread%3D%5Emain%24", map[string]int64{"main": 100}},
+	} {
+		w := httptest.NewRecorder()
+		ui.labelsJSON(w, httptest.NewRequest("GET", tc.path, nil))
+		var groups []report.TagGroup
+		if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+			t.Fatalf("%s: invalid JSON %v:\n%s", tc.path, err, w.Body.String())
+		}
+		got := make(map[string]int64)
+		for _, g := range groups {
+			if g.Key == "bytes" && !g.Numeric {
+				t.Errorf("%s: bytes label not numeric", tc.path)
+			}
+			if g.Key != "thread" {
+				continue
+			}
+			for _, v := range g.Values {
+				got[v.Value] = v.Flat
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got thread values %v, want %v", tc.path, got, tc.want)
+		}
 	}
-	mapping := []*profile.Mapping{
-		{
-			ID:             1,
-			Start:          addrBase,
-			Limit:          addrBase + 100,
-			Offset:         0,
-			File:           "testbin",
-			HasFunctions:   true,
-			HasFilenames:   true,
-			HasLineNumbers: true,
-		},
+}
+
+func TestOpenTab(t *testing.T) {
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t, AllowRx: "Rejecting opened profile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("profile", "new.folded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(fw, "main;work 5\nmain;idle 3\n")
+	mw.Close()
+	req := httptest.NewRequest("POST", "/open", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ui.open(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "./?tab=1" {
+		t.Fatalf("opening profile: got status %d to %q, want %d to ./?tab=1", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
 	}
 
-	// Three interesting addresses: base+{10,20,30}
-	locs := []*profile.Location{
-		{ID: 1, Address: addrBase + 10, Line: lines[0:1], Mapping: mapping[0]},
-		{ID: 2, Address: addrBase + 20, Line: lines[1:2], Mapping: mapping[0]},
-		{ID: 3, Address: addrBase + 30, Line: lines[2:3], Mapping: mapping[0]},
+	// The new tab is served along with the first one, which is unchanged.
+	tab := ui.tab(httptest.NewRequest("GET", "/top?tab=1", nil))
+	if tab.name != "new.folded" || len(tab.prof.Sample) != 2 {
+		t.Errorf("got tab %q with %d samples, want new.folded with 2", tab.name, len(tab.prof.Sample))
+	}
+	if first := ui.tab(httptest.NewRequest("GET", "/top?tab=2", nil)); first.prof != ui.currentProfile() {
+		t.Errorf("got tab %q for a missing tab, want the first tab", first.name)
 	}
 
-	// Two stack traces.
-	return &profile.Profile{
-		PeriodType:    &profile.ValueType{Type: "cpu", Unit: "milliseconds"},
-		Period:        1,
-		DurationNanos: 10e9,
-		SampleType: []*profile.ValueType{
-			{Type: "cpu", Unit: "milliseconds"},
-		},
-		Sample: []*profile.Sample{
-			{
-				Location: []*profile.Location{locs[2], locs[1], locs[0]},
-				Value:    []int64{100},
-			},
-			{
-				Location: []*profile.Location{locs[1], locs[0]},
-				Value:    []int64{200},
-			},
-		},
-		Location: locs,
-		Function: funcs,
-		Mapping:  mapping,
+	w = httptest.NewRecorder()
+	ui.open(w, httptest.NewRequest("POST", "/open", strings.NewReader("garbage")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("opening garbage: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCompareTabs(t *testing.T) {
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := makeFakeProfile()
+	newer.Scale(3)
+	ui.tabs = append(ui.tabs, newProfileTab("new.pb.gz", newer))
+
+	// compare posts the form with the fields and files, and returns the
+	// tab opened, or nil with the status of the failure.
+	compare := func(fields map[string]string, files map[string]string) (*profileTab, int) {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		for k, v := range files {
+			fw, err := mw.CreateFormFile(k, k+".folded")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(fw, v)
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/compare", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		ui.compare(w, req)
+		if w.Code != http.StatusSeeOther {
+			return nil, w.Code
+		}
+		return ui.tab(httptest.NewRequest("GET", strings.TrimPrefix(w.Header().Get("Location"), "."), nil)), w.Code
+	}
+	// values returns the sums of the first values of the samples of the
+	// tab from the new and the base profiles.
+	values := func(tab *profileTab) (newer, base int64) {
+		for _, s := range tab.prof.Sample {
+			if s.DiffBaseSample() {
+				base += s.Value[0]
+			} else {
+				newer += s.Value[0]
+			}
+		}
+		return newer, base
+	}
+
+	tab, code := compare(map[string]string{"source": "1", "base": "0", "mode": "diff"}, nil)
+	if tab == nil {
+		t.Fatalf("comparing tabs: got status %d, want %d", code, http.StatusSeeOther)
+	}
+	if n, b := values(tab); tab.name != "new.pb.gz vs tab 0" || n != 900 || b != -300 {
+		t.Errorf("comparing tabs: got tab %q with values %d and %d, want new.pb.gz vs tab 0 with 900 and -300", tab.name, n, b)
+	}
+	if n, b := values(ui.tabs[1]); n != 900 || b != 0 {
+		t.Errorf("got values %d and %d of the compared tab, want it unchanged", n, b)
+	}
+
+	tab, _ = compare(map[string]string{"source": "1", "base": "0", "mode": "relative"}, nil)
+	if n, b := values(tab); tab.name != "new.pb.gz vs tab 0 (relative)" || n != 300 || b != -300 {
+		t.Errorf("comparing relative: got tab %q with values %d and %d, want the new profile scaled to 300", tab.name, n, b)
+	}
+
+	tab, _ = compare(map[string]string{"source": "file", "base": "file"}, map[string]string{
+		"source_file": "main;work 5\nmain;idle 3\n",
+		"base_file":   "main;work 2\nmain;idle 3\n",
+	})
+	if n, b := values(tab); tab.name != "source_file.folded vs base_file.folded" || n != 8 || b != -5 {
+		t.Errorf("comparing files: got tab %q with values %d and %d, want 8 and -5", tab.name, n, b)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		fields map[string]string
+	}{
+		{"missing base", map[string]string{"source": "1"}},
+		{"missing tab", map[string]string{"source": "1", "base": "9"}},
+		{"unknown mode", map[string]string{"source": "1", "base": "0", "mode": "ratio"}},
+	} {
+		if _, code := compare(tc.fields, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", tc.desc, code, http.StatusBadRequest)
+		}
+	}
+	w := httptest.NewRecorder()
+	ui.compare(w, httptest.NewRequest("GET", "/compare", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /compare: got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		ui.refresh(w, httptest.NewRequest("POST", "/refresh", nil))
+		return w.Code
+	}
+	if code := refresh(); code != http.StatusBadRequest {
+		t.Errorf("refreshing a profile without a duration: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	fetches := 0
+	ui.tabs[0].refetch = func(context.Context) (*profile.Profile, error) {
+		fetches++
+		p := makeFakeProfile()
+		p.Scale(float64(fetches + 1))
+		return p, nil
+	}
+	w := httptest.NewRecorder()
+	ui.top(w, httptest.NewRequest("GET", "/top", nil))
+	if !strings.Contains(w.Body.String(), `id="live-toggle"`) {
+		t.Errorf("top view of a live profile has no live toggle:\n%s", w.Body.String())
+	}
+	for i := 1; i <= 2; i++ {
+		if code := refresh(); code != http.StatusOK {
+			t.Fatalf("refresh %d: got status %d", i, code)
+		}
+		if got, want := ui.currentProfile().Sample[0].Value[0], int64(100*(i+1)); got != want {
+			t.Errorf("refresh %d: got sample value %d, want %d", i, got, want)
+		}
+	}
+	if ui.tabs[0].refetch == nil {
+		t.Errorf("refreshed tab is no longer live")
+	}
+
+	req := httptest.NewRequest("POST", "/refresh", nil)
+	req.Header.Set("Origin", "http://attacker.example")
+	w = httptest.NewRecorder()
+	ui.refresh(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("refresh from another site: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// Concurrent refreshes wait for the fetch running, never starting
+	// one of their own while it runs.
+	var mu sync.Mutex
+	running := 0
+	release := make(chan struct{})
+	ui.tabs[0].refetch = func(context.Context) (*profile.Profile, error) {
+		mu.Lock()
+		running++
+		overlap := running > 1
+		mu.Unlock()
+		if overlap {
+			t.Errorf("refetches overlap")
+		}
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return makeFakeProfile(), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := refresh(); code != http.StatusOK {
+				t.Errorf("concurrent refresh: got status %d", code)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestIngest(t *testing.T) {
+	ui, err := makeWebInterface(makeFakeProfile(), &plugin.Options{
+		Obj: fakeObjTool{},
+		UI:  &proftest.TestUI{T: t, AllowRx: "Serving the profile pushed as|Rejecting pushed profile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := func(query, contentType string, body []byte) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/ingest?"+query, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		ui.ingest(w, req)
+		return w.Code
+	}
+	total := func() (v int64) {
+		for _, s := range ui.currentProfile().Sample {
+			v += s.Value[0]
+		}
+		return v
+	}
+
+	// A compatible profile is merged into the profile served.
+	var buf bytes.Buffer
+	if err := makeFakeProfile().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	before := total()
+	if code := push("name=app.cpu", "binary/octet-stream", buf.Bytes()); code != http.StatusOK {
+		t.Fatalf("pushing pprof profile: got status %d", code)
+	}
+	if got := total(); got != 2*before {
+		t.Errorf("got total %d after merge, want %d", got, 2*before)
+	}
+
+	// An incompatible profile, pushed as a form, replaces it.
+	buf.Reset()
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("profile", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(fw, "main;work 5\nmain;idle 3\n")
+	mw.Close()
+	if code := push("name="+url.QueryEscape("app.cpu{env=staging}"), mw.FormDataContentType(), buf.Bytes()); code != http.StatusOK {
+		t.Fatalf("pushing folded profile: got status %d", code)
+	}
+	if got := total(); got != 8 {
+		t.Errorf("got total %d after replace, want 8", got)
+	}
+	for _, s := range ui.currentProfile().Sample {
+		if s.Label["app"][0] != "app.cpu" || s.Label["env"][0] != "staging" {
+			t.Errorf("got labels %v, want app and env labels", s.Label)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"name=" + url.QueryEscape("{env=staging}"), http.StatusBadRequest},
+		{"name=app.cpu", http.StatusBadRequest}, // Not a profile.
+	} {
+		if code := push(tc.query, "binary/octet-stream", []byte("garbage")); code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.query, code, tc.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	ui.ingest(w, httptest.NewRequest("GET", "/ingest", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /ingest: got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// The pages of other sites cannot push profiles, nor open or compare
+	// them.
+	for path, handler := range map[string]http.HandlerFunc{"/ingest": ui.ingest, "/open": ui.open, "/compare": ui.compare} {
+		req := httptest.NewRequest("POST", path+"?name=app.cpu", strings.NewReader("main;work 5\n"))
+		req.Header.Set("Origin", "http://evil.example.com")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("POST %s from another site: got status %d, want %d", path, w.Code, http.StatusForbidden)
+		}
+	}
+	if got := total(); got != 8 {
+		t.Errorf("got total %d after pushes from another site, want 8", got)
 	}
 }
 
@@ -287,3 +812,181 @@ func TestIsLocalHost(t *testing.T) {
 		}
 	}
 }
+
+func TestFlameGraphHTML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_flamegraph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(dir, "flame.html")
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "Generating report in"},
+		HTTPTransport: &httpTransport{},
+	})
+	if err := generateReport(context.Background(), makeFakeProfile(), []string{"flamegraph"}, cfg, o); err != nil {
+		t.Fatalf("generateReport: %v", err)
+	}
+	got, err := ioutil.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(got)
+	for _, want := range []string{
+		`"n":"root"`,
+		`"n":"F1"`,
+		"function pprofFlameGraph(",
+		"d3.flamegraph",
+		".d3-flame-graph",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("flame graph page has no %q", want)
+		}
+	}
+	// The page must not load anything from a pprof server.
+	if m := regexp.MustCompile(`(src|href)="[^"#][^"]*"`).FindString(page); m != "" {
+		t.Errorf("flame graph page links to %s", m)
+	}
+}
+
+func TestFlameGraphJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_flamegraph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := currentConfig()
+	cfg.Output = filepath.Join(dir, "flame.json")
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t, AllowRx: "Generating report in"},
+		HTTPTransport: &httpTransport{},
+	})
+	if err := generateReport(context.Background(), makeFakeProfile(), []string{"flamegraphjson"}, cfg, o); err != nil {
+		t.Fatalf("generateReport: %v", err)
+	}
+	got, err := ioutil.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root treeNode
+	if err := json.Unmarshal(got, &root); err != nil {
+		t.Fatalf("invalid JSON %v:\n%s", err, got)
+	}
+	if root.Name != "root" || root.Cum != 300 || len(root.Children) != 1 || root.Children[0].Name != "F1" {
+		t.Errorf("got flame graph tree %s, want F1 under a root of 300", got)
+	}
+}
+
+func TestFlameGraphDiff(t *testing.T) {
+	p := makeFakeProfile()
+	// The base profile is subtracted, as with -diff_base.
+	p.Sample = append(p.Sample, &profile.Sample{
+		Location: p.Sample[0].Location,
+		Value:    []int64{-150},
+		Label:    map[string][]string{"pprof::base": {"true"}},
+	})
+	cfg := currentConfig()
+	cfg.CallTree = true
+	cfg.Trim = false
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: &httpTransport{},
+	})
+	_, rpt, err := generateRawReport(context.Background(), p, []string{"svg"}, cfg, o)
+	if err != nil {
+		t.Fatalf("generateRawReport: %v", err)
+	}
+	root, _, _ := flameGraphTree(rpt)
+
+	deltas := make(map[string]string)
+	var visit func(n *treeNode)
+	visit = func(n *treeNode) {
+		if n.Delta == nil {
+			t.Errorf("%s has no change", n.Name)
+		}
+		deltas[n.Name] = n.DeltaFormat
+		for _, c := range n.Children {
+			visit(c)
+		}
+	}
+	visit(root)
+	// The frames show the new profile, 300ms, of which F3 improved.
+	if root.Cum != 300 {
+		t.Errorf("got root of %d, want 300", root.Cum)
+	}
+	for name, want := range map[string]string{"root": "+150ms", "F1": "+150ms", "F2": "+150ms", "F3": "-50ms"} {
+		if deltas[name] != want {
+			t.Errorf("%s changed by %q, want %q", name, deltas[name], want)
+		}
+	}
+}
+
+func TestSandwichTree(t *testing.T) {
+	p := makeFakeProfile()
+	// A recursive call of F2 counts once in its sandwich.
+	l := p.Sample[0].Location
+	p.Sample = append(p.Sample, &profile.Sample{
+		Location: []*profile.Location{l[0], l[1], l[1], l[2]},
+		Value:    []int64{50},
+	})
+	cfg := currentConfig()
+	cfg.CallTree = true
+	cfg.Trim = false
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: &httpTransport{},
+	})
+	_, rpt, err := generateRawReport(context.Background(), p, []string{"svg"}, cfg, o)
+	if err != nil {
+		t.Fatalf("generateRawReport: %v", err)
+	}
+
+	flatten := func(n *treeNode) []string {
+		var nodes []string
+		var visit func(n *treeNode, path string)
+		visit = func(n *treeNode, path string) {
+			path += "/" + n.Name
+			nodes = append(nodes, fmt.Sprintf("%s=%d", path, n.Cum))
+			for _, c := range n.Children {
+				visit(c, path)
+			}
+		}
+		visit(n, "")
+		sort.Strings(nodes)
+		return nodes
+	}
+	for _, tc := range []struct {
+		rx               string
+		callers, callees []string
+	}{
+		{
+			rx:      "F2",
+			callers: []string{"/F2/F1=350", "/F2=350"},
+			callees: []string{"/F2/F2/F3=50", "/F2/F2=50", "/F2/F3=100", "/F2=350"},
+		},
+		{
+			rx:      "F3",
+			callers: []string{"/F3/F2/F1=100", "/F3/F2/F2/F1=50", "/F3/F2/F2=50", "/F3/F2=150", "/F3=150"},
+			callees: []string{"/F3=150"},
+		},
+	} {
+		trees := sandwichTree(rpt, regexp.MustCompile(tc.rx))
+		if trees == nil {
+			t.Fatalf("%s: got no sandwich", tc.rx)
+		}
+		if got := flatten(trees.Callers); !reflect.DeepEqual(got, tc.callers) {
+			t.Errorf("%s: got callers %v, want %v", tc.rx, got, tc.callers)
+		}
+		if got := flatten(trees.Callees); !reflect.DeepEqual(got, tc.callees) {
+			t.Errorf("%s: got callees %v, want %v", tc.rx, got, tc.callees)
+		}
+	}
+	if trees := sandwichTree(rpt, regexp.MustCompile("nothing")); trees != nil {
+		t.Errorf("got sandwich %v of no functions", trees)
+	}
+}
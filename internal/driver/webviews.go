@@ -0,0 +1,107 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/report"
+)
+
+// webViewNameRE matches the names of the views added by embedders.
+var webViewNameRE = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// customView is a view of the web interface added by the program
+// embedding pprof, with its parsed template.
+type customView struct {
+	plugin.WebView
+	tmpl *template.Template
+}
+
+// customViewEntry holds the entry of a custom view in the View menu.
+type customViewEntry struct {
+	Name, Title, Help string
+}
+
+// parseWebViews returns the custom views of the embedder's views, with
+// their templates parsed.
+func parseWebViews(views []plugin.WebView) ([]*customView, error) {
+	var custom []*customView
+	seen := make(map[string]bool)
+	for _, v := range views {
+		if !webViewNameRE.MatchString(v.Name) {
+			return nil, fmt.Errorf("invalid name %q of a web view, want lowercase letters, digits, '-' and '_'", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("duplicate web view %q", v.Name)
+		}
+		seen[v.Name] = true
+		tmpl, err := template.New(v.Name).Parse(v.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the template of the web view %q: %v", v.Name, err)
+		}
+		custom = append(custom, &customView{v, tmpl})
+	}
+	return custom, nil
+}
+
+// customViewEntries returns the entries of the custom views of ui in
+// the View menu.
+func (ui *webInterface) customViewEntries() []customViewEntry {
+	var entries []customViewEntry
+	for _, v := range ui.customViews {
+		title := v.Title
+		if title == "" {
+			title = v.Name
+		}
+		entries = append(entries, customViewEntry{v.Name, title, v.Help})
+	}
+	return entries
+}
+
+// customView generates the web page of the custom view v: its template
+// executed with the data of the profile of the view, below the header.
+func (ui *webInterface) customView(v *customView, w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"proto"}, nil)
+	if rpt == nil {
+		return // error already reported
+	}
+	var data interface{}
+	if v.Data != nil {
+		var err error
+		if data, err = v.Data(rpt.Profile(), req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			ui.options.UI.PrintErr(err)
+			return
+		}
+	}
+	body := &bytes.Buffer{}
+	if err := v.tmpl.Execute(body, data); err != nil {
+		http.Error(w, "internal template error", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	ui.render(w, req, "customview", rpt, errList, report.ProfileLabels(rpt), webArgs{
+		HTMLBody: template.HTML(body.String()),
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/google/pprof/internal/plugin"
+)
+
+// compressZstd post-processes the proto output of -zstd, compressing the
+// profile written uncompressed with the zstd tool. pprof decodes
+// zstd-compressed profiles itself, but leaves their encoding to the tool.
+func compressZstd(input io.Reader, output io.Writer, ui plugin.UI) error {
+	cmd := exec.Command("zstd", "-q", "-c")
+	var stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = input, output, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to execute zstd. Is zstd installed? Error: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/internal/proftest"
+	"github.com/google/pprof/profile"
+)
+
+func TestZstdProto(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	o := setDefaults(&plugin.Options{
+		Obj:           fakeObjTool{},
+		UI:            &proftest.TestUI{T: t},
+		HTTPTransport: &httpTransport{},
+	})
+	for _, compact := range []bool{false, true} {
+		cfg := currentConfig()
+		cfg.Zstd, cfg.Compact = true, compact
+		_, out, err := renderReport(context.Background(), makeFakeProfile(), []string{"proto"}, cfg, o)
+		if err != nil {
+			t.Fatalf("compact=%v: renderReport: %v", compact, err)
+		}
+		if !bytes.HasPrefix(out.Bytes(), []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+			t.Fatalf("compact=%v: got %x..., want a zstd frame", compact, out.Bytes()[:4])
+		}
+		p, err := profile.Parse(out)
+		if err != nil {
+			t.Fatalf("compact=%v: parsing the profile: %v", compact, err)
+		}
+		if len(p.Sample) != 2 {
+			t.Errorf("compact=%v: got %d samples, want 2", compact, len(p.Sample))
+		}
+	}
+}
@@ -114,6 +114,10 @@ func parseNotes(reader io.Reader, alignment int, order binary.ByteOrder) ([]elfN
 	return notes, nil
 }
 
+// pageSize is the size of the 4KB pages that mappings are assumed to be
+// aligned to, unless they are mapped onto huge pages.
+const pageSize = 4096
+
 // GetBuildID returns the GNU build-ID for an ELF binary.
 //
 // If no build-ID was found but the binary was read without error, it returns
@@ -174,9 +178,81 @@ func GetBuildID(binary io.ReaderAt) ([]byte, error) {
 // address where the mapping starts. The kernel is special, and may
 // use the address of the _stext symbol as the mmap start. _stext
 // offset can be obtained with `nm vmlinux | grep _stext`
+//
+// GetBase assumes that the mapping is aligned to 4KB pages. Use
+// GetBaseAligned for mappings aligned to the segments of binaries linked
+// with a larger alignment.
 func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint64, start, limit, offset uint64) (uint64, error) {
+	return GetBaseAligned(fh, loadSegment, stextOffset, start, limit, offset, pageSize)
+}
+
+// MappingAlignment returns the alignment of the runtime mapping starting
+// at start of the segment loadSegment: the alignment of the segment, if
+// it is larger than a 4KB page and start is a multiple of it, as for the
+// binaries linked with 2MB segment alignment whose text is mapped onto
+// transparent huge pages, or the 4KB page size otherwise.
+func MappingAlignment(loadSegment *elf.ProgHeader, start uint64) uint64 {
+	if loadSegment != nil && loadSegment.Align > pageSize && start%loadSegment.Align == 0 {
+		return loadSegment.Align
+	}
+	return pageSize
+}
+
+// GetBaseAligned determines the base address as GetBase does, for a
+// mapping aligned to align bytes, as returned by MappingAlignment. When a
+// segment is remapped onto huge pages, its mapping starts at the address
+// of the segment rounded down to the alignment, while the offset of the
+// mapping may still be the 4KB-aligned one of the original file mapping.
+// The loader keeps the addresses of the segments congruent to their
+// virtual addresses modulo their alignment, so a base computed from such
+// an offset is not a multiple of the alignment: the base of a user-mode
+// binary is then computed from the start of the mapping alone.
+func GetBaseAligned(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint64, start, limit, offset, align uint64) (uint64, error) {
+	r, err := GetRelocation(fh, loadSegment, stextOffset, start, limit, offset, align)
+	return r.Base, err
+}
+
+// RelocationMode names the rule by which the base of a mapping was
+// computed, as recorded in the profiles to tell how their addresses
+// were translated.
+type RelocationMode string
+
+const (
+	// RelocationNone is the mode of the mappings whose addresses are
+	// those of their binary: fixed-address executables without a load
+	// segment, and the fake mappings spanning the address space.
+	RelocationNone RelocationMode = "none"
+	// RelocationUser is the mode of user-mode executables and shared
+	// libraries, relocated by their load segment.
+	RelocationUser RelocationMode = "user"
+	// RelocationUserAligned is the mode of user-mode binaries whose
+	// mapping starts at their load segment rounded down to its
+	// alignment, as when remapped onto huge pages.
+	RelocationUserAligned RelocationMode = "user-aligned"
+	// RelocationKernel is the mode of the kernel relocated by its load
+	// segment.
+	RelocationKernel RelocationMode = "kernel"
+	// RelocationKernelStext is the mode of the kernel relocated by the
+	// address of its _stext symbol.
+	RelocationKernelStext RelocationMode = "kernel-stext"
+	// RelocationObject is the mode of relocatable objects, such as kernel
+	// modules, whose base is the start of their mapping.
+	RelocationObject RelocationMode = "object"
+	// RelocationOffset is the mode of shared objects without a load
+	// segment, relocated by the offset of their mapping alone.
+	RelocationOffset RelocationMode = "offset"
+)
+
+// A Relocation is the base of a mapping and the rule it was computed by.
+type Relocation struct {
+	Base uint64
+	Mode RelocationMode
+}
+
+// GetRelocation determines the base address of a mapping as
+// GetBaseAligned does, along with the rule it was computed by.
+func GetRelocation(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint64, start, limit, offset, align uint64) (Relocation, error) {
 	const (
-		pageSize = 4096
 		// PAGE_OFFSET for PowerPC64, see arch/powerpc/Kconfig in the kernel sources.
 		pageOffsetPpc64 = 0xc000000000000000
 	)
@@ -185,14 +261,14 @@ func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint6
 		// Some tools may introduce a fake mapping that spans the entire
 		// address space. Assume that the address has already been
 		// adjusted, so no additional base adjustment is necessary.
-		return 0, nil
+		return Relocation{0, RelocationNone}, nil
 	}
 
 	switch fh.Type {
 	case elf.ET_EXEC:
 		if loadSegment == nil {
 			// Assume fixed-address executable and so no adjustment.
-			return 0, nil
+			return Relocation{0, RelocationNone}, nil
 		}
 		if stextOffset == nil && start > 0 && start < 0x8000000000000000 {
 			// A regular user-mode executable. Compute the base offset using same
@@ -203,11 +279,11 @@ func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint6
 			// all the symbols) and so it may be nil even for the kernel executable.
 			// So additionally check that the start is within the user-mode half of
 			// the 64-bit address space.
-			return start - offset + loadSegment.Off - loadSegment.Vaddr, nil
+			return userRelocation(loadSegment, start, offset, align), nil
 		}
 		// Various kernel heuristics and cases follow.
 		if loadSegment.Vaddr == start-offset {
-			return offset, nil
+			return Relocation{offset, RelocationKernel}, nil
 		}
 		if start == 0 && limit != 0 {
 			// ChromeOS remaps its kernel to 0. Nothing else should come
@@ -215,9 +291,9 @@ func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint6
 			//       VADDR=0xffffffff80200000
 			// stextOffset=0xffffffff80200198
 			if stextOffset != nil {
-				return -*stextOffset, nil
+				return Relocation{-*stextOffset, RelocationKernelStext}, nil
 			}
-			return -loadSegment.Vaddr, nil
+			return Relocation{-loadSegment.Vaddr, RelocationKernel}, nil
 		}
 		if start >= loadSegment.Vaddr && limit > start && (offset == 0 || offset == pageOffsetPpc64 || offset == start) {
 			// Some kernels look like:
@@ -231,32 +307,32 @@ func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint6
 				// perf uses the address of _stext as start. Some tools may
 				// adjust for this before calling GetBase, in which case the page
 				// alignment should be different from that of stextOffset.
-				return start - *stextOffset, nil
+				return Relocation{start - *stextOffset, RelocationKernelStext}, nil
 			}
 
-			return start - loadSegment.Vaddr, nil
+			return Relocation{start - loadSegment.Vaddr, RelocationKernel}, nil
 		} else if start%pageSize != 0 && stextOffset != nil && *stextOffset%pageSize == start%pageSize {
 			// ChromeOS remaps its kernel to 0 + start%pageSize. Nothing
 			// else should come down this path. Empirical values:
 			//       start=0x198 limit=0x2f9fffff offset=0
 			//       VADDR=0xffffffff81000000
 			// stextOffset=0xffffffff81000198
-			return start - *stextOffset, nil
+			return Relocation{start - *stextOffset, RelocationKernelStext}, nil
 		}
 
-		return 0, fmt.Errorf("don't know how to handle EXEC segment: %v start=0x%x limit=0x%x offset=0x%x", *loadSegment, start, limit, offset)
+		return Relocation{}, fmt.Errorf("don't know how to handle EXEC segment: %v start=0x%x limit=0x%x offset=0x%x", *loadSegment, start, limit, offset)
 	case elf.ET_REL:
 		if offset != 0 {
-			return 0, fmt.Errorf("don't know how to handle mapping.Offset")
+			return Relocation{}, fmt.Errorf("don't know how to handle mapping.Offset")
 		}
-		return start, nil
+		return Relocation{start, RelocationObject}, nil
 	case elf.ET_DYN:
 		// The process mapping information, start = start of virtual address range,
 		// and offset = offset in the executable file of the start address, tells us
 		// that a runtime virtual address x maps to a file offset
 		// fx = x - start + offset.
 		if loadSegment == nil {
-			return start - offset, nil
+			return Relocation{start - offset, RelocationOffset}, nil
 		}
 		// The program header, if not nil, indicates the offset in the file where
 		// the executable segment is located (loadSegment.Off), and the base virtual
@@ -266,9 +342,22 @@ func GetBase(fh *elf.FileHeader, loadSegment *elf.ProgHeader, stextOffset *uint6
 		//
 		// Thus, a runtime virtual address x maps to a symbol address
 		// sx = x - start + offset - loadSegment.Off + loadSegment.Vaddr.
-		return start - offset + loadSegment.Off - loadSegment.Vaddr, nil
+		return userRelocation(loadSegment, start, offset, align), nil
+	}
+	return Relocation{}, fmt.Errorf("don't know how to handle FileHeader.Type %v", fh.Type)
+}
+
+// userRelocation returns the relocation of a user-mode binary whose
+// segment loadSegment is mapped at start, from file offset offset, with
+// the alignment align. See GetBaseAligned.
+func userRelocation(loadSegment *elf.ProgHeader, start, offset, align uint64) Relocation {
+	base := start - offset + loadSegment.Off - loadSegment.Vaddr
+	if align > pageSize && base%align != 0 && start%align == 0 {
+		// The mapping starts at the address of the segment rounded down
+		// to its alignment, rather than at that of its offset.
+		return Relocation{start - loadSegment.Vaddr&^(align-1), RelocationUserAligned}
 	}
-	return 0, fmt.Errorf("don't know how to handle FileHeader.Type %v", fh.Type)
+	return Relocation{base, RelocationUser}
 }
 
 // FindTextProgHeader finds the program segment header containing the .text
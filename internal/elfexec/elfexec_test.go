@@ -100,6 +100,112 @@ func TestGetBase(t *testing.T) {
 	}
 }
 
+func TestGetBaseAligned(t *testing.T) {
+	fhExec := &elf.FileHeader{
+		Type: elf.ET_EXEC,
+	}
+	fhDyn := &elf.FileHeader{
+		Type: elf.ET_DYN,
+	}
+	// The text segments of binaries linked with 2MB segment alignment.
+	hugeText := &elf.ProgHeader{
+		Vaddr: 0x201000,
+		Off:   0x1000,
+		Align: 0x200000,
+	}
+	hugeExecText := &elf.ProgHeader{
+		Vaddr: 0x401000,
+		Off:   0x1000,
+		Align: 0x200000,
+	}
+	hugeFirst := &elf.ProgHeader{
+		Align: 0x200000,
+	}
+
+	testcases := []struct {
+		label                string
+		fh                   *elf.FileHeader
+		loadSegment          *elf.ProgHeader
+		start, limit, offset uint64
+		wantAlign, want      uint64
+	}{
+		{"dyn huge pages", fhDyn, hugeText, 0x7f0000200000, 0x7f0000600000, 0x1000, 0x200000, 0x7f0000000000},
+		{"dyn file mapping", fhDyn, hugeText, 0x7f0000201000, 0x7f0000600000, 0x1000, 0x1000, 0x7f0000000000},
+		{"dyn huge pages at offset", fhDyn, hugeFirst, 0x7f0000000000, 0x7f0000400000, 0, 0x200000, 0x7f0000000000},
+		{"exec huge pages", fhExec, hugeExecText, 0x400000, 0x800000, 0x1000, 0x200000, 0},
+		{"exec file mapping", fhExec, hugeExecText, 0x401000, 0x800000, 0x1000, 0x1000, 0},
+	}
+
+	for _, tc := range testcases {
+		align := MappingAlignment(tc.loadSegment, tc.start)
+		if align != tc.wantAlign {
+			t.Errorf("%s: want alignment 0x%x, got 0x%x", tc.label, tc.wantAlign, align)
+		}
+		base, err := GetBaseAligned(tc.fh, tc.loadSegment, nil, tc.start, tc.limit, tc.offset, align)
+		if err != nil {
+			t.Errorf("%s: want no error, got %v", tc.label, err)
+			continue
+		}
+		if base != tc.want {
+			t.Errorf("%s: want 0x%x, got 0x%x", tc.label, tc.want, base)
+		}
+	}
+}
+
+func TestGetRelocation(t *testing.T) {
+	fhExec := &elf.FileHeader{
+		Type: elf.ET_EXEC,
+	}
+	fhDyn := &elf.FileHeader{
+		Type: elf.ET_DYN,
+	}
+	fhRel := &elf.FileHeader{
+		Type: elf.ET_REL,
+	}
+	lsOffset := &elf.ProgHeader{
+		Vaddr: 0x400000,
+		Off:   0x200000,
+	}
+	kernelHeader := &elf.ProgHeader{
+		Vaddr: 0xffffffff81000000,
+	}
+	hugeText := &elf.ProgHeader{
+		Vaddr: 0x201000,
+		Off:   0x1000,
+		Align: 0x200000,
+	}
+
+	testcases := []struct {
+		label                string
+		fh                   *elf.FileHeader
+		loadSegment          *elf.ProgHeader
+		stextOffset          *uint64
+		start, limit, offset uint64
+		want                 Relocation
+	}{
+		{"exec", fhExec, lsOffset, nil, 0x400000, 0x800000, 0, Relocation{0x200000, RelocationUser}},
+		{"exec no segment", fhExec, nil, nil, 0x400000, 0x800000, 0, Relocation{0, RelocationNone}},
+		{"fake mapping", fhExec, lsOffset, nil, 0, ^uint64(0), 0, Relocation{0, RelocationNone}},
+		{"kernel", fhExec, kernelHeader, nil, 0xffffffff82000000, 0xffffffff83000000, 0, Relocation{0x1000000, RelocationKernel}},
+		{"kernel stext", fhExec, kernelHeader, uint64p(0xffffffff81000198), 0xffffffff82000198, 0xffffffff83000000, 0, Relocation{0x1000000, RelocationKernelStext}},
+		{"dyn", fhDyn, lsOffset, nil, 0x7f0000000000, 0x7f0000400000, 0x200000, Relocation{0x7effffc00000, RelocationUser}},
+		{"dyn no segment", fhDyn, nil, nil, 0x7f0000001000, 0x7f0000400000, 0x1000, Relocation{0x7f0000000000, RelocationOffset}},
+		{"dyn huge pages", fhDyn, hugeText, nil, 0x7f0000200000, 0x7f0000600000, 0x1000, Relocation{0x7f0000000000, RelocationUserAligned}},
+		{"rel", fhRel, nil, nil, 0x2000, 0x3000, 0, Relocation{0x2000, RelocationObject}},
+	}
+
+	for _, tc := range testcases {
+		got, err := GetRelocation(tc.fh, tc.loadSegment, tc.stextOffset, tc.start, tc.limit, tc.offset, MappingAlignment(tc.loadSegment, tc.start))
+		if err != nil {
+			t.Errorf("%s: want no error, got %v", tc.label, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: want %+v, got %+v", tc.label, tc.want, got)
+		}
+	}
+}
+
 func uint64p(n uint64) *uint64 {
 	return &n
 }
@@ -439,3 +545,42 @@ func TestHeaderForFileOffset(t *testing.T) {
 		})
 	}
 }
+
+func TestSymbolHashes(t *testing.T) {
+	if got, want := gnuHash("printf"), uint32(0x156b2bb8); got != want {
+		t.Errorf("gnuHash(printf) = %#x, want %#x", got, want)
+	}
+	if got, want := sysvHash("printf"), uint32(0x077905a6); got != want {
+		t.Errorf("sysvHash(printf) = %#x, want %#x", got, want)
+	}
+}
+
+func TestFindSymbol(t *testing.T) {
+	f, err := elf.Open("../binutils/testdata/exe_linux_64")
+	if err != nil {
+		t.Fatalf("elf.Open: %v", err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatalf("Symbols: %v", err)
+	}
+	for _, want := range syms {
+		if want.Name == "" || want.Section == elf.SHN_UNDEF {
+			continue
+		}
+		got, err := FindSymbol(f, want.Name)
+		if err != nil {
+			t.Errorf("FindSymbol(%s): %v", want.Name, err)
+			continue
+		}
+		if got == nil || got.Value != want.Value || got.Size != want.Size {
+			t.Errorf("FindSymbol(%s) = %+v, want %+v", want.Name, got, want)
+		}
+	}
+	for _, name := range []string{"no_such_symbol", "puts"} {
+		if got, err := FindSymbol(f, name); got != nil || err != nil {
+			t.Errorf("FindSymbol(%s) = %+v, %v, want nil", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfexec
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+)
+
+// FindSymbol returns the defined symbol of f with the given name, or nil
+// if there is none. Dynamic symbols are looked up through the .gnu.hash or .hash
+// sections when present. Otherwise the symbol tables are scanned one
+// entry at a time, comparing only the offsets of the name in the string
+// table, so that huge symbol tables are never fully loaded in memory.
+func FindSymbol(f *elf.File, name string) (*elf.Symbol, error) {
+	s, hashed, err := lookupHashedSymbol(f, name)
+	if s != nil || err != nil {
+		return s, err
+	}
+	if s, err := scanSymbols(f, f.SectionByType(elf.SHT_SYMTAB), name); s != nil || err != nil {
+		return s, err
+	}
+	if hashed {
+		// The hash table covers all defined dynamic symbols.
+		return nil, nil
+	}
+	return scanSymbols(f, f.SectionByType(elf.SHT_DYNSYM), name)
+}
+
+// symbolTable provides random access to the entries of a symbol table.
+type symbolTable struct {
+	f            *elf.File
+	syms, strtab *elf.Section
+	entSize      int
+}
+
+func newSymbolTable(f *elf.File, syms *elf.Section) (*symbolTable, error) {
+	if syms == nil || syms.Type == elf.SHT_NOBITS {
+		return nil, nil
+	}
+	if int(syms.Link) >= len(f.Sections) {
+		return nil, fmt.Errorf("symbol table %s has invalid string table index %d", syms.Name, syms.Link)
+	}
+	t := &symbolTable{f: f, syms: syms, strtab: f.Sections[syms.Link], entSize: elf.Sym32Size}
+	if f.Class == elf.ELFCLASS64 {
+		t.entSize = elf.Sym64Size
+	}
+	return t, nil
+}
+
+// len returns the number of entries of the symbol table.
+func (t *symbolTable) len() int {
+	return int(t.syms.Size) / t.entSize
+}
+
+// decode decodes a symbol table entry, returning the offset of its name
+// in the string table.
+func (t *symbolTable) decode(b []byte) (elf.Symbol, uint32) {
+	order := t.f.ByteOrder
+	var s elf.Symbol
+	nameOff := order.Uint32(b[0:4])
+	if t.f.Class == elf.ELFCLASS64 {
+		s.Info, s.Other = b[4], b[5]
+		s.Section = elf.SectionIndex(order.Uint16(b[6:8]))
+		s.Value, s.Size = order.Uint64(b[8:16]), order.Uint64(b[16:24])
+	} else {
+		s.Value, s.Size = uint64(order.Uint32(b[4:8])), uint64(order.Uint32(b[8:12]))
+		s.Info, s.Other = b[12], b[13]
+		s.Section = elf.SectionIndex(order.Uint16(b[14:16]))
+	}
+	return s, nameOff
+}
+
+// symbol returns the i-th entry of the symbol table.
+func (t *symbolTable) symbol(i uint32) (elf.Symbol, uint32, error) {
+	b := make([]byte, t.entSize)
+	if _, err := t.syms.ReadAt(b, int64(i)*int64(t.entSize)); err != nil {
+		return elf.Symbol{}, 0, fmt.Errorf("reading symbol %d of %s: %v", i, t.syms.Name, err)
+	}
+	s, nameOff := t.decode(b)
+	return s, nameOff, nil
+}
+
+// hasName reports whether the string at offset off of the string table is
+// name.
+func (t *symbolTable) hasName(off uint32, name string) bool {
+	b := make([]byte, len(name)+1)
+	if _, err := t.strtab.ReadAt(b, int64(off)); err != nil {
+		return false
+	}
+	return string(b[:len(name)]) == name && b[len(name)] == 0
+}
+
+// scanSymbols scans the symbol table syms for a symbol with the given name.
+func scanSymbols(f *elf.File, syms *elf.Section, name string) (*elf.Symbol, error) {
+	t, err := newSymbolTable(f, syms)
+	if t == nil || err != nil {
+		return nil, err
+	}
+
+	// Find the offsets of the name in the string table, which may share
+	// its storage with the tails of longer names.
+	strs, err := t.strtab.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading string table of %s: %v", syms.Name, err)
+	}
+	target := append([]byte(name), 0)
+	offsets := make(map[uint32]bool)
+	for i := 0; ; {
+		j := bytes.Index(strs[i:], target)
+		if j == -1 {
+			break
+		}
+		offsets[uint32(i+j)] = true
+		i += j + 1
+	}
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+
+	r := bufio.NewReader(syms.Open())
+	b := make([]byte, t.entSize)
+	for i := 0; i < t.len(); i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", syms.Name, err)
+		}
+		if i == 0 {
+			continue // The first entry is reserved.
+		}
+		if s, nameOff := t.decode(b); offsets[nameOff] && s.Section != elf.SHN_UNDEF {
+			s.Name = name
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+// lookupHashedSymbol looks up a dynamic symbol through the GNU or SysV
+// symbol hash table. It also reports whether f has a hash table.
+func lookupHashedSymbol(f *elf.File, name string) (*elf.Symbol, bool, error) {
+	if h := f.SectionByType(elf.SHT_GNU_HASH); h != nil {
+		s, err := lookupGNUHash(f, h, name)
+		return s, true, err
+	}
+	if h := f.SectionByType(elf.SHT_HASH); h != nil {
+		s, err := lookupSysVHash(f, h, name)
+		return s, true, err
+	}
+	return nil, false, nil
+}
+
+// hashedSymbol returns the i-th entry of the symbol table t if it has the
+// given name.
+func hashedSymbol(t *symbolTable, i uint32, name string) (*elf.Symbol, error) {
+	s, nameOff, err := t.symbol(i)
+	if err != nil {
+		return nil, err
+	}
+	if s.Section == elf.SHN_UNDEF || !t.hasName(nameOff, name) {
+		return nil, nil
+	}
+	s.Name = name
+	return &s, nil
+}
+
+// lookupGNUHash looks up a symbol through a .gnu.hash section, which holds
+// a bloom filter followed by hash buckets and chains over the defined
+// entries of the dynamic symbol table.
+func lookupGNUHash(f *elf.File, hash *elf.Section, name string) (*elf.Symbol, error) {
+	t, err := newSymbolTable(f, dynamicSymbols(f, hash))
+	if t == nil || err != nil {
+		return nil, err
+	}
+	data, err := hash.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", hash.Name, err)
+	}
+	order := f.ByteOrder
+	if len(data) < 16 {
+		return nil, fmt.Errorf("malformed %s", hash.Name)
+	}
+	nbuckets := order.Uint32(data[0:4])
+	symoffset := order.Uint32(data[4:8])
+	bloomSize := order.Uint32(data[8:12])
+	bloomShift := order.Uint32(data[12:16])
+	wordBits := uint32(32)
+	if f.Class == elf.ELFCLASS64 {
+		wordBits = 64
+	}
+	bloomLen, bucketsLen := uint64(bloomSize)*uint64(wordBits/8), 4*uint64(nbuckets)
+	if nbuckets == 0 || bloomSize == 0 || uint64(len(data)-16) < bloomLen+bucketsLen {
+		return nil, fmt.Errorf("malformed %s", hash.Name)
+	}
+	bloom := data[16 : 16+bloomLen]
+	buckets := data[16+bloomLen : 16+bloomLen+bucketsLen]
+	chains := data[16+bloomLen+bucketsLen:]
+
+	h := gnuHash(name)
+	wordIdx := (h / wordBits) % bloomSize
+	var word uint64
+	if wordBits == 64 {
+		word = order.Uint64(bloom[wordIdx*8:])
+	} else {
+		word = uint64(order.Uint32(bloom[wordIdx*4:]))
+	}
+	mask := uint64(1)<<(h%wordBits) | uint64(1)<<((h>>bloomShift)%wordBits)
+	if word&mask != mask {
+		return nil, nil
+	}
+
+	i := order.Uint32(buckets[(h%nbuckets)*4:])
+	if i < symoffset {
+		return nil, nil
+	}
+	for {
+		c := uint64(i-symoffset) * 4
+		if c+4 > uint64(len(chains)) {
+			return nil, fmt.Errorf("malformed %s", hash.Name)
+		}
+		h2 := order.Uint32(chains[c:])
+		if h|1 == h2|1 {
+			if s, err := hashedSymbol(t, i, name); s != nil || err != nil {
+				return s, err
+			}
+		}
+		if h2&1 != 0 {
+			return nil, nil
+		}
+		i++
+	}
+}
+
+// lookupSysVHash looks up a symbol through a SysV .hash section, which
+// holds hash buckets and chains over the dynamic symbol table.
+func lookupSysVHash(f *elf.File, hash *elf.Section, name string) (*elf.Symbol, error) {
+	t, err := newSymbolTable(f, dynamicSymbols(f, hash))
+	if t == nil || err != nil {
+		return nil, err
+	}
+	data, err := hash.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", hash.Name, err)
+	}
+	order := f.ByteOrder
+	if len(data) < 8 {
+		return nil, fmt.Errorf("malformed %s", hash.Name)
+	}
+	nbucket := order.Uint32(data[0:4])
+	nchain := order.Uint32(data[4:8])
+	if nbucket == 0 || uint64(len(data)) < 8+4*(uint64(nbucket)+uint64(nchain)) {
+		return nil, fmt.Errorf("malformed %s", hash.Name)
+	}
+	buckets, chains := data[8:8+4*nbucket], data[8+4*nbucket:]
+
+	// Bound the number of steps to guard against cycles in the chains.
+	i := order.Uint32(buckets[(sysvHash(name)%nbucket)*4:])
+	for steps := uint32(0); i != 0 && i < nchain && steps < nchain; steps++ {
+		if s, err := hashedSymbol(t, i, name); s != nil || err != nil {
+			return s, err
+		}
+		i = order.Uint32(chains[i*4:])
+	}
+	return nil, nil
+}
+
+// dynamicSymbols returns the symbol table indexed by a hash section.
+func dynamicSymbols(f *elf.File, hash *elf.Section) *elf.Section {
+	if int(hash.Link) < len(f.Sections) && hash.Link != 0 {
+		return f.Sections[hash.Link]
+	}
+	return f.SectionByType(elf.SHT_DYNSYM)
+}
+
+// gnuHash is the hash function used by .gnu.hash sections.
+func gnuHash(name string) uint32 {
+	h := uint32(5381)
+	for i := 0; i < len(name); i++ {
+		h = h*33 + uint32(name[i])
+	}
+	return h
+}
+
+// sysvHash is the hash function used by SysV .hash sections.
+func sysvHash(name string) uint32 {
+	var h uint32
+	for i := 0; i < len(name); i++ {
+		h = h<<4 + uint32(name[i])
+		if g := h & 0xf0000000; g != 0 {
+			h ^= g >> 24
+		}
+		h &^= 0xf0000000
+	}
+	return h
+}
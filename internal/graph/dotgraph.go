@@ -19,6 +19,7 @@ import (
 	"io"
 	"math"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/google/pprof/internal/measurement"
@@ -28,6 +29,7 @@ import (
 // insight into how its elements should be rendered.
 type DotAttributes struct {
 	Nodes map[*Node]*DotNodeAttributes // A map allowing each Node to have its own visualization option
+	Graph map[string]string            // Optional extra attributes of the graph, for other tools to read
 }
 
 // DotNodeAttributes contains Node specific visualization options.
@@ -37,6 +39,7 @@ type DotNodeAttributes struct {
 	Peripheries int                    // An optional number of borders to place around a node
 	URL         string                 // An optional url link to add to a node
 	Formatter   func(*NodeInfo) string // An optional formatter for the node's label
+	Metadata    map[string]string      // Optional extra attributes of the node, for other tools to read
 }
 
 // DotConfig contains attributes about how a graph should be
@@ -45,9 +48,13 @@ type DotConfig struct {
 	Title     string   // The title of the DOT graph
 	LegendURL string   // The URL to link to from the legend.
 	Labels    []string // The labels for the DOT's legend
+	Heading   string   // An optional title drawn above the graph
+	Notes     []string // Optional text boxes drawn next to the legend
 
 	FormatValue func(int64) string // A formatting function for values
 	Total       int64              // The total weight of the graph, used to compute percentages
+	MaxNameLen  int                // Maximum length of node names, 0 for no limit
+	Metadata    bool               // Whether to add the raw values of the graph, nodes and edges as attributes
 }
 
 const maxNodelets = 4 // Number of nodelets for labels (both numeric and non)
@@ -114,27 +121,45 @@ func (b *builder) start() {
 	fmt.Fprintln(b, `node [style=filled fillcolor="#f8f8f8"]`)
 }
 
-// finish closes the opening curly bracket in the constructed DOT buffer.
+// finish closes the opening curly bracket in the constructed DOT buffer,
+// after the heading. The heading is set last, so that the legend does not
+// inherit it as its own label.
 func (b *builder) finish() {
+	if b.config.Heading != "" {
+		fmt.Fprintf(b, "label=\"%s\" labelloc=t fontsize=32\n", escapeForDot(b.config.Heading))
+	}
+	if b.config.Metadata {
+		fmt.Fprintf(b, "pprof_total=%d\n", b.config.Total)
+	}
+	if attrs := b.attributes.Graph; len(attrs) > 0 {
+		fmt.Fprintln(b, strings.TrimSpace(extraAttributes(attrs)))
+	}
 	fmt.Fprintln(b, "}")
 }
 
-// addLegend generates a legend in DOT format.
+// addLegend generates a legend in DOT format, with the notes.
 func (b *builder) addLegend() {
 	labels := b.config.Labels
-	if len(labels) == 0 {
+	if len(labels) == 0 && len(b.config.Notes) == 0 {
 		return
 	}
-	title := labels[0]
-	fmt.Fprintf(b, `subgraph cluster_L { "%s" [shape=box fontsize=16`, title)
-	fmt.Fprintf(b, ` label="%s\l"`, strings.Join(escapeAllForDot(labels), `\l`))
-	if b.config.LegendURL != "" {
-		fmt.Fprintf(b, ` URL="%s" target="_blank"`, b.config.LegendURL)
+	fmt.Fprint(b, `subgraph cluster_L {`)
+	if len(labels) > 0 {
+		title := labels[0]
+		fmt.Fprintf(b, ` "%s" [shape=box fontsize=16`, title)
+		fmt.Fprintf(b, ` label="%s\l"`, strings.Join(escapeAllForDot(labels), `\l`))
+		if b.config.LegendURL != "" {
+			fmt.Fprintf(b, ` URL="%s" target="_blank"`, b.config.LegendURL)
+		}
+		if b.config.Title != "" {
+			fmt.Fprintf(b, ` tooltip="%s"`, b.config.Title)
+		}
+		fmt.Fprint(b, "]")
 	}
-	if b.config.Title != "" {
-		fmt.Fprintf(b, ` tooltip="%s"`, b.config.Title)
+	for i, note := range b.config.Notes {
+		fmt.Fprintf(b, ` "note%d" [shape=note fontsize=16 style=filled fillcolor="#fff8c4" label="%s\l"]`, i+1, escapeForDot(note))
 	}
-	fmt.Fprintf(b, "] }\n")
+	fmt.Fprint(b, " }\n")
 }
 
 // addNode generates a graph node in DOT format.
@@ -147,7 +172,7 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 	if attrs != nil && attrs.Formatter != nil {
 		label = attrs.Formatter(&node.Info)
 	} else {
-		label = multilinePrintableName(&node.Info)
+		label = multilinePrintableName(&node.Info, b.config.MaxNameLen)
 	}
 
 	flatValue := b.config.FormatValue(flat)
@@ -209,6 +234,13 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 		}
 	}
 
+	if b.config.Metadata {
+		attr += fmt.Sprintf(` pprof_flat=%d pprof_cum=%d`, flat, cum)
+	}
+	if attrs != nil {
+		attr += extraAttributes(attrs.Metadata)
+	}
+
 	fmt.Fprintf(b, "N%d [%s]\n", nodeID, attr)
 }
 
@@ -318,9 +350,28 @@ func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 		attr = attr + " minlen=2"
 	}
 
+	if b.config.Metadata {
+		attr = fmt.Sprintf(`%s pprof_weight=%d`, attr, edge.WeightValue())
+	}
+
 	fmt.Fprintf(b, "N%d -> N%d [%s]\n", from, to, attr)
 }
 
+// extraAttributes returns the DOT attributes for attrs, in the order of
+// their names, each preceded by a space.
+func extraAttributes(attrs map[string]string) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var attr string
+	for _, name := range names {
+		attr += fmt.Sprintf(` %s="%s"`, name, escapeForDot(attrs[name]))
+	}
+	return attr
+}
+
 // dotColor returns a color for the given score (between -1.0 and
 // 1.0), with -1.0 colored green, 0.0 colored grey, and 1.0 colored
 // red. If isBackground is true, then a light (low-saturation)
@@ -381,9 +432,9 @@ func dotColor(score float64, isBackground bool) string {
 	return fmt.Sprintf("#%02x%02x%02x", uint8(r*255.0), uint8(g*255.0), uint8(b*255.0))
 }
 
-func multilinePrintableName(info *NodeInfo) string {
+func multilinePrintableName(info *NodeInfo, maxNameLen int) string {
 	infoCopy := *info
-	infoCopy.Name = escapeForDot(ShortenFunctionName(infoCopy.Name))
+	infoCopy.Name = escapeForDot(TruncateName(ShortenFunctionName(infoCopy.Name), maxNameLen))
 	infoCopy.Name = strings.Replace(infoCopy.Name, "::", `\n`, -1)
 	infoCopy.Name = strings.Replace(infoCopy.Name, ".", `\n`, -1)
 	if infoCopy.File != "" {
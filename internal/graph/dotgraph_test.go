@@ -150,6 +150,41 @@ func TestComposeWithNamesThatNeedEscaping(t *testing.T) {
 	compareGraphs(t, buf.Bytes(), "compose7.dot")
 }
 
+func TestComposeWithHeadingAndNotes(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+	c.Heading = `Before the "fix"`
+	c.Notes = []string{"Load test at 1k QPS", "Cache disabled\nsee issue 123"}
+
+	var buf bytes.Buffer
+	ComposeDot(&buf, g, a, c)
+
+	compareGraphs(t, buf.Bytes(), "compose8.dot")
+
+	// The notes are drawn even without a legend.
+	c.Labels = nil
+	buf.Reset()
+	ComposeDot(&buf, g, a, c)
+	if want := `subgraph cluster_L { "note1" [shape=note`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got graph without %s:\n%s", want, buf.String())
+	}
+}
+
+func TestComposeWithMetadata(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+	c.Metadata = true
+	a.Graph = map[string]string{"pprof_sample_type": "cpu", "pprof_sample_unit": "nanoseconds"}
+	a.Nodes[g.Nodes[0]] = &DotNodeAttributes{
+		Metadata: map[string]string{"pprof_function_ids": "1,2", "pprof_name": `src "main"`},
+	}
+
+	var buf bytes.Buffer
+	ComposeDot(&buf, g, a, c)
+
+	compareGraphs(t, buf.Bytes(), "compose9.dot")
+}
+
 func baseGraph() *Graph {
 	src := &Node{
 		Info:        NodeInfo{Name: "src"},
@@ -283,7 +318,7 @@ func TestMultilinePrintableName(t *testing.T) {
 	}
 
 	want := fmt.Sprintf(`%016x\ntest1\ntest2\ntest3\nfile.cc:999\n`, 123)
-	if got := multilinePrintableName(ni); got != want {
+	if got := multilinePrintableName(ni, 0); got != want {
 		t.Errorf("multilinePrintableName(%#v) == %q, want %q", ni, got, want)
 	}
 }
@@ -16,6 +16,7 @@
 package graph
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"path/filepath"
@@ -60,9 +61,20 @@ type Options struct {
 	OrigFnNames       bool                       // Preserve original (eg mangled) function names
 
 	CallTree     bool // Build a tree instead of a graph
+	MergeRepeats bool // Merge the consecutive frames of a node in a tree, eg of a file calling itself
 	DropNegative bool // Drop nodes with overall negative values
 
 	KeptNodes NodeSet // If non-nil, only use nodes in this set
+
+	// Context, if non-nil, cancels the construction of the graph: the
+	// samples left once it is done are not added to the graph.
+	Context context.Context
+}
+
+// canceled returns whether the construction of the graph is canceled,
+// checked once every 1024 samples, from the i-th one.
+func (o *Options) canceled(i int) bool {
+	return o.Context != nil && i%1024 == 0 && o.Context.Err() != nil
 }
 
 // Nodes is an ordered collection of graph nodes.
@@ -321,7 +333,10 @@ func newGraph(prof *profile.Profile, o *Options) (*Graph, map[uint64]Nodes) {
 	nodes, locationMap := CreateNodes(prof, o)
 	seenNode := make(map[*Node]bool)
 	seenEdge := make(map[nodePair]bool)
-	for _, sample := range prof.Sample {
+	for i, sample := range prof.Sample {
+		if o.canceled(i) {
+			break
+		}
 		var w, dw int64
 		w = o.SampleValue(sample.Value)
 		if o.SampleMeanDivisor != nil {
@@ -398,7 +413,10 @@ type nodePair struct {
 
 func newTree(prof *profile.Profile, o *Options) (g *Graph) {
 	parentNodeMap := make(map[*Node]NodeMap, len(prof.Sample))
-	for _, sample := range prof.Sample {
+	for i, sample := range prof.Sample {
+		if o.canceled(i) {
+			break
+		}
 		var w, dw int64
 		w = o.SampleValue(sample.Value)
 		if o.SampleMeanDivisor != nil {
@@ -417,6 +435,11 @@ func newTree(prof *profile.Profile, o *Options) (g *Graph) {
 				lines = []profile.Line{{}} // Create empty line to include location info.
 			}
 			for lidx := len(lines) - 1; lidx >= 0; lidx-- {
+				if o.MergeRepeats && parent != nil {
+					if ni := lineNodeInfo(l, lines[lidx], o); ni != nil && *ni == parent.Info {
+						continue
+					}
+				}
 				nodeMap := parentNodeMap[parent]
 				if nodeMap == nil {
 					nodeMap = make(NodeMap)
@@ -457,6 +480,23 @@ func ShortenFunctionName(f string) string {
 	return f
 }
 
+// TruncateName shortens name to at most max characters by replacing
+// its middle with an ellipsis, so that both its prefix (eg, the package
+// or namespace) and its suffix (eg, the function name) remain visible.
+// A max of 0 or less means no limit.
+func TruncateName(name string, max int) string {
+	r := []rune(name)
+	if max <= 0 || len(r) <= max {
+		return name
+	}
+	if max == 1 {
+		return "…"
+	}
+	head := (max - 1) / 2
+	tail := max - 1 - head
+	return string(r[:head]) + "…" + string(r[len(r)-tail:])
+}
+
 // TrimTree trims a Graph in forest form, keeping only the nodes in kept. This
 // will not work correctly if even a single node has multiple parents.
 func (g *Graph) TrimTree(kept NodePtrSet) {
@@ -576,15 +616,19 @@ func (nm NodeMap) nodes() Nodes {
 }
 
 func (nm NodeMap) findOrInsertLine(l *profile.Location, li profile.Line, o *Options) *Node {
+	if ni := lineNodeInfo(l, li, o); ni != nil {
+		return nm.FindOrInsertNode(*ni, o.KeptNodes)
+	}
+	return nil
+}
+
+// lineNodeInfo returns the info of the node of the line li of l.
+func lineNodeInfo(l *profile.Location, li profile.Line, o *Options) *NodeInfo {
 	var objfile string
 	if m := l.Mapping; m != nil && m.File != "" {
 		objfile = m.File
 	}
-
-	if ni := nodeInfo(l, li, objfile, o); ni != nil {
-		return nm.FindOrInsertNode(*ni, o.KeptNodes)
-	}
-	return nil
+	return nodeInfo(l, li, objfile, o)
 }
 
 func nodeInfo(l *profile.Location, line profile.Line, objfile string, o *Options) *NodeInfo {
@@ -16,6 +16,8 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/google/pprof/profile"
@@ -531,3 +533,67 @@ func TestShortenFunctionName(t *testing.T) {
 		}
 	}
 }
+
+func TestTruncateName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		max  int
+		want string
+	}{
+		{"std::vector<int>::push_back", 0, "std::vector<int>::push_back"},
+		{"std::vector<int>::push_back", 27, "std::vector<int>::push_back"},
+		{"std::vector<int>::push_back", 12, "std::…h_back"},
+		{"std::vector<int>::push_back", 1, "…"},
+		{"múltiplo::função", 7, "múl…ção"},
+	} {
+		if got := TruncateName(tc.name, tc.max); got != tc.want {
+			t.Errorf("TruncateName(%q, %d) = %q, want %q", tc.name, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestMergeRepeats(t *testing.T) {
+	// main.go calls itself through two functions before calling util.go,
+	// as a tree of files.
+	functions := []*profile.Function{
+		{ID: 1, Filename: "src/main.go"},
+		{ID: 2, Filename: "src/main.go"},
+		{ID: 3, Filename: "src/util.go"},
+	}
+	var locations []*profile.Location
+	for i, f := range functions {
+		locations = append(locations, &profile.Location{ID: uint64(i + 1), Line: []profile.Line{{Function: f}}})
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "milliseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{locations[2], locations[1], locations[0]}, Value: []int64{10}},
+		},
+		Location: locations,
+		Function: functions,
+	}
+	for _, tc := range []struct {
+		merge bool
+		want  []string
+	}{
+		{false, []string{"src/main.go", "src/main.go", "src/util.go"}},
+		{true, []string{"src/main.go", "src/util.go"}},
+	} {
+		g := New(p, &Options{
+			SampleValue:  func(v []int64) int64 { return v[0] },
+			CallTree:     true,
+			MergeRepeats: tc.merge,
+		})
+		var got []string
+		for _, n := range g.Nodes {
+			if n.Cum != 10 {
+				t.Errorf("merge %v: got cum %d for %s, want 10", tc.merge, n.Cum, n.Info.File)
+			}
+			got = append(got, n.Info.File)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("merge %v: got nodes %v, want %v", tc.merge, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/internal/measurement"
+)
+
+// ComposeMermaid writes the graph as a Mermaid flowchart, which renders
+// in Markdown on GitHub and in many wikis. The nodes and edges are
+// labeled and colored as in ComposeDot. The legend is written as
+// comments, and the heading, if any, as the title of the flowchart.
+func ComposeMermaid(w io.Writer, g *Graph, c *DotConfig) {
+	if c.Heading != "" {
+		fmt.Fprintf(w, "---\ntitle: %q\n---\n", c.Heading)
+	}
+	for _, l := range c.Labels {
+		for _, line := range strings.Split(l, "\n") {
+			fmt.Fprintln(w, strings.TrimSpace("%% "+line))
+		}
+	}
+	fmt.Fprintln(w, "flowchart TD")
+
+	nodeIDMap := make(map[*Node]int)
+	for i, n := range g.Nodes {
+		nodeIDMap[n] = i + 1
+	}
+	edges := EdgeMap{}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "    N%d[\"%s\"]\n", nodeIDMap[n], mermaidNodeLabel(n, c))
+		// Collect all edges. Use a fake node to support multiple incoming edges.
+		for _, e := range n.Out {
+			edges[&Node{}] = e
+		}
+	}
+	for _, e := range edges.Sort() {
+		label := c.FormatValue(e.WeightValue())
+		if e.Inline {
+			label += " (inline)"
+		}
+		arrow := "-->"
+		if e.Residual {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(w, "    N%d %s|\"%s\"| N%d\n", nodeIDMap[e.Src], arrow, escapeForMermaid(label), nodeIDMap[e.Dest])
+	}
+	for _, n := range g.Nodes {
+		score := float64(n.CumValue()) / float64(abs64(c.Total))
+		fmt.Fprintf(w, "    style N%d fill:%s,stroke:%s\n", nodeIDMap[n], dotColor(score, true), dotColor(score, false))
+	}
+}
+
+// mermaidNodeLabel returns the label of a node in a Mermaid flowchart,
+// with its name, location, and flat and cumulative values.
+func mermaidNodeLabel(n *Node, c *DotConfig) string {
+	info := n.Info
+	info.Name = TruncateName(ShortenFunctionName(info.Name), c.MaxNameLen)
+	if info.File != "" {
+		info.File = filepath.Base(info.File)
+	}
+	lines := info.NameComponents()
+
+	flat, cum := n.FlatValue(), n.CumValue()
+	value := "0"
+	if flat != 0 {
+		value = fmt.Sprintf("%s (%s)", c.FormatValue(flat), strings.TrimSpace(measurement.Percentage(flat, c.Total)))
+	}
+	if cum != flat {
+		if flat != 0 {
+			lines = append(lines, value)
+			value = ""
+		} else {
+			value += " "
+		}
+		value += fmt.Sprintf("of %s (%s)", c.FormatValue(cum), strings.TrimSpace(measurement.Percentage(cum, c.Total)))
+	}
+	lines = append(lines, value)
+	for i, l := range lines {
+		lines[i] = escapeForMermaid(l)
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+// escapeForMermaid escapes the characters that Mermaid interprets in
+// quoted text, using its entity codes.
+func escapeForMermaid(s string) string {
+	return strings.NewReplacer(
+		"#", "#35;",
+		`"`, "#quot;",
+		"<", "#lt;",
+		">", "#gt;",
+	).Replace(s)
+}
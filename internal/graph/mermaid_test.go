@@ -0,0 +1,34 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestComposeMermaid(t *testing.T) {
+	g := baseGraph()
+	_, c := baseAttrsAndConfig()
+	c.Heading = `Before the "fix"`
+	g.Nodes[0].Info = NodeInfo{Name: "std::vector<int>::push_back", File: "/src/vector.h", Lineno: 12}
+	g.Nodes[0].Flat = 0
+	g.Nodes[0].Out[g.Nodes[1]].Residual = true
+
+	var buf bytes.Buffer
+	ComposeMermaid(&buf, g, c)
+
+	compareGraphs(t, buf.Bytes(), "compose1.mmd")
+}
@@ -0,0 +1,445 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parquet writes Parquet files, in the format described by
+// https://github.com/apache/parquet-format, without depending on a Parquet
+// library. The files hold a flat schema of required INT64, DOUBLE and
+// string columns, whose values are PLAIN encoded in gzip-compressed data
+// pages, and are written in row groups as rows are added.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is the type of the values of a column.
+type Type int
+
+// Types of columns.
+const (
+	Int64 Type = iota
+	Double
+	String
+)
+
+// Column is a column of the schema of a file.
+type Column struct {
+	Name string
+	Type Type
+}
+
+const (
+	// rowGroupSize is the number of rows of a row group, which readers
+	// process as a unit.
+	rowGroupSize = 1 << 20
+
+	// pageSize is the number of values of a data page.
+	pageSize = 1 << 14
+
+	magic = "PAR1"
+)
+
+// Physical types, encodings and codecs of parquet.thrift.
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageTypeData = 0
+
+	convertedTypeUTF8 = 0
+)
+
+// Writer writes a Parquet file.
+type Writer struct {
+	w       io.Writer
+	offset  int64
+	columns []Column
+	created string
+
+	// The pages of the columns of the current row group, with the values
+	// of their last page yet to be compressed.
+	chunks []chunkBuffer
+	rows   int
+
+	rowGroups []rowGroup
+	numRows   int64
+	err       error
+}
+
+type rowGroup struct {
+	chunks    []columnChunk
+	totalSize int64
+	numRows   int64
+}
+
+type chunkBuffer struct {
+	pages            []byte
+	uncompressedSize int64
+	page             []byte
+	pageValues       int
+}
+
+type columnChunk struct {
+	offset                         int64
+	uncompressedSize, compressSize int64
+	numValues                      int64
+}
+
+// NewWriter returns a writer of a file with the given columns to w, which
+// records createdBy as the application that wrote it.
+func NewWriter(w io.Writer, columns []Column, createdBy string) *Writer {
+	return &Writer{
+		w:       w,
+		columns: columns,
+		created: createdBy,
+		chunks:  make([]chunkBuffer, len(columns)),
+	}
+}
+
+// Write adds a row, with a value per column, of type int64 for Int64
+// columns, float64 for Double columns and string for String columns.
+func (w *Writer) Write(row ...interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("row of %d values for %d columns", len(row), len(w.columns))
+	}
+	for i, v := range row {
+		var ok bool
+		switch w.columns[i].Type {
+		case Int64:
+			_, ok = v.(int64)
+		case Double:
+			_, ok = v.(float64)
+		case String:
+			_, ok = v.(string)
+		}
+		if !ok {
+			return fmt.Errorf("column %s: unexpected value %v of type %T", w.columns[i].Name, v, v)
+		}
+	}
+	for i, v := range row {
+		c := &w.chunks[i]
+		switch v := v.(type) {
+		case int64:
+			c.page = appendUint64(c.page, uint64(v))
+		case float64:
+			c.page = appendUint64(c.page, math.Float64bits(v))
+		case string:
+			var n [4]byte
+			binary.LittleEndian.PutUint32(n[:], uint32(len(v)))
+			c.page = append(c.page, n[:]...)
+			c.page = append(c.page, v...)
+		}
+		if c.pageValues++; c.pageValues == pageSize {
+			if err := c.flushPage(); err != nil {
+				w.err = err
+				return err
+			}
+		}
+	}
+	if w.rows++; w.rows == rowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Close writes the rows left and the footer of the file. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.offset == 0 {
+		w.write([]byte(magic))
+	}
+	if w.rows > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	footer := w.metadata()
+	w.write(footer)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	w.write(size[:])
+	w.write([]byte(magic))
+	return w.err
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	w.err = err
+}
+
+// flush writes the rows of the current row group.
+func (w *Writer) flush() error {
+	if w.offset == 0 {
+		w.write([]byte(magic))
+	}
+	rg := rowGroup{numRows: int64(w.rows)}
+	for i := range w.columns {
+		c := &w.chunks[i]
+		if c.pageValues > 0 {
+			if err := c.flushPage(); err != nil {
+				w.err = err
+				return err
+			}
+		}
+		chunk := columnChunk{
+			offset:           w.offset,
+			uncompressedSize: c.uncompressedSize,
+			compressSize:     int64(len(c.pages)),
+			numValues:        int64(w.rows),
+		}
+		w.write(c.pages)
+		rg.totalSize += chunk.uncompressedSize
+		rg.chunks = append(rg.chunks, chunk)
+		*c = chunkBuffer{pages: c.pages[:0], page: c.page[:0]}
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.numRows += int64(w.rows)
+	w.rows = 0
+	return w.err
+}
+
+// flushPage compresses the values of the last page of c and appends it,
+// with its header, to the pages of c.
+func (c *chunkBuffer) flushPage() error {
+	header, compressed, err := dataPage(c.page, c.pageValues)
+	if err != nil {
+		return err
+	}
+	c.pages = append(c.pages, header...)
+	c.pages = append(c.pages, compressed...)
+	c.uncompressedSize += int64(len(header) + len(c.page))
+	c.page, c.pageValues = c.page[:0], 0
+	return nil
+}
+
+// dataPage returns the header of a data page holding n PLAIN encoded
+// values, and the compressed values.
+func dataPage(data []byte, n int) ([]byte, []byte, error) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if len(data) > math.MaxInt32 || compressed.Len() > math.MaxInt32 {
+		return nil, nil, fmt.Errorf("data page of %d bytes is too large", len(data))
+	}
+
+	// The columns are required, without repetition or definition levels.
+	var e encoder
+	e.i32(1, pageTypeData)
+	e.i32(2, int32(len(data)))
+	e.i32(3, int32(compressed.Len()))
+	e.beginStruct(5)
+	e.i32(1, int32(n))
+	e.i32(2, encodingPlain)
+	e.i32(3, encodingRLE)
+	e.i32(4, encodingRLE)
+	e.endStruct()
+	e.stop()
+	return e.b, compressed.Bytes(), nil
+}
+
+// metadata returns the FileMetaData footer of the file.
+func (w *Writer) metadata() []byte {
+	var e encoder
+	e.i32(1, 1)
+	e.beginList(2, thriftStruct, len(w.columns)+1)
+	e.beginElement()
+	e.binary(4, "schema")
+	e.i32(5, int32(len(w.columns)))
+	e.endElement()
+	for _, c := range w.columns {
+		e.beginElement()
+		e.i32(1, physicalType(c.Type))
+		e.i32(3, 0) // REQUIRED.
+		e.binary(4, c.Name)
+		if c.Type == String {
+			e.i32(6, convertedTypeUTF8)
+			e.beginStruct(10)
+			e.beginStruct(1) // StringType.
+			e.endStruct()
+			e.endStruct()
+		}
+		e.endElement()
+	}
+	e.i64(3, w.numRows)
+	e.beginList(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		e.beginElement()
+		e.beginList(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			c := w.columns[i]
+			e.beginElement()
+			e.i64(2, chunk.offset)
+			e.beginStruct(3)
+			e.i32(1, physicalType(c.Type))
+			e.beginList(2, thriftI32, 2)
+			e.varint(zigzag(encodingPlain))
+			e.varint(zigzag(encodingRLE))
+			e.beginList(3, thriftBinary, 1)
+			e.string(c.Name)
+			e.i32(4, codecGzip)
+			e.i64(5, chunk.numValues)
+			e.i64(6, chunk.uncompressedSize)
+			e.i64(7, chunk.compressSize)
+			e.i64(9, chunk.offset)
+			e.endStruct()
+			e.endElement()
+		}
+		e.i64(2, rg.totalSize)
+		e.i64(3, rg.numRows)
+		e.endElement()
+	}
+	if w.created != "" {
+		e.binary(6, w.created)
+	}
+	e.stop()
+	return e.b
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Double:
+		return typeDouble
+	case String:
+		return typeByteArray
+	}
+	return typeInt64
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// encoder encodes Thrift structs in the compact protocol.
+type encoder struct {
+	b []byte
+	// last is the ID of the last field of the current struct, and ids
+	// those of the structs it is nested in.
+	last int
+	ids  []int
+}
+
+func (e *encoder) field(id, typ int) {
+	if delta := id - e.last; delta > 0 && delta <= 15 {
+		e.b = append(e.b, byte(delta<<4|typ))
+	} else {
+		e.b = append(e.b, byte(typ))
+		e.varint(zigzag(int64(id)))
+	}
+	e.last = id
+}
+
+func (e *encoder) i32(id int, v int32) {
+	e.field(id, thriftI32)
+	e.varint(zigzag(int64(v)))
+}
+
+func (e *encoder) i64(id int, v int64) {
+	e.field(id, thriftI64)
+	e.varint(zigzag(v))
+}
+
+func (e *encoder) binary(id int, s string) {
+	e.field(id, thriftBinary)
+	e.string(s)
+}
+
+func (e *encoder) string(s string) {
+	e.varint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// beginList starts a list field of n elements of type typ, which follow
+// it, within beginElement and endElement calls for structs.
+func (e *encoder) beginList(id, typ, n int) {
+	e.field(id, thriftList)
+	if n < 15 {
+		e.b = append(e.b, byte(n<<4|typ))
+	} else {
+		e.b = append(e.b, byte(0xf0|typ))
+		e.varint(uint64(n))
+	}
+}
+
+func (e *encoder) beginStruct(id int) {
+	e.field(id, thriftStruct)
+	e.beginElement()
+}
+
+func (e *encoder) endStruct() {
+	e.endElement()
+}
+
+func (e *encoder) beginElement() {
+	e.ids = append(e.ids, e.last)
+	e.last = 0
+}
+
+func (e *encoder) endElement() {
+	e.stop()
+	e.last = e.ids[len(e.ids)-1]
+	e.ids = e.ids[:len(e.ids)-1]
+}
+
+func (e *encoder) stop() {
+	e.b = append(e.b, 0)
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.b = append(e.b, byte(v)|0x80)
+		v >>= 7
+	}
+	e.b = append(e.b, byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "name", Type: String},
+		{Name: "ratio", Type: Double},
+	}
+	// Enough rows for several pages and row groups.
+	const rows = rowGroupSize + 3*pageSize + 5
+	row := func(i int) []interface{} {
+		return []interface{}{int64(i) - 1<<40, fmt.Sprint("f", i%100), float64(i) / 4}
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, columns, "pprof")
+	for i := 0; i < rows; i++ {
+		if err := w.Write(row(i)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(int64(1), 2, 3.0); err == nil {
+		t.Error("want error writing a value of the wrong type")
+	}
+	if err := w.Write(int64(1)); err == nil {
+		t.Error("want error writing a row without a value per column")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	meta := footer(t, data)
+	if got := meta[3]; got != int64(rows) {
+		t.Errorf("got %v rows, want %d", got, rows)
+	}
+	if got := meta[6]; got != "pprof" {
+		t.Errorf("got created_by %v, want pprof", got)
+	}
+	schema := meta[2].([]interface{})
+	if got := schema[0].(map[int]interface{})[5]; got != int64(len(columns)) {
+		t.Errorf("got %v children of the schema root, want %d", got, len(columns))
+	}
+	for i, c := range columns {
+		e := schema[i+1].(map[int]interface{})
+		if e[4] != c.Name || e[1] != int64(physicalType(c.Type)) || e[3] != int64(0) {
+			t.Errorf("got schema element %v for column %v", e, c)
+		}
+	}
+
+	rowGroups := meta[4].([]interface{})
+	if len(rowGroups) != 2 {
+		t.Fatalf("got %d row groups, want 2", len(rowGroups))
+	}
+	got := make([][]interface{}, len(columns))
+	for _, rg := range rowGroups {
+		for i, c := range rg.(map[int]interface{})[1].([]interface{}) {
+			md := c.(map[int]interface{})[3].(map[int]interface{})
+			if path := md[3].([]interface{}); !reflect.DeepEqual(path, []interface{}{columns[i].Name}) {
+				t.Errorf("got path %v for column %s", path, columns[i].Name)
+			}
+			got[i] = append(got[i], readChunk(t, data, columns[i].Type, md)...)
+		}
+	}
+	for i := 0; i < rows; i++ {
+		want := row(i)
+		for j := range columns {
+			if got[j][i] != want[j] {
+				t.Fatalf("row %d: got %v in column %s, want %v", i, got[j][i], columns[j].Name, want[j])
+			}
+		}
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []Column{{Name: "id", Type: Int64}}, "")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta := footer(t, buf.Bytes())
+	if meta[3] != int64(0) || len(meta[4].([]interface{})) != 0 {
+		t.Errorf("got metadata %v, want no rows", meta)
+	}
+}
+
+// footer returns the FileMetaData of a file.
+func footer(t *testing.T, data []byte) map[int]interface{} {
+	t.Helper()
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatalf("missing magic in %q...%q", data[:4], data[len(data)-4:])
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	d := &decoder{b: data[len(data)-8-size : len(data)-8]}
+	meta := d.structure()
+	if len(d.b) != 0 {
+		t.Fatalf("%d bytes left after the footer", len(d.b))
+	}
+	return meta
+}
+
+// readChunk returns the values of a column chunk, with the given metadata.
+func readChunk(t *testing.T, data []byte, typ Type, md map[int]interface{}) []interface{} {
+	t.Helper()
+	if md[4] != int64(codecGzip) {
+		t.Fatalf("got codec %v, want gzip", md[4])
+	}
+	start := md[9].(int64)
+	d := &decoder{b: data[start : start+md[7].(int64)]}
+	var values []interface{}
+	for len(d.b) > 0 {
+		h := d.structure()
+		dh := h[5].(map[int]interface{})
+		if h[1] != int64(pageTypeData) || dh[2] != int64(encodingPlain) {
+			t.Fatalf("unexpected page header %v", h)
+		}
+		n := int(h[3].(int64))
+		zr, err := gzip.NewReader(bytes.NewReader(d.b[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.b = d.b[n:]
+		if int64(len(page)) != h[2] {
+			t.Fatalf("got page of %d bytes, want %v", len(page), h[2])
+		}
+		for i := int64(0); i < dh[1].(int64); i++ {
+			switch typ {
+			case Int64:
+				values = append(values, int64(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case Double:
+				values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case String:
+				n := binary.LittleEndian.Uint32(page)
+				values = append(values, string(page[4:4+n]))
+				page = page[4+n:]
+			}
+		}
+		if len(page) != 0 {
+			t.Fatalf("%d bytes left in page", len(page))
+		}
+	}
+	if int64(len(values)) != md[5] {
+		t.Fatalf("got %d values, want %v", len(values), md[5])
+	}
+	return values
+}
+
+// decoder decodes Thrift structs in the compact protocol, as maps from
+// field IDs to int64, string, []interface{} or map[int]interface{} values.
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) structure() map[int]interface{} {
+	s := make(map[int]interface{})
+	id := 0
+	for {
+		h := d.b[0]
+		d.b = d.b[1:]
+		if h == 0 {
+			return s
+		}
+		if delta := int(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int(unzigzag(d.varint()))
+		}
+		s[id] = d.value(int(h & 0x0f))
+	}
+}
+
+func (d *decoder) value(typ int) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 3:
+		v := int64(int8(d.b[0]))
+		d.b = d.b[1:]
+		return v
+	case 4, thriftI32, thriftI64:
+		return unzigzag(d.varint())
+	case thriftBinary:
+		n := d.varint()
+		v := string(d.b[:n])
+		d.b = d.b[n:]
+		return v
+	case thriftList:
+		h := d.b[0]
+		d.b = d.b[1:]
+		n := uint64(h >> 4)
+		if n == 15 {
+			n = d.varint()
+		}
+		l := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			l = append(l, d.value(int(h&0x0f)))
+		}
+		return l
+	case thriftStruct:
+		return d.structure()
+	}
+	panic(fmt.Sprintf("unsupported type %d", typ))
+}
+
+func (d *decoder) varint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
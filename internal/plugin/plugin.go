@@ -16,6 +16,7 @@
 package plugin
 
 import (
+	"crypto/tls"
 	"io"
 	"net/http"
 	"regexp"
@@ -43,6 +44,71 @@ type Options struct {
 	// authentication checks.
 	HTTPServer    func(args *HTTPServerArgs) error
 	HTTPTransport http.RoundTripper
+
+	// RequestDecorator, if non-nil, is called on each outbound HTTP
+	// request of pprof, such as the fetches of profiles and the symbolz
+	// requests, before it is sent through HTTPTransport. It can modify
+	// the request, eg to add authentication headers with freshly refreshed
+	// tokens or to sign it, or fail it by returning an error.
+	RequestDecorator func(*http.Request) error
+
+	// HTTPMiddleware, if non-nil, wraps each handler of the web interface,
+	// including those served by a custom HTTPServer. It can check the
+	// authentication of the requests, eg the session of an SSO or OIDC
+	// proxy, and reject them before they reach pprof.
+	HTTPMiddleware func(http.Handler) http.Handler
+
+	// Telemetry, if non-nil, records the use of pprof, for embedders
+	// measuring the adoption of their profiling workflows. pprof never
+	// sends it anywhere itself.
+	Telemetry Telemetry
+
+	// WebViews are the views added to the web interface by the program
+	// embedding pprof, served next to those of pprof and listed in its
+	// View menu.
+	WebViews []WebView
+}
+
+// WebView is a view of the web interface added by a program embedding
+// pprof, such as the cost of the profile in dollars or the runbooks of
+// its functions, shown in the pages of pprof without changing its assets.
+type WebView struct {
+	// Name is the path of the view under the root of the web interface,
+	// eg "cost" to serve it at ./cost. It is made of lowercase letters,
+	// digits, '-' and '_', and differs from the paths of pprof.
+	Name string
+
+	// Title is the label of the view in the View menu, and Help its
+	// tooltip.
+	Title string
+	Help  string
+
+	// Template is the html/template of the body of the view, shown below
+	// the header of pprof. It is executed with the value returned by Data.
+	Template string
+
+	// Data, if non-nil, returns the value the template is executed with,
+	// from the profile of the view and the request for it. The profile is
+	// a copy of that of the tab browsed, with the options of the request,
+	// eg the focus and the sample index, applied. An error fails the view.
+	Data func(p *profile.Profile, req *http.Request) (interface{}, error)
+}
+
+// Telemetry records how pprof is used, anonymously: it is given the
+// names of the flags, reports and views used, never their values nor
+// anything of the profiles, and the durations of the operations of pprof.
+type Telemetry interface {
+	// Event records a use of the feature name of the given kind: "flag"
+	// for the flags set on the command line, eg "base", "report" for the
+	// reports generated, eg "top", and "view" for the pages of the web
+	// interface served, eg "/flamegraph".
+	Event(kind, name string)
+
+	// Duration records how long the operation took: "fetch" for the
+	// fetch of the profiles, "symbolize" for their symbolization, the
+	// name of a report, eg "report top", for its generation, and that of
+	// a view, eg "view /flamegraph", for serving it.
+	Duration(operation string, d time.Duration)
 }
 
 // Writer provides a mechanism to write data under a certain name,
@@ -211,4 +277,9 @@ type HTTPServerArgs struct {
 	// Handlers maps from URL paths to the handler to invoke to
 	// serve that path.
 	Handlers map[string]http.Handler
+
+	// TLSConfig, if non-nil, holds the certificate the web interface is
+	// served with over HTTPS, from the -http_cert or -http_self_signed
+	// flags.
+	TLSConfig *tls.Config
 }
@@ -0,0 +1,209 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to print the callers and callees of
+// functions in the shape of the call hierarchy of the Language Server
+// Protocol, for IDE plugins showing profiles in their call hierarchy
+// views.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+
+	"github.com/google/pprof/internal/graph"
+)
+
+// lspFunctionKind is the LSP SymbolKind of functions.
+const lspFunctionKind = 12
+
+// callHierarchy is the call hierarchy report of a profile, with the
+// functions selected by the report symbol.
+type callHierarchy struct {
+	SampleType string               `json:"sampleType"`
+	Unit       string               `json:"unit"`
+	Total      float64              `json:"total"`
+	Items      []callHierarchyEntry `json:"items"`
+}
+
+// callHierarchyEntry is a selected function, with the calls to and from
+// it as returned by the LSP callHierarchy/incomingCalls and
+// callHierarchy/outgoingCalls requests.
+type callHierarchyEntry struct {
+	Item          *callHierarchyItem  `json:"item"`
+	IncomingCalls []callHierarchyCall `json:"incomingCalls"`
+	OutgoingCalls []callHierarchyCall `json:"outgoingCalls"`
+}
+
+// callHierarchyItem is an LSP CallHierarchyItem, with the values of the
+// function in the unit of the report.
+type callHierarchyItem struct {
+	Name           string   `json:"name"`
+	Kind           int      `json:"kind"`
+	Detail         string   `json:"detail,omitempty"`
+	URI            string   `json:"uri"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+	Flat           float64  `json:"flat"`
+	FlatPercent    float64  `json:"flatPercent"`
+	Cum            float64  `json:"cum"`
+	CumPercent     float64  `json:"cumPercent"`
+}
+
+// callHierarchyCall is an LSP CallHierarchyIncomingCall, with From set,
+// or CallHierarchyOutgoingCall, with To set, with the weight of the
+// calls. The profile does not record the ranges of the calls.
+type callHierarchyCall struct {
+	From       *callHierarchyItem `json:"from,omitempty"`
+	To         *callHierarchyItem `json:"to,omitempty"`
+	FromRanges []lspRange         `json:"fromRanges"`
+	Weight     float64            `json:"weight"`
+	Percent    float64            `json:"percent"`
+	Inline     bool               `json:"inline,omitempty"`
+}
+
+// lspRange is an LSP Range, with zero based lines.
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// printCallHierarchy prints the callers and callees of the functions
+// matching the report symbol, as a JSON object.
+func printCallHierarchy(w io.Writer, rpt *Report) error {
+	o := rpt.options
+	g, _, _, _ := rpt.newTrimmedGraph()
+	rpt.selectOutputUnit(g)
+
+	// The start lines of the functions, which the nodes of functions
+	// do not keep.
+	type function struct{ name, file string }
+	startLines := make(map[function]int)
+	for _, f := range rpt.prof.Function {
+		startLines[function{f.Name, f.Filename}] = int(f.StartLine)
+	}
+
+	total, unit := rpt.plainValue(rpt.total)
+	percent := func(v int64) float64 {
+		if rpt.total == 0 {
+			return 0
+		}
+		return 100 * float64(v) / float64(rpt.total)
+	}
+	value := func(v int64) float64 {
+		f, _ := rpt.plainValue(v)
+		return f
+	}
+	items := make(map[*graph.Node]*callHierarchyItem)
+	item := func(n *graph.Node) *callHierarchyItem {
+		if it := items[n]; it != nil {
+			return it
+		}
+		info := n.Info
+		name := info.Name
+		if name == "" {
+			name = info.PrintableName()
+		}
+		line := info.Lineno
+		if line == 0 {
+			line = startLines[function{info.Name, info.File}]
+		}
+		var r lspRange
+		if line > 0 {
+			r.Start.Line, r.End.Line = line-1, line-1
+		}
+		it := &callHierarchyItem{
+			Name:           name,
+			Kind:           lspFunctionKind,
+			URI:            fileURI(info.File),
+			Range:          r,
+			SelectionRange: r,
+			Flat:           value(n.FlatValue()),
+			FlatPercent:    percent(n.FlatValue()),
+			Cum:            value(n.CumValue()),
+			CumPercent:     percent(n.CumValue()),
+		}
+		if info.File != "" {
+			it.Detail = filepath.Base(info.File)
+			if line > 0 {
+				it.Detail += fmt.Sprintf(":%d", line)
+			}
+		}
+		items[n] = it
+		return it
+	}
+
+	out := &callHierarchy{
+		SampleType: o.SampleType,
+		Unit:       unit,
+		Total:      total,
+		Items:      []callHierarchyEntry{},
+	}
+	for _, n := range g.Nodes {
+		if o.Symbol != nil && !o.Symbol.MatchString(n.Info.PrintableName()) {
+			continue
+		}
+		e := callHierarchyEntry{
+			Item:          item(n),
+			IncomingCalls: []callHierarchyCall{},
+			OutgoingCalls: []callHierarchyCall{},
+		}
+		call := func(edge *graph.Edge) callHierarchyCall {
+			return callHierarchyCall{
+				FromRanges: []lspRange{},
+				Weight:     value(edge.WeightValue()),
+				Percent:    percent(edge.WeightValue()),
+				Inline:     edge.Inline,
+			}
+		}
+		for _, edge := range n.In.Sort() {
+			c := call(edge)
+			c.From = item(edge.Src)
+			e.IncomingCalls = append(e.IncomingCalls, c)
+		}
+		for _, edge := range n.Out.Sort() {
+			c := call(edge)
+			c.To = item(edge.Dest)
+			e.OutgoingCalls = append(e.OutgoingCalls, c)
+		}
+		out.Items = append(out.Items, e)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// fileURI returns the file URI of an absolute path, or a relative path
+// as a relative URI reference, to be resolved by the IDE against the
+// root of its workspace.
+func fileURI(path string) string {
+	if path == "" {
+		return ""
+	}
+	u := &url.URL{Path: filepath.ToSlash(path)}
+	if filepath.IsAbs(path) {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestCallHierarchy(t *testing.T) {
+	f := []*profile.Function{
+		{ID: 1, Name: "main", Filename: "/src/main.go", StartLine: 10},
+		{ID: 2, Name: "parse", Filename: "/src/parse.go", StartLine: 20},
+		{ID: 3, Name: "scan", Filename: "lex/scan.go", StartLine: 30},
+		{ID: 4, Name: "alloc"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Line: []profile.Line{{Function: f[0], Line: 12}}},
+		{ID: 2, Line: []profile.Line{{Function: f[1], Line: 25}}},
+		{ID: 3, Line: []profile.Line{{Function: f[2], Line: 31}}},
+		{ID: 4, Line: []profile.Line{{Function: f[3]}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[2], l[1], l[0]}, Value: []int64{3000}},
+			{Location: []*profile.Location{l[3], l[1], l[0]}, Value: []int64{1000}},
+			{Location: []*profile.Location{l[1], l[0]}, Value: []int64{4000}},
+			{Location: []*profile.Location{l[0]}, Value: []int64{2000}},
+		},
+		Location: l,
+		Function: f,
+	}
+	// Aggregate by function and file, as pprof does for callhierarchy.
+	if err := p.Aggregate(true, true, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+	rpt := New(p, &Options{
+		OutputFormat: CallHierarchy,
+		OutputUnit:   "minimum",
+		Symbol:       regexp.MustCompile("^parse "),
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleType:   "cpu",
+		SampleUnit:   "nanoseconds",
+	})
+
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var got callHierarchy
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %v:\n%s", err, buf.String())
+	}
+
+	at := func(line int) lspRange {
+		return lspRange{Start: lspPosition{Line: line}, End: lspPosition{Line: line}}
+	}
+	item := func(name, detail, uri string, r lspRange, flat, cum float64) *callHierarchyItem {
+		return &callHierarchyItem{
+			Name: name, Kind: lspFunctionKind, Detail: detail, URI: uri, Range: r, SelectionRange: r,
+			Flat: flat, FlatPercent: flat * 10, Cum: cum, CumPercent: cum * 10,
+		}
+	}
+	parse := item("parse", "parse.go:20", "file:///src/parse.go", at(19), 4, 8)
+	want := callHierarchy{
+		SampleType: "cpu",
+		Unit:       "us",
+		Total:      10,
+		Items: []callHierarchyEntry{{
+			Item: parse,
+			IncomingCalls: []callHierarchyCall{
+				{From: item("main", "main.go:10", "file:///src/main.go", at(9), 2, 10), FromRanges: []lspRange{}, Weight: 8, Percent: 80},
+			},
+			OutgoingCalls: []callHierarchyCall{
+				{To: item("scan", "scan.go:30", "lex/scan.go", at(29), 3, 3), FromRanges: []lspRange{}, Weight: 3, Percent: 30},
+				{To: item("alloc", "", "", lspRange{}, 1, 1), FromRanges: []lspRange{}, Weight: 1, Percent: 10},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got call hierarchy:\n%s\nwant %+v", buf.String(), want)
+	}
+}
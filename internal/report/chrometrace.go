@@ -0,0 +1,201 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to export profiles on the Chrome Trace
+// Event format, which can be loaded into chrome://tracing and Perfetto.
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// threadLabels are the sample labels identifying the thread that recorded
+// a sample, in order of preference.
+var threadLabels = []string{"thread_name", "thread", "thread_id", "tid"}
+
+// TimestampLabel is the numeric sample label holding the time a sample
+// was recorded at.
+const TimestampLabel = "timestamp"
+
+type traceFile struct {
+	TraceEvents     []*traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+type traceEvent struct {
+	Name string            `json:"name"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// traceThread holds the timeline of the samples of a thread.
+type traceThread struct {
+	tid     int
+	samples []*traceSample
+}
+
+type traceSample struct {
+	start, dur float64 // In microseconds
+	hasStart   bool
+	frames     []string // From the root to the leaf
+}
+
+// printChromeTrace prints the samples of a profile as Chrome trace events.
+// Samples are laid out on one timeline per thread, at their timestamp if
+// known or otherwise one after another, lasting for their value. Frames
+// shared by consecutive samples are merged into a single event.
+func printChromeTrace(w io.Writer, rpt *Report) error {
+	prof := rpt.prof
+	o := rpt.options
+
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	threads := make(map[string]*traceThread)
+	var threadNames []string
+	for _, s := range prof.Sample {
+		v := o.SampleValue(s.Value)
+		if o.SampleMeanDivisor != nil {
+			if d := o.SampleMeanDivisor(s.Value); d != 0 {
+				v = v / d
+			}
+		}
+		if v <= 0 {
+			continue
+		}
+		ts := &traceSample{dur: traceMicros(v, o.SampleUnit)}
+		if t := s.NumLabel[TimestampLabel]; len(t) > 0 {
+			unit := o.NumLabelUnits[TimestampLabel]
+			if unit == "" {
+				unit = "nanoseconds"
+			}
+			ts.start, ts.hasStart = traceMicros(t[0], unit), true
+		}
+		for _, loc := range s.Location {
+			for _, n := range locations[loc.ID] {
+				ts.frames = append(ts.frames, n.Info.PrintableName())
+			}
+		}
+		for i, j := 0, len(ts.frames)-1; i < j; i, j = i+1, j-1 {
+			ts.frames[i], ts.frames[j] = ts.frames[j], ts.frames[i]
+		}
+
+		name := sampleThread(s)
+		t := threads[name]
+		if t == nil {
+			t = &traceThread{tid: len(threads) + 1}
+			threads[name] = t
+			threadNames = append(threadNames, name)
+		}
+		t.samples = append(t.samples, ts)
+	}
+
+	file := &traceFile{
+		TraceEvents:     []*traceEvent{},
+		DisplayTimeUnit: "ns",
+	}
+	for _, name := range threadNames {
+		t := threads[name]
+		if name != "" {
+			file.TraceEvents = append(file.TraceEvents, &traceEvent{
+				Name: "thread_name", Ph: "M", Pid: 1, Tid: t.tid,
+				Args: map[string]string{"name": name},
+			})
+		}
+		file.TraceEvents = append(file.TraceEvents, t.events()...)
+	}
+	return json.NewEncoder(w).Encode(file)
+}
+
+// events returns the complete events for the samples of t.
+func (t *traceThread) events() []*traceEvent {
+	timed := true
+	for _, s := range t.samples {
+		timed = timed && s.hasStart
+	}
+	if timed {
+		sort.SliceStable(t.samples, func(i, j int) bool {
+			return t.samples[i].start < t.samples[j].start
+		})
+	}
+
+	var events []*traceEvent
+	type open struct {
+		name  string
+		start float64
+	}
+	var stack []open
+	var now float64
+	closeFrames := func(depth int) {
+		for len(stack) > depth {
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			events = append(events, &traceEvent{
+				Name: f.name, Ph: "X", Ts: f.start, Dur: now - f.start, Pid: 1, Tid: t.tid,
+			})
+		}
+	}
+	for _, s := range t.samples {
+		if s.hasStart && s.start > now {
+			// Samples separated by a gap do not share frames.
+			closeFrames(0)
+			now = s.start
+		}
+		common := 0
+		for common < len(stack) && common < len(s.frames) && stack[common].name == s.frames[common] {
+			common++
+		}
+		closeFrames(common)
+		for _, f := range s.frames[common:] {
+			stack = append(stack, open{f, now})
+		}
+		now += s.dur
+	}
+	closeFrames(0)
+	return events
+}
+
+// sampleThread returns the name of the thread that recorded s, or the
+// empty string if unknown.
+func sampleThread(s *profile.Sample) string {
+	for _, key := range threadLabels {
+		if v := s.Label[key]; len(v) > 0 {
+			return v[0]
+		}
+		if v := s.NumLabel[key]; len(v) > 0 {
+			return strconv.FormatInt(v[0], 10)
+		}
+	}
+	return ""
+}
+
+// traceMicros converts a value to microseconds, the time unit of trace
+// events. Values of other than time units are used as is.
+func traceMicros(v int64, unit string) float64 {
+	// Scale returns the canonical "us" unit only for time units.
+	if us, u := measurement.Scale(v, unit, "microseconds"); u == "us" {
+		return us
+	}
+	return float64(v)
+}
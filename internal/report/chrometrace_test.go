@@ -0,0 +1,83 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestChromeTrace(t *testing.T) {
+	f := []*profile.Function{
+		{ID: 1, Name: "main"},
+		{ID: 2, Name: "work"},
+		{ID: 3, Name: "idle"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Line: []profile.Line{{Function: f[0]}}},
+		{ID: 2, Line: []profile.Line{{Function: f[1]}}},
+		{ID: 3, Line: []profile.Line{{Function: f[2]}}},
+	}
+	sample := func(thread string, ts, v int64, locs ...*profile.Location) *profile.Sample {
+		return &profile.Sample{
+			Location: locs,
+			Value:    []int64{v},
+			Label:    map[string][]string{"thread": {thread}},
+			NumLabel: map[string][]int64{"timestamp": {ts}},
+		}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			sample("worker", 3000, 1000, l[1], l[0]),
+			sample("worker", 2000, 1000, l[1], l[0]),
+			sample("worker", 10000, 1000, l[2], l[0]),
+			sample("io", 0, 5000, l[2]),
+		},
+		Location: l,
+		Function: f,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: ChromeTrace,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleUnit:   "nanoseconds",
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var got traceFile
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("invalid trace: %v", err)
+	}
+	want := []*traceEvent{
+		{Name: "thread_name", Ph: "M", Pid: 1, Tid: 1, Args: map[string]string{"name": "worker"}},
+		{Name: "work", Ph: "X", Ts: 2, Dur: 2, Pid: 1, Tid: 1},
+		{Name: "main", Ph: "X", Ts: 2, Dur: 2, Pid: 1, Tid: 1},
+		{Name: "idle", Ph: "X", Ts: 10, Dur: 1, Pid: 1, Tid: 1},
+		{Name: "main", Ph: "X", Ts: 10, Dur: 1, Pid: 1, Tid: 1},
+		{Name: "thread_name", Ph: "M", Pid: 1, Tid: 2, Args: map[string]string{"name": "io"}},
+		{Name: "idle", Ph: "X", Ts: 0, Dur: 5, Pid: 1, Tid: 2},
+	}
+	if !reflect.DeepEqual(got.TraceEvents, want) {
+		g, _ := json.Marshal(got.TraceEvents)
+		w, _ := json.Marshal(want)
+		t.Errorf("got events\n%s\nwant\n%s", g, w)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to detect and disambiguate distinct
+// functions that share a name, such as static functions in different
+// translation units, so that their costs are not merged in reports.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// Collision describes a function name shared by functions from
+// different source files or binaries.
+type Collision struct {
+	Name     string
+	Variants []*CollisionVariant
+}
+
+// CollisionVariant is one of the distinct functions sharing a name.
+type CollisionVariant struct {
+	Name    string // Disambiguated name
+	File    string // Source file of the function, if known
+	Objfile string // Binary containing the function, if known
+
+	functions []*profile.Function
+}
+
+// variantKey identifies a distinct function with a given name.
+type variantKey struct {
+	name, file, objfile string
+}
+
+// FindCollisions returns the function names in p that are shared by distinct
+// functions, sorted by name.
+func FindCollisions(p *profile.Profile) []*Collision {
+	variants := make(map[variantKey]*CollisionVariant)
+	byName := make(map[string][]*CollisionVariant)
+	seen := make(map[*profile.Function]bool)
+	for _, l := range p.Location {
+		var objfile string
+		if l.Mapping != nil {
+			objfile = l.Mapping.File
+		}
+		for _, ln := range l.Line {
+			f := ln.Function
+			if f == nil || f.Name == "" || seen[f] {
+				continue
+			}
+			seen[f] = true
+			k := variantKey{f.Name, filepath.Clean(f.Filename), objfile}
+			if f.Filename == "" {
+				k.file = ""
+			}
+			v := variants[k]
+			if v == nil {
+				v = &CollisionVariant{File: k.file, Objfile: k.objfile}
+				variants[k] = v
+				byName[f.Name] = append(byName[f.Name], v)
+			}
+			v.functions = append(v.functions, f)
+		}
+	}
+
+	var collisions []*Collision
+	for name, vs := range byName {
+		if len(vs) < 2 {
+			continue
+		}
+		collisions = append(collisions, &Collision{Name: name, Variants: vs})
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Name < collisions[j].Name
+	})
+	for _, c := range collisions {
+		c.nameVariants()
+	}
+	return collisions
+}
+
+// nameVariants sets the disambiguated names of the variants of c, which
+// include the components that differ across variants: the source file,
+// the binary, or both.
+func (c *Collision) nameVariants() {
+	files := make(map[string]bool)
+	fileBases := make(map[string]bool)
+	objfiles := make(map[string]bool)
+	for _, v := range c.Variants {
+		files[v.File] = true
+		fileBases[filepath.Base(v.File)] = true
+		objfiles[v.Objfile] = true
+	}
+	for _, v := range c.Variants {
+		v.Name = c.Name
+		if len(files) > 1 {
+			file := filepath.Base(v.File)
+			if len(fileBases) < len(files) {
+				file = v.File
+			}
+			if v.File == "" {
+				file = "?"
+			}
+			v.Name += " (" + file + ")"
+		}
+		if len(objfiles) > 1 {
+			obj := filepath.Base(v.Objfile)
+			if v.Objfile == "" {
+				obj = "?"
+			}
+			v.Name += " [" + obj + "]"
+		}
+	}
+	sort.Slice(c.Variants, func(i, j int) bool {
+		return c.Variants[i].Name < c.Variants[j].Name
+	})
+}
+
+// DisambiguateFunctions renames the functions in p that share a name with
+// a distinct function, so that they are reported separately.
+func DisambiguateFunctions(p *profile.Profile) {
+	for _, c := range FindCollisions(p) {
+		for _, v := range c.Variants {
+			for _, f := range v.functions {
+				f.Name = v.Name
+			}
+		}
+	}
+}
+
+// printCollisions prints the function names shared by distinct functions,
+// along with the flat and cumulative values of each of them.
+func printCollisions(w io.Writer, rpt *Report) error {
+	prof := rpt.prof
+	o := rpt.options
+
+	collisions := FindCollisions(prof)
+	if len(collisions) == 0 {
+		fmt.Fprintln(w, "No function name collisions found")
+		return nil
+	}
+
+	variant := make(map[*profile.Function]*CollisionVariant)
+	for _, c := range collisions {
+		for _, v := range c.Variants {
+			for _, f := range v.functions {
+				variant[f] = v
+			}
+		}
+	}
+	flat := make(map[*CollisionVariant]int64)
+	cum := make(map[*CollisionVariant]int64)
+	for _, s := range prof.Sample {
+		value := o.SampleValue(s.Value)
+		seen := make(map[*CollisionVariant]bool)
+		for i, l := range s.Location {
+			for j, ln := range l.Line {
+				v := variant[ln.Function]
+				if v == nil {
+					continue
+				}
+				if i == 0 && j == 0 {
+					flat[v] += value
+				}
+				if !seen[v] {
+					seen[v] = true
+					cum[v] += value
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%10s %5s%% %10s %5s%%\n", "flat", "flat", "cum", "cum")
+	for _, c := range collisions {
+		fmt.Fprintf(w, "%s: %d distinct functions\n", c.Name, len(c.Variants))
+		for _, v := range c.Variants {
+			fmt.Fprintf(w, "%10s %s %10s %s    %s\n",
+				rpt.formatValue(flat[v]), measurement.Percentage(flat[v], rpt.total),
+				rpt.formatValue(cum[v]), measurement.Percentage(cum[v], rpt.total),
+				v.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func collisionProfile() *profile.Profile {
+	m1 := &profile.Mapping{ID: 1, File: "/bin/main"}
+	m2 := &profile.Mapping{ID: 2, File: "/lib/libfoo.so"}
+	f := []*profile.Function{
+		{ID: 1, Name: "main", Filename: "main.c"},
+		{ID: 2, Name: "helper", Filename: "a/util.c"},
+		{ID: 3, Name: "helper", Filename: "b/util.c"},
+		{ID: 4, Name: "init", Filename: "init.c"},
+		{ID: 5, Name: "init", Filename: "init.c"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Mapping: m1, Line: []profile.Line{{Function: f[0]}}},
+		{ID: 2, Mapping: m1, Line: []profile.Line{{Function: f[1]}}},
+		{ID: 3, Mapping: m1, Line: []profile.Line{{Function: f[2]}}},
+		{ID: 4, Mapping: m1, Line: []profile.Line{{Function: f[3]}}},
+		{ID: 5, Mapping: m2, Line: []profile.Line{{Function: f[4]}}},
+	}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[1], l[0]}, Value: []int64{10}},
+			{Location: []*profile.Location{l[2], l[0]}, Value: []int64{20}},
+			{Location: []*profile.Location{l[3], l[0]}, Value: []int64{30}},
+			{Location: []*profile.Location{l[4], l[0]}, Value: []int64{40}},
+		},
+		Location: l,
+		Function: f,
+		Mapping:  []*profile.Mapping{m1, m2},
+	}
+}
+
+func TestDisambiguateFunctions(t *testing.T) {
+	p := collisionProfile()
+	DisambiguateFunctions(p)
+	var got []string
+	for _, f := range p.Function {
+		got = append(got, f.Name)
+	}
+	want := []string{
+		"main",
+		"helper (a/util.c)",
+		"helper (b/util.c)",
+		"init [main]",
+		"init [libfoo.so]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %q, want %q", got, want)
+	}
+}
+
+func TestPrintCollisions(t *testing.T) {
+	p := collisionProfile()
+	rpt := New(p, &Options{
+		OutputFormat: Collisions,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := strings.Join([]string{
+		"      flat  flat%        cum   cum%",
+		"helper: 2 distinct functions",
+		"        10 10.00%         10 10.00%    helper (a/util.c)",
+		"        20 20.00%         20 20.00%    helper (b/util.c)",
+		"init: 2 distinct functions",
+		"        40 40.00%         40 40.00%    init [libfoo.so]",
+		"        30 30.00%         30 30.00%    init [main]",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// Labels marking the samples of mutex profiles that record the stacks
+// holding a lock, along with those waiting for it.
+const (
+	// LockRoleLabel is "holder" for the stacks holding a lock and "waiter"
+	// for those waiting for it.
+	LockRoleLabel = "lock_role"
+	// LockLabel identifies the lock, eg by its address, pairing the
+	// holders of a lock with its waiters.
+	LockLabel = "lock"
+)
+
+// Contention is the time waiters with a stack waited for a lock held by
+// holders with another stack.
+type Contention struct {
+	Lock           string
+	Holder, Waiter []string // The frames of the stacks, from the leaf.
+	Wait           int64
+}
+
+// LockContentions pairs the holder and waiter samples of each lock in rpt,
+// returning the contentions sorted by decreasing wait, and the total wait.
+// The wait of each waiter is split among the holders of the lock, in
+// proportion to their values, as the profile does not record which
+// holder each waiter waited for. The wait when a lock has no holders is
+// reported with a nil Holder.
+func LockContentions(rpt *Report) ([]*Contention, int64) {
+	prof, o := rpt.prof, rpt.options
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	frames := func(s *profile.Sample) []string {
+		var frames []string
+		for _, l := range s.Location {
+			for _, n := range locations[l.ID] {
+				frames = append(frames, n.Info.PrintableName())
+			}
+		}
+		return frames
+	}
+
+	type stack struct {
+		frames []string
+		value  int64
+	}
+	// Merge the samples of each lock with the same stack.
+	byLock := make(map[string]map[string]map[string]*stack) // lock, role, stack
+	for _, s := range prof.Sample {
+		role, lock := firstLabel(s, LockRoleLabel), firstLabel(s, LockLabel)
+		if role != "holder" && role != "waiter" {
+			continue
+		}
+		if byLock[lock] == nil {
+			byLock[lock] = map[string]map[string]*stack{"holder": {}, "waiter": {}}
+		}
+		f := frames(s)
+		key := strings.Join(f, "\n")
+		st := byLock[lock][role][key]
+		if st == nil {
+			st = &stack{frames: f}
+			byLock[lock][role][key] = st
+		}
+		st.value += o.SampleValue(s.Value)
+	}
+
+	var contentions []*Contention
+	var total int64
+	for lock, roles := range byLock {
+		var held int64
+		for _, h := range roles["holder"] {
+			held += h.value
+		}
+		for _, wt := range roles["waiter"] {
+			total += wt.value
+			if len(roles["holder"]) == 0 {
+				contentions = append(contentions, &Contention{Lock: lock, Waiter: wt.frames, Wait: wt.value})
+				continue
+			}
+			for _, h := range roles["holder"] {
+				wait := wt.value / int64(len(roles["holder"]))
+				if held != 0 {
+					wait = int64(float64(wt.value) * float64(h.value) / float64(held))
+				}
+				if wait != 0 {
+					contentions = append(contentions, &Contention{Lock: lock, Holder: h.frames, Waiter: wt.frames, Wait: wait})
+				}
+			}
+		}
+	}
+	sort.Slice(contentions, func(i, j int) bool {
+		a, b := contentions[i], contentions[j]
+		if a.Wait != b.Wait {
+			return a.Wait > b.Wait
+		}
+		if a.Lock != b.Lock {
+			return a.Lock < b.Lock
+		}
+		if ah, bh := strings.Join(a.Holder, "\n"), strings.Join(b.Holder, "\n"); ah != bh {
+			return ah < bh
+		}
+		return strings.Join(a.Waiter, "\n") < strings.Join(b.Waiter, "\n")
+	})
+	return contentions, total
+}
+
+// firstLabel returns the first value of the label key of s, or "".
+func firstLabel(s *profile.Sample, key string) string {
+	if vs := s.Label[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// printContenders prints the stacks holding each lock along with the
+// stacks waiting for them, and how long they waited.
+func printContenders(w io.Writer, rpt *Report) error {
+	contentions, total := LockContentions(rpt)
+	if len(contentions) == 0 {
+		fmt.Fprintf(w, "No lock holders or waiters found; label mutex profile samples with %s=holder or %s=waiter, and %s=<lock id>\n",
+			LockRoleLabel, LockRoleLabel, LockLabel)
+		return nil
+	}
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+
+	const separator = "-----------+-------------------------------------------------------"
+	printStack := func(title string, frames []string) {
+		fmt.Fprintf(w, "%10s   %s\n", "", title)
+		if len(frames) == 0 {
+			fmt.Fprintf(w, "%10s     %s\n", "", "(unknown)")
+		}
+		for _, f := range frames {
+			fmt.Fprintf(w, "%10s     %s\n", "", f)
+		}
+	}
+	for _, c := range contentions {
+		fmt.Fprintln(w, separator)
+		fmt.Fprintf(w, "%10s (%s) waiting for lock %s\n",
+			rpt.formatValue(c.Wait), strings.TrimSpace(measurement.Percentage(c.Wait, total)), c.Lock)
+		printStack("holder:", c.Holder)
+		printStack("blocked waiter:", c.Waiter)
+	}
+	fmt.Fprintln(w, separator)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestLockContentions(t *testing.T) {
+	f := []*profile.Function{
+		{ID: 1, Name: "update"},
+		{ID: 2, Name: "flush"},
+		{ID: 3, Name: "read"},
+		{ID: 4, Name: "log"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Line: []profile.Line{{Function: f[0]}}},
+		{ID: 2, Line: []profile.Line{{Function: f[1]}}},
+		{ID: 3, Line: []profile.Line{{Function: f[2]}}},
+		{ID: 4, Line: []profile.Line{{Function: f[3]}}},
+	}
+	sample := func(role, lock string, v int64, loc *profile.Location) *profile.Sample {
+		return &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{v},
+			Label:    map[string][]string{LockRoleLabel: {role}, LockLabel: {lock}},
+		}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "delay", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			sample("holder", "a", 3000, l[0]),
+			sample("holder", "a", 1000, l[1]),
+			sample("waiter", "a", 6000, l[2]),
+			sample("waiter", "a", 2000, l[2]),
+			sample("waiter", "b", 2000, l[3]),
+			{Location: []*profile.Location{l[3]}, Value: []int64{1000}},
+		},
+		Location: l,
+		Function: f,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Contenders,
+		OutputUnit:   "minimum",
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleUnit:   "nanoseconds",
+	})
+
+	got, total := LockContentions(rpt)
+	want := []*Contention{
+		{Lock: "a", Holder: []string{"update"}, Waiter: []string{"read"}, Wait: 6000},
+		{Lock: "a", Holder: []string{"flush"}, Waiter: []string{"read"}, Wait: 2000},
+		{Lock: "b", Waiter: []string{"log"}, Wait: 2000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got contentions %+v, want %+v", got, want)
+	}
+	if total != 10000 {
+		t.Errorf("got total wait %d, want 10000", total)
+	}
+
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, want := range []string{
+		"       6us (60.00%) waiting for lock a\n             holder:\n               update\n             blocked waiter:\n               read\n",
+		"       2us (20.00%) waiting for lock b\n             holder:\n               (unknown)\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("contenders report does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
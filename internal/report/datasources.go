@@ -0,0 +1,84 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to report the cost of the memory accesses
+// of memory samples by the level of the memory hierarchy serving them.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/pprof/internal/measurement"
+)
+
+// DataSourceLabel is the label of memory samples holding the level of
+// the memory hierarchy that served the access, eg "L1", "L2" or "DRAM".
+const DataSourceLabel = "data_source"
+
+// dataSourceCost is the cost of the accesses served by a data source.
+type dataSourceCost struct {
+	source  string
+	value   int64
+	samples int64
+}
+
+// printDataSources prints the cost of each data source of the samples,
+// from the most to the least costly, along with their number of samples
+// and mean value, eg the mean latency of the accesses to each level with
+// -sample_index=latency. The samples without data source are reported
+// last.
+func printDataSources(w io.Writer, rpt *Report) error {
+	o := rpt.options
+
+	sources := make(map[string]*dataSourceCost)
+	unknown := &dataSourceCost{source: "(unknown)"}
+	for _, s := range rpt.prof.Sample {
+		c := unknown
+		if v := s.Label[DataSourceLabel]; len(v) > 0 {
+			if c = sources[v[0]]; c == nil {
+				c = &dataSourceCost{source: v[0]}
+				sources[v[0]] = c
+			}
+		}
+		c.value += o.SampleValue(s.Value)
+		c.samples++
+	}
+	if len(sources) == 0 {
+		fmt.Fprintf(w, "No samples with a %s label found\n", DataSourceLabel)
+		return nil
+	}
+
+	sorted := make([]*dataSourceCost, 0, len(sources)+1)
+	for _, c := range sources {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if a, b := abs64(sorted[i].value), abs64(sorted[j].value); a != b {
+			return a > b
+		}
+		return sorted[i].source < sorted[j].source
+	})
+	if unknown.samples > 0 {
+		sorted = append(sorted, unknown)
+	}
+
+	fmt.Fprintf(w, "%10s %6s %8s %10s  %s\n", "flat", "flat%", "samples", "mean", DataSourceLabel)
+	for _, c := range sorted {
+		fmt.Fprintf(w, "%10s %6s %8d %10s  %s\n", rpt.formatValue(c.value), measurement.Percentage(c.value, rpt.total), c.samples, rpt.formatValue(c.value/c.samples), c.source)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestPrintDataSources(t *testing.T) {
+	f := &profile.Function{ID: 1, Name: "scan"}
+	l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+	sample := func(latency int64, source string) *profile.Sample {
+		s := &profile.Sample{Location: []*profile.Location{l}, Value: []int64{1, latency}}
+		if source != "" {
+			s.Label = map[string][]string{"data_source": {source}}
+		}
+		return s
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "latency", Unit: "cycles"},
+		},
+		Sample: []*profile.Sample{
+			sample(4, "L1"),
+			sample(6, "L1"),
+			sample(20, "L2"),
+			sample(200, "DRAM"),
+			sample(10, ""),
+		},
+		Location: []*profile.Location{l},
+		Function: []*profile.Function{f},
+	}
+	rpt := New(p, &Options{
+		OutputFormat: DataSources,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := strings.Join([]string{
+		"      flat  flat%  samples       mean  data_source",
+		"       200 83.33%        1        200  DRAM",
+		"        20  8.33%        1         20  L2",
+		"        10  4.17%        2          5  L1",
+		"        10  4.17%        1         10  (unknown)",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
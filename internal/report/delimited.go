@@ -0,0 +1,188 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to print the text, traces and tags reports
+// as CSV or TSV, with a header row naming their columns, for spreadsheets
+// and scripts. The values are printed as plain numbers in the unit of the
+// unit column, and the percentages without % sign.
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+)
+
+// newDelimitedWriter returns a writer of the rows of a report to w,
+// separated by the delimiter of the report.
+func newDelimitedWriter(w io.Writer, rpt *Report) *csv.Writer {
+	cw := csv.NewWriter(w)
+	cw.Comma = rpt.options.Delimiter
+	return cw
+}
+
+// delimitedValue returns v formatted as a plain number by plainValue,
+// along with its unit.
+func (rpt *Report) delimitedValue(v int64) (string, string) {
+	f, u := rpt.plainValue(v)
+	return strconv.FormatFloat(f, 'f', -1, 64), u
+}
+
+// plainValue returns v scaled to the output unit of the report, or to the
+// sample unit if the output unit is chosen for each value, along with the
+// unit.
+func (rpt *Report) plainValue(v int64) (float64, string) {
+	o := rpt.options
+	if r := o.Ratio; r > 0 && r != 1 {
+		v = int64(float64(v) * r)
+	}
+	unit := o.OutputUnit
+	if unit == "" || unit == "minimum" || unit == "auto" {
+		unit = o.SampleUnit
+	}
+	return measurement.Scale(v, o.SampleUnit, unit)
+}
+
+// delimitedPercentage returns the percentage of value in total, without
+// % sign.
+func delimitedPercentage(value, total int64) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(100*float64(value)/float64(total), 'f', 2, 64)
+}
+
+// nodeFileLine returns the file:line of a node, or its file if the line is
+// not known, or "" if neither is.
+func nodeFileLine(n *graph.Node) string {
+	if n.Info.File == "" || n.Info.Lineno == 0 {
+		return n.Info.File
+	}
+	return n.Info.File + ":" + strconv.Itoa(n.Info.Lineno)
+}
+
+// printTextDelimited prints the text report with a row per node, with
+// the columns of the text report, the unit of the values, and the
+// function, inline label and file:line of the node.
+func printTextDelimited(w io.Writer, rpt *Report) error {
+	g, _, _, _ := rpt.newTrimmedGraph()
+	rpt.selectOutputUnit(g)
+
+	cw := newDelimitedWriter(w, rpt)
+	cw.Write([]string{"flat", "flat%", "sum%", "cum", "cum%", "unit", "function", "inline", "file:line"})
+	var flatSum int64
+	for _, n := range g.Nodes {
+		flat, cum := n.FlatValue(), n.CumValue()
+		flatSum += flat
+		name := n.Info.Name
+		if name == "" {
+			name = n.Info.PrintableName()
+		}
+		flatValue, unit := rpt.delimitedValue(flat)
+		cumValue, _ := rpt.delimitedValue(cum)
+		cw.Write([]string{
+			flatValue, delimitedPercentage(flat, rpt.total),
+			delimitedPercentage(flatSum, rpt.total),
+			cumValue, delimitedPercentage(cum, rpt.total),
+			unit, name, inlineLabel(n), nodeFileLine(n),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printTracesDelimited prints the traces report with a row per frame of
+// each sample, numbered from 1, with the depth of the frame from 0 at the
+// leaf, the function and file:line of the frame, and the labels of the
+// sample as key=value pairs separated by semicolons.
+func printTracesDelimited(w io.Writer, rpt *Report) error {
+	cw := newDelimitedWriter(w, rpt)
+	cw.Write([]string{"sample", "value", "unit", "depth", "function", "inline", "file:line", "labels"})
+	for i, item := range TraceItems(rpt) {
+		var labels []string
+		for _, l := range append(item.Labels, item.NumLabels...) {
+			for _, v := range l.Values {
+				labels = append(labels, l.Key+"="+v)
+			}
+		}
+		sort.Strings(labels)
+		value, unit := rpt.delimitedValue(item.Flat)
+		for depth, f := range item.Frames {
+			var inline string
+			if f.Inline {
+				inline = "(inline)"
+			}
+			name := f.Function
+			if name == "" {
+				name = f.Name
+			}
+			cw.Write([]string{strconv.Itoa(i + 1), value, unit, strconv.Itoa(depth), name, inline, f.FileLine, strings.Join(labels, ";")})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printTagsDelimited prints the tags report with a row per value of each
+// tag, with its percentage of the total of the tag.
+func printTagsDelimited(w io.Writer, rpt *Report) error {
+	cw := newDelimitedWriter(w, rpt)
+	cw.Write([]string{"tag", "value", "flat", "flat%", "unit"})
+	for _, g := range TagGroups(rpt) {
+		for _, v := range g.Values {
+			flat, unit := rpt.delimitedValue(v.Flat)
+			cw.Write([]string{g.Key, v.Value, flat, delimitedPercentage(v.Flat, g.Total), unit})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printLongCSV prints a row per frame of each sample of the profile, with
+// the raw values and the labels of the sample, as long format CSV for pivot
+// tables and data frames. It is printed as TSV with -table_format=tsv.
+func printLongCSV(w io.Writer, rpt *Report) error {
+	cw := csv.NewWriter(w)
+	if d := rpt.options.Delimiter; d != 0 {
+		cw.Comma = d
+	}
+	t := newFrameTable(rpt.prof)
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	cw.Write(header)
+	record := make([]string, len(t.columns))
+	if err := t.rows(func(row []interface{}) error {
+		for i, v := range row {
+			switch v := v.(type) {
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case string:
+				record[i] = v
+			}
+		}
+		return cw.Write(record)
+	}); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
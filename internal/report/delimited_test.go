@@ -0,0 +1,90 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDelimitedReports(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample[0].Label = map[string][]string{"thread": {"main"}}
+	p.Sample[3].Label = map[string][]string{"thread": {"worker, 1"}}
+	p.Sample[3].NumLabel = map[string][]int64{"bytes": {512}}
+
+	for _, tc := range []struct {
+		format    int
+		delimiter rune
+		want      []string
+	}{
+		{Text, ',', []string{
+			"flat,flat%,sum%,cum,cum%,unit,function,inline,file:line",
+			"10100,90.90,90.90,10100,90.90,cycles,tee,,/some/path/testdata/source2:8",
+			"1000,9.00,99.90,11000,99.00,cycles,tee,,/some/path/testdata/source2:2",
+			"10,0.09,99.99,110,0.99,cycles,bar,,testdata/source1:10",
+			"1,0.01,100.00,11111,100.00,cycles,main,,testdata/source1:2",
+			"0,0.00,100.00,10,0.09,cycles,foo,,testdata/source1:4",
+		}},
+		{Traces, '\t', []string{
+			"sample\tvalue\tunit\tdepth\tfunction\tinline\tfile:line\tlabels",
+			"1\t1\tcycles\t0\tmain\t\ttestdata/source1:2\tthread=main",
+			"2\t10\tcycles\t0\tbar\t\ttestdata/source1:10\t",
+			"2\t10\tcycles\t1\tfoo\t\ttestdata/source1:4\t",
+			"2\t10\tcycles\t2\tmain\t\ttestdata/source1:2\t",
+		}},
+		{Tags, ',', []string{
+			"tag,value,flat,flat%,unit",
+			"bytes,512B,1000,100.00,cycles",
+			`thread,"worker, 1",1000,99.90,cycles`,
+			"thread,main,1,0.10,cycles",
+		}},
+		{LongCSV, 0, []string{
+			"time_nanos,sample,depth,function,system_name,filename,line,inlined,address,mapping,build_id,samples,cpu,label_thread,label_bytes",
+			"0,1,0,main,,testdata/source1,2,0,0,,,1,1,main,0",
+			"0,2,0,bar,,testdata/source1,10,0,0,,,1,10,,0",
+			"0,2,1,foo,,testdata/source1,4,0,0,,,1,10,,0",
+			"0,2,2,main,,testdata/source1,2,0,0,,,1,10,,0",
+			"0,3,0,tee,,/some/path/testdata/source2,8,0,0,,,1,100,,0",
+			"0,3,1,bar,,testdata/source1,10,0,0,,,1,100,,0",
+			"0,3,2,main,,testdata/source1,2,0,0,,,1,100,,0",
+			`0,4,0,tee,,/some/path/testdata/source2,2,0,0,,,1,1000,"worker, 1",512`,
+			`0,4,1,main,,testdata/source1,2,0,0,,,1,1000,"worker, 1",512`,
+		}},
+	} {
+		rpt := New(p, &Options{
+			OutputFormat:  tc.format,
+			OutputUnit:    "minimum",
+			Ratio:         1,
+			NodeCount:     10,
+			SampleValue:   func(v []int64) int64 { return v[1] },
+			SampleUnit:    "cycles",
+			NumLabelUnits: map[string]string{"bytes": "bytes"},
+			Delimiter:     tc.delimiter,
+		})
+		var b bytes.Buffer
+		if err := Generate(&b, rpt, nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		got := strings.Split(b.String(), "\n")
+		if len(got) > len(tc.want) {
+			got = got[:len(tc.want)]
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("format %d: got:\n%s\nwant:\n%s", tc.format, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
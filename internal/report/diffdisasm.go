@@ -0,0 +1,358 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// maxDiffCells bounds the size of the table aligning the instructions of
+// two versions of a function. Larger functions are not aligned.
+const maxDiffCells = 1 << 24
+
+// diffVersion is a version of the functions of a differential
+// disassembly: the base profile and its binaries, or the new ones.
+type diffVersion struct {
+	prof  *profile.Profile
+	total int64
+	syms  map[string]*objSymbol
+	nodes map[*objSymbol]graph.Nodes
+}
+
+// diffRoutine is a function of a differential disassembly, in the old
+// and new versions, either of which may be missing.
+type diffRoutine struct {
+	name     string
+	old, new *objSymbol
+	delta    int64
+}
+
+// diffRow is a row of a differential disassembly, pairing an old and a
+// new instruction, either of which may be missing. Its mark is that of
+// diff -y: ' ' for identical instructions, '|' for changed ones, and '<'
+// and '>' for instructions only in the old or new version.
+type diffRow struct {
+	old, new *assemblyInstruction
+	mark     byte
+}
+
+// printDiffAssembly prints the annotated disassembly of the functions
+// matching the symbol regexp in the binaries of the base profile of a
+// differential profile side by side with those of the new profile,
+// aligning the instructions of each function and annotating them with
+// their flat values in both versions and the change between them.
+func printDiffAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
+	o := rpt.options
+	old, cur := splitBaseProfile(rpt.prof)
+	if len(old.Sample) == 0 {
+		return fmt.Errorf("diffdisasm requires a profile with a -diff_base profile")
+	}
+
+	var versions [2]*diffVersion
+	for i, p := range []*profile.Profile{old, cur} {
+		vrpt := *rpt
+		vrpt.prof = p
+		g := vrpt.newGraph(nil)
+		symbols := symbolsFromBinaries(p, g, o.Symbol, nil, obj)
+		v := &diffVersion{
+			prof:  p,
+			total: computeTotal(p, o.SampleValue, o.SampleMeanDivisor),
+			syms:  make(map[string]*objSymbol),
+			nodes: nodesPerSymbol(g.Nodes, symbols),
+		}
+		// Keep the lowest symbol of each name among those with samples.
+		for _, s := range symbols {
+			name := s.sym.Name[0]
+			if _, ok := v.nodes[s]; !ok {
+				continue
+			}
+			if prev := v.syms[name]; prev == nil || s.sym.Start < prev.sym.Start {
+				v.syms[name] = s
+			}
+		}
+		versions[i] = v
+	}
+	vold, vnew := versions[0], versions[1]
+
+	// Align the functions by name. A function with samples in only one
+	// version is looked up in the binaries of the other, as its code may
+	// have changed along with its cost.
+	names := make(map[string]bool)
+	for _, v := range versions {
+		for name := range v.syms {
+			names[name] = true
+		}
+	}
+	var routines []*diffRoutine
+	for name := range names {
+		r := &diffRoutine{name: name, old: vold.syms[name], new: vnew.syms[name]}
+		if r.old == nil {
+			r.old = lookupDiffSymbol(vold.prof, name, obj)
+		}
+		if r.new == nil {
+			r.new = lookupDiffSymbol(vnew.prof, name, obj)
+		}
+		oldFlat, _ := vold.nodes[r.old].Sum()
+		newFlat, _ := vnew.nodes[r.new].Sum()
+		r.delta = newFlat - oldFlat
+		routines = append(routines, r)
+	}
+	// Print the functions whose cost changed the most first.
+	sort.Slice(routines, func(i, j int) bool {
+		if di, dj := abs64(routines[i].delta), abs64(routines[j].delta); di != dj {
+			return di > dj
+		}
+		return routines[i].name < routines[j].name
+	})
+
+	fmt.Fprintf(w, "Total: old %s, new %s\n", rpt.formatValue(vold.total), rpt.formatValue(vnew.total))
+	for _, r := range routines {
+		var oldInsts, newInsts []assemblyInstruction
+		for _, s := range []struct {
+			v     *diffVersion
+			sym   *objSymbol
+			insts *[]assemblyInstruction
+		}{
+			{vold, r.old, &oldInsts},
+			{vnew, r.new, &newInsts},
+		} {
+			if s.sym == nil {
+				continue
+			}
+			insts, err := obj.Disasm(s.sym.sym.File, s.sym.sym.Start, s.sym.sym.End, o.IntelSyntax)
+			if err != nil {
+				return err
+			}
+			*s.insts = annotateAssembly(insts, s.v.nodes[s.sym], s.sym.file)
+		}
+		rows := alignAssembly(oldInsts, newInsts)
+
+		counts := make(map[byte]int)
+		for _, row := range rows {
+			counts[row.mark]++
+		}
+		oldFlat, _ := vold.nodes[r.old].Sum()
+		newFlat, _ := vnew.nodes[r.new].Sum()
+		fmt.Fprintf(w, "ROUTINE ======================== %s\n", r.name)
+		fmt.Fprintf(w, "%10s %10s %10s (old, new, delta flat) %d changed, %d removed, %d added instructions\n",
+			rpt.formatValue(oldFlat), rpt.formatValue(newFlat), formatDelta(r.delta, rpt),
+			counts['|'], counts['<'], counts['>'])
+		if r.old == nil || r.new == nil {
+			version := "old"
+			if r.new == nil {
+				version = "new"
+			}
+			fmt.Fprintf(w, "%32s (not in the %s binaries)\n", "", version)
+		}
+		for _, row := range rows {
+			var oldValue, newValue int64
+			oldText, newText := "", ""
+			if row.old != nil {
+				oldValue = row.old.flatValue()
+				oldText = fmt.Sprintf("%10x: %s", row.old.address, row.old.instruction)
+			}
+			if row.new != nil {
+				newValue = row.new.flatValue()
+				newText = fmt.Sprintf("%10x: %s", row.new.address, row.new.instruction)
+			}
+			fmt.Fprintf(w, "%10s %10s %10s %-52s %c %s\n",
+				valueOrDot(oldValue, rpt), valueOrDot(newValue, rpt),
+				formatDelta(newValue-oldValue, rpt),
+				oldText, row.mark, newText)
+		}
+	}
+	return nil
+}
+
+// SplitDiffBase splits the report of a differential profile, as with
+// -diff_base, into reports of its base profiles, with their values
+// restored, and of its new profiles. It returns nil reports if the
+// profile has no base profiles.
+func SplitDiffBase(rpt *Report) (base, cur *Report) {
+	old, p := splitBaseProfile(rpt.prof)
+	if len(old.Sample) == 0 {
+		return nil, nil
+	}
+	bo, co := *rpt.options, *rpt.options
+	return New(old, &bo), New(p, &co)
+}
+
+// splitBaseProfile splits a differential profile into the samples of
+// its base profiles, with their values restored, and the others.
+func splitBaseProfile(p *profile.Profile) (base, cur *profile.Profile) {
+	isBase := func(s *profile.Sample) bool { return len(s.Label["pprof::base"]) > 0 }
+	base, cur = p.Copy(), p.Copy()
+	base.Sample = filterSamples(base.Sample, isBase)
+	cur.Sample = filterSamples(cur.Sample, func(s *profile.Sample) bool { return !isBase(s) })
+	base.Scale(-1)
+	return base, cur
+}
+
+func filterSamples(samples []*profile.Sample, keep func(*profile.Sample) bool) []*profile.Sample {
+	var kept []*profile.Sample
+	for _, s := range samples {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// lookupDiffSymbol looks up the function named name in the binaries
+// with samples of the profile p, or returns nil if it is not found.
+func lookupDiffSymbol(p *profile.Profile, name string, obj plugin.ObjTool) *objSymbol {
+	sampled := make(map[*profile.Mapping]bool)
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			sampled[l.Mapping] = true
+		}
+	}
+	rx := regexp.MustCompile("^" + regexp.QuoteMeta(name) + "$")
+	for _, m := range p.Mapping {
+		if m.File == "" || !sampled[m] {
+			continue
+		}
+		f, err := obj.Open(m.File, m.Start, m.Limit, m.Offset)
+		if err != nil {
+			continue
+		}
+		syms, err := f.Symbols(rx, 0)
+		f.Close()
+		if err == nil && len(syms) > 0 {
+			return &objSymbol{sym: syms[0], file: f}
+		}
+	}
+	return nil
+}
+
+// addressRx matches the addresses and offsets in the text of an
+// instruction, which change whenever code moves.
+var addressRx = regexp.MustCompile(`0x[0-9a-fA-F]+|\b[0-9a-fA-F]{4,}\b|<[^>]*>`)
+
+// normalizedInstruction strips the addresses and offsets of the text of
+// an instruction, so that the same instructions of two versions of a
+// function compare equal.
+func normalizedInstruction(text string) string {
+	return addressRx.ReplaceAllString(text, "_")
+}
+
+// alignAssembly aligns the instructions of two versions of a function
+// along their longest common subsequence. Runs of instructions only in
+// either version between common ones are paired as changed instructions.
+func alignAssembly(old, new []assemblyInstruction) []diffRow {
+	n, m := len(old), len(new)
+	var rows []diffRow
+	if n*m > maxDiffCells {
+		for i := 0; i < n || i < m; i++ {
+			rows = append(rows, pairRow(old, new, i, i))
+		}
+		return rows
+	}
+	a := make([]string, n)
+	for i := range old {
+		a[i] = normalizedInstruction(old[i].instruction)
+	}
+	b := make([]string, m)
+	for j := range new {
+		b[j] = normalizedInstruction(new[j].instruction)
+	}
+	// lcs[i*(m+1)+j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j]
+			default:
+				lcs[i*(m+1)+j] = lcs[i*(m+1)+j+1]
+			}
+		}
+	}
+
+	var removed, added []int
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			i, j := -1, -1
+			if k < len(removed) {
+				i = removed[k]
+			}
+			if k < len(added) {
+				j = added[k]
+			}
+			rows = append(rows, pairRow(old, new, i, j))
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			flush()
+			rows = append(rows, diffRow{old: &old[i], new: &new[j], mark: ' '})
+			i++
+			j++
+		case j == m || i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+	return rows
+}
+
+// pairRow returns the row pairing old[i] and new[j], either of which
+// may be out of range.
+func pairRow(old, new []assemblyInstruction, i, j int) diffRow {
+	var row diffRow
+	if i >= 0 && i < len(old) {
+		row.old = &old[i]
+	}
+	if j >= 0 && j < len(new) {
+		row.new = &new[j]
+	}
+	switch {
+	case row.new == nil:
+		row.mark = '<'
+	case row.old == nil:
+		row.mark = '>'
+	case normalizedInstruction(row.old.instruction) == normalizedInstruction(row.new.instruction):
+		row.mark = ' '
+	default:
+		row.mark = '|'
+	}
+	return row
+}
+
+// formatDelta formats the change of a value, with its sign, intercepting
+// zero values.
+func formatDelta(delta int64, rpt *Report) string {
+	if delta > 0 {
+		return "+" + rpt.formatValue(delta)
+	}
+	return valueOrDot(delta, rpt)
+}
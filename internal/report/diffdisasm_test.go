@@ -0,0 +1,156 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// diffObjTool is an object tool for two builds of a binary, both with a
+// hot function at its start.
+type diffObjTool map[string][]plugin.Inst
+
+func (obj diffObjTool) Open(file string, start, limit, offset uint64) (plugin.ObjFile, error) {
+	return diffObjFile{file, obj[file][0].Addr}, nil
+}
+
+func (obj diffObjTool) Disasm(file string, start, end uint64, intelSyntax bool) ([]plugin.Inst, error) {
+	return obj[file], nil
+}
+
+type diffObjFile struct {
+	name  string
+	start uint64
+}
+
+func (f diffObjFile) Name() string                                   { return f.name }
+func (f diffObjFile) ObjAddr(addr uint64) (uint64, error)            { return addr, nil }
+func (f diffObjFile) BuildID() string                                { return "" }
+func (f diffObjFile) SourceLine(addr uint64) ([]plugin.Frame, error) { return nil, nil }
+func (f diffObjFile) Close() error                                   { return nil }
+
+func (f diffObjFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error) {
+	if r != nil && !r.MatchString("hot") {
+		return nil, nil
+	}
+	return []*plugin.Sym{{Name: []string{"hot"}, File: f.name, Start: f.start, End: f.start + 0xff}}, nil
+}
+
+func TestDiffAssembly(t *testing.T) {
+	obj := diffObjTool{
+		"/bin/old": {
+			{Addr: 0x1000, Text: "push rbp"},
+			{Addr: 0x1001, Text: "mov eax, 0x1"},
+			{Addr: 0x1006, Text: "add eax, ebx"},
+			{Addr: 0x1008, Text: "jmp 1001 <hot+0x1>"},
+			{Addr: 0x100a, Text: "ret"},
+		},
+		"/bin/new": {
+			{Addr: 0x2000, Text: "push rbp"},
+			{Addr: 0x2001, Text: "mov eax, 0x1"},
+			{Addr: 0x2006, Text: "imul eax, ebx"},
+			{Addr: 0x2009, Text: "nop"},
+			{Addr: 0x200a, Text: "jmp 2001 <hot+0x1>"},
+			{Addr: 0x200c, Text: "ret"},
+		},
+	}
+	m := []*profile.Mapping{
+		{ID: 1, File: "/bin/old", Start: 0x1000, Limit: 0x2000},
+		{ID: 2, File: "/bin/new", Start: 0x2000, Limit: 0x3000},
+	}
+	f := &profile.Function{ID: 1, Name: "hot"}
+	var l []*profile.Location
+	for _, a := range []uint64{0x1006, 0x2006, 0x2009} {
+		l = append(l, &profile.Location{ID: uint64(len(l) + 1), Mapping: m[a>>12-1], Address: a, Line: []profile.Line{{Function: f}}})
+	}
+	base := map[string][]string{"pprof::base": {"true"}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			// The base profile is subtracted, as with -diff_base.
+			{Location: []*profile.Location{l[0]}, Value: []int64{-10}, Label: base},
+			{Location: []*profile.Location{l[1]}, Value: []int64{30}},
+			{Location: []*profile.Location{l[2]}, Value: []int64{5}},
+		},
+		Location: l,
+		Function: []*profile.Function{f},
+		Mapping:  m,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: DiffDis,
+		OutputUnit:   "minimum",
+		Symbol:       regexp.MustCompile("hot"),
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, obj); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, want := range []string{
+		"Total: old 10, new 35\n",
+		"ROUTINE ======================== hot\n",
+		`        10         35        \+25 \(old, new, delta flat\) 1 changed, 0 removed, 1 added instructions\n`,
+		"         .          .          .       1000: push rbp",
+		`        10         30        \+20       1006: add eax, ebx\s+\|       2006: imul eax, ebx`,
+		`         .          5         \+5\s+>       2009: nop`,
+		`         .          .          .       1008: jmp 1001 <hot\+0x1>\s+       200a: jmp 2001 <hot\+0x1>`,
+	} {
+		if !regexp.MustCompile(want).MatchString(buf.String()) {
+			t.Errorf("report does not match %q:\n%s", want, buf.String())
+		}
+	}
+
+	// Without a base profile there is nothing to compare.
+	p.Sample = p.Sample[1:]
+	if err := Generate(&buf, New(p, rpt.options), obj); err == nil || !strings.Contains(err.Error(), "-diff_base") {
+		t.Errorf("got error %v, want one requiring -diff_base", err)
+	}
+}
+
+func TestAlignAssembly(t *testing.T) {
+	insts := func(texts ...string) []assemblyInstruction {
+		var a []assemblyInstruction
+		for _, t := range texts {
+			a = append(a, assemblyInstruction{instruction: t})
+		}
+		return a
+	}
+	for _, tc := range []struct {
+		old, new []assemblyInstruction
+		want     string
+	}{
+		{insts("a", "b", "c"), insts("a", "b", "c"), "   "},
+		{insts("a", "b", "c"), insts("a", "x", "y", "c"), " |> "},
+		{insts("a", "b", "c", "d"), insts("a", "d"), " << "},
+		{insts("call 4005d0 <f>"), insts("call 4006e0 <f>"), " "},
+		{nil, insts("a", "b"), ">>"},
+		{insts("a"), nil, "<"},
+	} {
+		var marks []byte
+		for _, row := range alignAssembly(tc.old, tc.new) {
+			marks = append(marks, row.mark)
+		}
+		if string(marks) != tc.want {
+			t.Errorf("alignAssembly(%v, %v): got marks %q, want %q", tc.old, tc.new, marks, tc.want)
+		}
+	}
+}
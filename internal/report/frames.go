@@ -0,0 +1,167 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to flatten the samples of a profile into a
+// table with a row per frame of each sample, which is written as a Parquet
+// file and as long format CSV. The columns are described in doc/README.md.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// frameColumn is a column of the table of frames.
+type frameColumn struct {
+	name    string
+	numeric bool // Whether the values are int64, or else strings.
+}
+
+// frameTable is the table of the frames of the samples of a profile.
+type frameTable struct {
+	p                       *profile.Profile
+	columns                 []frameColumn
+	labelKeys, numLabelKeys []string
+}
+
+// frameColumns are the columns of each row of a frame, before those of the
+// sample values and labels.
+var frameColumns = []frameColumn{
+	{"time_nanos", true},
+	{"sample", true},
+	{"depth", true},
+	{"function", false},
+	{"system_name", false},
+	{"filename", false},
+	{"line", true},
+	{"inlined", true},
+	{"address", true},
+	{"mapping", false},
+	{"build_id", false},
+}
+
+// newFrameTable returns the table of the frames of the samples of p, with a
+// column per sample type, named after it, and a column per label key,
+// holding the comma-separated values of string labels, or the first value
+// of numeric labels.
+func newFrameTable(p *profile.Profile) *frameTable {
+	t := &frameTable{p: p, columns: append([]frameColumn(nil), frameColumns...)}
+	seen := make(map[string]bool)
+	for _, c := range t.columns {
+		seen[c.name] = true
+	}
+	addColumn := func(name string, numeric bool) {
+		if seen[name] {
+			name = fmt.Sprintf("%s_%d", name, len(t.columns)+1)
+		}
+		seen[name] = true
+		t.columns = append(t.columns, frameColumn{name, numeric})
+	}
+	for _, st := range p.SampleType {
+		addColumn(st.Type, true)
+	}
+
+	labels, numLabels := make(map[string]bool), make(map[string]bool)
+	for _, s := range p.Sample {
+		for k := range s.Label {
+			labels[k] = true
+		}
+		for k := range s.NumLabel {
+			numLabels[k] = true
+		}
+	}
+	for k := range labels {
+		t.labelKeys = append(t.labelKeys, k)
+	}
+	// Numeric labels with the key of a string label are left out.
+	for k := range numLabels {
+		if !labels[k] {
+			t.numLabelKeys = append(t.numLabelKeys, k)
+		}
+	}
+	sort.Strings(t.labelKeys)
+	sort.Strings(t.numLabelKeys)
+	for _, k := range t.labelKeys {
+		addColumn("label_"+k, false)
+	}
+	for _, k := range t.numLabelKeys {
+		addColumn("label_"+k, true)
+	}
+	return t
+}
+
+// rows calls fn with each row of t, whose values are int64 for numeric
+// columns and strings otherwise. The row is reused across calls.
+func (t *frameTable) rows(fn func(row []interface{}) error) error {
+	p := t.p
+	row := make([]interface{}, len(t.columns))
+	for i, s := range p.Sample {
+		// The sample values and labels repeat on each frame.
+		n := len(frameColumns)
+		row[0], row[1] = p.TimeNanos, int64(i+1)
+		for _, v := range s.Value {
+			row[n] = v
+			n++
+		}
+		for _, k := range t.labelKeys {
+			row[n] = strings.Join(s.Label[k], ",")
+			n++
+		}
+		for _, k := range t.numLabelKeys {
+			var v int64
+			if vs := s.NumLabel[k]; len(vs) > 0 {
+				v = vs[0]
+			}
+			row[n] = v
+			n++
+		}
+
+		depth := int64(0)
+		for _, l := range s.Location {
+			mapping, buildID := "", ""
+			if m := l.Mapping; m != nil {
+				mapping, buildID = m.File, m.BuildID
+			}
+			row[8], row[9], row[10] = int64(l.Address), mapping, buildID
+			lines := l.Line
+			if len(lines) == 0 {
+				lines = []profile.Line{{}}
+			}
+			for j, ln := range lines {
+				name, systemName, filename := "", "", ""
+				if f := ln.Function; f != nil {
+					name, systemName, filename = f.Name, f.SystemName, f.Filename
+				}
+				if name == "" {
+					name = "0x" + strconv.FormatUint(l.Address, 16)
+				}
+				inlined := int64(0)
+				if j < len(lines)-1 {
+					inlined = 1
+				}
+				row[2], row[3], row[4], row[5], row[6], row[7] = depth, name, systemName, filename, ln.Line, inlined
+				if err := fn(row); err != nil {
+					return err
+				}
+				depth++
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// SeriesLabel is the sample label holding the profile of a series of
+// profiles a sample comes from, as the time the profile was collected at,
+// or as its source if the time is unknown.
+const SeriesLabel = "series"
+
+// SeriesTimeFormat is the format of the times of the series label.
+const SeriesTimeFormat = time.RFC3339
+
+// defaultHeatmapFunctions is the number of functions of the heatmap report
+// unless NodeCount is set.
+const defaultHeatmapFunctions = 30
+
+// LabelHeatmap holds the flat values of the functions costing the most over
+// the values of a label, such as the profiles of a series, for each value.
+type LabelHeatmap struct {
+	Columns []string // Values of the label.
+	Totals  []int64  // Totals of the samples of each column.
+	Total   int64    // Total of all samples, with or without the label.
+	Rows    []HeatmapRow
+}
+
+// HeatmapRow holds the flat values of a function for each column of a
+// heatmap.
+type HeatmapRow struct {
+	Name  string
+	Flat  []int64
+	Share []float64 // Flat share of the column totals, in percent.
+}
+
+// SeriesNames returns the profiles of the series of the samples of p, in
+// the order of their times if they all have one, or else in the order of
+// their first sample.
+func SeriesNames(p *profile.Profile) []string {
+	var names []string
+	seen := make(map[string]bool)
+	timed := true
+	for _, s := range p.Sample {
+		name := firstLabel(s, SeriesLabel)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if _, err := time.Parse(SeriesTimeFormat, name); err != nil {
+			timed = false
+		}
+	}
+	if timed {
+		sort.SliceStable(names, func(i, j int) bool {
+			a, _ := time.Parse(SeriesTimeFormat, names[i])
+			b, _ := time.Parse(SeriesTimeFormat, names[j])
+			return a.Before(b)
+		})
+	}
+	return names
+}
+
+// SeriesHeatmap returns the heatmap of the functions of the report over
+// the profiles of its series, or nil if its samples are not labeled with
+// a series.
+func SeriesHeatmap(rpt *Report) *LabelHeatmap {
+	names := SeriesNames(rpt.prof)
+	if len(names) == 0 {
+		return nil
+	}
+	return labelHeatmap(rpt, SeriesLabel, names, defaultHeatmapFunctions)
+}
+
+// labelHeatmap returns the heatmap of the functions of the report over
+// the values of the label key, as columns in that order. It holds the
+// functions with the largest flat value over all columns, by the first
+// location of the samples, up to NodeCount or else n of them.
+func labelHeatmap(rpt *Report, key string, columns []string, n int) *LabelHeatmap {
+	prof, o := rpt.prof, rpt.options
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	column := make(map[string]int)
+	for i, c := range columns {
+		column[c] = i
+	}
+	h := &LabelHeatmap{Columns: columns, Totals: make([]int64, len(columns))}
+	flat := make(map[string][]int64)
+	for _, s := range prof.Sample {
+		v := o.SampleValue(s.Value)
+		h.Total += v
+		i, ok := column[firstLabel(s, key)]
+		if !ok {
+			continue
+		}
+		h.Totals[i] += v
+		name := "(unknown)"
+		if len(s.Location) > 0 {
+			if nodes := locations[s.Location[0].ID]; len(nodes) > 0 {
+				name = nodes[0].Info.PrintableName()
+			}
+		}
+		if flat[name] == nil {
+			flat[name] = make([]int64, len(columns))
+		}
+		flat[name][i] += v
+	}
+
+	type function struct {
+		name  string
+		total int64
+	}
+	var functions []function
+	for name, values := range flat {
+		var total int64
+		for _, v := range values {
+			total += v
+		}
+		functions = append(functions, function{name, total})
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].total != functions[j].total {
+			return abs64(functions[i].total) > abs64(functions[j].total)
+		}
+		return functions[i].name < functions[j].name
+	})
+	if o.NodeCount > 0 {
+		n = o.NodeCount
+	}
+	if len(functions) > n {
+		functions = functions[:n]
+	}
+	for _, f := range functions {
+		r := HeatmapRow{Name: f.name, Flat: flat[f.name], Share: make([]float64, len(columns))}
+		for i, v := range r.Flat {
+			if h.Totals[i] != 0 {
+				r.Share[i] = 100 * float64(v) / float64(h.Totals[i])
+			}
+		}
+		h.Rows = append(h.Rows, r)
+	}
+	return h
+}
+
+// heatmapShades are the characters shading the cells of the heatmap
+// report, from the lowest to the highest share.
+var heatmapShades = []string{" ", "░", "▒", "▓", "█"}
+
+// printHeatmap prints the flat share of the total of each profile of a
+// series spent in each of the functions costing the most over all the
+// profiles, shaded by the share relative to the largest one, to spot
+// which functions changed and when.
+func printHeatmap(w io.Writer, rpt *Report) error {
+	h := SeriesHeatmap(rpt)
+	if h == nil {
+		fmt.Fprintf(w, "No series found; load several profiles with -series to label the samples with %s=<time>\n", SeriesLabel)
+		return nil
+	}
+	max := 0.0
+	for _, r := range h.Rows {
+		for _, s := range r.Share {
+			if s > max {
+				max = s
+			}
+		}
+	}
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	fmt.Fprintln(w, "Profiles:")
+	for i, c := range h.Columns {
+		fmt.Fprintf(w, "  %2d %-25s %10s\n", i+1, c, rpt.formatValue(h.Totals[i]))
+	}
+	fmt.Fprintf(w, "Flat share of the profile total of the top %d functions:\n", len(h.Rows))
+	for i := range h.Columns {
+		fmt.Fprintf(w, "%8d ", i+1)
+	}
+	fmt.Fprintln(w, " function")
+	for _, r := range h.Rows {
+		for i, v := range r.Flat {
+			share, shade := ".", heatmapShades[0]
+			if v != 0 {
+				share = strings.TrimSpace(measurement.Percentage(v, h.Totals[i]))
+				if max > 0 {
+					shade = heatmapShades[int(r.Share[i]/max*float64(len(heatmapShades)-1)+0.5)]
+				}
+			}
+			fmt.Fprintf(w, "%7s%s ", share, shade)
+		}
+		fmt.Fprintf(w, " %s\n", graph.TruncateName(r.Name, rpt.options.MaxNameLen))
+	}
+	return nil
+}
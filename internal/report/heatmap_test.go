@@ -0,0 +1,89 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestPrintHeatmap(t *testing.T) {
+	fn := func(id uint64, name string) *profile.Function {
+		return &profile.Function{ID: id, Name: name}
+	}
+	parse, serve := fn(1, "parse"), fn(2, "serve")
+	lparse := &profile.Location{ID: 1, Line: []profile.Line{{Function: parse}}}
+	lserve := &profile.Location{ID: 2, Line: []profile.Line{{Function: serve}}}
+	sample := func(v int64, loc *profile.Location, series string) *profile.Sample {
+		return &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{v},
+			Label:    map[string][]string{SeriesLabel: {series}},
+		}
+	}
+	const t1, t2 = "2020-06-01T10:00:00Z", "2020-06-01T11:00:00Z"
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			sample(60, lserve, t2),
+			sample(40, lparse, t2),
+			sample(90, lserve, t1),
+			sample(10, lparse, t1),
+		},
+		Location: []*profile.Location{lparse, lserve},
+		Function: []*profile.Function{parse, serve},
+	}
+	if got, want := SeriesNames(p), []string{t1, t2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got series %q, want %q", got, want)
+	}
+
+	rpt := New(p, &Options{
+		OutputFormat: Heatmap,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleType:   "samples",
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := strings.Join([]string{
+		"Type: samples",
+		"Profiles:",
+		"   1 2020-06-01T10:00:00Z             100",
+		"   2 2020-06-01T11:00:00Z             100",
+		"Flat share of the profile total of the top 2 functions:",
+		"       1        2  function",
+		" 90.00%█  60.00%▓  serve",
+		" 10.00%   40.00%▒  parse",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSeriesNamesUntimed(t *testing.T) {
+	p := &profile.Profile{}
+	for _, name := range []string{"b.pb.gz", "a.pb.gz", "b.pb.gz"} {
+		p.Sample = append(p.Sample, &profile.Sample{Label: map[string][]string{SeriesLabel: {name}}})
+	}
+	if got, want := SeriesNames(p), []string{"b.pb.gz", "a.pb.gz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got series %q, want %q", got, want)
+	}
+}
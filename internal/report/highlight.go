@@ -0,0 +1,221 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+// sourceLanguage holds the lexical rules of a language whose source is
+// highlighted in weblist reports.
+type sourceLanguage struct {
+	keywords     map[string]bool
+	rawStrings   bool // Whether `...` are raw strings, possibly on several lines.
+	preprocessor bool // Whether lines starting with # are directives.
+}
+
+func keywords(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, k := range strings.Fields(s) {
+		m[k] = true
+	}
+	return m
+}
+
+var (
+	goLanguage = &sourceLanguage{
+		keywords: keywords(`break case chan const continue default defer else
+			fallthrough for func go goto if import interface map package range
+			return select struct switch type var nil true false`),
+		rawStrings: true,
+	}
+	cLanguage = &sourceLanguage{
+		keywords: keywords(`auto bool break case catch char class const
+			constexpr continue default delete do double else enum explicit
+			extern false float for friend goto if inline int long namespace
+			new noexcept nullptr operator private protected public register
+			return short signed sizeof static struct switch template this
+			throw true try typedef typename union unsigned using virtual void
+			volatile while`),
+		preprocessor: true,
+	}
+	rustLanguage = &sourceLanguage{
+		keywords: keywords(`as async await break const continue crate dyn
+			else enum extern false fn for if impl in let loop match mod move
+			mut pub ref return self Self static struct super trait true type
+			unsafe use where while`),
+	}
+
+	// sourceLanguages holds the languages by file extension.
+	sourceLanguages = map[string]*sourceLanguage{
+		".go":  goLanguage,
+		".c":   cLanguage,
+		".h":   cLanguage,
+		".cc":  cLanguage,
+		".cpp": cLanguage,
+		".cxx": cLanguage,
+		".hh":  cLanguage,
+		".hpp": cLanguage,
+		".hxx": cLanguage,
+		".rs":  rustLanguage,
+	}
+)
+
+// highlighter highlights the lines of a source file in weblist reports,
+// wrapping its keywords, literals and comments in spans of the kw, str,
+// num and com classes. It keeps the block comments and raw strings open
+// at the end of a line, to highlight the following lines.
+type highlighter struct {
+	lang             *sourceLanguage
+	inComment, inRaw bool
+}
+
+// newHighlighter returns a highlighter for the source file fname, or nil
+// if its language is not known.
+func newHighlighter(fname string) *highlighter {
+	lang := sourceLanguages[strings.ToLower(filepath.Ext(fname))]
+	if lang == nil {
+		return nil
+	}
+	return &highlighter{lang: lang}
+}
+
+// line returns the HTML of a line of source, which is only escaped by a
+// nil highlighter.
+func (h *highlighter) line(s string) string {
+	if h == nil {
+		return template.HTMLEscapeString(s)
+	}
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString("<span class=" + class + ">")
+		b.WriteString(template.HTMLEscapeString(text))
+		b.WriteString("</span>")
+	}
+	// closing returns the end of the text from start to the end delimiter,
+	// or -1 if it is not on the line.
+	closing := func(start int, end string) int {
+		if i := strings.Index(s[start:], end); i >= 0 {
+			return start + i + len(end)
+		}
+		return -1
+	}
+
+	i := 0
+	if h.lang.preprocessor && !h.inComment {
+		if t := strings.TrimLeft(s, " \t"); strings.HasPrefix(t, "#") {
+			i = len(s) - len(t)
+			b.WriteString(s[:i])
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			span("kw", s[i:j])
+			i = j
+		}
+	}
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case h.inComment || h.inRaw:
+			end, class := "*/", "com"
+			if h.inRaw {
+				end, class = "`", "str"
+			}
+			j := closing(i, end)
+			if j < 0 {
+				span(class, s[i:])
+				return b.String()
+			}
+			h.inComment, h.inRaw = false, false
+			span(class, s[i:j])
+			i = j
+		case strings.HasPrefix(s[i:], "//"):
+			span("com", s[i:])
+			return b.String()
+		case strings.HasPrefix(s[i:], "/*"):
+			j := closing(i+2, "*/")
+			if j < 0 {
+				h.inComment = true
+				span("com", s[i:])
+				return b.String()
+			}
+			span("com", s[i:j])
+			i = j
+		case c == '`' && h.lang.rawStrings:
+			j := closing(i+1, "`")
+			if j < 0 {
+				h.inRaw = true
+				span("str", s[i:])
+				return b.String()
+			}
+			span("str", s[i:j])
+			i = j
+		case c == '"' || c == '\'' && isCharLiteral(s[i:]):
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++
+			} else {
+				j = len(s)
+			}
+			span("str", s[i:j])
+			i = j
+		case isIdentChar(c):
+			j := i + 1
+			for j < len(s) && (isIdentChar(s[j]) || c >= '0' && c <= '9' && s[j] == '.') {
+				j++
+			}
+			switch word := s[i:j]; {
+			case c >= '0' && c <= '9':
+				span("num", word)
+			case h.lang.keywords[word]:
+				span("kw", word)
+			default:
+				b.WriteString(template.HTMLEscapeString(word))
+			}
+			i = j
+		default:
+			b.WriteString(template.HTMLEscapeString(s[i : i+1]))
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// isCharLiteral reports whether s starts with a character literal, rather
+// than with a quote of another kind, such as a Rust lifetime.
+func isCharLiteral(s string) bool {
+	if len(s) > 1 && s[1] == '\\' {
+		return true
+	}
+	j := 1
+	for j < len(s) && j < 5 && s[j] != '\'' {
+		j++
+	}
+	// A single character, possibly of several bytes.
+	return j < len(s) && s[j] == '\'' && j > 1 && (j == 2 || s[1] >= 0x80)
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	for _, tc := range []struct {
+		file  string
+		lines []string
+		want  []string
+	}{
+		{
+			"main.go",
+			[]string{`func f(s string) int { return len("a<b") + 0x1f // done`},
+			[]string{`<span class=kw>func</span> f(s string) int { <span class=kw>return</span> len(<span class=str>&#34;a&lt;b&#34;</span>) + <span class=num>0x1f</span> <span class=com>// done</span>`},
+		},
+		{
+			"main.go",
+			[]string{"x := `raw", "string` /* a", "comment */ 'c'"},
+			[]string{
+				"x := <span class=str>`raw</span>",
+				"<span class=str>string`</span> <span class=com>/* a</span>",
+				"<span class=com>comment */</span> <span class=str>&#39;c&#39;</span>",
+			},
+		},
+		{
+			"lib.cc",
+			[]string{`  #include <vector>`, `static int x = 1.5e3; // "q"`},
+			[]string{
+				`  <span class=kw>#include</span> &lt;vector&gt;`,
+				`<span class=kw>static</span> <span class=kw>int</span> x = <span class=num>1.5e3</span>; <span class=com>// &#34;q&#34;</span>`,
+			},
+		},
+		{
+			"lib.rs",
+			[]string{`fn f<'a>(s: &'a str) -> char { '\n' }`},
+			[]string{`<span class=kw>fn</span> f&lt;&#39;a&gt;(s: &amp;&#39;a str) -&gt; char { <span class=str>&#39;\n&#39;</span> }`},
+		},
+		{
+			"notes.txt",
+			[]string{`func "x" <y>`},
+			[]string{`func &#34;x&#34; &lt;y&gt;`},
+		},
+	} {
+		h := newHighlighter(tc.file)
+		for i, line := range tc.lines {
+			if got := h.line(line); got != tc.want[i] {
+				t.Errorf("%s: line(%q) = %q, want %q", tc.file, line, got, tc.want[i])
+			}
+		}
+	}
+}
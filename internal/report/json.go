@@ -0,0 +1,132 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to print the top nodes and call graph of a
+// profile as a JSON object, for dashboards and other programs.
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/google/pprof/internal/graph"
+)
+
+// jsonReport is the JSON report of a profile.
+type jsonReport struct {
+	Title      string   `json:"title,omitempty"`
+	Labels     []string `json:"labels"`
+	SampleType string   `json:"sample_type"`
+	Unit       string   `json:"unit"`
+	Total      float64  `json:"total"`
+
+	// The nodes are sorted as in the top report, by decreasing flat
+	// value, or cum value with -cum.
+	Nodes        []jsonNode `json:"nodes"`
+	Edges        []jsonEdge `json:"edges"`
+	DroppedNodes int        `json:"dropped_nodes"`
+	DroppedEdges int        `json:"dropped_edges"`
+}
+
+// jsonNode is a node of the JSON report, with its values in the unit of
+// the report.
+type jsonNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Inline      string  `json:"inline,omitempty"`
+	File        string  `json:"file,omitempty"`
+	Line        int     `json:"line,omitempty"`
+	Address     uint64  `json:"address,omitempty"`
+	Flat        float64 `json:"flat"`
+	FlatPercent float64 `json:"flat_percent"`
+	Cum         float64 `json:"cum"`
+	CumPercent  float64 `json:"cum_percent"`
+}
+
+// jsonEdge is an edge of the JSON report, from a caller to a callee,
+// identified by the IDs of their nodes. Residual edges stand for call
+// paths through nodes dropped from the report.
+type jsonEdge struct {
+	Source   int     `json:"source"`
+	Target   int     `json:"target"`
+	Weight   float64 `json:"weight"`
+	Inline   bool    `json:"inline,omitempty"`
+	Residual bool    `json:"residual,omitempty"`
+}
+
+// printJSON prints the nodes and edges of the graph of a profile, trimmed
+// as for the graph reports, as a JSON object.
+func printJSON(w io.Writer, rpt *Report) error {
+	o := rpt.options
+	g, origCount, droppedNodes, droppedEdges := rpt.newTrimmedGraph()
+	rpt.selectOutputUnit(g)
+
+	total, unit := rpt.plainValue(rpt.total)
+	out := &jsonReport{
+		Title:        o.Title,
+		Labels:       ProfileLabels(rpt),
+		SampleType:   o.SampleType,
+		Unit:         unit,
+		Total:        total,
+		Nodes:        []jsonNode{},
+		Edges:        []jsonEdge{},
+		DroppedNodes: droppedNodes + origCount - len(g.Nodes),
+		DroppedEdges: droppedEdges,
+	}
+	percent := func(v int64) float64 {
+		if rpt.total == 0 {
+			return 0
+		}
+		return 100 * float64(v) / float64(rpt.total)
+	}
+	ids := make(map[*graph.Node]int, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n] = i + 1
+		name := n.Info.Name
+		if name == "" {
+			name = n.Info.PrintableName()
+		}
+		flat, _ := rpt.plainValue(n.FlatValue())
+		cum, _ := rpt.plainValue(n.CumValue())
+		out.Nodes = append(out.Nodes, jsonNode{
+			ID:          i + 1,
+			Name:        name,
+			Inline:      inlineLabel(n),
+			File:        n.Info.File,
+			Line:        n.Info.Lineno,
+			Address:     n.Info.Address,
+			Flat:        flat,
+			FlatPercent: percent(n.FlatValue()),
+			Cum:         cum,
+			CumPercent:  percent(n.CumValue()),
+		})
+	}
+	for _, n := range g.Nodes {
+		for _, e := range n.Out.Sort() {
+			weight, _ := rpt.plainValue(e.WeightValue())
+			out.Edges = append(out.Edges, jsonEdge{
+				Source:   ids[e.Src],
+				Target:   ids[e.Dest],
+				Weight:   weight,
+				Inline:   e.Inline,
+				Residual: e.Residual,
+			})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	rpt := New(testProfile.Copy(), &Options{
+		OutputFormat: JSON,
+		OutputUnit:   "minimum",
+		Ratio:        1,
+		NodeCount:    3,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cycles",
+		SampleUnit:   "cycles",
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var got jsonReport
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.String())
+	}
+
+	if got.SampleType != "cycles" || got.Unit != "cycles" || got.Total != 11111 {
+		t.Errorf("got sample type %q, unit %q and total %v, want cycles, cycles and 11111", got.SampleType, got.Unit, got.Total)
+	}
+	if got.DroppedNodes != 2 {
+		t.Errorf("got %d dropped nodes, want 2", got.DroppedNodes)
+	}
+	var nodes []string
+	for _, n := range got.Nodes {
+		nodes = append(nodes, fmt.Sprintf("%d %s %s:%d %v %.2f %v %.2f", n.ID, n.Name, n.File, n.Line, n.Flat, n.FlatPercent, n.Cum, n.CumPercent))
+	}
+	wantNodes := []string{
+		"1 tee /some/path/testdata/source2:8 10100 90.90 10100 90.90",
+		"2 tee /some/path/testdata/source2:2 1000 9.00 11000 99.00",
+		"3 bar testdata/source1:10 10 0.09 110 0.99",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("got nodes:\n%q\nwant:\n%q", nodes, wantNodes)
+	}
+	var edges []string
+	for _, e := range got.Edges {
+		edges = append(edges, fmt.Sprintf("%d->%d %v inline=%v residual=%v", e.Source, e.Target, e.Weight, e.Inline, e.Residual))
+	}
+	wantEdges := []string{
+		"2->1 10000 inline=false residual=false",
+		"3->1 100 inline=false residual=false",
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("got edges:\n%q\nwant:\n%q", edges, wantEdges)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Metadata holds external metadata of functions and packages, such as
+// the team owning them, shown next to their cost in reports.
+type Metadata struct {
+	Keys   []string                     // Sorted keys of the metadata.
+	byName map[string]map[string]string // Metadata by function or package name.
+}
+
+// ParseMetadata parses the metadata of functions and packages, from a
+// JSON object mapping their names to objects of string values if isJSON
+// is set, and else from CSV whose header row names the keys, after the
+// first column holding the names.
+func ParseMetadata(r io.Reader, isJSON bool) (*Metadata, error) {
+	m := &Metadata{byName: make(map[string]map[string]string)}
+	if isJSON {
+		if err := json.NewDecoder(r).Decode(&m.byName); err != nil {
+			return nil, fmt.Errorf("parsing metadata: %v", err)
+		}
+	} else {
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("parsing metadata: %v", err)
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("parsing metadata: no header row")
+		}
+		keys := rows[0][1:]
+		for _, row := range rows[1:] {
+			values := make(map[string]string)
+			for i, v := range row[1:] {
+				if v != "" {
+					values[keys[i]] = v
+				}
+			}
+			m.byName[row[0]] = values
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, values := range m.byName {
+		for k := range values {
+			if !seen[k] {
+				seen[k] = true
+				m.Keys = append(m.Keys, k)
+			}
+		}
+	}
+	sort.Strings(m.Keys)
+	return m, nil
+}
+
+// Lookup returns the metadata of the function name: that given for the
+// function itself, or else for the innermost package or namespace
+// containing it, such as github.com/a/b for github.com/a/b.(*T).M.
+func (m *Metadata) Lookup(name string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	if values, ok := m.byName[name]; ok {
+		return values
+	}
+	// The package path ends at the first dot after its last slash.
+	pkg := strings.LastIndex(name, "/") + 1
+	for i := len(name) - 1; i > 0; i-- {
+		if name[i] == '.' && i > pkg || strings.HasPrefix(name[i:], "::") {
+			if values, ok := m.byName[name[:i]]; ok {
+				return values
+			}
+		}
+	}
+	return nil
+}
+
+// Badges returns the metadata of the function name as key=value pairs,
+// sorted by key.
+func (m *Metadata) Badges(name string) []string {
+	values := m.Lookup(name)
+	var badges []string
+	for _, k := range m.keys() {
+		if v, ok := values[k]; ok {
+			badges = append(badges, k+"="+v)
+		}
+	}
+	return badges
+}
+
+func (m *Metadata) keys() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Keys
+}
+
+// Badges returns the metadata of the function name in the report, as
+// key=value pairs, or nil if the report has no metadata.
+func (rpt *Report) Badges(name string) []string {
+	return rpt.options.Metadata.Badges(name)
+}
+
+// metadataGroupItems returns the text items of the report grouping the
+// functions by their value of the metadata key rpt.options.MetadataGroup.
+// The flat value of a group is that of the samples whose leaf function
+// is in the group, and its cumulative value that of the samples with any
+// function in the group.
+func metadataGroupItems(rpt *Report) []TextItem {
+	key := rpt.options.MetadataGroup
+	group := func(name string) string {
+		if v, ok := rpt.options.Metadata.Lookup(name)[key]; ok {
+			return v
+		}
+		return "(no " + key + ")"
+	}
+	flat, cum := make(map[string]int64), make(map[string]int64)
+	for _, s := range rpt.prof.Sample {
+		v := rpt.options.SampleValue(s.Value)
+		seen := make(map[string]bool)
+		leaf := true
+		for _, loc := range s.Location {
+			// A location without lines is a frame of an unknown function.
+			names := []string{""}
+			if len(loc.Line) > 0 {
+				names = names[:0]
+				for _, line := range loc.Line {
+					if line.Function != nil {
+						names = append(names, line.Function.Name)
+					} else {
+						names = append(names, "")
+					}
+				}
+			}
+			for _, name := range names {
+				g := group(name)
+				if leaf {
+					flat[g] += v
+					leaf = false
+				}
+				if !seen[g] {
+					seen[g] = true
+					cum[g] += v
+				}
+			}
+		}
+	}
+
+	var items []TextItem
+	for g, c := range cum {
+		items = append(items, TextItem{
+			Name:       g,
+			Flat:       flat[g],
+			Cum:        c,
+			FlatFormat: rpt.formatValue(flat[g]),
+			CumFormat:  rpt.formatValue(c),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if rpt.options.CumSort && a.Cum != b.Cum {
+			return abs64(a.Cum) > abs64(b.Cum)
+		}
+		if a.Flat != b.Flat {
+			return abs64(a.Flat) > abs64(b.Flat)
+		}
+		if a.Cum != b.Cum {
+			return abs64(a.Cum) > abs64(b.Cum)
+		}
+		return a.Name < b.Name
+	})
+	return items
+}
+
+// metadataGroupLabels returns the legend of a report grouping the
+// functions by the metadata key rpt.options.MetadataGroup.
+func metadataGroupLabels(rpt *Report) []string {
+	return append(ProfileLabels(rpt), "Grouped by "+rpt.options.MetadataGroup)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestParseMetadata(t *testing.T) {
+	csv := strings.Join([]string{
+		"name,team,critical",
+		"github.com/a/b,storage,",
+		"github.com/a/b.(*T).Hot,storage,yes",
+		"ns::Class,rendering,yes",
+	}, "\n")
+	json := `{
+		"github.com/a/b": {"team": "storage"},
+		"github.com/a/b.(*T).Hot": {"team": "storage", "critical": "yes"},
+		"ns::Class": {"team": "rendering", "critical": "yes"}
+	}`
+	for _, tc := range []struct {
+		desc, data string
+		isJSON     bool
+	}{
+		{"csv", csv, false},
+		{"json", json, true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			m, err := ParseMetadata(strings.NewReader(tc.data), tc.isJSON)
+			if err != nil {
+				t.Fatalf("ParseMetadata: %v", err)
+			}
+			if want := []string{"critical", "team"}; !reflect.DeepEqual(m.Keys, want) {
+				t.Errorf("got keys %q, want %q", m.Keys, want)
+			}
+			for name, want := range map[string][]string{
+				"github.com/a/b.(*T).Hot":  {"critical=yes", "team=storage"},
+				"github.com/a/b.(*T).Cold": {"team=storage"},
+				"github.com/a/b.F.func1":   {"team=storage"},
+				"github.com/a/bc.F":        nil,
+				"ns::Class::method":        {"critical=yes", "team=rendering"},
+				"main":                     nil,
+			} {
+				if got := m.Badges(name); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got badges %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataGroupItems(t *testing.T) {
+	fn := func(id uint64, name string) *profile.Function {
+		return &profile.Function{ID: id, Name: name}
+	}
+	main, hot, draw := fn(1, "main"), fn(2, "github.com/a/b.Hot"), fn(3, "ns::Class::draw")
+	loc := func(id uint64, f *profile.Function) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: f}}}
+	}
+	lmain, lhot, ldraw := loc(1, main), loc(2, hot), loc(3, draw)
+	sample := func(v int64, locs ...*profile.Location) *profile.Sample {
+		return &profile.Sample{Location: locs, Value: []int64{v}}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			sample(10, lhot, lmain),
+			sample(20, ldraw, lhot, lmain),
+			sample(5, lmain),
+		},
+		Location: []*profile.Location{lmain, lhot, ldraw},
+		Function: []*profile.Function{main, hot, draw},
+	}
+	m, err := ParseMetadata(strings.NewReader("name,team\ngithub.com/a/b,storage\nns,rendering\n"), false)
+	if err != nil {
+		t.Fatalf("ParseMetadata: %v", err)
+	}
+	rpt := New(p, &Options{
+		OutputFormat:  Text,
+		SampleValue:   func(v []int64) int64 { return v[0] },
+		Metadata:      m,
+		MetadataGroup: "team",
+	})
+	items, labels := TextItems(rpt)
+	var got []string
+	for _, it := range items {
+		got = append(got, it.Name+" "+it.FlatFormat+" "+it.CumFormat)
+	}
+	want := []string{"rendering 20 20", "storage 10 30", "(no team) 5 35"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got items %q, want %q", got, want)
+	}
+	if last := labels[len(labels)-1]; last != "Grouped by team" {
+		t.Errorf("got last label %q, want %q", last, "Grouped by team")
+	}
+}
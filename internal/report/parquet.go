@@ -0,0 +1,41 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"io"
+
+	"github.com/google/pprof/internal/parquet"
+)
+
+// printParquet writes the frames of the samples of the profile of the
+// report as the rows of a Parquet file, for data warehouses.
+func printParquet(w io.Writer, rpt *Report) error {
+	t := newFrameTable(rpt.prof)
+	columns := make([]parquet.Column, len(t.columns))
+	for i, c := range t.columns {
+		columns[i] = parquet.Column{Name: c.name, Type: parquet.String}
+		if c.numeric {
+			columns[i].Type = parquet.Int64
+		}
+	}
+	pw := parquet.NewWriter(w, columns, "pprof")
+	if err := t.rows(func(row []interface{}) error {
+		return pw.Write(row...)
+	}); err != nil {
+		return err
+	}
+	return pw.Close()
+}
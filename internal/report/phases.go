@@ -0,0 +1,109 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/internal/graph"
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// PhaseLabel is the sample label holding the execution phase, such as
+// startup or shutdown, during which a sample was recorded.
+const PhaseLabel = "phase"
+
+// defaultPhaseFunctions is the number of functions compared by the phases
+// report unless NodeCount is set.
+const defaultPhaseFunctions = 20
+
+// PhaseNames returns the execution phases of the samples of p, in the order
+// of their earliest timestamp, or of their first sample for the phases
+// of samples without timestamps.
+func PhaseNames(p *profile.Profile) []string {
+	type phase struct {
+		name  string
+		first int64 // Earliest timestamp.
+		timed bool
+		index int // Index of the first sample.
+	}
+	byName := make(map[string]*phase)
+	var phases []*phase
+	for i, s := range p.Sample {
+		name := firstLabel(s, PhaseLabel)
+		if name == "" {
+			continue
+		}
+		ph := byName[name]
+		if ph == nil {
+			ph = &phase{name: name, index: i}
+			byName[name] = ph
+			phases = append(phases, ph)
+		}
+		if t := s.NumLabel[TimestampLabel]; len(t) > 0 && (!ph.timed || t[0] < ph.first) {
+			ph.first, ph.timed = t[0], true
+		}
+	}
+	sort.SliceStable(phases, func(i, j int) bool {
+		a, b := phases[i], phases[j]
+		if a.timed && b.timed && a.first != b.first {
+			return a.first < b.first
+		}
+		return a.index < b.index
+	})
+	var names []string
+	for _, ph := range phases {
+		names = append(names, ph.name)
+	}
+	return names
+}
+
+// printPhases prints the total of each execution phase of the profile,
+// and the share of its total spent in each of the functions costing the
+// most over all phases, to compare the phases.
+func printPhases(w io.Writer, rpt *Report) error {
+	phases := PhaseNames(rpt.prof)
+	if len(phases) == 0 {
+		fmt.Fprintf(w, "No phases found; label the samples with %s=<phase>, or use -phase_map\n", PhaseLabel)
+		return nil
+	}
+	h := labelHeatmap(rpt, PhaseLabel, phases, defaultPhaseFunctions)
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	fmt.Fprintln(w, "Phases:")
+	for i, ph := range phases {
+		fmt.Fprintf(w, "  %-20s %10s %s\n", ph, rpt.formatValue(h.Totals[i]), measurement.Percentage(h.Totals[i], h.Total))
+	}
+	fmt.Fprintf(w, "Flat share of the phase total of the top %d functions:\n", len(h.Rows))
+	for _, ph := range phases {
+		fmt.Fprintf(w, "%12s ", graph.TruncateName(ph, 12))
+	}
+	fmt.Fprintln(w, " function")
+	for _, r := range h.Rows {
+		for i, v := range r.Flat {
+			share := "."
+			if v != 0 {
+				share = strings.TrimSpace(measurement.Percentage(v, h.Totals[i]))
+			}
+			fmt.Fprintf(w, "%12s ", share)
+		}
+		fmt.Fprintf(w, " %s\n", graph.TruncateName(r.Name, rpt.options.MaxNameLen))
+	}
+	return nil
+}
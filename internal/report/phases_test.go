@@ -0,0 +1,79 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestPrintPhases(t *testing.T) {
+	fn := func(id uint64, name string) *profile.Function {
+		return &profile.Function{ID: id, Name: name}
+	}
+	load, serve := fn(1, "load"), fn(2, "serve")
+	lload := &profile.Location{ID: 1, Line: []profile.Line{{Function: load}}}
+	lserve := &profile.Location{ID: 2, Line: []profile.Line{{Function: serve}}}
+	sample := func(v int64, loc *profile.Location, phase string, ts int64) *profile.Sample {
+		return &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{v},
+			Label:    map[string][]string{PhaseLabel: {phase}},
+			NumLabel: map[string][]int64{TimestampLabel: {ts}},
+		}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			sample(10, lserve, "steady", 20),
+			sample(30, lload, "startup", 10),
+			sample(10, lserve, "startup", 15),
+			sample(50, lserve, "steady", 30),
+		},
+		Location: []*profile.Location{lload, lserve},
+		Function: []*profile.Function{load, serve},
+	}
+	if got, want := PhaseNames(p), []string{"startup", "steady"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got phases %q, want %q", got, want)
+	}
+
+	rpt := New(p, &Options{
+		OutputFormat: Phases,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleType:   "samples",
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := strings.Join([]string{
+		"Type: samples",
+		"Phases:",
+		"  startup                      40 40.00%",
+		"  steady                       60 60.00%",
+		"Flat share of the phase total of the top 2 functions:",
+		"     startup       steady  function",
+		"      25.00%         100%  serve",
+		"      75.00%            .  load",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
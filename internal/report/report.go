@@ -17,8 +17,10 @@
 package report
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -35,18 +37,36 @@ import (
 
 // Output formats.
 const (
-	Callgrind = iota
+	CallHierarchy = iota
+	Callgrind
+	ChromeTrace
+	Collisions
 	Comments
+	Contenders
+	DataSources
+	DiffDis
 	Dis
 	Dot
+	Folded
+	Heatmap
+	JSON
 	List
+	LongCSV
+	Mermaid
+	OTLP
+	Parquet
+	Phases
 	Proto
 	Raw
+	Speedscope
+	SQLite
 	Tags
 	Text
 	TopProto
+	TraceIDs
 	Traces
 	Tree
+	Unsampled
 	WebList
 )
 
@@ -57,11 +77,15 @@ type Options struct {
 
 	CumSort       bool
 	CallTree      bool
+	MergeRepeats  bool // Whether call trees merge the consecutive frames of a node, as of source files.
 	DropNegative  bool
 	CompactLabels bool
 	Ratio         float64
 	Title         string
 	ProfileLabels []string
+	GraphTitle    string   // Title drawn above graph images.
+	Annotations   []string // Text boxes drawn next to the legend of graph images.
+	DotMetadata   bool     // Add raw values and profile IDs as attributes of DOT graphs.
 	ActiveFilters []string
 	NumLabelUnits map[string]string
 
@@ -73,6 +97,7 @@ type Options struct {
 	SampleMeanDivisor func(s []int64) int64
 	SampleType        string
 	SampleUnit        string // Unit for the sample data from the profile.
+	Total             int64  // Value percentages are relative to, if not the total of the samples.
 
 	OutputUnit string // Units for data formatting in report.
 
@@ -81,10 +106,49 @@ type Options struct {
 	TrimPath   string         // Paths to trim from source file paths.
 
 	IntelSyntax bool // Whether or not to print assembly in Intel syntax.
+
+	AsmSource      bool    // Whether to print the source lines above their instructions in disassembly reports.
+	AsmHotFraction float64 // Fraction of the total below which the basic blocks of disassembly reports are hidden, 0 to show all.
+
+	MinSymbolSize uint64 // Minimum size in bytes of the functions listed by the unsampled report.
+
+	MaxNameLen int // Maximum length of names in text and graph reports, 0 for no limit.
+
+	FairShare bool // Whether tags reports divide the value of a sample among the values of each of its labels.
+
+	Metadata      *Metadata // External metadata of the functions, shown in text and web reports.
+	MetadataGroup string    // Metadata key by which text reports group the functions, if not empty.
+
+	Delimiter rune // Delimiter of the fields of text, traces and tags reports printed as CSV or TSV, 0 for plain text.
+
+	CompactProto bool // Whether to encode proto output to reduce its size.
+	RawProto     bool // Whether to write proto output uncompressed, for the caller to compress it.
+
+	// Context, if non-nil, cancels the generation of the report: its
+	// graphs stop adding samples once it is done, and Generate then fails
+	// with its error.
+	Context context.Context
 }
 
-// Generate generates a report as directed by the Report.
+// Generate generates a report as directed by the Report. It fails with
+// the error of the context of the options of the report if it is done.
 func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
+	if err := generate(w, rpt, obj); err != nil {
+		return err
+	}
+	return rpt.Err()
+}
+
+// Err returns the error of the context of the options of the report, if
+// any: non-nil once the report is canceled.
+func (rpt *Report) Err() error {
+	if ctx := rpt.options.Context; ctx != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 	o := rpt.options
 
 	switch o.OutputFormat {
@@ -92,29 +156,63 @@ func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 		return printComments(w, rpt)
 	case Dot:
 		return printDOT(w, rpt)
+	case Mermaid:
+		return printMermaid(w, rpt)
 	case Tree:
 		return printTree(w, rpt)
 	case Text:
 		return printText(w, rpt)
 	case Traces:
 		return printTraces(w, rpt)
+	case TraceIDs:
+		return printTraceIDs(w, rpt)
+	case DataSources:
+		return printDataSources(w, rpt)
+	case Folded:
+		return printFolded(w, rpt)
+	case JSON:
+		return printJSON(w, rpt)
 	case Raw:
 		fmt.Fprint(w, rpt.prof.String())
 		return nil
 	case Tags:
 		return printTags(w, rpt)
-	case Proto:
+	case Proto, OTLP:
 		return printProto(w, rpt)
 	case TopProto:
 		return printTopProto(w, rpt)
 	case Dis:
 		return printAssembly(w, rpt, obj)
+	case DiffDis:
+		return printDiffAssembly(w, rpt, obj)
+	case Unsampled:
+		return printUnsampled(w, rpt, obj)
 	case List:
 		return printSource(w, rpt)
 	case WebList:
 		return printWebSource(w, rpt, obj)
+	case CallHierarchy:
+		return printCallHierarchy(w, rpt)
 	case Callgrind:
 		return printCallgrind(w, rpt)
+	case Collisions:
+		return printCollisions(w, rpt)
+	case Contenders:
+		return printContenders(w, rpt)
+	case Phases:
+		return printPhases(w, rpt)
+	case Heatmap:
+		return printHeatmap(w, rpt)
+	case Speedscope:
+		return printSpeedscope(w, rpt)
+	case SQLite:
+		return printSQLite(w, rpt)
+	case Parquet:
+		return printParquet(w, rpt)
+	case LongCSV:
+		return printLongCSV(w, rpt)
+	case ChromeTrace:
+		return printChromeTrace(w, rpt)
 	}
 	return fmt.Errorf("unexpected output format")
 }
@@ -126,11 +224,11 @@ func (rpt *Report) newTrimmedGraph() (g *graph.Graph, origCount, droppedNodes, d
 
 	// Build a graph and refine it. On each refinement step we must rebuild the graph from the samples,
 	// as the graph itself doesn't contain enough information to preserve full precision.
-	visualMode := o.OutputFormat == Dot
+	visualMode := o.OutputFormat == Dot || o.OutputFormat == Mermaid
 	cumSort := o.CumSort
 
 	// The call_tree option is only honored when generating visual representations of the callgraph.
-	callTree := o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Callgrind)
+	callTree := o.CallTree && (visualMode || o.OutputFormat == Callgrind)
 
 	// First step: Build complete graph to identify low frequency nodes, based on their cum weight.
 	g = rpt.newGraph(nil)
@@ -278,22 +376,25 @@ func (rpt *Report) newGraph(nodes graph.NodeSet) *graph.Graph {
 		SampleValue:       o.SampleValue,
 		SampleMeanDivisor: o.SampleMeanDivisor,
 		FormatTag:         formatTag,
-		CallTree:          o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Callgrind),
+		CallTree:          o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Mermaid || o.OutputFormat == Callgrind),
+		MergeRepeats:      o.MergeRepeats,
 		DropNegative:      o.DropNegative,
 		KeptNodes:         nodes,
+		Context:           o.Context,
 	}
 
 	// Only keep binary names for disassembly-based reports, otherwise
 	// remove it to allow merging of functions across binaries.
 	switch o.OutputFormat {
-	case Raw, List, WebList, Dis, Callgrind:
+	case Raw, List, WebList, Dis, DiffDis, Callgrind:
 		gopt.ObjNames = true
 	}
 
 	return graph.New(rpt.prof, gopt)
 }
 
-// printProto writes the incoming proto via thw writer w.
+// printProto writes the incoming proto via thw writer w, as an OTLP
+// request for the OTLP format.
 // If the divide_by option has been specified, samples are scaled appropriately.
 func printProto(w io.Writer, rpt *Report) error {
 	p, o := rpt.prof, rpt.options
@@ -306,6 +407,17 @@ func printProto(w io.Writer, rpt *Report) error {
 			}
 		}
 	}
+	if o.OutputFormat == OTLP {
+		return p.WriteOTLP(w)
+	}
+	switch {
+	case o.CompactProto && o.RawProto:
+		return p.WriteCompactUncompressed(w)
+	case o.CompactProto:
+		return p.WriteCompact(w)
+	case o.RawProto:
+		return p.WriteUncompressed(w)
+	}
 	return p.Write(w)
 }
 
@@ -400,6 +512,19 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 		address = &hex
 	}
 
+	var reader *sourceReader
+	if o.AsmSource {
+		sourcePath := o.SourcePath
+		if sourcePath == "" {
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("could not stat current dir: %v", err)
+			}
+			sourcePath = wd
+		}
+		reader = newSourceReader(sourcePath, o.TrimPath)
+	}
+
 	fmt.Fprintln(w, "Total:", rpt.formatValue(rpt.total))
 	symbols := symbolsFromBinaries(prof, g, o.Symbol, address, obj)
 	symNodes := nodesPerSymbol(g.Nodes, symbols)
@@ -446,6 +571,10 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 		}
 
 		ns := annotateAssembly(insts, sns, s.file)
+		var hot []bool
+		if o.AsmHotFraction > 0 {
+			hot = hotInstructions(ns, int64(o.AsmHotFraction*float64(rpt.total)))
+		}
 
 		fmt.Fprintf(w, "ROUTINE ======================== %s\n", s.sym.Name[0])
 		for _, name := range s.sym.Name[1:] {
@@ -456,10 +585,31 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 			measurement.Percentage(cumSum, rpt.total))
 
 		function, file, line := "", "", 0
-		for _, n := range ns {
+		srcFile, srcLine, skipped := "", 0, false
+		for i, n := range ns {
+			if hot != nil && !hot[i] {
+				skipped = true
+				continue
+			}
+			if skipped {
+				// Mark the basic blocks hidden before this one.
+				fmt.Fprintf(w, "%32s\n", "⋮")
+				skipped = false
+				function, file, line = "", "", 0
+			}
+			if o.AsmSource && insts[i].Line != 0 && (insts[i].File != srcFile || insts[i].Line != srcLine) {
+				srcFile, srcLine = insts[i].File, insts[i].Line
+				loc := fmt.Sprintf("%s:%d", filepath.Base(srcFile), srcLine)
+				if src, ok := reader.line(srcFile, srcLine); ok {
+					fmt.Fprintf(w, "%32s: %s\n", loc, strings.TrimSpace(src))
+				} else {
+					fmt.Fprintf(w, "%32s\n", loc)
+				}
+			}
 			locStr := ""
-			// Skip loc information if it hasn't changed from previous instruction.
-			if n.function != function || n.file != file || n.line != line {
+			// Skip loc information if it hasn't changed from previous
+			// instruction, or if the source lines show it.
+			if !o.AsmSource && (n.function != function || n.file != file || n.line != line) {
 				function, file, line = n.function, n.file, n.line
 				if n.function != "" {
 					locStr = n.function + " "
@@ -497,6 +647,9 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 				)
 			}
 		}
+		if skipped {
+			fmt.Fprintf(w, "%32s\n", "⋮")
+		}
 	}
 	return nil
 }
@@ -666,6 +819,85 @@ func annotateAssembly(insts []plugin.Inst, samples graph.Nodes, file plugin.ObjF
 	return asm
 }
 
+// hotInstructions reports whether the instructions of asm belong to basic
+// blocks whose flat value is at least min. The blocks end after the jumps
+// and returns, and start at the targets of the jumps within asm.
+func hotInstructions(asm []assemblyInstruction, min int64) []bool {
+	if len(asm) == 0 {
+		return nil
+	}
+	first, last := asm[0].address, asm[len(asm)-1].address
+	starts := make(map[uint64]bool)
+	for i, in := range asm {
+		target, ok := jumpTarget(in.instruction)
+		if !ok {
+			continue
+		}
+		if target >= first && target <= last {
+			starts[target] = true
+		}
+		if i+1 < len(asm) {
+			starts[asm[i+1].address] = true
+		}
+	}
+
+	hot := make([]bool, len(asm))
+	for begin := 0; begin < len(asm); {
+		end := begin + 1
+		for end < len(asm) && !starts[asm[end].address] {
+			end++
+		}
+		var flat int64
+		for _, in := range asm[begin:end] {
+			flat += in.flatValue()
+		}
+		if flat != 0 && abs64(flat) >= min {
+			for i := begin; i < end; i++ {
+				hot[i] = true
+			}
+		}
+		begin = end
+	}
+	return hot
+}
+
+// jumpTarget reports whether the instruction inst ends a basic block, as
+// a jump or a return, and returns the address it jumps to, or 0 if it is
+// not known.
+func jumpTarget(inst string) (uint64, bool) {
+	fields := strings.Fields(strings.ToLower(inst))
+	// Skip the prefixes of the instruction.
+	for len(fields) > 0 {
+		switch fields[0] {
+		case "rep", "repz", "repnz", "repe", "repne", "lock", "bnd", "notrack":
+			fields = fields[1:]
+			continue
+		}
+		break
+	}
+	if len(fields) == 0 {
+		return 0, false
+	}
+	switch op := fields[0]; {
+	case strings.HasPrefix(op, "j"), strings.HasPrefix(op, "loop"):
+		// x86 jumps, such as jmp and jne.
+	case op == "b", strings.HasPrefix(op, "b."), op == "br", op == "bx",
+		op == "cbz", op == "cbnz", op == "tbz", op == "tbnz":
+		// ARM branches, but not the calls bl and blr.
+	case strings.HasPrefix(op, "ret"):
+		return 0, true
+	default:
+		return 0, false
+	}
+	for _, f := range fields[1:] {
+		f = strings.TrimPrefix(strings.TrimRight(f, ","), "0x")
+		if a, err := strconv.ParseUint(f, 16, 64); err == nil {
+			return a, true
+		}
+	}
+	return 0, true
+}
+
 // valueOrDot formats a value according to a report, intercepting zero
 // values.
 func valueOrDot(value int64, rpt *Report) string {
@@ -675,39 +907,73 @@ func valueOrDot(value int64, rpt *Report) string {
 	return rpt.formatValue(value)
 }
 
-// printTags collects all tags referenced in the profile and prints
-// them in a sorted table.
-func printTags(w io.Writer, rpt *Report) error {
+// TagGroup holds the values of a tag of the samples of a report, from
+// the most to the least costly.
+type TagGroup struct {
+	Key         string
+	Total       int64  // Raw value
+	TotalFormat string // Formatted value
+	Numeric     bool   // Whether the values are those of a numeric label
+	Values      []TagValue
+}
+
+// TagValue holds a value of a tag, and the total value of its samples.
+type TagValue struct {
+	Value      string
+	Flat       int64  // Raw value
+	FlatFormat string // Formatted value
+	Percent    string // Percentage of the total of the tag, empty if zero
+}
+
+// TagGroups collects all tags referenced in the profile of the report,
+// in the order of the tags report.
+func TagGroups(rpt *Report) []TagGroup {
 	p := rpt.prof
 
 	o := rpt.options
 	formatTag := func(v int64, key string) string {
 		return measurement.ScaledLabel(v, key, o.OutputUnit)
 	}
+	formatValue := func(v int64) string {
+		f, u := measurement.Scale(v, o.SampleUnit, o.OutputUnit)
+		return fmt.Sprintf("%.1f%s", f, u)
+	}
+
+	// share returns the value of a sample counted for the ith of its n
+	// values of a label. With FairShare, the shares of the n values add
+	// up to the value of the sample.
+	share := func(v int64, i, n int) int64 {
+		if !o.FairShare {
+			return v
+		}
+		return v*int64(i+1)/int64(n) - v*int64(i)/int64(n)
+	}
 
 	// Hashtable to keep accumulate tags as key,value,count.
 	tagMap := make(map[string]map[string]int64)
+	numeric := make(map[string]bool)
 	for _, s := range p.Sample {
 		for key, vals := range s.Label {
-			for _, val := range vals {
+			for i, val := range vals {
 				valueMap, ok := tagMap[key]
 				if !ok {
 					valueMap = make(map[string]int64)
 					tagMap[key] = valueMap
 				}
-				valueMap[val] += o.SampleValue(s.Value)
+				valueMap[val] += share(o.SampleValue(s.Value), i, len(vals))
 			}
 		}
 		for key, vals := range s.NumLabel {
 			unit := o.NumLabelUnits[key]
-			for _, nval := range vals {
+			numeric[key] = true
+			for i, nval := range vals {
 				val := formatTag(nval, unit)
 				valueMap, ok := tagMap[key]
 				if !ok {
 					valueMap = make(map[string]int64)
 					tagMap[key] = valueMap
 				}
-				valueMap[val] += o.SampleValue(s.Value)
+				valueMap[val] += share(o.SampleValue(s.Value), i, len(vals))
 			}
 		}
 	}
@@ -716,7 +982,7 @@ func printTags(w io.Writer, rpt *Report) error {
 	for key := range tagMap {
 		tagKeys = append(tagKeys, &graph.Tag{Name: key})
 	}
-	tabw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	var groups []TagGroup
 	for _, tagKey := range graph.SortTags(tagKeys, true) {
 		var total int64
 		key := tagKey.Name
@@ -726,14 +992,33 @@ func printTags(w io.Writer, rpt *Report) error {
 			tags = append(tags, &graph.Tag{Name: t, Flat: c})
 		}
 
-		f, u := measurement.Scale(total, o.SampleUnit, o.OutputUnit)
-		fmt.Fprintf(tabw, "%s:\t Total %.1f%s\n", key, f, u)
+		g := TagGroup{Key: key, Total: total, TotalFormat: formatValue(total), Numeric: numeric[key]}
 		for _, t := range graph.SortTags(tags, true) {
-			f, u := measurement.Scale(t.FlatValue(), o.SampleUnit, o.OutputUnit)
+			v := TagValue{Value: t.Name, Flat: t.FlatValue(), FlatFormat: formatValue(t.FlatValue())}
 			if total > 0 {
-				fmt.Fprintf(tabw, " \t%.1f%s (%s):\t %s\n", f, u, measurement.Percentage(t.FlatValue(), total), t.Name)
+				v.Percent = measurement.Percentage(t.FlatValue(), total)
+			}
+			g.Values = append(g.Values, v)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// printTags collects all tags referenced in the profile and prints
+// them in a sorted table.
+func printTags(w io.Writer, rpt *Report) error {
+	if rpt.options.Delimiter != 0 {
+		return printTagsDelimited(w, rpt)
+	}
+	tabw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	for _, g := range TagGroups(rpt) {
+		fmt.Fprintf(tabw, "%s:\t Total %s\n", g.Key, g.TotalFormat)
+		for _, v := range g.Values {
+			if v.Percent != "" {
+				fmt.Fprintf(tabw, " \t%s (%s):\t %s\n", v.FlatFormat, v.Percent, v.Value)
 			} else {
-				fmt.Fprintf(tabw, " \t%.1f%s:\t %s\n", f, u, t.Name)
+				fmt.Fprintf(tabw, " \t%s:\t %s\n", v.FlatFormat, v.Value)
 			}
 		}
 		fmt.Fprintln(tabw)
@@ -754,14 +1039,18 @@ func printComments(w io.Writer, rpt *Report) error {
 // TextItem holds a single text report entry.
 type TextItem struct {
 	Name                  string
-	InlineLabel           string // Not empty if inlined
-	Flat, Cum             int64  // Raw values
-	FlatFormat, CumFormat string // Formatted values
+	InlineLabel           string   // Not empty if inlined
+	Flat, Cum             int64    // Raw values
+	FlatFormat, CumFormat string   // Formatted values
+	Badges                []string `json:",omitempty"` // External metadata, as key=value pairs
 }
 
 // TextItems returns a list of text items from the report and a list
 // of labels that describe the report.
 func TextItems(rpt *Report) ([]TextItem, []string) {
+	if rpt.options.MetadataGroup != "" {
+		return metadataGroupItems(rpt), metadataGroupLabels(rpt)
+	}
 	g, origCount, droppedNodes, _ := rpt.newTrimmedGraph()
 	rpt.selectOutputUnit(g)
 	labels := reportLabels(rpt, g, origCount, droppedNodes, 0, false)
@@ -771,39 +1060,46 @@ func TextItems(rpt *Report) ([]TextItem, []string) {
 	for _, n := range g.Nodes {
 		name, flat, cum := n.Info.PrintableName(), n.FlatValue(), n.CumValue()
 
-		var inline, noinline bool
-		for _, e := range n.In {
-			if e.Inline {
-				inline = true
-			} else {
-				noinline = true
-			}
-		}
-
-		var inl string
-		if inline {
-			if noinline {
-				inl = "(partial-inline)"
-			} else {
-				inl = "(inline)"
-			}
-		}
-
 		flatSum += flat
 		items = append(items, TextItem{
 			Name:        name,
-			InlineLabel: inl,
+			InlineLabel: inlineLabel(n),
 			Flat:        flat,
 			Cum:         cum,
 			FlatFormat:  rpt.formatValue(flat),
 			CumFormat:   rpt.formatValue(cum),
+			Badges:      rpt.Badges(n.Info.Name),
 		})
 	}
 	return items, labels
 }
 
+// inlineLabel returns the label of a node of a text report called
+// inline, by some or all of its callers, or "" if it is not.
+func inlineLabel(n *graph.Node) string {
+	var inline, noinline bool
+	for _, e := range n.In {
+		if e.Inline {
+			inline = true
+		} else {
+			noinline = true
+		}
+	}
+
+	switch {
+	case inline && noinline:
+		return "(partial-inline)"
+	case inline:
+		return "(inline)"
+	}
+	return ""
+}
+
 // printText prints a flat text report for a profile.
 func printText(w io.Writer, rpt *Report) error {
+	if rpt.options.Delimiter != 0 {
+		return printTextDelimited(w, rpt)
+	}
 	items, labels := TextItems(rpt)
 	fmt.Fprintln(w, strings.Join(labels, "\n"))
 	fmt.Fprintf(w, "%10s %5s%% %5s%% %10s %5s%%\n",
@@ -814,92 +1110,192 @@ func printText(w io.Writer, rpt *Report) error {
 		if inl != "" {
 			inl = " " + inl
 		}
+		if len(item.Badges) > 0 {
+			inl += " [" + strings.Join(item.Badges, " ") + "]"
+		}
 		flatSum += item.Flat
 		fmt.Fprintf(w, "%10s %s %s %10s %s  %s%s\n",
 			item.FlatFormat, measurement.Percentage(item.Flat, rpt.total),
 			measurement.Percentage(flatSum, rpt.total),
 			item.CumFormat, measurement.Percentage(item.Cum, rpt.total),
-			item.Name, inl)
+			graph.TruncateName(item.Name, rpt.options.MaxNameLen), inl)
 	}
 	return nil
 }
 
-// printTraces prints all traces from a profile.
-func printTraces(w io.Writer, rpt *Report) error {
-	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+// TraceItem holds a sample of a traces report.
+type TraceItem struct {
+	Labels    []TraceLabel // String labels
+	NumLabels []TraceLabel // Numeric labels, formatted with their units
+	Flat      int64        // Raw value
+	Value     string       // Formatted value
+	Frames    []TraceFrame // From the leaf to the root
+}
+
+// TraceLabel holds the values of a label of a sample.
+type TraceLabel struct {
+	Key    string
+	Values []string
+}
+
+// TraceFrame holds a frame of the stack of a sample.
+type TraceFrame struct {
+	Name   string
+	Inline bool
+
+	Function, FileLine string // Function name and file:line, if known
+}
+
+// traceLabelLine formats a label of a traces report.
+func traceLabelLine(l TraceLabel) string {
+	return fmt.Sprintf("%10s:  %s\n", l.Key, strings.Join(l.Values, " "))
+}
+
+// sortTraceLabels sorts labels as they are printed.
+func sortTraceLabels(labels []TraceLabel) {
+	sort.Slice(labels, func(i, j int) bool {
+		return traceLabelLine(labels[i]) < traceLabelLine(labels[j])
+	})
+}
 
+// TraceItems returns the samples of the profile of the report with a
+// stack, in the order of the traces report.
+func TraceItems(rpt *Report) []TraceItem {
 	prof := rpt.prof
 	o := rpt.options
 
-	const separator = "-----------+-------------------------------------------------------"
-
+	var items []TraceItem
 	_, locations := graph.CreateNodes(prof, &graph.Options{})
 	for _, sample := range prof.Sample {
-		type stk struct {
-			*graph.NodeInfo
-			inline bool
-		}
-		var stack []stk
+		var item TraceItem
 		for _, loc := range sample.Location {
 			nodes := locations[loc.ID]
 			for i, n := range nodes {
 				// The inline flag may be inaccurate if 'show' or 'hide' filter is
 				// used. See https://github.com/google/pprof/issues/511.
 				inline := i != len(nodes)-1
-				stack = append(stack, stk{&n.Info, inline})
+				item.Frames = append(item.Frames, TraceFrame{n.Info.PrintableName(), inline, n.Info.Name, nodeFileLine(n)})
 			}
 		}
 
-		if len(stack) == 0 {
+		if len(item.Frames) == 0 {
 			continue
 		}
 
-		fmt.Fprintln(w, separator)
-		// Print any text labels for the sample.
-		var labels []string
-		for s, vs := range sample.Label {
-			labels = append(labels, fmt.Sprintf("%10s:  %s\n", s, strings.Join(vs, " ")))
+		for key, vals := range sample.Label {
+			item.Labels = append(item.Labels, TraceLabel{key, vals})
 		}
-		sort.Strings(labels)
-		fmt.Fprint(w, strings.Join(labels, ""))
+		sortTraceLabels(item.Labels)
 
-		// Print any numeric labels for the sample
-		var numLabels []string
 		for key, vals := range sample.NumLabel {
 			unit := o.NumLabelUnits[key]
 			numValues := make([]string, len(vals))
 			for i, vv := range vals {
 				numValues[i] = measurement.Label(vv, unit)
 			}
-			numLabels = append(numLabels, fmt.Sprintf("%10s:  %s\n", key, strings.Join(numValues, " ")))
+			item.NumLabels = append(item.NumLabels, TraceLabel{key, numValues})
 		}
-		sort.Strings(numLabels)
-		fmt.Fprint(w, strings.Join(numLabels, ""))
+		sortTraceLabels(item.NumLabels)
 
 		var d, v int64
 		v = o.SampleValue(sample.Value)
 		if o.SampleMeanDivisor != nil {
 			d = o.SampleMeanDivisor(sample.Value)
 		}
-		// Print call stack.
 		if d != 0 {
 			v = v / d
 		}
-		for i, s := range stack {
+		item.Flat, item.Value = v, rpt.formatValue(v)
+		items = append(items, item)
+	}
+	return items
+}
+
+// printTraces prints all traces from a profile.
+func printTraces(w io.Writer, rpt *Report) error {
+	if rpt.options.Delimiter != 0 {
+		return printTracesDelimited(w, rpt)
+	}
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+
+	const separator = "-----------+-------------------------------------------------------"
+
+	for _, item := range TraceItems(rpt) {
+		fmt.Fprintln(w, separator)
+		// Print any text labels for the sample.
+		for _, l := range item.Labels {
+			fmt.Fprint(w, traceLabelLine(l))
+		}
+
+		// Print any numeric labels for the sample
+		for _, l := range item.NumLabels {
+			fmt.Fprint(w, traceLabelLine(l))
+		}
+
+		// Print call stack.
+		for i, f := range item.Frames {
 			var vs, inline string
 			if i == 0 {
-				vs = rpt.formatValue(v)
+				vs = item.Value
 			}
-			if s.inline {
+			if f.Inline {
 				inline = " (inline)"
 			}
-			fmt.Fprintf(w, "%10s   %s%s\n", vs, s.PrintableName(), inline)
+			fmt.Fprintf(w, "%10s   %s%s\n", vs, f.Name, inline)
 		}
 	}
 	fmt.Fprintln(w, separator)
 	return nil
 }
 
+// printFolded prints all stacks from a profile in the folded format used
+// by flamegraph.pl and other stack collapsing tools: one line per distinct
+// stack, with frames from root to leaf separated by semicolons, followed by
+// the total value of the samples with that stack. The format has no
+// escapes, so the semicolons of frame names are replaced by colons.
+func printFolded(w io.Writer, rpt *Report) error {
+	prof := rpt.prof
+	o := rpt.options
+
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	values := make(map[string]int64)
+	for _, sample := range prof.Sample {
+		var frames []string
+		for _, loc := range sample.Location {
+			for _, n := range locations[loc.ID] {
+				frames = append(frames, strings.Replace(n.Info.PrintableName(), ";", ":", -1))
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		// Folded stacks start at the root.
+		for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+			frames[i], frames[j] = frames[j], frames[i]
+		}
+
+		v := o.SampleValue(sample.Value)
+		if o.SampleMeanDivisor != nil {
+			if d := o.SampleMeanDivisor(sample.Value); d != 0 {
+				v = v / d
+			}
+		}
+		values[strings.Join(frames, ";")] += v
+	}
+
+	stacks := make([]string, 0, len(values))
+	for stack, v := range values {
+		if v != 0 {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, values[stack])
+	}
+	return nil
+}
+
 // printCallgrind prints a graph for a profile on callgrind format.
 func printCallgrind(w io.Writer, rpt *Report) error {
 	o := rpt.options
@@ -912,6 +1308,8 @@ func printCallgrind(w io.Writer, rpt *Report) error {
 
 	nodeNames := getDisambiguatedNames(g)
 
+	fmt.Fprintln(w, "version: 1")
+	fmt.Fprintln(w, "creator: pprof")
 	fmt.Fprintln(w, "positions: instr line")
 	fmt.Fprintln(w, "events:", o.SampleType+"("+o.OutputUnit+")")
 
@@ -919,15 +1317,18 @@ func printCallgrind(w io.Writer, rpt *Report) error {
 	files := make(map[string]int)
 	names := make(map[string]int)
 
-	// prevInfo points to the previous NodeInfo.
-	// It is used to group cost lines together as much as possible.
+	// prevInfo points to the previous NodeInfo and prevName to its
+	// disambiguated name. They are used to group cost lines together as
+	// much as possible.
 	var prevInfo *graph.NodeInfo
+	var prevName string
 	for _, n := range g.Nodes {
-		if prevInfo == nil || n.Info.Objfile != prevInfo.Objfile || n.Info.File != prevInfo.File || n.Info.Name != prevInfo.Name {
+		name := nodeNames[n]
+		if prevInfo == nil || n.Info.Objfile != prevInfo.Objfile || n.Info.File != prevInfo.File || name != prevName {
 			fmt.Fprintln(w)
 			fmt.Fprintln(w, "ob="+callgrindName(objfiles, n.Info.Objfile))
 			fmt.Fprintln(w, "fl="+callgrindName(files, n.Info.File))
-			fmt.Fprintln(w, "fn="+callgrindName(names, n.Info.Name))
+			fmt.Fprintln(w, "fn="+callgrindName(names, name))
 		}
 
 		addr := callgrindAddress(prevInfo, n.Info.Address)
@@ -938,6 +1339,9 @@ func printCallgrind(w io.Writer, rpt *Report) error {
 		for _, out := range n.Out.Sort() {
 			c, _ := measurement.Scale(out.Weight, o.SampleUnit, o.OutputUnit)
 			callee := out.Dest
+			if callee.Info.Objfile != n.Info.Objfile {
+				fmt.Fprintln(w, "cob="+callgrindName(objfiles, callee.Info.Objfile))
+			}
 			fmt.Fprintln(w, "cfl="+callgrindName(files, callee.Info.File))
 			fmt.Fprintln(w, "cfn="+callgrindName(names, nodeNames[callee]))
 			// pprof doesn't have a flat weight for a call, leave as 0.
@@ -949,7 +1353,7 @@ func printCallgrind(w io.Writer, rpt *Report) error {
 			fmt.Fprintf(w, "* * %d\n", int64(c))
 		}
 
-		prevInfo = &n.Info
+		prevInfo, prevName = &n.Info, name
 	}
 
 	return nil
@@ -1053,7 +1457,7 @@ func printTree(w io.Writer, rpt *Report) error {
 	fmt.Fprintln(w, legend)
 	var flatSum int64
 
-	rx := rpt.options.Symbol
+	rx, maxNameLen := rpt.options.Symbol, rpt.options.MaxNameLen
 	for _, n := range g.Nodes {
 		name, flat, cum := n.Info.PrintableName(), n.FlatValue(), n.CumValue()
 
@@ -1071,7 +1475,7 @@ func printTree(w io.Writer, rpt *Report) error {
 				inline = " (inline)"
 			}
 			fmt.Fprintf(w, "%50s %s |   %s%s\n", rpt.formatValue(in.Weight),
-				measurement.Percentage(in.Weight, cum), in.Src.Info.PrintableName(), inline)
+				measurement.Percentage(in.Weight, cum), graph.TruncateName(in.Src.Info.PrintableName(), maxNameLen), inline)
 		}
 
 		// Print current node.
@@ -1082,7 +1486,7 @@ func printTree(w io.Writer, rpt *Report) error {
 			measurement.Percentage(flatSum, rpt.total),
 			rpt.formatValue(cum),
 			measurement.Percentage(cum, rpt.total),
-			name)
+			graph.TruncateName(name, maxNameLen))
 
 		// Print outgoing edges.
 		outEdges := n.Out.Sort()
@@ -1092,7 +1496,7 @@ func printTree(w io.Writer, rpt *Report) error {
 				inline = " (inline)"
 			}
 			fmt.Fprintf(w, "%50s %s |   %s%s\n", rpt.formatValue(out.Weight),
-				measurement.Percentage(out.Weight, cum), out.Dest.Info.PrintableName(), inline)
+				measurement.Percentage(out.Weight, cum), graph.TruncateName(out.Dest.Info.PrintableName(), maxNameLen), inline)
 		}
 	}
 	if len(g.Nodes) > 0 {
@@ -1111,8 +1515,11 @@ func GetDOT(rpt *Report) (*graph.Graph, *graph.DotConfig) {
 	c := &graph.DotConfig{
 		Title:       rpt.options.Title,
 		Labels:      labels,
+		Heading:     rpt.options.GraphTitle,
+		Notes:       rpt.options.Annotations,
 		FormatValue: rpt.formatValue,
 		Total:       rpt.total,
+		MaxNameLen:  rpt.options.MaxNameLen,
 	}
 	return g, c
 }
@@ -1120,7 +1527,90 @@ func GetDOT(rpt *Report) (*graph.Graph, *graph.DotConfig) {
 // printDOT prints an annotated callgraph in DOT format.
 func printDOT(w io.Writer, rpt *Report) error {
 	g, c := GetDOT(rpt)
-	graph.ComposeDot(w, g, &graph.DotAttributes{}, c)
+	a := &graph.DotAttributes{}
+	if rpt.options.DotMetadata {
+		c.Metadata = true
+		a = dotMetadata(rpt, g)
+	}
+	graph.ComposeDot(w, g, a, c)
+	return nil
+}
+
+// dotMetadata returns the DOT attributes that identify the nodes of g in
+// the profile of rpt, with the IDs of their functions and the build IDs
+// of their binaries, so that tools can process the graph without the
+// profile.
+func dotMetadata(rpt *Report, g *graph.Graph) *graph.DotAttributes {
+	o := rpt.options
+	a := &graph.DotAttributes{
+		Nodes: make(map[*graph.Node]*graph.DotNodeAttributes, len(g.Nodes)),
+		Graph: map[string]string{
+			"pprof_sample_type": o.SampleType,
+			"pprof_sample_unit": o.SampleUnit,
+		},
+	}
+
+	// Find the locations of each node as the graph nodes are built.
+	functionIDs := make(map[graph.NodeInfo]map[uint64]bool)
+	buildIDs := make(map[graph.NodeInfo]map[string]bool)
+	_, locations := graph.CreateNodes(rpt.prof, &graph.Options{})
+	for _, l := range rpt.prof.Location {
+		for i, n := range locations[l.ID] {
+			if i < len(l.Line) && l.Line[i].Function != nil {
+				if functionIDs[n.Info] == nil {
+					functionIDs[n.Info] = make(map[uint64]bool)
+				}
+				functionIDs[n.Info][l.Line[i].Function.ID] = true
+			}
+			if l.Mapping != nil && l.Mapping.BuildID != "" {
+				if buildIDs[n.Info] == nil {
+					buildIDs[n.Info] = make(map[string]bool)
+				}
+				buildIDs[n.Info][l.Mapping.BuildID] = true
+			}
+		}
+	}
+
+	for _, n := range g.Nodes {
+		m := make(map[string]string)
+		for name, v := range map[string]string{
+			"pprof_name":    n.Info.Name,
+			"pprof_file":    n.Info.File,
+			"pprof_objfile": n.Info.Objfile,
+		} {
+			if v != "" {
+				m[name] = v
+			}
+		}
+		if ids := functionIDs[n.Info]; len(ids) > 0 {
+			var sorted []uint64
+			for id := range ids {
+				sorted = append(sorted, id)
+			}
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			var s []string
+			for _, id := range sorted {
+				s = append(s, strconv.FormatUint(id, 10))
+			}
+			m["pprof_function_ids"] = strings.Join(s, ",")
+		}
+		if ids := buildIDs[n.Info]; len(ids) > 0 {
+			var s []string
+			for id := range ids {
+				s = append(s, id)
+			}
+			sort.Strings(s)
+			m["pprof_build_ids"] = strings.Join(s, ",")
+		}
+		a.Nodes[n] = &graph.DotNodeAttributes{Metadata: m}
+	}
+	return a
+}
+
+// printMermaid prints an annotated callgraph as a Mermaid flowchart.
+func printMermaid(w io.Writer, rpt *Report) error {
+	g, c := GetDOT(rpt)
+	graph.ComposeMermaid(w, g, c)
 	return nil
 }
 
@@ -1241,8 +1731,11 @@ func New(prof *profile.Profile, o *Options) *Report {
 		}
 		return measurement.ScaledLabel(v, o.SampleUnit, o.OutputUnit)
 	}
-	return &Report{prof, computeTotal(prof, o.SampleValue, o.SampleMeanDivisor),
-		o, format}
+	total := o.Total
+	if total == 0 {
+		total = computeTotal(prof, o.SampleValue, o.SampleMeanDivisor)
+	}
+	return &Report{prof, total, o, format}
 }
 
 // NewDefault builds a new report indexing the last sample value
@@ -1304,6 +1797,15 @@ type Report struct {
 // Total returns the total number of samples in a report.
 func (rpt *Report) Total() int64 { return rpt.total }
 
+// SampleType returns the type of the sample values of a report.
+func (rpt *Report) SampleType() string { return rpt.options.SampleType }
+
+// SampleUnit returns the unit of the raw sample values of a report.
+func (rpt *Report) SampleUnit() string { return rpt.options.SampleUnit }
+
+// Profile returns the profile of a report, which callers must not modify.
+func (rpt *Report) Profile() *profile.Profile { return rpt.prof }
+
 func abs64(i int64) int64 {
 	if i < 0 {
 		return -i
@@ -16,9 +16,13 @@ package report
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"reflect"
 	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/google/pprof/internal/binutils"
@@ -248,6 +252,128 @@ func TestDisambiguation(t *testing.T) {
 	}
 }
 
+func TestCallgrindCallsAcrossObjects(t *testing.T) {
+	m := []*profile.Mapping{
+		{ID: 1, File: "/bin/main", HasFunctions: true},
+		{ID: 2, File: "/lib/libc.so", HasFunctions: true},
+	}
+	f := []*profile.Function{
+		{ID: 1, Name: "main", Filename: "main.c"},
+		{ID: 2, Name: "memcpy", Filename: "memcpy.S"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Mapping: m[0], Address: 0x1000, Line: []profile.Line{{Function: f[0], Line: 3}}},
+		{ID: 2, Mapping: m[1], Address: 0x2000, Line: []profile.Line{{Function: f[1], Line: 7}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{l[1], l[0]}, Value: []int64{5}}},
+		Location:   l,
+		Function:   f,
+		Mapping:    m,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Callgrind,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleType:   "samples",
+		SampleUnit:   "count",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"version: 1\n",
+		"ob=(1) /lib/libc.so\nfl=(1) memcpy.S\nfn=(1) memcpy\n0x2000 7 5\n",
+		"ob=(2) /bin/main\nfl=(2) main.c\nfn=(2) main\n-4096 3 0\ncob=(1)\ncfl=(1)\ncfn=(1)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("callgrind output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestFoldedFrameNames(t *testing.T) {
+	f := []*profile.Function{
+		{ID: 1, Name: "main"},
+		{ID: 2, Name: "lambda;1"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Line: []profile.Line{{Function: f[0]}}},
+		{ID: 2, Line: []profile.Line{{Function: f[1]}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{l[1], l[0]}, Value: []int64{5}}},
+		Location:   l,
+		Function:   f,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Folded,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	// The semicolons of frame names would separate frames.
+	if got, want := buf.String(), "main;lambda:1 5\n"; got != want {
+		t.Errorf("got folded stacks %q, want %q", got, want)
+	}
+}
+
+func TestDotMetadata(t *testing.T) {
+	m := []*profile.Mapping{
+		{ID: 1, File: "/bin/main", BuildID: "aaaa", HasFunctions: true},
+		{ID: 2, File: "/lib/libc.so", BuildID: "bbbb", HasFunctions: true},
+		{ID: 3, File: "/lib32/libc.so", BuildID: "cccc", HasFunctions: true},
+	}
+	f := []*profile.Function{
+		{ID: 1, Name: "main", Filename: "main.c"},
+		{ID: 2, Name: "memcpy", Filename: "memcpy.S"},
+		{ID: 3, Name: "memcpy", Filename: "memcpy.S"},
+	}
+	l := []*profile.Location{
+		{ID: 1, Mapping: m[0], Line: []profile.Line{{Function: f[0], Line: 3}}},
+		{ID: 2, Mapping: m[1], Line: []profile.Line{{Function: f[1], Line: 7}}},
+		{ID: 3, Mapping: m[2], Line: []profile.Line{{Function: f[2], Line: 7}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[1], l[0]}, Value: []int64{5}},
+			{Location: []*profile.Location{l[2], l[0]}, Value: []int64{2}},
+		},
+		Location: l,
+		Function: f,
+		Mapping:  m,
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Dot,
+		DotMetadata:  true,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+		SampleType:   "samples",
+		SampleUnit:   "count",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`pprof_flat=7 pprof_cum=7 pprof_build_ids="bbbb,cccc" pprof_file="memcpy.S" pprof_function_ids="2,3" pprof_name="memcpy"]`,
+		`pprof_flat=0 pprof_cum=7 pprof_build_ids="aaaa" pprof_file="main.c" pprof_function_ids="1" pprof_name="main"]`,
+		` pprof_weight=7]`,
+		"pprof_total=7\n",
+		`pprof_sample_type="samples" pprof_sample_unit="count"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("dot output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
 func TestFunctionMap(t *testing.T) {
 
 	fm := make(functionMap)
@@ -279,6 +405,21 @@ func TestFunctionMap(t *testing.T) {
 	}
 }
 
+func TestGenerateCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rpt := New(testProfile.Copy(), &Options{
+		OutputFormat: Text,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   testProfile.SampleType[1].Type,
+		SampleUnit:   testProfile.SampleType[1].Unit,
+		Context:      ctx,
+	})
+	if err := Generate(ioutil.Discard, rpt, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Generate with a canceled context: got error %v, want %v", err, context.Canceled)
+	}
+}
+
 func TestLegendActiveFilters(t *testing.T) {
 	activeFilterInput := []string{
 		"focus=123|456|789|101112|131415|161718|192021|222324|252627|282930|313233|343536|363738|acbdefghijklmnop",
@@ -417,3 +558,151 @@ func TestComputeTotal(t *testing.T) {
 		})
 	}
 }
+
+func TestAssemblyHotSource(t *testing.T) {
+	obj := diffObjTool{
+		"/bin/app": {
+			{Addr: 0x1000, Text: "push rbp", File: "testdata/source1", Line: 1},
+			{Addr: 0x1001, Text: "mov eax, 0x1", File: "testdata/source1", Line: 2},
+			{Addr: 0x1006, Text: "add eax, ebx", File: "testdata/source1", Line: 3},
+			{Addr: 0x1008, Text: "jne 1001 <hot+0x1>", File: "testdata/source1", Line: 3},
+			{Addr: 0x100a, Text: "ret", File: "testdata/missing", Line: 4},
+		},
+	}
+	m := &profile.Mapping{ID: 1, File: "/bin/app", Start: 0x1000, Limit: 0x2000}
+	f := &profile.Function{ID: 1, Name: "hot"}
+	var l []*profile.Location
+	for _, a := range []uint64{0x1000, 0x1006, 0x100a} {
+		l = append(l, &profile.Location{ID: uint64(len(l) + 1), Mapping: m, Address: a, Line: []profile.Line{{Function: f}}})
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[0]}, Value: []int64{1}},
+			{Location: []*profile.Location{l[1]}, Value: []int64{30}},
+			{Location: []*profile.Location{l[2]}, Value: []int64{4}},
+		},
+		Location: l,
+		Function: []*profile.Function{f},
+		Mapping:  []*profile.Mapping{m},
+	}
+
+	for _, tc := range []struct {
+		desc          string
+		source        bool
+		hot           float64
+		want, notWant []string
+	}{
+		{
+			desc:   "with source",
+			source: true,
+			want: []string{
+				`(?m)^ +source1:1: source1 line 1;\n +1 +1 +1000: push rbp\n`,
+				`(?m)^ +source1:3: source1 line 3;\n +30 +30 +1006: add eax, ebx\n +\. +\. +1008: jne`,
+				`(?m)^ +missing:4\n +4 +4 +100a: ret\n`,
+			},
+			notWant: []string{"⋮", ";hot"},
+		},
+		{
+			desc: "only hot",
+			hot:  0.1,
+			want: []string{
+				`(?m)^ +⋮\n +\. +\. +1001: mov eax, 0x1 +;source1:2\n +30 +30 +1006: add eax, ebx +;hot source1:3\n`,
+				`(?m)^ +4 +4 +100a: ret +;hot missing:4\n\z`,
+			},
+			notWant: []string{"push rbp", "source1 line"},
+		},
+	} {
+		rpt := New(p, &Options{
+			OutputFormat:   Dis,
+			OutputUnit:     "minimum",
+			Symbol:         regexp.MustCompile("hot"),
+			SampleValue:    func(v []int64) int64 { return v[0] },
+			SourcePath:     ".",
+			AsmSource:      tc.source,
+			AsmHotFraction: tc.hot,
+		})
+		var buf bytes.Buffer
+		if err := Generate(&buf, rpt, obj); err != nil {
+			t.Fatalf("%s: Generate: %v", tc.desc, err)
+		}
+		for _, want := range tc.want {
+			if !regexp.MustCompile(want).MatchString(buf.String()) {
+				t.Errorf("%s: report does not match %q:\n%s", tc.desc, want, buf.String())
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if strings.Contains(buf.String(), notWant) {
+				t.Errorf("%s: report contains %q:\n%s", tc.desc, notWant, buf.String())
+			}
+		}
+	}
+}
+
+func TestJumpTarget(t *testing.T) {
+	for _, tc := range []struct {
+		inst   string
+		target uint64
+		jump   bool
+	}{
+		{"jmp 1001 <hot+0x1>", 0x1001, true},
+		{"JNE 0x4005f0", 0x4005f0, true},
+		{"bnd jmp 4010", 0x4010, true},
+		{"jmp *%rax", 0, true},
+		{"b.ne 4005f0 <foo+0x20>", 0x4005f0, true},
+		{"cbz w0, 400600", 0x400600, true},
+		{"retq", 0, true},
+		{"bl 400700 <bar>", 0, false},
+		{"callq 400700 <bar>", 0, false},
+		{"mov eax, 0x1", 0, false},
+	} {
+		target, jump := jumpTarget(tc.inst)
+		if target != tc.target || jump != tc.jump {
+			t.Errorf("jumpTarget(%q) = %x, %v, want %x, %v", tc.inst, target, jump, tc.target, tc.jump)
+		}
+	}
+}
+
+func TestTagGroupsFairShare(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Value: []int64{10}, Label: map[string][]string{"tenant": {"a", "b", "c"}}, NumLabel: map[string][]int64{"size": {1, 2}}},
+			{Value: []int64{5}, Label: map[string][]string{"tenant": {"a"}}},
+		},
+	}
+	for _, tc := range []struct {
+		fairShare bool
+		want      map[string]map[string]int64
+	}{
+		{
+			want: map[string]map[string]int64{
+				"tenant": {"a": 15, "b": 10, "c": 10},
+				"size":   {"1": 10, "2": 10},
+			},
+		},
+		{
+			// The shares add up to the value of the samples.
+			fairShare: true,
+			want: map[string]map[string]int64{
+				"tenant": {"a": 8, "b": 3, "c": 4},
+				"size":   {"1": 5, "2": 5},
+			},
+		},
+	} {
+		rpt := New(p, &Options{
+			SampleValue: func(v []int64) int64 { return v[0] },
+			FairShare:   tc.fairShare,
+		})
+		got := make(map[string]map[string]int64)
+		for _, g := range TagGroups(rpt) {
+			got[g.Key] = make(map[string]int64)
+			for _, v := range g.Values {
+				got[g.Key][v.Value] = v.Flat
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("fair share %v: got tags %v, want %v", tc.fairShare, got, tc.want)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -35,11 +36,28 @@ import (
 	"github.com/google/pprof/profile"
 )
 
-// printSource prints an annotated source listing, include all
-// functions with samples that match the regexp rpt.options.symbol.
-// The sources are sorted by function name and then by filename to
-// eliminate potential nondeterminism.
-func printSource(w io.Writer, rpt *Report) error {
+// SourceRoutine holds the annotated source of a function in one of its
+// files, for source listings. File is empty if there is no source
+// information for the function.
+type SourceRoutine struct {
+	Name, File string
+	Flat, Cum  int64  // Raw values
+	Error      string // Error reading the file, if any
+	Lines      []SourceLine
+}
+
+// SourceLine holds a line of a source listing, and the value of its
+// samples.
+type SourceLine struct {
+	Line      int
+	Source    string
+	Flat, Cum int64 // Raw values
+}
+
+// SourceRoutines returns the annotated sources of the functions with
+// samples that match the regexp rpt.options.symbol, sorted by function
+// name and then by filename to eliminate potential nondeterminism.
+func SourceRoutines(rpt *Report) ([]SourceRoutine, error) {
 	o := rpt.options
 	g := rpt.newGraph(nil)
 
@@ -62,13 +80,13 @@ func printSource(w io.Writer, rpt *Report) error {
 	if sourcePath == "" {
 		wd, err := os.Getwd()
 		if err != nil {
-			return fmt.Errorf("could not stat current dir: %v", err)
+			return nil, fmt.Errorf("could not stat current dir: %v", err)
 		}
 		sourcePath = wd
 	}
 	reader := newSourceReader(sourcePath, o.TrimPath)
 
-	fmt.Fprintf(w, "Total: %s\n", rpt.formatValue(rpt.total))
+	var routines []SourceRoutine
 	for _, fn := range functions {
 		name := fn.Info.Name
 
@@ -87,32 +105,58 @@ func printSource(w io.Writer, rpt *Report) error {
 		}
 
 		if len(sourceFiles) == 0 {
-			fmt.Fprintf(w, "No source information for %s\n", name)
+			routines = append(routines, SourceRoutine{Name: name})
 			continue
 		}
 
 		sourceFiles.Sort(graph.FileOrder)
 
-		// Print each file associated with this function.
+		// Annotate each file associated with this function.
 		for _, fl := range sourceFiles {
 			filename := fl.Info.File
 			fns := fileNodes[filename]
-			flatSum, cumSum := fns.Sum()
+			r := SourceRoutine{Name: name, File: filename}
+			r.Flat, r.Cum = fns.Sum()
 
 			fnodes, _, err := getSourceFromFile(filename, reader, fns, 0, 0)
-			fmt.Fprintf(w, "ROUTINE ======================== %s in %s\n", name, filename)
-			fmt.Fprintf(w, "%10s %10s (flat, cum) %s of Total\n",
-				rpt.formatValue(flatSum), rpt.formatValue(cumSum),
-				measurement.Percentage(cumSum, rpt.total))
-
 			if err != nil {
-				fmt.Fprintf(w, " Error: %v\n", err)
-				continue
+				r.Error = err.Error()
 			}
-
 			for _, fn := range fnodes {
-				fmt.Fprintf(w, "%10s %10s %6d:%s\n", valueOrDot(fn.Flat, rpt), valueOrDot(fn.Cum, rpt), fn.Info.Lineno, fn.Info.Name)
+				r.Lines = append(r.Lines, SourceLine{Line: fn.Info.Lineno, Source: fn.Info.Name, Flat: fn.Flat, Cum: fn.Cum})
 			}
+			routines = append(routines, r)
+		}
+	}
+	return routines, nil
+}
+
+// printSource prints an annotated source listing, include all
+// functions with samples that match the regexp rpt.options.symbol.
+func printSource(w io.Writer, rpt *Report) error {
+	routines, err := SourceRoutines(rpt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Total: %s\n", rpt.formatValue(rpt.total))
+	for _, r := range routines {
+		if r.File == "" {
+			fmt.Fprintf(w, "No source information for %s\n", r.Name)
+			continue
+		}
+		fmt.Fprintf(w, "ROUTINE ======================== %s in %s\n", r.Name, r.File)
+		fmt.Fprintf(w, "%10s %10s (flat, cum) %s of Total\n",
+			rpt.formatValue(r.Flat), rpt.formatValue(r.Cum),
+			measurement.Percentage(r.Cum, rpt.total))
+
+		if r.Error != "" {
+			fmt.Fprintf(w, " Error: %s\n", r.Error)
+			continue
+		}
+
+		for _, l := range r.Lines {
+			fmt.Fprintf(w, "%10s %10s %6d:%s\n", valueOrDot(l.Flat, rpt), valueOrDot(l.Cum, rpt), l.Line, l.Source)
 		}
 	}
 	return nil
@@ -604,7 +648,7 @@ func (sp *sourcePrinter) printFile(w io.Writer, f *sourceFile, rpt *Report) {
 			continue
 		}
 		printFunctionHeader(w, fn.name, f.fname, fn.flat, fn.cum, rpt)
-		var asm []assemblyInstruction
+		var lines []sourceLine
 		for l := fn.begin; l < fn.end; l++ {
 			lineContents, ok := sp.reader.line(f.fname, l)
 			if !ok {
@@ -622,7 +666,7 @@ func (sp *sourcePrinter) printFile(w io.Writer, f *sourceFile, rpt *Report) {
 			}
 
 			// Make list of assembly instructions.
-			asm = asm[:0]
+			var asm []assemblyInstruction
 			var flatSum, cumSum int64
 			var lastAddr uint64
 			for _, inst := range f.lines[l] {
@@ -647,7 +691,29 @@ func (sp *sourcePrinter) printFile(w io.Writer, f *sourceFile, rpt *Report) {
 				})
 			}
 
-			printFunctionSourceLine(w, l, flatSum, cumSum, lineContents, asm, sp.reader, rpt)
+			lines = append(lines, sourceLine{l, flatSum, cumSum, lineContents, asm})
+		}
+
+		// Collapse the runs of lines without samples that are long enough
+		// to hide the hot ones around them.
+		hl := newHighlighter(f.fname)
+		for i := 0; i < len(lines); {
+			j := i
+			for j < len(lines) && lines[j].flat == 0 && lines[j].cum == 0 {
+				j++
+			}
+			if j-i < minColdLines {
+				j = i + 1
+			} else {
+				fmt.Fprintf(w, "<span class=coldtoggle> %6s ⋯ %d lines without samples\n</span><span class=coldlines>", "", j-i)
+			}
+			for _, ln := range lines[i:j] {
+				printFunctionSourceLine(w, ln, fn.cum, hl, sp.reader, rpt)
+			}
+			if j-i >= minColdLines {
+				fmt.Fprint(w, "</span>")
+			}
+			i = j
 		}
 		printFunctionClosing(w)
 	}
@@ -779,21 +845,39 @@ func printFunctionHeader(w io.Writer, name, path string, flatSum, cumSum int64,
 		measurement.Percentage(cumSum, rpt.total))
 }
 
-// printFunctionSourceLine prints a source line and the corresponding assembly.
-func printFunctionSourceLine(w io.Writer, lineNo int, flat, cum int64, lineContents string,
-	assembly []assemblyInstruction, reader *sourceReader, rpt *Report) {
-	if len(assembly) == 0 {
+// sourceLine is a line of a function of a weblist report, with its
+// values and assembly instructions.
+type sourceLine struct {
+	lineNo    int
+	flat, cum int64
+	contents  string
+	assembly  []assemblyInstruction
+}
+
+// minColdLines is the length of the shortest run of lines without samples
+// collapsed in weblist reports.
+const minColdLines = 8
+
+// printFunctionSourceLine prints a source line and the corresponding
+// assembly, with a bar of the share of the cumulative value fnCum of its
+// function, highlighting the source with hl.
+func printFunctionSourceLine(w io.Writer, ln sourceLine, fnCum int64, hl *highlighter, reader *sourceReader, rpt *Report) {
+	var bar string
+	if ln.cum != 0 && fnCum != 0 {
+		bar = fmt.Sprintf(`<span style="width:%.0f%%"></span>`, 100*math.Min(1, math.Abs(float64(ln.cum)/float64(fnCum))))
+	}
+	if len(ln.assembly) == 0 {
 		fmt.Fprintf(w,
-			"<span class=line> %6d</span> <span class=nop>  %10s %10s %8s  %s </span>\n",
-			lineNo,
-			valueOrDot(flat, rpt), valueOrDot(cum, rpt),
-			"", template.HTMLEscapeString(lineContents))
+			"<span class=line> %6d</span> <span class=nop>  %10s %10s <span class=bar>%s</span>  %s </span>\n",
+			ln.lineNo,
+			valueOrDot(ln.flat, rpt), valueOrDot(ln.cum, rpt),
+			bar, hl.line(ln.contents))
 		return
 	}
 
 	nestedInfo := false
 	cl := "deadsrc"
-	for _, an := range assembly {
+	for _, an := range ln.assembly {
 		if len(an.inlineCalls) > 0 || an.instruction != synthAsm {
 			nestedInfo = true
 			cl = "livesrc"
@@ -801,13 +885,13 @@ func printFunctionSourceLine(w io.Writer, lineNo int, flat, cum int64, lineConte
 	}
 
 	fmt.Fprintf(w,
-		"<span class=line> %6d</span> <span class=%s>  %10s %10s %8s  %s </span>",
-		lineNo, cl,
-		valueOrDot(flat, rpt), valueOrDot(cum, rpt),
-		"", template.HTMLEscapeString(lineContents))
+		"<span class=line> %6d</span> <span class=%s>  %10s %10s <span class=bar>%s</span>  %s </span>",
+		ln.lineNo, cl,
+		valueOrDot(ln.flat, rpt), valueOrDot(ln.cum, rpt),
+		bar, hl.line(ln.contents))
 	if nestedInfo {
-		srcIndent := indentation(lineContents)
-		printNested(w, srcIndent, assembly, reader, rpt)
+		srcIndent := indentation(ln.contents)
+		printNested(w, srcIndent, ln.assembly, reader, rpt)
 	}
 	fmt.Fprintln(w)
 }
@@ -849,8 +933,8 @@ func printNested(w io.Writer, srcIndent int, assembly []assemblyInstruction, rea
 			continue
 		}
 		text := strings.Repeat(" ", srcIndent+4+4*len(curCalls)) + an.instruction
-		fmt.Fprintf(w, " %8s %10s %10s %8x: %s <span class=unimportant>%s</span>\n",
-			"", valueOrDot(flat, rpt), valueOrDot(cum, rpt), an.address,
+		fmt.Fprintf(w, " %s %10s %10s <span data-addr=%x>%8x</span>: %s <span class=unimportant>%s</span>\n",
+			jumpArrow(an), valueOrDot(flat, rpt), valueOrDot(cum, rpt), an.address, an.address,
 			template.HTMLEscapeString(rightPad(text, 80)),
 			// fileline should not be escaped since it was formed by appending
 			// line number (just digits) to an escaped file name. Escaping here
@@ -860,6 +944,22 @@ func printNested(w io.Writer, srcIndent int, assembly []assemblyInstruction, rea
 	fmt.Fprint(w, "</span>")
 }
 
+// jumpArrow returns the 8 columns printed before an instruction of a
+// weblist report: an arrow pointing up for the jumps to a lower address,
+// as in loops, and down for the others, which shows the instruction
+// jumped to when clicked.
+func jumpArrow(an assemblyInstruction) string {
+	target, ok := jumpTarget(an.instruction)
+	if !ok || target == 0 {
+		return strings.Repeat(" ", 8)
+	}
+	arrow := "↓"
+	if target <= an.address {
+		arrow = "↑"
+	}
+	return fmt.Sprintf(`%6s<span class=jump data-target=%x title="Jump to %x">%s</span> `, "", target, target, arrow)
+}
+
 // printFunctionClosing prints the end of a function in a weblist report.
 func printFunctionClosing(w io.Writer) {
 	fmt.Fprintln(w, "</pre>")
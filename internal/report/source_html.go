@@ -50,6 +50,47 @@ background-color: #eeeeee;
 color: #008800;
 display: none;
 }
+.kw {
+  color: #0000cc;
+}
+.str {
+  color: #a31515;
+}
+.num {
+  color: #098658;
+}
+.com {
+  color: #6a737d;
+  font-style: italic;
+}
+.bar {
+  display: inline-block;
+  width: 8ch;
+  height: 0.8em;
+  vertical-align: middle;
+}
+.bar span {
+  display: inline-block;
+  height: 100%;
+  background-color: #e8735a;
+}
+.coldtoggle {
+  color: #aaaaaa;
+  cursor: pointer;
+}
+.coldtoggle:hover {
+  background-color: #eeeeee;
+}
+.coldlines {
+  display: none;
+}
+.jump {
+  color: #0000cc;
+  cursor: pointer;
+}
+.jumptarget {
+  background-color: #ffff99;
+}
 </style>`
 
 const weblistPageScript = `<script type="text/javascript">
@@ -59,15 +100,46 @@ function pprof_toggle_asm(e) {
   if (e.target) target = e.target;
   else if (e.srcElement) target = e.srcElement;
 
+  // Show the instruction a jump goes to, expanding the lines it is in.
+  var jump = target && target.closest && target.closest(".jump");
+  if (jump) {
+    pprof_show_jump(jump);
+    e.preventDefault();
+    return false;
+  }
+
+  // The spans highlighting the source are within the clicked line.
+  if (target && target.closest) target = target.closest(".livesrc, .coldtoggle");
+
   if (target) {
-    var asm = target.nextSibling;
-    if (asm && asm.className == "asm") {
-      asm.style.display = (asm.style.display == "block" ? "" : "block");
+    var hidden = target.nextSibling;
+    if (hidden && hidden.className == "asm") {
+      hidden.style.display = (hidden.style.display == "block" ? "" : "block");
+      e.preventDefault();
+      return false;
+    }
+    // Expand or collapse the lines without samples.
+    if (hidden && hidden.className == "coldlines") {
+      hidden.style.display = (hidden.style.display == "inline" ? "" : "inline");
       e.preventDefault();
       return false;
     }
   }
 }
+
+function pprof_show_jump(jump) {
+  var pre = jump.closest("pre");
+  var dest = pre.querySelector('[data-addr="' + jump.dataset.target + '"]');
+  if (!dest) return;
+  for (var p = dest.parentElement; p != pre; p = p.parentElement) {
+    if (p.className == "asm") p.style.display = "block";
+    if (p.className == "coldlines") p.style.display = "inline";
+  }
+  var prev = pre.querySelector(".jumptarget");
+  if (prev) prev.classList.remove("jumptarget");
+  dest.classList.add("jumptarget");
+  dest.scrollIntoView({block: "center"});
+}
 </script>`
 
 const weblistPageClosing = `
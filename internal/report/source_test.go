@@ -47,7 +47,7 @@ func TestWebList(t *testing.T) {
 	}
 	output := buf.String()
 
-	for _, expect := range []string{"func busyLoop", "callq.*mapassign"} {
+	for _, expect := range []string{"<span class=kw>func</span> busyLoop", "callq.*mapassign"} {
 		if match, _ := regexp.MatchString(expect, output); !match {
 			t.Errorf("weblist output does not contain '%s':\n%s", expect, output)
 		}
@@ -122,6 +122,86 @@ func testSourceMapping(t *testing.T, zeroAddress bool) {
 	}
 }
 
+func TestWebListSourceLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof_weblist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var src []string
+	for i := 1; i <= 30; i++ {
+		src = append(src, fmt.Sprintf("\tx += %d // line %d", i, i))
+	}
+	src[0] = "func hot() {"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hot.go"), []byte(strings.Join(src, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &profile.Function{ID: 1, Name: "hot", Filename: "hot.go"}
+	var locs []*profile.Location
+	for _, line := range []int64{2, 20} {
+		locs = append(locs, &profile.Location{ID: uint64(len(locs) + 1), Address: uint64(len(locs) + 1), Line: []profile.Line{{Function: f, Line: line}}})
+	}
+	rpt := &Report{
+		prof: &profile.Profile{
+			Sample: []*profile.Sample{
+				{Value: []int64{30}, Location: locs[:1]},
+				{Value: []int64{10}, Location: locs[1:]},
+			},
+			Location: locs,
+			Function: []*profile.Function{f},
+		},
+		options: &Options{
+			Symbol:      regexp.MustCompile("hot"),
+			SampleValue: func(s []int64) int64 { return s[0] },
+			SourcePath:  dir,
+		},
+		formatValue: func(v int64) string { return fmt.Sprint(v) },
+	}
+	var out bytes.Buffer
+	if err := PrintWebList(&out, rpt, nil, -1); err != nil {
+		t.Fatalf("PrintWebList: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		// The source is highlighted.
+		`<span class=kw>func</span> hot\(\) {`,
+		`x \+= <span class=num>2</span> <span class=com>// line 2</span>`,
+		// The lines have bars of their share of the function.
+		`30 +30 <span class=bar><span style="width:75%"></span></span>`,
+		`10 +10 <span class=bar><span style="width:25%"></span></span>`,
+		`\. +\. <span class=bar></span>`,
+		// The lines without samples between the hot ones are collapsed,
+		// unlike the shorter runs around them.
+		`(?s)<span class=coldtoggle> +⋯ 17 lines without samples\n</span><span class=coldlines>.*// line 3\b.*// line 19\b.*</span>.*line 20\b`,
+	} {
+		if !regexp.MustCompile(want).MatchString(got) {
+			t.Errorf("weblist does not match %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "class=coldtoggle"); n != 1 {
+		t.Errorf("got %d collapsed runs, want 1:\n%s", n, got)
+	}
+}
+
+func TestJumpArrow(t *testing.T) {
+	for _, tc := range []struct {
+		address uint64
+		inst    string
+		want    string
+	}{
+		{0x1008, "jne 1001 <hot+0x1>", `      <span class=jump data-target=1001 title="Jump to 1001">↑</span> `},
+		{0x1008, "jmp 0x1010", `      <span class=jump data-target=1010 title="Jump to 1010">↓</span> `},
+		{0x1008, "jmp *%rax", "        "},
+		{0x1008, "ret", "        "},
+		{0x1008, "add eax, ebx", "        "},
+	} {
+		if got := jumpArrow(assemblyInstruction{address: tc.address, instruction: tc.inst}); got != tc.want {
+			t.Errorf("jumpArrow(%q) = %q, want %q", tc.inst, got, tc.want)
+		}
+	}
+}
+
 func TestOpenSourceFile(t *testing.T) {
 	tempdir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -0,0 +1,146 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to export profiles on the file format of
+// speedscope (https://www.speedscope.app), described by
+// https://www.speedscope.app/file-format-schema.json.
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	"github.com/google/pprof/internal/graph"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeFile struct {
+	Schema             string               `json:"$schema"`
+	Shared             speedscopeShared     `json:"shared"`
+	Profiles           []*speedscopeProfile `json:"profiles"`
+	Name               string               `json:"name,omitempty"`
+	ActiveProfileIndex int                  `json:"activeProfileIndex"`
+	Exporter           string               `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// speedscopeUnits maps profile units to the units known to speedscope.
+var speedscopeUnits = map[string]string{
+	"nanoseconds":  "nanoseconds",
+	"microseconds": "microseconds",
+	"milliseconds": "milliseconds",
+	"seconds":      "seconds",
+	"bytes":        "bytes",
+}
+
+// printSpeedscope prints the samples of a profile for the selected sample
+// type as a sampled profile in speedscope format. The timestamps of the
+// samples are not exported, as they would need an evented profile.
+func printSpeedscope(w io.Writer, rpt *Report) error {
+	prof := rpt.prof
+	o := rpt.options
+
+	unit := speedscopeUnits[o.SampleUnit]
+	if unit == "" {
+		unit = "none"
+	}
+	sp := &speedscopeProfile{
+		Type: "sampled",
+		Name: o.SampleType,
+		Unit: unit,
+	}
+	file := &speedscopeFile{
+		Schema:   speedscopeSchema,
+		Profiles: []*speedscopeProfile{sp},
+		Exporter: "pprof",
+	}
+	if len(prof.Mapping) > 0 && prof.Mapping[0].File != "" {
+		file.Name = filepath.Base(prof.Mapping[0].File)
+	}
+
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	frames := make(map[graph.NodeInfo]int)
+	for _, sample := range prof.Sample {
+		v := o.SampleValue(sample.Value)
+		if o.SampleMeanDivisor != nil {
+			if d := o.SampleMeanDivisor(sample.Value); d != 0 {
+				v = v / d
+			}
+		}
+		if v == 0 {
+			continue
+		}
+
+		var stack []int
+		for _, loc := range sample.Location {
+			for _, n := range locations[loc.ID] {
+				id, ok := frames[n.Info]
+				if !ok {
+					id = len(file.Shared.Frames)
+					frames[n.Info] = id
+					name := n.Info.Name
+					if name == "" || n.Info.Address != 0 {
+						name = n.Info.PrintableName()
+					}
+					file.Shared.Frames = append(file.Shared.Frames, speedscopeFrame{
+						Name: name,
+						File: n.Info.File,
+						Line: n.Info.Lineno,
+					})
+				}
+				stack = append(stack, id)
+			}
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		// Speedscope stacks start at the root.
+		for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+			stack[i], stack[j] = stack[j], stack[i]
+		}
+		sp.Samples = append(sp.Samples, stack)
+		sp.Weights = append(sp.Weights, v)
+		sp.EndValue += v
+	}
+	if file.Shared.Frames == nil {
+		file.Shared.Frames = []speedscopeFrame{}
+	}
+	if sp.Samples == nil {
+		sp.Samples, sp.Weights = [][]int{}, []int64{}
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to export the samples of a profile, with
+// their locations, functions and labels, to a SQLite database. The schema
+// of the database is described in doc/README.md.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/internal/sqlite"
+	"github.com/google/pprof/profile"
+)
+
+// printSQLite writes the profile of the report as a SQLite database.
+func printSQLite(w io.Writer, rpt *Report) error {
+	db, err := sqliteDB(rpt.prof)
+	if err != nil {
+		return err
+	}
+	_, err = db.WriteTo(w)
+	return err
+}
+
+// sqliteDB returns the database holding p.
+func sqliteDB(p *profile.Profile) (*sqlite.DB, error) {
+	db := &sqlite.DB{}
+	var errs []error
+	insert := func(t *sqlite.Table, rowID int64, values ...interface{}) {
+		var err error
+		if rowID == 0 {
+			err = t.Insert(values...)
+		} else {
+			err = t.InsertRowID(rowID, values...)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	meta := db.CreateTable("profile", "CREATE TABLE profile(key TEXT, value TEXT)")
+	addMeta := func(key, value string) {
+		if value != "" && value != "0" {
+			insert(meta, 0, key, value)
+		}
+	}
+	addMeta("time_nanos", strconv.FormatInt(p.TimeNanos, 10))
+	addMeta("duration_nanos", strconv.FormatInt(p.DurationNanos, 10))
+	if pt := p.PeriodType; pt != nil {
+		addMeta("period_type", pt.Type)
+		addMeta("period_unit", pt.Unit)
+	}
+	addMeta("period", strconv.FormatInt(p.Period, 10))
+	addMeta("default_sample_type", p.DefaultSampleType)
+	addMeta("drop_frames", p.DropFrames)
+	addMeta("keep_frames", p.KeepFrames)
+	for _, c := range p.Comments {
+		addMeta("comment", c)
+	}
+
+	// The samples have a column per sample type, named after it.
+	types := db.CreateTable("sample_types", "CREATE TABLE sample_types(id INTEGER PRIMARY KEY, type TEXT, unit TEXT)")
+	columns := []string{"id INTEGER PRIMARY KEY"}
+	seen := map[string]bool{"id": true}
+	for i, st := range p.SampleType {
+		insert(types, 0, nil, st.Type, st.Unit)
+		name := st.Type
+		if seen[strings.ToLower(name)] {
+			name = fmt.Sprintf("%s_%d", name, i+1)
+		}
+		seen[strings.ToLower(name)] = true
+		columns = append(columns, sqliteIdentifier(name)+" INTEGER")
+	}
+	samples := db.CreateTable("samples", "CREATE TABLE samples("+strings.Join(columns, ", ")+")")
+	stacks := db.CreateTable("stacks", "CREATE TABLE stacks(sample_id INTEGER, depth INTEGER, location_id INTEGER)")
+	labels := db.CreateTable("labels", "CREATE TABLE labels(sample_id INTEGER, key TEXT, value TEXT)")
+	numLabels := db.CreateTable("num_labels", "CREATE TABLE num_labels(sample_id INTEGER, key TEXT, value INTEGER, unit TEXT)")
+	for i, s := range p.Sample {
+		id := int64(i + 1)
+		values := []interface{}{nil}
+		for _, v := range s.Value {
+			values = append(values, v)
+		}
+		insert(samples, 0, values...)
+		for depth, l := range s.Location {
+			insert(stacks, 0, id, int64(depth), int64(l.ID))
+		}
+		var keys []string
+		for k := range s.Label {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range s.Label[k] {
+				insert(labels, 0, id, k, v)
+			}
+		}
+		keys = keys[:0]
+		for k := range s.NumLabel {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			units := s.NumUnit[k]
+			for j, v := range s.NumLabel[k] {
+				var unit interface{}
+				if j < len(units) && units[j] != "" {
+					unit = units[j]
+				}
+				insert(numLabels, 0, id, k, v, unit)
+			}
+		}
+	}
+
+	// The rows of the tables keyed by ID are inserted by increasing ID.
+	locs := db.CreateTable("locations", "CREATE TABLE locations(id INTEGER PRIMARY KEY, mapping_id INTEGER, address INTEGER, is_folded INTEGER)")
+	lines := db.CreateTable("lines", "CREATE TABLE lines(location_id INTEGER, depth INTEGER, function_id INTEGER, line INTEGER)")
+	locations := append([]*profile.Location(nil), p.Location...)
+	sort.Slice(locations, func(i, j int) bool { return locations[i].ID < locations[j].ID })
+	for _, l := range locations {
+		var mapping interface{}
+		if l.Mapping != nil {
+			mapping = int64(l.Mapping.ID)
+		}
+		folded := int64(0)
+		if l.IsFolded {
+			folded = 1
+		}
+		insert(locs, int64(l.ID), nil, mapping, int64(l.Address), folded)
+		for depth, ln := range l.Line {
+			var function interface{}
+			if ln.Function != nil {
+				function = int64(ln.Function.ID)
+			}
+			insert(lines, 0, int64(l.ID), int64(depth), function, ln.Line)
+		}
+	}
+
+	funcs := db.CreateTable("functions", "CREATE TABLE functions(id INTEGER PRIMARY KEY, name TEXT, system_name TEXT, filename TEXT, start_line INTEGER)")
+	functions := append([]*profile.Function(nil), p.Function...)
+	sort.Slice(functions, func(i, j int) bool { return functions[i].ID < functions[j].ID })
+	for _, f := range functions {
+		insert(funcs, int64(f.ID), nil, f.Name, f.SystemName, f.Filename, f.StartLine)
+	}
+
+	maps := db.CreateTable("mappings", "CREATE TABLE mappings(id INTEGER PRIMARY KEY, memory_start INTEGER, memory_limit INTEGER, file_offset INTEGER, filename TEXT, build_id TEXT)")
+	mappings := append([]*profile.Mapping(nil), p.Mapping...)
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].ID < mappings[j].ID })
+	for _, m := range mappings {
+		insert(maps, int64(m.ID), nil, int64(m.Start), int64(m.Limit), int64(m.Offset), m.File, m.BuildID)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return db, nil
+}
+
+// sqliteIdentifier returns name quoted as an SQL identifier.
+func sqliteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// Timeline holds the value of the samples of a report over time, by
+// their timestamp label, summed in buckets of equal length.
+// Its times are encoded in JSON as strings, as they are not exact as
+// JavaScript numbers.
+type Timeline struct {
+	Start, End int64 `json:",string"` // Times of the earliest and latest sample, in nanoseconds.
+	Step       int64 `json:",string"` // Length of the buckets, in nanoseconds.
+	Values     []int64
+	Formats    []string // Formatted values of the buckets.
+}
+
+// HasTimestamps reports whether a sample of p has a timestamp label.
+func HasTimestamps(p *profile.Profile) bool {
+	for _, s := range p.Sample {
+		if len(s.NumLabel[TimestampLabel]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SampleTime returns the time of the sample s in nanoseconds, from its
+// timestamp label whose unit is unit, nanoseconds if empty, and whether it
+// has one.
+func SampleTime(s *profile.Sample, unit string) (int64, bool) {
+	t := s.NumLabel[TimestampLabel]
+	if len(t) == 0 {
+		return 0, false
+	}
+	if unit == "" {
+		unit = "nanoseconds"
+	}
+	ns, _ := measurement.Scale(t[0], unit, "nanoseconds")
+	return int64(ns), true
+}
+
+// SampleTimeline returns the timeline of the samples of the report in at
+// most n buckets, or nil if they have no timestamps.
+func SampleTimeline(rpt *Report, n int) *Timeline {
+	o := rpt.options
+	unit := o.NumLabelUnits[TimestampLabel]
+	var tl *Timeline
+	for _, s := range rpt.prof.Sample {
+		t, ok := SampleTime(s, unit)
+		if !ok {
+			continue
+		}
+		if tl == nil {
+			tl = &Timeline{Start: t, End: t}
+		}
+		if t < tl.Start {
+			tl.Start = t
+		}
+		if t > tl.End {
+			tl.End = t
+		}
+	}
+	if tl == nil {
+		return nil
+	}
+	// The last bucket holds the latest sample.
+	tl.Step = (tl.End-tl.Start)/int64(n) + 1
+	tl.Values = make([]int64, (tl.End-tl.Start)/tl.Step+1)
+	for _, s := range rpt.prof.Sample {
+		if t, ok := SampleTime(s, unit); ok {
+			tl.Values[(t-tl.Start)/tl.Step] += o.SampleValue(s.Value)
+		}
+	}
+	for _, v := range tl.Values {
+		tl.Formats = append(tl.Formats, rpt.formatValue(v))
+	}
+	return tl
+}
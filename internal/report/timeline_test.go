@@ -0,0 +1,58 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestSampleTimeline(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+	}
+	for _, s := range []struct{ v, ms int64 }{{1, 10}, {2, 12}, {4, 25}, {8, 40}} {
+		p.Sample = append(p.Sample, &profile.Sample{
+			Value:    []int64{s.v},
+			NumLabel: map[string][]int64{TimestampLabel: {s.ms}},
+		})
+	}
+	p.Sample = append(p.Sample, &profile.Sample{Value: []int64{16}})
+	if !HasTimestamps(p) {
+		t.Errorf("got no timestamps")
+	}
+
+	rpt := New(p, &Options{
+		SampleValue:   func(v []int64) int64 { return v[0] },
+		NumLabelUnits: map[string]string{TimestampLabel: "ms"},
+	})
+	got := SampleTimeline(rpt, 3)
+	want := &Timeline{
+		Start:   10e6,
+		End:     40e6,
+		Step:    10e6 + 1,
+		Values:  []int64{3, 4, 8},
+		Formats: []string{"3", "4", "8"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got timeline %+v, want %+v", got, want)
+	}
+
+	if tl := SampleTimeline(New(&profile.Profile{}, &Options{}), 3); tl != nil {
+		t.Errorf("got timeline %+v without timestamps", tl)
+	}
+}
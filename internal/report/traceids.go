@@ -0,0 +1,106 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file contains routines to report the cost of the distributed
+// traces recorded by the trace and span ID labels of samples.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/profile"
+)
+
+// The labels of samples identifying the distributed trace and span
+// during which they were taken.
+const (
+	TraceIDLabel = "trace_id"
+	SpanIDLabel  = "span_id"
+)
+
+// HasTraceIDs reports whether a sample of p has a trace ID label.
+func HasTraceIDs(p *profile.Profile) bool {
+	for _, s := range p.Sample {
+		if len(s.Label[TraceIDLabel]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// traceCost is the cost of a distributed trace.
+type traceCost struct {
+	id      string
+	value   int64
+	samples int
+	spans   map[string]bool
+}
+
+// printTraceIDs prints the cost of each trace ID of the samples, from
+// the most to the least costly, along with their number of samples and
+// spans. The samples without trace ID are reported last.
+func printTraceIDs(w io.Writer, rpt *Report) error {
+	o := rpt.options
+
+	traces := make(map[string]*traceCost)
+	untraced := &traceCost{id: "(no trace)"}
+	for _, s := range rpt.prof.Sample {
+		ids := s.Label[TraceIDLabel]
+		if len(ids) == 0 {
+			untraced.value += o.SampleValue(s.Value)
+			untraced.samples++
+			continue
+		}
+		for _, id := range ids {
+			t := traces[id]
+			if t == nil {
+				t = &traceCost{id: id, spans: make(map[string]bool)}
+				traces[id] = t
+			}
+			t.value += o.SampleValue(s.Value)
+			t.samples++
+			for _, span := range s.Label[SpanIDLabel] {
+				t.spans[span] = true
+			}
+		}
+	}
+	if len(traces) == 0 {
+		fmt.Fprintf(w, "No samples with a %s label found\n", TraceIDLabel)
+		return nil
+	}
+
+	sorted := make([]*traceCost, 0, len(traces))
+	for _, t := range traces {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if a, b := abs64(sorted[i].value), abs64(sorted[j].value); a != b {
+			return a > b
+		}
+		return sorted[i].id < sorted[j].id
+	})
+
+	fmt.Fprintf(w, "%10s %6s %8s %6s  %s\n", "flat", "flat%", "samples", "spans", TraceIDLabel)
+	for _, t := range sorted {
+		fmt.Fprintf(w, "%10s %6s %8d %6d  %s\n", rpt.formatValue(t.value), measurement.Percentage(t.value, rpt.total), t.samples, len(t.spans), t.id)
+	}
+	if untraced.samples > 0 {
+		fmt.Fprintf(w, "%10s %6s %8d %6s  %s\n", rpt.formatValue(untraced.value), measurement.Percentage(untraced.value, rpt.total), untraced.samples, "", untraced.id)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestPrintTraceIDs(t *testing.T) {
+	f := &profile.Function{ID: 1, Name: "main"}
+	l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+	sample := func(v int64, labels map[string][]string) *profile.Sample {
+		return &profile.Sample{Location: []*profile.Location{l}, Value: []int64{v}, Label: labels}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			sample(10, map[string][]string{"trace_id": {"a"}, "span_id": {"1"}}),
+			sample(20, map[string][]string{"trace_id": {"a"}, "span_id": {"2"}}),
+			sample(40, map[string][]string{"trace_id": {"b"}}),
+			sample(30, nil),
+		},
+		Location: []*profile.Location{l},
+		Function: []*profile.Function{f},
+	}
+	if !HasTraceIDs(p) {
+		t.Errorf("HasTraceIDs: got false, want true")
+	}
+	rpt := New(p, &Options{
+		OutputFormat: TraceIDs,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := strings.Join([]string{
+		"      flat  flat%  samples  spans  trace_id",
+		"        40 40.00%        1      0  b",
+		"        30 30.00%        2      2  a",
+		"        30 30.00%        1         (no trace)",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
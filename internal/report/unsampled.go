@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/pprof/internal/measurement"
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// unsampledSymbol is a function of the symbol table of a binary of the
+// profile that no sample goes through.
+type unsampledSymbol struct {
+	sym  *plugin.Sym
+	size uint64
+}
+
+// printUnsampled lists the functions matching the symbol regexp in the
+// binaries of the profile that appear in no stack of its samples, and
+// are at least as large as the minimum size, largest first. They are
+// candidates for removal or for loading lazily.
+func printUnsampled(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
+	o := rpt.options
+
+	// Gather the addresses of the locations of the samples per mapping.
+	addrs := make(map[*profile.Mapping][]uint64)
+	for _, s := range rpt.prof.Sample {
+		for _, l := range s.Location {
+			if l.Mapping != nil && l.Mapping.File != "" {
+				addrs[l.Mapping] = append(addrs[l.Mapping], l.Address)
+			}
+		}
+	}
+
+	type symKey struct {
+		file  string
+		start uint64
+	}
+	seen := make(map[symKey]bool)
+	var syms []unsampledSymbol
+	var count, total, unsampled uint64
+	for _, m := range rpt.prof.Mapping {
+		if len(addrs[m]) == 0 {
+			continue
+		}
+		f, err := obj.Open(m.File, m.Start, m.Limit, m.Offset)
+		if err != nil {
+			fmt.Fprintf(w, "%v\n", err)
+			continue
+		}
+		msyms, err := f.Symbols(o.Symbol, 0)
+		var sampled []uint64
+		for _, a := range addrs[m] {
+			if oa, err := f.ObjAddr(a); err == nil {
+				sampled = append(sampled, oa)
+			}
+		}
+		f.Close()
+		if err != nil {
+			continue
+		}
+		sort.Slice(sampled, func(i, j int) bool { return sampled[i] < sampled[j] })
+
+		for _, s := range msyms {
+			k := symKey{s.File, s.Start}
+			if s.End < s.Start || seen[k] {
+				continue
+			}
+			seen[k] = true
+			size := s.End - s.Start + 1
+			count++
+			total += size
+			i := sort.Search(len(sampled), func(i int) bool { return sampled[i] >= s.Start })
+			if i < len(sampled) && sampled[i] <= s.End {
+				continue
+			}
+			unsampled += size
+			if size >= o.MinSymbolSize {
+				syms = append(syms, unsampledSymbol{s, size})
+			}
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("no functions matching %v found in the binaries of the profile", o.Symbol)
+	}
+
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].size != syms[j].size {
+			return syms[i].size > syms[j].size
+		}
+		return syms[i].sym.Name[0] < syms[j].sym.Name[0]
+	})
+
+	var size uint64
+	for _, s := range syms {
+		size += s.size
+	}
+	fmt.Fprintf(w, "Unsampled: %d of %d bytes (%s) in the functions matching %v\n",
+		unsampled, total, measurement.Percentage(int64(unsampled), int64(total)), o.Symbol)
+	fmt.Fprintf(w, "Showing %d functions of at least %d bytes, %d bytes (%s)\n",
+		len(syms), o.MinSymbolSize, size, measurement.Percentage(int64(size), int64(total)))
+	fmt.Fprintf(w, "%10s %12s  %s\n", "size", "address", "function")
+	for _, s := range syms {
+		fmt.Fprintf(w, "%10d %12x  %s (%s)\n", s.size, s.sym.Start, s.sym.Name[0], filepath.Base(s.sym.File))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/google/pprof/internal/plugin"
+	"github.com/google/pprof/profile"
+)
+
+// symbolsObjTool is an object tool for binaries with the given symbols.
+type symbolsObjTool map[string][]*plugin.Sym
+
+func (obj symbolsObjTool) Open(file string, start, limit, offset uint64) (plugin.ObjFile, error) {
+	return symbolsObjFile{file, obj[file]}, nil
+}
+
+func (obj symbolsObjTool) Disasm(file string, start, end uint64, intelSyntax bool) ([]plugin.Inst, error) {
+	return nil, nil
+}
+
+type symbolsObjFile struct {
+	name string
+	syms []*plugin.Sym
+}
+
+func (f symbolsObjFile) Name() string                                   { return f.name }
+func (f symbolsObjFile) ObjAddr(addr uint64) (uint64, error)            { return addr, nil }
+func (f symbolsObjFile) BuildID() string                                { return "" }
+func (f symbolsObjFile) SourceLine(addr uint64) ([]plugin.Frame, error) { return nil, nil }
+func (f symbolsObjFile) Close() error                                   { return nil }
+
+func (f symbolsObjFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error) {
+	var syms []*plugin.Sym
+	for _, s := range f.syms {
+		if r == nil || r.MatchString(s.Name[0]) {
+			syms = append(syms, s)
+		}
+	}
+	return syms, nil
+}
+
+func TestUnsampled(t *testing.T) {
+	sym := func(name string, start, size uint64) *plugin.Sym {
+		return &plugin.Sym{Name: []string{name}, File: "/bin/app", Start: start, End: start + size - 1}
+	}
+	obj := symbolsObjTool{
+		"/bin/app": {
+			sym("main.main", 0x1000, 0x100),
+			sym("main.hot", 0x1100, 0x100),
+			sym("main.cold", 0x1200, 0x2000),
+			sym("main.tiny", 0x3200, 0x10),
+			sym("fmt.Printf", 0x4000, 0x1000),
+		},
+		"/lib/unused.so": {sym("unused", 0x10000, 0x8000)},
+	}
+	m := []*profile.Mapping{
+		{ID: 1, File: "/bin/app", Start: 0x1000, Limit: 0x8000},
+		{ID: 2, File: "/lib/unused.so", Start: 0x10000, Limit: 0x20000},
+	}
+	var l []*profile.Location
+	for _, a := range []uint64{0x1010, 0x11ff} {
+		l = append(l, &profile.Location{ID: uint64(len(l) + 1), Mapping: m[0], Address: a})
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l[1], l[0]}, Value: []int64{10}},
+		},
+		Location: l,
+		Mapping:  m,
+	}
+
+	for _, tc := range []struct {
+		symbol  string
+		minSize uint64
+		want    []string
+		notWant []string
+	}{
+		{
+			symbol:  ".",
+			minSize: 0x100,
+			want: []string{
+				`Unsampled: 12304 of 12816 bytes \(96.00%\) in the functions matching .\n`,
+				`Showing 2 functions of at least 256 bytes, 12288 bytes \(95.88%\)\n`,
+				`(?s)      8192         1200  main.cold \(app\)\n.*      4096         4000  fmt.Printf \(app\)\n`,
+			},
+			// Binaries without samples and sampled or small functions
+			// are not listed.
+			notWant: []string{"unused", "main.main", "main.hot", "main.tiny"},
+		},
+		{
+			symbol: `^main\.`,
+			want: []string{
+				`Unsampled: 8208 of 8720 bytes`,
+				`Showing 2 functions of at least 0 bytes`,
+				`main.tiny`,
+			},
+			notWant: []string{"fmt.Printf"},
+		},
+	} {
+		rpt := New(p, &Options{
+			OutputFormat:  Unsampled,
+			Symbol:        regexp.MustCompile(tc.symbol),
+			MinSymbolSize: tc.minSize,
+			SampleValue:   func(v []int64) int64 { return v[0] },
+		})
+		var buf bytes.Buffer
+		if err := Generate(&buf, rpt, obj); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		for _, want := range tc.want {
+			if !regexp.MustCompile(want).MatchString(buf.String()) {
+				t.Errorf("%s: report does not match %q:\n%s", tc.symbol, want, buf.String())
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if bytes.Contains(buf.Bytes(), []byte(notWant)) {
+				t.Errorf("%s: report lists %s:\n%s", tc.symbol, notWant, buf.String())
+			}
+		}
+	}
+
+	rpt := New(p, &Options{
+		OutputFormat: Unsampled,
+		Symbol:       regexp.MustCompile("nothing"),
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+	if err := Generate(&bytes.Buffer{}, rpt, obj); err == nil {
+		t.Error("Generate: got no error for a regexp matching no functions")
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqlite writes SQLite databases, in the file format described by
+// https://www.sqlite.org/fileformat.html, without depending on the SQLite
+// library. The databases hold tables, filled once with rows, which are
+// written in the b-trees of the tables; they have no indexes, which can be
+// created with the CREATE INDEX statement of SQLite.
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// pageSize is the size of the pages of the database, the largest
+	// supported by SQLite, so that rows are not split on overflow pages.
+	pageSize = 65536
+
+	// maxPayload is the largest payload of a row that fits in a leaf page
+	// of a table b-tree.
+	maxPayload = pageSize - 35
+
+	// headerSize is the size of the header of the database, at the start
+	// of its first page.
+	headerSize = 100
+
+	leafHeaderSize     = 8
+	interiorHeaderSize = 12
+	leafPageType       = 0x0d
+	interiorPageType   = 0x05
+)
+
+// DB is a SQLite database being written.
+type DB struct {
+	tables []*Table
+}
+
+// Table is a table of a database, with the rows inserted in it.
+type Table struct {
+	name, sql string
+	cells     [][]byte
+	rowIDs    []int64
+}
+
+// CreateTable adds a table to db, created by the sql statement, eg
+// "CREATE TABLE t(id INTEGER PRIMARY KEY, name TEXT)".
+func (db *DB) CreateTable(name, sql string) *Table {
+	t := &Table{name: name, sql: sql}
+	db.tables = append(db.tables, t)
+	return t
+}
+
+// Insert adds a row to t, with values of type nil, int64, float64, string
+// or []byte for its columns. The rows are numbered from 1 in the order they
+// are inserted; the value of an INTEGER PRIMARY KEY column, which must be
+// the row number, is given as nil and read as the row number by SQLite.
+func (t *Table) Insert(values ...interface{}) error {
+	return t.InsertRowID(t.lastRowID()+1, values...)
+}
+
+// lastRowID returns the row number of the last row inserted in t, or 0.
+func (t *Table) lastRowID() int64 {
+	if len(t.rowIDs) == 0 {
+		return 0
+	}
+	return t.rowIDs[len(t.rowIDs)-1]
+}
+
+// InsertRowID adds a row to t with the given row number, which must be
+// greater than those of the rows already inserted.
+func (t *Table) InsertRowID(rowID int64, values ...interface{}) error {
+	if last := t.lastRowID(); rowID <= last {
+		return fmt.Errorf("table %s: row %d inserted after row %d", t.name, rowID, last)
+	}
+	payload, err := record(values)
+	if err != nil {
+		return fmt.Errorf("table %s: %v", t.name, err)
+	}
+	if len(payload) > maxPayload {
+		return fmt.Errorf("table %s: row %d of %d bytes is too large", t.name, rowID, len(payload))
+	}
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowID))
+	t.cells = append(t.cells, append(cell, payload...))
+	t.rowIDs = append(t.rowIDs, rowID)
+	return nil
+}
+
+// WriteTo writes db to w.
+func (db *DB) WriteTo(w io.Writer) (int64, error) {
+	// The first page holds the schema table, whose rows give the root
+	// pages of the tables, which follow it.
+	pages := [][]byte{nil}
+	schema := &Table{name: "sqlite_master"}
+	for _, t := range db.tables {
+		root, err := writeTree(&pages, t.cells, t.rowIDs)
+		if err != nil {
+			return 0, fmt.Errorf("table %s: %v", t.name, err)
+		}
+		if err := schema.Insert("table", t.name, t.name, int64(root), t.sql); err != nil {
+			return 0, err
+		}
+	}
+	first, ok := leafPage(schema.cells, headerSize)
+	if !ok {
+		return 0, fmt.Errorf("schema of %d tables does not fit in a page", len(db.tables))
+	}
+	pages[0] = first
+	writeHeader(first, len(pages))
+
+	var n int64
+	for _, p := range pages {
+		m, err := w.Write(p)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeTree appends to pages the pages of a table b-tree holding cells,
+// with the given row numbers, and returns the number of its root page.
+func writeTree(pages *[][]byte, cells [][]byte, rowIDs []int64) (int, error) {
+	type child struct {
+		page     int
+		maxRowID int64
+	}
+	var children []child
+	for start := 0; start < len(cells) || len(children) == 0; {
+		end := start
+		size := leafHeaderSize
+		for end < len(cells) && size+len(cells[end])+2 <= pageSize {
+			size += len(cells[end]) + 2
+			end++
+		}
+		if end == start && start < len(cells) {
+			return 0, fmt.Errorf("row of %d bytes does not fit in a page", len(cells[start]))
+		}
+		p, _ := leafPage(cells[start:end], 0)
+		*pages = append(*pages, p)
+		c := child{page: len(*pages)}
+		if end > start {
+			c.maxRowID = rowIDs[end-1]
+		}
+		children = append(children, c)
+		start = end
+	}
+
+	// Each level of interior pages points to the pages of the level below,
+	// up to a single root page.
+	for len(children) > 1 {
+		var parents []child
+		for start := 0; start < len(children); {
+			end := start
+			size := interiorHeaderSize
+			// The last child of a page is its right-most pointer, without
+			// a cell.
+			var cells [][]byte
+			for end < len(children)-1 {
+				c := children[end]
+				cell := make([]byte, 4, 13)
+				binary.BigEndian.PutUint32(cell, uint32(c.page))
+				cell = appendVarint(cell, uint64(c.maxRowID))
+				if size+len(cell)+2 > pageSize {
+					break
+				}
+				size += len(cell) + 2
+				cells = append(cells, cell)
+				end++
+			}
+			right := children[end]
+			*pages = append(*pages, interiorPage(cells, right.page))
+			parents = append(parents, child{page: len(*pages), maxRowID: right.maxRowID})
+			start = end + 1
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// leafPage returns a leaf page of a table b-tree holding cells, with its
+// b-tree header at offset, or false if they do not fit in it.
+func leafPage(cells [][]byte, offset int) ([]byte, bool) {
+	p := make([]byte, pageSize)
+	content := pageSize
+	ptr := offset + leafHeaderSize
+	for _, c := range cells {
+		content -= len(c)
+		if content < ptr+2 {
+			return nil, false
+		}
+		copy(p[content:], c)
+		binary.BigEndian.PutUint16(p[ptr:], uint16(content))
+		ptr += 2
+	}
+	h := p[offset:]
+	h[0] = leafPageType
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	// A cell content area starting at 65536 is written as 0.
+	binary.BigEndian.PutUint16(h[5:], uint16(content))
+	return p, true
+}
+
+// interiorPage returns an interior page of a table b-tree holding cells,
+// with the page number of its right-most child.
+func interiorPage(cells [][]byte, right int) []byte {
+	p := make([]byte, pageSize)
+	content := pageSize
+	ptr := interiorHeaderSize
+	for _, c := range cells {
+		content -= len(c)
+		copy(p[content:], c)
+		binary.BigEndian.PutUint16(p[ptr:], uint16(content))
+		ptr += 2
+	}
+	p[0] = interiorPageType
+	binary.BigEndian.PutUint16(p[3:], uint16(len(cells)))
+	binary.BigEndian.PutUint16(p[5:], uint16(content))
+	binary.BigEndian.PutUint32(p[8:], uint32(right))
+	return p
+}
+
+// writeHeader writes the database header at the start of the first page
+// of a database of n pages.
+func writeHeader(p []byte, n int) {
+	copy(p, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(p[16:], 1) // Page size of 65536.
+	p[18], p[19] = 1, 1                   // Legacy file format versions.
+	p[21], p[22], p[23] = 64, 32, 32      // Payload fractions.
+	binary.BigEndian.PutUint32(p[24:], 1) // File change counter.
+	binary.BigEndian.PutUint32(p[28:], uint32(n))
+	binary.BigEndian.PutUint32(p[40:], 1) // Schema cookie.
+	binary.BigEndian.PutUint32(p[44:], 4) // Schema format.
+	binary.BigEndian.PutUint32(p[56:], 1) // UTF-8 text encoding.
+	binary.BigEndian.PutUint32(p[92:], 1) // Version valid for change 1.
+	binary.BigEndian.PutUint32(p[96:], 3031001)
+}
+
+// record returns the encoding of a row with values in the record format.
+func record(values []interface{}) ([]byte, error) {
+	var header, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			header = appendVarint(header, 0)
+		case int64:
+			switch {
+			case v == 0:
+				header = appendVarint(header, 8)
+			case v == 1:
+				header = appendVarint(header, 9)
+			default:
+				t, n := intSerialType(v)
+				header = appendVarint(header, t)
+				for i := n - 1; i >= 0; i-- {
+					body = append(body, byte(v>>(8*uint(i))))
+				}
+			}
+		case float64:
+			header = appendVarint(header, 7)
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+			body = append(body, b[:]...)
+		case string:
+			header = appendVarint(header, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			header = appendVarint(header, uint64(2*len(v)+12))
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+		}
+	}
+	// The size of the header includes the varint of its size, which takes
+	// a single byte for up to 126 columns.
+	size := len(header) + 1
+	if size > 127 {
+		size++
+	}
+	rec := appendVarint(nil, uint64(size))
+	rec = append(rec, header...)
+	return append(rec, body...), nil
+}
+
+// intSerialType returns the serial type of an integer and the number of
+// bytes of its encoding.
+func intSerialType(v int64) (uint64, int) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	}
+	return 6, 8
+}
+
+// appendVarint appends the SQLite varint encoding of v to b, which is
+// big-endian, with 7 bits per byte and 8 bits in the ninth byte.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	db := &DB{}
+	small := db.CreateTable("small", "CREATE TABLE small(id INTEGER PRIMARY KEY, name TEXT, value INTEGER, ratio REAL, data BLOB)")
+	want := [][]interface{}{
+		{nil, "a", int64(0), 0.5, []byte{1, 2}},
+		{nil, "", int64(1), -1.25, nil},
+		{nil, strings.Repeat("b", 200), int64(-300), math.Inf(1), []byte{}},
+		{nil, "c", int64(math.MinInt64), 0.0, nil},
+		{nil, "d", int64(1 << 40), 1e300, nil},
+	}
+	for _, row := range want {
+		if err := small.Insert(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enough rows for a table b-tree with interior pages.
+	large := db.CreateTable("large", "CREATE TABLE large(value INTEGER, name TEXT)")
+	const rows = 20000
+	for i := 0; i < rows; i++ {
+		if err := large.InsertRowID(int64(10*i+7), int64(i)*int64(i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &reader{t: t, data: buf.Bytes()}
+	if got := string(r.data[:16]); got != "SQLite format 3\x00" {
+		t.Fatalf("got header %q", got)
+	}
+	if got, want := int(binary.BigEndian.Uint32(r.data[28:])), len(r.data)/pageSize; got != want {
+		t.Errorf("got %d pages in header, want %d", got, want)
+	}
+
+	schema := r.table(1)
+	if len(schema) != 2 {
+		t.Fatalf("got %d tables, want 2", len(schema))
+	}
+	roots := make(map[string]int)
+	for _, row := range schema {
+		roots[row.values[1].(string)] = int(row.values[3].(int64))
+	}
+
+	var got [][]interface{}
+	for i, row := range r.table(roots["small"]) {
+		if row.rowID != int64(i+1) {
+			t.Errorf("got row %d, want %d", row.rowID, i+1)
+		}
+		got = append(got, row.values)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+
+	rowsGot := r.table(roots["large"])
+	if len(rowsGot) != rows {
+		t.Fatalf("got %d rows, want %d", len(rowsGot), rows)
+	}
+	if r.depth < 2 {
+		t.Errorf("got b-tree of depth %d, want interior pages", r.depth)
+	}
+	for i, row := range rowsGot {
+		want := []interface{}{int64(i) * int64(i), fmt.Sprint(i)}
+		if row.rowID != int64(10*i+7) || !reflect.DeepEqual(row.values, want) {
+			t.Fatalf("got row %d %v, want %d %v", row.rowID, row.values, 10*i+7, want)
+		}
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	db := &DB{}
+	tbl := db.CreateTable("t", "CREATE TABLE t(a)")
+	if err := tbl.InsertRowID(5, int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.InsertRowID(5, int64(2)); err == nil {
+		t.Error("want error inserting a row with a row number already used")
+	}
+	if err := tbl.Insert(1); err == nil {
+		t.Error("want error inserting a value of type int")
+	}
+	if err := tbl.Insert(strings.Repeat("x", pageSize)); err == nil {
+		t.Error("want error inserting a row larger than a page")
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 16383, 16384, 1<<56 - 1, 1 << 56, math.MaxUint64} {
+		b := appendVarint(nil, v)
+		got, n := varint(b)
+		if got != v || n != len(b) {
+			t.Errorf("varint %x: decoded %x from %d of %d bytes", v, got, n, len(b))
+		}
+	}
+}
+
+// reader reads the tables of a database written by WriteTo.
+type reader struct {
+	t     *testing.T
+	data  []byte
+	depth int
+}
+
+type row struct {
+	rowID  int64
+	values []interface{}
+}
+
+// table returns the rows of the table b-tree rooted at the given page.
+func (r *reader) table(root int) []row {
+	r.depth = 0
+	return r.tree(root, 1)
+}
+
+func (r *reader) tree(page, depth int) []row {
+	if depth > r.depth {
+		r.depth = depth
+	}
+	p := r.data[(page-1)*pageSize : page*pageSize]
+	h := p
+	if page == 1 {
+		h = p[headerSize:]
+	}
+	count := int(binary.BigEndian.Uint16(h[3:]))
+	var rows []row
+	switch h[0] {
+	case leafPageType:
+		for i := 0; i < count; i++ {
+			c := p[binary.BigEndian.Uint16(h[leafHeaderSize+2*i:]):]
+			size, n := varint(c)
+			rowID, m := varint(c[n:])
+			rows = append(rows, row{int64(rowID), r.record(c[n+m : n+m+int(size)])})
+		}
+	case interiorPageType:
+		for i := 0; i < count; i++ {
+			c := p[binary.BigEndian.Uint16(h[interiorHeaderSize+2*i:]):]
+			rows = append(rows, r.tree(int(binary.BigEndian.Uint32(c)), depth+1)...)
+		}
+		rows = append(rows, r.tree(int(binary.BigEndian.Uint32(h[8:])), depth+1)...)
+	default:
+		r.t.Fatalf("page %d: unexpected page type %d", page, h[0])
+	}
+	return rows
+}
+
+func (r *reader) record(b []byte) []interface{} {
+	size, n := varint(b)
+	header, body := b[n:size], b[size:]
+	var values []interface{}
+	for len(header) > 0 {
+		st, n := varint(header)
+		header = header[n:]
+		switch {
+		case st == 0:
+			values = append(values, nil)
+		case st >= 1 && st <= 6:
+			size := []int{1, 2, 3, 4, 6, 8}[st-1]
+			v := int64(int8(body[0]))
+			for _, c := range body[1:size] {
+				v = v<<8 | int64(c)
+			}
+			values = append(values, v)
+			body = body[size:]
+		case st == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case st == 8 || st == 9:
+			values = append(values, int64(st-8))
+		case st >= 12 && st%2 == 0:
+			size := int(st-12) / 2
+			values = append(values, append([]byte{}, body[:size]...))
+			body = body[size:]
+		case st >= 13:
+			size := int(st-13) / 2
+			values = append(values, string(body[:size]))
+			body = body[size:]
+		default:
+			r.t.Fatalf("unexpected serial type %d", st)
+		}
+	}
+	return values
+}
+
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
@@ -18,12 +18,15 @@
 package symbolizer
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/pprof/internal/binutils"
 	"github.com/google/pprof/internal/plugin"
@@ -37,8 +40,32 @@ type Symbolizer struct {
 	Obj       plugin.ObjTool
 	UI        plugin.UI
 	Transport http.RoundTripper
+
+	// Interrupt, if not nil, interrupts the symbolization once closed,
+	// as the timeout option does once expired.
+	Interrupt <-chan struct{}
 }
 
+// ErrInterrupted is the error of a symbolization interrupted before it
+// completed, which leaves the profile partially symbolized, with the
+// comment starting with PartialComment. Symbolizing it again with the
+// resume option continues from there.
+var ErrInterrupted = errors.New("symbolization interrupted")
+
+// PartialComment starts the comment of a partially symbolized profile,
+// followed by the start addresses of its mappings whose symbolization
+// completed, eg "pprof::partial-symbolization complete=0x400000,0x7f0000".
+const PartialComment = "pprof::partial-symbolization complete="
+
+// RelocationComment starts the comments recording how the addresses of
+// each mapping symbolized locally were translated to those of its
+// binary: the base subtracted from them, the rule it was computed by and
+// the load segment it was computed from, eg "# relocation start=0x400000
+// base=0x0 mode=user segment=off=0x1000,vaddr=0x401000,align=0x1000
+// file=/usr/bin/server". Starting with '#', they are kept out of the
+// headers of reports, as the -comments report shows them.
+const RelocationComment = "# relocation "
+
 // test taps for dependency injection
 var symbolzSymbolize = symbolz.Symbolize
 var localSymbolize = doLocalSymbolize
@@ -49,7 +76,17 @@ var demangleFunction = Demangle
 // missed entries using symbolz.
 func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *profile.Profile) error {
 	remote, local, fast, force, demanglerMode := true, true, false, false, ""
+	var resume bool
+	var timeout time.Duration
 	for _, o := range strings.Split(strings.ToLower(mode), ":") {
+		if t := strings.TrimPrefix(o, "timeout="); t != o {
+			d, err := time.ParseDuration(t)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid symbolization timeout %q, want a positive duration such as 30s", t)
+			}
+			timeout = d
+			continue
+		}
 		switch o {
 		case "":
 			continue
@@ -63,6 +100,8 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 			remote, local = true, false
 		case "force":
 			force = true
+		case "resume":
+			resume = true
 		default:
 			switch d := strings.TrimPrefix(o, "demangle="); d {
 			case "full", "none", "templates":
@@ -73,14 +112,43 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 				continue
 			}
 			s.UI.PrintErr("ignoring unrecognized symbolization option: " + mode)
-			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|remote|none][:force][:demangle=[none|full|templates|default]")
+			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|remote|none][:force][:demangle=[none|full|templates|default]][:timeout=duration]")
+		}
+	}
+
+	if resume {
+		if !resumeSymbolization(p) {
+			s.UI.PrintErr("The profile is not partially symbolized, symbolizing it as usual")
+		}
+	} else if partialMappings(p) != nil {
+		s.UI.PrintErr("The symbolization of the profile was interrupted, use -resume to complete it")
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	interrupted := func() bool {
+		select {
+		case <-s.Interrupt:
+			return true
+		case <-deadline:
+			return true
+		default:
+			return false
 		}
 	}
 
 	var err error
 	if local {
 		// Symbolize locally using binutils.
-		if err = localSymbolize(p, fast, force, s.Obj, s.UI); err != nil {
+		if err = localSymbolize(p, fast, force, interrupted, s.Obj, s.UI); err == ErrInterrupted {
+			// The remote symbolization would not be resumed either.
+			demangleFunction(p, force, demanglerMode)
+			return err
+		}
+		if err != nil {
 			s.UI.PrintErr("local symbolization: " + err.Error())
 		}
 	}
@@ -125,8 +193,10 @@ func statusCodeError(resp *http.Response) error {
 
 // doLocalSymbolize adds symbol and line number information to all locations
 // in a profile. mode enables some options to control
-// symbolization.
-func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+// symbolization. It symbolizes the locations of a mapping after those of
+// the previous one, and returns ErrInterrupted, marking the mappings
+// completed as such, once interrupted returns true.
+func doLocalSymbolize(prof *profile.Profile, fast, force bool, interrupted func() bool, obj plugin.ObjTool, ui plugin.UI) error {
 	if fast {
 		if bu, ok := obj.(*binutils.Binutils); ok {
 			bu.SetFastSymbolization(true)
@@ -139,59 +209,189 @@ func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjToo
 	}
 	defer mt.close()
 
-	functions := make(map[profile.Function]*profile.Function)
+	locations := make(map[*profile.Mapping][]*profile.Location)
 	for _, l := range mt.prof.Location {
-		m := l.Mapping
+		if mt.segments[l.Mapping] != nil {
+			locations[l.Mapping] = append(locations[l.Mapping], l)
+		}
+	}
+	functions := make(map[profile.Function]*profile.Function)
+	for i, m := range mt.prof.Mapping {
+		for _, l := range locations[m] {
+			if interrupted != nil && interrupted() {
+				pending := make(map[*profile.Mapping]bool)
+				for _, m := range mt.prof.Mapping[i:] {
+					pending[m] = mt.segments[m] != nil
+				}
+				markPartial(mt.prof, pending)
+				mt.recordRelocations()
+				return ErrInterrupted
+			}
+			mt.symbolizeLocation(l, functions)
+		}
+	}
+
+	mt.recordRelocations()
+	return nil
+}
+
+// recordRelocations records in the comments of the profile the relocation
+// of the mappings symbolized, replacing any previous record of them.
+func (mt *mappingTable) recordRelocations() {
+	var records []string
+	recorded := make(map[uint64]bool)
+	for _, m := range mt.prof.Mapping {
 		segment := mt.segments[m]
 		if segment == nil {
-			// Nothing to do.
 			continue
 		}
-
-		stack, err := segment.SourceLine(l.Address)
-		if err != nil || len(stack) == 0 {
-			// No answers from addr2line.
+		r, ok := binutils.FileRelocation(segment)
+		if !ok {
 			continue
 		}
+		load := "none"
+		if ph := r.Segment; ph != nil {
+			load = fmt.Sprintf("off=%#x,vaddr=%#x,align=%#x", ph.Off, ph.Vaddr, ph.Align)
+		}
+		records = append(records, fmt.Sprintf("%sstart=%#x base=%#x mode=%s segment=%s file=%s", RelocationComment, m.Start, r.Base, r.Mode, load, m.File))
+		recorded[m.Start] = true
+	}
+	if len(records) == 0 {
+		return
+	}
+	comments := mt.prof.Comments[:0]
+	for _, c := range mt.prof.Comments {
+		if start, ok := relocationStart(c); !ok || !recorded[start] {
+			comments = append(comments, c)
+		}
+	}
+	mt.prof.Comments = append(comments, records...)
+}
 
-		l.Line = make([]profile.Line, len(stack))
-		l.IsFolded = false
-		for i, frame := range stack {
-			if frame.Func != "" {
-				m.HasFunctions = true
-			}
-			if frame.File != "" {
-				m.HasFilenames = true
-			}
-			if frame.Line != 0 {
-				m.HasLineNumbers = true
-			}
-			f := &profile.Function{
-				Name:       frame.Func,
-				SystemName: frame.Func,
-				Filename:   frame.File,
-			}
-			if fp := functions[*f]; fp != nil {
-				f = fp
-			} else {
-				functions[*f] = f
-				f.ID = uint64(len(mt.prof.Function)) + 1
-				mt.prof.Function = append(mt.prof.Function, f)
-			}
-			l.Line[i] = profile.Line{
-				Function: f,
-				Line:     int64(frame.Line),
-			}
+// relocationStart returns the start address of the mapping of the
+// relocation comment c, and whether c is one.
+func relocationStart(c string) (uint64, bool) {
+	if !strings.HasPrefix(c, RelocationComment+"start=") {
+		return 0, false
+	}
+	a := strings.TrimPrefix(c, RelocationComment+"start=")
+	if i := strings.IndexByte(a, ' '); i >= 0 {
+		a = a[:i]
+	}
+	start, err := strconv.ParseUint(a, 0, 64)
+	return start, err == nil
+}
+
+// symbolizeLocation sets the lines of the location l from its segment,
+// adding their functions to those of the profile.
+func (mt *mappingTable) symbolizeLocation(l *profile.Location, functions map[profile.Function]*profile.Function) {
+	m := l.Mapping
+	segment := mt.segments[m]
+
+	stack, err := segment.SourceLine(l.Address)
+	if err != nil || len(stack) == 0 {
+		// No answers from addr2line.
+		return
+	}
+
+	l.Line = make([]profile.Line, len(stack))
+	l.IsFolded = false
+	for i, frame := range stack {
+		if frame.Func != "" {
+			m.HasFunctions = true
+		}
+		if frame.File != "" {
+			m.HasFilenames = true
+		}
+		if frame.Line != 0 {
+			m.HasLineNumbers = true
+		}
+		f := &profile.Function{
+			Name:       frame.Func,
+			SystemName: frame.Func,
+			Filename:   frame.File,
+		}
+		if fp := functions[*f]; fp != nil {
+			f = fp
+		} else {
+			functions[*f] = f
+			f.ID = uint64(len(mt.prof.Function)) + 1
+			mt.prof.Function = append(mt.prof.Function, f)
 		}
+		l.Line[i] = profile.Line{
+			Function: f,
+			Line:     int64(frame.Line),
+		}
+	}
+
+	if len(stack) > 0 {
+		m.HasInlineFrames = true
+	}
+}
 
-		if len(stack) > 0 {
-			m.HasInlineFrames = true
+// markPartial records in the comments of p that the symbolization of the
+// mappings pending did not complete, replacing any previous record.
+func markPartial(p *profile.Profile, pending map[*profile.Mapping]bool) {
+	used := make(map[*profile.Mapping]bool)
+	for _, l := range p.Location {
+		used[l.Mapping] = true
+	}
+	var complete []string
+	for _, m := range p.Mapping {
+		if used[m] && !pending[m] {
+			complete = append(complete, fmt.Sprintf("%#x", m.Start))
 		}
 	}
+	removePartialComment(p)
+	p.Comments = append(p.Comments, PartialComment+strings.Join(complete, ","))
+}
 
+// partialMappings returns the start addresses of the mappings of p whose
+// symbolization completed, if p is partially symbolized, or else nil.
+func partialMappings(p *profile.Profile) map[uint64]bool {
+	for _, c := range p.Comments {
+		if !strings.HasPrefix(c, PartialComment) {
+			continue
+		}
+		complete := make(map[uint64]bool)
+		for _, a := range strings.Split(strings.TrimPrefix(c, PartialComment), ",") {
+			if start, err := strconv.ParseUint(a, 0, 64); err == nil {
+				complete[start] = true
+			}
+		}
+		return complete
+	}
 	return nil
 }
 
+// resumeSymbolization prepares the partially symbolized profile p for
+// its symbolization to be completed: the mappings not marked completed
+// are no longer marked symbolized, to be symbolized again. It reports
+// whether p was partially symbolized.
+func resumeSymbolization(p *profile.Profile) bool {
+	complete := partialMappings(p)
+	if complete == nil {
+		return false
+	}
+	for _, m := range p.Mapping {
+		if !complete[m.Start] {
+			m.HasFunctions, m.HasFilenames, m.HasLineNumbers, m.HasInlineFrames = false, false, false, false
+		}
+	}
+	removePartialComment(p)
+	return true
+}
+
+func removePartialComment(p *profile.Profile) {
+	comments := p.Comments[:0]
+	for _, c := range p.Comments {
+		if !strings.HasPrefix(c, PartialComment) {
+			comments = append(comments, c)
+		}
+	}
+	p.Comments = comments
+}
+
 // Demangle updates the function names in a profile with demangled C++
 // names, simplified according to demanglerMode. If force is set,
 // overwrite any names that appear already demangled.
@@ -16,11 +16,14 @@ package symbolizer
 
 import (
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
 	"testing"
 
+	"github.com/google/pprof/internal/binutils"
 	"github.com/google/pprof/internal/plugin"
 	"github.com/google/pprof/internal/proftest"
 	"github.com/google/pprof/profile"
@@ -173,7 +176,7 @@ func symbolzMock(p *profile.Profile, force bool, sources plugin.MappingSources,
 	return nil
 }
 
-func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+func localMock(p *profile.Profile, fast, force bool, interrupted func() bool, obj plugin.ObjTool, ui plugin.UI) error {
 	var args []string
 	if fast {
 		args = append(args, "fast")
@@ -205,7 +208,7 @@ func TestLocalSymbolization(t *testing.T) {
 	}
 
 	b := mockObjTool{}
-	if err := localSymbolize(prof, false, false, b, &proftest.TestUI{T: t}); err != nil {
+	if err := localSymbolize(prof, false, false, nil, b, &proftest.TestUI{T: t}); err != nil {
 		t.Fatalf("localSymbolize(): %v", err)
 	}
 
@@ -222,6 +225,59 @@ func TestLocalSymbolization(t *testing.T) {
 	}
 }
 
+func TestResumeSymbolization(t *testing.T) {
+	prof := testProfile.Copy()
+	other := &profile.Mapping{ID: 2, Start: 0x6000, Limit: 0x9000, File: "other"}
+	prof.Mapping = append(prof.Mapping, other)
+	prof.Location[3].Mapping, prof.Location[4].Mapping = other, other
+
+	// Interrupted at the last location, of the second mapping.
+	var calls int
+	interrupted := func() bool {
+		calls++
+		return calls > 4
+	}
+	ui := &proftest.TestUI{T: t, AllowRx: "use -resume"}
+	if err := localSymbolize(prof, false, false, interrupted, mockObjTool{}, ui); err != ErrInterrupted {
+		t.Fatalf("interrupted localSymbolize(): got error %v, want %v", err, ErrInterrupted)
+	}
+	if got, want := strings.Join(prof.Comments, "\n"), PartialComment+"0x1000"; got != want {
+		t.Errorf("got comments %q, want %q", got, want)
+	}
+	if len(prof.Location[3].Line) == 0 || len(prof.Location[4].Line) != 0 {
+		t.Fatalf("got lines %v and %v, want the last location left unsymbolized", prof.Location[3].Line, prof.Location[4].Line)
+	}
+
+	// The partially symbolized mapping is not symbolized again, but for
+	// resuming.
+	s := Symbolizer{Obj: mockObjTool{}, UI: ui}
+	if err := s.Symbolize("local", nil, prof); err != nil || len(prof.Location[4].Line) != 0 || ui.NumAllowRxMatches != 1 {
+		t.Errorf("symbolizing again: got error %v, lines %v and %d warnings, want the warning only", err, prof.Location[4].Line, ui.NumAllowRxMatches)
+	}
+	if err := s.Symbolize("local:resume", nil, prof); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	for _, loc := range prof.Location {
+		if err := checkSymbolizedLocation(loc.Address, loc.Line); err != nil {
+			t.Errorf("location %d: %v", loc.Address, err)
+		}
+	}
+	if len(prof.Comments) != 0 {
+		t.Errorf("got comments %q after resuming, want none", prof.Comments)
+	}
+
+	interrupt := make(chan struct{})
+	close(interrupt)
+	s.Interrupt = interrupt
+	prof = testProfile.Copy()
+	if err := s.Symbolize("local", nil, prof); err != ErrInterrupted || strings.Join(prof.Comments, "\n") != PartialComment {
+		t.Errorf("symbolizing once interrupted: got error %v and comments %q, want %v with no mapping completed", err, prof.Comments, ErrInterrupted)
+	}
+	if err := s.Symbolize("local:timeout=soon", nil, testProfile.Copy()); err == nil {
+		t.Error("symbolizing with an invalid timeout: got no error")
+	}
+}
+
 func checkSymbolizedLocation(a uint64, got []profile.Line) error {
 	want, ok := mockAddresses[a]
 	if !ok {
@@ -298,3 +354,22 @@ func (mockObjFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error)
 func (mockObjFile) Close() error {
 	return nil
 }
+
+func TestRelocationComments(t *testing.T) {
+	m := &profile.Mapping{ID: 1, Start: 0x5400000, Limit: 0x5401000, File: filepath.Join("..", "binutils", "testdata", "exe_linux_64")}
+	prof := &profile.Profile{
+		Mapping:  []*profile.Mapping{m},
+		Location: []*profile.Location{{ID: 1, Address: 0x5400400, Mapping: m}},
+		Comments: []string{"dummy", RelocationComment + "start=0x5400000 base=0x0 mode=none segment=none file=stale"},
+	}
+	// The base is computed, and recorded, whether the binutils are
+	// installed or not.
+	ui := &proftest.TestUI{T: t, AllowRx: ".*"}
+	if err := localSymbolize(prof, true, true, nil, &binutils.Binutils{}, ui); err != nil {
+		t.Fatalf("localSymbolize(): %v", err)
+	}
+	want := []string{"dummy", RelocationComment + "start=0x5400000 base=0x5000000 mode=user segment=off=0x0,vaddr=0x400000,align=0x200000 file=" + m.File}
+	if !reflect.DeepEqual(prof.Comments, want) {
+		t.Errorf("got comments %q, want %q", prof.Comments, want)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pprofweb serves the interactive pprof web UI from within a Go
+// program, so a service can expose the UI for its own profiles next to
+// the raw net/http/pprof endpoints.
+//
+// A typical use is:
+//
+//	http.Handle("/debug/pprof/ui/", http.StripPrefix("/debug/pprof/ui",
+//		pprofweb.Handler(pprofweb.RuntimeProfile("heap"))))
+//
+// The UI uses relative links, so the handler must be mounted on a path
+// ending in a slash with that prefix stripped.
+//
+// Programs built with the pprof_lite tag have no web UI: the handler then
+// serves the errors of the views.
+package pprofweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+)
+
+// FetchFunc returns the profile to display for the UI. It is called
+// with the request that triggered the load, detached from its context, as
+// the profile is shared by all the requests.
+type FetchFunc func(req *http.Request) (*profile.Profile, error)
+
+// Handler returns an http.Handler serving the pprof web UI for the
+// profile returned by fetch. The profile is fetched on the first
+// request and whenever a request carries the "refresh" query parameter,
+// which is then redirected to its URL without it.
+func Handler(fetch FetchFunc) http.Handler {
+	return &handler{fetch: fetch}
+}
+
+// RuntimeProfile returns a FetchFunc that collects the named profile
+// from the running program using runtime/pprof. The name "profile"
+// selects a CPU profile, whose duration is taken from the "seconds"
+// query parameter (30 seconds by default).
+func RuntimeProfile(name string) FetchFunc {
+	return func(req *http.Request) (*profile.Profile, error) {
+		var buf bytes.Buffer
+		if name == "profile" {
+			seconds := 30
+			if s := req.URL.Query().Get("seconds"); s != "" {
+				v, err := strconv.Atoi(s)
+				if err != nil || v <= 0 {
+					return nil, fmt.Errorf("invalid seconds %q", s)
+				}
+				seconds = v
+			}
+			if err := pprof.StartCPUProfile(&buf); err != nil {
+				return nil, err
+			}
+			select {
+			case <-time.After(time.Duration(seconds) * time.Second):
+			case <-req.Context().Done():
+			}
+			pprof.StopCPUProfile()
+		} else {
+			p := pprof.Lookup(name)
+			if p == nil {
+				return nil, fmt.Errorf("unknown profile %q", name)
+			}
+			if err := p.WriteTo(&buf, 0); err != nil {
+				return nil, err
+			}
+		}
+		return profile.Parse(&buf)
+	}
+}
+
+type handler struct {
+	fetch FetchFunc
+
+	mu       sync.Mutex
+	handlers map[string]http.Handler
+	loading  *loadCall // The load in progress, if any.
+}
+
+// loadCall is a load of the UI handlers in progress, shared by the
+// requests waiting for it.
+type loadCall struct {
+	done     chan struct{} // Closed once the load completed.
+	handlers map[string]http.Handler
+	err      error
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handlers, err := h.load(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The UI copies the parameters of the page into its links, which
+	// would fetch the profile again on every view.
+	if q := req.URL.Query(); q.Get("refresh") != "" && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		q.Del("refresh")
+		// The location is relative to that of the request, as the
+		// handler may be mounted with a prefix stripped.
+		w.Header().Set("Location", "?"+q.Encode())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	hh := handlers[path]
+	if hh == nil {
+		http.NotFound(w, req)
+		return
+	}
+	hh.ServeHTTP(w, req)
+}
+
+// load returns the UI handlers, fetching the profile and building them
+// if needed. The profile is fetched without holding the lock, so that the
+// other requests keep being served the previous handlers meanwhile, and
+// the requests needing a load while one is in progress share it. The load
+// is not canceled with the request that started it, which the others
+// wait for.
+func (h *handler) load(req *http.Request) (map[string]http.Handler, error) {
+	h.mu.Lock()
+	if h.handlers != nil && req.URL.Query().Get("refresh") == "" {
+		handlers := h.handlers
+		h.mu.Unlock()
+		return handlers, nil
+	}
+	c := h.loading
+	if c == nil {
+		c = &loadCall{done: make(chan struct{})}
+		h.loading = c
+		go h.run(c, req.WithContext(context.Background()))
+	}
+	h.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.handlers, c.err
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// run runs the load c for req, serving its handlers once it completed.
+func (h *handler) run(c *loadCall, req *http.Request) {
+	c.handlers, c.err = h.build(req)
+
+	h.mu.Lock()
+	if c.err == nil {
+		h.handlers = c.handlers
+	}
+	h.loading = nil
+	h.mu.Unlock()
+	close(c.done)
+}
+
+// build fetches the profile and returns the UI handlers serving it.
+func (h *handler) build(req *http.Request) (map[string]http.Handler, error) {
+	p, err := h.fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching profile: %v", err)
+	}
+	var handlers map[string]http.Handler
+	err = driver.PProf(&driver.Options{
+		Flagset: &flags{args: []string{"-"}},
+		Fetch:   fetcher{p},
+		UI:      quietUI{},
+		HTTPServer: func(args *driver.HTTPServerArgs) error {
+			handlers = args.Handlers
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return handlers, nil
+}
+
+// fetcher implements driver.Fetcher by returning a fixed profile.
+type fetcher struct {
+	p *profile.Profile
+}
+
+func (f fetcher) Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error) {
+	return f.p.Copy(), "", nil
+}
+
+// flags implements driver.FlagSet, leaving every option at its default
+// except for the ones needed to start the web interface.
+type flags struct {
+	args []string
+}
+
+func (*flags) Bool(name string, def bool, usage string) *bool {
+	if name == "no_browser" {
+		def = true
+	}
+	return &def
+}
+
+func (*flags) Int(name string, def int, usage string) *int {
+	return &def
+}
+
+func (*flags) Float64(name string, def float64, usage string) *float64 {
+	return &def
+}
+
+func (*flags) String(name string, def string, usage string) *string {
+	switch name {
+	case "http":
+		def = "localhost:0"
+	case "symbolize":
+		def = "none"
+	}
+	return &def
+}
+
+func (*flags) StringList(name string, def string, usage string) *[]*string {
+	return &[]*string{&def}
+}
+
+func (*flags) ExtraUsage() string {
+	return ""
+}
+
+func (*flags) AddExtraUsage(eu string) {}
+
+func (f *flags) Parse(usage func()) []string {
+	return f.args
+}
+
+// quietUI implements driver.UI, discarding all messages.
+type quietUI struct{}
+
+func (quietUI) ReadLine(prompt string) (string, error) {
+	return "", fmt.Errorf("pprofweb: no interactive input")
+}
+func (quietUI) Print(...interface{})                {}
+func (quietUI) PrintErr(...interface{})             {}
+func (quietUI) IsTerminal() bool                    { return false }
+func (quietUI) WantBrowser() bool                   { return false }
+func (quietUI) SetAutoComplete(func(string) string) {}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pprof_lite
+// +build !pprof_lite
+
+package pprofweb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestHandler(t *testing.T) {
+	fetches := 0
+	fetch := RuntimeProfile("goroutine")
+	h := Handler(func(req *http.Request) (*profile.Profile, error) {
+		fetches++
+		return fetch(req)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/ui/", http.StripPrefix("/debug/pprof/ui", h))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, c := range []struct {
+		path, want string
+		fetches    int
+	}{
+		{"/debug/pprof/ui/top", "goroutine", 1},
+		{"/debug/pprof/ui/flamegraph", "", 1},
+		{"/debug/pprof/ui/top?refresh=1", "goroutine", 2},
+	} {
+		res, err := http.Get(server.URL + c.path)
+		if err != nil {
+			t.Fatalf("fetching %s: %v", c.path, err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", c.path, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d: %s", c.path, res.StatusCode, http.StatusOK, data)
+		}
+		if !strings.Contains(string(data), c.want) {
+			t.Errorf("%s: response does not contain %q", c.path, c.want)
+		}
+		if fetches != c.fetches {
+			t.Errorf("%s: got %d fetches, want %d", c.path, fetches, c.fetches)
+		}
+		// Refreshes are redirected, so that the links of the page do not
+		// refresh the profile again.
+		if got := res.Request.URL.Query().Get("refresh"); got != "" {
+			t.Errorf("%s: got redirected to %s, want the URL without refresh", c.path, res.Request.URL)
+		}
+	}
+}
+
+func TestHandlerServesDuringFetch(t *testing.T) {
+	fetch := RuntimeProfile("goroutine")
+	started, release := make(chan struct{}), make(chan struct{})
+	var slow bool
+	h := Handler(func(req *http.Request) (*profile.Profile, error) {
+		if slow {
+			close(started)
+			<-release
+		}
+		return fetch(req)
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	get := func(path string) error {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: got status %d", path, res.StatusCode)
+		}
+		return nil
+	}
+	if err := get("/top"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refresh fetching the profile leaves the UI served meanwhile.
+	slow = true
+	refreshed := make(chan error)
+	go func() { refreshed <- get("/top?refresh=1") }()
+	<-started
+	served := make(chan error)
+	go func() { served <- get("/flamegraph") }()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("the UI is not served while the profile is fetched")
+	}
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerLoadOutlivesRequest(t *testing.T) {
+	fetch := RuntimeProfile("goroutine")
+	started, release := make(chan struct{}), make(chan struct{})
+	h := Handler(func(req *http.Request) (*profile.Profile, error) {
+		close(started)
+		<-release
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return fetch(req)
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	// The request starting the load is canceled while the profile is
+	// fetched, which does not cancel the fetch.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", server.URL+"/top", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled := make(chan struct{})
+	go func() {
+		if res, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+			res.Body.Close()
+		}
+		close(canceled)
+	}()
+	<-started
+	cancel()
+	<-canceled
+	time.Sleep(10 * time.Millisecond) // Let the server see the request canceled.
+	close(release)
+
+	res, err := http.Get(server.URL + "/top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d after a canceled load, want %d", res.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// WriteCompact writes the profile as a gzip-compressed marshaled
+// protobuf, like Write, encoded to reduce its size. The samples with the
+// same locations and labels are merged, the unreferenced locations,
+// functions and mappings are dropped, and the locations, functions and
+// strings are numbered by decreasing number of references, so that the
+// most referenced ones get the shortest varint encodings. The samples are
+// sorted by stack from the root, so that similar samples are encoded next
+// to each other, which helps their compression. The profile written holds
+// the same data as p, which is left unchanged.
+func (p *Profile) WriteCompact(w io.Writer) error {
+	zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(p.marshalCompact()); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// WriteCompactUncompressed writes the profile like WriteCompact, without
+// compressing it.
+func (p *Profile) WriteCompactUncompressed(w io.Writer) error {
+	_, err := w.Write(p.marshalCompact())
+	return err
+}
+
+// marshalCompact returns the marshaled protobuf written by WriteCompact.
+func (p *Profile) marshalCompact() []byte {
+	c := p.Compact()
+	c.renumberByReferences()
+	c.sortSamplesByStack()
+
+	c.preEncodeStrings(c.stringsByReferences())
+	return marshal(c)
+}
+
+// renumberByReferences reorders and renumbers the locations and functions
+// of p by decreasing number of references from its samples and locations.
+// The mappings keep their order, as the first one is the main binary.
+func (p *Profile) renumberByReferences() {
+	locRefs := make(map[*Location]int)
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			locRefs[l]++
+		}
+	}
+	sort.SliceStable(p.Location, func(i, j int) bool {
+		return locRefs[p.Location[i]] > locRefs[p.Location[j]]
+	})
+	funcRefs := make(map[*Function]int)
+	for i, l := range p.Location {
+		l.ID = uint64(i + 1)
+		for _, ln := range l.Line {
+			if ln.Function != nil {
+				funcRefs[ln.Function]++
+			}
+		}
+	}
+	sort.SliceStable(p.Function, func(i, j int) bool {
+		return funcRefs[p.Function[i]] > funcRefs[p.Function[j]]
+	})
+	for i, f := range p.Function {
+		f.ID = uint64(i + 1)
+	}
+}
+
+// sortSamplesByStack sorts the samples of p by the IDs of their locations,
+// from the root of their stacks.
+func (p *Profile) sortSamplesByStack() {
+	sort.SliceStable(p.Sample, func(i, j int) bool {
+		a, b := p.Sample[i].Location, p.Sample[j].Location
+		for k := 1; k <= len(a) && k <= len(b); k++ {
+			if x, y := a[len(a)-k].ID, b[len(b)-k].ID; x != y {
+				return x < y
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+// stringsByReferences returns the strings referenced by p, by decreasing
+// number of references, with the strings referenced as often in the order
+// they are added to the string table by preEncode.
+func (p *Profile) stringsByReferences() []string {
+	refs := make(map[string]int)
+	var order []string
+	add := func(s string) {
+		if s == "" {
+			return
+		}
+		if refs[s] == 0 {
+			order = append(order, s)
+		}
+		refs[s]++
+	}
+
+	for _, st := range p.SampleType {
+		add(st.Type)
+		add(st.Unit)
+	}
+	for _, s := range p.Sample {
+		// The labels are visited in the order of preEncode, so that the
+		// encoding is deterministic.
+		var keys []string
+		for k := range s.Label {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range s.Label[k] {
+				add(k)
+				add(v)
+			}
+		}
+		keys = keys[:0]
+		for k := range s.NumLabel {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			units := s.NumUnit[k]
+			for i := range s.NumLabel[k] {
+				add(k)
+				if len(units) != 0 {
+					add(units[i])
+				}
+			}
+		}
+	}
+	for _, m := range p.Mapping {
+		add(m.File)
+		add(m.BuildID)
+	}
+	for _, f := range p.Function {
+		add(f.Name)
+		add(f.SystemName)
+		add(f.Filename)
+	}
+	add(p.DropFrames)
+	add(p.KeepFrames)
+	if pt := p.PeriodType; pt != nil {
+		add(pt.Type)
+		add(pt.Unit)
+	}
+	for _, c := range p.Comments {
+		add(c)
+	}
+	add(p.DefaultSampleType)
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return refs[order[i]] > refs[order[j]]
+	})
+	return order
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteCompact(t *testing.T) {
+	for _, source := range []string{
+		"cppbench.cpu",
+		"cppbench.growth",
+		"go.crc32.cpu",
+		"gobench.cpu",
+		"java.heap",
+	} {
+		t.Run(source, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := Parse(bytes.NewBuffer(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := p.String()
+
+			var compact, plain bytes.Buffer
+			if err := p.WriteCompact(&compact); err != nil {
+				t.Fatalf("WriteCompact: %v", err)
+			}
+			if err := p.Write(&plain); err != nil {
+				t.Fatal(err)
+			}
+			if p.String() != before {
+				t.Errorf("WriteCompact modified the profile")
+			}
+			if compact.Len() >= plain.Len() {
+				t.Errorf("got %d bytes written, want less than the %d bytes of Write", compact.Len(), plain.Len())
+			}
+
+			q, err := Parse(bytes.NewReader(compact.Bytes()))
+			if err != nil {
+				t.Fatalf("parsing compact profile: %v", err)
+			}
+			if got, want := compactSummary(q), compactSummary(p.Compact()); got != want {
+				t.Errorf("compact profile differs, got:\n%s\nwant:\n%s", got, want)
+			}
+
+			var again bytes.Buffer
+			if err := p.WriteCompact(&again); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again.Bytes(), compact.Bytes()) {
+				t.Errorf("WriteCompact is not deterministic")
+			}
+
+			var raw bytes.Buffer
+			if err := p.WriteCompactUncompressed(&raw); err != nil {
+				t.Fatalf("WriteCompactUncompressed: %v", err)
+			}
+			if r, err := Parse(&raw); err != nil || compactSummary(r) != compactSummary(p.Compact()) {
+				t.Errorf("uncompressed compact profile differs, or does not parse: %v", err)
+			}
+		})
+	}
+}
+
+// compactSummary returns the headers and samples of p, sorted and
+// independent of the IDs of its locations, functions and mappings.
+func compactSummary(p *Profile) string {
+	var samples []string
+	for _, s := range p.Sample {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%v", s.Value)
+		for _, l := range s.Location {
+			fmt.Fprintf(&b, " %#x", l.Address)
+			if m := l.Mapping; m != nil {
+				fmt.Fprintf(&b, "[%s %s %#x-%#x]", m.File, m.BuildID, m.Start, m.Limit)
+			}
+			for _, ln := range l.Line {
+				if f := ln.Function; f != nil {
+					fmt.Fprintf(&b, "{%s %s %s:%d}", f.Name, f.SystemName, f.Filename, ln.Line)
+				}
+			}
+		}
+		// The empty units of the numeric labels are not encoded.
+		units := make(map[string][]string)
+		for k, u := range s.NumUnit {
+			if len(u) != 0 {
+				units[k] = u
+			}
+		}
+		fmt.Fprintf(&b, " %v %v %v", s.Label, s.NumLabel, units)
+		samples = append(samples, b.String())
+	}
+	sort.Strings(samples)
+
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	var periodType string
+	if pt := p.PeriodType; pt != nil {
+		periodType = pt.Type + "/" + pt.Unit
+	}
+	header := fmt.Sprintf("%v %s %d %d %d %q %q %q", types, periodType, p.Period, p.TimeNanos, p.DurationNanos, p.Comments, p.DropFrames, p.DefaultSampleType)
+	return header + "\n" + strings.Join(samples, "\n")
+}
@@ -27,8 +27,17 @@ func (p *Profile) decoder() []decoder {
 // (with suffix X) from the corresponding exported fields. The
 // exported fields are cleared up to facilitate testing.
 func (p *Profile) preEncode() {
+	p.preEncodeStrings(nil)
+}
+
+// preEncodeStrings is preEncode with the strings of order at the start of
+// the string table, in that order, right after the empty string.
+func (p *Profile) preEncodeStrings(order []string) {
 	strings := make(map[string]int)
 	addString(strings, "")
+	for _, s := range order {
+		addString(strings, s)
+	}
 
 	for _, st := range p.SampleType {
 		st.typeX = addString(strings, st.Type)
@@ -170,6 +179,9 @@ var profileDecoder = []decoder{
 	func(b *buffer, m message) error {
 		x := new(Sample)
 		pp := m.(*Profile)
+		if err := checkElements("samples", len(pp.Sample), 1); err != nil {
+			return err
+		}
 		pp.Sample = append(pp.Sample, x)
 		return decodeMessage(b, x)
 	},
@@ -177,6 +189,9 @@ var profileDecoder = []decoder{
 	func(b *buffer, m message) error {
 		x := new(Mapping)
 		pp := m.(*Profile)
+		if err := checkElements("mappings", len(pp.Mapping), 1); err != nil {
+			return err
+		}
 		pp.Mapping = append(pp.Mapping, x)
 		return decodeMessage(b, x)
 	},
@@ -185,6 +200,9 @@ var profileDecoder = []decoder{
 		x := new(Location)
 		x.Line = make([]Line, 0, 8) // Pre-allocate Line buffer
 		pp := m.(*Profile)
+		if err := checkElements("locations", len(pp.Location), 1); err != nil {
+			return err
+		}
 		pp.Location = append(pp.Location, x)
 		err := decodeMessage(b, x)
 		var tmp []Line
@@ -195,11 +213,17 @@ var profileDecoder = []decoder{
 	func(b *buffer, m message) error {
 		x := new(Function)
 		pp := m.(*Profile)
+		if err := checkElements("functions", len(pp.Function), 1); err != nil {
+			return err
+		}
 		pp.Function = append(pp.Function, x)
 		return decodeMessage(b, x)
 	},
 	// repeated string string_table = 6
 	func(b *buffer, m message) error {
+		if err := checkElements("strings", len(m.(*Profile).stringTable), 1); err != nil {
+			return err
+		}
 		err := decodeStrings(b, &m.(*Profile).stringTable)
 		if err != nil {
 			return err
@@ -303,6 +327,10 @@ func (p *Profile) postDecode() error {
 		numLabels := make(map[string][]int64, len(s.labelX))
 		numUnits := make(map[string][]string, len(s.labelX))
 		for _, l := range s.labelX {
+			if !validLabel(p.stringTable, l) {
+				p.warn(warnInvalidLabel)
+				continue
+			}
 			var key, value string
 			key, err = getString(p.stringTable, &l.keyX, err)
 			if l.strX != 0 {
@@ -554,12 +582,20 @@ func addString(strings map[string]int, s string) int64 {
 	return int64(i)
 }
 
+// validLabel reports whether the key and values of l refer to entries
+// of the string table, and whether the key is not empty.
+func validLabel(strings []string, l label) bool {
+	valid := func(x int64) bool { return x >= 0 && x < int64(len(strings)) }
+	return l.keyX != 0 && valid(l.keyX) && valid(l.strX) && valid(l.unitX)
+}
+
 func getString(strings []string, strng *int64, err error) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	s := int(*strng)
-	if s < 0 || s >= len(strings) {
+	// Compare the index before converting it, as ints may be narrower.
+	s := *strng
+	if s < 0 || s >= int64(len(strings)) {
 		return "", errMalformed
 	}
 	*strng = 0
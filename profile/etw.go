@@ -0,0 +1,315 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the sampled profiles of
+// Windows Event Tracing (ETW) traces into the profile.proto format. The
+// .etl files are not read directly, but as exported to text by
+// "xperf -i trace.etl -o trace.csv -a dumper", after being recorded with
+// the Profile stack walk, eg "xperf -on PROC_THREAD+LOADER+PROFILE
+// -stackwalk Profile".
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The events of the xperf dumper output converted to samples.
+const (
+	etwSampledProfile = "SampledProfile"
+	etwStack          = "Stack"
+)
+
+// The columns of the events of the xperf dumper output, as named in the
+// event definitions of its header.
+const (
+	etwTimeStamp = "TimeStamp"
+	etwProcess   = "Process Name ( PID)"
+	etwThreadID  = "ThreadID"
+	etwPC        = "PrgrmCtr"
+	etwFunction  = "Image!Function"
+	etwCount     = "Count"
+	etwFrameNo   = "No."
+	etwAddress   = "Address"
+)
+
+// etwProcessName matches the process of an event, eg "app.exe (1234)".
+var etwProcessName = regexp.MustCompile(`^(.*) \(\s*(\d+)\)$`)
+
+// etwEvent holds the columns of an event definition of the xperf dumper
+// output.
+type etwEvent struct {
+	columns  []string
+	function int // Index of the Image!Function column, or -1.
+}
+
+// field returns the value of the named column of a record of the event,
+// or "" if the event has no such column. The Image!Function column may
+// hold commas, as in C++ template arguments, so the columns after it are
+// located from the end of the record.
+func (e *etwEvent) field(record []string, column string) string {
+	for i, c := range e.columns {
+		if c != column {
+			continue
+		}
+		extra := len(record) - len(e.columns)
+		switch {
+		case extra < 0 && i >= len(record):
+			return ""
+		case extra <= 0 || e.function == -1 || i < e.function:
+			return record[i]
+		case i == e.function:
+			return strings.Join(record[i:i+extra+1], ",")
+		default:
+			return record[i+extra]
+		}
+	}
+	return ""
+}
+
+// etwSampleKey identifies the sampled profile event that stack events
+// belong to.
+type etwSampleKey struct {
+	timestamp, thread string
+}
+
+// parseETW parses the xperf dumper output of an ETW trace. Each sampled
+// profile event becomes a sample, with the stack of the stack events of
+// the same thread and time stamp, or only its program counter if it has
+// no stack events.
+func parseETW(b []byte) (*Profile, error) {
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	s.Buffer(nil, len(b)+1)
+	events := make(map[string]*etwEvent)
+	inHeader := false
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if line == "BeginHeader" {
+			inHeader = true
+			continue
+		}
+		if line == "EndHeader" {
+			break
+		}
+		if !inHeader {
+			return nil, errUnrecognized
+		}
+		record := etwRecord(line)
+		e := &etwEvent{columns: record[1:], function: -1}
+		for i, c := range e.columns {
+			if c == etwFunction {
+				e.function = i
+			}
+		}
+		events[record[0]] = e
+	}
+	sampled, stack := events[etwSampledProfile], events[etwStack]
+	if sampled == nil {
+		return nil, errUnrecognized
+	}
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "samples", Unit: "count"},
+		Period:     1,
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+	}
+	ep := &etwParser{
+		p:         p,
+		locations: make(map[string]*Location),
+		functions: make(map[string]*Function),
+		mappings:  make(map[string]*Mapping),
+		samples:   make(map[etwSampleKey]*Sample),
+		pcs:       make(map[*Sample]etwFrame),
+	}
+	for s.Scan() {
+		lineno++
+		record := etwRecord(s.Text())
+		var err error
+		switch record[0] {
+		case etwSampledProfile:
+			err = ep.addSample(sampled, record[1:])
+		case etwStack:
+			if stack != nil {
+				err = ep.addFrame(stack, record[1:])
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ETW trace line %d: %v", lineno, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 {
+			// The program counter is the only frame of a sample without stack.
+			pc := ep.pcs[s]
+			s.Location = []*Location{ep.location(pc.addr, pc.frame)}
+		}
+	}
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("ETW trace has no sampled profile events")
+	}
+	return p, nil
+}
+
+// etwRecord splits a line of the xperf dumper output into its columns.
+func etwRecord(line string) []string {
+	record := strings.Split(line, ",")
+	for i, f := range record {
+		record[i] = strings.TrimSpace(f)
+	}
+	return record
+}
+
+// etwParser holds the state of the conversion of an ETW trace.
+type etwParser struct {
+	p         *Profile
+	locations map[string]*Location
+	functions map[string]*Function
+	mappings  map[string]*Mapping
+
+	samples map[etwSampleKey]*Sample
+	pcs     map[*Sample]etwFrame // Program counters of the samples.
+}
+
+// etwFrame is the address of a frame, and its image!function.
+type etwFrame struct {
+	addr  uint64
+	frame string
+}
+
+// addSample adds the sample of a SampledProfile event, with the process
+// and thread labels, and records its program counter for the samples
+// without stack.
+func (ep *etwParser) addSample(e *etwEvent, record []string) error {
+	count := int64(1)
+	if c := e.field(record, etwCount); c != "" {
+		var err error
+		if count, err = strconv.ParseInt(c, 10, 64); err != nil {
+			return fmt.Errorf("invalid count %q", c)
+		}
+	}
+	pc, err := strconv.ParseUint(e.field(record, etwPC), 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid program counter %q", e.field(record, etwPC))
+	}
+	tid, err := strconv.ParseInt(e.field(record, etwThreadID), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid thread ID %q", e.field(record, etwThreadID))
+	}
+
+	s := &Sample{
+		Value:    []int64{count},
+		NumLabel: make(map[string][]int64),
+	}
+	// The IDs of the idle process and its threads are 0, which numeric
+	// labels cannot hold.
+	if tid != 0 {
+		s.NumLabel["tid"] = []int64{tid}
+	}
+	if m := etwProcessName.FindStringSubmatch(e.field(record, etwProcess)); m != nil {
+		s.Label = map[string][]string{"process": {m[1]}}
+		if pid, _ := strconv.ParseInt(m[2], 10, 64); pid != 0 {
+			s.NumLabel["pid"] = []int64{pid}
+		}
+	}
+	ep.samples[etwSampleKey{e.field(record, etwTimeStamp), e.field(record, etwThreadID)}] = s
+	ep.pcs[s] = etwFrame{pc, e.field(record, etwFunction)}
+	ep.p.Sample = append(ep.p.Sample, s)
+	return nil
+}
+
+// addFrame adds the frame of a Stack event to the sample of the same
+// thread and time stamp. The frames of a stack are numbered from 1, at
+// the leaf. Stacks of other events are ignored.
+func (ep *etwParser) addFrame(e *etwEvent, record []string) error {
+	s := ep.samples[etwSampleKey{e.field(record, etwTimeStamp), e.field(record, etwThreadID)}]
+	if s == nil {
+		return nil
+	}
+	n, err := strconv.Atoi(e.field(record, etwFrameNo))
+	if err != nil || n != len(s.Location)+1 {
+		return fmt.Errorf("unexpected stack frame %q, want %d", e.field(record, etwFrameNo), len(s.Location)+1)
+	}
+	addr, err := strconv.ParseUint(e.field(record, etwAddress), 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", e.field(record, etwAddress))
+	}
+	s.Location = append(s.Location, ep.location(addr, e.field(record, etwFunction)))
+	return nil
+}
+
+// location returns the location of the code at addr, described by
+// frame as image!function, where the function may be unknown, as in
+// "app.exe!0x1234" or "Unknown".
+func (ep *etwParser) location(addr uint64, frame string) *Location {
+	key := fmt.Sprintf("%x %s", addr, frame)
+	if l := ep.locations[key]; l != nil {
+		return l
+	}
+	p := ep.p
+	l := &Location{
+		ID:      uint64(len(p.Location) + 1),
+		Address: addr,
+	}
+	ep.locations[key] = l
+	p.Location = append(p.Location, l)
+
+	image, name := "", frame
+	if i := strings.Index(frame, "!"); i != -1 {
+		image, name = frame[:i], frame[i+1:]
+	}
+	if image != "" && image != "Unknown" {
+		m := ep.mappings[image]
+		if m == nil {
+			m = &Mapping{
+				ID:   uint64(len(p.Mapping) + 1),
+				File: image,
+			}
+			ep.mappings[image] = m
+			p.Mapping = append(p.Mapping, m)
+		}
+		l.Mapping = m
+	}
+	if image == "" || name == "" || strings.HasPrefix(name, "0x") {
+		return l
+	}
+	if l.Mapping != nil {
+		l.Mapping.HasFunctions = true
+	}
+	f := ep.functions[frame]
+	if f == nil {
+		f = &Function{
+			ID:         uint64(len(p.Function) + 1),
+			Name:       name,
+			SystemName: name,
+		}
+		ep.functions[frame] = f
+		p.Function = append(p.Function, f)
+	}
+	l.Line = []Line{{Function: f}}
+	return l
+}
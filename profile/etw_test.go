@@ -0,0 +1,50 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseETWMalformed(t *testing.T) {
+	const header = "BeginHeader\n" +
+		"SampledProfile, TimeStamp, Process Name ( PID), ThreadID, PrgrmCtr, Image!Function, Count\n" +
+		"Stack, TimeStamp, ThreadID, No., Address, Image!Function\n" +
+		"EndHeader\n"
+	const sample = "SampledProfile, 1, a.exe (1), 2, 0x10, a.exe!f, 1\n"
+	for _, tc := range []struct {
+		desc, data, want string
+	}{
+		{"no samples", header, "ETW trace has no sampled profile events"},
+		{"bad count", header + "SampledProfile, 1, a.exe (1), 2, 0x10, a.exe!f, x\n", `ETW trace line 5: invalid count "x"`},
+		{"bad program counter", header + "SampledProfile, 1, a.exe (1), 2, pc, a.exe!f, 1\n", `ETW trace line 5: invalid program counter "pc"`},
+		{"missing frame", header + sample + "Stack, 1, 2, 2, 0x10, a.exe!f\n", `ETW trace line 6: unexpected stack frame "2", want 1`},
+		{"bad address", header + sample + "Stack, 1, 2, 1, addr, a.exe!f\n", `ETW trace line 6: invalid address "addr"`},
+	} {
+		_, err := parseETW([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %s", tc.desc, err, tc.want)
+		}
+	}
+	for _, data := range []string{
+		"SampledProfile, 1\n",
+		"BeginHeader\nStack, TimeStamp, ThreadID, No., Address, Image!Function\nEndHeader\n",
+	} {
+		if _, err := parseETW([]byte(data)); err != errUnrecognized {
+			t.Errorf("got error %v for %q, want errUnrecognized", err, data)
+		}
+	}
+}
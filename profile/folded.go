@@ -0,0 +1,187 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert folded stacks, as produced by
+// the stackcollapse-* scripts, bpftrace or async-profiler, into the
+// profile.proto format.
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// parseFolded parses a profile in the folded stack format: one sample
+// per line, with frames from the root to the leaf separated by
+// semicolons, followed by a space and the sample count. Empty lines and
+// lines starting with '#' are ignored. The thread and thread state frames
+// of async-profiler become labels of the samples.
+func parseFolded(b []byte) (*Profile, error) {
+	p := &Profile{
+		PeriodType: &ValueType{Type: "samples", Unit: "count"},
+		Period:     1,
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+	}
+	locations := make(map[string]*Location)
+
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	s.Buffer(nil, len(b)+1)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if isSpaceOrComment(line) {
+			continue
+		}
+		sep := strings.LastIndexByte(line, ' ')
+		if sep == -1 {
+			return nil, errUnrecognized
+		}
+		n, err := strconv.ParseInt(line[sep+1:], 10, 64)
+		if err != nil {
+			return nil, errUnrecognized
+		}
+		frames, labels, numLabels := asyncProfilerLabels(strings.Split(strings.TrimSpace(line[:sep]), ";"))
+		locs := make([]*Location, 0, len(frames))
+		// Frames are listed from the root, samples start at the leaf.
+		for i := len(frames) - 1; i >= 0; i-- {
+			name := frames[i]
+			if name == "" {
+				continue
+			}
+			loc := locations[name]
+			if loc == nil {
+				fn := &Function{
+					ID:         uint64(len(p.Function) + 1),
+					Name:       name,
+					SystemName: name,
+				}
+				p.Function = append(p.Function, fn)
+				loc = &Location{
+					ID:   uint64(len(p.Location) + 1),
+					Line: []Line{{Function: fn}},
+				}
+				p.Location = append(p.Location, loc)
+				locations[name] = loc
+			}
+			locs = append(locs, loc)
+		}
+		if len(locs) == 0 {
+			return nil, errUnrecognized
+		}
+		p.Sample = append(p.Sample, &Sample{
+			Location: locs,
+			Value:    []int64{n},
+			Label:    labels,
+			NumLabel: numLabels,
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Sample) == 0 {
+		return nil, errUnrecognized
+	}
+	return p, nil
+}
+
+// asyncProfilerLabels removes from the frames of a stack, listed from the
+// root, the pseudo-frames added by async-profiler: the "[name tid=N]"
+// frame of the thread at the root, given with -t, and the "[STATE]" frame
+// of the thread state, such as [RUNNABLE] or [STATE_SLEEPING], next to the
+// root or to the leaf. It returns the frames left and the labels holding
+// the thread name, id and state.
+func asyncProfilerLabels(frames []string) ([]string, map[string][]string, map[string][]int64) {
+	var labels map[string][]string
+	var numLabels map[string][]int64
+	setLabel := func(key, value string) {
+		if labels == nil {
+			labels = make(map[string][]string)
+		}
+		labels[key] = []string{value}
+	}
+	if len(frames) > 0 {
+		if name, tid, ok := asyncProfilerThread(frames[0]); ok {
+			if name != "" {
+				setLabel("thread", name)
+			}
+			numLabels = map[string][]int64{"tid": {tid}}
+			frames = frames[1:]
+		}
+	}
+	if len(frames) > 1 {
+		if state := asyncProfilerState(frames[0]); state != "" {
+			setLabel("thread_state", state)
+			frames = frames[1:]
+		} else if state := asyncProfilerState(frames[len(frames)-1]); state != "" {
+			setLabel("thread_state", state)
+			frames = frames[:len(frames)-1]
+		}
+	}
+	return frames, labels, numLabels
+}
+
+// asyncProfilerThread parses a thread frame of async-profiler, eg
+// "[main tid=1234]" or "[tid=1234]", into the name and id of the thread.
+func asyncProfilerThread(frame string) (string, int64, bool) {
+	if !strings.HasPrefix(frame, "[") || !strings.HasSuffix(frame, "]") {
+		return "", 0, false
+	}
+	frame = frame[1 : len(frame)-1]
+	i := strings.LastIndex(frame, "tid=")
+	if i == -1 || (i > 0 && frame[i-1] != ' ') {
+		return "", 0, false
+	}
+	tid, err := strconv.ParseInt(frame[i+len("tid="):], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.TrimSpace(frame[:i]), tid, true
+}
+
+// threadStates are the thread states of the JVM and of async-profiler.
+var threadStates = map[string]bool{
+	"new":           true,
+	"runnable":      true,
+	"running":       true,
+	"blocked":       true,
+	"waiting":       true,
+	"timed_waiting": true,
+	"sleeping":      true,
+	"parked":        true,
+	"terminated":    true,
+	"default":       true,
+}
+
+// asyncProfilerState returns the thread state of a state frame, eg
+// "[RUNNABLE]" or "[STATE_SLEEPING]", in the form of threadState, or ""
+// if frame is not one.
+func asyncProfilerState(frame string) string {
+	if !strings.HasPrefix(frame, "[") || !strings.HasSuffix(frame, "]") {
+		return ""
+	}
+	state := threadState(frame[1 : len(frame)-1])
+	if !threadStates[state] {
+		return ""
+	}
+	return state
+}
+
+// threadState returns the name of a thread state in lower case, without
+// the STATE_ prefix of the JFR and async-profiler constants, eg "runnable"
+// for STATE_RUNNABLE.
+func threadState(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.ToUpper(name), "STATE_"))
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFolded(t *testing.T) {
+	const folded = `# collapsed stacks
+main;foo;bar 10
+main;foo 5
+
+main;baz (inlined);bar 3
+`
+	p, err := Parse(strings.NewReader(folded))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got, want := len(p.Function), 4; got != want {
+		t.Errorf("got %d functions, want %d", got, want)
+	}
+	var got []string
+	for _, s := range p.Sample {
+		var names []string
+		for _, l := range s.Location {
+			names = append(names, l.Line[0].Function.Name)
+		}
+		got = append(got, fmt.Sprintf("%s %d", strings.Join(names, ","), s.Value[0]))
+	}
+	want := []string{
+		"bar,foo,main 10",
+		"foo,main 5",
+		"bar,baz (inlined),main 3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples %q, want %q", got, want)
+	}
+}
+
+func TestParseFoldedAsyncProfiler(t *testing.T) {
+	const folded = `[main tid=7939];java/lang/Thread.run;com/example/Worker.work_[j] 10
+[tid=12];[STATE_SLEEPING];java/lang/Thread.sleep 4
+[C2 CompilerThread0 tid=21];Compile::Optimize;[RUNNABLE] 2
+[unknown];start_thread 1
+`
+	p, err := Parse(strings.NewReader(folded))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var got []string
+	for _, s := range p.Sample {
+		var names []string
+		for _, l := range s.Location {
+			names = append(names, l.Line[0].Function.Name)
+		}
+		got = append(got, fmt.Sprintf("%s %d thread=%v tid=%v state=%v", strings.Join(names, ","), s.Value[0], s.Label["thread"], s.NumLabel["tid"], s.Label["thread_state"]))
+	}
+	want := []string{
+		"com/example/Worker.work_[j],java/lang/Thread.run 10 thread=[main] tid=[7939] state=[]",
+		"java/lang/Thread.sleep 4 thread=[] tid=[12] state=[sleeping]",
+		"Compile::Optimize 2 thread=[C2 CompilerThread0] tid=[21] state=[runnable]",
+		"start_thread,[unknown] 1 thread=[] tid=[] state=[]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseFoldedUnrecognized(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"# only comments\n",
+		"main;foo\n",
+		"main;foo 10\nnot a sample\n",
+		"main;foo 1.5\n",
+	} {
+		if _, err := parseFolded([]byte(input)); err != errUnrecognized {
+			t.Errorf("parseFolded(%q): got error %v, want %v", input, err, errUnrecognized)
+		}
+	}
+}
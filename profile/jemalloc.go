@@ -0,0 +1,189 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the heap profiles dumped by
+// jemalloc, in prof.<pid>.<seq>.[fimu].heap files, into the profile.proto
+// format. The profiles of jemalloc 3, which use the format of tcmalloc,
+// are parsed by parseHeap instead.
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// jemallocHeaderRE matches the first line of a jemalloc heap profile,
+	// with the mean sampling interval in bytes, eg "heap_v2/524288".
+	jemallocHeaderRE = regexp.MustCompile(`^heap_v2/(\d+)$`)
+	// jemallocCountsRE matches the counts of a thread, or of all threads
+	// as "t*", with the objects and bytes in use, followed by those
+	// allocated since the start of the profile, eg "t*: 2: 64 [5: 160]".
+	jemallocCountsRE = regexp.MustCompile(`^t(\*|\d+): *(\d+): *(\d+) *\[ *(\d+): *(\d+) *\]$`)
+)
+
+// parseJemallocHeap parses a jemalloc heap profile. Each backtrace,
+// starting with "@", becomes a sample with the counts of all its threads,
+// scaled to account for the sampling. The allocation counts are only
+// reported if jemalloc accumulated them, with the prof_accum option.
+func parseJemallocHeap(b []byte) (*Profile, error) {
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errUnrecognized
+	}
+	header := jemallocHeaderRE.FindStringSubmatch(strings.TrimSpace(s.Text()))
+	if header == nil {
+		return nil, errUnrecognized
+	}
+	period, err := strconv.ParseInt(header[1], 10, 64)
+	if err != nil {
+		return nil, errUnrecognized
+	}
+
+	// The counts of the whole profile follow the header.
+	if !s.Scan() {
+		return nil, fmt.Errorf("jemalloc heap profile has no counts")
+	}
+	totals := jemallocCountsRE.FindStringSubmatch(strings.TrimSpace(s.Text()))
+	if totals == nil || totals[1] != "*" {
+		return nil, fmt.Errorf("malformed jemalloc heap profile counts: %s", s.Text())
+	}
+	hasAlloc := totals[4] != "0" || totals[5] != "0"
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "space", Unit: "bytes"},
+		Period:     period,
+	}
+	if hasAlloc {
+		// Put alloc before inuse so that default pprof selection
+		// will prefer inuse_space.
+		p.SampleType = []*ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		}
+	} else {
+		p.SampleType = []*ValueType{
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		}
+	}
+
+	locs := make(map[uint64]*Location)
+	var sloc []*Location
+	inStack := false
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if isSpaceOrComment(line) {
+			continue
+		}
+		if isMemoryMapSentinel(line) {
+			break
+		}
+
+		if strings.HasPrefix(line, "@") {
+			addrs, err := parseHexAddresses(line)
+			if err != nil || len(addrs) == 0 {
+				return nil, fmt.Errorf("malformed jemalloc heap backtrace: %s", line)
+			}
+			sloc = nil
+			for _, addr := range addrs {
+				// Addresses from stack traces point to the next instruction after
+				// each call. Adjust by -1 to land somewhere on the actual call.
+				addr--
+				loc := locs[addr]
+				if loc == nil {
+					loc = &Location{
+						Address: addr,
+					}
+					p.Location = append(p.Location, loc)
+					locs[addr] = loc
+				}
+				sloc = append(sloc, loc)
+			}
+			inStack = true
+			continue
+		}
+
+		counts := jemallocCountsRE.FindStringSubmatch(line)
+		if counts == nil {
+			return nil, fmt.Errorf("malformed jemalloc heap sample: %s", line)
+		}
+		if counts[1] != "*" || !inStack {
+			// The counts of each thread are already part of those of all threads.
+			continue
+		}
+		value, blocksize, err := parseJemallocCounts(counts, period, hasAlloc)
+		if err != nil {
+			return nil, fmt.Errorf("malformed jemalloc heap sample: %s: %v", line, err)
+		}
+		p.Sample = append(p.Sample, &Sample{
+			Value:    value,
+			Location: sloc,
+			NumLabel: map[string][]int64{"bytes": {blocksize}},
+		})
+		inStack = false
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if err := parseAdditionalSections(s, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseJemallocCounts returns the values of the counts of all threads of
+// a backtrace, matched by jemallocCountsRE, and the mean size of their
+// objects.
+func parseJemallocCounts(counts []string, rate int64, includeAlloc bool) (value []int64, blocksize int64, err error) {
+	addValues := func(countString, sizeString string, label string) error {
+		count, err := strconv.ParseInt(countString, 10, 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseInt(sizeString, 10, 64)
+		if err != nil {
+			return err
+		}
+		if count == 0 && size != 0 {
+			return fmt.Errorf("%s count was 0 but %s bytes was %d", label, label, size)
+		}
+		if count != 0 {
+			blocksize = size / count
+			count, size = scaleHeapSample(count, size, rate)
+		}
+		value = append(value, count, size)
+		return nil
+	}
+
+	if includeAlloc {
+		if err := addValues(counts[4], counts[5], "allocation"); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := addValues(counts[2], counts[3], "inuse"); err != nil {
+		return nil, 0, err
+	}
+	return value, blocksize, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJemallocHeapInuse(t *testing.T) {
+	// Without prof_accum, jemalloc only reports the objects in use, and
+	// with a sampling interval of 1 every allocation is sampled.
+	const data = "heap_v2/1\n" +
+		"  t*: 3: 96 [0: 0]\n" +
+		"  t0: 3: 96 [0: 0]\n" +
+		"@ 0x11 0x21\n" +
+		"  t*: 2: 64 [0: 0]\n" +
+		"  t0: 2: 64 [0: 0]\n" +
+		"@ 0x12 0x21\n" +
+		"  t*: 1: 32 [0: 0]\n" +
+		"  t0: 1: 32 [0: 0]\n"
+	p, err := parseJemallocHeap([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type)
+	}
+	if want := []string{"inuse_objects", "inuse_space"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got sample types %v, want %v", types, want)
+	}
+	var values [][]int64
+	for _, s := range p.Sample {
+		values = append(values, s.Value)
+	}
+	if want := [][]int64{{2, 64}, {1, 32}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got values %v, want %v", values, want)
+	}
+	if len(p.Location) != 3 || p.Sample[1].Location[1] != p.Sample[0].Location[1] {
+		t.Errorf("got %d locations, want 3 with the callers shared", len(p.Location))
+	}
+}
+
+func TestParseJemallocHeapMalformed(t *testing.T) {
+	const header = "heap_v2/524288\n  t*: 1: 32 [0: 0]\n"
+	for _, tc := range []struct {
+		desc, data, want string
+	}{
+		{"no counts", "heap_v2/524288\n", "jemalloc heap profile has no counts"},
+		{"thread totals", "heap_v2/524288\n  t0: 1: 32 [0: 0]\n", "malformed jemalloc heap profile counts"},
+		{"bad backtrace", header + "@ main\n", "malformed jemalloc heap backtrace: @ main"},
+		{"bad counts", header + "@ 0x10\n  t*: 1 32\n", "malformed jemalloc heap sample: t*: 1 32"},
+		{"no objects", header + "@ 0x10\n  t*: 0: 32 [0: 0]\n", "inuse count was 0 but inuse bytes was 32"},
+	} {
+		_, err := parseJemallocHeap([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %s", tc.desc, err, tc.want)
+		}
+	}
+	for _, data := range []string{
+		"",
+		"heap profile: 1: 32 [1: 32] @ heap_v2/524288\n",
+	} {
+		if _, err := parseJemallocHeap([]byte(data)); err != errUnrecognized {
+			t.Errorf("got error %v for %q, want errUnrecognized", err, data)
+		}
+	}
+}
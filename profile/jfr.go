@@ -0,0 +1,671 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert Java Flight Recorder (JFR)
+// recordings into the profile.proto format. Execution samples and object
+// allocation events are converted into samples, labeled with the thread
+// that recorded them, its state and the class of the allocated objects.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var jfrMagic = []byte("FLR\x00")
+
+const (
+	jfrHeaderSize        = 68
+	jfrMetadataEventType = 0
+	jfrConstantPoolType  = 1
+	jfrCompressedInts    = 1 // Chunk feature flag for LEB128 integers.
+	jfrMaxDepth          = 64
+)
+
+// jfrEvents are the names of the events converted into samples.
+const (
+	jfrExecutionSample   = "jdk.ExecutionSample"
+	jfrAllocationInTLAB  = "jdk.ObjectAllocationInNewTLAB"
+	jfrAllocationOutside = "jdk.ObjectAllocationOutsideTLAB"
+	jfrAllocationSample  = "jdk.ObjectAllocationSample"
+)
+
+// jfrReader decodes the primitive values of a JFR chunk. Errors are
+// sticky: after the first error all reads return zero values.
+type jfrReader struct {
+	b          []byte
+	pos        int
+	compressed bool
+	err        error
+}
+
+func (r *jfrReader) fail() {
+	if r.err == nil {
+		r.err = errMalformed
+	}
+}
+
+func (r *jfrReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.b) {
+		r.fail()
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *jfrReader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// varint decodes a JFR compressed integer: up to 8 groups of 7 bits, least
+// significant first, followed by an optional byte with the 8 top bits.
+func (r *jfrReader) varint() int64 {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		b := r.byte()
+		if i == 8 {
+			v |= uint64(b) << 56
+			break
+		}
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return int64(v)
+}
+
+// fixed reads a big-endian integer of n bytes, or a compressed integer if
+// the chunk uses them.
+func (r *jfrReader) fixed(n int) int64 {
+	if r.compressed {
+		return r.varint()
+	}
+	b := r.bytes(n)
+	if b == nil {
+		return 0
+	}
+	switch n {
+	case 2:
+		return int64(int16(binary.BigEndian.Uint16(b)))
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(b)))
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (r *jfrReader) int() int64  { return r.fixed(4) }
+func (r *jfrReader) long() int64 { return r.fixed(8) }
+
+// count reads a non-negative int used as a length, bounded by the size of
+// the remaining data so that malformed input cannot cause huge allocations.
+func (r *jfrReader) count() int {
+	n := r.int()
+	if n < 0 || n > int64(len(r.b)-r.pos) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// jfrRef is a reference to an entry of a constant pool.
+type jfrRef struct {
+	typeID, index int64
+}
+
+// string reads an encoded string, which may be a reference to the string
+// constant pool.
+func (r *jfrReader) string(stringType int64) interface{} {
+	switch enc := r.byte(); enc {
+	case 0, 1: // null, empty
+		return ""
+	case 2: // Constant pool reference
+		return jfrRef{stringType, r.long()}
+	case 3: // UTF-8
+		return string(r.bytes(r.count()))
+	case 4: // UTF-16 char array
+		n := r.count()
+		chars := make([]uint16, n)
+		for i := range chars {
+			chars[i] = uint16(r.fixed(2))
+		}
+		return string(utf16.Decode(chars))
+	case 5: // Latin-1
+		b := r.bytes(r.count())
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	default:
+		r.fail()
+		return ""
+	}
+}
+
+// jfrClass is a type described by the metadata of a chunk.
+type jfrClass struct {
+	name   string
+	fields []jfrField
+}
+
+type jfrField struct {
+	name   string
+	typeID int64
+	cp     bool // Whether values are references to a constant pool.
+	array  bool
+}
+
+// jfrObject holds the field values of a complex type.
+type jfrObject map[string]interface{}
+
+// jfrEvent is an event converted into samples.
+type jfrEvent struct {
+	class  string
+	fields jfrObject
+}
+
+// jfrChunk holds the decoded contents of a chunk of a recording.
+type jfrChunk struct {
+	r                                     *jfrReader
+	startNanos, durationNanos, startTicks int64
+	ticksPerSecond                        int64
+	classes                               map[int64]*jfrClass
+	stringType                            int64
+	pools                                 map[int64]map[int64]interface{}
+	events                                []jfrEvent
+}
+
+// parseJFR parses a Java Flight Recorder recording, made of one or more
+// chunks, into a profile.
+func parseJFR(b []byte) (*Profile, error) {
+	if !bytes.HasPrefix(b, jfrMagic) {
+		return nil, errUnrecognized
+	}
+	jb := newJFRBuilder()
+	for len(b) > 0 {
+		c, size, err := parseJFRChunk(b)
+		if err != nil {
+			return nil, err
+		}
+		jb.addChunk(c)
+		b = b[size:]
+	}
+	return jb.profile(), nil
+}
+
+// parseJFRChunk parses the chunk at the start of b, returning it along with
+// its size.
+func parseJFRChunk(b []byte) (*jfrChunk, int, error) {
+	if len(b) < jfrHeaderSize || !bytes.HasPrefix(b, jfrMagic) {
+		return nil, 0, fmt.Errorf("malformed JFR chunk header")
+	}
+	if major := binary.BigEndian.Uint16(b[4:6]); major != 2 {
+		return nil, 0, fmt.Errorf("unsupported JFR version %d.%d", major, binary.BigEndian.Uint16(b[6:8]))
+	}
+	header := func(i int) int64 { return int64(binary.BigEndian.Uint64(b[8+8*i:])) }
+	size, metadataOffset := header(0), header(2)
+	if size < jfrHeaderSize || size > int64(len(b)) || metadataOffset < jfrHeaderSize || metadataOffset >= size {
+		return nil, 0, fmt.Errorf("malformed JFR chunk header")
+	}
+	c := &jfrChunk{
+		r:              &jfrReader{b: b[:size], compressed: binary.BigEndian.Uint32(b[64:68])&jfrCompressedInts != 0},
+		startNanos:     header(3),
+		durationNanos:  header(4),
+		startTicks:     header(5),
+		ticksPerSecond: header(6),
+		pools:          make(map[int64]map[int64]interface{}),
+	}
+	if err := c.parseMetadata(int(metadataOffset)); err != nil {
+		return nil, 0, err
+	}
+	if err := c.parseEvents(); err != nil {
+		return nil, 0, err
+	}
+	return c, int(size), nil
+}
+
+// jfrElement is a node of the metadata element tree.
+type jfrElement struct {
+	name     string
+	attrs    map[string]string
+	children []*jfrElement
+}
+
+func (c *jfrChunk) parseMetadata(offset int) error {
+	r := c.r
+	r.pos = offset
+	r.int() // size
+	if r.long() != jfrMetadataEventType {
+		return fmt.Errorf("malformed JFR metadata")
+	}
+	r.long() // start time
+	r.long() // duration
+	r.long() // metadata id
+	strs := make([]string, r.count())
+	for i := range strs {
+		s, _ := r.string(0).(string)
+		strs[i] = s
+	}
+	root := c.parseElement(strs, 0)
+	if r.err != nil {
+		return fmt.Errorf("malformed JFR metadata: %v", r.err)
+	}
+
+	c.classes = make(map[int64]*jfrClass)
+	for _, m := range root.children {
+		if m.name != "metadata" {
+			continue
+		}
+		for _, e := range m.children {
+			if e.name != "class" {
+				continue
+			}
+			id, err := strconv.ParseInt(e.attrs["id"], 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed JFR class id %q", e.attrs["id"])
+			}
+			class := &jfrClass{name: e.attrs["name"]}
+			for _, f := range e.children {
+				if f.name != "field" {
+					continue
+				}
+				typeID, err := strconv.ParseInt(f.attrs["class"], 10, 64)
+				if err != nil {
+					return fmt.Errorf("malformed JFR field type %q", f.attrs["class"])
+				}
+				class.fields = append(class.fields, jfrField{
+					name:   f.attrs["name"],
+					typeID: typeID,
+					cp:     f.attrs["constantPool"] == "true",
+					array:  f.attrs["dimension"] == "1",
+				})
+			}
+			c.classes[id] = class
+			if class.name == "java.lang.String" {
+				c.stringType = id
+			}
+		}
+	}
+	return nil
+}
+
+func (c *jfrChunk) parseElement(strs []string, depth int) *jfrElement {
+	r := c.r
+	str := func() string {
+		i := r.int()
+		if i < 0 || i >= int64(len(strs)) {
+			r.fail()
+			return ""
+		}
+		return strs[i]
+	}
+	if depth > jfrMaxDepth {
+		r.fail()
+		return &jfrElement{}
+	}
+	e := &jfrElement{name: str(), attrs: make(map[string]string)}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		k := str()
+		e.attrs[k] = str()
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		e.children = append(e.children, c.parseElement(strs, depth+1))
+	}
+	return e
+}
+
+// parseEvents parses the constant pools and the events of interest of the
+// chunk, skipping all other events.
+func (c *jfrChunk) parseEvents() error {
+	r := c.r
+	for pos := jfrHeaderSize; pos < len(r.b); {
+		r.pos = pos
+		size := r.int()
+		typeID := r.long()
+		if r.err != nil || size <= 0 || int64(pos)+size > int64(len(r.b)) {
+			return fmt.Errorf("malformed JFR event at offset %d", pos)
+		}
+		switch typeID {
+		case jfrMetadataEventType:
+		case jfrConstantPoolType:
+			c.parseConstantPool()
+		default:
+			class := c.classes[typeID]
+			if class == nil {
+				break
+			}
+			switch class.name {
+			case jfrExecutionSample, jfrAllocationInTLAB, jfrAllocationOutside, jfrAllocationSample:
+				obj, _ := c.readObject(class, 0).(jfrObject)
+				c.events = append(c.events, jfrEvent{class.name, obj})
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("malformed JFR event at offset %d: %v", pos, r.err)
+		}
+		pos += int(size)
+	}
+	return nil
+}
+
+func (c *jfrChunk) parseConstantPool() {
+	r := c.r
+	r.long() // start time
+	r.long() // duration
+	r.long() // delta to the previous constant pool
+	r.byte() // flush
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		typeID := r.long()
+		class := c.classes[typeID]
+		if class == nil {
+			r.fail()
+			return
+		}
+		pool := c.pools[typeID]
+		if pool == nil {
+			pool = make(map[int64]interface{})
+			c.pools[typeID] = pool
+		}
+		for m := r.count(); m > 0 && r.err == nil; m-- {
+			index := r.long()
+			pool[index] = c.readValue(typeID, 0)
+		}
+	}
+}
+
+// readValue reads a value of the given type.
+func (c *jfrChunk) readValue(typeID int64, depth int) interface{} {
+	r := c.r
+	class := c.classes[typeID]
+	if class == nil {
+		r.fail()
+		return nil
+	}
+	switch class.name {
+	case "boolean":
+		return r.byte() != 0
+	case "byte":
+		return int64(int8(r.byte()))
+	case "char", "short":
+		return r.fixed(2)
+	case "int":
+		return r.int()
+	case "long":
+		return r.long()
+	case "float":
+		if b := r.bytes(4); b != nil {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		}
+		return 0.0
+	case "double":
+		if b := r.bytes(8); b != nil {
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		return 0.0
+	case "java.lang.String":
+		return r.string(c.stringType)
+	}
+	return c.readObject(class, depth)
+}
+
+func (c *jfrChunk) readObject(class *jfrClass, depth int) interface{} {
+	r := c.r
+	if depth > jfrMaxDepth {
+		r.fail()
+		return nil
+	}
+	obj := make(jfrObject, len(class.fields))
+	for _, f := range class.fields {
+		read := func() interface{} {
+			if f.cp {
+				return jfrRef{f.typeID, r.long()}
+			}
+			return c.readValue(f.typeID, depth+1)
+		}
+		if f.array {
+			vs := make([]interface{}, r.count())
+			for i := range vs {
+				vs[i] = read()
+			}
+			obj[f.name] = vs
+		} else {
+			obj[f.name] = read()
+		}
+		if r.err != nil {
+			return nil
+		}
+	}
+	return obj
+}
+
+// resolve returns the value referenced by v if it is a constant pool
+// reference, or v otherwise.
+func (c *jfrChunk) resolve(v interface{}) interface{} {
+	for depth := 0; depth < jfrMaxDepth; depth++ {
+		ref, ok := v.(jfrRef)
+		if !ok {
+			return v
+		}
+		v = c.pools[ref.typeID][ref.index]
+	}
+	return nil
+}
+
+func (c *jfrChunk) object(v interface{}) jfrObject {
+	obj, _ := c.resolve(v).(jfrObject)
+	return obj
+}
+
+func (c *jfrChunk) str(v interface{}) string {
+	s, _ := c.resolve(v).(string)
+	return s
+}
+
+func (c *jfrChunk) num(v interface{}) int64 {
+	n, _ := c.resolve(v).(int64)
+	return n
+}
+
+// symbol returns the string of a jdk.types.Symbol.
+func (c *jfrChunk) symbol(v interface{}) string {
+	return c.str(c.object(v)["string"])
+}
+
+// className returns the name of a java.lang.Class, in Java notation.
+func (c *jfrChunk) className(v interface{}) string {
+	return strings.Replace(c.symbol(c.object(v)["name"]), "/", ".", -1)
+}
+
+// nanos converts a chunk timestamp in ticks to nanoseconds since the epoch.
+func (c *jfrChunk) nanos(ticks int64) int64 {
+	if c.ticksPerSecond <= 0 {
+		return c.startNanos
+	}
+	return c.startNanos + int64(float64(ticks-c.startTicks)*1e9/float64(c.ticksPerSecond))
+}
+
+// jfrBuilder accumulates the samples of the chunks of a recording.
+type jfrBuilder struct {
+	p          *Profile
+	functions  map[string]*Function
+	locations  map[string]*Location
+	execution  []*Sample
+	allocation []*Sample
+	sampled    []*Sample // From jdk.ObjectAllocationSample events.
+}
+
+func newJFRBuilder() *jfrBuilder {
+	return &jfrBuilder{
+		p:         &Profile{},
+		functions: make(map[string]*Function),
+		locations: make(map[string]*Location),
+	}
+}
+
+func (jb *jfrBuilder) addChunk(c *jfrChunk) {
+	if jb.p.TimeNanos == 0 || c.startNanos < jb.p.TimeNanos {
+		jb.p.TimeNanos = c.startNanos
+	}
+	jb.p.DurationNanos += c.durationNanos
+
+	stacks := make(map[interface{}][]*Location)
+	for _, e := range c.events {
+		ref := e.fields["stackTrace"]
+		locs, ok := stacks[ref]
+		if !ok {
+			locs = jb.stack(c, c.object(ref))
+			stacks[ref] = locs
+		}
+		thread := e.fields["sampledThread"]
+		if thread == nil {
+			thread = e.fields["eventThread"]
+		}
+		s := &Sample{
+			Location: locs,
+			Label:    make(map[string][]string),
+			NumLabel: map[string][]int64{"timestamp": {c.nanos(c.num(e.fields["startTime"]))}},
+			NumUnit:  map[string][]string{"timestamp": {"nanoseconds"}},
+		}
+		if t := c.object(thread); t != nil {
+			name := c.str(t["javaName"])
+			if name == "" {
+				name = c.str(t["osName"])
+			}
+			if name != "" {
+				s.Label["thread"] = []string{name}
+			}
+		}
+		if st := c.object(e.fields["state"]); st != nil {
+			if state := c.str(st["name"]); state != "" {
+				s.Label["thread_state"] = []string{threadState(state)}
+			}
+		}
+		if e.class != jfrExecutionSample {
+			if class := c.className(e.fields["objectClass"]); class != "" {
+				s.Label["allocation_class"] = []string{class}
+			}
+		}
+
+		switch e.class {
+		case jfrExecutionSample:
+			s.Value = []int64{1}
+			jb.execution = append(jb.execution, s)
+		case jfrAllocationInTLAB:
+			// The event is recorded when a new TLAB is allocated, and
+			// stands for all the allocations made in it.
+			s.Value = []int64{1, c.num(e.fields["tlabSize"])}
+			jb.allocation = append(jb.allocation, s)
+		case jfrAllocationOutside:
+			s.Value = []int64{1, c.num(e.fields["allocationSize"])}
+			jb.allocation = append(jb.allocation, s)
+		case jfrAllocationSample:
+			s.Value = []int64{1, c.num(e.fields["weight"])}
+			jb.sampled = append(jb.sampled, s)
+		}
+	}
+}
+
+// stack returns the locations of a jdk.types.StackTrace, from the leaf to
+// the root.
+func (jb *jfrBuilder) stack(c *jfrChunk, trace jfrObject) []*Location {
+	frames, _ := trace["frames"].([]interface{})
+	locs := make([]*Location, 0, len(frames))
+	for _, f := range frames {
+		frame := c.object(f)
+		method := c.object(frame["method"])
+		if method == nil {
+			continue
+		}
+		name := c.symbol(method["name"])
+		if class := c.className(method["type"]); class != "" {
+			name = class + "." + name
+		}
+		line := c.num(frame["lineNumber"])
+		if line < 0 {
+			line = 0
+		}
+
+		key := name + ":" + strconv.FormatInt(line, 10)
+		loc := jb.locations[key]
+		if loc == nil {
+			fn := jb.functions[name]
+			if fn == nil {
+				fn = &Function{
+					ID:         uint64(len(jb.p.Function) + 1),
+					Name:       name,
+					SystemName: name,
+				}
+				jb.functions[name] = fn
+				jb.p.Function = append(jb.p.Function, fn)
+			}
+			loc = &Location{
+				ID:   uint64(len(jb.p.Location) + 1),
+				Line: []Line{{Function: fn, Line: line}},
+			}
+			jb.locations[key] = loc
+			jb.p.Location = append(jb.p.Location, loc)
+		}
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+// profile returns the profile holding all samples. Allocation samples
+// recorded by jdk.ObjectAllocationSample events supersede those of TLAB
+// events, which would otherwise count the same allocations twice.
+func (jb *jfrBuilder) profile() *Profile {
+	p := jb.p
+	allocation := jb.allocation
+	if len(jb.sampled) > 0 {
+		allocation = jb.sampled
+	}
+
+	hasAlloc, hasExec := len(allocation) > 0, len(jb.execution) > 0
+	if hasAlloc {
+		p.SampleType = append(p.SampleType,
+			&ValueType{Type: "alloc_objects", Unit: "count"},
+			&ValueType{Type: "alloc_space", Unit: "bytes"})
+	}
+	if hasExec {
+		p.SampleType = append(p.SampleType, &ValueType{Type: "samples", Unit: "count"})
+		p.PeriodType = &ValueType{Type: "samples", Unit: "count"}
+		p.Period = 1
+	}
+	if len(p.SampleType) == 0 {
+		p.SampleType = []*ValueType{{Type: "samples", Unit: "count"}}
+	}
+	if hasExec && hasAlloc {
+		p.DefaultSampleType = "samples"
+		for _, s := range allocation {
+			s.Value = append(s.Value, 0)
+		}
+		for _, s := range jb.execution {
+			s.Value = []int64{0, 0, s.Value[0]}
+		}
+	}
+	p.Sample = append(allocation, jb.execution...)
+	return p
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// jfrWriter encodes values with compressed integers.
+type jfrWriter struct {
+	bytes.Buffer
+}
+
+func (w *jfrWriter) varint(v int64) {
+	u := uint64(v)
+	for i := 0; i < 8; i++ {
+		if u < 0x80 {
+			w.WriteByte(byte(u))
+			return
+		}
+		w.WriteByte(byte(u) | 0x80)
+		u >>= 7
+	}
+	w.WriteByte(byte(u))
+}
+
+func (w *jfrWriter) str(s string) {
+	w.WriteByte(3)
+	w.varint(int64(len(s)))
+	w.WriteString(s)
+}
+
+// event frames the payload of an event with its size and type.
+func (w *jfrWriter) event(typeID int64, payload []byte) {
+	var body jfrWriter
+	body.varint(typeID)
+	body.Write(payload)
+	// The size includes its own encoding, which takes 1 or 2 bytes here.
+	size := int64(body.Len() + 1)
+	if size >= 0x80 {
+		size++
+	}
+	w.varint(size)
+	w.Write(body.Bytes())
+}
+
+// jfrTestClass describes a class for the metadata of a test recording.
+type jfrTestClass struct {
+	id     int64
+	name   string
+	fields []string // name:typeID[:cp][:array]
+}
+
+var jfrTestClasses = []jfrTestClass{
+	{20, "long", nil},
+	{21, "int", nil},
+	{22, "boolean", nil},
+	{23, "java.lang.String", nil},
+	{30, "jdk.types.Symbol", []string{"string:23"}},
+	{31, "java.lang.Class", []string{"name:30:cp", "modifiers:21"}},
+	{32, "jdk.types.Method", []string{"type:31:cp", "name:30:cp", "descriptor:30:cp"}},
+	{33, "jdk.types.StackFrame", []string{"method:32:cp", "lineNumber:21", "bytecodeIndex:21"}},
+	{34, "jdk.types.StackTrace", []string{"truncated:22", "frames:33::array"}},
+	{35, "java.lang.Thread", []string{"osName:23", "osThreadId:20", "javaName:23", "javaThreadId:20"}},
+	{36, "jdk.types.ThreadState", []string{"name:23"}},
+	{100, "jdk.ExecutionSample", []string{"startTime:20", "sampledThread:35:cp", "stackTrace:34:cp", "state:36:cp"}},
+	{101, "jdk.ObjectAllocationSample", []string{"startTime:20", "eventThread:35:cp", "stackTrace:34:cp", "objectClass:31:cp", "weight:20"}},
+	{102, "jdk.GarbageCollection", []string{"startTime:20", "duration:20"}},
+}
+
+func jfrMetadata() []byte {
+	strs := map[string]int64{}
+	var table []string
+	idx := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		strs[s] = int64(len(table))
+		table = append(table, s)
+		return strs[s]
+	}
+	var tree jfrWriter
+	element := func(w *jfrWriter, name string, attrs [][2]string, children int) {
+		w.varint(idx(name))
+		w.varint(int64(len(attrs)))
+		for _, a := range attrs {
+			w.varint(idx(a[0]))
+			w.varint(idx(a[1]))
+		}
+		w.varint(int64(children))
+	}
+	element(&tree, "root", nil, 1)
+	element(&tree, "metadata", nil, len(jfrTestClasses))
+	for _, c := range jfrTestClasses {
+		element(&tree, "class", [][2]string{{"id", strconv.FormatInt(c.id, 10)}, {"name", c.name}}, len(c.fields))
+		for _, f := range c.fields {
+			parts := strings.Split(f, ":")
+			attrs := [][2]string{{"name", parts[0]}, {"class", parts[1]}}
+			if len(parts) > 2 && parts[2] == "cp" {
+				attrs = append(attrs, [2]string{"constantPool", "true"})
+			}
+			if len(parts) > 3 && parts[3] == "array" {
+				attrs = append(attrs, [2]string{"dimension", "1"})
+			}
+			element(&tree, "field", attrs, 0)
+		}
+	}
+
+	var w jfrWriter
+	w.varint(0) // start time
+	w.varint(0) // duration
+	w.varint(1) // metadata id
+	w.varint(int64(len(table)))
+	for _, s := range table {
+		w.str(s)
+	}
+	w.Write(tree.Bytes())
+	return w.Bytes()
+}
+
+func jfrConstantPools() []byte {
+	var w jfrWriter
+	w.varint(0)    // start time
+	w.varint(0)    // duration
+	w.varint(0)    // delta
+	w.WriteByte(1) // flush
+	w.varint(7)    // pools
+	w.varint(30)   // jdk.types.Symbol
+	w.varint(4)
+	for i, s := range []string{"com/example/Main", "main", "work", "java/lang/String"} {
+		w.varint(int64(i + 1))
+		w.str(s)
+	}
+	w.varint(31) // java.lang.Class
+	w.varint(2)
+	w.varint(1)
+	w.varint(1) // name
+	w.varint(1) // modifiers
+	w.varint(2)
+	w.varint(4)
+	w.varint(1)
+	w.varint(32) // jdk.types.Method
+	w.varint(2)
+	for i, name := range []int64{2, 3} {
+		w.varint(int64(i + 1))
+		w.varint(1)    // type
+		w.varint(name) // name
+		w.varint(0)    // descriptor
+	}
+	w.varint(34) // jdk.types.StackTrace
+	w.varint(1)
+	w.varint(7)
+	w.WriteByte(0) // truncated
+	w.varint(2)    // frames
+	w.varint(2)    // work
+	w.varint(12)
+	w.varint(0)
+	w.varint(1) // main
+	w.varint(5)
+	w.varint(0)
+	w.varint(35) // java.lang.Thread
+	w.varint(1)
+	w.varint(9)
+	w.str("os-thread")
+	w.varint(100)
+	w.str("worker-1")
+	w.varint(1)
+	w.varint(36) // jdk.types.ThreadState
+	w.varint(1)
+	w.varint(1)
+	w.str("STATE_RUNNABLE")
+	w.varint(23) // java.lang.String, unused
+	w.varint(0)
+	return w.Bytes()
+}
+
+// jfrTestChunk returns a chunk holding two execution samples, one
+// allocation sample and one ignored event, recorded at 1000 ticks per
+// second.
+func jfrTestChunk() []byte {
+	var events jfrWriter
+	for _, ticks := range []int64{1100, 1200} {
+		var e jfrWriter
+		e.varint(ticks)
+		e.varint(9) // thread
+		e.varint(7) // stack trace
+		e.varint(1) // state
+		events.event(100, e.Bytes())
+	}
+	var e jfrWriter
+	e.varint(1300)
+	e.varint(9)
+	e.varint(7)
+	e.varint(2) // java.lang.String
+	e.varint(4096)
+	events.event(101, e.Bytes())
+	e.Reset()
+	e.varint(1400)
+	e.varint(50)
+	events.event(102, e.Bytes())
+	events.event(1, jfrConstantPools())
+	metadataOffset := jfrHeaderSize + events.Len()
+	events.event(0, jfrMetadata())
+
+	header := make([]byte, jfrHeaderSize)
+	copy(header, jfrMagic)
+	binary.BigEndian.PutUint16(header[4:], 2)
+	binary.BigEndian.PutUint64(header[8:], uint64(jfrHeaderSize+events.Len()))
+	binary.BigEndian.PutUint64(header[16:], uint64(metadataOffset)) // constant pool
+	binary.BigEndian.PutUint64(header[24:], uint64(metadataOffset))
+	binary.BigEndian.PutUint64(header[32:], 5e9)  // start nanos
+	binary.BigEndian.PutUint64(header[40:], 1e9)  // duration nanos
+	binary.BigEndian.PutUint64(header[48:], 1000) // start ticks
+	binary.BigEndian.PutUint64(header[56:], 1000) // ticks per second
+	binary.BigEndian.PutUint32(header[64:], jfrCompressedInts)
+	return append(header, events.Bytes()...)
+}
+
+func TestParseJFR(t *testing.T) {
+	chunk := jfrTestChunk()
+	// A recording may hold multiple chunks.
+	p, err := Parse(bytes.NewReader(append(chunk, chunk...)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if want := []string{"alloc_objects/count", "alloc_space/bytes", "samples/count"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got sample types %v, want %v", types, want)
+	}
+	if p.DefaultSampleType != "samples" {
+		t.Errorf("got default sample type %q, want samples", p.DefaultSampleType)
+	}
+	if p.TimeNanos != 5e9 || p.DurationNanos != 2e9 {
+		t.Errorf("got time %d and duration %d, want 5e9 and 2e9", p.TimeNanos, p.DurationNanos)
+	}
+
+	var got []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			frames = append(frames, fmt.Sprintf("%s:%d", l.Line[0].Function.Name, l.Line[0].Line))
+		}
+		got = append(got, fmt.Sprintf("%v %v %s thread=%v state=%v class=%v ts=%v",
+			s.Value, frames[0], frames[1], s.Label["thread"], s.Label["thread_state"], s.Label["allocation_class"], s.NumLabel["timestamp"]))
+	}
+	want := []string{
+		"[1 4096 0] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[] class=[java.lang.String] ts=[5300000000]",
+		"[1 4096 0] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[] class=[java.lang.String] ts=[5300000000]",
+		"[0 0 1] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[runnable] class=[] ts=[5100000000]",
+		"[0 0 1] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[runnable] class=[] ts=[5200000000]",
+		"[0 0 1] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[runnable] class=[] ts=[5100000000]",
+		"[0 0 1] com.example.Main.work:12 com.example.Main.main:5 thread=[worker-1] state=[runnable] class=[] ts=[5200000000]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(p.Location) != 2 || len(p.Function) != 2 {
+		t.Errorf("got %d locations and %d functions, want 2 and 2", len(p.Location), len(p.Function))
+	}
+}
+
+func TestParseJFRMalformed(t *testing.T) {
+	chunk := jfrTestChunk()
+	for _, tc := range []struct {
+		desc string
+		data []byte
+	}{
+		{"truncated header", chunk[:40]},
+		{"truncated chunk", chunk[:len(chunk)-10]},
+		{"unsupported version", append(append([]byte{}, chunk[:5]...), append([]byte{1}, chunk[6:]...)...)},
+	} {
+		if _, err := parseJFR(tc.data); err == nil || err == errUnrecognized {
+			t.Errorf("%s: got error %v, want a parsing error", tc.desc, err)
+		}
+	}
+}
@@ -1166,6 +1166,11 @@ var allocRxStr = strings.Join([]string{
 	`malloc_zone_memalign`,
 	`malloc_zone_free`,
 
+	// jemalloc, with the prefix of the Rust jemallocator crate.
+	`(_rjem_)?je_.*`,
+	`(_rjem_)?[mr]allocx`,
+	`(_rjem_)?sdallocx`,
+
 	// Go runtime
 	`runtime\..*`,
 
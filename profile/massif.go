@@ -0,0 +1,281 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the output of Valgrind's
+// massif heap profiler into the profile.proto format.
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// massifNode matches a node of a massif heap tree, eg
+	// " n2: 1000 0x4005C4: g (a.c:5)".
+	massifNode = regexp.MustCompile(`^( *)n(\d+): (\d+) (.*)$`)
+	// massifFrame matches the code location of a node, eg
+	// "0x4005C4: g (a.c:5)" or "0x4E9A1A4: f (in /lib/libc.so.6)".
+	massifFrame = regexp.MustCompile(`^(0x[0-9a-fA-F]+): (.*)$`)
+	// massifSource matches the source or object file at the end of the
+	// function of a node, eg "g (a.c:5)" or "f (in /lib/libc.so.6)".
+	massifSource = regexp.MustCompile(`^(.*) \((?:in ([^()]+)|([^()]+):(\d+))\)$`)
+)
+
+// massifTimeUnits maps the massif time units to the units of the time
+// numeric label.
+var massifTimeUnits = map[string]string{
+	"ms": "ms",
+	"B":  "bytes",
+	"i":  "instructions",
+}
+
+// massifBelowThreshold is the name of the frame for the allocations that
+// massif aggregates because they are below its reporting threshold.
+const massifBelowThreshold = "(below massif threshold)"
+
+// parseMassif parses the output of massif, as written to massif.out.<pid>
+// files. The heap trees of the detailed snapshots are converted to
+// samples with the bytes allocated by each stack: the samples of the
+// peak snapshot have a peak_space value, which is the default, and the
+// samples of every detailed snapshot have a snapshot_space value, and a
+// snapshot numeric label to select them.
+func parseMassif(b []byte) (*Profile, error) {
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	s.Buffer(nil, len(b)+1)
+	if !s.Scan() || !strings.HasPrefix(s.Text(), "desc:") {
+		return nil, errUnrecognized
+	}
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "space", Unit: "bytes"},
+		SampleType: []*ValueType{
+			{Type: "peak_space", Unit: "bytes"},
+			{Type: "snapshot_space", Unit: "bytes"},
+		},
+		DefaultSampleType: "peak_space",
+	}
+	mp := &massifParser{
+		p:         p,
+		locations: make(map[string]*Location),
+		functions: make(map[string]*Function),
+		mappings:  make(map[string]*Mapping),
+	}
+	var snapshot, time int64
+	var timeUnit string
+	for lineno := 2; s.Scan(); lineno++ {
+		line := s.Text()
+		key, value := line, ""
+		if i := strings.IndexAny(line, ":="); i != -1 {
+			key, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		var err error
+		switch key {
+		case "cmd":
+			p.Comments = append(p.Comments, "massif: "+value)
+		case "time_unit":
+			timeUnit = massifTimeUnits[value]
+		case "snapshot":
+			snapshot, err = strconv.ParseInt(value, 10, 64)
+		case "time":
+			time, err = strconv.ParseInt(value, 10, 64)
+		case "heap_tree":
+			if value == "detailed" || value == "peak" {
+				snap := &massifSnapshot{snapshot, time, timeUnit, value == "peak"}
+				lineno, err = mp.parseTree(s, lineno, snap)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("massif line %d: %v", lineno, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("massif output has no detailed snapshots")
+	}
+	return p, nil
+}
+
+// massifParser holds the state of the massif parser.
+type massifParser struct {
+	p         *Profile
+	locations map[string]*Location
+	functions map[string]*Function
+	mappings  map[string]*Mapping
+}
+
+// massifSnapshot describes a snapshot with a heap tree.
+type massifSnapshot struct {
+	index, time int64
+	timeUnit    string
+	peak        bool
+}
+
+// massifTreeNode is a node of a heap tree being parsed.
+type massifTreeNode struct {
+	loc        *Location
+	bytes      int64
+	childBytes int64
+	children   int
+}
+
+// parseTree parses the heap tree of snap following its heap_tree line,
+// numbered lineno, adding a sample for the bytes allocated directly by
+// each of its nodes. It returns the number of the last line of the tree.
+func (mp *massifParser) parseTree(s *bufio.Scanner, lineno int, snap *massifSnapshot) (int, error) {
+	var stack []*massifTreeNode
+	for {
+		if !s.Scan() {
+			return lineno, fmt.Errorf("truncated heap tree")
+		}
+		lineno++
+		m := massifNode.FindStringSubmatch(s.Text())
+		if m == nil {
+			return lineno, fmt.Errorf("malformed heap tree node %q", s.Text())
+		}
+		if len(m[1]) != len(stack) {
+			return lineno, fmt.Errorf("heap tree node at unexpected depth %d", len(m[1]))
+		}
+		children, err := strconv.Atoi(m[2])
+		if err != nil {
+			return lineno, err
+		}
+		n := &massifTreeNode{children: children}
+		if n.bytes, err = strconv.ParseInt(m[3], 10, 64); err != nil {
+			return lineno, err
+		}
+		if len(stack) > 0 {
+			// The root describes the allocation functions, which are not a
+			// frame of any stack.
+			if n.loc, err = mp.location(m[4]); err != nil {
+				return lineno, err
+			}
+			parent := stack[len(stack)-1]
+			parent.children--
+			parent.childBytes += n.bytes
+		}
+		stack = append(stack, n)
+
+		// Pop the nodes without pending children, adding a sample for
+		// the bytes they allocate themselves.
+		for len(stack) > 0 && stack[len(stack)-1].children == 0 {
+			top := stack[len(stack)-1]
+			if self := top.bytes - top.childBytes; self > 0 && len(stack) > 1 {
+				mp.addSample(stack, self, snap)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			return lineno, nil
+		}
+	}
+}
+
+// addSample adds a sample for the given bytes of snap, allocated by the
+// stack of the tree nodes of stack, starting from the root.
+func (mp *massifParser) addSample(stack []*massifTreeNode, bytes int64, snap *massifSnapshot) {
+	s := &Sample{
+		Value:    []int64{0, bytes},
+		NumLabel: map[string][]int64{"snapshot": {snap.index}, "time": {snap.time}},
+	}
+	if snap.timeUnit != "" {
+		s.NumUnit = map[string][]string{"time": {snap.timeUnit}}
+	}
+	if snap.peak {
+		s.Value[0] = bytes
+	}
+	// The children of a node are its callers, so the stack starts with
+	// the allocation site below the root.
+	for _, n := range stack[1:] {
+		s.Location = append(s.Location, n.loc)
+	}
+	mp.p.Sample = append(mp.p.Sample, s)
+}
+
+// location returns the location for the description of a heap tree node.
+func (mp *massifParser) location(desc string) (*Location, error) {
+	if strings.HasPrefix(desc, "in ") && strings.Contains(desc, "below massif's threshold") {
+		desc = massifBelowThreshold
+	}
+	if l := mp.locations[desc]; l != nil {
+		return l, nil
+	}
+
+	p := mp.p
+	l := &Location{ID: uint64(len(p.Location) + 1)}
+	name, file := desc, ""
+	var line int64
+	if m := massifFrame.FindStringSubmatch(desc); m != nil {
+		addr, err := strconv.ParseUint(m[1], 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		l.Address = addr
+		name = m[2]
+		if m := massifSource.FindStringSubmatch(name); m != nil {
+			name = m[1]
+			if obj := m[2]; obj != "" {
+				l.Mapping = mp.mapping(obj)
+			} else {
+				file = m[3]
+				if line, err = strconv.ParseInt(m[4], 10, 64); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else if desc != massifBelowThreshold {
+		return nil, fmt.Errorf("malformed heap tree node location %q", desc)
+	}
+
+	fk := name + "\x00" + file
+	f := mp.functions[fk]
+	if f == nil {
+		f = &Function{
+			ID:         uint64(len(p.Function) + 1),
+			Name:       name,
+			SystemName: name,
+			Filename:   file,
+		}
+		mp.functions[fk] = f
+		p.Function = append(p.Function, f)
+	}
+	l.Line = []Line{{Function: f, Line: line}}
+	mp.locations[desc] = l
+	p.Location = append(p.Location, l)
+	return l, nil
+}
+
+// mapping returns the mapping for the object file obj. Massif does not
+// record the address ranges of objects, and resolves the functions
+// itself.
+func (mp *massifParser) mapping(obj string) *Mapping {
+	m := mp.mappings[obj]
+	if m == nil {
+		m = &Mapping{
+			ID:           uint64(len(mp.p.Mapping) + 1),
+			File:         obj,
+			HasFunctions: true,
+		}
+		mp.mappings[obj] = m
+		mp.p.Mapping = append(mp.p.Mapping, m)
+	}
+	return m
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMassifMalformed(t *testing.T) {
+	const header = "desc: (none)\ncmd: ./prog\ntime_unit: ms\n#-----------\nsnapshot=0\n#-----------\ntime=0\n"
+	for _, tc := range []struct {
+		desc, data, want string
+	}{
+		{"no detailed snapshots", header + "heap_tree=empty\n", "no detailed snapshots"},
+		{"truncated tree", header + "heap_tree=peak\nn1: 10 (heap allocation functions)\n", "massif line 9: truncated heap tree"},
+		{"bad depth", header + "heap_tree=peak\nn1: 10 (heap allocation functions)\n  n0: 10 0x1: f (a.c:1)\n", "massif line 10: heap tree node at unexpected depth 2"},
+		{"bad node", header + "heap_tree=detailed\nn1 10\n", `massif line 9: malformed heap tree node "n1 10"`},
+		{"bad location", header + "heap_tree=detailed\nn1: 10 (heap allocation functions)\n n0: 10 main\n", `massif line 10: malformed heap tree node location "main"`},
+	} {
+		_, err := parseMassif([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %s", tc.desc, err, tc.want)
+		}
+	}
+	if _, err := parseMassif([]byte("cmd: ./prog\n")); err != errUnrecognized {
+		t.Errorf("got error %v for a file without massif header, want errUnrecognized", err)
+	}
+}
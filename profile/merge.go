@@ -57,6 +57,21 @@ func Merge(srcs []*Profile) (*Profile, error) {
 	}
 
 	for _, src := range srcs {
+		// Fail before adding the elements of src if they may not fit.
+		for _, c := range []struct {
+			kind     string
+			count, n int
+		}{
+			{"samples", len(p.Sample), len(src.Sample)},
+			{"locations", len(p.Location), len(src.Location)},
+			{"functions", len(p.Function), len(src.Function)},
+			{"mappings", len(p.Mapping), len(src.Mapping)},
+		} {
+			if err := checkElements(c.kind, c.count, c.n); err != nil {
+				return nil, err
+			}
+		}
+
 		// Clear the profile-specific hash tables
 		pm.locationsByID = make(map[uint64]*Location, len(src.Location))
 		pm.functionsByID = make(map[uint64]*Function, len(src.Function))
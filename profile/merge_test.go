@@ -15,6 +15,9 @@
 package profile
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -165,3 +168,47 @@ func TestMapMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeTooLarge(t *testing.T) {
+	defer func(max int) { maxElements = max }(maxElements)
+	maxElements = 3
+
+	newProfile := func() *Profile {
+		f := &Function{ID: 1, Name: "main"}
+		l := &Location{ID: 1, Line: []Line{{Function: f}}}
+		p := &Profile{
+			PeriodType: &ValueType{Type: "samples", Unit: "count"},
+			SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+			Location:   []*Location{l},
+			Function:   []*Function{f},
+		}
+		for i := 0; i < 2; i++ {
+			p.Sample = append(p.Sample, &Sample{
+				Location: []*Location{l},
+				Value:    []int64{1},
+				Label:    map[string][]string{"i": {string(rune('a' + i))}},
+			})
+		}
+		return p
+	}
+	if _, err := Merge([]*Profile{newProfile()}); err != nil {
+		t.Fatalf("Merge of 2 samples: %v", err)
+	}
+	if _, err := Merge([]*Profile{newProfile(), newProfile()}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Merge of 4 samples: got error %v, want ErrTooLarge", err)
+	}
+
+	// Decoding fails the same way, here for samples and not strings.
+	maxElements = 10
+	p := newProfile()
+	for len(p.Sample) <= maxElements {
+		p.Sample = append(p.Sample, p.Sample[0])
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseUncompressed(buf.Bytes()); !errors.Is(err, ErrTooLarge) || !strings.Contains(err.Error(), "samples") {
+		t.Errorf("ParseUncompressed of %d samples: got error %v, want ErrTooLarge for samples", len(p.Sample), err)
+	}
+}
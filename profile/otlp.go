@@ -0,0 +1,539 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the conversion between profiles and the
+// OpenTelemetry profiles signal, as sent by OTLP exporters in an
+// ExportProfilesServiceRequest. The signal wraps profiles in resource
+// and instrumentation scope envelopes; the profiles themselves use the
+// profile.proto encoding.
+
+package profile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// otlpRequest is an ExportProfilesServiceRequest.
+type otlpRequest struct {
+	resourceProfiles []*otlpResourceProfiles
+}
+
+// otlpResourceProfiles holds the profiles of a resource.
+type otlpResourceProfiles struct {
+	resource      *otlpResource
+	scopeProfiles []*otlpScopeProfiles
+	schemaURL     string
+}
+
+// otlpResource describes the entity producing profiles, eg a process or
+// a container.
+type otlpResource struct {
+	attributes []*otlpKeyValue
+}
+
+// otlpScopeProfiles holds the profiles produced by an instrumentation
+// scope, ie by a profiler.
+type otlpScopeProfiles struct {
+	scope     *otlpScope
+	profiles  []*otlpProfileContainer
+	schemaURL string
+}
+
+// otlpScope is an InstrumentationScope.
+type otlpScope struct {
+	name, version string
+}
+
+// otlpProfileContainer holds a profile and its metadata.
+type otlpProfileContainer struct {
+	profileID               string
+	startTime, endTime      uint64
+	attributes              []*otlpKeyValue
+	originalPayloadFormat   string
+	originalPayload         string
+	profile                 string // Encoded as profile.proto.
+	hasOriginal, hasProfile bool
+}
+
+// otlpKeyValue is an attribute. Only scalar values are supported; arrays
+// and key-value lists are ignored.
+type otlpKeyValue struct {
+	key   string
+	value otlpValue
+}
+
+// otlpValue is an AnyValue.
+type otlpValue struct {
+	kind int // One of the otlpValue* constants.
+	str  string
+	i    int64
+	d    float64
+	b    bool
+}
+
+// The kinds of otlpValue, numbered after their field in AnyValue.
+const (
+	otlpValueNone = iota
+	otlpValueString
+	otlpValueBool
+	otlpValueInt
+	otlpValueDouble
+)
+
+func (p *otlpRequest) decoder() []decoder {
+	return otlpRequestDecoder
+}
+
+func (p *otlpRequest) encode(b *buffer) {
+	for _, x := range p.resourceProfiles {
+		encodeMessage(b, 1, x)
+	}
+}
+
+var otlpRequestDecoder = []decoder{
+	nil, // 0
+	// repeated ResourceProfiles resource_profiles = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResourceProfiles)
+		pp := m.(*otlpRequest)
+		pp.resourceProfiles = append(pp.resourceProfiles, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (p *otlpResourceProfiles) decoder() []decoder {
+	return otlpResourceProfilesDecoder
+}
+
+func (p *otlpResourceProfiles) encode(b *buffer) {
+	if p.resource != nil {
+		encodeMessage(b, 1, p.resource)
+	}
+	for _, x := range p.scopeProfiles {
+		encodeMessage(b, 2, x)
+	}
+	if p.schemaURL != "" {
+		encodeString(b, 3, p.schemaURL)
+	}
+}
+
+var otlpResourceProfilesDecoder = []decoder{
+	nil, // 0
+	// Resource resource = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResource)
+		m.(*otlpResourceProfiles).resource = x
+		return decodeMessage(b, x)
+	},
+	// repeated ScopeProfiles scope_profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpScopeProfiles)
+		pp := m.(*otlpResourceProfiles)
+		pp.scopeProfiles = append(pp.scopeProfiles, x)
+		return decodeMessage(b, x)
+	},
+	// string schema_url = 3
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpResourceProfiles).schemaURL) },
+}
+
+func (p *otlpResource) decoder() []decoder {
+	return otlpResourceDecoder
+}
+
+func (p *otlpResource) encode(b *buffer) {
+	for _, x := range p.attributes {
+		encodeMessage(b, 1, x)
+	}
+}
+
+var otlpResourceDecoder = []decoder{
+	nil, // 0
+	// repeated KeyValue attributes = 1
+	func(b *buffer, m message) error {
+		x := new(otlpKeyValue)
+		pp := m.(*otlpResource)
+		pp.attributes = append(pp.attributes, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (p *otlpScopeProfiles) decoder() []decoder {
+	return otlpScopeProfilesDecoder
+}
+
+func (p *otlpScopeProfiles) encode(b *buffer) {
+	if p.scope != nil {
+		encodeMessage(b, 1, p.scope)
+	}
+	for _, x := range p.profiles {
+		encodeMessage(b, 2, x)
+	}
+	if p.schemaURL != "" {
+		encodeString(b, 3, p.schemaURL)
+	}
+}
+
+var otlpScopeProfilesDecoder = []decoder{
+	nil, // 0
+	// InstrumentationScope scope = 1
+	func(b *buffer, m message) error {
+		x := new(otlpScope)
+		m.(*otlpScopeProfiles).scope = x
+		return decodeMessage(b, x)
+	},
+	// repeated ProfileContainer profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpProfileContainer)
+		pp := m.(*otlpScopeProfiles)
+		pp.profiles = append(pp.profiles, x)
+		return decodeMessage(b, x)
+	},
+	// string schema_url = 3
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScopeProfiles).schemaURL) },
+}
+
+func (p *otlpScope) decoder() []decoder {
+	return otlpScopeDecoder
+}
+
+func (p *otlpScope) encode(b *buffer) {
+	if p.name != "" {
+		encodeString(b, 1, p.name)
+	}
+	if p.version != "" {
+		encodeString(b, 2, p.version)
+	}
+}
+
+var otlpScopeDecoder = []decoder{
+	nil, // 0
+	// string name = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScope).name) },
+	// string version = 2
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpScope).version) },
+}
+
+func (p *otlpProfileContainer) decoder() []decoder {
+	return otlpProfileContainerDecoder
+}
+
+func (p *otlpProfileContainer) encode(b *buffer) {
+	if p.profileID != "" {
+		encodeString(b, 1, p.profileID)
+	}
+	encodeFixed64Opt(b, 2, p.startTime)
+	encodeFixed64Opt(b, 3, p.endTime)
+	for _, x := range p.attributes {
+		encodeMessage(b, 4, x)
+	}
+	if p.hasOriginal {
+		encodeString(b, 6, p.originalPayloadFormat)
+		encodeString(b, 7, p.originalPayload)
+	}
+	if p.hasProfile {
+		encodeString(b, 8, p.profile)
+	}
+}
+
+var otlpProfileContainerDecoder = []decoder{
+	nil, // 0
+	// bytes profile_id = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpProfileContainer).profileID) },
+	// fixed64 start_time_unix_nano = 2
+	func(b *buffer, m message) error { return decodeFixed64(b, &m.(*otlpProfileContainer).startTime) },
+	// fixed64 end_time_unix_nano = 3
+	func(b *buffer, m message) error { return decodeFixed64(b, &m.(*otlpProfileContainer).endTime) },
+	// repeated KeyValue attributes = 4
+	func(b *buffer, m message) error {
+		x := new(otlpKeyValue)
+		pp := m.(*otlpProfileContainer)
+		pp.attributes = append(pp.attributes, x)
+		return decodeMessage(b, x)
+	},
+	// uint32 dropped_attributes_count = 5
+	nil,
+	// string original_payload_format = 6
+	func(b *buffer, m message) error {
+		pp := m.(*otlpProfileContainer)
+		pp.hasOriginal = true
+		return decodeString(b, &pp.originalPayloadFormat)
+	},
+	// bytes original_payload = 7
+	func(b *buffer, m message) error {
+		pp := m.(*otlpProfileContainer)
+		pp.hasOriginal = true
+		return decodeString(b, &pp.originalPayload)
+	},
+	// Profile profile = 8
+	func(b *buffer, m message) error {
+		pp := m.(*otlpProfileContainer)
+		pp.hasProfile = true
+		return decodeString(b, &pp.profile)
+	},
+}
+
+func (p *otlpKeyValue) decoder() []decoder {
+	return otlpKeyValueDecoder
+}
+
+func (p *otlpKeyValue) encode(b *buffer) {
+	encodeString(b, 1, p.key)
+	encodeMessage(b, 2, &p.value)
+}
+
+var otlpKeyValueDecoder = []decoder{
+	nil, // 0
+	// string key = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpKeyValue).key) },
+	// AnyValue value = 2
+	func(b *buffer, m message) error { return decodeMessage(b, &m.(*otlpKeyValue).value) },
+}
+
+func (p *otlpValue) decoder() []decoder {
+	return otlpValueDecoder
+}
+
+func (p *otlpValue) encode(b *buffer) {
+	switch p.kind {
+	case otlpValueString:
+		encodeString(b, 1, p.str)
+	case otlpValueBool:
+		encodeBool(b, 2, p.b)
+	case otlpValueInt:
+		encodeInt64(b, 3, p.i)
+	case otlpValueDouble:
+		encodeFixed64(b, 4, math.Float64bits(p.d))
+	}
+}
+
+var otlpValueDecoder = []decoder{
+	nil, // 0
+	// string string_value = 1
+	func(b *buffer, m message) error {
+		pp := m.(*otlpValue)
+		pp.kind = otlpValueString
+		return decodeString(b, &pp.str)
+	},
+	// bool bool_value = 2
+	func(b *buffer, m message) error {
+		pp := m.(*otlpValue)
+		pp.kind = otlpValueBool
+		return decodeBool(b, &pp.b)
+	},
+	// int64 int_value = 3
+	func(b *buffer, m message) error {
+		pp := m.(*otlpValue)
+		pp.kind = otlpValueInt
+		return decodeInt64(b, &pp.i)
+	},
+	// double double_value = 4
+	func(b *buffer, m message) error {
+		pp := m.(*otlpValue)
+		var u uint64
+		if err := decodeFixed64(b, &u); err != nil {
+			return err
+		}
+		pp.kind, pp.d = otlpValueDouble, math.Float64frombits(u)
+		return nil
+	},
+}
+
+// otlpPprofFormat is the original payload format of profiles in the
+// profile.proto format.
+const otlpPprofFormat = "pprof"
+
+// parseOTLP parses an ExportProfilesServiceRequest. The profiles it holds
+// are merged into a single profile. The attributes of their resources
+// and containers are added as labels to all of their samples, and their
+// instrumentation scopes are recorded as comments.
+func parseOTLP(b []byte) (*Profile, error) {
+	var req otlpRequest
+	if err := unmarshal(b, &req); err != nil {
+		return nil, errUnrecognized
+	}
+	var profiles []*Profile
+	for _, rp := range req.resourceProfiles {
+		for _, sp := range rp.scopeProfiles {
+			for _, c := range sp.profiles {
+				p, err := c.parse()
+				if err != nil {
+					return nil, err
+				}
+				if p == nil {
+					continue
+				}
+				if sp.scope != nil && sp.scope.name != "" {
+					p.Comments = append(p.Comments, strings.TrimSpace("otel scope: "+sp.scope.name+" "+sp.scope.version))
+				}
+				var attrs []*otlpKeyValue
+				if rp.resource != nil {
+					attrs = rp.resource.attributes
+				}
+				p.addOTLPAttributes(append(attrs, c.attributes...))
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	if len(profiles) == 0 {
+		// A request without profiles is indistinguishable from most
+		// other inputs.
+		return nil, errUnrecognized
+	}
+	if len(profiles) == 1 {
+		return profiles[0], nil
+	}
+	p, err := Merge(profiles)
+	if err != nil {
+		return nil, fmt.Errorf("merging OTLP profiles: %v", err)
+	}
+	return p, nil
+}
+
+// parse parses the profile held by c, if any.
+func (c *otlpProfileContainer) parse() (*Profile, error) {
+	data := c.profile
+	if !c.hasProfile {
+		if !c.hasOriginal {
+			return nil, nil
+		}
+		if c.originalPayloadFormat != otlpPprofFormat {
+			return nil, fmt.Errorf("OTLP profile has unsupported original payload format %q", c.originalPayloadFormat)
+		}
+		data = c.originalPayload
+	}
+	p, err := ParseUncompressed([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("OTLP profile: %v", err)
+	}
+	if p.TimeNanos == 0 {
+		p.TimeNanos = int64(c.startTime)
+	}
+	if p.DurationNanos == 0 && c.endTime > c.startTime {
+		p.DurationNanos = int64(c.endTime - c.startTime)
+	}
+	return p, nil
+}
+
+// addOTLPAttributes adds the attributes as labels to all samples of p
+// not already carrying a label with the same key. Integer attributes are
+// added as numeric labels.
+func (p *Profile) addOTLPAttributes(attrs []*otlpKeyValue) {
+	for _, a := range attrs {
+		var str string
+		switch v := a.value; v.kind {
+		case otlpValueString:
+			str = v.str
+		case otlpValueBool:
+			str = strconv.FormatBool(v.b)
+		case otlpValueDouble:
+			str = strconv.FormatFloat(v.d, 'g', -1, 64)
+		case otlpValueInt:
+			for _, s := range p.Sample {
+				if _, ok := s.NumLabel[a.key]; ok {
+					continue
+				}
+				if s.NumLabel == nil {
+					s.NumLabel = make(map[string][]int64)
+				}
+				s.NumLabel[a.key] = []int64{v.i}
+			}
+			continue
+		default:
+			continue
+		}
+		for _, s := range p.Sample {
+			if _, ok := s.Label[a.key]; ok {
+				continue
+			}
+			if s.Label == nil {
+				s.Label = make(map[string][]string)
+			}
+			s.Label[a.key] = []string{str}
+		}
+	}
+}
+
+// WriteOTLP writes the profile as an uncompressed OTLP
+// ExportProfilesServiceRequest, as sent to OpenTelemetry collectors. The
+// string labels with the same value on every sample are written as
+// attributes of the resource rather than of the samples.
+func (p *Profile) WriteOTLP(w io.Writer) error {
+	if len(p.Sample) == 0 {
+		return errors.New("cannot write an empty profile as OTLP")
+	}
+	p = p.Copy()
+	res := &otlpResource{}
+	for _, k := range commonLabels(p.Sample) {
+		res.attributes = append(res.attributes, &otlpKeyValue{
+			key:   k,
+			value: otlpValue{kind: otlpValueString, str: p.Sample[0].Label[k][0]},
+		})
+		for _, s := range p.Sample {
+			delete(s.Label, k)
+		}
+	}
+
+	data := serialize(p)
+	id := sha256.Sum256(data)
+	c := &otlpProfileContainer{
+		profileID:  string(id[:16]),
+		profile:    string(data),
+		hasProfile: true,
+	}
+	if p.TimeNanos > 0 {
+		c.startTime = uint64(p.TimeNanos)
+		c.endTime = uint64(p.TimeNanos + p.DurationNanos)
+	}
+	req := &otlpRequest{
+		resourceProfiles: []*otlpResourceProfiles{{
+			resource: res,
+			scopeProfiles: []*otlpScopeProfiles{{
+				scope:    &otlpScope{name: "pprof"},
+				profiles: []*otlpProfileContainer{c},
+			}},
+		}},
+	}
+	_, err := w.Write(marshal(req))
+	return err
+}
+
+// commonLabels returns the sorted keys of the labels with a single value,
+// the same on all samples.
+func commonLabels(samples []*Sample) []string {
+	var keys []string
+	for k, v := range samples[0].Label {
+		if len(v) != 1 {
+			continue
+		}
+		common := true
+		for _, s := range samples[1:] {
+			if sv := s.Label[k]; len(sv) != 1 || sv[0] != v[0] {
+				common = false
+				break
+			}
+		}
+		if common {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
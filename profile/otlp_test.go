@@ -0,0 +1,142 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/internal/proftest"
+)
+
+func TestOTLPRoundTrip(t *testing.T) {
+	p := testProfile1.Copy()
+	for _, s := range p.Sample {
+		s.Label["service.name"] = []string{"api"}
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteOTLP(&buf); err != nil {
+		t.Fatalf("WriteOTLP: %v", err)
+	}
+
+	// The common label is written as a resource attribute.
+	var req otlpRequest
+	if err := unmarshal(buf.Bytes(), &req); err != nil {
+		t.Fatalf("decoding request: %v", err)
+	}
+	if len(req.resourceProfiles) != 1 {
+		t.Fatalf("got %d resource profiles, want 1", len(req.resourceProfiles))
+	}
+	attrs := req.resourceProfiles[0].resource.attributes
+	if len(attrs) != 1 || attrs[0].key != "service.name" || attrs[0].value.str != "api" {
+		t.Errorf("got resource attributes %+v, want service.name=api", attrs)
+	}
+
+	q, err := ParseData(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	if want := []string{"otel scope: pprof"}; !reflect.DeepEqual(q.Comments, want) {
+		t.Errorf("got comments %q, want %q", q.Comments, want)
+	}
+	q.Comments = nil
+	if got, want := q.String(), p.String(); got != want {
+		diff, err := proftest.Diff([]byte(want), []byte(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Errorf("round trip through OTLP changed the profile:\n%s", diff)
+	}
+}
+
+func TestParseOTLP(t *testing.T) {
+	payload := func(p *Profile) string {
+		return string(serialize(p.Copy()))
+	}
+	p := testProfile1.Copy()
+	p.TimeNanos, p.DurationNanos = 0, 0
+	req := &otlpRequest{
+		resourceProfiles: []*otlpResourceProfiles{{
+			resource: &otlpResource{attributes: []*otlpKeyValue{
+				{key: "service.name", value: otlpValue{kind: otlpValueString, str: "api"}},
+				{key: "process.pid", value: otlpValue{kind: otlpValueInt, i: 42}},
+				{key: "sampled", value: otlpValue{kind: otlpValueBool, b: true}},
+				{key: "ratio", value: otlpValue{kind: otlpValueDouble, d: 0.5}},
+				// Samples keep their own labels.
+				{key: "key1", value: otlpValue{kind: otlpValueString, str: "resource"}},
+			}},
+			scopeProfiles: []*otlpScopeProfiles{{
+				scope: &otlpScope{name: "profiler", version: "1.2"},
+				profiles: []*otlpProfileContainer{{
+					startTime:             5e9,
+					endTime:               15e9,
+					attributes:            []*otlpKeyValue{{key: "profile.kind", value: otlpValue{kind: otlpValueString, str: "cpu"}}},
+					originalPayloadFormat: otlpPprofFormat,
+					originalPayload:       payload(p),
+					hasOriginal:           true,
+				}},
+			}},
+		}},
+	}
+	q, err := ParseData(marshal(req))
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	if q.TimeNanos != 5e9 || q.DurationNanos != 10e9 {
+		t.Errorf("got time %d and duration %d, want 5e9 and 10e9", q.TimeNanos, q.DurationNanos)
+	}
+	if want := []string{"otel scope: profiler 1.2"}; !reflect.DeepEqual(q.Comments, want) {
+		t.Errorf("got comments %q, want %q", q.Comments, want)
+	}
+	for i, s := range q.Sample {
+		want := map[string][]string{
+			"service.name": {"api"},
+			"sampled":      {"true"},
+			"ratio":        {"0.5"},
+			"profile.kind": {"cpu"},
+		}
+		for k, v := range p.Sample[i].Label {
+			want[k] = v
+		}
+		if !reflect.DeepEqual(s.Label, want) {
+			t.Errorf("sample %d: got labels %v, want %v", i, s.Label, want)
+		}
+		if want := map[string][]int64{"process.pid": {42}}; !reflect.DeepEqual(s.NumLabel, want) {
+			t.Errorf("sample %d: got numeric labels %v, want %v", i, s.NumLabel, want)
+		}
+	}
+
+	// The profiles of several containers are merged.
+	c := req.resourceProfiles[0].scopeProfiles[0].profiles[0]
+	req.resourceProfiles[0].scopeProfiles[0].profiles = append(req.resourceProfiles[0].scopeProfiles[0].profiles, &otlpProfileContainer{
+		profile:    payload(p),
+		hasProfile: true,
+	})
+	q, err = ParseData(marshal(req))
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	if got, want := len(q.Sample), 2*len(p.Sample); got != want {
+		t.Errorf("got %d samples from two profiles, want %d", got, want)
+	}
+
+	c.originalPayloadFormat = "jfr"
+	if _, err := ParseData(marshal(req)); err == nil || !strings.Contains(err.Error(), `unsupported original payload format "jfr"`) {
+		t.Errorf("got error %v, want unsupported original payload format", err)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the samples printed by
+// "perf script" into the profile.proto format. Beyond the samples of
+// hardware and software events, it reads the memory samples synthesized
+// by perf from Arm Statistical Profiling Extension (SPE) records, or
+// from other memory sampling facilities, with their data source and
+// latency, as printed with "perf script -F +data_src,+weight". The
+// samples of several events, as recorded with "perf record -e
+// cycles,cache-misses", get a sample type per event.
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// perfScriptSample matches the first line of a sample, with the
+	// thread name, the thread ID or process/thread IDs, the optional CPU,
+	// the time stamp, the optional period and the event, eg
+	// "server  4310/4312 [003] 5230.102657:    1 l1d-miss: ...".
+	perfScriptSample = regexp.MustCompile(`^(\S.*?)\s+(\d+)(?:/(\d+))?\s+(?:\[\d+\]\s+)?\d+\.\d+:\s+(?:(\d+)\s+)?(\S+):(?:\s+(.*))?$`)
+	// perfScriptModifiers matches the modifiers of an event, eg ":u" in
+	// "cycles:u" for the cycles spent in user space.
+	perfScriptModifiers = regexp.MustCompile(`:[ukhIGHpPSDW]+$`)
+	// perfScriptFrame matches a frame, with its instruction address,
+	// symbol and the binary containing it, eg
+	// "aaaac1c40a14 scan+0x34 (/usr/local/bin/server)".
+	perfScriptFrame = regexp.MustCompile(`^([0-9a-f]+)\s+(.*?)\s*\(([^()]*)\)$`)
+	// perfScriptOffset matches the offset of a symbol, eg "+0x34".
+	perfScriptOffset = regexp.MustCompile(`\+0x[0-9a-f]+$`)
+)
+
+// perfScriptUnknown is the symbol or binary printed by perf when it is
+// not known.
+const perfScriptUnknown = "[unknown]"
+
+// parsePerfScript parses the output of perf script. Each sample becomes a
+// sample with a samples value, and a latency value if the samples have
+// a weight, with thread, pid, tid and event labels. If there are samples
+// of several events, the samples value is replaced by a value per
+// event, named after it without its modifiers, eg "cache-misses" for
+// "cache-misses:u": the period of the sample in the value of its event,
+// or 1 if the period is not printed, and 0 in the others. Memory samples
+// keep a single samples value, as perf synthesizes overlapping events,
+// eg l1d-access and l1d-miss, from the same memory records. The memory samples
+// also have a data_source label, with the level of the memory hierarchy
+// that served the access, eg "L1", "L2" or "DRAM", and a memory_op label,
+// eg "LOAD" or "STORE".
+func parsePerfScript(b []byte) (*Profile, error) {
+	s := bufio.NewScanner(bytes.NewBuffer(b))
+	s.Buffer(nil, len(b)+1)
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "samples", Unit: "count"},
+		Period:     1,
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+	}
+	pp := &perfScriptParser{
+		p:         p,
+		locations: make(map[string]*Location),
+		functions: make(map[string]*Function),
+		mappings:  make(map[string]*Mapping),
+	}
+	var latencies, periods []int64
+	var events []string
+	eventIndex := make(map[string]int)
+	var sample *Sample
+	hasLatency, hasMemory := false, false
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The thread names are right-aligned, and the frames of call
+		// chains are indented.
+		m := perfScriptSample.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil && (line[0] == ' ' || line[0] == '\t') {
+			if sample == nil {
+				return nil, errUnrecognized
+			}
+			l, err := pp.frame(strings.TrimSpace(line))
+			if err != nil {
+				return nil, fmt.Errorf("perf script line %d: %v", lineno, err)
+			}
+			sample.Location = append(sample.Location, l)
+			continue
+		}
+		if m == nil {
+			if sample == nil {
+				return nil, errUnrecognized
+			}
+			return nil, fmt.Errorf("perf script line %d: malformed sample: %s", lineno, line)
+		}
+		sample = &Sample{
+			Label:    map[string][]string{"thread": {m[1]}, "event": {m[5]}},
+			NumLabel: make(map[string][]int64),
+		}
+		// The thread ID is printed alone, or after the process ID. The
+		// IDs of the idle task are 0, which numeric labels cannot hold.
+		pid, tid := "", m[2]
+		if m[3] != "" {
+			pid, tid = m[2], m[3]
+		}
+		for _, id := range []struct{ key, value string }{{"pid", pid}, {"tid", tid}} {
+			if v, _ := strconv.ParseInt(id.value, 10, 64); v != 0 {
+				sample.NumLabel[id.key] = []int64{v}
+			}
+		}
+
+		if _, ok := eventIndex[m[5]]; !ok {
+			eventIndex[m[5]] = len(events)
+			events = append(events, m[5])
+		}
+		period := int64(1)
+		if m[4] != "" {
+			period, _ = strconv.ParseInt(m[4], 10, 64)
+		}
+		periods = append(periods, period)
+
+		fields := m[6]
+		if i := perfScriptFrameStart(fields); i != -1 {
+			// The sample is printed without call chain, with its
+			// instruction at the end of the line.
+			l, err := pp.frame(fields[i:])
+			if err != nil {
+				return nil, fmt.Errorf("perf script line %d: %v", lineno, err)
+			}
+			sample.Location = append(sample.Location, l)
+			fields = fields[:i]
+		}
+		latency, ok := perfScriptMemory(fields, sample)
+		hasLatency = hasLatency || ok
+		hasMemory = hasMemory || ok || len(sample.Label["data_source"]) > 0 || len(sample.Label["memory_op"]) > 0
+		latencies = append(latencies, latency)
+		p.Sample = append(p.Sample, sample)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Sample) == 0 {
+		return nil, errUnrecognized
+	}
+
+	splitEvents := len(events) > 1 && !hasMemory
+	if splitEvents {
+		p.SampleType = nil
+		for _, e := range perfScriptEventTypes(events) {
+			p.SampleType = append(p.SampleType, &ValueType{Type: e, Unit: "count"})
+		}
+	}
+	if hasLatency {
+		p.SampleType = append(p.SampleType, &ValueType{Type: "latency", Unit: "cycles"})
+	}
+	samples := p.Sample[:0]
+	for i, s := range p.Sample {
+		if len(s.Location) == 0 {
+			// The samples printed without instruction cannot be located.
+			continue
+		}
+		s.Value = []int64{1}
+		if splitEvents {
+			s.Value = make([]int64, len(events))
+			s.Value[eventIndex[s.Label["event"][0]]] = periods[i]
+		}
+		if hasLatency {
+			s.Value = append(s.Value, latencies[i])
+		}
+		samples = append(samples, s)
+	}
+	p.Sample = samples
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("perf script output has no samples with instructions, print them with -F +ip,+sym,+dso")
+	}
+	return p, nil
+}
+
+// perfScriptEventTypes returns the sample types of events, which are
+// their names without modifiers, unless that makes them ambiguous, as
+// for "cycles:u" and "cycles:k".
+func perfScriptEventTypes(events []string) []string {
+	types := make([]string, len(events))
+	seen := make(map[string]bool)
+	for i, e := range events {
+		types[i] = perfScriptModifiers.ReplaceAllString(e, "")
+		if seen[types[i]] {
+			return events
+		}
+		seen[types[i]] = true
+	}
+	return types
+}
+
+// perfScriptFrameStart returns the index of the frame at the end of the
+// fields of a sample printed without call chain, or -1 if there is none.
+// Symbols may hold spaces, as in "f(int, int)", so the frame starts at the
+// last address it can start with.
+func perfScriptFrameStart(fields string) int {
+	if !strings.HasSuffix(fields, ")") {
+		return -1
+	}
+	for i := len(fields) - 1; i >= 0; i-- {
+		if i > 0 && fields[i-1] != ' ' && fields[i-1] != '\t' {
+			continue
+		}
+		if perfScriptFrame.MatchString(fields[i:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// perfScriptMemory adds the data source of a memory sample to its labels,
+// from the fields of the sample as printed by perf for the data_src
+// field, eg "|OP LOAD|LVL L1 hit|SNP None|TLB L1 or L2 hit|LCK No". It
+// returns the weight printed after them, which is the latency of the
+// access in cycles, and whether the sample has a weight.
+func perfScriptMemory(fields string, s *Sample) (int64, bool) {
+	i := strings.Index(fields, "|")
+	if i == -1 {
+		return 0, false
+	}
+	parts := strings.Split(fields[i+1:], "|")
+	for _, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), " ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch value := strings.TrimSpace(kv[1]); kv[0] {
+		case "OP":
+			if value != "N/A" {
+				s.Label["memory_op"] = []string{value}
+			}
+		case "LVL":
+			if source := perfScriptDataSource(value); source != "" {
+				s.Label["data_source"] = []string{source}
+			}
+		}
+	}
+	last := strings.Fields(parts[len(parts)-1])
+	if len(last) < 3 {
+		return 0, false
+	}
+	w, err := strconv.ParseInt(last[len(last)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return w, true
+}
+
+// perfScriptDataSource returns the data source of a memory access from
+// the memory level printed by perf, eg "L1" for "L1 hit", "DRAM" for
+// "RAM hit" and "Remote DRAM (1 hop)" for "Remote RAM (1 hop) hit". It
+// returns "" if the level is not available.
+func perfScriptDataSource(level string) string {
+	level = strings.TrimSuffix(level, " hit")
+	if level == "" || level == "N/A" {
+		return ""
+	}
+	// The levels are printed twice when perf knows both their legacy and
+	// numeric encodings, eg "L1 or L1 hit".
+	if parts := strings.Split(level, " or "); len(parts) == 2 && parts[0] == parts[1] {
+		level = parts[0]
+	}
+	words := strings.Fields(level)
+	for i, w := range words {
+		if w == "RAM" {
+			words[i] = "DRAM"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// perfScriptParser holds the state of the conversion of perf script
+// output.
+type perfScriptParser struct {
+	p         *Profile
+	locations map[string]*Location
+	functions map[string]*Function
+	mappings  map[string]*Mapping
+}
+
+// frame returns the location of a frame printed by perf, with its
+// address, symbol and binary, eg "aaaac1c40a14 scan+0x34 (/bin/server)".
+func (pp *perfScriptParser) frame(frame string) (*Location, error) {
+	m := perfScriptFrame.FindStringSubmatch(frame)
+	if m == nil {
+		return nil, fmt.Errorf("malformed frame: %s", frame)
+	}
+	addr, err := strconv.ParseUint(m[1], 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", m[1])
+	}
+	name, file := perfScriptOffset.ReplaceAllString(m[2], ""), m[3]
+
+	key := fmt.Sprintf("%x %s %s", addr, name, file)
+	if l := pp.locations[key]; l != nil {
+		return l, nil
+	}
+	p := pp.p
+	l := &Location{
+		ID:      uint64(len(p.Location) + 1),
+		Address: addr,
+	}
+	pp.locations[key] = l
+	p.Location = append(p.Location, l)
+
+	if file != "" && file != perfScriptUnknown {
+		m := pp.mappings[file]
+		if m == nil {
+			m = &Mapping{
+				ID:   uint64(len(p.Mapping) + 1),
+				File: file,
+			}
+			pp.mappings[file] = m
+			p.Mapping = append(p.Mapping, m)
+		}
+		l.Mapping = m
+	}
+	if name == "" || name == perfScriptUnknown {
+		return l, nil
+	}
+	if l.Mapping != nil {
+		l.Mapping.HasFunctions = true
+	}
+	fk := file + "!" + name
+	f := pp.functions[fk]
+	if f == nil {
+		f = &Function{
+			ID:         uint64(len(p.Function) + 1),
+			Name:       name,
+			SystemName: name,
+		}
+		pp.functions[fk] = f
+		p.Function = append(p.Function, f)
+	}
+	l.Line = []Line{{Function: f}}
+	return l, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePerfScriptCallChains(t *testing.T) {
+	const data = "" +
+		"            app  1234 [000]   100.000001:     250000 cycles:u: \n" +
+		"\t    aaaa0000104c work+0xc (/usr/bin/app)\n" +
+		"\t    aaaa00001100 main+0x20 (/usr/bin/app)\n" +
+		"\n" +
+		"            app  1234 [000]   100.000002:     250000 cycles:u: \n" +
+		"\t    aaaa00001050 work+0x10 (/usr/bin/app)\n" +
+		"\t    aaaa00001100 main+0x20 (/usr/bin/app)\n" +
+		"\n"
+	p, err := parsePerfScript([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.SampleType) != 1 || p.SampleType[0].Type != "samples" {
+		t.Errorf("got sample types %v, want only samples", p.SampleType)
+	}
+	var stacks [][]string
+	for _, s := range p.Sample {
+		var stack []string
+		for _, l := range s.Location {
+			stack = append(stack, l.Line[0].Function.Name)
+		}
+		stacks = append(stacks, stack)
+		if got := s.Label["event"]; !reflect.DeepEqual(got, []string{"cycles:u"}) {
+			t.Errorf("got event %v, want cycles:u", got)
+		}
+		if got := s.NumLabel["tid"]; !reflect.DeepEqual(got, []int64{1234}) {
+			t.Errorf("got tid %v, want 1234", got)
+		}
+	}
+	if want := [][]string{{"work", "main"}, {"work", "main"}}; !reflect.DeepEqual(stacks, want) {
+		t.Errorf("got stacks %v, want %v", stacks, want)
+	}
+	if len(p.Function) != 2 || len(p.Location) != 3 {
+		t.Errorf("got %d functions and %d locations, want 2 and 3", len(p.Function), len(p.Location))
+	}
+}
+
+func TestParsePerfScriptEvents(t *testing.T) {
+	const data = "" +
+		"            app  1234 [000]   100.000001:     250000 cycles:u: \n" +
+		"\t    aaaa0000104c work+0xc (/usr/bin/app)\n" +
+		"\n" +
+		"            app  1234 [000]   100.000002:         20 cache-misses:u: \n" +
+		"\t    aaaa00001050 work+0x10 (/usr/bin/app)\n" +
+		"\n" +
+		"            app  1234 [000]   100.000003:     250000 cycles:u: \n" +
+		"\t    aaaa00001050 work+0x10 (/usr/bin/app)\n" +
+		"\n"
+	p, err := parsePerfScript([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if want := []string{"cycles/count", "cache-misses/count"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got sample types %v, want %v", types, want)
+	}
+	var values [][]int64
+	for _, s := range p.Sample {
+		values = append(values, s.Value)
+	}
+	if want := [][]int64{{250000, 0}, {0, 20}, {250000, 0}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got values %v, want %v", values, want)
+	}
+
+	// Events that only differ by their modifiers keep them.
+	if got := perfScriptEventTypes([]string{"cycles:u", "cycles:k"}); !reflect.DeepEqual(got, []string{"cycles:u", "cycles:k"}) {
+		t.Errorf("got event types %v, want cycles:u and cycles:k", got)
+	}
+}
+
+func TestPerfScriptDataSource(t *testing.T) {
+	for _, tc := range []struct {
+		level, want string
+	}{
+		{"L1 hit", "L1"},
+		{"L2 or L2 hit", "L2"},
+		{"L3 miss", "L3 miss"},
+		{"RAM hit", "DRAM"},
+		{"Remote RAM (1 hop) hit", "Remote DRAM (1 hop)"},
+		{"N/A", ""},
+	} {
+		if got := perfScriptDataSource(tc.level); got != tc.want {
+			t.Errorf("perfScriptDataSource(%q): got %q, want %q", tc.level, got, tc.want)
+		}
+	}
+}
+
+func TestParsePerfScriptMalformed(t *testing.T) {
+	const sample = "app 1234 [000] 100.000001: cycles:\n"
+	for _, tc := range []struct {
+		desc, data, want string
+	}{
+		{"bad frame", sample + "\t    main (/usr/bin/app)\n", "perf script line 2: malformed frame: main (/usr/bin/app)"},
+		{"bad sample", sample + "\t    aaaa1000 main (/usr/bin/app)\napp cycles\n", "perf script line 3: malformed sample: app cycles"},
+		{"no instructions", sample, "perf script output has no samples with instructions"},
+	} {
+		_, err := parsePerfScript([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %s", tc.desc, err, tc.want)
+		}
+	}
+	for _, data := range []string{
+		"",
+		"main;work 10\n",
+		"\t    aaaa1000 main (/usr/bin/app)\n",
+	} {
+		if _, err := parsePerfScript([]byte(data)); err != errUnrecognized {
+			t.Errorf("got error %v for %q, want errUnrecognized", err, data)
+		}
+	}
+}
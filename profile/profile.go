@@ -58,6 +58,10 @@ type Profile struct {
 	keepFramesX        int64
 	stringTable        []string
 	defaultSampleTypeX int64
+
+	// warnings counts the contents that could not be fully interpreted
+	// when parsing the profile, by kind.
+	warnings [numWarningKinds]int
 }
 
 // ValueType corresponds to Profile.ValueType
@@ -141,7 +145,7 @@ type Function struct {
 }
 
 // Parse parses a profile and checks for its validity. The input
-// may be a gzip-compressed encoded protobuf or one of many legacy
+// may be a gzip or zstd-compressed encoded protobuf or one of many legacy
 // profile formats which may be unsupported in the future.
 func Parse(r io.Reader) (*Profile, error) {
 	data, err := ioutil.ReadAll(r)
@@ -164,15 +168,23 @@ func ParseData(data []byte) (*Profile, error) {
 		if err != nil {
 			return nil, fmt.Errorf("decompressing profile: %v", err)
 		}
+	} else if isZstd(data) {
+		if data, err = zstdDecompress(data); err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
 	}
 	if p, err = ParseUncompressed(data); err != nil && err != errNoData && err != errConcatProfile {
 		p, err = parseLegacy(data)
 	}
+	if err == errUnrecognized {
+		p, err = parseForeign(data)
+	}
 
 	if err != nil {
 		return nil, fmt.Errorf("parsing profile: %v", err)
 	}
 
+	p.repair()
 	if err := p.CheckValid(); err != nil {
 		return nil, fmt.Errorf("malformed profile: %v", err)
 	}
@@ -184,10 +196,29 @@ var errMalformed = fmt.Errorf("malformed profile format")
 var errNoData = fmt.Errorf("empty input file")
 var errConcatProfile = fmt.Errorf("concatenated profiles detected")
 
+// ErrTooLarge is the error of profiles with more samples, locations,
+// functions, mappings or strings than their slices can hold, as merged
+// profiles of billions of samples on 32-bit platforms.
+var ErrTooLarge = fmt.Errorf("profile too large")
+
+// maxElements is the largest number of elements of each kind in a
+// profile, which is that of the largest slice.
+var maxElements = int(^uint(0) >> 1)
+
+// checkElements returns an error wrapping ErrTooLarge if n more elements
+// of a kind cannot be added to the count of a profile.
+func checkElements(kind string, count, n int) error {
+	if count > maxElements-n {
+		return fmt.Errorf("%w: more than %d %s", ErrTooLarge, maxElements, kind)
+	}
+	return nil
+}
+
 func parseLegacy(data []byte) (*Profile, error) {
 	parsers := []func([]byte) (*Profile, error){
 		parseCPU,
 		parseHeap,
+		parseJemallocHeap,
 		parseGoCount, // goroutine, threadcreate
 		parseThread,
 		parseContention,
@@ -207,6 +238,34 @@ func parseLegacy(data []byte) (*Profile, error) {
 	return nil, errUnrecognized
 }
 
+// parseForeign parses profiles produced by tools other than pprof. These
+// are tried after the legacy formats, and are not subject to the legacy
+// frame filtering heuristics.
+func parseForeign(data []byte) (*Profile, error) {
+	parsers := []func([]byte) (*Profile, error){
+		parseJFR,
+		parseSimpleperf,
+		parseMassif,
+		parseETW,
+		parseV8CPUProfile,
+		parseV8HeapSnapshot,
+		parsePerfScript,
+		parseFolded,
+		parseOTLP,
+	}
+
+	for _, parser := range parsers {
+		p, err := parser(data)
+		if err == nil {
+			return p, nil
+		}
+		if err != errUnrecognized {
+			return nil, err
+		}
+	}
+	return nil, errUnrecognized
+}
+
 // ParseUncompressed parses an uncompressed protobuf into a profile.
 func ParseUncompressed(data []byte) (*Profile, error) {
 	if len(data) == 0 {
@@ -428,6 +487,7 @@ func (p *Profile) Aggregate(inlineFrame, function, filename, linenumber, address
 			if !function {
 				f.Name = ""
 				f.SystemName = ""
+				f.StartLine = 0
 			}
 			if !filename {
 				f.Filename = ""
@@ -785,10 +845,11 @@ func (p *Profile) HasFileLines() bool {
 
 // Unsymbolizable returns true if a mapping points to a binary for which
 // locations can't be symbolized in principle, at least now. Examples are
-// "[vdso]", [vsyscall]" and some others, see the code.
+// "[vdso]", [vsyscall]" and some others, see the code. Mappings with an
+// empty or inverted address range are unsymbolizable as well.
 func (m *Mapping) Unsymbolizable() bool {
 	name := filepath.Base(m.File)
-	return strings.HasPrefix(name, "[") || strings.HasPrefix(name, "linux-vdso") || strings.HasPrefix(m.File, "/dev/dri/")
+	return strings.HasPrefix(name, "[") || strings.HasPrefix(name, "linux-vdso") || strings.HasPrefix(m.File, "/dev/dri/") || m.emptyRange()
 }
 
 // Copy makes a fully independent copy of a profile.
@@ -48,6 +48,11 @@ func TestParse(t *testing.T) {
 		"java.cpu",
 		"java.heap",
 		"java.contention",
+		"jemalloc.heap",
+		"valgrind.massif",
+		"node.cpuprofile",
+		"spe.perf.script",
+		"xperf.etw.csv",
 	} {
 		inbytes, err := ioutil.ReadFile(filepath.Join(path, source))
 		if err != nil {
@@ -582,6 +587,24 @@ func TestAggregation(t *testing.T) {
 	}
 }
 
+func TestAggregateFiles(t *testing.T) {
+	prof := &Profile{
+		Function: []*Function{
+			{ID: 1, Name: "F1", SystemName: "F1", Filename: "a.go", StartLine: 3},
+			{ID: 2, Name: "F2", SystemName: "F2", Filename: "a.go", StartLine: 8},
+		},
+	}
+	if err := prof.Aggregate(true, false, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+	// The functions of a file are no longer told apart.
+	for _, f := range prof.Function {
+		if f.Name != "" || f.SystemName != "" || f.StartLine != 0 || f.Filename != "a.go" {
+			t.Errorf("got function %+v aggregated by file, want only the file a.go", f)
+		}
+	}
+}
+
 // checkAggregation verifies that the profile remained consistent
 // with its aggregation.
 func checkAggregation(prof *Profile, a *aggTest) error {
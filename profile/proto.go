@@ -137,6 +137,20 @@ func encodeInt64Opt(b *buffer, tag int, x int64) {
 	encodeInt64(b, tag, x)
 }
 
+func encodeFixed64(b *buffer, tag int, x uint64) {
+	encodeVarint(b, uint64(tag)<<3|1)
+	for i := 0; i < 8; i++ {
+		b.data = append(b.data, byte(x>>uint(8*i)))
+	}
+}
+
+func encodeFixed64Opt(b *buffer, tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	encodeFixed64(b, tag, x)
+}
+
 func encodeString(b *buffer, tag int, x string) {
 	encodeLength(b, tag, len(x))
 	b.data = append(b.data, x...)
@@ -204,6 +218,10 @@ func decodeField(b *buffer, data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Field numbers have 29 bits, which fit in the ints of all platforms.
+	if x>>3 >= 1<<29 {
+		return nil, errors.New("invalid field number")
+	}
 	b.field = int(x >> 3)
 	b.typ = int(x & 7)
 	b.data = nil
@@ -315,6 +333,14 @@ func decodeUint64(b *buffer, x *uint64) error {
 	return nil
 }
 
+func decodeFixed64(b *buffer, x *uint64) error {
+	if err := checkType(b, 1); err != nil {
+		return err
+	}
+	*x = b.u64
+	return nil
+}
+
 func decodeUint64s(b *buffer, x *[]uint64) error {
 	if b.typ == 2 {
 		data := b.data
@@ -179,3 +179,16 @@ func TestMarshalUnmarshal(t *testing.T) {
 		t.Error("\n" + string(d))
 	}
 }
+
+func TestDecodeFieldNumber(t *testing.T) {
+	// The field number 2^29 of a varint, which protocol buffers cannot
+	// have, followed by its value.
+	data := []byte{0x80, 0x80, 0x80, 0x80, 0x10, 0x01}
+	if _, err := decodeField(&buffer{}, data); err == nil {
+		t.Errorf("decodeField: got no error for field number 2^29")
+	}
+	data = []byte{0xf8, 0xff, 0xff, 0xff, 0x0f, 0x01} // Field number 2^29-1.
+	if _, err := decodeField(&buffer{}, data); err != nil {
+		t.Errorf("decodeField: got %v for field number 2^29-1", err)
+	}
+}
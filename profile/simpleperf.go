@@ -0,0 +1,487 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the samples reported by
+// Android's simpleperf, with "simpleperf report-sample --protobuf", into
+// the profile.proto format. The perf.data files recorded by simpleperf
+// are read by the perf.data converter instead.
+
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// simpleperfMagic starts the protobuf output of simpleperf, followed by
+// a 16-bit version and a sequence of records, each preceded by its
+// 32-bit size and terminated by a record of size 0.
+const simpleperfMagic = "SIMPLEPERF"
+
+// spRecord is a record of the simpleperf report, holding one of its
+// fields.
+type spRecord struct {
+	sample *spSample
+	lost   *spLost
+	file   *spFile
+	thread *spThread
+	meta   *spMetaInfo
+}
+
+// spSample is a sample of simpleperf, with its call chain starting at
+// the leaf.
+type spSample struct {
+	time        uint64
+	threadID    int64
+	callchain   []*spCallChainEntry
+	eventCount  uint64
+	eventTypeID uint64
+}
+
+// spCallChainEntry is a frame of a sample of simpleperf.
+type spCallChainEntry struct {
+	vaddrInFile   uint64
+	fileID        uint64
+	symbolID      int64 // -1 if the frame has no symbol.
+	executionType uint64
+}
+
+// The execution types of frames, distinguishing the Java methods run by
+// ART from native code.
+const (
+	spNativeMethod = iota
+	spInterpretedJVMMethod
+	spJITJVMMethod
+	spARTMethod
+)
+
+// spLost reports the samples lost while recording.
+type spLost struct {
+	sampleCount, lostCount uint64
+}
+
+// spFile is a file with code, and the symbols of its sampled frames.
+type spFile struct {
+	id             uint64
+	path           string
+	symbols        []string
+	mangledSymbols []string
+}
+
+// spThread identifies a thread.
+type spThread struct {
+	threadID, processID uint64
+	threadName          string
+}
+
+// spMetaInfo holds information on the recording.
+type spMetaInfo struct {
+	eventTypes     []string
+	appPackageName string
+}
+
+func (p *spRecord) decoder() []decoder {
+	return spRecordDecoder
+}
+
+func (p *spRecord) encode(b *buffer) {
+	switch {
+	case p.sample != nil:
+		encodeMessage(b, 1, p.sample)
+	case p.lost != nil:
+		encodeMessage(b, 2, p.lost)
+	case p.file != nil:
+		encodeMessage(b, 3, p.file)
+	case p.thread != nil:
+		encodeMessage(b, 4, p.thread)
+	case p.meta != nil:
+		encodeMessage(b, 5, p.meta)
+	}
+}
+
+var spRecordDecoder = []decoder{
+	nil, // 0
+	// Sample sample = 1
+	func(b *buffer, m message) error {
+		x := new(spSample)
+		m.(*spRecord).sample = x
+		return decodeMessage(b, x)
+	},
+	// LostSituation lost = 2
+	func(b *buffer, m message) error {
+		x := new(spLost)
+		m.(*spRecord).lost = x
+		return decodeMessage(b, x)
+	},
+	// File file = 3
+	func(b *buffer, m message) error {
+		x := new(spFile)
+		m.(*spRecord).file = x
+		return decodeMessage(b, x)
+	},
+	// Thread thread = 4
+	func(b *buffer, m message) error {
+		x := new(spThread)
+		m.(*spRecord).thread = x
+		return decodeMessage(b, x)
+	},
+	// MetaInfo meta_info = 5
+	func(b *buffer, m message) error {
+		x := new(spMetaInfo)
+		m.(*spRecord).meta = x
+		return decodeMessage(b, x)
+	},
+}
+
+func (p *spSample) decoder() []decoder {
+	return spSampleDecoder
+}
+
+func (p *spSample) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.time)
+	encodeInt64Opt(b, 2, p.threadID)
+	for _, x := range p.callchain {
+		encodeMessage(b, 3, x)
+	}
+	encodeUint64Opt(b, 4, p.eventCount)
+	encodeUint64Opt(b, 5, p.eventTypeID)
+}
+
+var spSampleDecoder = []decoder{
+	nil, // 0
+	// optional uint64 time = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spSample).time) },
+	// optional int32 thread_id = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*spSample).threadID) },
+	// repeated CallChainEntry callchain = 3
+	func(b *buffer, m message) error {
+		x := &spCallChainEntry{symbolID: -1}
+		pp := m.(*spSample)
+		pp.callchain = append(pp.callchain, x)
+		return decodeMessage(b, x)
+	},
+	// optional uint64 event_count = 4
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spSample).eventCount) },
+	// optional uint32 event_type_id = 5
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spSample).eventTypeID) },
+}
+
+func (p *spCallChainEntry) decoder() []decoder {
+	return spCallChainEntryDecoder
+}
+
+func (p *spCallChainEntry) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.vaddrInFile)
+	encodeUint64Opt(b, 2, p.fileID)
+	encodeInt64(b, 3, p.symbolID)
+	encodeUint64Opt(b, 4, p.executionType)
+}
+
+var spCallChainEntryDecoder = []decoder{
+	nil, // 0
+	// optional uint64 vaddr_in_file = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spCallChainEntry).vaddrInFile) },
+	// optional uint32 file_id = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spCallChainEntry).fileID) },
+	// optional int32 symbol_id = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*spCallChainEntry).symbolID) },
+	// optional ExecutionType execution_type = 4
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spCallChainEntry).executionType) },
+}
+
+func (p *spLost) decoder() []decoder {
+	return spLostDecoder
+}
+
+func (p *spLost) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.sampleCount)
+	encodeUint64Opt(b, 2, p.lostCount)
+}
+
+var spLostDecoder = []decoder{
+	nil, // 0
+	// optional uint64 sample_count = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spLost).sampleCount) },
+	// optional uint64 lost_count = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spLost).lostCount) },
+}
+
+func (p *spFile) decoder() []decoder {
+	return spFileDecoder
+}
+
+func (p *spFile) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.id)
+	encodeString(b, 2, p.path)
+	encodeStrings(b, 3, p.symbols)
+	encodeStrings(b, 4, p.mangledSymbols)
+}
+
+var spFileDecoder = []decoder{
+	nil, // 0
+	// optional uint32 id = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spFile).id) },
+	// optional string path = 2
+	func(b *buffer, m message) error { return decodeString(b, &m.(*spFile).path) },
+	// repeated string symbol = 3
+	func(b *buffer, m message) error { return decodeStrings(b, &m.(*spFile).symbols) },
+	// repeated string mangled_symbol = 4
+	func(b *buffer, m message) error { return decodeStrings(b, &m.(*spFile).mangledSymbols) },
+}
+
+func (p *spThread) decoder() []decoder {
+	return spThreadDecoder
+}
+
+func (p *spThread) encode(b *buffer) {
+	encodeUint64Opt(b, 1, p.threadID)
+	encodeUint64Opt(b, 2, p.processID)
+	encodeString(b, 3, p.threadName)
+}
+
+var spThreadDecoder = []decoder{
+	nil, // 0
+	// optional uint32 thread_id = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spThread).threadID) },
+	// optional uint32 process_id = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*spThread).processID) },
+	// optional string thread_name = 3
+	func(b *buffer, m message) error { return decodeString(b, &m.(*spThread).threadName) },
+}
+
+func (p *spMetaInfo) decoder() []decoder {
+	return spMetaInfoDecoder
+}
+
+func (p *spMetaInfo) encode(b *buffer) {
+	encodeStrings(b, 1, p.eventTypes)
+	if p.appPackageName != "" {
+		encodeString(b, 2, p.appPackageName)
+	}
+}
+
+var spMetaInfoDecoder = []decoder{
+	nil, // 0
+	// repeated string event_type = 1
+	func(b *buffer, m message) error { return decodeStrings(b, &m.(*spMetaInfo).eventTypes) },
+	// optional string app_package_name = 2
+	func(b *buffer, m message) error { return decodeString(b, &m.(*spMetaInfo).appPackageName) },
+}
+
+// parseSimpleperf parses the protobuf output of simpleperf report-sample.
+// There is a sample type per recorded event, with the event counts, in
+// addition to the number of samples. The samples are labeled with their
+// thread, and the frames of Java methods are placed in the mappings of
+// the dex, oat or JIT code they run from, which simpleperf symbolizes.
+func parseSimpleperf(b []byte) (*Profile, error) {
+	if !bytes.HasPrefix(b, []byte(simpleperfMagic)) {
+		return nil, errUnrecognized
+	}
+	b = b[len(simpleperfMagic):]
+	if len(b) < 2 {
+		return nil, fmt.Errorf("truncated simpleperf header")
+	}
+	if version := uint16(b[0]) | uint16(b[1])<<8; version != 1 {
+		return nil, fmt.Errorf("unsupported simpleperf version %d", version)
+	}
+	b = b[2:]
+
+	var samples []*spSample
+	files := make(map[uint64]*spFile)
+	threads := make(map[uint64]*spThread)
+	meta := &spMetaInfo{}
+	var lost []*spLost
+	for {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated simpleperf record")
+		}
+		size := le32(b)
+		b = b[4:]
+		if size == 0 {
+			break
+		}
+		if uint64(size) > uint64(len(b)) {
+			return nil, fmt.Errorf("truncated simpleperf record")
+		}
+		var r spRecord
+		if err := unmarshal(b[:size], &r); err != nil {
+			return nil, fmt.Errorf("simpleperf record: %v", err)
+		}
+		b = b[size:]
+		switch {
+		case r.sample != nil:
+			samples = append(samples, r.sample)
+		case r.lost != nil:
+			lost = append(lost, r.lost)
+		case r.file != nil:
+			files[r.file.id] = r.file
+		case r.thread != nil:
+			threads[r.thread.threadID] = r.thread
+		case r.meta != nil:
+			meta = r.meta
+		}
+	}
+
+	events := meta.eventTypes
+	if len(events) == 0 {
+		events = []string{"events"}
+	}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+	}
+	for _, e := range events {
+		unit := "count"
+		// simpleperf counts the clock events in nanoseconds.
+		if e == "cpu-clock" || e == "task-clock" {
+			unit = "nanoseconds"
+		}
+		p.SampleType = append(p.SampleType, &ValueType{Type: e, Unit: unit})
+	}
+	p.PeriodType = p.SampleType[1]
+	if meta.appPackageName != "" {
+		p.Comments = append(p.Comments, "simpleperf app: "+meta.appPackageName)
+	}
+	for _, l := range lost {
+		p.Comments = append(p.Comments, fmt.Sprintf("simpleperf lost %d of %d samples", l.lostCount, l.sampleCount))
+	}
+
+	sp := &simpleperfParser{
+		p:         p,
+		files:     files,
+		mappings:  make(map[uint64]*Mapping),
+		locations: make(map[spLocationKey]*Location),
+		functions: make(map[spLocationKey]*Function),
+	}
+	for i, s := range samples {
+		if s.eventTypeID >= uint64(len(events)) {
+			return nil, fmt.Errorf("simpleperf sample %d has unknown event type %d", i, s.eventTypeID)
+		}
+		ps := &Sample{
+			Value:    make([]int64, len(p.SampleType)),
+			Label:    make(map[string][]string),
+			NumLabel: map[string][]int64{"tid": {s.threadID}},
+		}
+		ps.Value[0] = 1
+		ps.Value[1+s.eventTypeID] = int64(s.eventCount)
+		if t := threads[uint64(s.threadID)]; t != nil {
+			ps.NumLabel["pid"] = []int64{int64(t.processID)}
+			if t.threadName != "" {
+				ps.Label["thread"] = []string{t.threadName}
+			}
+		}
+		for _, e := range s.callchain {
+			l, err := sp.location(e)
+			if err != nil {
+				return nil, fmt.Errorf("simpleperf sample %d: %v", i, err)
+			}
+			ps.Location = append(ps.Location, l)
+		}
+		p.Sample = append(p.Sample, ps)
+	}
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("simpleperf output has no samples")
+	}
+	return p, nil
+}
+
+// spLocationKey identifies an address or symbol of a file.
+type spLocationKey struct {
+	fileID uint64
+	value  int64
+}
+
+// simpleperfParser holds the state of the simpleperf parser.
+type simpleperfParser struct {
+	p         *Profile
+	files     map[uint64]*spFile
+	mappings  map[uint64]*Mapping
+	locations map[spLocationKey]*Location
+	functions map[spLocationKey]*Function
+}
+
+// location returns the location of a frame, at its address in its file.
+func (sp *simpleperfParser) location(e *spCallChainEntry) (*Location, error) {
+	key := spLocationKey{e.fileID, int64(e.vaddrInFile)}
+	if l := sp.locations[key]; l != nil {
+		return l, nil
+	}
+	f := sp.files[e.fileID]
+	if f == nil {
+		return nil, fmt.Errorf("unknown file %d", e.fileID)
+	}
+	p := sp.p
+	l := &Location{
+		ID:      uint64(len(p.Location) + 1),
+		Mapping: sp.mapping(f, e.executionType),
+		Address: e.vaddrInFile,
+	}
+	if e.symbolID >= 0 {
+		if e.symbolID >= int64(len(f.symbols)) {
+			return nil, fmt.Errorf("unknown symbol %d of %s", e.symbolID, f.path)
+		}
+		fk := spLocationKey{e.fileID, e.symbolID}
+		fn := sp.functions[fk]
+		if fn == nil {
+			name := f.symbols[e.symbolID]
+			fn = &Function{
+				ID:         uint64(len(p.Function) + 1),
+				Name:       name,
+				SystemName: name,
+			}
+			if e.symbolID < int64(len(f.mangledSymbols)) && f.mangledSymbols[e.symbolID] != "" {
+				fn.SystemName = f.mangledSymbols[e.symbolID]
+			}
+			sp.functions[fk] = fn
+			p.Function = append(p.Function, fn)
+		}
+		l.Line = []Line{{Function: fn}}
+		l.Mapping.HasFunctions = true
+	}
+	sp.locations[key] = l
+	p.Location = append(p.Location, l)
+	return l, nil
+}
+
+// mapping returns the mapping of file f, in which addresses are relative
+// to the file. The mappings with frames symbolized by simpleperf, and
+// those of the code run by ART, which pprof cannot symbolize, are marked
+// as symbolized.
+func (sp *simpleperfParser) mapping(f *spFile, executionType uint64) *Mapping {
+	m := sp.mappings[f.id]
+	if m == nil {
+		m = &Mapping{
+			ID:   uint64(len(sp.p.Mapping) + 1),
+			File: f.path,
+		}
+		sp.mappings[f.id] = m
+		sp.p.Mapping = append(sp.p.Mapping, m)
+	}
+	if executionType != spNativeMethod || isARTCode(f.path) {
+		m.HasFunctions = true
+	}
+	return m
+}
+
+// isARTCode reports whether path is a file or memory region holding the
+// Java code run by ART: dex, oat and vdex files, and the JIT code cache.
+func isARTCode(path string) bool {
+	path = strings.TrimSuffix(path, " (deleted)")
+	for _, suffix := range []string{".dex", ".odex", ".oat", ".vdex", ".art"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return strings.Contains(path, "jit-code-cache") || strings.Contains(path, "jit-cache") || strings.Contains(path, "dalvik-")
+}
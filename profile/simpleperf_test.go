@@ -0,0 +1,121 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// simpleperfOutput encodes records as the protobuf output of simpleperf.
+func simpleperfOutput(records ...*spRecord) []byte {
+	b := []byte(simpleperfMagic + "\x01\x00")
+	for _, r := range records {
+		data := marshal(r)
+		n := len(data)
+		b = append(b, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+		b = append(b, data...)
+	}
+	return append(b, 0, 0, 0, 0)
+}
+
+func TestParseSimpleperf(t *testing.T) {
+	const (
+		libc = iota
+		app
+		jit
+		apk
+	)
+	data := simpleperfOutput(
+		&spRecord{meta: &spMetaInfo{eventTypes: []string{"cpu-cycles", "cpu-clock"}, appPackageName: "com.example.app"}},
+		&spRecord{sample: &spSample{threadID: 11, eventCount: 1000, callchain: []*spCallChainEntry{
+			{vaddrInFile: 0x1000, fileID: libc, symbolID: 0},
+			{vaddrInFile: 0x2000, fileID: app, symbolID: -1},
+			{vaddrInFile: 0x30, fileID: jit, symbolID: 0, executionType: spJITJVMMethod},
+			{vaddrInFile: 0x400, fileID: apk, symbolID: 0, executionType: spInterpretedJVMMethod},
+		}}},
+		&spRecord{sample: &spSample{threadID: 12, eventCount: 250000, eventTypeID: 1, callchain: []*spCallChainEntry{
+			{vaddrInFile: 0x1000, fileID: libc, symbolID: 0},
+		}}},
+		&spRecord{lost: &spLost{sampleCount: 100, lostCount: 2}},
+		&spRecord{file: &spFile{id: libc, path: "/apex/com.android.runtime/lib64/bionic/libc.so", symbols: []string{"memcpy"}}},
+		&spRecord{file: &spFile{id: app, path: "/data/app/com.example.app/lib/arm64/libnative.so"}},
+		&spRecord{file: &spFile{id: jit, path: "[anon:dalvik-jit-code-cache]", symbols: []string{"com.example.Main.run"}}},
+		&spRecord{file: &spFile{id: apk, path: "/data/app/com.example.app/base.apk!/classes.dex", symbols: []string{"com.example.Main.main"}}},
+		&spRecord{thread: &spThread{threadID: 11, processID: 10, threadName: "main"}},
+	)
+	p, err := ParseData(data)
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+
+	var types []string
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if want := []string{"samples/count", "cpu-cycles/count", "cpu-clock/nanoseconds"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got sample types %v, want %v", types, want)
+	}
+	if want := []string{"simpleperf app: com.example.app", "simpleperf lost 2 of 100 samples"}; !reflect.DeepEqual(p.Comments, want) {
+		t.Errorf("got comments %q, want %q", p.Comments, want)
+	}
+
+	var got []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			name := fmt.Sprintf("%#x", l.Address)
+			if len(l.Line) > 0 {
+				name = l.Line[0].Function.Name
+			}
+			frames = append(frames, name)
+		}
+		got = append(got, fmt.Sprintf("%v %s %v %v", s.Value, strings.Join(frames, " "), s.Label, s.NumLabel))
+	}
+	want := []string{
+		"[1 1000 0] memcpy 0x2000 com.example.Main.run com.example.Main.main map[thread:[main]] map[pid:[10] tid:[11]]",
+		"[1 0 250000] memcpy map[] map[tid:[12]]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Only the native library without symbols is left to symbolize.
+	for _, m := range p.Mapping {
+		if want := !strings.HasSuffix(m.File, "libnative.so"); m.HasFunctions != want {
+			t.Errorf("mapping %s has HasFunctions %v, want %v", m.File, m.HasFunctions, want)
+		}
+	}
+}
+
+func TestParseSimpleperfMalformed(t *testing.T) {
+	sample := &spRecord{sample: &spSample{callchain: []*spCallChainEntry{{fileID: 1, symbolID: -1}}}}
+	for _, tc := range []struct {
+		data []byte
+		want string
+	}{
+		{[]byte(simpleperfMagic + "\x02\x00"), "unsupported simpleperf version 2"},
+		{[]byte(simpleperfMagic + "\x01\x00\x05\x00\x00\x00"), "truncated simpleperf record"},
+		{simpleperfOutput(), "simpleperf output has no samples"},
+		{simpleperfOutput(sample), "simpleperf sample 0: unknown file 1"},
+		{simpleperfOutput(&spRecord{sample: &spSample{eventTypeID: 1}}), "simpleperf sample 0 has unknown event type 1"},
+	} {
+		if _, err := parseSimpleperf(tc.data); err == nil || err.Error() != tc.want {
+			t.Errorf("got error %v, want %s", err, tc.want)
+		}
+	}
+}
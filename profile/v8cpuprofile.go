@@ -0,0 +1,177 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the CPU profiles of the V8
+// JavaScript engine, as saved by Chrome DevTools and node --cpu-prof in
+// .cpuprofile files, into the profile.proto format.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// v8CPUProfile is a CPU profile of V8. The call tree is described by its
+// nodes, and the samples hold the IDs of the nodes executing at each
+// sampling tick, taken after the time deltas, in microseconds.
+type v8CPUProfile struct {
+	Nodes      []*v8Node `json:"nodes"`
+	StartTime  int64     `json:"startTime"`
+	EndTime    int64     `json:"endTime"`
+	Samples    []int64   `json:"samples"`
+	TimeDeltas []int64   `json:"timeDeltas"`
+}
+
+// v8Node is a node of the call tree of a V8 CPU profile.
+type v8Node struct {
+	ID        int64       `json:"id"`
+	CallFrame v8CallFrame `json:"callFrame"`
+	HitCount  int64       `json:"hitCount"`
+	Children  []int64     `json:"children"`
+}
+
+// v8CallFrame describes the function of a node. Line and column numbers
+// are zero-based, and locate the start of the function.
+type v8CallFrame struct {
+	FunctionName string `json:"functionName"`
+	ScriptID     string `json:"scriptId"`
+	URL          string `json:"url"`
+	LineNumber   int64  `json:"lineNumber"`
+	ColumnNumber int64  `json:"columnNumber"`
+}
+
+// v8RootName is the name of the root of the call tree, which is not a
+// frame of any stack.
+const v8RootName = "(root)"
+
+// parseV8CPUProfile parses a V8 CPU profile. Each node of the call tree
+// becomes a sample, with the number of ticks it was sampled and the time
+// until the following tick, or only its hit count if the profile has no
+// samples.
+func parseV8CPUProfile(b []byte) (*Profile, error) {
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '{' {
+		return nil, errUnrecognized
+	}
+	var v8 v8CPUProfile
+	if err := json.Unmarshal(b, &v8); err != nil || len(v8.Nodes) == 0 || v8.Nodes[0].CallFrame.FunctionName != v8RootName {
+		return nil, errUnrecognized
+	}
+	if len(v8.TimeDeltas) != len(v8.Samples) {
+		return nil, fmt.Errorf("V8 CPU profile has %d samples and %d time deltas", len(v8.Samples), len(v8.TimeDeltas))
+	}
+
+	nodes := make(map[int64]*v8Node, len(v8.Nodes))
+	for _, n := range v8.Nodes {
+		if nodes[n.ID] != nil {
+			return nil, fmt.Errorf("V8 CPU profile has duplicate node %d", n.ID)
+		}
+		nodes[n.ID] = n
+	}
+	parents := make(map[int64]*v8Node)
+	for _, n := range v8.Nodes {
+		for _, c := range n.Children {
+			if nodes[c] == nil {
+				return nil, fmt.Errorf("V8 CPU profile node %d has unknown child %d", n.ID, c)
+			}
+			parents[c] = n
+		}
+	}
+
+	// Collect the ticks and time, in microseconds, of the nodes.
+	counts := make(map[int64]int64)
+	times := make(map[int64]int64)
+	if len(v8.Samples) == 0 {
+		for _, n := range v8.Nodes {
+			counts[n.ID] = n.HitCount
+		}
+	}
+	for i, id := range v8.Samples {
+		if nodes[id] == nil {
+			return nil, fmt.Errorf("V8 CPU profile sample %d has unknown node %d", i, id)
+		}
+		counts[id]++
+		if i+1 < len(v8.TimeDeltas) {
+			times[id] += v8.TimeDeltas[i+1]
+		}
+	}
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "cpu", Unit: "nanoseconds"},
+		SampleType: []*ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		TimeNanos:     v8.StartTime * 1000,
+		DurationNanos: (v8.EndTime - v8.StartTime) * 1000,
+	}
+	if len(v8.Samples) > 1 {
+		// Use the mean sampling interval.
+		p.Period = p.DurationNanos / int64(len(v8.Samples))
+	}
+	locations := make(map[v8CallFrame]*Location)
+	functions := make(map[v8CallFrame]*Function)
+	location := func(cf v8CallFrame) *Location {
+		if l := locations[cf]; l != nil {
+			return l
+		}
+		fk := cf
+		fk.ColumnNumber = 0
+		f := functions[fk]
+		if f == nil {
+			name := cf.FunctionName
+			if name == "" {
+				name = "(anonymous)"
+			}
+			f = &Function{
+				ID:         uint64(len(p.Function) + 1),
+				Name:       name,
+				SystemName: name,
+				Filename:   cf.URL,
+				StartLine:  cf.LineNumber + 1,
+			}
+			functions[fk] = f
+			p.Function = append(p.Function, f)
+		}
+		l := &Location{
+			ID:   uint64(len(p.Location) + 1),
+			Line: []Line{{Function: f, Line: cf.LineNumber + 1}},
+		}
+		locations[cf] = l
+		p.Location = append(p.Location, l)
+		return l
+	}
+
+	for _, n := range v8.Nodes {
+		count := counts[n.ID]
+		if count == 0 {
+			continue
+		}
+		s := &Sample{Value: []int64{count, times[n.ID] * 1000}}
+		// Walk up to the root, which has no frame.
+		seen := make(map[int64]bool)
+		for ; n != nil && parents[n.ID] != nil; n = parents[n.ID] {
+			if seen[n.ID] {
+				return nil, fmt.Errorf("V8 CPU profile has a cycle at node %d", n.ID)
+			}
+			seen[n.ID] = true
+			s.Location = append(s.Location, location(n.CallFrame))
+		}
+		if len(s.Location) > 0 {
+			p.Sample = append(p.Sample, s)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"testing"
+)
+
+func TestParseV8CPUProfileHitCounts(t *testing.T) {
+	const data = `{"nodes":[
+{"id":1,"callFrame":{"functionName":"(root)"},"hitCount":1,"children":[2]},
+{"id":2,"callFrame":{"functionName":"main","url":"main.js","lineNumber":4},"hitCount":7}]}`
+	p, err := parseV8CPUProfile([]byte(data))
+	if err != nil {
+		t.Fatalf("parseV8CPUProfile: %v", err)
+	}
+	// The ticks of the root have no stack and are dropped.
+	if len(p.Sample) != 1 || p.Sample[0].Value[0] != 7 || p.Sample[0].Value[1] != 0 {
+		t.Fatalf("got samples %v, want a sample with 7 ticks", p.Sample)
+	}
+	if l := p.Sample[0].Location[0].Line[0]; l.Function.Name != "main" || l.Function.Filename != "main.js" || l.Line != 5 {
+		t.Errorf("got line %+v of %+v, want main.js:5", l, l.Function)
+	}
+}
+
+func TestParseV8CPUProfileMalformed(t *testing.T) {
+	const root = `{"id":1,"callFrame":{"functionName":"(root)"},"children":[2]}`
+	for _, tc := range []struct {
+		data, want string
+	}{
+		{`{"nodes":[` + root + `]}`, "V8 CPU profile node 1 has unknown child 2"},
+		{`{"nodes":[` + root + `,{"id":2},{"id":2}]}`, "V8 CPU profile has duplicate node 2"},
+		{`{"nodes":[` + root + `,{"id":2}],"samples":[2]}`, "V8 CPU profile has 1 samples and 0 time deltas"},
+		{`{"nodes":[` + root + `,{"id":2}],"samples":[3],"timeDeltas":[1]}`, "V8 CPU profile sample 0 has unknown node 3"},
+	} {
+		if _, err := parseV8CPUProfile([]byte(tc.data)); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: got error %v, want %s", tc.data, err, tc.want)
+		}
+	}
+	for _, data := range []string{"", "main;foo 1", `{"traceEvents":[]}`, `{"nodes":[{"id":1}]}`} {
+		if _, err := parseV8CPUProfile([]byte(data)); err != errUnrecognized {
+			t.Errorf("%q: got error %v, want errUnrecognized", data, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a parser to convert the heap snapshots of the V8
+// JavaScript engine, as saved by Chrome DevTools and node in
+// .heapsnapshot files, into the profile.proto format.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// v8HeapSnapshot is a heap snapshot of V8. The nodes and edges of the
+// heap graph are flattened into arrays of integers, with the fields
+// described by the metadata of the snapshot. The edges of each node
+// follow those of the previous node.
+type v8HeapSnapshot struct {
+	Snapshot struct {
+		Meta struct {
+			NodeFields []string          `json:"node_fields"`
+			NodeTypes  []json.RawMessage `json:"node_types"`
+			EdgeFields []string          `json:"edge_fields"`
+			EdgeTypes  []json.RawMessage `json:"edge_types"`
+		} `json:"meta"`
+	} `json:"snapshot"`
+	Nodes   []int64  `json:"nodes"`
+	Edges   []int64  `json:"edges"`
+	Strings []string `json:"strings"`
+}
+
+// parseV8HeapSnapshot parses a V8 heap snapshot into an approximate heap
+// profile. Each object reachable from the root of the snapshot becomes a
+// sample, with its size and a stack made of the objects dominating it,
+// ie through which it is retained, from itself to the root. The
+// cumulative values of the frames are thus the sizes retained by the
+// objects of a type. Objects are named by their constructor, and other
+// nodes by their type, as in the summary view of Chrome DevTools, eg
+// "(string)". Weak edges do not retain objects, and repeated frames,
+// such as those of linked lists, are merged.
+func parseV8HeapSnapshot(b []byte) (*Profile, error) {
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '{' {
+		return nil, errUnrecognized
+	}
+	// The metadata comes first, so look for it before parsing the
+	// whole snapshot.
+	head := b
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	if !bytes.Contains(head, []byte(`"node_fields"`)) {
+		return nil, errUnrecognized
+	}
+	var hs v8HeapSnapshot
+	if err := json.Unmarshal(b, &hs); err != nil {
+		return nil, errUnrecognized
+	}
+	meta := hs.Snapshot.Meta
+	if len(meta.NodeFields) == 0 || len(meta.EdgeFields) == 0 {
+		return nil, errUnrecognized
+	}
+
+	field := func(fields []string, name string) (int, error) {
+		for i, f := range fields {
+			if f == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("V8 heap snapshot has no %s field", name)
+	}
+	types := func(raw []json.RawMessage, i int) ([]string, error) {
+		var names []string
+		if i >= len(raw) || json.Unmarshal(raw[i], &names) != nil {
+			return nil, fmt.Errorf("V8 heap snapshot has no type names")
+		}
+		return names, nil
+	}
+	var nodeType, nodeName, nodeSize, nodeEdges, edgeType, edgeTo int
+	var nodeTypes, edgeTypes []string
+	var err error
+	for _, f := range []struct {
+		fields []string
+		name   string
+		i      *int
+	}{
+		{meta.NodeFields, "type", &nodeType},
+		{meta.NodeFields, "name", &nodeName},
+		{meta.NodeFields, "self_size", &nodeSize},
+		{meta.NodeFields, "edge_count", &nodeEdges},
+		{meta.EdgeFields, "type", &edgeType},
+		{meta.EdgeFields, "to_node", &edgeTo},
+	} {
+		if *f.i, err = field(f.fields, f.name); err != nil {
+			return nil, err
+		}
+	}
+	if nodeTypes, err = types(meta.NodeTypes, nodeType); err != nil {
+		return nil, err
+	}
+	if edgeTypes, err = types(meta.EdgeTypes, edgeType); err != nil {
+		return nil, err
+	}
+	nf, ef := len(meta.NodeFields), len(meta.EdgeFields)
+	if len(hs.Nodes)%nf != 0 || len(hs.Edges)%ef != 0 {
+		return nil, fmt.Errorf("V8 heap snapshot has %d node and %d edge values, not multiples of their %d and %d fields", len(hs.Nodes), len(hs.Edges), nf, ef)
+	}
+	n := len(hs.Nodes) / nf
+	if n == 0 {
+		return nil, fmt.Errorf("V8 heap snapshot has no nodes")
+	}
+
+	// Collect the edges of each node that retain objects.
+	weak := -1
+	for i, t := range edgeTypes {
+		if t == "weak" {
+			weak = i
+		}
+	}
+	first := make([]int, n+1) // Edges of node i are edges[first[i]:first[i+1]].
+	var edges []int
+	e := 0
+	for i := 0; i < n; i++ {
+		first[i] = len(edges)
+		count := int(hs.Nodes[i*nf+nodeEdges])
+		for j := 0; j < count; j++ {
+			if (e+1)*ef > len(hs.Edges) {
+				return nil, fmt.Errorf("V8 heap snapshot node %d has more edges than the snapshot", i)
+			}
+			to := hs.Edges[e*ef+edgeTo]
+			if to < 0 || to%int64(nf) != 0 || to/int64(nf) >= int64(n) {
+				return nil, fmt.Errorf("V8 heap snapshot edge %d points to invalid node offset %d", e, to)
+			}
+			if int(hs.Edges[e*ef+edgeType]) != weak {
+				edges = append(edges, int(to/int64(nf)))
+			}
+			e++
+		}
+	}
+	first[n] = len(edges)
+
+	dom := v8Dominators(n, first, edges)
+
+	p := &Profile{
+		PeriodType: &ValueType{Type: "space", Unit: "bytes"},
+		SampleType: []*ValueType{
+			{Type: "objects", Unit: "count"},
+			{Type: "space", Unit: "bytes"},
+		},
+		DefaultSampleType: "space",
+	}
+	locations := make(map[string]*Location)
+	location := func(name string) *Location {
+		if l := locations[name]; l != nil {
+			return l
+		}
+		f := &Function{ID: uint64(len(p.Function) + 1), Name: name, SystemName: name}
+		l := &Location{ID: uint64(len(p.Location) + 1), Line: []Line{{Function: f}}}
+		locations[name] = l
+		p.Function = append(p.Function, f)
+		p.Location = append(p.Location, l)
+		return l
+	}
+	frame := func(i int) (string, error) {
+		t := int(hs.Nodes[i*nf+nodeType])
+		if t < 0 || t >= len(nodeTypes) {
+			return "", fmt.Errorf("V8 heap snapshot node %d has invalid type %d", i, t)
+		}
+		switch nodeTypes[t] {
+		case "object", "native", "synthetic":
+			s := hs.Nodes[i*nf+nodeName]
+			if s < 0 || s >= int64(len(hs.Strings)) {
+				return "", fmt.Errorf("V8 heap snapshot node %d has invalid name %d", i, s)
+			}
+			if name := hs.Strings[s]; name != "" {
+				return name, nil
+			}
+		}
+		return "(" + nodeTypes[t] + ")", nil
+	}
+
+	// Intern the stacks of the objects, in a tree of stacks where each
+	// stack is its top frame on the stack of the dominator of the object.
+	// Dominators precede the objects they dominate in dom.order.
+	type stackKey struct {
+		loc    *Location
+		parent int
+	}
+	type stack struct {
+		stackKey
+		objects, size int64
+	}
+	stacks := []*stack{{}} // The empty stack of the root.
+	stackIDs := make(map[stackKey]int)
+	stackOf := make([]int, n)
+	for _, i := range dom.order[1:] {
+		name, err := frame(i)
+		if err != nil {
+			return nil, err
+		}
+		parent := stackOf[dom.parent[i]]
+		id := parent
+		if loc := location(name); stacks[parent].loc != loc {
+			key := stackKey{loc, parent}
+			var ok bool
+			if id, ok = stackIDs[key]; !ok {
+				id = len(stacks)
+				stacks = append(stacks, &stack{stackKey: key})
+				stackIDs[key] = id
+			}
+		}
+		stackOf[i] = id
+		stacks[id].objects++
+		stacks[id].size += hs.Nodes[i*nf+nodeSize]
+	}
+	for _, s := range stacks[1:] {
+		if s.objects == 0 {
+			continue
+		}
+		sample := &Sample{Value: []int64{s.objects, s.size}}
+		for st := s; st.loc != nil; st = stacks[st.parent] {
+			sample.Location = append(sample.Location, st.loc)
+		}
+		p.Sample = append(p.Sample, sample)
+	}
+	return p, nil
+}
+
+// v8Dominated is the dominator tree of the nodes of a heap graph
+// reachable from its root, node 0.
+type v8Dominated struct {
+	order  []int // The reachable nodes in reverse postorder, from the root.
+	parent []int // The immediate dominator of each reachable node.
+}
+
+// v8Dominators computes the dominator tree of the graph of n nodes where
+// the successors of node i are edges[first[i]:first[i+1]], with the
+// algorithm of Cooper, Harvey and Kennedy, "A Simple, Fast Dominance
+// Algorithm".
+func v8Dominators(n int, first, edges []int) v8Dominated {
+	// Number the nodes in postorder, with an iterative depth-first search.
+	const unvisited = -1
+	post := make([]int, n)
+	for i := range post {
+		post[i] = unvisited
+	}
+	var order []int // Postorder.
+	visited := make([]bool, n)
+	type frame struct{ node, next int }
+	dfs := []frame{{0, first[0]}}
+	visited[0] = true
+	for len(dfs) > 0 {
+		top := &dfs[len(dfs)-1]
+		if top.next < first[top.node+1] {
+			succ := edges[top.next]
+			top.next++
+			if !visited[succ] {
+				visited[succ] = true
+				dfs = append(dfs, frame{succ, first[succ]})
+			}
+			continue
+		}
+		post[top.node] = len(order)
+		order = append(order, top.node)
+		dfs = dfs[:len(dfs)-1]
+	}
+
+	// Collect the predecessors of the reachable nodes.
+	predFirst := make([]int, n+1)
+	for i := 0; i < n; i++ {
+		if post[i] == unvisited {
+			continue
+		}
+		for _, s := range edges[first[i]:first[i+1]] {
+			predFirst[s+1]++
+		}
+	}
+	for i := 0; i < n; i++ {
+		predFirst[i+1] += predFirst[i]
+	}
+	preds := make([]int, predFirst[n])
+	fill := append([]int(nil), predFirst[:n]...)
+	for i := 0; i < n; i++ {
+		if post[i] == unvisited {
+			continue
+		}
+		for _, s := range edges[first[i]:first[i+1]] {
+			preds[fill[s]] = i
+			fill[s]++
+		}
+	}
+
+	idom := make([]int, n)
+	for i := range idom {
+		idom[i] = unvisited
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for post[a] < post[b] {
+				a = idom[a]
+			}
+			for post[b] < post[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := len(order) - 2; k >= 0; k-- { // Reverse postorder, without the root.
+			i := order[k]
+			d := unvisited
+			for _, p := range preds[predFirst[i]:predFirst[i+1]] {
+				if idom[p] == unvisited {
+					continue
+				}
+				if d == unvisited {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if idom[i] != d {
+				idom[i] = d
+				changed = true
+			}
+		}
+	}
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return v8Dominated{order: order, parent: idom}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHeapSnapshot is a heap snapshot where the roots retain a Cache,
+// holding an array of a string also referenced by a list of two Entry
+// objects, and an object only referenced weakly.
+const testHeapSnapshot = `{"snapshot":{"meta":{
+"node_fields":["type","name","id","self_size","edge_count"],
+"node_types":[["hidden","array","string","object","code","closure","synthetic"],"string","number","number","number"],
+"edge_fields":["type","name_or_index","to_node"],
+"edge_types":[["context","element","property","internal","hidden","shortcut","weak"],"string_or_number","node"]},
+"node_count":8,"edge_count":8},
+"nodes":[
+6,0,1,0,1,
+6,1,3,0,3,
+3,2,5,100,1,
+1,0,7,50,1,
+2,5,9,10,0,
+3,3,11,20,2,
+3,4,13,30,0,
+3,3,15,20,0],
+"edges":[
+2,0,5,
+2,0,10, 2,0,25, 6,0,30,
+2,0,15,
+1,0,20,
+2,0,20, 2,0,35],
+"strings":["","(GC roots)","Cache","Entry","Lost","abc"]}`
+
+func TestParseV8HeapSnapshot(t *testing.T) {
+	p, err := Parse(strings.NewReader(testHeapSnapshot))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if st := p.SampleType; len(st) != 2 || st[0].Type != "objects" || st[1].Type != "space" || st[1].Unit != "bytes" || p.DefaultSampleType != "space" {
+		t.Errorf("got profile:\n%s\nwant objects and space in bytes samples", p)
+	}
+	got := make(map[string][]int64)
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			frames = append(frames, l.Line[0].Function.Name)
+		}
+		got[strings.Join(frames, ";")] = s.Value
+	}
+	want := map[string][]int64{
+		"(GC roots)":               {1, 0},
+		"Cache;(GC roots)":         {1, 100},
+		"(array);Cache;(GC roots)": {1, 50},
+		// The string is retained by both the Cache and the Entry objects.
+		"(string);(GC roots)": {1, 10},
+		// The list of Entry objects is merged in a frame.
+		"Entry;(GC roots)": {2, 40},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+}
+
+func TestV8Dominators(t *testing.T) {
+	// 0 -> 1 -> 3, 0 -> 2 -> 3 -> 4 -> 1, and 5 is unreachable.
+	succs := [][]int{{1, 2}, {3}, {3}, {4}, {1}, {0}}
+	var first, edges []int
+	for _, s := range succs {
+		first = append(first, len(edges))
+		edges = append(edges, s...)
+	}
+	first = append(first, len(edges))
+	dom := v8Dominators(len(succs), first, edges)
+	if want := []int{0, 0, 0, 0, 3, -1}; !reflect.DeepEqual(dom.parent, want) {
+		t.Errorf("got dominators %v, want %v", dom.parent, want)
+	}
+	if len(dom.order) != 5 || dom.order[0] != 0 {
+		t.Errorf("got order %v, want the 5 reachable nodes from the root", dom.order)
+	}
+}
+
+func TestParseV8HeapSnapshotMalformed(t *testing.T) {
+	const meta = `{"snapshot":{"meta":{"node_fields":["type","name","self_size","edge_count"],"node_types":[["object"]],"edge_fields":["type","to_node"],"edge_types":[["property"]]}},`
+	for _, tc := range []struct {
+		data, want string
+	}{
+		{`{"snapshot":{"meta":{"node_fields":["type"],"edge_fields":["type"]}}}`, "V8 heap snapshot has no name field"},
+		{meta + `"nodes":[0,0,0],"edges":[]}`, "V8 heap snapshot has 3 node and 0 edge values, not multiples of their 4 and 2 fields"},
+		{meta + `"nodes":[0,0,0,1],"edges":[]}`, "V8 heap snapshot node 0 has more edges than the snapshot"},
+		{meta + `"nodes":[0,0,0,1],"edges":[0,8]}`, "V8 heap snapshot edge 0 points to invalid node offset 8"},
+		{meta + `"nodes":[0,0,0,1,3,0,0,0],"edges":[0,4],"strings":[""]}`, "V8 heap snapshot node 1 has invalid type 3"},
+	} {
+		if _, err := parseV8HeapSnapshot([]byte(tc.data)); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: got error %v, want %s", tc.data, err, tc.want)
+		}
+	}
+	for _, data := range []string{"", "main;foo 1", `{"nodes":[{"id":1}]}`} {
+		if _, err := parseV8HeapSnapshot([]byte(data)); err != errUnrecognized {
+			t.Errorf("%q: got error %v, want errUnrecognized", data, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "fmt"
+
+// warningKind identifies a kind of profile contents that could only be
+// partially interpreted.
+type warningKind int
+
+const (
+	warnInvalidLabel warningKind = iota
+	warnUnnamedSampleType
+	warnUntypedValues
+	warnMissingValues
+	warnZeroLengthMapping
+	numWarningKinds
+)
+
+// warningFormats describes each kind of warning, given its number of
+// occurrences.
+var warningFormats = [numWarningKinds]string{
+	warnInvalidLabel:      "%d labels with invalid keys or values were dropped",
+	warnUnnamedSampleType: "%d sample types without a name were named unknown_<index>",
+	warnUntypedValues:     "%d samples had values without a sample type, typed as unknown_<index>",
+	warnMissingValues:     "%d samples were missing values, set to zero",
+	warnZeroLengthMapping: "%d mappings have an empty address range and will not be symbolized",
+}
+
+// warn records an occurrence of a warning of kind k.
+func (p *Profile) warn(k warningKind) {
+	p.warnings[k]++
+}
+
+// Warnings returns an itemized summary of the contents of p that could
+// not be fully interpreted when it was parsed, and that were dropped or
+// adjusted instead of failing the parse. It is empty for well-formed
+// profiles.
+func (p *Profile) Warnings() []string {
+	var ws []string
+	for k, n := range p.warnings {
+		if n > 0 {
+			ws = append(ws, fmt.Sprintf(warningFormats[k], n))
+		}
+	}
+	return ws
+}
+
+// repair adjusts the parts of a freshly parsed profile that would
+// otherwise make it invalid, recording a warning for each of them.
+func (p *Profile) repair() {
+	for i, st := range p.SampleType {
+		if st != nil && st.Type == "" {
+			st.Type = fmt.Sprintf("unknown_%d", i)
+			p.warn(warnUnnamedSampleType)
+		}
+	}
+
+	types := len(p.SampleType)
+	values := types
+	for _, s := range p.Sample {
+		if s == nil {
+			continue
+		}
+		switch n := len(s.Value); {
+		case n > types:
+			p.warn(warnUntypedValues)
+			if n > values {
+				values = n
+			}
+		case n < types:
+			p.warn(warnMissingValues)
+		}
+	}
+	for i := types; i < values; i++ {
+		p.SampleType = append(p.SampleType, &ValueType{Type: fmt.Sprintf("unknown_%d", i), Unit: "count"})
+	}
+	for _, s := range p.Sample {
+		if s != nil && len(s.Value) < values {
+			s.Value = append(s.Value, make([]int64, values-len(s.Value))...)
+		}
+	}
+
+	for _, m := range p.Mapping {
+		if m != nil && m.emptyRange() {
+			p.warn(warnZeroLengthMapping)
+		}
+	}
+}
+
+// emptyRange reports whether the address range of m is empty or inverted.
+// Mappings with no addresses at all, as produced for profiles without
+// memory map information, are not considered empty.
+func (m *Mapping) emptyRange() bool {
+	return m.Limit < m.Start || (m.Limit == m.Start && m.Start != 0)
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	m := []*Mapping{
+		{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/a"},
+		{ID: 2, Start: 0x3000, Limit: 0x3000, File: "/lib/b.so"},
+	}
+	l := []*Location{
+		{ID: 1, Mapping: m[0], Address: 0x1100},
+		{ID: 2, Mapping: m[1], Address: 0x3000},
+	}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}, {Unit: "bytes"}},
+		Sample: []*Sample{
+			{Location: l[:1], Value: []int64{1, 10}, Label: map[string][]string{"": {"dropped"}, "key": {"kept"}}},
+			{Location: l[1:], Value: []int64{2}},
+			{Location: l, Value: []int64{3, 30, 300}},
+		},
+		Mapping:  m,
+		Location: l,
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	want := []string{
+		"1 labels with invalid keys or values were dropped",
+		"1 sample types without a name were named unknown_<index>",
+		"1 samples had values without a sample type, typed as unknown_<index>",
+		"1 samples were missing values, set to zero",
+		"1 mappings have an empty address range and will not be symbolized",
+	}
+	if ws := got.Warnings(); !reflect.DeepEqual(ws, want) {
+		t.Errorf("got warnings %q, want %q", ws, want)
+	}
+
+	var types []string
+	for _, st := range got.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if want := []string{"samples/count", "unknown_1/bytes", "unknown_2/count"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got sample types %v, want %v", types, want)
+	}
+	var values [][]int64
+	for _, s := range got.Sample {
+		values = append(values, s.Value)
+	}
+	if want := [][]int64{{1, 10, 0}, {2, 0, 0}, {3, 30, 300}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got values %v, want %v", values, want)
+	}
+	if want := map[string][]string{"key": {"kept"}}; !reflect.DeepEqual(got.Sample[0].Label, want) {
+		t.Errorf("got labels %v, want %v", got.Sample[0].Label, want)
+	}
+	if got.Mapping[0].Unsymbolizable() || !got.Mapping[1].Unsymbolizable() {
+		t.Errorf("want only the empty mapping to be unsymbolizable")
+	}
+}
+
+func TestNoWarnings(t *testing.T) {
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*Sample{{Value: []int64{1}, Label: map[string][]string{"key": {"value"}}}},
+		Mapping:    []*Mapping{{ID: 1}},
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if ws := got.Warnings(); len(ws) != 0 {
+		t.Errorf("got warnings %q, want none", ws)
+	}
+}
@@ -0,0 +1,900 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements a decoder of the Zstandard compression format, as
+// described by RFC 8878, for zstd-compressed profiles. Frames with
+// dictionaries are not supported. pprof writes zstd-compressed profiles
+// with the zstd tool.
+
+package profile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	zstdMagic          = 0xfd2fb528
+	zstdSkippableMagic = 0x184d2a50 // With the low 4 bits ignored.
+	zstdMaxBlockSize   = 128 << 10
+)
+
+var errZstdCorrupt = errors.New("corrupt zstd data")
+
+// zstdMaxSize is the largest size of the data decompressed, far above
+// that of profiles, so that small corrupt or malicious frames cannot
+// decompress to exhaust the memory.
+var zstdMaxSize = 1 << 30
+
+// isZstd reports whether data starts with a zstd frame.
+func isZstd(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == zstdMagic
+}
+
+// Symbols of the sequences: their baselines and numbers of extra bits.
+var (
+	zstdLLBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMLBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+
+	// Predefined distributions of the symbols of the sequences.
+	zstdLLDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMLDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOFDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	zstdLLDefaultLog = 6
+	zstdMLDefaultLog = 6
+	zstdOFDefaultLog = 5
+
+	zstdLLMaxLog = 9
+	zstdMLMaxLog = 9
+	zstdOFMaxLog = 8
+
+	zstdMaxOffsetCode = 31
+	zstdHufMaxBits    = 11
+)
+
+// xxhash64 returns the XXH64 hash of b, with a seed of 0, as used by the
+// checksums of zstd frames.
+func xxhash64(b []byte) uint64 {
+	var (
+		p1 uint64 = 11400714785074694791
+		p2 uint64 = 14029467366897019727
+		p3 uint64 = 1609587929392839161
+		p4 uint64 = 9650029242287828579
+		p5 uint64 = 2870177450012600261
+	)
+	round := func(acc, in uint64) uint64 {
+		return bits.RotateLeft64(acc+in*p2, 31) * p1
+	}
+	n := uint64(len(b))
+	var h uint64
+	if len(b) >= 32 {
+		v1, v2, v3, v4 := p1+p2, p2, uint64(0), -p1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = round(v1, binary.LittleEndian.Uint64(b))
+			v2 = round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range []uint64{v1, v2, v3, v4} {
+			h = (h^round(0, v))*p1 + p4
+		}
+	} else {
+		h = p5
+	}
+	h += n
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*p1 + p4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * p1
+		h = bits.RotateLeft64(h, 23)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * p5
+		h = bits.RotateLeft64(h, 11) * p1
+	}
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}
+
+// zstdForwardReader reads the bits of b from the lowest bit of its first
+// byte, as in FSE table descriptions.
+type zstdForwardReader struct {
+	b   []byte
+	pos int // In bits.
+}
+
+func (r *zstdForwardReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		byteIndex := (r.pos + i) >> 3
+		if byteIndex < len(r.b) {
+			v |= uint32(r.b[byteIndex]>>uint((r.pos+i)&7)&1) << uint(i)
+		}
+	}
+	return v
+}
+
+// zstdBackwardReader reads the bits of a bitstream written forward from
+// its end, after the final 1 bit marking its end, as in the entropy coded
+// streams. Reading past its start gives zeros and sets overflow.
+type zstdBackwardReader struct {
+	b   []byte
+	pos int // Number of bits left.
+}
+
+func newZstdBackwardReader(b []byte) (*zstdBackwardReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	last := b[len(b)-1]
+	return &zstdBackwardReader{b: b, pos: 8*len(b) - bits.LeadingZeros8(last) - 1}, nil
+}
+
+func (r *zstdBackwardReader) peek(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		bit := r.pos - n + i
+		if bit >= 0 {
+			v |= uint32(r.b[bit>>3]>>uint(bit&7)&1) << uint(i)
+		}
+	}
+	return v
+}
+
+func (r *zstdBackwardReader) bits(n int) uint32 {
+	v := r.peek(n)
+	r.pos -= n
+	return v
+}
+
+func (r *zstdBackwardReader) overflow() bool {
+	return r.pos < 0
+}
+
+// zstdFSEEntry is an entry of an FSE decoding table.
+type zstdFSEEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+// zstdFSETable is an FSE decoding table.
+type zstdFSETable struct {
+	log     int
+	entries []zstdFSEEntry
+}
+
+// zstdSpread returns the symbols of the states of an FSE table with the
+// given normalized counts, where -1 stands for a probability lower than 1,
+// as done by the encoder and the decoder.
+func zstdSpread(norm []int16, log int) []uint8 {
+	size := 1 << uint(log)
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	return symbols
+}
+
+func newZstdFSETable(norm []int16, log int) *zstdFSETable {
+	size := 1 << uint(log)
+	symbols := zstdSpread(norm, log)
+	next := make([]int, len(norm))
+	for s, c := range norm {
+		if c == -1 {
+			next[s] = 1
+		} else {
+			next[s] = int(c)
+		}
+	}
+	t := &zstdFSETable{log: log, entries: make([]zstdFSEEntry, size)}
+	for u, s := range symbols {
+		n := next[s]
+		next[s]++
+		nb := log - (bits.Len(uint(n)) - 1)
+		t.entries[u] = zstdFSEEntry{symbol: s, nbBits: uint8(nb), newState: uint16(n<<uint(nb) - size)}
+	}
+	return t
+}
+
+// zstdRLETable returns an FSE table always decoding symbol.
+func zstdRLETable(symbol uint8) *zstdFSETable {
+	return &zstdFSETable{entries: []zstdFSEEntry{{symbol: symbol}}}
+}
+
+// readZstdFSETable reads an FSE table description with symbols up to
+// maxSymbol and an accuracy log up to maxLog from b, and returns the table
+// and the number of bytes read.
+func readZstdFSETable(b []byte, maxSymbol, maxLog int) (*zstdFSETable, int, error) {
+	norm, log, n, err := readZstdNormCounts(b, maxSymbol, maxLog)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newZstdFSETable(norm, log), n, nil
+}
+
+func readZstdNormCounts(b []byte, maxSymbol, maxLog int) ([]int16, int, int, error) {
+	if len(b) == 0 {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	r := &zstdForwardReader{b: b}
+	log := int(r.bits(4)) + 5
+	r.pos += 4
+	if log > maxLog {
+		return nil, 0, 0, fmt.Errorf("zstd FSE accuracy log %d larger than %d", log, maxLog)
+	}
+	remaining := 1<<uint(log) + 1
+	threshold := 1 << uint(log)
+	nbBits := log + 1
+	var norm []int16
+	previous0 := false
+	for remaining > 1 && len(norm) <= maxSymbol {
+		if previous0 {
+			for {
+				repeat := int(r.bits(2))
+				r.pos += 2
+				for i := 0; i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSymbol {
+				return nil, 0, 0, errZstdCorrupt
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		v := int(r.bits(nbBits))
+		if v&(threshold-1) < max {
+			v &= threshold - 1
+			r.pos += nbBits - 1
+		} else {
+			if v >= threshold {
+				v -= max
+			}
+			r.pos += nbBits
+		}
+		count := v - 1
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || r.pos > 8*len(b) {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	return norm, log, (r.pos + 7) / 8, nil
+}
+
+// zstdFSEState is the state of an FSE decoder.
+type zstdFSEState struct {
+	t     *zstdFSETable
+	state int
+}
+
+func (s *zstdFSEState) init(t *zstdFSETable, r *zstdBackwardReader) {
+	s.t = t
+	s.state = int(r.bits(t.log))
+}
+
+func (s *zstdFSEState) symbol() uint8 {
+	return s.t.entries[s.state].symbol
+}
+
+func (s *zstdFSEState) update(r *zstdBackwardReader) {
+	e := s.t.entries[s.state]
+	s.state = int(e.newState) + int(r.bits(int(e.nbBits)))
+}
+
+// zstdHuffman is a Huffman decoding table, indexed by the next maxBits
+// bits of a stream.
+type zstdHuffman struct {
+	maxBits int
+	symbols []uint8
+	nbBits  []uint8
+}
+
+// readZstdHuffman reads a Huffman tree description from b, and returns the
+// table and the number of bytes read.
+func readZstdHuffman(b []byte) (*zstdHuffman, int, error) {
+	if len(b) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	var weights []uint8
+	header := int(b[0])
+	n := 1
+	if header >= 128 {
+		count := header - 127
+		n += (count + 1) / 2
+		if n > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < count; i++ {
+			w := b[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&0xf)
+		}
+	} else {
+		n += header
+		if n > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		var err error
+		if weights, err = decodeZstdWeights(b[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+	h, err := newZstdHuffman(weights)
+	return h, n, err
+}
+
+// decodeZstdWeights decodes the weights of a Huffman tree description,
+// compressed with two interleaved FSE states.
+func decodeZstdWeights(b []byte) ([]uint8, error) {
+	t, n, err := readZstdFSETable(b, 255, 6)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newZstdBackwardReader(b[n:])
+	if err != nil {
+		return nil, err
+	}
+	var s1, s2 zstdFSEState
+	s1.init(t, r)
+	s2.init(t, r)
+	var weights []uint8
+	for len(weights) < 255 {
+		weights = append(weights, s1.symbol())
+		s1.update(r)
+		if r.overflow() {
+			weights = append(weights, s2.symbol())
+			break
+		}
+		weights = append(weights, s2.symbol())
+		s2.update(r)
+		if r.overflow() {
+			weights = append(weights, s1.symbol())
+			break
+		}
+	}
+	return weights, nil
+}
+
+// newZstdHuffman returns the Huffman table of the weights of the symbols,
+// but for the last one, whose weight is implied.
+func newZstdHuffman(weights []uint8) (*zstdHuffman, error) {
+	var total int
+	for _, w := range weights {
+		if w > zstdHufMaxBits {
+			return nil, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || len(weights) > 255 {
+		return nil, errZstdCorrupt
+	}
+	maxBits := bits.Len(uint(total))
+	rest := 1<<uint(maxBits) - total
+	if maxBits > zstdHufMaxBits || rest&(rest-1) != 0 {
+		return nil, errZstdCorrupt
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+
+	var rankCount [zstdHufMaxBits + 2]int
+	nbBits := make([]uint8, len(weights))
+	for s, w := range weights {
+		if w > 0 {
+			nbBits[s] = uint8(maxBits + 1 - int(w))
+			rankCount[nbBits[s]]++
+		}
+	}
+	// The longest codes come first.
+	var rankIndex [zstdHufMaxBits + 2]int
+	for n := maxBits; n >= 1; n-- {
+		rankIndex[n-1] = rankIndex[n] + rankCount[n]<<uint(maxBits-n)
+	}
+	h := &zstdHuffman{
+		maxBits: maxBits,
+		symbols: make([]uint8, 1<<uint(maxBits)),
+		nbBits:  make([]uint8, 1<<uint(maxBits)),
+	}
+	for s, n := range nbBits {
+		if n == 0 {
+			continue
+		}
+		code := rankIndex[n]
+		size := 1 << uint(maxBits-int(n))
+		for i := code; i < code+size; i++ {
+			h.symbols[i], h.nbBits[i] = uint8(s), n
+		}
+		rankIndex[n] += size
+	}
+	return h, nil
+}
+
+// decodeStream decodes n literals from a Huffman coded stream.
+func (h *zstdHuffman) decodeStream(dst, b []byte, n int) ([]byte, error) {
+	r, err := newZstdBackwardReader(b)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		v := r.peek(h.maxBits)
+		dst = append(dst, h.symbols[v])
+		r.pos -= int(h.nbBits[v])
+	}
+	if r.pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	return dst, nil
+}
+
+// zstdDecoder holds the state of the decoder of a frame kept across its
+// blocks.
+type zstdDecoder struct {
+	out     []byte
+	start   int // Start of the frame in out.
+	window  int
+	rep     [3]int
+	huffman *zstdHuffman
+	tables  [3]*zstdFSETable // Literal lengths, offsets, match lengths.
+}
+
+// zstdDecompress decompresses the zstd frames of data, which may be
+// separated by skippable frames, up to zstdMaxSize bytes.
+func zstdDecompress(data []byte) ([]byte, error) {
+	d := &zstdDecoder{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errZstdCorrupt
+		}
+		magic := binary.LittleEndian.Uint32(data)
+		if magic&^0xf == zstdSkippableMagic {
+			if len(data) < 8 {
+				return nil, errZstdCorrupt
+			}
+			size := int64(binary.LittleEndian.Uint32(data[4:]))
+			if size > int64(len(data)-8) {
+				return nil, errZstdCorrupt
+			}
+			data = data[8+size:]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, errors.New("not a zstd frame")
+		}
+		n, err := d.frame(data[4:])
+		if err != nil {
+			return nil, err
+		}
+		data = data[4+n:]
+	}
+	return d.out, nil
+}
+
+// frame decodes a frame after its magic number and returns its size.
+func (d *zstdDecoder) frame(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, errZstdCorrupt
+	}
+	fhd := b[0]
+	pos := 1
+	singleSegment := fhd&0x20 != 0
+	checksum := fhd&0x04 != 0
+	if fhd&0x08 != 0 {
+		return 0, errZstdCorrupt
+	}
+	if !singleSegment {
+		if len(b) < 2 {
+			return 0, errZstdCorrupt
+		}
+		exp, mantissa := int(b[1]>>3), int(b[1]&7)
+		base := 1 << uint(10+exp)
+		d.window = base + base/8*mantissa
+		pos++
+	}
+	if dictIDSize := []int{0, 1, 2, 4}[fhd&3]; dictIDSize > 0 {
+		if len(b) < pos+dictIDSize {
+			return 0, errZstdCorrupt
+		}
+		for _, c := range b[pos : pos+dictIDSize] {
+			if c != 0 {
+				return 0, errors.New("zstd dictionaries are not supported")
+			}
+		}
+		pos += dictIDSize
+	}
+	fcsSize := []int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && singleSegment {
+		fcsSize = 1
+	}
+	pos += fcsSize
+	if len(b) < pos {
+		return 0, errZstdCorrupt
+	}
+
+	d.start = len(d.out)
+	d.rep = [3]int{1, 4, 8}
+	d.huffman = nil
+	d.tables = [3]*zstdFSETable{}
+	for last := false; !last; {
+		if len(b) < pos+3 {
+			return 0, errZstdCorrupt
+		}
+		header := int(b[pos]) | int(b[pos+1])<<8 | int(b[pos+2])<<16
+		pos += 3
+		last = header&1 != 0
+		size := header >> 3
+		switch (header >> 1) & 3 {
+		case 0: // Raw.
+			if len(b) < pos+size {
+				return 0, errZstdCorrupt
+			}
+			d.out = append(d.out, b[pos:pos+size]...)
+			pos += size
+		case 1: // RLE.
+			if len(b) < pos+1 || size > zstdMaxBlockSize {
+				return 0, errZstdCorrupt
+			}
+			for i := 0; i < size; i++ {
+				d.out = append(d.out, b[pos])
+			}
+			pos++
+		case 2:
+			if len(b) < pos+size || size > zstdMaxBlockSize {
+				return 0, errZstdCorrupt
+			}
+			if err := d.block(b[pos : pos+size]); err != nil {
+				return 0, err
+			}
+			pos += size
+		default:
+			return 0, errZstdCorrupt
+		}
+		if len(d.out) > zstdMaxSize {
+			return 0, fmt.Errorf("zstd data larger than %d bytes", zstdMaxSize)
+		}
+	}
+	if checksum {
+		if len(b) < pos+4 {
+			return 0, errZstdCorrupt
+		}
+		if uint32(xxhash64(d.out[d.start:])) != binary.LittleEndian.Uint32(b[pos:]) {
+			return 0, errors.New("zstd checksum mismatch")
+		}
+		pos += 4
+	}
+	return pos, nil
+}
+
+// block decodes a compressed block.
+func (d *zstdDecoder) block(b []byte) error {
+	literals, n, err := d.literals(b)
+	if err != nil {
+		return err
+	}
+	b = b[n:]
+
+	// The sequences section.
+	if len(b) < 1 {
+		return errZstdCorrupt
+	}
+	nbSeq := int(b[0])
+	switch {
+	case nbSeq == 0:
+		d.out = append(d.out, literals...)
+		return nil
+	case nbSeq < 128:
+		b = b[1:]
+	case nbSeq < 255:
+		if len(b) < 2 {
+			return errZstdCorrupt
+		}
+		nbSeq = (nbSeq-128)<<8 + int(b[1])
+		b = b[2:]
+	default:
+		if len(b) < 3 {
+			return errZstdCorrupt
+		}
+		nbSeq = int(b[1]) + int(b[2])<<8 + 0x7f00
+		b = b[3:]
+	}
+	if len(b) < 1 {
+		return errZstdCorrupt
+	}
+	modes := b[0]
+	b = b[1:]
+	for i, c := range []struct {
+		maxSymbol, maxLog, defaultLog int
+		defaults                      []int16
+	}{
+		{35, zstdLLMaxLog, zstdLLDefaultLog, zstdLLDefault},
+		{zstdMaxOffsetCode, zstdOFMaxLog, zstdOFDefaultLog, zstdOFDefault},
+		{52, zstdMLMaxLog, zstdMLDefaultLog, zstdMLDefault},
+	} {
+		switch (modes >> uint(6-2*i)) & 3 {
+		case 0:
+			d.tables[i] = newZstdFSETable(c.defaults, c.defaultLog)
+		case 1:
+			if len(b) < 1 || int(b[0]) > c.maxSymbol {
+				return errZstdCorrupt
+			}
+			d.tables[i] = zstdRLETable(b[0])
+			b = b[1:]
+		case 2:
+			t, n, err := readZstdFSETable(b, c.maxSymbol, c.maxLog)
+			if err != nil {
+				return err
+			}
+			d.tables[i] = t
+			b = b[n:]
+		case 3:
+			if d.tables[i] == nil {
+				return errZstdCorrupt
+			}
+		}
+	}
+
+	r, err := newZstdBackwardReader(b)
+	if err != nil {
+		return err
+	}
+	var ll, of, ml zstdFSEState
+	ll.init(d.tables[0], r)
+	of.init(d.tables[1], r)
+	ml.init(d.tables[2], r)
+	start := len(d.out)
+	for i := 0; i < nbSeq; i++ {
+		ofCode, mlCode, llCode := of.symbol(), ml.symbol(), ll.symbol()
+		if int(ofCode) > zstdMaxOffsetCode || int(mlCode) >= len(zstdMLBase) || int(llCode) >= len(zstdLLBase) {
+			return errZstdCorrupt
+		}
+		offsetValue := 1<<ofCode + int(r.bits(int(ofCode)))
+		matchLen := int(zstdMLBase[mlCode]) + int(r.bits(int(zstdMLBits[mlCode])))
+		litLen := int(zstdLLBase[llCode]) + int(r.bits(int(zstdLLBits[llCode])))
+		if i < nbSeq-1 {
+			ll.update(r)
+			ml.update(r)
+			of.update(r)
+		}
+
+		offset := zstdUpdateRep(&d.rep, offsetValue, litLen)
+		if litLen > len(literals) {
+			return errZstdCorrupt
+		}
+		d.out = append(d.out, literals[:litLen]...)
+		literals = literals[litLen:]
+		if offset <= 0 || offset > len(d.out)-d.start {
+			return fmt.Errorf("zstd offset %d out of range", offset)
+		}
+		if len(d.out)-start+matchLen > zstdMaxBlockSize {
+			return errZstdCorrupt
+		}
+		from := len(d.out) - offset
+		for j := 0; j < matchLen; j++ {
+			d.out = append(d.out, d.out[from+j])
+		}
+	}
+	if r.pos != 0 {
+		return errZstdCorrupt
+	}
+	d.out = append(d.out, literals...)
+	return nil
+}
+
+// zstdUpdateRep returns the offset of a match coded by offsetValue after
+// litLen literals, and updates the repeated offsets rep.
+func zstdUpdateRep(rep *[3]int, offsetValue, litLen int) int {
+	if offsetValue > 3 {
+		offset := offsetValue - 3
+		*rep = [3]int{offset, rep[0], rep[1]}
+		return offset
+	}
+	idx := offsetValue - 1
+	if litLen == 0 {
+		idx++
+	}
+	var offset int
+	switch idx {
+	case 0:
+		return rep[0]
+	case 3:
+		offset = rep[0] - 1
+	default:
+		offset = rep[idx]
+	}
+	if idx > 1 {
+		rep[2] = rep[1]
+	}
+	rep[1] = rep[0]
+	rep[0] = offset
+	return offset
+}
+
+// literals decodes the literals section of a block, and returns the
+// literals and its size.
+func (d *zstdDecoder) literals(b []byte) ([]byte, int, error) {
+	if len(b) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	blockType := b[0] & 3
+	sizeFormat := (b[0] >> 2) & 3
+	if blockType <= 1 {
+		// Raw and RLE literals.
+		var size, n int
+		switch sizeFormat {
+		case 0, 2:
+			size, n = int(b[0]>>3), 1
+		case 1:
+			if len(b) < 2 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, n = int(b[0]>>4)+int(b[1])<<4, 2
+		case 3:
+			if len(b) < 3 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, n = int(b[0]>>4)+int(b[1])<<4+int(b[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return nil, 0, errZstdCorrupt
+		}
+		if blockType == 0 {
+			if len(b) < n+size {
+				return nil, 0, errZstdCorrupt
+			}
+			return b[n : n+size], n + size, nil
+		}
+		if len(b) < n+1 {
+			return nil, 0, errZstdCorrupt
+		}
+		lits := make([]byte, size)
+		for i := range lits {
+			lits[i] = b[n]
+		}
+		return lits, n + 1, nil
+	}
+
+	// Huffman coded literals.
+	n, sizeBits := []int{3, 3, 4, 5}[sizeFormat], []int{10, 10, 14, 18}[sizeFormat]
+	if len(b) < n {
+		return nil, 0, errZstdCorrupt
+	}
+	var header uint64
+	for i := n - 1; i >= 0; i-- {
+		header = header<<8 | uint64(b[i])
+	}
+	header >>= 4
+	regenerated := int(header & (1<<uint(sizeBits) - 1))
+	compressed := int(header >> uint(sizeBits) & (1<<uint(sizeBits) - 1))
+	if regenerated > zstdMaxBlockSize || len(b) < n+compressed {
+		return nil, 0, errZstdCorrupt
+	}
+	data := b[n : n+compressed]
+	if blockType == 2 {
+		h, hn, err := readZstdHuffman(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = h
+		data = data[hn:]
+	} else if d.huffman == nil {
+		return nil, 0, errZstdCorrupt
+	}
+
+	lits := make([]byte, 0, regenerated)
+	var err error
+	if sizeFormat == 0 {
+		lits, err = d.huffman.decodeStream(lits, data, regenerated)
+	} else {
+		if len(data) < 6 {
+			return nil, 0, errZstdCorrupt
+		}
+		sizes := []int{int(binary.LittleEndian.Uint16(data)), int(binary.LittleEndian.Uint16(data[2:])), int(binary.LittleEndian.Uint16(data[4:]))}
+		data = data[6:]
+		sizes = append(sizes, len(data)-sizes[0]-sizes[1]-sizes[2])
+		if sizes[3] < 0 {
+			return nil, 0, errZstdCorrupt
+		}
+		streamSize := (regenerated + 3) / 4
+		for i, size := range sizes {
+			count := streamSize
+			if i == 3 {
+				count = regenerated - 3*streamSize
+			}
+			if count < 0 {
+				return nil, 0, errZstdCorrupt
+			}
+			if lits, err = d.huffman.decodeStream(lits, data[:size], count); err != nil {
+				break
+			}
+			data = data[size:]
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return lits, n + compressed, nil
+}
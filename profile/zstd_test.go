@@ -0,0 +1,149 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestZstdTool(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rnd.Read(random)
+	var text strings.Builder
+	for text.Len() < 1<<20 {
+		fmt.Fprintf(&text, "func%d samples=%d file%d.go:%d\n", rnd.Intn(500), rnd.Intn(1000), rnd.Intn(50), rnd.Intn(2000))
+	}
+	// Literals of few distinct bytes, without matches.
+	skewed := make([]byte, 200<<10)
+	for i := range skewed {
+		skewed[i] = byte(rnd.ExpFloat64() * 2)
+	}
+	// Longer than the default window, with matches beyond it.
+	long := bytes.Repeat([]byte(text.String()[:3<<20/7]), 25)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{42}},
+		{"short", []byte("abcabcabcabcabcabcabc")},
+		{"zeros", make([]byte, 500<<10)},
+		{"random", random},
+		{"text", []byte(text.String())},
+		{"skewed", skewed},
+		{"long", long},
+	} {
+		for _, level := range [][]string{{"-1"}, {"-3"}, {"-19"}, {"-22", "--ultra"}, {"-3", "--long"}} {
+			cmd := exec.Command("zstd", append([]string{"-q", "-c"}, level...)...)
+			cmd.Stdin = bytes.NewReader(tc.data)
+			c, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%s: zstd %v: %v", tc.name, level, err)
+			}
+			if !isZstd(c) {
+				t.Fatalf("%s: zstd %v: got %x..., want zstd magic number", tc.name, level, c[:4])
+			}
+			got, err := zstdDecompress(c)
+			if err != nil {
+				t.Errorf("%s: zstdDecompress of zstd %v: %v", tc.name, level, err)
+				continue
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Errorf("%s: zstd %v: got %d bytes back, want the %d bytes compressed", tc.name, level, len(got), len(tc.data))
+			}
+		}
+	}
+}
+
+func TestZstdDecompress(t *testing.T) {
+	// The zstd frame was written by the zstd tool at level 19, with
+	// compressed Huffman weights and FSE tables.
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "cppbench.cpu.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := ioutil.ReadFile(filepath.Join("testdata", "cppbench.cpu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParseData(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.String(), want.String(); got != want {
+		t.Errorf("got profile:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Frames may be concatenated, with skippable frames in between.
+	skippable := []byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 1, 2, 3}
+	more := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x58, 0x21, 0x00, 0x00, 'm', 'o', 'r', 'e', 0xf4, 0xc8, 0xfb, 0x27}
+	two := append(append(append([]byte(nil), data...), skippable...), more...)
+	b, err := zstdDecompress(two)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte("more")) {
+		t.Errorf("got %q at the end of concatenated frames, want more", b[len(b)-10:])
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := zstdDecompress(corrupt); err == nil {
+		t.Error("want error decompressing data with a wrong checksum")
+	}
+	for n := 1; n < len(data)-4; n += 7 {
+		if _, err := zstdDecompress(data[:n]); err == nil {
+			t.Errorf("want error decompressing the first %d bytes", n)
+		}
+	}
+}
+
+func TestZstdMaxSize(t *testing.T) {
+	defer func(n int) { zstdMaxSize = n }(zstdMaxSize)
+	zstdMaxSize = 1 << 20
+
+	// A frame of 10 RLE blocks of 128KB each, without checksum.
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00}
+	for i := 0; i < 10; i++ {
+		header := zstdMaxBlockSize<<3 | 1<<1
+		if i == 9 {
+			header |= 1 // Last block.
+		}
+		frame = append(frame, byte(header), byte(header>>8), byte(header>>16), 'x')
+	}
+	if _, err := zstdDecompress(frame); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("got error %v decompressing %d bytes from a frame of %d, want one for data too large", err, 10*zstdMaxBlockSize, len(frame))
+	}
+	zstdMaxSize = 10 * zstdMaxBlockSize
+	if b, err := zstdDecompress(frame); err != nil || len(b) != zstdMaxSize {
+		t.Errorf("got %d bytes, error %v at the size limit, want %d bytes", len(b), err, zstdMaxSize)
+	}
+}